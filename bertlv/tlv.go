@@ -0,0 +1,215 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bertlv
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidTag is returned for tags that are longer than four bytes, and by Marshal for tag 0.
+	ErrInvalidTag = errors.New("invalid tag")
+	// ErrInvalidLength is returned for indefinite lengths, lengths of more than four bytes and
+	// lengths past the end of the data.
+	ErrInvalidLength = errors.New("invalid length")
+)
+
+const (
+	// maxTagLen and maxLengthLen are the longest tags and length fields Unmarshal accepts.
+	maxTagLen    = 4
+	maxLengthLen = 4
+)
+
+// Tag is a BER-TLV tag with its bytes as a big endian number, 7F49 for the public key template.
+// The keys of TLVData compute long tags differently, 7F49 is 3FC9 there.
+type Tag uint32
+
+// Constructed is true for tags whose value is a sequence of objects, ISO/IEC 7816-4 5.2.2.1.
+func (t Tag) Constructed() bool {
+	return t.Bytes()[0]&classMaskValue == classMaskValue
+}
+
+// String returns the tag in hex, like 7F49.
+func (t Tag) String() string {
+	return fmt.Sprintf("%X", t.Bytes())
+}
+
+// Bytes returns the encoded tag, 7F49 is 7F 49.
+func (t Tag) Bytes() []byte {
+	b := []byte{byte(t >> 24), byte(t >> 16), byte(t >> 8), byte(t)}
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+
+	return b
+}
+
+// TLV is a BER-TLV data object. The objects in the value of a constructed tag are in Children,
+// constructed tags whose value isn't a sequence of objects have no Children.
+type TLV struct {
+	Tag Tag
+	// Value is the value of primitive objects, Unmarshal sets it to the encoded children for constructed ones.
+	Value []byte
+	// Children are the objects of a constructed tag, Marshal encodes them instead of Value when there are any.
+	Children []TLV
+}
+
+// Find returns the first object of tlvs at the path of tags, which goes through constructed objects.
+func Find(tlvs []TLV, tags ...Tag) (TLV, bool) {
+	for i, tag := range tags {
+		found := false
+
+		for _, obj := range tlvs {
+			if obj.Tag != tag {
+				continue
+			}
+
+			if i == len(tags)-1 {
+				return obj, true
+			}
+
+			tlvs, found = obj.Children, true
+
+			break
+		}
+
+		if !found {
+			break
+		}
+	}
+
+	return TLV{}, false
+}
+
+// Unmarshal parses a sequence of objects with tags of up to four bytes and lengths of up to four bytes,
+// the values of constructed tags are parsed into their Children when they're a sequence of objects.
+// 00 and FF bytes between objects are padding and skipped, ISO/IEC 7816-4 5.2.2,
+// so every object has a tag Marshal can write back. The values aren't copied from data.
+func Unmarshal(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+
+	for len(data) > 0 {
+		if data[0] == 0x00 || data[0] == 0xff {
+			data = data[1:]
+
+			continue
+		}
+
+		obj, rest, err := unmarshalOne(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.Tag.Constructed() {
+			// cards don't always set the bit only for sequences, the AA PIN policy of a YubiKey is one byte.
+			obj.Children, _ = Unmarshal(obj.Value)
+		}
+
+		tlvs = append(tlvs, obj)
+		data = rest
+	}
+
+	return tlvs, nil
+}
+
+func unmarshalOne(data []byte) (TLV, []byte, error) {
+	tagLen := 1
+	if data[0]&longTagMaskValue == longTagMaskValue {
+		// subsequent tag bytes have the high bit set, except for the last one.
+		for tagLen < len(data) && data[tagLen]&highBitMask != 0 {
+			tagLen++
+		}
+
+		tagLen++
+	}
+
+	if tagLen > maxTagLen {
+		return TLV{}, nil, fmt.Errorf("%w: %d bytes", ErrInvalidTag, tagLen)
+	}
+
+	if tagLen >= len(data) {
+		return TLV{}, nil, ErrNoBytesLeft
+	}
+
+	var tag Tag
+	for _, b := range data[:tagLen] {
+		tag = tag<<8 | Tag(b)
+	}
+
+	data = data[tagLen:]
+
+	n := int(data[0])
+	data = data[1:]
+
+	if n&highBitMask != 0 {
+		size := n & sevenBitMask
+		if size == 0 || size > maxLengthLen || size > len(data) {
+			return TLV{}, nil, fmt.Errorf("%w: %d length bytes", ErrInvalidLength, size)
+		}
+
+		n = 0
+		for _, b := range data[:size] {
+			n = n<<8 | int(b)
+		}
+
+		data = data[size:]
+	}
+
+	if n < 0 || n > len(data) {
+		return TLV{}, nil, fmt.Errorf("%w: %s is %d bytes, %d left", ErrInvalidLength, tag, n, len(data))
+	}
+
+	return TLV{Tag: tag, Value: data[:n]}, data[n:], nil
+}
+
+// Marshal encodes objects with the shortest lengths, constructed objects with Children are encoded from them.
+func Marshal(tlvs ...TLV) ([]byte, error) {
+	var b []byte
+
+	for _, obj := range tlvs {
+		if obj.Tag == 0 {
+			return nil, fmt.Errorf("%w: 0", ErrInvalidTag)
+		}
+
+		value := obj.Value
+
+		if len(obj.Children) > 0 {
+			var err error
+			if value, err = Marshal(obj.Children...); err != nil {
+				return nil, fmt.Errorf("%s: %w", obj.Tag, err)
+			}
+		}
+
+		b = append(b, obj.Tag.Bytes()...)
+		b = append(b, marshalLength(len(value))...)
+		b = append(b, value...)
+	}
+
+	return b, nil
+}
+
+func marshalLength(n int) []byte {
+	if n < longTagInitialLen {
+		return []byte{byte(n)}
+	}
+
+	var l []byte
+	for ; n > 0; n >>= 8 {
+		l = append([]byte{byte(n)}, l...)
+	}
+
+	return append([]byte{highBitMask | byte(len(l))}, l...)
+}
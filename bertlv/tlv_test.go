@@ -0,0 +1,240 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bertlv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(string(bytes.ReplaceAll([]byte(s), []byte(" "), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	long := bytes.Repeat([]byte{0xab}, 300)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		expected  []TLV
+		expectErr error
+	}{
+		{
+			name: "public key template",
+			data: mustHex(t, "7f49 05 86 03 040102"),
+			expected: []TLV{{
+				Tag:      0x7f49,
+				Value:    mustHex(t, "86 03 040102"),
+				Children: []TLV{{Tag: 0x86, Value: mustHex(t, "040102")}},
+			}},
+		},
+		{
+			name: "cardholder data",
+			data: mustHex(t, "65 09 5b00 5f2d00 5f3501 39"),
+			expected: []TLV{{
+				Tag:   0x65,
+				Value: mustHex(t, "5b00 5f2d00 5f3501 39"),
+				Children: []TLV{
+					{Tag: 0x5b, Value: []byte{}},
+					{Tag: 0x5f2d, Value: []byte{}},
+					{Tag: 0x5f35, Value: []byte{0x39}},
+				},
+			}},
+		},
+		{
+			name:     "two byte length",
+			data:     append(mustHex(t, "53 82 012c"), long...),
+			expected: []TLV{{Tag: 0x53, Value: long}},
+		},
+		{
+			name:     "three byte tag",
+			data:     mustHex(t, "5f ff 01 01 aa"),
+			expected: []TLV{{Tag: 0x5fff01, Value: []byte{0xaa}}},
+		},
+		{name: "five byte tag", data: mustHex(t, "5f ff ff ff 01 00"), expectErr: ErrInvalidTag},
+		{name: "indefinite length", data: mustHex(t, "30 80 00 00"), expectErr: ErrInvalidLength},
+		{name: "length past the end", data: mustHex(t, "53 05 0102"), expectErr: ErrInvalidLength},
+		{name: "length bytes past the end", data: mustHex(t, "53 82 01"), expectErr: ErrInvalidLength},
+		{name: "no length", data: mustHex(t, "5f2d"), expectErr: ErrNoBytesLeft},
+		{
+			name:     "constructed tag without objects",
+			data:     mustHex(t, "ac 03 aa 01 02"),
+			expected: []TLV{{Tag: 0xac, Value: mustHex(t, "aa 01 02"), Children: []TLV{{Tag: 0xaa, Value: []byte{0x02}}}}},
+		},
+		{
+			name:     "constructed tag with a bad object",
+			data:     mustHex(t, "65 02 5b05"),
+			expected: []TLV{{Tag: 0x65, Value: mustHex(t, "5b05")}},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tlvs, err := Unmarshal(tc.data)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("got error %v expected %v", err, tc.expectErr)
+			}
+
+			if !reflect.DeepEqual(tlvs, tc.expected) {
+				t.Errorf("got %+v expected %+v", tlvs, tc.expected)
+			}
+
+			if tc.expectErr != nil {
+				return
+			}
+
+			b, err := Marshal(tlvs...)
+			if err != nil || !bytes.Equal(b, tc.data) {
+				t.Errorf("marshal: got %X, %v expected %X", b, err, tc.data)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	b, err := Marshal(TLV{Tag: 0x7f49, Children: []TLV{{Tag: 0x81, Value: bytes.Repeat([]byte{1}, 0x80)}, {Tag: 0x82, Value: []byte{1, 0, 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(mustHex(t, "7f49 81 88 81 81 80"), bytes.Repeat([]byte{1}, 0x80)...)
+	expected = append(expected, mustHex(t, "82 03 010001")...)
+
+	if !bytes.Equal(b, expected) {
+		t.Errorf("got %X expected %X", b, expected)
+	}
+
+	if _, err := Marshal(TLV{Tag: 0x65, Children: []TLV{{}}}); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("tag 0: got %v expected %v", err, ErrInvalidTag)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// padding between objects is dropped, everything else is written back as it was read.
+	for data, expected := range map[string]string{
+		"7f49 05 86 03 040102":  "7f49 05 86 03 040102",
+		"00 5f2d 02 656e ff ff": "5f2d 02 656e",
+		"65 05 00 5b 01 41 00 ": "65 03 5b 01 41",
+		"0000":                  "",
+	} {
+		tlvs, err := Unmarshal(mustHex(t, data))
+		if err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+
+		b, err := Marshal(tlvs...)
+		if err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+
+		if want := mustHex(t, expected); !bytes.Equal(b, want) {
+			t.Errorf("%s: got %X expected %X", data, b, want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	tlvs, err := Unmarshal(mustHex(t, "6e 0c 4f 01 d2 73 07 c0 01 7d c5 02 abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if obj, ok := Find(tlvs, 0x6e, 0x73, 0xc5); !ok || !bytes.Equal(obj.Value, []byte{0xab, 0xcd}) {
+		t.Errorf("6E.73.C5: got %+v, %t", obj, ok)
+	}
+
+	for _, path := range [][]Tag{{0x6e, 0xc5}, {0x73}, {0x6e, 0x4f, 0x01}} {
+		if obj, ok := Find(tlvs, path...); ok {
+			t.Errorf("%v: found %+v", path, obj)
+		}
+	}
+}
+
+func TestTag(t *testing.T) {
+	t.Parallel()
+
+	for tag, expected := range map[Tag]string{0x7f49: "7F49", 0x86: "86", 0x5fff01: "5FFF01"} {
+		if tag.String() != expected {
+			t.Errorf("got %s expected %s", tag, expected)
+		}
+	}
+
+	if !Tag(0x7f49).Constructed() || Tag(0x5f50).Constructed() {
+		t.Error("constructed bit")
+	}
+}
+
+// FuzzUnmarshal checks Unmarshal doesn't panic and that what it returns marshals back to the same objects.
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range []string{"7f49058603040102", "65095b005f2d005f350139", "538201", "5fffffff0100", "3080"} {
+		f.Add(mustHex(f, seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tlvs, err := Unmarshal(data)
+		if err != nil {
+			return
+		}
+
+		b, err := Marshal(tlvs...)
+		if err != nil {
+			t.Fatalf("marshal of %X: %v", data, err)
+		}
+
+		again, err := Unmarshal(b)
+		if err != nil {
+			t.Fatalf("unmarshal of %X: %v", b, err)
+		}
+
+		b2, err := Marshal(again...)
+		if err != nil || !bytes.Equal(b, b2) {
+			t.Fatalf("marshal of %X isn't stable: %X, %v", b, b2, err)
+		}
+	})
+}
+
+// FuzzParse checks Parse doesn't panic.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{"7f49058603040102", "65095b005f2d005f350139", "538201"} {
+		f.Add(mustHex(f, seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = Parse(data, nil)
+	})
+}
@@ -0,0 +1,141 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/example/shared"
+	"github.com/areese/piv-go/piv"
+)
+
+func (a *app) newEncryptCommand() *cobra.Command {
+	var (
+		output  string
+		openPGP bool
+		armor   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "encrypt FILE",
+		Short: "Encrypt a file for the decryption key of the card",
+		Long: "Encrypt a file for the decryption key of the card. The file is encrypted with AES-256-GCM in chunks\n" +
+			"and only the data key is wrapped by the card key, or with --openpgp into a message gpg --decrypt reads.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if a.out.structured() {
+				return errNoStructuredOutput
+			}
+
+			ctx := cmd.Context()
+
+			yk, err := a.openCard(ctx, false)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			card := shared.NewGPGYubiKeyImpl(yk).WithConfig(a.cfg)
+
+			if openPGP {
+				data, err := shared.LoadFile(ctx, a.log, args[0])
+				if err != nil {
+					return err
+				}
+
+				message, err := card.EncryptOpenPGP(ctx, a.log, data, armor)
+				if err != nil {
+					return err
+				}
+
+				return writeOutput(cmd.OutOrStdout(), output, message)
+			}
+
+			if output == "" {
+				return errOutputRequired
+			}
+
+			recipient, err := card.ReadPublicKey(ctx, a.log, piv.AsymmetricConfidentiality)
+			if err != nil {
+				return err
+			}
+
+			return shared.EncryptFile(ctx, a.log, recipient, args[0], output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write, stdout for OpenPGP messages if not set")
+	cmd.Flags().BoolVar(&openPGP, "openpgp", false, "write an OpenPGP message instead of an envelope")
+	cmd.Flags().BoolVar(&armor, "armor", true, "ASCII armor the OpenPGP message")
+
+	return cmd
+}
+
+func (a *app) newDecryptCommand() *cobra.Command {
+	var (
+		output  string
+		openPGP bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "decrypt FILE",
+		Short: "Decrypt a file with the decryption key of the card",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if a.out.structured() {
+				return errNoStructuredOutput
+			}
+
+			ctx := cmd.Context()
+
+			yk, err := a.openCard(ctx, false)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			card := shared.NewGPGYubiKeyImpl(yk).WithConfig(a.cfg)
+
+			if err := card.ReadPINAndVerify(ctx, a.log, piv.PW2); err != nil {
+				return err
+			}
+
+			if openPGP {
+				data, err := shared.LoadFile(ctx, a.log, args[0])
+				if err != nil {
+					return err
+				}
+
+				plainText, err := card.DecryptOpenPGP(ctx, a.log, data)
+				if err != nil {
+					return err
+				}
+
+				return writeOutput(cmd.OutOrStdout(), output, plainText)
+			}
+
+			if output == "" {
+				return errOutputRequired
+			}
+
+			return shared.DecryptFile(ctx, a.log, card, args[0], output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write, stdout for OpenPGP messages if not set")
+	cmd.Flags().BoolVar(&openPGP, "openpgp", false, "decrypt an OpenPGP message instead of an envelope")
+
+	return cmd
+}
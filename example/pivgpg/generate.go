@@ -0,0 +1,193 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/example/shared"
+	"github.com/areese/piv-go/piv"
+)
+
+// algorithms are the names of the --algorithm flag.
+//
+// nolint:gochecknoglobals
+var algorithms = map[string]piv.Algorithm{
+	"rsa2048": piv.AlgorithmRSA2048,
+	"ec256":   piv.AlgorithmEC256,
+	"ec384":   piv.AlgorithmEC384,
+	"ed25519": piv.AlgorithmEd25519,
+}
+
+func parseAlgorithm(s string) (piv.Algorithm, error) {
+	alg, ok := algorithms[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q, expected rsa2048, ec256, ec384 or ed25519", piv.ErrNoSuchAlgorithm, s)
+	}
+
+	return alg, nil
+}
+
+// generatedKey is the structured output of generate.
+type generatedKey struct {
+	Slot      string `json:"slot"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"`
+}
+
+// importedKey is the structured output of import, one for each slot.
+type importedKey struct {
+	Slot        string    `json:"slot"`
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+	Origin      string    `json:"origin"`
+}
+
+// encodePublicKey returns pub as a PEM public key.
+func encodePublicKey(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func (a *app) newGenerateCommand() *cobra.Command {
+	var keyType, algorithm string
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a key on the card and write its public key",
+		Long: "Generate a key on the card, replacing the key in the slot, and write its public key in PEM.\n" +
+			"--json writes the slot and algorithm with the PEM public key.\n" +
+			"Ed25519 is an X25519 key in the decryption slot and EC keys in the decryption slot are ECDH keys.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+
+			slot, err := parseKeyType(keyType)
+			if err != nil {
+				return err
+			}
+
+			alg, err := parseAlgorithm(algorithm)
+			if err != nil {
+				return err
+			}
+
+			yk, err := a.openCard(ctx, true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			if err := a.verifyPIN(ctx, yk, piv.PW3); err != nil {
+				return err
+			}
+
+			pub, err := yk.GenerateOpenPGPKeyContext(ctx, slot, alg)
+			if err != nil {
+				return err
+			}
+
+			a.log.InfoMsgf("generated a %s %s key", alg, slot)
+
+			encoded, err := encodePublicKey(pub)
+			if err != nil {
+				return err
+			}
+
+			v := generatedKey{Slot: slot.String(), Algorithm: alg.String(), PublicKey: string(encoded)}
+
+			return a.out.write(cmd.OutOrStdout(), v, func() error {
+				_, err := cmd.OutOrStdout().Write(encoded)
+
+				return err
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&keyType, "slot", "sig", "slot of the key: sig, dec or aut")
+	cmd.Flags().StringVar(&algorithm, "algorithm", "rsa2048", "algorithm of the key: rsa2048, ec256, ec384 or ed25519")
+
+	return cmd
+}
+
+func (a *app) newImportCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import FILE",
+		Short: "Import the keys of an armored secret key, like gpg --export-secret-keys --armor writes",
+		Long: "Import the keys of an armored secret key. The newest key that can sign, encrypt and authenticate\n" +
+			"goes to the signature, decryption and authentication slot, like keytocard in gpg --edit-key.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			in, _, err := shared.OpenFile(ctx, a.log, args[0])
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+
+			yk, err := a.openCard(ctx, true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			passphrase, err := a.cfg.ReadSecret(ctx, a.log, "Please enter the passphrase of the secret key in "+args[0], "Passphrase")
+			if err != nil {
+				return err
+			}
+
+			adminPIN, err := a.cfg.ReadPIN(ctx, a.log, yk, piv.PW3)
+			if err != nil {
+				return err
+			}
+
+			keys, err := yk.ImportArmoredKey(in, passphrase, adminPIN)
+			if err != nil {
+				return err
+			}
+
+			imported := make([]importedKey, 0, len(keys))
+
+			for slot := piv.SignatureKey; slot <= piv.KeyTypeLast; slot++ {
+				if key, ok := keys[slot]; ok {
+					fingerprint := strings.ToUpper(hex.EncodeToString(key.Fingerprint))
+					a.log.InfoMsgf("imported %s key %s", slot, fingerprint)
+
+					imported = append(imported, importedKey{
+						Slot:        slot.String(),
+						Fingerprint: fingerprint,
+						Created:     key.Created,
+						Origin:      key.Origin.String(),
+					})
+				}
+			}
+
+			return a.out.write(cmd.OutOrStdout(), imported, nil)
+		},
+	}
+}
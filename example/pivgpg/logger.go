@@ -0,0 +1,77 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/areese/piv-go/example/shared"
+)
+
+// logger writes to stderr, so stdout only has the output of the command.
+type logger struct {
+	w       io.Writer
+	verbose bool
+	debug   bool
+	quiet   bool
+}
+
+var _ shared.LogI = (*logger)(nil)
+
+func (l *logger) VerboseMsg(message string) {
+	if l.verbose {
+		fmt.Fprintln(l.w, message)
+	}
+}
+
+func (l *logger) VerboseMsgf(format string, args ...interface{}) {
+	l.VerboseMsg(fmt.Sprintf(format, args...))
+}
+
+func (l *logger) InfoMsg(message string) {
+	if !l.quiet {
+		fmt.Fprintln(l.w, message)
+	}
+}
+
+func (l *logger) InfoMsgf(format string, args ...interface{}) {
+	l.InfoMsg(fmt.Sprintf(format, args...))
+}
+
+func (l *logger) DebugMsg(message string) {
+	if l.debug {
+		fmt.Fprintln(l.w, message)
+	}
+}
+
+func (l *logger) DebugMsgf(format string, args ...interface{}) {
+	l.DebugMsg(fmt.Sprintf(format, args...))
+}
+
+func (l *logger) IsDebugEnabled() bool {
+	return l.debug
+}
+
+// ErrorMsg is only written in verbose mode, the error is returned to main and printed once there.
+func (l *logger) ErrorMsg(err error, message string) {
+	if l.verbose {
+		fmt.Fprintf(l.w, "%s: %v\n", message, err)
+	}
+}
+
+func (l *logger) ErrorMsgf(err error, format string, args ...interface{}) {
+	l.ErrorMsg(err, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,169 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// pivgpg uses the OpenPGP applet of a YubiKey or another OpenPGP card, to show its status, encrypt and
+// decrypt files, sign and verify, generate or import keys, reset the applet and manage the PINs.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/example/shared"
+	"github.com/areese/piv-go/piv"
+)
+
+// errOutputRequired is returned when a command writing binary data has no --output.
+var errOutputRequired = errors.New("--output is required")
+
+// app is the state shared by the subcommands, set from the persistent flags.
+type app struct {
+	cfg *shared.Config
+	log *logger
+	out output
+
+	// listCards lists the cards for the cards command.
+	listCards func(ctx context.Context) ([]piv.CardInfo, error)
+}
+
+func main() {
+	if err := newRootCommand(newApp()).Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "pivgpg: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *app {
+	return &app{
+		cfg:       shared.New(context.Background(), nil),
+		log:       &logger{w: os.Stderr},
+		listCards: piv.ListCards,
+	}
+}
+
+func newRootCommand(a *app) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "pivgpg",
+		Short:         "Use the OpenPGP applet of a smart card",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			a.log.verbose = a.cfg.Verbose || a.cfg.Debug
+			a.log.debug = a.cfg.Debug
+			a.log.quiet = a.cfg.Quiet
+
+			return a.out.parse()
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.cfg.Serial, "serial", "", "serial number of the card to use")
+	flags.StringSliceVar(&a.cfg.ReaderAllow, "reader-allow", nil, "regular expressions of the readers to use, readers with YubiKey in their name by default")
+	flags.StringSliceVar(&a.cfg.ReaderDeny, "reader-deny", nil, "regular expressions of the readers never to use")
+	flags.StringVar(&a.cfg.ReaderPolicy, "reader-policy", "", "card to use when several are selected: first, newest-serial or error")
+	flags.StringVar(&a.cfg.Pinentry, "pinentry", "", "pinentry program asking for PINs, they're read from the terminal without one")
+	flags.BoolVarP(&a.cfg.Verbose, "verbose", "v", false, "log what is done")
+	flags.BoolVar(&a.cfg.Debug, "debug", false, "log debugging details")
+	flags.BoolVar(&a.cfg.Trace, "trace", false, "trace the calls to the card")
+	flags.BoolVarP(&a.cfg.Quiet, "quiet", "q", false, "only write the output of the command")
+	a.out.addFlags(root)
+
+	root.AddCommand(
+		a.newCardsCommand(),
+		a.newStatusCommand(),
+		a.newEncryptCommand(),
+		a.newDecryptCommand(),
+		a.newSignCommand(),
+		a.newVerifyCommand(),
+		a.newGenerateCommand(),
+		a.newImportCommand(),
+		a.newResetCommand(),
+		a.newPINCommand(),
+	)
+
+	return root
+}
+
+// openCard opens the card to use, the first one if the reader policy leaves several.
+// allowEmpty selects cards without a decryption key too. The caller must close the card.
+func (a *app) openCard(ctx context.Context, allowEmpty bool) (*piv.GPGYubiKey, error) {
+	a.cfg.CardSelection.WithAllowEmpty(allowEmpty)
+
+	yubikeys, err := a.cfg.SelectCards(ctx, a.log)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, yk := range yubikeys[1:] {
+		if err := yk.Close(); err != nil {
+			a.log.ErrorMsg(err, "closing an unused card failed")
+		}
+	}
+
+	if len(yubikeys) > 1 {
+		a.log.InfoMsgf("[%d] cards found, using the first one", len(yubikeys))
+	}
+
+	return yubikeys[0], nil
+}
+
+// verifyPIN asks for pw and verifies it.
+func (a *app) verifyPIN(ctx context.Context, yk *piv.GPGYubiKey, pw piv.PW) error {
+	return shared.NewGPGYubiKeyImpl(yk).WithConfig(a.cfg).ReadPINAndVerify(ctx, a.log, pw)
+}
+
+// keyAuth asks for the PIN when the card needs it.
+func (a *app) keyAuth(ctx context.Context, yk *piv.GPGYubiKey, pw piv.PW) piv.OpenPGPKeyAuth {
+	return piv.OpenPGPKeyAuth{PINPrompt: func() ([]byte, error) {
+		return a.cfg.ReadPIN(ctx, a.log, yk, pw)
+	}}
+}
+
+// closeCard closes yk, logging the error.
+func (a *app) closeCard(yk *piv.GPGYubiKey) {
+	if err := yk.Close(); err != nil {
+		a.log.ErrorMsg(err, "closing the card failed")
+	}
+}
+
+// parseKeyType parses the slot names of the --slot flags.
+func parseKeyType(s string) (piv.KeyType, error) {
+	switch s {
+	case "sig", "signature":
+		return piv.SignatureKey, nil
+	case "dec", "decryption", "encryption":
+		return piv.DecryptionKey, nil
+	case "aut", "authentication":
+		return piv.AuthenticationKey, nil
+	default:
+		return piv.KeyTypeUnknown, fmt.Errorf("%w: %q, expected sig, dec or aut", piv.ErrUnknownKeyType, s)
+	}
+}
+
+// writeOutput writes data to path, or to stdout if path is - or empty.
+func writeOutput(stdout io.Writer, path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := stdout.Write(data)
+
+		return err
+	}
+
+	// nolint:gomnd // owner read and write, the output may be a secret.
+	return os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,258 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// testCards is the card access of a pivtest client with one card.
+type testCards struct {
+	*piv.Client
+}
+
+func (c testCards) Cards() ([]string, error) {
+	return []string{pivtest.Reader(0)}, nil
+}
+
+// runCommand runs pivgpg with args on card and returns what it wrote to stdout.
+func runCommand(t *testing.T, card *pivtest.Card, args ...string) (string, error) {
+	t.Helper()
+
+	a := newApp()
+	a.cfg.CardAccessor = testCards{pivtest.NewClient(card)}
+
+	var stdout, stderr bytes.Buffer
+
+	a.log.w = &stderr
+
+	root := newRootCommand(a)
+	root.SetArgs(args)
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+
+	err := root.Execute()
+
+	return stdout.String(), err
+}
+
+// signWithCard generates a signature key on card and signs data like pivgpg sign.
+func signWithCard(t *testing.T, card *pivtest.Card, data []byte) string {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(card).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer yk.Close()
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.SignatureKey, piv.AlgorithmEC256); err != nil {
+		t.Fatal(err)
+	}
+
+	priv, err := yk.OpenPGPPrivateKey(piv.SignatureKey, piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(data)
+
+	sig, err := priv.(crypto.Signer).Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	out, err := runCommand(t, pivtest.NewCard(pivtest.Options{}), "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "Yubico") {
+		t.Errorf("got status:\n%s", out)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{})
+	dir := t.TempDir()
+	data := []byte("signed by the card")
+	file := filepath.Join(dir, "data")
+	sigFile := filepath.Join(dir, "data.sig")
+
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(sigFile, []byte(signWithCard(t, card, data)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCommand(t, card, "verify", file, sigFile); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("changed"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCommand(t, card, "verify", file, sigFile); !errors.Is(err, errBadSignature) {
+		t.Errorf("got %v expected %v", err, errBadSignature)
+	}
+}
+
+func TestReset(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{})
+
+	if _, err := runCommand(t, card, "reset"); !errors.Is(err, errNotConfirmed) {
+		t.Fatalf("got %v expected %v", err, errNotConfirmed)
+	}
+
+	if _, err := runCommand(t, card, "reset", "--yes"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runCommand(t, card, "pin", "retries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "PIN: 3\nReset code: 0\nAdmin PIN: 3\n"; out != want {
+		t.Errorf("got %q expected %q", out, want)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	t.Parallel()
+
+	if _, err := runCommand(t, pivtest.NewCard(pivtest.Options{}), "generate", "--slot", "x"); !errors.Is(err, piv.ErrUnknownKeyType) {
+		t.Errorf("got %v expected %v", err, piv.ErrUnknownKeyType)
+	}
+
+	if _, err := runCommand(t, pivtest.NewCard(pivtest.Options{}), "generate", "--algorithm", "dsa"); !errors.Is(err, piv.ErrNoSuchAlgorithm) {
+		t.Errorf("got %v expected %v", err, piv.ErrNoSuchAlgorithm)
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{})
+
+	out, err := runCommand(t, card, "status", "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status []piv.GpgDataJSON
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+
+	if len(status) != 1 || status[0].Serial != fmt.Sprint(pivtest.DefaultSerial) {
+		t.Errorf("got status:\n%s", out)
+	}
+
+	out, err = runCommand(t, card, "status", "--format", "{{.Serial}} {{.Manufacturer}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := status[0].Serial + " " + status[0].Manufacturer + "\n"; out != want {
+		t.Errorf("got %q expected %q", out, want)
+	}
+
+	if _, err := runCommand(t, card, "status", "--format", "{{.Serial"); err == nil {
+		t.Error("bad template accepted")
+	}
+
+	if _, err := runCommand(t, card, "status", "--json", "--format", "{{.Serial}}"); err == nil {
+		t.Error("--json accepted with a template")
+	}
+}
+
+func TestCards(t *testing.T) {
+	t.Parallel()
+
+	a := newApp()
+	a.listCards = pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).ListCards
+
+	var stdout bytes.Buffer
+
+	root := newRootCommand(a)
+	root.SetArgs([]string{"cards", "--json"})
+	root.SetOut(&stdout)
+
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	var cards []piv.CardInfoJSON
+	if err := json.Unmarshal(stdout.Bytes(), &cards); err != nil {
+		t.Fatalf("%v:\n%s", err, stdout.String())
+	}
+
+	if len(cards) != 1 || cards[0].Reader != pivtest.Reader(0) || cards[0].Serial != pivtest.DefaultSerial {
+		t.Errorf("got cards:\n%s", stdout.String())
+	}
+}
+
+func TestPINRetriesJSON(t *testing.T) {
+	t.Parallel()
+
+	out, err := runCommand(t, pivtest.NewCard(pivtest.Options{}), "pin", "retries", "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got pinRetries
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+
+	if want := (pinRetries{PIN: 3, AdminPIN: 3}); got != want {
+		t.Errorf("got %+v expected %+v", got, want)
+	}
+
+	if _, err := runCommand(t, pivtest.NewCard(pivtest.Options{}), "encrypt", "--json", "file"); !errors.Is(err, errNoStructuredOutput) {
+		t.Errorf("got %v expected %v", err, errNoStructuredOutput)
+	}
+}
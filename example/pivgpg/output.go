@@ -0,0 +1,117 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// errNoStructuredOutput is returned by commands writing binary data when --json or --format is set.
+var errNoStructuredOutput = errors.New("the command has no structured output, --json and --format can't be used")
+
+// output is how the commands write what they found, set from --json and --format.
+type output struct {
+	format string
+	json   bool
+	tmpl   *template.Template
+}
+
+func (o *output) addFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.format, "format", formatText, "output format: text, json or a Go template executed for each item, such as {{.Serial}}")
+	flags.BoolVar(&o.json, "json", false, "write JSON, the same as --format json")
+}
+
+// parse checks the flags and parses the template.
+func (o *output) parse() error {
+	if o.json {
+		if o.format != formatText && o.format != formatJSON {
+			return fmt.Errorf("--json can't be used with --format %q", o.format)
+		}
+
+		o.format = formatJSON
+	}
+
+	if o.format == "" {
+		o.format = formatText
+	}
+
+	if o.format == formatText || o.format == formatJSON {
+		return nil
+	}
+
+	tmpl, err := template.New("format").Parse(o.format)
+	if err != nil {
+		return fmt.Errorf("--format: %w", err)
+	}
+
+	o.tmpl = tmpl
+
+	return nil
+}
+
+// structured is true when --json or a template is used.
+func (o *output) structured() bool {
+	return o.format != formatText
+}
+
+// write writes v as JSON, or applies the template to each element of v if it's a slice and to v otherwise,
+// each followed by a newline. text writes the text output, nil if the command has none.
+func (o *output) write(w io.Writer, v any, text func() error) error {
+	switch {
+	case o.format == formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(v)
+	case o.tmpl != nil:
+		items := []any{v}
+
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+			items = make([]any, rv.Len())
+			for i := range items {
+				items[i] = rv.Index(i).Interface()
+			}
+		}
+
+		for _, item := range items {
+			if err := o.tmpl.Execute(w, item); err != nil {
+				return err
+			}
+
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case text != nil:
+		return text()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// errPINMismatch is returned when the new PIN isn't repeated the same.
+var errPINMismatch = errors.New("the new PINs don't match")
+
+// pinRetries is the structured output of pin retries.
+type pinRetries struct {
+	PIN       int `json:"pin"`
+	ResetCode int `json:"resetCode"`
+	AdminPIN  int `json:"adminPIN"`
+}
+
+func (a *app) newPINCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pin",
+		Short: "Show the PIN retries, change or unblock the PINs",
+	}
+
+	cmd.AddCommand(a.newPINRetriesCommand(), a.newPINChangeCommand(), a.newPINUnblockCommand())
+
+	return cmd
+}
+
+func (a *app) newPINRetriesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "retries",
+		Short: "Show the remaining attempts of the PINs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			yk, err := a.openCard(cmd.Context(), true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			retries, err := yk.PINRetries()
+			if err != nil {
+				return err
+			}
+
+			v := pinRetries{PIN: retries.PW1, ResetCode: retries.ResetCode, AdminPIN: retries.PW3}
+
+			return a.out.write(cmd.OutOrStdout(), v, func() error {
+				_, err := fmt.Fprintf(cmd.OutOrStdout(), "PIN: %d\nReset code: %d\nAdmin PIN: %d\n", v.PIN, v.ResetCode, v.AdminPIN)
+
+				return err
+			})
+		},
+	}
+}
+
+func (a *app) newPINChangeCommand() *cobra.Command {
+	var admin bool
+
+	cmd := &cobra.Command{
+		Use:   "change",
+		Short: "Change the PIN, or the admin PIN with --admin",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+
+			pw, name := piv.PW1, "PIN"
+			if admin {
+				pw, name = piv.PW3, "Admin PIN"
+			}
+
+			yk, err := a.openCard(ctx, true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			oldPIN, err := a.cfg.ReadPIN(ctx, a.log, yk, pw)
+			if err != nil {
+				return err
+			}
+
+			newPIN, err := a.readNewPIN(ctx, name)
+			if err != nil {
+				return err
+			}
+
+			if err := yk.ChangePIN(pw, oldPIN, newPIN); err != nil {
+				return err
+			}
+
+			a.log.InfoMsgf("the %s was changed", name)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&admin, "admin", false, "change the admin PIN")
+
+	return cmd
+}
+
+func (a *app) newPINUnblockCommand() *cobra.Command {
+	var admin bool
+
+	cmd := &cobra.Command{
+		Use:   "unblock",
+		Short: "Set a new PIN with the reset code, or with the admin PIN with --admin",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+
+			yk, err := a.openCard(ctx, true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			var resetCode []byte
+
+			if admin {
+				err = a.verifyPIN(ctx, yk, piv.PW3)
+			} else {
+				resetCode, err = a.cfg.ReadSecret(ctx, a.log, "Please enter the reset code of the card", "Reset code")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			newPIN, err := a.readNewPIN(ctx, "PIN")
+			if err != nil {
+				return err
+			}
+
+			if err := yk.UnblockPIN(resetCode, newPIN); err != nil {
+				return err
+			}
+
+			a.log.InfoMsg("the PIN was set")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&admin, "admin", false, "use the admin PIN instead of the reset code")
+
+	return cmd
+}
+
+// readNewPIN asks for a new PIN twice.
+func (a *app) readNewPIN(ctx context.Context, name string) ([]byte, error) {
+	newPIN, err := a.cfg.ReadSecret(ctx, a.log, "Please enter the new "+name, "New "+name)
+	if err != nil {
+		return nil, err
+	}
+
+	repeated, err := a.cfg.ReadSecret(ctx, a.log, "Please repeat the new "+name, "New "+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(newPIN, repeated) {
+		return nil, errPINMismatch
+	}
+
+	return newPIN, nil
+}
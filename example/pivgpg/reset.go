@@ -0,0 +1,61 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// errNotConfirmed is returned by reset without --yes.
+var errNotConfirmed = errors.New("reset deletes the keys, run it with --yes")
+
+func (a *app) newResetCommand() *cobra.Command {
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "reset",
+		Short: "Reset the OpenPGP applet to its factory settings",
+		Long: "Reset the OpenPGP applet to its factory settings, deleting the keys, certificates and cardholder data\n" +
+			"and setting the default PINs. The PINs aren't needed, the other applets aren't changed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !yes {
+				return errNotConfirmed
+			}
+
+			yk, err := a.openCard(cmd.Context(), true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			if err := yk.ResetOpenPGP(piv.ResetOpenPGPOptions{Confirm: true}); err != nil {
+				return err
+			}
+
+			a.log.InfoMsg("the OpenPGP applet was reset")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&yes, "yes", false, "confirm the reset, it can't be undone")
+
+	return cmd
+}
@@ -0,0 +1,225 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/example/shared"
+	"github.com/areese/piv-go/piv"
+)
+
+// errBadSignature is returned by verify when the signature doesn't match.
+var errBadSignature = errors.New("bad signature")
+
+// signature is the structured output of sign.
+type signature struct {
+	Slot      string `json:"slot"`
+	Signature string `json:"signature"`
+}
+
+// verification is the structured output of verify, it's written for bad signatures too.
+type verification struct {
+	Slot  string `json:"slot"`
+	Valid bool   `json:"valid"`
+}
+
+// signedData returns what is signed for a file: the file itself for Ed25519 keys, which hash the message,
+// or its SHA-256 digest with the options to sign it.
+func signedData(pub crypto.PublicKey, data []byte) ([]byte, crypto.SignerOpts) {
+	if _, ok := pub.(ed25519.PublicKey); ok {
+		return data, crypto.Hash(0)
+	}
+
+	digest := sha256.Sum256(data)
+
+	return digest[:], crypto.SHA256
+}
+
+func (a *app) newSignCommand() *cobra.Command {
+	var (
+		output  string
+		keyType string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign FILE",
+		Short: "Sign a file, the signature is written in base64",
+		Long: "Sign a file with the signature key, or the authentication key with --slot aut.\n" +
+			"RSA keys sign the SHA-256 digest with PKCS#1 v1.5, ECDSA signatures are ASN.1 and Ed25519 keys sign the file.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			slot, err := parseKeyType(keyType)
+			if err != nil {
+				return err
+			}
+
+			data, err := shared.LoadFile(ctx, a.log, args[0])
+			if err != nil {
+				return err
+			}
+
+			yk, err := a.openCard(ctx, true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			pw := piv.PW1
+			if slot != piv.SignatureKey {
+				pw = piv.PW2
+			}
+
+			priv, err := yk.OpenPGPPrivateKey(slot, a.keyAuth(ctx, yk, pw))
+			if err != nil {
+				return err
+			}
+
+			signer, ok := priv.(crypto.Signer)
+			if !ok {
+				return fmt.Errorf("%w: the %s key can't sign", piv.ErrNotSupportedByCard, slot)
+			}
+
+			signed, opts := signedData(signer.Public(), data)
+
+			sig, err := signer.Sign(nil, signed, opts)
+			if err != nil {
+				return err
+			}
+
+			v := signature{Slot: slot.String(), Signature: base64.StdEncoding.EncodeToString(sig)}
+
+			if a.out.structured() {
+				var buf bytes.Buffer
+
+				if err := a.out.write(&buf, v, nil); err != nil {
+					return err
+				}
+
+				return writeOutput(cmd.OutOrStdout(), output, buf.Bytes())
+			}
+
+			return writeOutput(cmd.OutOrStdout(), output, []byte(v.Signature+"\n"))
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the signature to, stdout if not set")
+	cmd.Flags().StringVar(&keyType, "slot", "sig", "key to sign with: sig or aut")
+
+	return cmd
+}
+
+func (a *app) newVerifyCommand() *cobra.Command {
+	var keyType string
+
+	cmd := &cobra.Command{
+		Use:   "verify FILE SIGNATURE",
+		Short: "Verify a signature made by sign with the public key on the card",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			slot, err := parseKeyType(keyType)
+			if err != nil {
+				return err
+			}
+
+			data, err := shared.LoadFile(ctx, a.log, args[0])
+			if err != nil {
+				return err
+			}
+
+			encoded, err := shared.LoadFile(ctx, a.log, args[1])
+			if err != nil {
+				return err
+			}
+
+			sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+			if err != nil {
+				return fmt.Errorf("signature is not base64: %w", err)
+			}
+
+			yk, err := a.openCard(ctx, true)
+			if err != nil {
+				return err
+			}
+			defer a.closeCard(yk)
+
+			pub, err := yk.ReadOpenPGPPublicKey(slot)
+			if err != nil {
+				return err
+			}
+
+			err = verifySignature(pub, data, sig)
+			if err != nil && !errors.Is(err, errBadSignature) {
+				return err
+			}
+
+			valid := err == nil
+
+			if err := a.out.write(cmd.OutOrStdout(), verification{Slot: slot.String(), Valid: valid}, nil); err != nil {
+				return err
+			}
+
+			if !valid {
+				return err
+			}
+
+			a.log.InfoMsgf("good signature from the %s key", slot)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&keyType, "slot", "sig", "key that signed: sig or aut")
+
+	return cmd
+}
+
+// verifySignature checks sig of data like sign made it.
+func verifySignature(pub crypto.PublicKey, data, sig []byte) error {
+	signed, opts := signedData(pub, data)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, opts.HashFunc(), signed, sig); err != nil {
+			return fmt.Errorf("%w: %w", errBadSignature, err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, signed, sig) {
+			return errBadSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, signed, sig) {
+			return errBadSignature
+		}
+	default:
+		return fmt.Errorf("%w: %T can't verify", piv.ErrNoSuchAlgorithm, pub)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/areese/piv-go/piv"
+)
+
+func (a *app) newCardsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cards",
+		Short: "List the cards in every reader with their serial, version and applets",
+		Long: "List the cards in every reader with their serial, version and applets. The reader flags aren't used,\n" +
+			"every card is listed. --json includes the OpenPGP data of the cards that have the applet.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			infos, err := a.listCards(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			cards := make([]*piv.CardInfoJSON, len(infos))
+			for i, info := range infos {
+				cards[i] = info.JSON()
+			}
+
+			return a.out.write(cmd.OutOrStdout(), cards, func() error {
+				return writeCards(cmd.OutOrStdout(), cards)
+			})
+		},
+	}
+}
+
+// writeCards writes a line for each card.
+func writeCards(w io.Writer, cards []*piv.CardInfoJSON) error {
+	for _, card := range cards {
+		var err error
+
+		if card.Error != "" {
+			_, err = fmt.Fprintf(w, "%s: %s\n", card.Reader, card.Error)
+		} else {
+			_, err = fmt.Fprintf(w, "%s: %s %s serial %d version %s applets %s\n",
+				card.Reader, card.Family, card.Model, card.Serial, card.Version, strings.Join(card.Applets, ","))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *app) newStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of the selected cards like gpg --card-status, or their OpenPGP data with --json",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			a.cfg.CardSelection.WithAllowEmpty(true)
+
+			yubikeys, err := a.cfg.SelectCards(cmd.Context(), a.log)
+			if err != nil {
+				return err
+			}
+
+			for _, yk := range yubikeys {
+				defer a.closeCard(yk)
+			}
+
+			if !a.out.structured() {
+				for _, yk := range yubikeys {
+					if err := yk.WriteCardStatus(cmd.OutOrStdout()); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}
+
+			status := make([]*piv.GpgDataJSON, len(yubikeys))
+
+			for i, yk := range yubikeys {
+				data, err := yk.GPGData()
+				if err != nil {
+					return err
+				}
+
+				status[i] = data.JSON()
+			}
+
+			return a.out.write(cmd.OutOrStdout(), status, nil)
+		},
+	}
+}
@@ -44,6 +44,11 @@ func (p *PGPCardAccess) OpenGPG(card string) (*piv.GPGYubiKey, error) {
 // nolint:funlen,cyclop
 func (c *CardSelection) GetCards(ctx context.Context, logger LogI, cfg *Config) ([]*piv.GPGYubiKey, error) {
 	logger = Nop(logger)
+	readerFilter, err := cfg.ReaderFilter()
+	if err != nil {
+		return nil, err
+	}
+
 	// List all smartcards connected to the system.
 	cards, err := c.CardAccessor.Cards()
 	if err != nil {
@@ -55,12 +60,25 @@ func (c *CardSelection) GetCards(ctx context.Context, logger LogI, cfg *Config)
 	ykIndex := 0
 
 	for index, card := range cards {
-		if !strings.Contains(strings.ToLower(card), "yubikey") {
+		if len(readerFilter.Allow) == 0 && !strings.Contains(strings.ToLower(card), "yubikey") {
 			logger.VerboseMsgf("skipping %s", card)
 
 			continue
 		}
 
+		var matches bool
+
+		matches, err = readerFilter.Matches(card)
+		if err != nil {
+			return nil, err
+		}
+
+		if !matches {
+			logger.VerboseMsgf("skipping %s not matching the reader filter", card)
+
+			continue
+		}
+
 		logger.VerboseMsgf("opening %s", card)
 
 		var gpgCard *piv.GPGYubiKey
@@ -111,7 +129,50 @@ func (c *CardSelection) GetCards(ctx context.Context, logger LogI, cfg *Config)
 		return nil, err
 	}
 
-	return yubikeys[0:ykIndex], nil
+	if cfg.ReaderPolicy == "" {
+		return yubikeys[0:ykIndex], nil
+	}
+
+	return applyReaderPolicy(logger, readerFilter.Policy, yubikeys[0:ykIndex])
+}
+
+// applyReaderPolicy keeps the card policy picks out of several selected cards, the others are closed.
+func applyReaderPolicy(logger LogI, policy piv.ReaderPolicy, yubikeys []*piv.GPGYubiKey) ([]*piv.GPGYubiKey, error) {
+	picked := 0
+
+	switch policy {
+	case piv.ReaderPolicyFirst:
+	case piv.ReaderPolicyError:
+		if len(yubikeys) > 1 {
+			closeCards(logger, yubikeys)
+
+			return nil, fmt.Errorf("%w: %d cards selected", piv.ErrMultipleCards, len(yubikeys))
+		}
+	case piv.ReaderPolicyNewestSerial:
+		var newest uint32
+
+		for index, gpgCard := range yubikeys {
+			// Yubico serials are BCD in the AID, which orders the same as the decimal serial.
+			serial, err := gpgCard.Serial()
+			if err == nil && serial > newest {
+				newest, picked = serial, index
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown reader policy %s", policy)
+	}
+
+	closeCards(logger, append(yubikeys[:picked:picked], yubikeys[picked+1:]...))
+
+	return yubikeys[picked : picked+1], nil
+}
+
+func closeCards(logger LogI, yubikeys []*piv.GPGYubiKey) {
+	for _, gpgCard := range yubikeys {
+		if err := gpgCard.Close(); err != nil {
+			logger.ErrorMsgf(err, "gpgCard.Close() failed")
+		}
+	}
 }
 
 // nolint:unparam
@@ -146,13 +207,13 @@ func (c *CardSelection) filter(ctx context.Context, logger LogI, gpgCard *piv.GP
 
 	// TODO: we only support decryption keys right now.
 	hasKey, err = HasValidKeyType(logger, gpgCard, piv.DecryptionKey)
-	if err != nil {
+	if err != nil && !c.AllowEmpty {
 		logger.ErrorMsgf(err, "gpgCard.HasValidKeyType() of [%s] failed", card)
 
 		return false, err
 	}
 
-	if !hasKey {
+	if !hasKey && !c.AllowEmpty {
 		logger.VerboseMsgf("Ignoring card serial [%s] no DecryptionKey keys found for [%s]", serial, card)
 
 		return false, piv.ErrKeyNotPresent
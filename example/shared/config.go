@@ -26,6 +26,9 @@ type CardSelection struct {
 	*YubikeyData
 
 	CardAccessor CardAccess
+
+	// AllowEmpty selects cards without a decryption key, for commands that generate keys or reset the card.
+	AllowEmpty bool
 }
 
 type Config struct {
@@ -46,6 +49,23 @@ type Config struct {
 	ShowPublic bool
 
 	Base64Encoded bool
+
+	// OpenPGP encrypts to ASCII armored OpenPGP messages that `gpg --decrypt` reads, instead of envelopes.
+	OpenPGP bool
+
+	// ReaderAllow are regular expressions of the readers to use, readers with YubiKey in their name if it's empty.
+	ReaderAllow []string
+
+	// ReaderDeny are regular expressions of readers that are never used, such as the embedded reader of a kiosk.
+	ReaderDeny []string
+
+	// ReaderPolicy picks one card when several are selected: first, newest-serial or error.
+	// All selected cards are used if it's empty.
+	ReaderPolicy string
+
+	// Pinentry is the GnuPG pinentry program that asks for PINs, such as pinentry-mac.
+	// PINs are read from the terminal if it's empty or isn't found.
+	Pinentry string
 }
 
 func (c *Config) SelectCards(ctx context.Context, logger LogI) ([]*piv.GPGYubiKey, error) {
@@ -104,6 +124,12 @@ func (c *CardSelection) WithYubikeyData(value *YubikeyData) *CardSelection {
 	return c
 }
 
+func (c *CardSelection) WithAllowEmpty(value bool) *CardSelection {
+	c.AllowEmpty = value
+
+	return c
+}
+
 func (c *Config) WithCardSelection(value *CardSelection) *Config {
 	c.CardSelection = value
 
@@ -151,3 +177,43 @@ func (c *Config) WithBase64Encoded(value bool) *Config {
 
 	return c
 }
+
+func (c *Config) WithReaderAllow(value ...string) *Config {
+	c.ReaderAllow = value
+
+	return c
+}
+
+func (c *Config) WithReaderDeny(value ...string) *Config {
+	c.ReaderDeny = value
+
+	return c
+}
+
+func (c *Config) WithReaderPolicy(value string) *Config {
+	c.ReaderPolicy = value
+
+	return c
+}
+
+func (c *Config) WithPinentry(value string) *Config {
+	c.Pinentry = value
+
+	return c
+}
+
+// ReaderFilter returns the reader allow and deny lists and policy of the config.
+func (c *Config) ReaderFilter() (piv.ReaderFilter, error) {
+	rv := piv.ReaderFilter{Allow: c.ReaderAllow, Deny: c.ReaderDeny}
+
+	if c.ReaderPolicy != "" {
+		policy, err := piv.ParseReaderPolicy(c.ReaderPolicy)
+		if err != nil {
+			return rv, err
+		}
+
+		rv.Policy = policy
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,267 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// ErrNotAnEnvelope is returned when the data doesn't start with the envelope header.
+var ErrNotAnEnvelope = errors.New("not an encrypted envelope")
+
+// The envelope is:
+//
+//	magic "PGE1"
+//	1 byte key kind, envelopeRSA or envelopeECDH
+//	2 bytes big endian length of the wrapped key
+//	wrapped key, the RSA encrypted data key or the ephemeral ECDH public key
+//	12 bytes nonce
+//	AES-256-GCM sealed data, the header is the additional data
+//
+// RSA keys wrap a random data key with PKCS#1 v1.5, the only padding the card can remove.
+// ECDH keys use the SHA-256 of the shared secret as the data key, like EncryptECDH.
+var envelopeMagic = []byte("PGE1")
+
+const (
+	envelopeRSA  byte = 1
+	envelopeECDH byte = 2
+
+	envelopeDataKeySize = 32
+)
+
+// SealEnvelope encrypts plainText of any size for the decryption key of a card.
+// The data is encrypted with AES-256-GCM and the data key is wrapped by the RSA or ECDH recipient.
+func SealEnvelope(recipient crypto.PublicKey, plainText []byte) ([]byte, error) {
+	kind, wrapped, aead, err := wrapEnvelopeKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	header := envelopeHeader(envelopeMagic, kind, wrapped)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+
+	return aead.Seal(out, nonce, plainText, header), nil
+}
+
+// OpenEnvelope decrypts the output of SealEnvelope, the card unwraps the data key.
+// The PIN has to be presented first, see AuthPIN.
+func OpenEnvelope(ctx context.Context, logger LogI, yubikey GPGWrapper, data []byte) ([]byte, error) {
+	header, rest, err := parseEnvelopeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, wrapped := header[len(envelopeMagic)], header[len(envelopeMagic)+3:]
+
+	aead, err := unwrapEnvelopeKey(ctx, logger, yubikey, kind, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: envelope too short", piv.ErrTooShort)
+	}
+
+	plainText, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope, likely malformed input: %w", err)
+	}
+
+	return plainText, nil
+}
+
+// wrapEnvelopeKey returns a new data key wrapped for recipient.
+func wrapEnvelopeKey(recipient crypto.PublicKey) (byte, []byte, cipher.AEAD, error) {
+	switch pub := recipient.(type) {
+	case *rsa.PublicKey:
+		dataKey := make([]byte, envelopeDataKeySize)
+
+		if _, err := rand.Read(dataKey); err != nil {
+			return 0, nil, nil, err
+		}
+
+		wrapped, err := rsa.EncryptPKCS1v15(rand.Reader, pub, dataKey)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+
+		aead, err := envelopeAEAD(dataKey)
+
+		return envelopeRSA, wrapped, aead, err
+	case *ecdh.PublicKey:
+		ephemeral, secret, err := piv.ECDHKeyAgreement(rand.Reader, pub)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("key agreement failed: %w", err)
+		}
+
+		aead, err := ecdhAEAD(secret)
+
+		return envelopeECDH, ephemeral.Bytes(), aead, err
+	}
+
+	return 0, nil, nil, fmt.Errorf("%w: %T", piv.ErrNoSuchAlgorithm, recipient)
+}
+
+// envelopeHeader is the magic, the key kind and the wrapped key.
+func envelopeHeader(magic []byte, kind byte, wrapped []byte) []byte {
+	header := append([]byte{}, magic...)
+	header = append(header, kind)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+
+	return append(header, wrapped...)
+}
+
+// unwrapEnvelopeKey asks the card for the data key of an envelope.
+func unwrapEnvelopeKey(ctx context.Context, logger LogI, yubikey GPGWrapper, kind byte, wrapped []byte) (cipher.AEAD, error) {
+	switch kind {
+	case envelopeRSA:
+		dataKey, err := yubikey.Decrypt(ctx, logger, wrapped)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(dataKey) != envelopeDataKeySize {
+			return nil, fmt.Errorf("%w: data key is %d bytes", ErrNotAnEnvelope, len(dataKey))
+		}
+
+		return envelopeAEAD(dataKey)
+	case envelopeECDH:
+		recipient, err := yubikey.ReadPublicKey(ctx, logger, piv.AsymmetricConfidentiality)
+		if err != nil {
+			return nil, err
+		}
+
+		ecdhKey, ok := recipient.(*ecdh.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: envelope is for an ECDH key, the card has %T", piv.ErrNoSuchAlgorithm, recipient)
+		}
+
+		ephemeral, err := ecdhKey.Curve().NewPublicKey(wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ephemeral key, likely malformed input: %w", err)
+		}
+
+		secret, err := yubikey.DecryptECDH(ctx, logger, ephemeral)
+		if err != nil {
+			return nil, err
+		}
+
+		return ecdhAEAD(secret)
+	}
+
+	return nil, fmt.Errorf("%w: unknown key kind [%d]", ErrNotAnEnvelope, kind)
+}
+
+// parseEnvelopeHeader splits data into the header, which is authenticated, and the nonce and sealed data.
+func parseEnvelopeHeader(data []byte) ([]byte, []byte, error) {
+	// magic, kind and the length of the wrapped key.
+	fixed := len(envelopeMagic) + 3
+
+	if len(data) < fixed || !bytes.Equal(data[:len(envelopeMagic)], envelopeMagic) {
+		return nil, nil, ErrNotAnEnvelope
+	}
+
+	end := fixed + int(binary.BigEndian.Uint16(data[len(envelopeMagic)+1:]))
+	if len(data) < end {
+		return nil, nil, fmt.Errorf("%w: envelope too short", piv.ErrTooShort)
+	}
+
+	return data[:end], data[end:], nil
+}
+
+func envelopeAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// EncryptFile encrypts the file at inPath for recipient and writes the stream to outPath.
+// The file is read in chunks, see EncryptStream.
+func EncryptFile(ctx context.Context, logger LogI, recipient crypto.PublicKey, inPath, outPath string) error {
+	logger = Nop(logger)
+
+	return streamFile(ctx, logger, inPath, outPath, func(in io.Reader, out io.Writer) error {
+		return EncryptStream(ctx, logger, recipient, in, out)
+	})
+}
+
+// DecryptFile decrypts the stream at inPath with the card and writes the plain text to outPath.
+// outPath is removed if the stream can't be decrypted.
+func DecryptFile(ctx context.Context, logger LogI, yubikey GPGWrapper, inPath, outPath string) error {
+	logger = Nop(logger)
+
+	return streamFile(ctx, logger, inPath, outPath, func(in io.Reader, out io.Writer) error {
+		return DecryptStream(ctx, logger, yubikey, in, out)
+	})
+}
+
+func streamFile(ctx context.Context, logger LogI, inPath, outPath string, f func(in io.Reader, out io.Writer) error) error {
+	in, _, err := OpenFile(ctx, logger, inPath)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	// nolint:gomnd // owner read and write, the output may be a secret.
+	out, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		err = fmt.Errorf("%w: failed to create path [%s]", err, outPath)
+		logger.ErrorMsgf(err, "Create path [%s] failed.", outPath)
+
+		return err
+	}
+
+	err = f(in, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w: failed to process [%s]", err, inPath)
+		logger.ErrorMsgf(err, "Writing [%s] failed.", outPath)
+
+		if removeErr := os.Remove(outPath); removeErr != nil {
+			logger.ErrorMsgf(removeErr, "Removing [%s] failed.", outPath)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func newTestEnvelopeKey(t *testing.T, rsaKey bool) *GPGYubiKeyImpl {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if rsaKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("generate rsa key: %v", err)
+		}
+
+		if _, err := yk.ImportOpenPGPKey(piv.DecryptionKey, key); err != nil {
+			t.Fatalf("import: %v", err)
+		}
+	} else if _, err := yk.GenerateOpenPGPKey(piv.DecryptionKey, piv.AlgorithmEC256); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// reload the algorithm attributes of the new key.
+	if _, err := yk.GPGData(); err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	rv := NewGPGYubiKeyImpl(yk)
+	if err := rv.AuthPIN(context.Background(), nil, []byte(pivtest.DefaultPIN)); err != nil {
+		t.Fatalf("auth pin: %v", err)
+	}
+
+	return rv
+}
+
+func TestEnvelope(t *testing.T) {
+	t.Parallel()
+
+	// bigger than any RSA block.
+	plainText := make([]byte, 100_000)
+	if _, err := rand.Read(plainText); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		rsaKey bool
+	}{
+		{name: "rsa", rsaKey: true},
+		{name: "ecdh"},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			yubikey := newTestEnvelopeKey(t, tc.rsaKey)
+
+			recipient, err := yubikey.ReadPublicKey(ctx, nil, piv.AsymmetricConfidentiality)
+			if err != nil {
+				t.Fatalf("read public key: %v", err)
+			}
+
+			dir := t.TempDir()
+			inPath := filepath.Join(dir, "plain")
+			encPath := filepath.Join(dir, "plain.enc")
+			outPath := filepath.Join(dir, "plain.out")
+
+			if err := os.WriteFile(inPath, plainText, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := EncryptFile(ctx, nil, recipient, inPath, encPath); err != nil {
+				t.Fatalf("EncryptFile: %v", err)
+			}
+
+			if err := DecryptFile(ctx, nil, yubikey, encPath, outPath); err != nil {
+				t.Fatalf("DecryptFile: %v", err)
+			}
+
+			got, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, plainText) {
+				t.Errorf("decrypted file differs from the plain text")
+			}
+
+			envelope, err := SealEnvelope(recipient, plainText)
+			if err != nil {
+				t.Fatalf("SealEnvelope: %v", err)
+			}
+
+			got, err = OpenEnvelope(ctx, nil, yubikey, envelope)
+			if err != nil || !bytes.Equal(got, plainText) {
+				t.Errorf("OpenEnvelope = %v, want the plain text", err)
+			}
+
+			// the header is authenticated.
+			envelope[len(envelopeMagic)+3] ^= 1
+			if _, err := OpenEnvelope(ctx, nil, yubikey, envelope); err == nil {
+				t.Errorf("OpenEnvelope accepted a modified header")
+			}
+
+			if _, err := OpenEnvelope(ctx, nil, yubikey, plainText); !errors.Is(err, ErrNotAnEnvelope) {
+				t.Errorf("OpenEnvelope(plain text) = %v, want ErrNotAnEnvelope", err)
+			}
+		})
+	}
+}
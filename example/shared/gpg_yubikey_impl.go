@@ -15,13 +15,15 @@
 package shared
 
 import (
+	"bytes"
 	"context"
-	"crypto/rsa"
+	"crypto"
+	"crypto/ecdh"
+	"errors"
 	"fmt"
-	"syscall"
+	"io"
 
 	"github.com/areese/piv-go/piv"
-	"golang.org/x/term"
 )
 
 type GPGWrapper interface {
@@ -32,9 +34,18 @@ type GPGWrapper interface {
 	// ReadPasswordAndSendToYubikey reads the password from the terminal and sends it to the yubikey for verification.
 	// This must be called before the Decrypt call will work.
 	ReadPasswordAndSendToYubikey(ctx context.Context, logger LogI) error
+	// ReadPINAndVerify asks for pw with the pinentry of the config, or from the terminal, and verifies it.
+	ReadPINAndVerify(ctx context.Context, logger LogI, pw piv.PW) error
 	Decrypt(ctx context.Context, logger LogI, data []byte) ([]byte, error)
 	Encrypt(ctx context.Context, logger LogI, data []byte) ([]byte, error)
-	ReadPublicKey(ctx context.Context, logger LogI, keyType piv.AsymmetricKeyType) (*rsa.PublicKey, error)
+	// EncryptOpenPGP returns an OpenPGP message for the decryption key that `gpg --decrypt` reads.
+	EncryptOpenPGP(ctx context.Context, logger LogI, data []byte, armor bool) ([]byte, error)
+	// DecryptOpenPGP decrypts an OpenPGP message, e.g. from `gpg --encrypt`, the PIN has to be sent first.
+	DecryptOpenPGP(ctx context.Context, logger LogI, data []byte) ([]byte, error)
+	// DecryptECDH returns the shared secret of an ECDH decryption key and the ephemeral key of the sender.
+	DecryptECDH(ctx context.Context, logger LogI, peer *ecdh.PublicKey) ([]byte, error)
+	// ReadPublicKey returns an *rsa.PublicKey, or an *ecdh.PublicKey for ECDH decryption keys.
+	ReadPublicKey(ctx context.Context, logger LogI, keyType piv.AsymmetricKeyType) (crypto.PublicKey, error)
 	AuthPIN(ctx context.Context, logger LogI, pin []byte) error
 	Fingerprint(ctx context.Context, logger LogI) (string, error)
 	GetAttestationCert(ctx context.Context, logger LogI, keyType piv.KeyType) ([]byte, error)
@@ -43,7 +54,8 @@ type GPGWrapper interface {
 var _ GPGWrapper = (*GPGYubiKeyImpl)(nil)
 
 type GPGYubiKeyImpl struct {
-	yk *piv.GPGYubiKey
+	yk  *piv.GPGYubiKey
+	cfg *Config
 }
 
 func (g *GPGYubiKeyImpl) AuthPIN(ctx context.Context, logger LogI, pin []byte) error {
@@ -118,26 +130,15 @@ func (g *GPGYubiKeyImpl) Close(ctx context.Context, logger LogI) {
 	}
 }
 
-// ReadPasswordAndSendToYubikey reads the password from the terminal and sends it to the yubikey for verification.
+// ReadPasswordAndSendToYubikey reads the password with the pinentry of the config, or from the terminal,
+// and sends it to the yubikey for verification.
 // This must be called before the Decrypt call will work.
-// nolint:forbidigo
 func (g *GPGYubiKeyImpl) ReadPasswordAndSendToYubikey(ctx context.Context, logger LogI) error {
 	logger = Nop(logger)
 
-	var (
-		bytePassword []byte
-		err          error
-	)
-
-	fmt.Print("Enter Password: ")
-
-	bytePassword, err = term.ReadPassword(syscall.Stdin)
-
-	// add a newline after reading.
-	fmt.Println()
-
+	bytePassword, err := g.config().ReadPIN(ctx, logger, g.yk, piv.PW2)
 	if err != nil {
-		err = fmt.Errorf("failed to read password from terminal: %w", err)
+		err = fmt.Errorf("failed to read password: %w", err)
 		logger.ErrorMsg(err, "Failed to read password")
 
 		return err
@@ -155,6 +156,39 @@ func (g *GPGYubiKeyImpl) ReadPasswordAndSendToYubikey(ctx context.Context, logge
 	return nil
 }
 
+// ReadPINAndVerify asks for pw with the pinentry of the config, or from the terminal, and verifies it:
+// PW1 for signing, PW2 for decryption or PW3 for changing the card.
+func (g *GPGYubiKeyImpl) ReadPINAndVerify(ctx context.Context, logger LogI, pw piv.PW) error {
+	logger = Nop(logger)
+
+	pin, err := g.config().ReadPIN(ctx, logger, g.yk, pw)
+	if err != nil {
+		err = fmt.Errorf("failed to read %s: %w", pw, err)
+		logger.ErrorMsg(err, "Failed to read PIN")
+
+		return err
+	}
+
+	err = g.yk.VerifyPIN(pw, pin)
+	if err != nil {
+		err = fmt.Errorf("verifying %s failed: %w", pw, err)
+		logger.ErrorMsg(err, "Failed to verify PIN")
+
+		return err
+	}
+
+	return nil
+}
+
+// config returns the config of the key, PINs are read from the terminal without one.
+func (g *GPGYubiKeyImpl) config() *Config {
+	if g.cfg == nil {
+		return &Config{}
+	}
+
+	return g.cfg
+}
+
 func NewGPGYubiKeyImpl(yubikey *piv.GPGYubiKey) *GPGYubiKeyImpl {
 	rv := &GPGYubiKeyImpl{
 		yk: yubikey,
@@ -163,6 +197,13 @@ func NewGPGYubiKeyImpl(yubikey *piv.GPGYubiKey) *GPGYubiKeyImpl {
 	return rv
 }
 
+// WithConfig sets the config whose pinentry asks for PINs.
+func (g *GPGYubiKeyImpl) WithConfig(value *Config) *GPGYubiKeyImpl {
+	g.cfg = value
+
+	return g
+}
+
 func (g *GPGYubiKeyImpl) Decrypt(ctx context.Context, logger LogI, data []byte) ([]byte, error) {
 	rv, err := g.yk.Decrypt(data)
 	if err != nil {
@@ -174,7 +215,31 @@ func (g *GPGYubiKeyImpl) Decrypt(ctx context.Context, logger LogI, data []byte)
 	return rv, nil
 }
 
-func (g *GPGYubiKeyImpl) ReadPublicKey(ctx context.Context, logger LogI, keyType piv.AsymmetricKeyType) (*rsa.PublicKey, error) {
+func (g *GPGYubiKeyImpl) DecryptECDH(ctx context.Context, logger LogI, peer *ecdh.PublicKey) ([]byte, error) {
+	rv, err := g.yk.DecryptECDH(peer)
+	if err != nil {
+		err = fmt.Errorf("%w: failed to compute shared secret", err)
+
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+func (g *GPGYubiKeyImpl) ReadPublicKey(ctx context.Context, logger LogI, keyType piv.AsymmetricKeyType) (crypto.PublicKey, error) {
+	if keyType == piv.AsymmetricConfidentiality {
+		ecdhKey, err := g.yk.ReadECDHPublicKey()
+		if err == nil {
+			return ecdhKey, nil
+		}
+
+		if !errors.Is(err, piv.ErrNotECDHKey) {
+			err = fmt.Errorf("%w: failed to read public key", err)
+
+			return nil, err
+		}
+	}
+
 	rv, err := g.yk.ReadPublicKey(keyType)
 	if err != nil {
 		err = fmt.Errorf("%w: failed to read public key", err)
@@ -199,6 +264,44 @@ func (g *GPGYubiKeyImpl) Encrypt(ctx context.Context, logger LogI, data []byte)
 	return rv, nil
 }
 
+func (g *GPGYubiKeyImpl) EncryptOpenPGP(ctx context.Context, logger LogI, data []byte, armor bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := g.yk.EncryptOpenPGPMessage(&buf, piv.OpenPGPMessageOptions{Armor: armor})
+	if err == nil {
+		_, err = w.Write(data)
+	}
+
+	if err == nil {
+		err = w.Close()
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w: failed to encrypt message", err)
+
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (g *GPGYubiKeyImpl) DecryptOpenPGP(ctx context.Context, logger LogI, data []byte) ([]byte, error) {
+	var rv []byte
+
+	r, err := g.yk.DecryptOpenPGPMessage(bytes.NewReader(data), piv.OpenPGPKeyAuth{})
+	if err == nil {
+		rv, err = io.ReadAll(r)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w: failed to decrypt message", err)
+
+		return nil, err
+	}
+
+	return rv, nil
+}
+
 func (g *GPGYubiKeyImpl) GetAttestationCert(ctx context.Context, logger LogI, keyType piv.KeyType) ([]byte, error) {
 	rv, err := g.yk.GetAttestationCert(keyType)
 	if err != nil {
@@ -16,6 +16,11 @@ package shared
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 
@@ -36,7 +41,7 @@ func (c *Config) DecryptSetup(ctx context.Context, logger LogI) (*GPGYubiKeyImpl
 		return nil, err
 	}
 
-	rv := NewGPGYubiKeyImpl(yubikey)
+	rv := NewGPGYubiKeyImpl(yubikey).WithConfig(c)
 
 	return rv, nil
 }
@@ -160,3 +165,80 @@ func DecryptBase64(yubikey *piv.GPGYubiKey, base64Data string) ([]byte, error) {
 
 	return Decrypt(yubikey, cipherTextBytes)
 }
+
+// EncryptECDH encrypts plainText for an ECDH decryption key.
+// The output is the ephemeral public key, the nonce and the AES-GCM sealed data,
+// the AES key is the SHA-256 of the shared secret.
+func EncryptECDH(recipient *ecdh.PublicKey, plainText []byte) ([]byte, error) {
+	ephemeral, secret, err := piv.ECDHKeyAgreement(rand.Reader, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+
+	aead, err := ecdhAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, ephemeral.Bytes()...)
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, plainText, nil), nil
+}
+
+// DecryptECDH decrypts the output of EncryptECDH, the card computes the shared secret.
+func DecryptECDH(yubikey *piv.GPGYubiKey, cipherTextBytes []byte) ([]byte, error) {
+	recipient, err := yubikey.ReadECDHPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decryption key: %w", err)
+	}
+
+	pointLen := len(recipient.Bytes())
+	if len(cipherTextBytes) < pointLen {
+		return nil, fmt.Errorf("%w: cipherTextBytes too short", piv.ErrTooShort)
+	}
+
+	ephemeral, err := recipient.Curve().NewPublicKey(cipherTextBytes[:pointLen])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ephemeral key, likely malformed input: %w", err)
+	}
+
+	secret, err := yubikey.DecryptECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+
+	aead, err := ecdhAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := cipherTextBytes[pointLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: cipherTextBytes too short", piv.ErrTooShort)
+	}
+
+	plainText, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt cipherTextBytes, likely malformed input: %w", err)
+	}
+
+	return plainText, nil
+}
+
+func ecdhAEAD(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(secret)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
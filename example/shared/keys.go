@@ -16,7 +16,11 @@ package shared
 
 import (
 	"context"
+	"crypto/ecdh"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 
 	"github.com/areese/piv-go/bertlv"
@@ -143,6 +147,27 @@ func (c *Config) DisplayKey(ctx context.Context, logger LogI, index int, yubikey
 
 		logger.VerboseMsg("Getting Public Key")
 
+		// ECDH keys are read with the curve from the algorithm attributes.
+		ecdhKey, err := yubikey.ReadECDHPublicKey()
+		if err == nil {
+			pemString, err = exportECDHPublicKeyAsPemStr(ecdhKey)
+			if err != nil {
+				logger.ErrorMsgf(err, "exportECDHPublicKeyAsPemStr failed")
+
+				return err
+			}
+
+			logger.InfoMsgf("pubkey: %s", pemString)
+
+			return nil
+		}
+
+		if !errors.Is(err, piv.ErrNotECDHKey) {
+			logger.ErrorMsgf(err, "yubikey.ReadECDHPublicKey failed")
+
+			return err
+		}
+
 		pubkey, err = yubikey.ReadPublicKey(piv.AsymmetricConfidentiality)
 		if err != nil {
 			logger.ErrorMsgf(err, "yubikey.ReadPublicKey(%s) failed", piv.AsymmetricConfidentiality)
@@ -163,6 +188,15 @@ func (c *Config) DisplayKey(ctx context.Context, logger LogI, index int, yubikey
 	return nil
 }
 
+func exportECDHPublicKeyAsPemStr(publicKey *ecdh.PublicKey) (string, error) {
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal ecdh public key: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})), nil
+}
+
 func (c *Config) DisplayKeys(ctx context.Context, logger LogI, yubikeys []*piv.GPGYubiKey, closeKey bool, showPublicKey bool) error {
 	logger = Nop(logger)
 
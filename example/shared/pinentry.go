@@ -0,0 +1,331 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"golang.org/x/term"
+)
+
+var (
+	// ErrPinentryCanceled is returned when the PIN dialog was canceled or closed.
+	ErrPinentryCanceled = errors.New("pinentry canceled")
+	// ErrPinentryTimeout is returned when the PIN wasn't entered before the deadline of the context.
+	ErrPinentryTimeout = errors.New("pinentry timed out")
+	// ErrNoPINInput is returned when there is neither a pinentry program nor a terminal to read the PIN from.
+	ErrNoPINInput = errors.New("no pinentry program or terminal to read the PIN from")
+)
+
+const (
+	// gpgErrCanceled and gpgErrTimeout are the GnuPG error codes of pinentry, in the low 16 bits of ERR.
+	// https://github.com/gpg/libgpg-error/blob/master/src/err-codes.h.in
+	gpgErrTimeout  = 62
+	gpgErrCanceled = 99
+	gpgErrCodeMask = 0xffff
+)
+
+// Pinentry asks for PINs with a GnuPG pinentry program, such as pinentry-mac or pinentry-gnome3,
+// which shows the system PIN dialog. It speaks the Assuan protocol of pinentry on the program's stdin
+// and stdout.
+// https://www.gnupg.org/documentation/manuals/assuan/
+type Pinentry struct {
+	// Program is the pinentry to run, found in PATH if it's not a path.
+	Program string
+	// Title is the title of the dialog.
+	Title string
+}
+
+// NewPinentry returns a Pinentry running program.
+func NewPinentry(program string) *Pinentry {
+	return &Pinentry{Program: program, Title: "piv-go"}
+}
+
+// GetPIN shows the dialog with the description and prompt and returns the PIN that was entered.
+// When ctx has a deadline the dialog closes itself then, and the program is killed when ctx is done.
+func (p *Pinentry) GetPIN(ctx context.Context, desc, prompt string) ([]byte, error) {
+	// nolint:gosec
+	cmd := exec.CommandContext(ctx, p.Program)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("pinentry stdin: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("pinentry stdout: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %w", p.Program, err)
+	}
+
+	pin, err := pinentryGetPIN(ctx, bufio.NewReader(stdout), stdin, p.commands(ctx, desc, prompt))
+
+	// BYE ends pinentry, closing its stdin ends it too if it didn't get that far.
+	_ = stdin.Close()
+	_ = cmd.Wait()
+
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPinentryTimeout, ctx.Err())
+	}
+
+	return pin, err
+}
+
+// commands are the options and texts sent to pinentry before GETPIN.
+func (p *Pinentry) commands(ctx context.Context, desc, prompt string) []string {
+	var cmds []string
+
+	// curses pinentries need the terminal, as gpg-agent tells them.
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		cmds = append(cmds, "OPTION ttyname="+tty)
+
+		if t := os.Getenv("TERM"); t != "" {
+			cmds = append(cmds, "OPTION ttytype="+t)
+		}
+	}
+
+	if p.Title != "" {
+		cmds = append(cmds, "SETTITLE "+assuanEscape(p.Title))
+	}
+
+	cmds = append(cmds, "SETDESC "+assuanEscape(desc), "SETPROMPT "+assuanEscape(prompt))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		seconds := int(math.Ceil(time.Until(deadline).Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+
+		cmds = append(cmds, "SETTIMEOUT "+strconv.Itoa(seconds))
+	}
+
+	return append(cmds, "GETPIN")
+}
+
+// pinentryGetPIN sends cmds to pinentry and returns the data of the last one, the PIN of GETPIN.
+func pinentryGetPIN(ctx context.Context, r *bufio.Reader, w io.Writer, cmds []string) ([]byte, error) {
+	// pinentry greets with OK.
+	if _, err := assuanResponse(r); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+
+	for _, cmd := range cmds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.WriteString(w, cmd+"\n"); err != nil {
+			return nil, fmt.Errorf("pinentry %s: %w", strings.Fields(cmd)[0], err)
+		}
+
+		var err error
+
+		data, err = assuanResponse(r)
+		if err != nil {
+			return nil, fmt.Errorf("pinentry %s: %w", strings.Fields(cmd)[0], err)
+		}
+	}
+
+	_, _ = io.WriteString(w, "BYE\n")
+
+	return data, nil
+}
+
+// assuanResponse reads the lines of a response up to OK or ERR and returns the data of its D lines.
+func assuanResponse(r *bufio.Reader) ([]byte, error) {
+	var data []byte
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "D "):
+			d, err := assuanUnescape(line[2:])
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, d...)
+		case strings.HasPrefix(line, "ERR "):
+			return nil, assuanError(line[4:])
+		}
+		// status and comment lines are ignored.
+	}
+}
+
+// assuanError returns the error of an ERR line, "code description".
+func assuanError(s string) error {
+	code, desc, _ := strings.Cut(s, " ")
+
+	n, err := strconv.ParseUint(code, 10, 32)
+	if err == nil {
+		switch n & gpgErrCodeMask {
+		case gpgErrCanceled:
+			return ErrPinentryCanceled
+		case gpgErrTimeout:
+			return ErrPinentryTimeout
+		}
+	}
+
+	return fmt.Errorf("pinentry error %s: %s", code, desc)
+}
+
+// assuanEscape escapes the characters that can't be in an Assuan line.
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// assuanUnescape decodes the %XX escapes of data lines.
+func assuanUnescape(s string) ([]byte, error) {
+	var b []byte
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+
+			continue
+		}
+
+		if i+2 >= len(s) {
+			return nil, fmt.Errorf("truncated escape %q", s[i:])
+		}
+
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape %q: %w", s[i:i+3], err)
+		}
+
+		b = append(b, c[0])
+		i += 2
+	}
+
+	return b, nil
+}
+
+// ReadPIN asks for pw of yk with the pinentry of the config, or from the terminal if there is no pinentry
+// or it can't be started. The dialog shows the serial of the card and the remaining tries.
+// When ctx is done before the PIN is entered ErrPinentryTimeout is returned.
+func (c *Config) ReadPIN(ctx context.Context, logger LogI, yk *piv.GPGYubiKey, pw piv.PW) ([]byte, error) {
+	logger = Nop(logger)
+
+	desc, prompt := pinDescription(yk, pw)
+
+	return c.ReadSecret(ctx, logger, desc, prompt)
+}
+
+// ReadSecret asks for a secret that isn't the current PIN of a card, such as a new PIN or the passphrase
+// of a key, with the pinentry of the config or from the terminal.
+func (c *Config) ReadSecret(ctx context.Context, logger LogI, desc, prompt string) ([]byte, error) {
+	logger = Nop(logger)
+
+	if c.Pinentry != "" {
+		pin, err := NewPinentry(c.Pinentry).GetPIN(ctx, desc, prompt)
+		if err == nil || !errors.Is(err, exec.ErrNotFound) && !errors.Is(err, os.ErrNotExist) {
+			return pin, err
+		}
+
+		logger.VerboseMsgf("pinentry [%s] not found, reading the PIN from the terminal: %v", c.Pinentry, err)
+	}
+
+	return readTerminalPIN(ctx, desc, prompt)
+}
+
+// pinDescription returns the text and prompt of the PIN dialog for pw, like gpg-agent's.
+func pinDescription(yk *piv.GPGYubiKey, pw piv.PW) (string, string) {
+	prompt := "PIN"
+	if pw == piv.PW3 {
+		prompt = "Admin PIN"
+	}
+
+	desc := "Please enter the " + prompt
+
+	if serial, err := yk.SerialString(); err == nil {
+		desc += " of the card with the serial number " + serial
+	}
+
+	if retries, err := yk.PINRetries(); err == nil {
+		tries := retries.PW1
+		if pw == piv.PW3 {
+			tries = retries.PW3
+		}
+
+		desc += fmt.Sprintf("\n\nAttempts remaining: %d", tries)
+	}
+
+	return desc, prompt
+}
+
+// readTerminalPIN reads the PIN from the terminal without echo, or returns ErrPinentryTimeout when ctx is done first.
+// The prompt is written to stderr, stdout may be the output of the command.
+func readTerminalPIN(ctx context.Context, desc, prompt string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, ErrNoPINInput
+	}
+
+	type result struct {
+		pin []byte
+		err error
+	}
+
+	fmt.Fprintln(os.Stderr, desc)
+	fmt.Fprint(os.Stderr, prompt+": ")
+
+	done := make(chan result, 1)
+
+	go func() {
+		pin, err := term.ReadPassword(int(os.Stdin.Fd()))
+		done <- result{pin, err}
+	}()
+
+	select {
+	case r := <-done:
+		// add a newline after reading.
+		fmt.Fprintln(os.Stderr)
+
+		if r.err != nil {
+			return nil, fmt.Errorf("failed to read PIN from terminal: %w", r.err)
+		}
+
+		return r.pin, nil
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+
+		return nil, fmt.Errorf("%w: %w", ErrPinentryTimeout, ctx.Err())
+	}
+}
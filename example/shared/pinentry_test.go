@@ -0,0 +1,107 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"golang.org/x/term"
+)
+
+func TestPinentryGetPIN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		responses string
+		want      string
+		wantErr   error
+	}{
+		{
+			name:      "pin",
+			responses: "OK Pleased to meet you\nOK\nOK\nS PASSWORD_FROM_CACHE\nD 12%2534\nOK\n",
+			want:      "12%34",
+		},
+		{
+			name:      "canceled",
+			responses: "OK Pleased to meet you\nOK\nOK\nERR 83886179 Operation cancelled <Pinentry>\n",
+			wantErr:   ErrPinentryCanceled,
+		},
+		{
+			name:      "timeout",
+			responses: "OK Pleased to meet you\nOK\nOK\nERR 83886142 Timeout <Pinentry>\n",
+			wantErr:   ErrPinentryTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var sent bytes.Buffer
+
+			cmds := []string{"SETDESC " + assuanEscape("Please enter the PIN\n\n100%"), "SETPROMPT PIN", "GETPIN"}
+
+			pin, err := pinentryGetPIN(context.Background(), bufio.NewReader(strings.NewReader(test.responses)), &sent, cmds)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v expected %v", err, test.wantErr)
+			}
+
+			if string(pin) != test.want {
+				t.Errorf("got PIN %q expected %q", pin, test.want)
+			}
+
+			if want := "SETDESC Please enter the PIN%0A%0A100%25\n"; !strings.HasPrefix(sent.String(), want) {
+				t.Errorf("sent %q expected it to start with %q", sent.String(), want)
+			}
+		})
+	}
+}
+
+func TestPinentryCommands(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmds := NewPinentry("pinentry").commands(ctx, "desc", "Admin PIN")
+
+	if cmds[len(cmds)-1] != "GETPIN" || cmds[len(cmds)-2] != "SETTIMEOUT 30" {
+		t.Errorf("got %q", cmds)
+	}
+}
+
+func TestConfigReadPINFallback(t *testing.T) {
+	t.Parallel()
+
+	if term.IsTerminal(0) {
+		t.Skip("stdin is a terminal")
+	}
+
+	yubikey := newTestEnvelopeKey(t, false)
+	cfg := &Config{Pinentry: "/nonexistent/pinentry"}
+
+	// without the pinentry the terminal is used, which the test doesn't have.
+	if _, err := cfg.ReadPIN(context.Background(), nil, yubikey.yk, piv.PW3); !errors.Is(err, ErrNoPINInput) {
+		t.Errorf("got %v expected %v", err, ErrNoPINInput)
+	}
+}
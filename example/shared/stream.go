@@ -0,0 +1,216 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrStreamTruncated is returned when a stream ends before its last chunk.
+	ErrStreamTruncated = errors.New("encrypted stream truncated")
+	// ErrStreamTooLarge is returned when the chunk counter of a stream would wrap.
+	ErrStreamTooLarge = errors.New("encrypted stream too large")
+)
+
+// The stream is the envelope header with the magic "PGS1", followed by:
+//
+//	7 bytes nonce prefix
+//	chunks, 4 bytes big endian length and the AES-256-GCM sealed chunk
+//
+// Every chunk but the last has streamChunkSize bytes of plain text. The nonce of a chunk
+// is the prefix, the 4 bytes big endian chunk number and 1 for the last chunk or 0, so chunks
+// can't be reordered, dropped or the stream cut at a chunk boundary. The header is the
+// additional data of every chunk.
+var streamMagic = []byte("PGS1")
+
+const (
+	streamNoncePrefixSize = 7
+	streamChunkSize       = 64 * 1024
+)
+
+// EncryptStream encrypts in for the decryption key of a card and writes the stream to out.
+// Only one chunk is held in memory, so in can be of any size.
+func EncryptStream(ctx context.Context, logger LogI, recipient crypto.PublicKey, in io.Reader, out io.Writer) error {
+	logger = Nop(logger)
+
+	kind, wrapped, aead, err := wrapEnvelopeKey(recipient)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, streamNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+
+	header := envelopeHeader(streamMagic, kind, wrapped)
+	if _, err := out.Write(append(append([]byte{}, header...), prefix...)); err != nil {
+		return fmt.Errorf("%w: failed to write stream header", err)
+	}
+
+	var (
+		reader = bufio.NewReader(in)
+		chunk  = make([]byte, streamChunkSize)
+		sealed []byte
+	)
+
+	for counter := uint32(0); ; counter++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: failed to read chunk [%d]", err, counter)
+		}
+
+		// a full chunk is the last one when nothing follows it.
+		last := n < len(chunk)
+		if !last {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			}
+		}
+
+		sealed = binary.BigEndian.AppendUint32(sealed[:0], 0)
+		sealed = aead.Seal(sealed, streamNonce(prefix, counter, last), chunk[:n], header)
+		binary.BigEndian.PutUint32(sealed, uint32(len(sealed)-4))
+
+		if _, err := out.Write(sealed); err != nil {
+			return fmt.Errorf("%w: failed to write chunk [%d]", err, counter)
+		}
+
+		if last {
+			logger.VerboseMsgf("Encrypted [%d] chunks", counter+1)
+
+			return nil
+		}
+
+		if counter == ^uint32(0) {
+			return ErrStreamTooLarge
+		}
+	}
+}
+
+// DecryptStream decrypts the output of EncryptStream with the card and writes the plain text to out.
+// Every chunk is authenticated before it is written, but when an error is returned out may hold
+// the chunks before it and has to be discarded.
+func DecryptStream(ctx context.Context, logger LogI, yubikey GPGWrapper, in io.Reader, out io.Writer) error {
+	logger = Nop(logger)
+
+	reader := bufio.NewReader(in)
+
+	header, err := readStreamHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	kind, wrapped := header[len(streamMagic)], header[len(streamMagic)+3:]
+
+	aead, err := unwrapEnvelopeKey(ctx, logger, yubikey, kind, wrapped)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, streamNoncePrefixSize)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return fmt.Errorf("%w: %w", ErrStreamTruncated, err)
+	}
+
+	var (
+		length [4]byte
+		sealed []byte
+		plain  []byte
+	)
+
+	for counter := uint32(0); ; counter++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return fmt.Errorf("%w: chunk [%d]: %w", ErrStreamTruncated, counter, err)
+		}
+
+		size := binary.BigEndian.Uint32(length[:])
+		if size > uint32(streamChunkSize+aead.Overhead()) {
+			return fmt.Errorf("%w: chunk [%d] is [%d] bytes", ErrNotAnEnvelope, counter, size)
+		}
+
+		sealed = append(sealed[:0], make([]byte, size)...)
+		if _, err := io.ReadFull(reader, sealed); err != nil {
+			return fmt.Errorf("%w: chunk [%d]: %w", ErrStreamTruncated, counter, err)
+		}
+
+		// the chunk is either the last one or not, try the flag that matches what follows it.
+		_, peekErr := reader.Peek(1)
+		last := errors.Is(peekErr, io.EOF)
+
+		plain, err = aead.Open(plain[:0], streamNonce(prefix, counter, last), sealed, header)
+		if err != nil {
+			if last {
+				err = fmt.Errorf("%w: %w", ErrStreamTruncated, err)
+			}
+
+			return fmt.Errorf("failed to decrypt chunk [%d], likely malformed input: %w", counter, err)
+		}
+
+		if _, err := out.Write(plain); err != nil {
+			return fmt.Errorf("%w: failed to write chunk [%d]", err, counter)
+		}
+
+		if last {
+			logger.VerboseMsgf("Decrypted [%d] chunks", counter+1)
+
+			return nil
+		}
+	}
+}
+
+// readStreamHeader reads the magic, key kind and wrapped key of a stream.
+func readStreamHeader(reader *bufio.Reader) ([]byte, error) {
+	// magic, kind and the length of the wrapped key.
+	header := make([]byte, len(streamMagic)+3)
+
+	if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header[:len(streamMagic)], streamMagic) {
+		return nil, ErrNotAnEnvelope
+	}
+
+	wrapped := make([]byte, binary.BigEndian.Uint16(header[len(streamMagic)+1:]))
+	if _, err := io.ReadFull(reader, wrapped); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStreamTruncated, err)
+	}
+
+	return append(header, wrapped...), nil
+}
+
+// streamNonce is the nonce of chunk counter.
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := binary.BigEndian.AppendUint32(append([]byte{}, prefix...), counter)
+	if last {
+		return append(nonce, 1)
+	}
+
+	return append(nonce, 0)
+}
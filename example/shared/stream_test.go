@@ -0,0 +1,108 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	yubikey := newTestEnvelopeKey(t, false)
+
+	recipient, err := yubikey.ReadPublicKey(ctx, nil, piv.AsymmetricConfidentiality)
+	if err != nil {
+		t.Fatalf("read public key: %v", err)
+	}
+
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3 * streamChunkSize} {
+		plainText := make([]byte, size)
+		if _, err := rand.Read(plainText); err != nil {
+			t.Fatal(err)
+		}
+
+		var encrypted, decrypted bytes.Buffer
+
+		if err := EncryptStream(ctx, nil, recipient, bytes.NewReader(plainText), &encrypted); err != nil {
+			t.Fatalf("EncryptStream(%d bytes): %v", size, err)
+		}
+
+		if err := DecryptStream(ctx, nil, yubikey, bytes.NewReader(encrypted.Bytes()), &decrypted); err != nil {
+			t.Fatalf("DecryptStream(%d bytes): %v", size, err)
+		}
+
+		if !bytes.Equal(decrypted.Bytes(), plainText) {
+			t.Errorf("DecryptStream(%d bytes) differs from the plain text", size)
+		}
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	yubikey := newTestEnvelopeKey(t, false)
+
+	recipient, err := yubikey.ReadPublicKey(ctx, nil, piv.AsymmetricConfidentiality)
+	if err != nil {
+		t.Fatalf("read public key: %v", err)
+	}
+
+	var encrypted bytes.Buffer
+
+	if err := EncryptStream(ctx, nil, recipient, bytes.NewReader(make([]byte, 2*streamChunkSize+10)), &encrypted); err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+
+	stream := encrypted.Bytes()
+	// header, nonce prefix, then the first chunk.
+	headerSize := len(stream) - 2*(4+streamChunkSize+16) - (4 + 10 + 16)
+
+	flipped := append([]byte{}, stream...)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := []struct {
+		name      string
+		stream    []byte
+		expectErr error
+	}{
+		{name: "cut at a chunk boundary", stream: stream[:headerSize+4+streamChunkSize+16], expectErr: ErrStreamTruncated},
+		{name: "cut in a chunk", stream: stream[:len(stream)-5], expectErr: ErrStreamTruncated},
+		{name: "appended", stream: append(append([]byte{}, stream...), stream[headerSize:]...)},
+		{name: "flipped", stream: flipped},
+		{name: "not a stream", stream: []byte("hello"), expectErr: ErrNotAnEnvelope},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := DecryptStream(ctx, nil, yubikey, bytes.NewReader(tc.stream), &bytes.Buffer{})
+			if err == nil {
+				t.Fatalf("DecryptStream accepted a modified stream")
+			}
+
+			if tc.expectErr != nil && !errors.Is(err, tc.expectErr) {
+				t.Errorf("DecryptStream = %v, want %v", err, tc.expectErr)
+			}
+		})
+	}
+}
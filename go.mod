@@ -2,6 +2,16 @@ module github.com/areese/piv-go
 
 go 1.22.1
 
-require golang.org/x/term v0.10.0
+require (
+	github.com/ProtonMail/go-crypto v1.1.3
+	github.com/spf13/cobra v1.8.1
+	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
+)
 
-require golang.org/x/sys v0.10.0 // indirect
+require (
+	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
@@ -0,0 +1,133 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package age encrypts and decrypts files in the age v1 format (https://age-encryption.org/v1)
+// with keys on a YubiKey.
+//
+// The recipients and identities have the same shape as the ones of filippo.io/age, a Stanza has the
+// same fields as age.Stanza, so they can be adapted to the age ecosystem without this package depending on it.
+//
+//   - X25519Recipient and OpenPGPIdentity use the X25519 decryption key of the OpenPGP applet, files
+//     can be encrypted with `age -r age1...` using the String of the recipient.
+//   - P256Recipient and PIVIdentity use a P-256 key in a PIV slot with the piv-p256 stanza of
+//     age-plugin-yubikey, the String of the recipient is an age1yubikey1... recipient.
+//   - RSARecipient and OpenPGPIdentity use an RSA decryption key of the OpenPGP applet. age only has
+//     RSA-OAEP recipients for ssh-rsa keys and the card only removes PKCS#1 v1.5 padding, so its
+//     openpgp-rsa stanza is only understood by this package.
+//
+// The PIN has to be presented by the KeyAuth or OpenPGPKeyAuth the keys were opened with.
+package age
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// ErrIncorrectIdentity is returned by Identity.Unwrap when none of the stanzas are for the identity.
+	ErrIncorrectIdentity = errors.New("incorrect identity for recipient block")
+	// ErrNoIdentityMatch is returned by Decrypt when none of the identities can unwrap the file key.
+	ErrNoIdentityMatch = errors.New("no identity matched any of the recipients")
+	// ErrMalformed is returned for files and recipients that aren't valid age.
+	ErrMalformed = errors.New("malformed age file")
+)
+
+// fileKeySize is the size of the file key of age v1.
+const fileKeySize = 16
+
+// Stanza is a recipient stanza of the header, with the same fields as age.Stanza.
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// Recipient wraps the file key for one recipient, the same interface as age.Recipient.
+type Recipient interface {
+	Wrap(fileKey []byte) ([]*Stanza, error)
+}
+
+// Identity unwraps the file key from the stanzas of a header, the same interface as age.Identity.
+// It returns ErrIncorrectIdentity when none of the stanzas are for the identity.
+type Identity interface {
+	Unwrap(stanzas []*Stanza) ([]byte, error)
+}
+
+// Encrypt writes the age header for recipients to dst and returns a writer for the plain text.
+// The file is only complete once the writer is closed.
+func Encrypt(dst io.Writer, recipients ...Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients specified")
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(randReader, fileKey); err != nil {
+		return nil, err
+	}
+
+	h := &header{}
+
+	for _, r := range recipients {
+		stanzas, err := r.Wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+
+		h.recipients = append(h.recipients, stanzas...)
+	}
+
+	if err := h.marshal(fileKey, dst); err != nil {
+		return nil, err
+	}
+
+	return newPayloadWriter(fileKey, dst)
+}
+
+// Decrypt reads the age header from src, unwraps the file key with the first identity that
+// matches and returns a reader for the plain text.
+func Decrypt(src io.Reader, identities ...Identity) (io.Reader, error) {
+	if len(identities) == 0 {
+		return nil, errors.New("no identities specified")
+	}
+
+	h, payload, err := parseHeader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+
+	for _, id := range identities {
+		fileKey, err = id.Unwrap(h.recipients)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		break
+	}
+
+	if fileKey == nil {
+		return nil, ErrNoIdentityMatch
+	}
+
+	if err := h.verify(fileKey); err != nil {
+		return nil, err
+	}
+
+	return newPayloadReader(fileKey, payload)
+}
@@ -0,0 +1,359 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// softwareX25519 is an X25519 decrypter like the one of the OpenPGP applet, msg is the peer key.
+type softwareX25519 struct {
+	priv *ecdh.PrivateKey
+}
+
+func (s *softwareX25519) Public() crypto.PublicKey {
+	return s.priv.PublicKey()
+}
+
+func (s *softwareX25519) Decrypt(_ io.Reader, msg []byte, _ crypto.DecrypterOpts) ([]byte, error) {
+	peer, err := ecdh.X25519().NewPublicKey(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.priv.ECDH(peer)
+}
+
+func newX25519Identity(t *testing.T) *OpenPGPIdentity {
+	t.Helper()
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := NewOpenPGPIdentity(&softwareX25519{priv: priv})
+	if err != nil {
+		t.Fatalf("NewOpenPGPIdentity: %v", err)
+	}
+
+	return id
+}
+
+func newOpenPGPRSAIdentity(t *testing.T) *OpenPGPIdentity {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := yk.ImportOpenPGPKey(piv.DecryptionKey, key); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	decrypter, err := yk.OpenPGPDecrypter(piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatalf("decrypter: %v", err)
+	}
+
+	id, err := NewOpenPGPIdentity(decrypter)
+	if err != nil {
+		t.Fatalf("NewOpenPGPIdentity: %v", err)
+	}
+
+	return id
+}
+
+func newPIVIdentity(t *testing.T) *PIVIdentity {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotKeyManagement, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyOnce,
+		TouchPolicy: piv.TouchPolicyNever,
+	})
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	priv, err := yk.PrivateKey(piv.SlotKeyManagement, pub, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+	if err != nil {
+		t.Fatalf("private key: %v", err)
+	}
+
+	id, err := NewPIVIdentity(priv.(*piv.ECDSAPrivateKey))
+	if err != nil {
+		t.Fatalf("NewPIVIdentity: %v", err)
+	}
+
+	return id
+}
+
+func encrypt(t *testing.T, plainText []byte, recipients ...Recipient) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w, err := Encrypt(&buf, recipients...)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	if _, err := w.Write(plainText); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func decrypt(file []byte, identities ...Identity) ([]byte, error) {
+	r, err := Decrypt(bytes.NewReader(file), identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	x25519 := newX25519Identity(t)
+	openPGPRSA := newOpenPGPRSAIdentity(t)
+	pivP256 := newPIVIdentity(t)
+
+	tests := []struct {
+		name      string
+		recipient Recipient
+		identity  Identity
+	}{
+		{name: "x25519", recipient: x25519.Recipient(), identity: x25519},
+		{name: "openpgp rsa", recipient: openPGPRSA.Recipient(), identity: openPGPRSA},
+		{name: "piv-p256", recipient: pivP256.Recipient(), identity: pivP256},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, size := range []int{0, 1, chunkSize, chunkSize + 1, 2*chunkSize + 5} {
+				plainText := make([]byte, size)
+				if _, err := rand.Read(plainText); err != nil {
+					t.Fatal(err)
+				}
+
+				file := encrypt(t, plainText, tc.recipient)
+				if !bytes.HasPrefix(file, []byte(intro)) {
+					t.Fatalf("file doesn't start with %q", intro)
+				}
+
+				got, err := decrypt(file, tc.identity)
+				if err != nil {
+					t.Fatalf("decrypt %d bytes: %v", size, err)
+				}
+
+				if !bytes.Equal(got, plainText) {
+					t.Errorf("decrypt %d bytes differs from the plain text", size)
+				}
+			}
+		})
+	}
+
+	// every identity finds its stanza in a file for all of them.
+	file := encrypt(t, []byte("hello"), x25519.Recipient(), openPGPRSA.Recipient(), pivP256.Recipient())
+
+	for _, tc := range tests {
+		if got, err := decrypt(file, tc.identity); err != nil || string(got) != "hello" {
+			t.Errorf("%s: decrypt = %q, %v", tc.name, got, err)
+		}
+	}
+
+	if _, err := decrypt(encrypt(t, []byte("hello"), x25519.Recipient()), pivP256, newX25519Identity(t)); !errors.Is(err, ErrNoIdentityMatch) {
+		t.Errorf("decrypt with other identities = %v, want ErrNoIdentityMatch", err)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	t.Parallel()
+
+	id := newX25519Identity(t)
+	file := encrypt(t, make([]byte, chunkSize+10), id.Recipient())
+	footer := bytes.Index(file, []byte("\n"+footerStart)) + 1
+	payload := bytes.IndexByte(file[footer:], '\n') + footer + 1
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{name: "header", file: flip(file, len(intro)+5)},
+		{name: "mac", file: flip(file, footer+len(footerStart)+2)},
+		{name: "payload", file: flip(file, len(file)-1)},
+		{name: "truncated at chunk", file: file[:payload+payloadNonceSize+chunkSize+16]},
+		{name: "truncated", file: file[:len(file)-1]},
+		{name: "appended", file: append(append([]byte{}, file...), 0)},
+		{name: "not age", file: []byte("hello\n")},
+	}
+
+	for _, tc := range tests {
+		if _, err := decrypt(tc.file, id); err == nil {
+			t.Errorf("%s: decrypt accepted a modified file", tc.name)
+		}
+	}
+}
+
+func flip(b []byte, i int) []byte {
+	b = append([]byte{}, b...)
+	b[i] ^= 1
+
+	return b
+}
+
+func TestStanzaBody(t *testing.T) {
+	t.Parallel()
+
+	// bodies of a multiple of 48 bytes end with an empty line.
+	for _, size := range []int{0, 16, 47, 48, 96, 100} {
+		s := &Stanza{Type: "test", Args: []string{"a", "b"}, Body: bytes.Repeat([]byte{0xaa}, size)}
+		h := &header{recipients: []*Stanza{s}}
+
+		var buf bytes.Buffer
+		if err := h.marshal(make([]byte, fileKeySize), &buf); err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+
+		got, _, err := parseHeader(&buf)
+		if err != nil {
+			t.Fatalf("parseHeader(%d byte body): %v", size, err)
+		}
+
+		if err := got.verify(make([]byte, fileKeySize)); err != nil {
+			t.Errorf("verify(%d byte body): %v", size, err)
+		}
+
+		if len(got.recipients) != 1 || !bytes.Equal(got.recipients[0].Body, s.Body) || strings.Join(got.recipients[0].Args, " ") != "a b" {
+			t.Errorf("parseHeader(%d byte body) = %+v", size, got.recipients)
+		}
+	}
+}
+
+func TestRecipientStrings(t *testing.T) {
+	t.Parallel()
+
+	x25519 := newX25519Identity(t).Recipient().(*X25519Recipient)
+
+	parsed, err := ParseX25519Recipient(x25519.String())
+	if err != nil || !parsed.pub.Equal(x25519.pub) {
+		t.Errorf("ParseX25519Recipient(%s) = %v", x25519, err)
+	}
+
+	if !strings.HasPrefix(x25519.String(), "age1") {
+		t.Errorf("X25519 recipient %s doesn't start with age1", x25519)
+	}
+
+	p256 := newPIVIdentity(t).Recipient()
+
+	parsedP256, err := ParseP256Recipient(p256.String())
+	if err != nil || !parsedP256.pub.Equal(p256.pub) {
+		t.Errorf("ParseP256Recipient(%s) = %v", p256, err)
+	}
+
+	if !strings.HasPrefix(p256.String(), "age1yubikey1") {
+		t.Errorf("P-256 recipient %s doesn't start with age1yubikey1", p256)
+	}
+
+	if _, err := ParseX25519Recipient(p256.String()); err == nil {
+		t.Errorf("ParseX25519Recipient accepted %s", p256)
+	}
+}
+
+func TestX25519RecipientKnownAnswer(t *testing.T) {
+	t.Parallel()
+
+	// the identity of the age testkit, https://c2sp.org/CCTV/age, the X25519 key 0x42 repeated.
+	_, secret, err := bech32Decode("AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX")
+	if err != nil {
+		t.Fatalf("bech32Decode: %v", err)
+	}
+
+	priv, err := ecdh.X25519().NewPrivateKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewX25519Recipient(priv.PublicKey())
+	if err != nil {
+		t.Fatalf("NewX25519Recipient: %v", err)
+	}
+
+	if got, want := r.String(), "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+func TestBech32(t *testing.T) {
+	t.Parallel()
+
+	// https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki#test-vectors
+	for _, s := range []string{"A12UEL5L", "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw"} {
+		hrp, data, err := bech32Decode(s)
+		if err != nil {
+			t.Errorf("bech32Decode(%s): %v", s, err)
+
+			continue
+		}
+
+		if got, err := bech32Encode(hrp, data); err != nil || got != strings.ToLower(s) {
+			t.Errorf("bech32Encode = %s, %v, want %s", got, err, strings.ToLower(s))
+		}
+	}
+
+	for _, s := range []string{"A12UEL5l", "a12uel5m", "pzry9x0s0muk", "1pzry9x0s0muk"} {
+		if _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("bech32Decode(%s) accepted an invalid string", s)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bech32 encodes the recipients, https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+// without the 90 character limit, like age.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+
+		for i, g := range bech32Generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	v := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range []byte(hrp) {
+		v = append(v, c>>5)
+	}
+
+	v = append(v, 0)
+	for _, c := range []byte(hrp) {
+		v = append(v, c&31)
+	}
+
+	return v
+}
+
+// convertBits regroups data of fromBits bit groups into toBits bit groups.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var (
+		acc  uint32
+		bits uint
+		out  []byte
+	)
+
+	maxv := byte(1<<toBits - 1)
+
+	for _, b := range data {
+		if b>>fromBits != 0 {
+			return nil, fmt.Errorf("%w: invalid data byte %#x", ErrMalformed, b)
+		}
+
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits)&maxv)
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits))&maxv)
+		}
+	} else if bits >= fromBits || byte(acc<<(toBits-bits))&maxv != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrMalformed)
+	}
+
+	return out, nil
+}
+
+// bech32Encode returns the lower case bech32 string of data.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	hrp = strings.ToLower(hrp)
+	poly := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var b strings.Builder
+
+	b.WriteString(hrp + "1")
+
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[(poly>>uint(5*(5-i)))&31])
+	}
+
+	return b.String(), nil
+}
+
+// bech32Decode returns the human readable part and the data of s.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, fmt.Errorf("%w: mixed case", ErrMalformed)
+	}
+
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, fmt.Errorf("%w: separator '1' at invalid position", ErrMalformed)
+	}
+
+	hrp := s[:pos]
+
+	values := make([]byte, 0, len(s)-pos-1)
+
+	for _, c := range []byte(s[pos+1:]) {
+		v := strings.IndexByte(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("%w: invalid character %q", ErrMalformed, c)
+		}
+
+		values = append(values, byte(v))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, fmt.Errorf("%w: invalid checksum", ErrMalformed)
+	}
+
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return hrp, data, nil
+}
@@ -0,0 +1,464 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// randReader is the source of file keys, nonces and ephemeral keys.
+var randReader = rand.Reader
+
+const (
+	intro       = "age-encryption.org/v1\n"
+	stanzaStart = "->"
+	footerStart = "---"
+	// columnsPerLine is the width of the base64 stanza bodies.
+	columnsPerLine = 64
+
+	payloadNonceSize = 16
+	chunkSize        = 64 * 1024
+)
+
+var b64 = base64.RawStdEncoding.Strict()
+
+// header is the age header, the recipient stanzas and the MAC.
+type header struct {
+	recipients []*Stanza
+	mac        []byte
+	// raw is the header up to and including "---", what the MAC is computed over.
+	raw []byte
+}
+
+func headerMAC(fileKey, raw []byte) ([]byte, error) {
+	key, err := deriveKey(fileKey, nil, "header", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(raw)
+
+	return h.Sum(nil), nil
+}
+
+// marshal writes the header with its MAC.
+func (h *header) marshal(fileKey []byte, w io.Writer) error {
+	var buf bytes.Buffer
+
+	buf.WriteString(intro)
+
+	for _, s := range h.recipients {
+		if err := s.marshal(&buf); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteString(footerStart)
+
+	mac, err := headerMAC(fileKey, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	buf.WriteString(" " + b64.EncodeToString(mac) + "\n")
+
+	_, err = w.Write(buf.Bytes())
+
+	return err
+}
+
+// verify checks the MAC of a parsed header.
+func (h *header) verify(fileKey []byte) error {
+	mac, err := headerMAC(fileKey, h.raw)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(mac, h.mac) {
+		return fmt.Errorf("%w: bad header MAC", ErrMalformed)
+	}
+
+	return nil
+}
+
+func (s *Stanza) marshal(w *bytes.Buffer) error {
+	if !validString(s.Type) {
+		return fmt.Errorf("%w: stanza type %q", ErrMalformed, s.Type)
+	}
+
+	w.WriteString(stanzaStart + " " + s.Type)
+
+	for _, a := range s.Args {
+		if !validString(a) {
+			return fmt.Errorf("%w: stanza argument %q", ErrMalformed, a)
+		}
+
+		w.WriteString(" " + a)
+	}
+
+	w.WriteString("\n")
+
+	// the body always ends with a line shorter than columnsPerLine, which may be empty.
+	body := b64.EncodeToString(s.Body)
+	for ; len(body) >= columnsPerLine; body = body[columnsPerLine:] {
+		w.WriteString(body[:columnsPerLine] + "\n")
+	}
+
+	w.WriteString(body + "\n")
+
+	return nil
+}
+
+// validString is true for the non-empty printable ASCII allowed in stanza types and arguments.
+func validString(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range []byte(s) {
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseHeader reads the header from src and returns the reader positioned at the payload.
+func parseHeader(src io.Reader) (*header, io.Reader, error) {
+	r := bufio.NewReader(src)
+	h := &header{}
+
+	line, err := r.ReadString('\n')
+	if err != nil || line != intro {
+		return nil, nil, fmt.Errorf("%w: unknown intro %q", ErrMalformed, line)
+	}
+
+	raw := []byte(line)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+
+		switch {
+		case strings.HasPrefix(line, footerStart+" "):
+			mac, err := b64.DecodeString(strings.TrimSuffix(line[len(footerStart)+1:], "\n"))
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, fmt.Errorf("%w: header MAC", ErrMalformed)
+			}
+
+			h.mac = mac
+			h.raw = append(raw, footerStart...)
+
+			return h, r, nil
+		case strings.HasPrefix(line, stanzaStart+" "):
+			raw = append(raw, line...)
+
+			fields := strings.Split(strings.TrimSuffix(line, "\n")[len(stanzaStart)+1:], " ")
+			for _, f := range fields {
+				if !validString(f) {
+					return nil, nil, fmt.Errorf("%w: stanza %q", ErrMalformed, line)
+				}
+			}
+
+			s := &Stanza{Type: fields[0], Args: fields[1:]}
+
+			if s.Body, raw, err = parseBody(r, raw); err != nil {
+				return nil, nil, err
+			}
+
+			h.recipients = append(h.recipients, s)
+		default:
+			return nil, nil, fmt.Errorf("%w: unexpected line %q", ErrMalformed, line)
+		}
+	}
+}
+
+// parseBody reads the base64 lines of a stanza body, the last one is shorter than columnsPerLine.
+func parseBody(r *bufio.Reader, raw []byte) ([]byte, []byte, error) {
+	var body []byte
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+
+		raw = append(raw, line...)
+		line = strings.TrimSuffix(line, "\n")
+
+		b, err := b64.DecodeString(line)
+		if err != nil || len(line) > columnsPerLine {
+			return nil, nil, fmt.Errorf("%w: stanza body", ErrMalformed)
+		}
+
+		body = append(body, b...)
+
+		if len(line) < columnsPerLine {
+			return body, raw, nil
+		}
+	}
+}
+
+// deriveKey is HKDF-SHA256.
+func deriveKey(secret, salt []byte, info string, size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// wrapFileKey seals the file key with ChaCha20-Poly1305 and a zero nonce, the key is only used once.
+func wrapFileKey(key, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+// unwrapFileKey opens the body of a stanza, a failure means the stanza is for another identity.
+func unwrapFileKey(key, body []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) != fileKeySize+aead.Overhead() {
+		return nil, fmt.Errorf("%w: stanza body is %d bytes", ErrMalformed, len(body))
+	}
+
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), body, nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+
+	return fileKey, nil
+}
+
+// payloadWriter encrypts the payload with the STREAM construction, 64 KiB chunks of ChaCha20-Poly1305.
+type payloadWriter struct {
+	w       io.Writer
+	payload *chunkCipher
+	buf     []byte
+	closed  bool
+}
+
+func newPayloadWriter(fileKey []byte, w io.Writer) (*payloadWriter, error) {
+	nonce := make([]byte, payloadNonceSize)
+	if _, err := io.ReadFull(randReader, nonce); err != nil {
+		return nil, err
+	}
+
+	c, err := newChunkCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(nonce); err != nil {
+		return nil, err
+	}
+
+	return &payloadWriter{w: w, payload: c, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (p *payloadWriter) Write(data []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("write on closed age writer")
+	}
+
+	n := 0
+
+	for len(data) > 0 {
+		// a full chunk is only written once more data follows, the last chunk is sealed by Close.
+		if len(p.buf) == chunkSize {
+			if err := p.flush(false); err != nil {
+				return n, err
+			}
+		}
+
+		c := copy(p.buf[len(p.buf):chunkSize], data)
+		p.buf = p.buf[:len(p.buf)+c]
+		data = data[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close seals the last chunk, it doesn't close the underlying writer.
+func (p *payloadWriter) Close() error {
+	if p.closed {
+		return nil
+	}
+
+	p.closed = true
+
+	return p.flush(true)
+}
+
+func (p *payloadWriter) flush(last bool) error {
+	sealed := p.payload.seal(p.buf, last)
+	p.buf = p.buf[:0]
+	_, err := p.w.Write(sealed)
+
+	return err
+}
+
+// payloadReader decrypts the payload chunk by chunk.
+type payloadReader struct {
+	r       *bufio.Reader
+	payload *chunkCipher
+	sealed  []byte
+	plain   []byte
+	err     error
+}
+
+func newPayloadReader(fileKey []byte, src io.Reader) (*payloadReader, error) {
+	r := bufio.NewReader(src)
+
+	nonce := make([]byte, payloadNonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("%w: payload nonce: %w", ErrMalformed, err)
+	}
+
+	c, err := newChunkCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payloadReader{r: r, payload: c, sealed: make([]byte, chunkSize+chacha20poly1305.Overhead)}, nil
+}
+
+func (p *payloadReader) Read(out []byte) (int, error) {
+	for len(p.plain) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+
+		p.err = p.readChunk()
+	}
+
+	n := copy(out, p.plain)
+	p.plain = p.plain[n:]
+
+	return n, nil
+}
+
+// readChunk decrypts the next chunk into plain, it returns io.EOF after the last chunk.
+func (p *payloadReader) readChunk() error {
+	n, err := io.ReadFull(p.r, p.sealed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		if errors.Is(err, io.EOF) {
+			// the previous chunk wasn't the last one.
+			return fmt.Errorf("%w: payload truncated", ErrMalformed)
+		}
+
+		return err
+	}
+
+	last := n < len(p.sealed)
+	if !last {
+		if _, err := p.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+
+	p.plain, err = p.payload.open(p.sealed[:n], last)
+	if err != nil {
+		return err
+	}
+
+	if last {
+		return io.EOF
+	}
+
+	return nil
+}
+
+// chunkCipher is the STREAM construction of age, the nonce of a chunk is its 11 byte
+// counter and 1 for the last chunk.
+type chunkCipher struct {
+	aead    cipher.AEAD
+	counter uint64
+}
+
+func newChunkCipher(fileKey, nonce []byte) (*chunkCipher, error) {
+	key, err := deriveKey(fileKey, nonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkCipher{aead: aead}, nil
+}
+
+func (c *chunkCipher) nonce(last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], c.counter)
+
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+func (c *chunkCipher) seal(plain []byte, last bool) []byte {
+	sealed := c.aead.Seal(nil, c.nonce(last), plain, nil)
+	c.counter++
+
+	return sealed
+}
+
+func (c *chunkCipher) open(sealed []byte, last bool) ([]byte, error) {
+	// only an empty payload has an empty last chunk.
+	if last && len(sealed) == c.aead.Overhead() && c.counter > 0 {
+		return nil, fmt.Errorf("%w: empty last chunk", ErrMalformed)
+	}
+
+	plain, err := c.aead.Open(nil, c.nonce(last), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload chunk %d: %w", ErrMalformed, c.counter, err)
+	}
+
+	c.counter++
+
+	return plain, nil
+}
@@ -0,0 +1,182 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+const (
+	rsaType = "openpgp-rsa"
+	// tagSize is the size of the key tags in stanza arguments.
+	tagSize = 4
+)
+
+// RSARecipient wraps the file key with PKCS#1 v1.5 for an RSA decryption key of the OpenPGP applet.
+// The stanza is "openpgp-rsa" with the tag of the key, only OpenPGPIdentity unwraps it.
+type RSARecipient struct {
+	pub *rsa.PublicKey
+	tag string
+}
+
+var _ Recipient = (*RSARecipient)(nil)
+
+// NewRSARecipient returns the recipient for pub.
+func NewRSARecipient(pub *rsa.PublicKey) (*RSARecipient, error) {
+	tag, err := rsaTag(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RSARecipient{pub: pub, tag: tag}, nil
+}
+
+// rsaTag is the first bytes of the SHA-256 of the PKIX encoding of pub.
+func rsaTag(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(der)
+
+	return b64.EncodeToString(h[:tagSize]), nil
+}
+
+// Wrap returns the openpgp-rsa stanza.
+func (r *RSARecipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	body, err := rsa.EncryptPKCS1v15(randReader, r.pub, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Stanza{{Type: rsaType, Args: []string{r.tag}, Body: body}}, nil
+}
+
+// OpenPGPIdentity unwraps X25519 stanzas with an X25519 decryption key, or openpgp-rsa stanzas
+// with an RSA decryption key, of the OpenPGP applet.
+type OpenPGPIdentity struct {
+	decrypter crypto.Decrypter
+	// x25519 is the public key of an X25519 decryption key.
+	x25519 *ecdh.PublicKey
+	// rsaTag is the tag of an RSA decryption key.
+	rsaTag string
+}
+
+var _ Identity = (*OpenPGPIdentity)(nil)
+
+// NewOpenPGPIdentity returns the identity of the decryption key, as returned by GPGYubiKey.OpenPGPDecrypter.
+func NewOpenPGPIdentity(decrypter crypto.Decrypter) (*OpenPGPIdentity, error) {
+	id := &OpenPGPIdentity{decrypter: decrypter}
+
+	switch pub := decrypter.Public().(type) {
+	case *ecdh.PublicKey:
+		if pub.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("%w: age needs an X25519 key", ErrMalformed)
+		}
+
+		id.x25519 = pub
+	case *rsa.PublicKey:
+		tag, err := rsaTag(pub)
+		if err != nil {
+			return nil, err
+		}
+
+		id.rsaTag = tag
+	default:
+		return nil, fmt.Errorf("%w: unsupported decryption key %T", ErrMalformed, pub)
+	}
+
+	return id, nil
+}
+
+// Recipient returns the recipient of the identity, an *X25519Recipient or an *RSARecipient.
+func (i *OpenPGPIdentity) Recipient() Recipient {
+	if i.x25519 != nil {
+		return &X25519Recipient{pub: i.x25519}
+	}
+
+	return &RSARecipient{pub: i.decrypter.Public().(*rsa.PublicKey), tag: i.rsaTag}
+}
+
+// Unwrap has the card unwrap the file key of the first matching stanza.
+// As X25519 stanzas don't say their recipient, every one of them costs a card operation.
+func (i *OpenPGPIdentity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		var (
+			fileKey []byte
+			err     error
+		)
+
+		switch {
+		case s.Type == x25519Type && i.x25519 != nil:
+			fileKey, err = i.unwrapX25519(s)
+		case s.Type == rsaType && i.rsaTag != "" && len(s.Args) == 1 && s.Args[0] == i.rsaTag:
+			fileKey, err = i.unwrapRSA(s)
+		default:
+			continue
+		}
+
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+
+		return fileKey, err
+	}
+
+	return nil, ErrIncorrectIdentity
+}
+
+func (i *OpenPGPIdentity) unwrapX25519(s *Stanza) ([]byte, error) {
+	if len(s.Args) != 1 {
+		return nil, fmt.Errorf("%w: X25519 stanza has %d arguments", ErrMalformed, len(s.Args))
+	}
+
+	share, err := b64.DecodeString(s.Args[0])
+	if err != nil || len(share) != 32 {
+		return nil, fmt.Errorf("%w: X25519 share", ErrMalformed)
+	}
+
+	secret, err := i.decrypter.Decrypt(randReader, share, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x25519Key(secret, share, i.x25519.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return unwrapFileKey(key, s.Body)
+}
+
+func (i *OpenPGPIdentity) unwrapRSA(s *Stanza) ([]byte, error) {
+	fileKey, err := i.decrypter.Decrypt(randReader, s.Body, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fileKey) != fileKeySize {
+		return nil, fmt.Errorf("%w: file key is %d bytes", ErrMalformed, len(fileKey))
+	}
+
+	return fileKey, nil
+}
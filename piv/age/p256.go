@@ -0,0 +1,206 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// The piv-p256 stanza of age-plugin-yubikey, https://github.com/str4d/age-plugin-yubikey.
+const (
+	p256Type  = "piv-p256"
+	p256Label = "piv-p256"
+	p256HRP   = "age1yubikey"
+)
+
+// P256Recipient wraps the file key for a P-256 key in a PIV slot, like age-plugin-yubikey.
+type P256Recipient struct {
+	pub *ecdsa.PublicKey
+}
+
+var _ Recipient = (*P256Recipient)(nil)
+
+// NewP256Recipient returns the recipient for pub, which must be a P-256 key.
+func NewP256Recipient(pub *ecdsa.PublicKey) (*P256Recipient, error) {
+	if pub == nil || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: not a P-256 key", ErrMalformed)
+	}
+
+	return &P256Recipient{pub: pub}, nil
+}
+
+// ParseP256Recipient parses an age1yubikey1... recipient.
+func ParseP256Recipient(s string) (*P256Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if hrp != p256HRP {
+		return nil, fmt.Errorf("%w: %q isn't an age1yubikey recipient", ErrMalformed, s)
+	}
+
+	pub, err := decompressP256(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &P256Recipient{pub: pub}, nil
+}
+
+// String returns the age1yubikey1... encoding of the recipient.
+func (r *P256Recipient) String() string {
+	s, _ := bech32Encode(p256HRP, elliptic.MarshalCompressed(r.pub.Curve, r.pub.X, r.pub.Y))
+
+	return s
+}
+
+// Wrap returns the piv-p256 stanza with the tag of the recipient and the compressed ephemeral key.
+func (r *P256Recipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	recipient, err := r.pub.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.P256().GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	epk := compressP256(ephemeral.PublicKey())
+
+	pk := elliptic.MarshalCompressed(r.pub.Curve, r.pub.X, r.pub.Y)
+
+	key, err := deriveKey(secret, append(append([]byte{}, epk...), pk...), p256Label, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := wrapFileKey(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Stanza{{Type: p256Type, Args: []string{p256Tag(pk), b64.EncodeToString(epk)}, Body: body}}, nil
+}
+
+// p256Tag is the first bytes of the SHA-256 of the compressed key.
+func p256Tag(compressed []byte) string {
+	h := sha256.Sum256(compressed)
+
+	return b64.EncodeToString(h[:tagSize])
+}
+
+func compressP256(pub *ecdh.PublicKey) []byte {
+	// the ecdh encoding is uncompressed, 04 || X || Y, the prefix of the compressed point is 02 or 03 for the parity of Y.
+	b := pub.Bytes()
+
+	return append([]byte{2 | b[len(b)-1]&1}, b[1:33]...)
+}
+
+func decompressP256(data []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), data)
+	if x == nil {
+		return nil, fmt.Errorf("%w: invalid compressed P-256 point", ErrMalformed)
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// SharedKeyer is a P-256 key that computes ECDH shared secrets, like *piv.ECDSAPrivateKey
+// returned by YubiKey.PrivateKey for a key in a PIV slot.
+type SharedKeyer interface {
+	Public() crypto.PublicKey
+	SharedKey(peer *ecdsa.PublicKey) ([]byte, error)
+}
+
+// PIVIdentity unwraps the piv-p256 stanzas of a P-256 key in a PIV slot.
+type PIVIdentity struct {
+	key SharedKeyer
+	pk  []byte
+	tag string
+}
+
+var _ Identity = (*PIVIdentity)(nil)
+
+// NewPIVIdentity returns the identity of key.
+func NewPIVIdentity(key SharedKeyer) (*PIVIdentity, error) {
+	pub, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: age-plugin-yubikey needs a P-256 key", ErrMalformed)
+	}
+
+	pk := elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)
+
+	return &PIVIdentity{key: key, pk: pk, tag: p256Tag(pk)}, nil
+}
+
+// Recipient returns the recipient of the identity.
+func (i *PIVIdentity) Recipient() *P256Recipient {
+	return &P256Recipient{pub: i.key.Public().(*ecdsa.PublicKey)}
+}
+
+// Unwrap has the card unwrap the file key of the piv-p256 stanza with the tag of the key.
+func (i *PIVIdentity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != p256Type || len(s.Args) != 2 || s.Args[0] != i.tag {
+			continue
+		}
+
+		fileKey, err := i.unwrap(s)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+
+		return fileKey, err
+	}
+
+	return nil, ErrIncorrectIdentity
+}
+
+func (i *PIVIdentity) unwrap(s *Stanza) ([]byte, error) {
+	epk, err := b64.DecodeString(s.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: piv-p256 ephemeral key", ErrMalformed)
+	}
+
+	peer, err := decompressP256(epk)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := i.key.SharedKey(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(secret, append(append([]byte{}, epk...), i.pk...), p256Label, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return unwrapFileKey(key, s.Body)
+}
@@ -0,0 +1,103 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"crypto/ecdh"
+	"fmt"
+)
+
+const (
+	x25519Type  = "X25519"
+	x25519Label = "age-encryption.org/v1/X25519"
+	x25519HRP   = "age"
+)
+
+// X25519Recipient is the native age recipient for an X25519 key, e.g. the decryption key of the OpenPGP applet.
+type X25519Recipient struct {
+	pub *ecdh.PublicKey
+}
+
+var _ Recipient = (*X25519Recipient)(nil)
+
+// NewX25519Recipient returns the recipient for pub, which must be an X25519 key.
+func NewX25519Recipient(pub *ecdh.PublicKey) (*X25519Recipient, error) {
+	if pub == nil || pub.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%w: not an X25519 key", ErrMalformed)
+	}
+
+	return &X25519Recipient{pub: pub}, nil
+}
+
+// ParseX25519Recipient parses an age1... recipient.
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if hrp != x25519HRP {
+		return nil, fmt.Errorf("%w: %q isn't an X25519 recipient", ErrMalformed, s)
+	}
+
+	pub, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	return &X25519Recipient{pub: pub}, nil
+}
+
+// String returns the age1... encoding of the recipient.
+func (r *X25519Recipient) String() string {
+	s, _ := bech32Encode(x25519HRP, r.pub.Bytes())
+
+	return s
+}
+
+// Wrap returns the X25519 stanza with the file key wrapped for the recipient.
+func (r *X25519Recipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeral.ECDH(r.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	share := ephemeral.PublicKey().Bytes()
+
+	body, err := x25519Wrap(secret, share, r.pub.Bytes(), fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Stanza{{Type: x25519Type, Args: []string{b64.EncodeToString(share)}, Body: body}}, nil
+}
+
+func x25519Key(secret, share, recipient []byte) ([]byte, error) {
+	return deriveKey(secret, append(append([]byte{}, share...), recipient...), x25519Label, 32)
+}
+
+func x25519Wrap(secret, share, recipient, fileKey []byte) ([]byte, error) {
+	key, err := x25519Key(secret, share, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapFileKey(key, fileKey)
+}
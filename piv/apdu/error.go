@@ -0,0 +1,155 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apdu has the errors returned by smart cards in the status words of a response APDU.
+//
+// Card errors from the piv package wrap an *Error, so the status words are available with errors.As
+// and the common cases with errors.Is:
+//
+//	var e *apdu.Error
+//	if errors.As(err, &e) {
+//		fmt.Printf("SW1=%02x SW2=%02x\n", e.SW1, e.SW2)
+//	}
+//
+//	var wrong apdu.ErrWrongPIN
+//	if errors.As(err, &wrong) {
+//		fmt.Printf("%d retries left\n", wrong.RetriesLeft)
+//	}
+package apdu
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDataNotFound is returned for 6A82, the data object, file or application isn't on the card.
+	ErrDataNotFound = errors.New("data object or application not found")
+	// ErrReferenceNotFound is returned for 6A88, the key, PIN or other data referenced by P1-P2 isn't on the card.
+	// It's not ErrDataNotFound: a wrong reference is a bad command rather than an empty slot.
+	ErrReferenceNotFound = errors.New("referenced data not found")
+	// ErrSecurityStatusNotSatisfied is returned for 6982, the PIN or touch required by the command wasn't presented.
+	ErrSecurityStatusNotSatisfied = errors.New("security status not satisfied")
+	// ErrConditionsNotSatisfied is returned for 6985, the command can't be used in the current state of the card.
+	ErrConditionsNotSatisfied = errors.New("conditions of use not satisfied")
+	// ErrPINBlocked is returned for 6983, the error counter of the PIN is zero.
+	ErrPINBlocked = errors.New("pin blocked")
+)
+
+// ErrWrongPIN is returned for 63Cx when a PIN, PUK or reset code was rejected.
+// RetriesLeft is the value of the error counter reported by the card.
+type ErrWrongPIN struct {
+	RetriesLeft int
+}
+
+func (e ErrWrongPIN) Error() string {
+	r := "retries"
+	if e.RetriesLeft == 1 {
+		r = "retry"
+	}
+
+	return fmt.Sprintf("verification failed (%d %s remaining)", e.RetriesLeft, r)
+}
+
+// Error is a response APDU with a status other than 9000.
+// ISO/IEC 7816-4 5.1.3 Status bytes.
+type Error struct {
+	SW1 byte
+	SW2 byte
+}
+
+// Status returns the status word, SW1 and SW2 as one number.
+func (e *Error) Status() uint16 {
+	return uint16(e.SW1)<<8 | uint16(e.SW2)
+}
+
+func (e *Error) Error() string {
+	var msg string
+
+	switch st := e.Status(); {
+	case e.SW1 == 0x61:
+		msg = fmt.Sprintf("0x%02x bytes available", e.SW2)
+	case st == 0x6983:
+		// The PIN was blocked rather than a failed verification.
+		msg = "authentication method blocked"
+	case st&0xfff0 == 0x63c0, st&0xfff0 == 0x6300:
+		msg = ErrWrongPIN{int(e.SW2 & 0x0f)}.Error()
+	default:
+		msg = messages[st]
+	}
+
+	if msg != "" {
+		msg = ": " + msg
+	}
+
+	return fmt.Sprintf("smart card error %04x%s", e.Status(), msg)
+}
+
+// Unwrap returns the sentinel for the status, if there is one.
+func (e *Error) Unwrap() []error {
+	st := e.Status()
+
+	switch {
+	case st == 0x6a82:
+		return []error{ErrDataNotFound}
+	case st == 0x6a88:
+		return []error{ErrReferenceNotFound}
+	case st == 0x6982:
+		return []error{ErrSecurityStatusNotSatisfied}
+	case st == 0x6983:
+		return []error{ErrPINBlocked, ErrWrongPIN{0}}
+	case st == 0x6985:
+		return []error{ErrConditionsNotSatisfied}
+	case st&0xfff0 == 0x63c0:
+		return []error{ErrWrongPIN{int(st & 0x0f)}}
+	case st&0xfff0 == 0x6300:
+		// Older YubiKeys sometimes return sw1=0x63 and sw2=0x0N to indicate the
+		// number of retries. This isn't spec compliant, but support it anyway.
+		//
+		// https://github.com/areese/piv-go/issues/60
+		return []error{ErrWrongPIN{int(st & 0x0f)}}
+	}
+
+	return nil
+}
+
+// messages are the descriptions of the status words.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf 7.1 Status Bytes.
+var messages = map[uint16]string{
+	0x6581: "decryption failed",
+	0x6600: "security-related issues (reserved for UIF in this application)",
+	0x6700: "wrong length (Lc and/or Le)",
+	0x6881: "logical channel not supported",
+	0x6882: "secure messaging not supported",
+	0x6883: "last command of the chain expected",
+	0x6884: "command chaining not supported",
+	// PW wrong, PW not checked (command not allowed) or secure messaging incorrect (checksum and/or cryptogram).
+	0x6982: "security status not satisfied",
+	0x6985: "Condition of use not satisfied",
+	// e.g. SM-key.
+	0x6987: "expected secure messaging data objects are missing",
+	// e.g. wrong TLV-structure in command data.
+	0x6988: "secure messaging data objects are incorrect",
+	0x6a80: "incorrect parameter in command data field",
+	0x6a81: "function not supported",
+	0x6a82: "data object or application not found",
+	0x6a84: "not enough memory",
+	0x6a86: "incorrect parameter in P1 or P2",
+	0x6a88: "referenced data or reference data not found",
+	0x6b00: "Wrong parameters P1-P2",
+	0x6d00: "Instruction code (INS) not supported or invalid",
+	0x6e00: "Class (CLA) not supported",
+	0x6f00: "No precise diagnosis",
+	0x9000: "Command correct",
+}
@@ -0,0 +1,67 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apdu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *Error
+		is     error
+		wrong  int
+		errMsg string
+	}{
+		{name: "not found", err: &Error{0x6a, 0x82}, is: ErrDataNotFound, wrong: -1, errMsg: "smart card error 6a82: data object or application not found"},
+		{name: "referenced data", err: &Error{0x6a, 0x88}, is: ErrReferenceNotFound, wrong: -1, errMsg: "smart card error 6a88: referenced data or reference data not found"},
+		{name: "security status", err: &Error{0x69, 0x82}, is: ErrSecurityStatusNotSatisfied, wrong: -1, errMsg: "smart card error 6982: security status not satisfied"},
+		{name: "conditions", err: &Error{0x69, 0x85}, is: ErrConditionsNotSatisfied, wrong: -1, errMsg: "smart card error 6985: Condition of use not satisfied"},
+		{name: "blocked", err: &Error{0x69, 0x83}, is: ErrPINBlocked, wrong: 0, errMsg: "smart card error 6983: authentication method blocked"},
+		{name: "wrong pin", err: &Error{0x63, 0xc2}, wrong: 2, errMsg: "smart card error 63c2: verification failed (2 retries remaining)"},
+		{name: "old yubikey", err: &Error{0x63, 0x01}, wrong: 1, errMsg: "smart card error 6301: verification failed (1 retry remaining)"},
+		{name: "bytes available", err: &Error{0x61, 0x10}, wrong: -1, errMsg: "smart card error 6110: 0x10 bytes available"},
+		{name: "unknown", err: &Error{0x6c, 0x00}, wrong: -1, errMsg: "smart card error 6c00"},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tc.err.Error(); got != tc.errMsg {
+				t.Errorf("Error() = %q, want %q", got, tc.errMsg)
+			}
+
+			if tc.is != nil && !errors.Is(tc.err, tc.is) {
+				t.Errorf("%v should be %v", tc.err, tc.is)
+			}
+
+			if tc.is != ErrDataNotFound && errors.Is(tc.err, ErrDataNotFound) {
+				t.Errorf("%v shouldn't be %v", tc.err, ErrDataNotFound)
+			}
+
+			var wrong ErrWrongPIN
+			if got := errors.As(tc.err, &wrong); got != (tc.wrong >= 0) {
+				t.Errorf("errors.As(ErrWrongPIN) = %t", got)
+			} else if got && wrong.RetriesLeft != tc.wrong {
+				t.Errorf("RetriesLeft = %d, want %d", wrong.RetriesLeft, tc.wrong)
+			}
+		})
+	}
+}
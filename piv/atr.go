@@ -0,0 +1,322 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The ATR (Answer To Reset) is available from PC/SC without connecting to the card, so it can be used to
+// pick the right card before opening it.
+// ISO/IEC 7816-3 8.2 Answer-to-Reset.
+// Known ATRs are collected at https://smartcard-atr.apdu.fr/
+
+const (
+	atrDirectConvention  = 0x3b
+	atrInverseConvention = 0x3f
+)
+
+var (
+	// ErrATRMalformed is returned when an ATR is too short or has an unknown initial character.
+	ErrATRMalformed = errors.New("malformed ATR")
+	// ErrATRChecksum is returned when the check byte (TCK) of an ATR is wrong.
+	ErrATRChecksum = errors.New("ATR checksum mismatch")
+)
+
+// ATR is a decoded Answer To Reset.
+type ATR struct {
+	Raw []byte
+	// InverseConvention is set if the initial character (TS) is 3F.
+	InverseConvention bool
+	// Protocols are the transmission protocols from the TD bytes, T=0 if there are none.
+	Protocols []int
+	// HistoricalBytes usually identify the card, many cards put the product name here.
+	HistoricalBytes []byte
+}
+
+// ParseATR decodes an ATR and verifies its check byte.
+func ParseATR(b []byte) (*ATR, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrATRMalformed, len(b))
+	}
+
+	atr := &ATR{Raw: b}
+
+	switch b[0] {
+	case atrDirectConvention:
+	case atrInverseConvention:
+		atr.InverseConvention = true
+	default:
+		return nil, fmt.Errorf("%w: TS %02x", ErrATRMalformed, b[0])
+	}
+
+	// T0 has the indicator for TA1-TD1 in the high nibble and the number of historical bytes in the low one.
+	historical := int(b[1] & 0x0f)
+	indicator := b[1] >> 4
+	i := 2
+
+	for {
+		// TA, TB and TC are skipped, only TD has information needed here.
+		for _, bit := range []byte{0x01, 0x02, 0x04} {
+			if indicator&bit != 0 {
+				i++
+			}
+		}
+
+		if indicator&0x08 == 0 {
+			break
+		}
+
+		if i >= len(b) {
+			return nil, fmt.Errorf("%w: truncated interface bytes", ErrATRMalformed)
+		}
+
+		td := b[i]
+		i++
+
+		if protocol := int(td & 0x0f); !containsInt(atr.Protocols, protocol) {
+			atr.Protocols = append(atr.Protocols, protocol)
+		}
+
+		indicator = td >> 4
+	}
+
+	if i+historical > len(b) {
+		return nil, fmt.Errorf("%w: truncated historical bytes", ErrATRMalformed)
+	}
+
+	atr.HistoricalBytes = b[i : i+historical]
+	i += historical
+
+	if len(atr.Protocols) == 0 {
+		atr.Protocols = []int{0}
+	}
+
+	// TCK is only present if a protocol other than T=0 is offered, it makes the XOR of T0 to TCK zero.
+	if len(atr.Protocols) > 1 || atr.Protocols[0] != 0 {
+		if i >= len(b) {
+			return nil, fmt.Errorf("%w: missing TCK", ErrATRMalformed)
+		}
+
+		var check byte
+		for _, c := range b[1 : i+1] {
+			check ^= c
+		}
+
+		if check != 0 {
+			return nil, ErrATRChecksum
+		}
+	}
+
+	return atr, nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CardFamily is the kind of card identified from its ATR.
+type CardFamily int
+
+const (
+	CardFamilyUnknown CardFamily = iota
+	CardFamilyYubiKey
+	// CardFamilyOpenPGPCard is the ZeitControl OpenPGP card, also used in the Nitrokey Pro.
+	CardFamilyOpenPGPCard
+	// CardFamilyGnuk is Gnuk, which the Nitrokey Start runs.
+	CardFamilyGnuk
+	CardFamilyCanoKey
+	CardFamilyNitrokey3
+	CardFamilySmartCardHSM
+)
+
+func (f CardFamily) String() string {
+	switch f {
+	case CardFamilyUnknown:
+		return "unknown"
+	case CardFamilyYubiKey:
+		return "YubiKey"
+	case CardFamilyOpenPGPCard:
+		return "OpenPGP card"
+	case CardFamilyGnuk:
+		return "Gnuk"
+	case CardFamilyCanoKey:
+		return "CanoKey"
+	case CardFamilyNitrokey3:
+		return "Nitrokey 3"
+	case CardFamilySmartCardHSM:
+		return "SmartCard-HSM"
+	default:
+		return fmt.Sprintf("CardFamily(%d)", int(f))
+	}
+}
+
+// CardIdentity is what is known about a card from its ATR.
+type CardIdentity struct {
+	Family CardFamily
+	Model  string
+	// Applications the model is known to have, they may be disabled on a particular card.
+	Applications Capability
+}
+
+// HasApplication reports whether the card is known to have the application.
+// Unknown cards might have any application.
+func (c CardIdentity) HasApplication(app Capability) bool {
+	return c.Family == CardFamilyUnknown || c.Applications&app == app
+}
+
+const yubiKeyApplications = CapabilityOTP | CapabilityU2F | CapabilityOpenPGP | CapabilityPIV | CapabilityOATH
+
+// knownCards is matched in order, either on the exact historical bytes or on a name in them.
+// nolint:gochecknoglobals
+var knownCards = []struct {
+	historical []byte
+	name       string
+	identity   CardIdentity
+}{
+	{
+		historical: []byte{0x00, 0x31, 0x84, 0x73, 0x80, 0x01, 0x80, 0x00, 0x90, 0x00},
+		identity:   CardIdentity{Family: CardFamilyGnuk, Model: "Gnuk", Applications: CapabilityOpenPGP},
+	},
+	{
+		historical: []byte{0x00, 0x31, 0xc5, 0x73, 0xc0, 0x01, 0x40, 0x00, 0x90, 0x00},
+		identity:   CardIdentity{Family: CardFamilyOpenPGPCard, Model: "OpenPGP card", Applications: CapabilityOpenPGP},
+	},
+	{
+		name:     "yubikeyneo",
+		identity: CardIdentity{Family: CardFamilyYubiKey, Model: "YubiKey NEO", Applications: yubiKeyApplications},
+	},
+	{
+		name:     "yubikey4",
+		identity: CardIdentity{Family: CardFamilyYubiKey, Model: "YubiKey 4", Applications: yubiKeyApplications},
+	},
+	{
+		name: "yubikey",
+		identity: CardIdentity{
+			Family: CardFamilyYubiKey, Model: "YubiKey 5",
+			Applications: yubiKeyApplications | CapabilityFIDO2 | CapabilityHSMAuth,
+		},
+	},
+	{
+		name: "canokey",
+		identity: CardIdentity{
+			Family: CardFamilyCanoKey, Model: "CanoKey",
+			Applications: CapabilityOpenPGP | CapabilityPIV | CapabilityOATH | CapabilityFIDO2,
+		},
+	},
+	{
+		name: "nitrokey",
+		identity: CardIdentity{
+			Family: CardFamilyNitrokey3, Model: "Nitrokey 3",
+			Applications: CapabilityOpenPGP | CapabilityPIV | CapabilityFIDO2,
+		},
+	},
+	{
+		name:     "hsm1",
+		identity: CardIdentity{Family: CardFamilySmartCardHSM, Model: "SmartCard-HSM"},
+	},
+}
+
+// Identify returns what is known about the card from its historical bytes.
+func (a *ATR) Identify() CardIdentity {
+	lowerName := strings.ToLower(string(a.HistoricalBytes))
+
+	for _, k := range knownCards {
+		if k.historical != nil && bytes.Equal(k.historical, a.HistoricalBytes) {
+			return k.identity
+		}
+
+		if k.name != "" && strings.Contains(lowerName, k.name) {
+			return k.identity
+		}
+	}
+
+	return CardIdentity{}
+}
+
+// CardInfo is a reader and the card in it, identified without connecting to the card.
+// ListCards connects to the card to fill in the rest.
+type CardInfo struct {
+	Reader string
+	// ATR is nil if the reader is empty or the ATR can't be decoded.
+	ATR      *ATR
+	Identity CardIdentity
+
+	// Serial is 0 if it's unknown.
+	Serial uint32
+	// Version is the firmware version, zero if it's unknown.
+	Version Version
+	// Applets are the names of the applets that could be selected, such as "PIV" and "OpenPGP".
+	Applets []string
+	// OpenPGP is the data of the OpenPGP applet, nil if the card doesn't have one.
+	OpenPGP *GpgData
+	// Err is why the card couldn't be read, such as another program using it exclusively.
+	Err error
+}
+
+// CardInfos lists all readers with the identity of the card in them.
+func CardInfos() ([]CardInfo, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.CardInfos()
+}
+
+// CardInfos lists all readers with the identity of the card in them.
+// Unlike opening each card to read its serial, this doesn't disturb cards other programs are using.
+func (c *Client) CardInfos() ([]CardInfo, error) {
+	ctx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+	defer ctx.Close()
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		return nil, fmt.Errorf("listing readers: %w", err)
+	}
+
+	infos := make([]CardInfo, 0, len(readers))
+
+	for _, reader := range readers {
+		raw, err := ctx.ATR(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading ATR of %s: %w", reader, err)
+		}
+
+		info := CardInfo{Reader: reader}
+
+		if atr, err := ParseATR(raw); err == nil {
+			info.ATR = atr
+			info.Identity = atr.Identify()
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,178 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustHex(tb testing.TB, s string) []byte {
+	tb.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		tb.Fatalf("decoding %s: %v", s, err)
+	}
+
+	return b
+}
+
+func TestParseATR(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		atr       string
+		family    CardFamily
+		model     string
+		protocols []int
+		err       error
+	}{
+		{
+			name:      "YubiKey 5",
+			atr:       "3B FD 13 00 00 81 31 FE 15 80 73 C0 21 C0 57 59 75 62 69 4B 65 79 40",
+			family:    CardFamilyYubiKey,
+			model:     "YubiKey 5",
+			protocols: []int{1},
+		},
+		{
+			name:      "YubiKey NEO",
+			atr:       "3B FC 13 00 00 81 31 FE 15 59 75 62 69 6B 65 79 4E 45 4F 72 33 E1",
+			family:    CardFamilyYubiKey,
+			model:     "YubiKey NEO",
+			protocols: []int{1},
+		},
+		{
+			name:      "Gnuk",
+			atr:       "3B DA 11 FF 81 B1 FE 55 1F 03 00 31 84 73 80 01 80 00 90 00 E4",
+			family:    CardFamilyGnuk,
+			model:     "Gnuk",
+			protocols: []int{1, 15},
+		},
+		{
+			name:      "OpenPGP card",
+			atr:       "3B DA 18 FF 81 B1 FE 75 1F 03 00 31 C5 73 C0 01 40 00 90 00 0C",
+			family:    CardFamilyOpenPGPCard,
+			model:     "OpenPGP card",
+			protocols: []int{1, 15},
+		},
+		{
+			name:      "CanoKey",
+			atr:       "3B F7 11 00 00 81 31 FE 65 43 61 6E 6F 6B 65 79 99",
+			family:    CardFamilyCanoKey,
+			model:     "CanoKey",
+			protocols: []int{1},
+		},
+		{
+			name:      "Nitrokey 3",
+			atr:       "3B 8F 01 80 5D 4E 69 74 72 6F 6B 65 79 00 00 00 00 00 6A",
+			family:    CardFamilyNitrokey3,
+			model:     "Nitrokey 3",
+			protocols: []int{1},
+		},
+		{
+			name:      "SmartCard-HSM",
+			atr:       "3B FE 18 00 00 81 31 FE 45 80 31 81 54 48 53 4D 31 73 80 21 40 81 07 FA",
+			family:    CardFamilySmartCardHSM,
+			model:     "SmartCard-HSM",
+			protocols: []int{1},
+		},
+		{
+			name:      "T=0 without TCK",
+			atr:       "3B 02 14 50",
+			family:    CardFamilyUnknown,
+			protocols: []int{0},
+		},
+		{
+			name: "bad checksum",
+			atr:  "3B F7 11 00 00 81 31 FE 65 43 61 6E 6F 6B 65 79 98",
+			err:  ErrATRChecksum,
+		},
+		{
+			name: "bad TS",
+			atr:  "3C 00",
+			err:  ErrATRMalformed,
+		},
+		{
+			name: "truncated",
+			atr:  "3B 8F 01 80 5D",
+			err:  ErrATRMalformed,
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			atr, err := ParseATR(mustHex(t, tc.atr))
+			if !expectedError(t, err, tc.err) || tc.err != nil {
+				return
+			}
+
+			id := atr.Identify()
+			if id.Family != tc.family || id.Model != tc.model {
+				t.Errorf("got %s %q expected %s %q", id.Family, id.Model, tc.family, tc.model)
+			}
+
+			if len(atr.Protocols) != len(tc.protocols) {
+				t.Fatalf("got protocols %v expected %v", atr.Protocols, tc.protocols)
+			}
+
+			for i := range tc.protocols {
+				if atr.Protocols[i] != tc.protocols[i] {
+					t.Errorf("got protocols %v expected %v", atr.Protocols, tc.protocols)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_CardInfos(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{
+		client: &client{},
+		SCConstruct: &TestSCConstructor{
+			Ctx: TestSCContext{
+				Readers: []string{"Yubico YubiKey OTP+FIDO+CCID 00 00", "Empty Reader 01 00"},
+				ATRs: map[string][]byte{
+					"Yubico YubiKey OTP+FIDO+CCID 00 00": mustHex(t, "3B FD 13 00 00 81 31 FE 15 80 73 C0 21 C0 57 59 75 62 69 4B 65 79 40"),
+				},
+			},
+		},
+	}
+
+	infos, err := c.CardInfos()
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if len(infos) != 2 {
+		t.Fatalf("got %d cards", len(infos))
+	}
+
+	if infos[0].ATR == nil || !infos[0].Identity.HasApplication(CapabilityPIV) || infos[0].Identity.Family != CardFamilyYubiKey {
+		t.Errorf("unexpected card %+v", infos[0])
+	}
+
+	if infos[1].ATR != nil || infos[1].Identity.Family != CardFamilyUnknown {
+		t.Errorf("unexpected empty reader %+v", infos[1])
+	}
+}
@@ -0,0 +1,202 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// YubicoPIVCAURL is where Yubico publishes the PIV attestation CA, for FetchAttestationRoots.
+const YubicoPIVCAURL = "https://developers.yubico.com/PIV/Introduction/piv-attestation-ca.pem"
+
+// maxRootsSize limits the PEM bundle read by FetchAttestationRoots.
+const maxRootsSize = 1 << 20
+
+var (
+	extIDFIPSCertified = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 41482, 3, 10})
+	extIDCSPNCertified = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 41482, 3, 11})
+)
+
+var (
+	// ErrMissingAttestationExtension is returned by VerifyChain for slot certificates without
+	// the serial, firmware version or key policy extension.
+	ErrMissingAttestationExtension = errors.New("attestation extension missing")
+	// ErrAttestationPolicy is returned by AttestationFacts.Check when the attestation doesn't satisfy the policy.
+	ErrAttestationPolicy = errors.New("attestation doesn't satisfy policy")
+)
+
+// AttestationFacts is what a verified attestation proves about a key, for enrollment services
+// deciding whether to issue a certificate for it.
+type AttestationFacts struct {
+	Attestation
+
+	// PublicKey is the attested key.
+	PublicKey crypto.PublicKey
+	// FIPS and CSPN are set when the YubiKey is FIPS or CSPN certified.
+	FIPS bool
+	CSPN bool
+	// Chain is the verified chain from the slot certificate to the root.
+	Chain []*x509.Certificate
+}
+
+// AttestationPolicy holds the requirements of AttestationFacts.Check, zero fields aren't checked.
+type AttestationPolicy struct {
+	// Serial is the serial the YubiKey must have.
+	Serial uint32
+	// MinVersion is the oldest firmware allowed.
+	MinVersion Version
+	// PINPolicies and TouchPolicies are the allowed policies of the key.
+	PINPolicies   []PINPolicy
+	TouchPolicies []TouchPolicy
+	// Slots are the slots the key may be in.
+	Slots []Slot
+	// FIPS requires a FIPS certified YubiKey.
+	FIPS bool
+}
+
+// Check returns an error wrapping ErrAttestationPolicy for the first requirement of p that isn't met.
+func (a *AttestationFacts) Check(p AttestationPolicy) error {
+	switch {
+	case p.Serial != 0 && a.Serial != p.Serial:
+		return fmt.Errorf("%w: serial %d, expected %d", ErrAttestationPolicy, a.Serial, p.Serial)
+	case p.MinVersion != (Version{}) && !supportsVersion(a.Version, p.MinVersion.Major, p.MinVersion.Minor, p.MinVersion.Patch):
+		return fmt.Errorf("%w: firmware %d.%d.%d older than %d.%d.%d", ErrAttestationPolicy,
+			a.Version.Major, a.Version.Minor, a.Version.Patch, p.MinVersion.Major, p.MinVersion.Minor, p.MinVersion.Patch)
+	case len(p.PINPolicies) > 0 && !slices.Contains(p.PINPolicies, a.PINPolicy):
+		return fmt.Errorf("%w: pin policy %s", ErrAttestationPolicy, a.PINPolicy)
+	case len(p.TouchPolicies) > 0 && !slices.Contains(p.TouchPolicies, a.TouchPolicy):
+		return fmt.Errorf("%w: touch policy %s", ErrAttestationPolicy, a.TouchPolicy)
+	case len(p.Slots) > 0 && !slices.Contains(p.Slots, a.Slot):
+		return fmt.Errorf("%w: slot %s", ErrAttestationPolicy, a.Slot)
+	case p.FIPS && !a.FIPS:
+		return fmt.Errorf("%w: not FIPS certified", ErrAttestationPolicy)
+	}
+
+	return nil
+}
+
+// VerifyChain verifies slotCert with the Yubico CA bundle, see Verifier.VerifyChain.
+func VerifyChain(slotCert *x509.Certificate, chain ...*x509.Certificate) (*AttestationFacts, error) {
+	var v Verifier
+	return v.VerifyChain(slotCert, chain...)
+}
+
+// VerifyChain proves that the key of slotCert was generated on a YubiKey, like Verify, and returns
+// the facts of the attestation. chain holds the certificate of the attestation key, from
+// AttestationCertificate, and any intermediates between it and the roots. Unlike Verify, the serial,
+// firmware version and key policy extensions are required.
+func (v *Verifier) VerifyChain(slotCert *x509.Certificate, chain ...*x509.Certificate) (*AttestationFacts, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("verifying attestation: no attestation certificate")
+	}
+
+	o := x509.VerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	o.Roots = v.Roots
+	if o.Roots == nil {
+		cas, err := yubicoCAs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load yubico CAs: %v", err)
+		}
+		o.Roots = cas
+	}
+
+	// See Verify, the attestation cert of some YubiKey 4 has no basic constraints.
+	// The workaround is applied to a copy, the caller's certificates aren't changed.
+	attestationCert := *chain[0]
+	if !attestationCert.BasicConstraintsValid {
+		attestationCert.BasicConstraintsValid = true
+		attestationCert.IsCA = true
+	}
+
+	o.Intermediates = x509.NewCertPool()
+	o.Intermediates.AddCert(&attestationCert)
+
+	for _, c := range chain[1:] {
+		o.Intermediates.AddCert(c)
+	}
+
+	chains, err := slotCert.Verify(o)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying attestation certificate: %v", err)
+	}
+
+	for _, id := range []asn1.ObjectIdentifier{extIDSerialNumber, extIDFirmwareVersion, extIDKeyPolicy} {
+		if !hasExtension(slotCert, id) {
+			return nil, fmt.Errorf("%w: %s", ErrMissingAttestationExtension, id)
+		}
+	}
+
+	a, err := parseAttestation(slotCert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AttestationFacts{
+		Attestation: *a,
+		PublicKey:   slotCert.PublicKey,
+		FIPS:        hasExtension(slotCert, extIDFIPSCertified),
+		CSPN:        hasExtension(slotCert, extIDCSPNCertified),
+		Chain:       chains[0],
+	}, nil
+}
+
+func hasExtension(cert *x509.Certificate, id asn1.ObjectIdentifier) bool {
+	return slices.ContainsFunc(cert.Extensions, func(e pkix.Extension) bool { return e.Id.Equal(id) })
+}
+
+// FetchAttestationRoots downloads a PEM bundle of attestation roots, such as YubicoPIVCAURL, for Verifier.Roots.
+// The roots this package embeds are used when Verifier.Roots is nil, fetching picks up CAs Yubico adds later.
+// client is http.DefaultClient if nil.
+func FetchAttestationRoots(ctx context.Context, client *http.Client, url string) (*x509.CertPool, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching attestation roots: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching attestation roots: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching attestation roots: %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxRootsSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading attestation roots: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates in attestation roots from %s", url)
+	}
+
+	return pool, nil
+}
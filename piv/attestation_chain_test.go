@@ -0,0 +1,154 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestVerifier_VerifyChain(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{Serial: 424242})
+
+	yk, err := pivtest.NewClient(card).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer yk.Close()
+
+	opts := piv.Key{Algorithm: piv.AlgorithmEC256, PINPolicy: piv.PINPolicyAlways, TouchPolicy: piv.TouchPolicyNever}
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotSignature, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestationCert, err := yk.AttestationCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slotCert, err := yk.Attest(piv.SlotSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := piv.Verifier{Roots: card.AttestationRoots()}
+
+	facts, err := v.VerifyChain(slotCert, attestationCert)
+	if err != nil {
+		t.Fatalf("verifying: %v", err)
+	}
+
+	if !facts.PublicKey.(*ecdsa.PublicKey).Equal(pub) || facts.Serial != 424242 || facts.Slot != piv.SlotSignature || facts.FIPS {
+		t.Errorf("unexpected facts %+v", facts)
+	}
+
+	if len(facts.Chain) != 3 || !facts.Chain[1].Equal(attestationCert) {
+		t.Errorf("chain has %d certificates", len(facts.Chain))
+	}
+
+	policy := piv.AttestationPolicy{
+		Serial:      424242,
+		MinVersion:  piv.Version{Major: 5, Minor: 4},
+		PINPolicies: []piv.PINPolicy{piv.PINPolicyOnce, piv.PINPolicyAlways},
+	}
+	if err := facts.Check(policy); err != nil {
+		t.Errorf("checking policy: %v", err)
+	}
+
+	for _, p := range []piv.AttestationPolicy{
+		{Serial: 1},
+		{MinVersion: piv.Version{Major: 5, Minor: 7}},
+		{TouchPolicies: []piv.TouchPolicy{piv.TouchPolicyAlways}},
+		{Slots: []piv.Slot{piv.SlotAuthentication}},
+		{FIPS: true},
+	} {
+		if err := facts.Check(p); !errors.Is(err, piv.ErrAttestationPolicy) {
+			t.Errorf("policy %+v: %v", p, err)
+		}
+	}
+
+	if _, err := piv.VerifyChain(slotCert, attestationCert); err == nil {
+		t.Errorf("verified with the Yubico roots")
+	}
+
+	// like the attestation cert of some YubiKey 4, the workaround mustn't change the caller's copy.
+	noConstraints := *attestationCert
+	noConstraints.BasicConstraintsValid = false
+	noConstraints.IsCA = false
+
+	if _, err := v.VerifyChain(slotCert, &noConstraints); err != nil {
+		t.Errorf("verifying without basic constraints: %v", err)
+	}
+
+	if noConstraints.BasicConstraintsValid || noConstraints.IsCA {
+		t.Errorf("VerifyChain changed the attestation certificate")
+	}
+}
+
+func TestFetchAttestationRoots(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{})
+
+	yk, err := pivtest.NewClient(card).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer yk.Close()
+
+	attestationCert, err := yk.AttestationCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ca.pem" {
+			http.NotFound(w, r)
+			return
+		}
+
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: attestationCert.Raw})
+	}))
+	defer srv.Close()
+
+	pool, err := piv.FetchAttestationRoots(context.Background(), srv.Client(), srv.URL+"/ca.pem")
+	if err != nil {
+		t.Fatalf("fetching: %v", err)
+	}
+
+	expected := x509.NewCertPool()
+	expected.AddCert(attestationCert)
+
+	if !pool.Equal(expected) {
+		t.Errorf("unexpected roots")
+	}
+
+	if _, err := piv.FetchAttestationRoots(context.Background(), srv.Client(), srv.URL+"/missing"); err == nil {
+		t.Errorf("fetched a missing bundle")
+	}
+}
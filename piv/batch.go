@@ -0,0 +1,178 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBatchEmpty is returned when running a batch without operations.
+var ErrBatchEmpty = errors.New("batch has no operations")
+
+type batchOp struct {
+	name string
+	run  func(tx SCTx) ([]byte, error)
+}
+
+// Batch queues OpenPGP operations so they run back to back in the card's transaction,
+// with the applet selected once for all of them.
+// This is much cheaper than individual calls when signing many digests.
+type Batch struct {
+	yk  *GPGYubiKey
+	ops []batchOp
+}
+
+// BatchResult is the outcome of a single operation in a Batch.
+// Data is nil for operations that don't return anything, like verifying a PIN.
+type BatchResult struct {
+	Name string
+	Data []byte
+}
+
+// NewBatch starts an empty batch on the card.
+func (yk *GPGYubiKey) NewBatch() *Batch {
+	return &Batch{yk: yk}
+}
+
+// Len returns the number of queued operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// VerifySigningPIN queues a VERIFY of PW1 for PSO:COMPUTE DIGITAL SIGNATURE (81).
+func (b *Batch) VerifySigningPIN(pin []byte) *Batch {
+	return b.verify("verify signing pin", pin, paramOpenGPGVerifyPW1)
+}
+
+// VerifyPIN queues a VERIFY of PW1 for decryption and authentication (82), like AuthPIN.
+func (b *Batch) VerifyPIN(pin []byte) *Batch {
+	return b.verify("verify pin", pin, paramOpenGPGVerifyPW2)
+}
+
+func (b *Batch) verify(name string, pin []byte, pwField byte) *Batch {
+	b.ops = append(b.ops, batchOp{
+		name: name,
+		run: func(tx SCTx) ([]byte, error) {
+			if len(pin) < minPW1Length {
+				return nil, ErrTooShort
+			}
+
+			kdf, err := gpgKDF(tx, b.yk.gpgData)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, gpgLogin(tx, kdf.Derive(pin, pwField), pwField)
+		},
+	})
+
+	return b
+}
+
+// GetData queues a GET DATA of the data object with the given tag, for example 0x6E or 0x5F50.
+func (b *Batch) GetData(tag uint16) *Batch {
+	b.ops = append(b.ops, batchOp{
+		name: fmt.Sprintf("get data %04x", tag),
+		run: func(tx SCTx) ([]byte, error) {
+			return tx.Transmit(apdu{instruction: insGetDataA, param1: byte(tag >> 8), param2: byte(tag)})
+		},
+	})
+
+	return b
+}
+
+// Sign queues a PSO:COMPUTE DIGITAL SIGNATURE of each digest with the signature key.
+// For RSA keys each digest must already be a DigestInfo.
+func (b *Batch) Sign(digests ...[]byte) *Batch {
+	for i, digest := range digests {
+		digest := digest
+
+		b.ops = append(b.ops, batchOp{
+			name: fmt.Sprintf("sign %d", i),
+			run: func(tx SCTx) ([]byte, error) {
+				if len(digest) == 0 {
+					return nil, ErrTooShort
+				}
+
+				sig, err := gpgComputeDigitalSignature(tx, digest)
+				if err != nil {
+					return nil, err
+				}
+
+				b.yk.gpgData.countSignature()
+
+				return sig, nil
+			},
+		})
+	}
+
+	return b
+}
+
+// Decrypt queues a PSO:DECIPHER of the ciphertext with the decryption key.
+func (b *Batch) Decrypt(ciphertext []byte) *Batch {
+	b.ops = append(b.ops, batchOp{
+		name: "decrypt",
+		run: func(tx SCTx) ([]byte, error) {
+			if len(ciphertext) == 0 {
+				return nil, ErrTooShort
+			}
+
+			return gpgDecipher(tx, ciphertext)
+		},
+	})
+
+	return b
+}
+
+// Run selects the OpenPGP applet once and executes the queued operations in order.
+// It stops at the first failure, the results of the operations that ran before it are returned with the error.
+// The batch is emptied so it can be reused.
+func (b *Batch) Run() ([]BatchResult, error) {
+	if b.yk == nil || b.yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if b.yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.Batch.Run\u001b[0m")
+	}
+
+	if len(b.ops) == 0 {
+		return nil, ErrBatchEmpty
+	}
+
+	ops := b.ops
+	b.ops = nil
+
+	// another program may have selected a different applet between transactions, so select it once here.
+	if err := ykSelectOpenGPGApplication(b.yk.tx); err != nil {
+		return nil, fmt.Errorf("selecting openpgp applet: %w", err)
+	}
+
+	results := make([]BatchResult, 0, len(ops))
+
+	for i, op := range ops {
+		data, err := op.run(b.yk.tx)
+		if err != nil {
+			return results, fmt.Errorf("batch operation %d (%s): %w", i, op.name, err)
+		}
+
+		results = append(results, BatchResult{Name: op.name, Data: data})
+	}
+
+	return results, nil
+}
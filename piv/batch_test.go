@@ -0,0 +1,120 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBatch_Run(t *testing.T) {
+	t.Parallel()
+
+	pin := []byte("123456")
+	selectAPDU := apdu{instruction: insSelectApplication, param1: paramOpenGPGASelectApplication, data: aidOpenPGP[:]}
+	verifyAPDU := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW1, data: pin}
+	getDataAPDU := apdu{instruction: insGetDataA, param1: 0x5f, param2: 0x50}
+	signAPDU := func(d []byte) apdu {
+		return apdu{
+			instruction: insPerformSecurityOperation,
+			param1:      securityOperationComputeDigitalSignatureParam1,
+			param2:      securityOperationComputeDigitalSignatureParam2,
+			data:        d,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		tx        *TestSCTx
+		batch     func(b *Batch) *Batch
+		expected  [][]byte
+		expectErr error
+	}{
+		{
+			name:      "empty",
+			tx:        &TestSCTx{},
+			batch:     func(b *Batch) *Batch { return b },
+			expectErr: ErrBatchEmpty,
+		},
+		{
+			name: "verify, read and sign",
+			tx: &TestSCTx{
+				APDUList:     []apdu{selectAPDU, verifyAPDU, getDataAPDU, signAPDU([]byte{1}), signAPDU([]byte{2})},
+				ResponseList: [][]byte{{}, {}, []byte("https://example.com"), {0xa1}, {0xa2}},
+			},
+			batch: func(b *Batch) *Batch {
+				return b.VerifySigningPIN(pin).GetData(0x5f50).Sign([]byte{1}, []byte{2})
+			},
+			expected: [][]byte{nil, []byte("https://example.com"), {0xa1}, {0xa2}},
+		},
+		{
+			name: "stops at first failure",
+			tx: &TestSCTx{
+				APDUList:     []apdu{selectAPDU, signAPDU([]byte{1}), signAPDU([]byte{2})},
+				ResponseList: [][]byte{{}, {0xa1}, nil},
+				TransmitErr:  []error{nil, nil, &apduErr{0x69, 0x82}},
+			},
+			batch: func(b *Batch) *Batch {
+				return b.Sign([]byte{1}, []byte{2}, []byte{3})
+			},
+			expected:  [][]byte{{0xa1}},
+			expectErr: AuthErr{-1},
+		},
+		{
+			name:      "short pin",
+			tx:        &TestSCTx{APDUList: []apdu{selectAPDU}, ResponseList: [][]byte{{}}},
+			batch:     func(b *Batch) *Batch { return b.VerifyPIN([]byte("123")) },
+			expected:  [][]byte{},
+			expectErr: ErrTooShort,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+			yk.tx = tc.tx
+
+			b := tc.batch(yk.NewBatch())
+			results, err := b.Run()
+
+			if !expectedError(t, err, tc.expectErr) {
+				return
+			}
+
+			if b.Len() != 0 {
+				t.Errorf("expected the batch to be emptied, has %d operations", b.Len())
+			}
+
+			if tc.expected == nil {
+				return
+			}
+
+			if len(results) != len(tc.expected) {
+				t.Fatalf("got %d results expected %d", len(results), len(tc.expected))
+			}
+
+			for i, r := range results {
+				if !bytes.Equal(r.Data, tc.expected[i]) {
+					t.Errorf("result %d (%s): got %x expected %x", i, r.Name, r.Data, tc.expected[i])
+				}
+			}
+		})
+	}
+}
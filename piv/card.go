@@ -0,0 +1,418 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCardClosed is returned by the applets of a Card after Card.Close.
+var ErrCardClosed = errors.New("card closed")
+
+// Applet is an application of a YubiKey that Card.SelectApplet can switch to.
+type Applet int
+
+const (
+	AppletPIV Applet = iota
+	AppletOpenPGP
+	AppletManagement
+	AppletOATH
+)
+
+func (a Applet) String() string {
+	switch a {
+	case AppletPIV:
+		return "PIV"
+	case AppletOpenPGP:
+		return "OpenPGP"
+	case AppletManagement:
+		return "Management"
+	case AppletOATH:
+		return "OATH"
+	}
+
+	return fmt.Sprintf("unknown: %d", a)
+}
+
+// AID returns the application identifier of the applet, nil for unknown applets.
+func (a Applet) AID() []byte {
+	switch a {
+	case AppletPIV:
+		return append([]byte{}, aidPIV[:]...)
+	case AppletOpenPGP:
+		return append([]byte{}, aidOpenPGP[:]...)
+	case AppletManagement:
+		return append([]byte{}, aidManagement[:]...)
+	case AppletOATH:
+		return append([]byte{}, aidOATH[:]...)
+	}
+
+	return nil
+}
+
+// Card is one connection to a card shared by its applets, so a process can sign with the PIV applet
+// and decrypt with the OpenPGP applet without opening the card twice.
+// The YubiKey and GPGYubiKey of a Card select their applet again before a command when another one
+// was used in between. Selecting an applet resets the PINs verified in the others, verify them again
+// after switching. A Card isn't safe for concurrent use, CardManager serializes access to one applet.
+type Card struct {
+	ctx    SCContext
+	h      SCHandle
+	tx     SCTx
+	reader string
+	rand   io.Reader
+	closed bool
+
+	pinPrompt PINPromptFunc
+	pinCache  *PINCache
+
+	// selected is the AID the card last selected, nil when it isn't known.
+	selected []byte
+	// applets caches the select response and APDU settings of each AID.
+	applets map[string]*appletState
+
+	piv *YubiKey
+	gpg *GPGYubiKey
+}
+
+// appletState is what Card remembers about an applet.
+type appletState struct {
+	response []byte
+	// extendedLength and maxCommand are restored when the applet is selected again.
+	extendedLength bool
+	maxCommand     int
+}
+
+// OpenCard connects to card without selecting an applet, see Card.
+func OpenCard(card string) (*Card, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenCard(card)
+}
+
+// OpenCard connects to card without selecting an applet, see Card.
+func (c *Client) OpenCard(card string) (*Card, error) {
+	ctx, h, tx, err := c.connectCard(card)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	if c.client != nil {
+		r = c.client.Rand
+	}
+
+	return &Card{
+		ctx:       ctx,
+		h:         h,
+		tx:        tx,
+		reader:    card,
+		rand:      r,
+		pinPrompt: c.PINPrompt,
+		pinCache:  c.PINCache,
+		applets:   map[string]*appletState{},
+	}, nil
+}
+
+// Close releases the connection to the smart card, the YubiKey and GPGYubiKey of the card can't be used after it.
+func (c *Card) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	if c.piv != nil {
+		c.piv.pins.cache.endTransaction(c.piv.pins.card)
+	}
+
+	return closeHandles(c.ctx, c.h)
+}
+
+// SelectApplet selects the applet with aid and returns its response to SELECT.
+// It doesn't send anything when aid is already selected, the response is the one cached when it was.
+func (c *Card) SelectApplet(aid []byte) ([]byte, error) {
+	if c.closed {
+		return nil, ErrCardClosed
+	}
+
+	if c.selected != nil && bytes.Equal(c.selected, aid) {
+		return c.applets[string(aid)].response, nil
+	}
+
+	return c.selectApplet(aid)
+}
+
+// selectApplet sends SELECT for aid and restores the APDU settings of the applet.
+func (c *Card) selectApplet(aid []byte) ([]byte, error) {
+	c.selected = nil
+
+	resp, err := ykSelectApplicationResponse(c.tx, aid)
+	if err != nil {
+		return nil, fmt.Errorf("selecting applet %x: %w", aid, err)
+	}
+
+	state := c.state(aid)
+	state.response = resp
+	c.selected = append([]byte{}, aid...)
+
+	if e, ok := c.tx.(ExtendedLengthTx); ok {
+		e.SetExtendedLength(state.extendedLength)
+		e.SetMaxCommandLength(state.maxCommand)
+	}
+
+	return resp, nil
+}
+
+func (c *Card) state(aid []byte) *appletState {
+	state, ok := c.applets[string(aid)]
+	if !ok {
+		state = &appletState{}
+		c.applets[string(aid)] = state
+	}
+
+	return state
+}
+
+// PIV returns the PIV applet of the card, Close on it does nothing, close the Card instead.
+func (c *Card) PIV() (*YubiKey, error) {
+	if c.closed {
+		return nil, ErrCardClosed
+	}
+
+	if c.piv != nil {
+		return c.piv, nil
+	}
+
+	tx := &appletTx{SCTx: c.tx, card: c, aid: aidPIV[:]}
+	if _, err := c.SelectApplet(tx.aid); err != nil {
+		return nil, err
+	}
+
+	v, err := ykVersion(tx)
+	if err != nil {
+		return nil, fmt.Errorf("getting yubikey version: %w", err)
+	}
+
+	yk := &YubiKey{
+		ctx:     sharedContext{c.ctx},
+		h:       sharedHandle{c.h},
+		tx:      tx,
+		version: v,
+		quirks:  pivQuirksFor(c.reader),
+		rand:    c.rand,
+	}
+	yk.pins.card = c.reader
+
+	if yk.rand == nil {
+		yk.rand = rand.Reader
+	}
+
+	yk.SetPINPrompt(c.pinPrompt, c.pinCache)
+
+	c.piv = yk
+
+	return yk, nil
+}
+
+// OpenPGP returns the OpenPGP applet of the card, Close on it does nothing, close the Card instead.
+// Secure messaging isn't possible, selecting another applet would end the session.
+func (c *Card) OpenPGP() (*GPGYubiKey, error) {
+	if c.closed {
+		return nil, ErrCardClosed
+	}
+
+	if c.gpg != nil {
+		return c.gpg, nil
+	}
+
+	tx := &appletTx{SCTx: c.tx, card: c, aid: aidOpenPGP[:]}
+	if _, err := c.SelectApplet(tx.aid); err != nil {
+		return nil, err
+	}
+
+	gpgData, err := ykOpenGPGData(tx, c.reader, ParseLenient)
+	if err == nil {
+		err = gpgData.readLoginData(tx)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("selecting openpgp applet: %w", err)
+	}
+
+	if gpgData.ExtendedLengthSupported() {
+		tx.SetExtendedLength(true)
+
+		if maxCommand, _, ok := gpgData.ExtendedLengthInformation(); ok {
+			tx.SetMaxCommandLength(maxCommand)
+		}
+	}
+
+	c.gpg = &GPGYubiKey{
+		ctx:     sharedContext{c.ctx},
+		h:       sharedHandle{c.h},
+		tx:      tx,
+		gpgData: gpgData,
+	}
+
+	return c.gpg, nil
+}
+
+// Management returns the management applet of the card, Close on it does nothing, close the Card instead.
+func (c *Card) Management() (*Management, error) {
+	if c.closed {
+		return nil, ErrCardClosed
+	}
+
+	tx := &appletTx{SCTx: c.tx, card: c, aid: aidManagement[:]}
+
+	resp, err := c.SelectApplet(tx.aid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Management{
+		ctx:       sharedContext{c.ctx},
+		h:         sharedHandle{c.h},
+		tx:        tx,
+		version:   parseManagementVersion(resp),
+		transport: TransportForReader(c.reader),
+	}, nil
+}
+
+// APDU is a command for an applet this package has no type for, like the OATH applet of package oath.
+type APDU struct {
+	Instruction byte
+	Param1      byte
+	Param2      byte
+	Data        []byte
+	// SendRemaining is the instruction that reads the rest of a long response, GET RESPONSE (C0) when 0.
+	// The YubiKey OATH applet uses SEND REMAINING (A5).
+	SendRemaining byte
+}
+
+// Transmit sends cmd to the applet with aid, selecting it first when another applet was used in between,
+// and returns the response data. Long commands are chained. A status other than 9000 is an error wrapping
+// an *apdu.Error. Selecting another applet resets what was verified in this one, check the errors for
+// apdu.ErrSecurityStatusNotSatisfied.
+func (c *Card) Transmit(aid []byte, cmd APDU) ([]byte, error) {
+	if _, err := c.SelectApplet(aid); err != nil {
+		return nil, err
+	}
+
+	return c.tx.Transmit(apdu{
+		instruction:   cmd.Instruction,
+		param1:        cmd.Param1,
+		param2:        cmd.Param2,
+		data:          cmd.Data,
+		sendRemaining: cmd.SendRemaining,
+	})
+}
+
+// sharedContext and sharedHandle belong to a Card, closing them is left to it.
+type sharedContext struct{ SCContext }
+
+func (sharedContext) Close() error { return nil }
+
+type sharedHandle struct{ SCHandle }
+
+func (sharedHandle) Close() error { return nil }
+
+// appletTx selects the applet of aid on the card before each command if another applet was selected.
+type appletTx struct {
+	SCTx
+	card *Card
+	aid  []byte
+}
+
+var (
+	_ TransactionTx    = (*appletTx)(nil)
+	_ ExtendedLengthTx = (*appletTx)(nil)
+)
+
+// Transmit sends d, a SELECT sent through the applet, like Serial does to read the management applet,
+// is sent as is and remembered by the card.
+func (t *appletTx) Transmit(d apdu) ([]byte, error) {
+	if d.instruction == insSelectApplication && d.param1 == 0x04 {
+		if t.card.closed {
+			return nil, ErrCardClosed
+		}
+
+		return t.card.selectApplet(d.data)
+	}
+
+	if _, err := t.card.SelectApplet(t.aid); err != nil {
+		return nil, err
+	}
+
+	return t.SCTx.Transmit(d)
+}
+
+func (t *appletTx) TransmitBytes(req []byte) (bool, []byte, error) {
+	if _, err := t.card.SelectApplet(t.aid); err != nil {
+		return false, nil, err
+	}
+
+	return t.SCTx.TransmitBytes(req)
+}
+
+// BeginTransaction forgets the selected applet, another program may have selected a different one.
+func (t *appletTx) BeginTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	t.card.selected = nil
+
+	return tx.BeginTransaction()
+}
+
+func (t *appletTx) EndTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	return tx.EndTransaction()
+}
+
+func (t *appletTx) SetExtendedLength(enabled bool) {
+	t.card.state(t.aid).extendedLength = enabled
+
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok && bytes.Equal(t.card.selected, t.aid) {
+		e.SetExtendedLength(enabled)
+	}
+}
+
+func (t *appletTx) ExtendedLength() bool {
+	return t.card.state(t.aid).extendedLength
+}
+
+func (t *appletTx) SetMaxCommandLength(n int) {
+	t.card.state(t.aid).maxCommand = n
+
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok && bytes.Equal(t.card.selected, t.aid) {
+		e.SetMaxCommandLength(n)
+	}
+}
@@ -0,0 +1,157 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrCardManagerClosed is returned by CardManager.Do after Close.
+var ErrCardManagerClosed = errors.New("card manager closed")
+
+// PC/SC return codes after which the card has to be connected again.
+// https://pcsclite.apdu.fr/api/group__ErrorCodes.html
+const (
+	rcNoSmartcard       int64 = 0x8010000C // SCARD_E_NO_SMARTCARD
+	rcReaderUnavailable int64 = 0x80100017 // SCARD_E_READER_UNAVAILABLE
+	rcUnpoweredCard     int64 = 0x80100067 // SCARD_W_UNPOWERED_CARD
+	rcResetCard         int64 = 0x80100068 // SCARD_W_RESET_CARD
+	rcRemovedCard       int64 = 0x80100069 // SCARD_W_REMOVED_CARD
+)
+
+// cardLost reports whether err means the card was reset or removed, rather than the command failing.
+func cardLost(err error) bool {
+	var sc *scErr
+	if !errors.As(err, &sc) {
+		return false
+	}
+
+	switch sc.rc {
+	case rcNoSmartcard, rcReaderUnavailable, rcUnpoweredCard, rcResetCard, rcRemovedCard:
+		return true
+	}
+
+	return false
+}
+
+// CardManager owns the PC/SC context for a card and serializes access to its OpenPGP applet
+// across goroutines. When the card was reset by another application, or removed and inserted again,
+// it reconnects and selects the applet again, so long-running daemons don't need their own retry logic.
+type CardManager struct {
+	client *Client
+	card   string
+	opts   GPGOpenOptions
+
+	mu     sync.Mutex
+	ctx    SCContext
+	yk     *GPGYubiKey
+	closed bool
+}
+
+// NewCardManager returns a CardManager for card, it connects on the first Do.
+func (c *Client) NewCardManager(card string, opts GPGOpenOptions) *CardManager {
+	return &CardManager{client: c, card: card, opts: opts}
+}
+
+// Do calls fn with the card while holding the lock, connecting first if needed.
+// If fn fails because the card was reset or removed, Do connects again and calls fn once more,
+// so fn must be safe to repeat. A reset clears the verified PINs, fn has to present them itself.
+// yk is only valid during fn, it must not be kept or closed.
+func (m *CardManager) Do(fn func(yk *GPGYubiKey) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrCardManagerClosed
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := m.connect(); err != nil {
+			return err
+		}
+
+		err := fn(m.yk)
+		if err == nil || !cardLost(err) || attempt > 0 {
+			return err
+		}
+
+		m.disconnect()
+	}
+}
+
+// Close disconnects from the card and releases the context.
+func (m *CardManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+	m.disconnect()
+
+	if m.ctx == nil {
+		return nil
+	}
+
+	err := m.ctx.Close()
+	m.ctx = nil
+
+	return err
+}
+
+// connect opens the card if it isn't open, a context that can't connect is replaced the next time.
+func (m *CardManager) connect() error {
+	if m.yk != nil {
+		return nil
+	}
+
+	if m.ctx == nil {
+		ctx, err := m.client.SCConstruct.NewSCContext()
+		if err != nil {
+			return fmt.Errorf("connecting to smart card daemon: %w", err)
+		}
+
+		m.ctx = ctx
+	}
+
+	yk, err := openGPGOnContext(m.ctx, m.card, m.opts)
+	if err != nil {
+		logClose(m.opts.Logger, "context", m.ctx.Close())
+		m.ctx = nil
+
+		return err
+	}
+
+	m.yk = yk
+
+	return nil
+}
+
+// disconnect drops the card, the handle of a reset or removed card is already unusable so
+// errors are ignored.
+func (m *CardManager) disconnect() {
+	if m.yk == nil {
+		return
+	}
+
+	m.yk.tx.Close()
+	m.yk.h.Close()
+	m.yk = nil
+}
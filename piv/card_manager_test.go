@@ -0,0 +1,165 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// newTestCardManager returns a CardManager whose every connect gets a fresh card, and the number of connects.
+func newTestCardManager(t *testing.T) (*CardManager, *int) {
+	t.Helper()
+
+	connects := 0
+	c := &Client{
+		client: &client{},
+		SCConstruct: &TestSCConstructor{
+			Ctx: TestSCContext{
+				ConnectFunc: func(string) (SCHandle, error) {
+					connects++
+
+					return &TestSCHandle{Ctx: basicOpenGPGTx()}, nil
+				},
+			},
+		},
+	}
+
+	return c.NewCardManager("", GPGOpenOptions{}), &connects
+}
+
+func TestCardManager_Do(t *testing.T) {
+	t.Parallel()
+
+	errCommand := errors.New("command failed")
+	// card errors are wrapped like Transmit wraps them.
+	errRemoved := fmt.Errorf("transmit: %w", &scErr{rc: rcRemovedCard})
+
+	tests := []struct {
+		name string
+		// errs are returned by fn in turn, then nil.
+		errs             []error
+		expectErr        error
+		expectCalls      int
+		expectReconnects int
+	}{
+		{name: "ok", expectCalls: 1},
+		{name: "reset", errs: []error{&scErr{rc: rcResetCard}}, expectCalls: 2, expectReconnects: 1},
+		{name: "removed", errs: []error{&scErr{rc: rcRemovedCard}}, expectCalls: 2, expectReconnects: 1},
+		{name: "command error", errs: []error{errCommand}, expectErr: errCommand, expectCalls: 1},
+		{
+			name:             "still removed",
+			errs:             []error{errRemoved, errRemoved},
+			expectErr:        &scErr{rc: rcRemovedCard},
+			expectCalls:      2,
+			expectReconnects: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m, connects := newTestCardManager(t)
+			calls := 0
+
+			err := m.Do(func(yk *GPGYubiKey) error {
+				calls++
+
+				if yk.gpgData == nil {
+					t.Error("card wasn't opened")
+				}
+
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+
+				return nil
+			})
+			if !expectedError(t, err, tc.expectErr) {
+				return
+			}
+
+			if calls != tc.expectCalls {
+				t.Errorf("got %d calls expected %d", calls, tc.expectCalls)
+			}
+
+			if *connects != 1+tc.expectReconnects {
+				t.Errorf("got %d connects expected %d", *connects, 1+tc.expectReconnects)
+			}
+
+			// the card stays connected unless it was lost.
+			before := *connects
+			if err := m.Do(func(*GPGYubiKey) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			if expected := before; tc.expectErr == nil && *connects != expected {
+				t.Errorf("got %d connects expected %d", *connects, expected)
+			}
+
+			if err := m.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.Do(func(*GPGYubiKey) error { return nil }); !errors.Is(err, ErrCardManagerClosed) {
+				t.Errorf("got %v expected %v", err, ErrCardManagerClosed)
+			}
+		})
+	}
+}
+
+func TestCardManager_Serialized(t *testing.T) {
+	t.Parallel()
+
+	m, connects := newTestCardManager(t)
+	defer m.Close()
+
+	// the manager's lock is the only thing protecting count.
+	count := 0
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := m.Do(func(*GPGYubiKey) error {
+				count++
+
+				return nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if count != 16 {
+		t.Errorf("got %d calls expected 16", count)
+	}
+
+	if *connects != 1 {
+		t.Errorf("got %d connects expected 1", *connects)
+	}
+}
@@ -0,0 +1,119 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestCard_Applets(t *testing.T) {
+	t.Parallel()
+
+	card, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenCard(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open card: %v", err)
+	}
+
+	t.Cleanup(func() { card.Close() })
+
+	yk, err := card.PIV()
+	if err != nil {
+		t.Fatalf("piv: %v", err)
+	}
+
+	gpg, err := card.OpenPGP()
+	if err != nil {
+		t.Fatalf("openpgp: %v", err)
+	}
+
+	pivPub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotSignature, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyAlways,
+		TouchPolicy: piv.TouchPolicyNever,
+	})
+	if err != nil {
+		t.Fatalf("piv generate: %v", err)
+	}
+
+	if err := gpg.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	gpgPub, err := gpg.GenerateOpenPGPKey(piv.SignatureKey, piv.AlgorithmEC256)
+	if err != nil {
+		t.Fatalf("openpgp generate: %v", err)
+	}
+
+	pivKey, err := yk.PrivateKey(piv.SlotSignature, pivPub, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+	if err != nil {
+		t.Fatalf("piv private key: %v", err)
+	}
+
+	gpgKey, err := gpg.OpenPGPPrivateKey(piv.SignatureKey, piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatalf("openpgp private key: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("hello"))
+
+	// every signature switches applets.
+	for i := 0; i < 2; i++ {
+		for name, key := range map[string]crypto.Signer{"piv": pivKey.(crypto.Signer), "openpgp": gpgKey.(crypto.Signer)} {
+			sig, err := key.Sign(nil, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Fatalf("%s sign: %v", name, err)
+			}
+
+			if !ecdsa.VerifyASN1(key.Public().(*ecdsa.PublicKey), digest[:], sig) {
+				t.Errorf("%s signature doesn't verify", name)
+			}
+		}
+	}
+
+	if !gpgKey.(crypto.Signer).Public().(*ecdsa.PublicKey).Equal(gpgPub) {
+		t.Error("openpgp public key differs from the generated one")
+	}
+
+	if _, err := yk.Serial(); err != nil {
+		t.Errorf("serial: %v", err)
+	}
+
+	if _, err := gpg.GPGData(); err != nil {
+		t.Errorf("gpg data: %v", err)
+	}
+
+	// closing an applet leaves the card open.
+	yk.Close()
+
+	if _, err := card.SelectApplet(piv.AppletPIV.AID()); err != nil {
+		t.Errorf("select after applet close: %v", err)
+	}
+
+	if err := card.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	if _, err := card.OpenPGP(); !errors.Is(err, piv.ErrCardClosed) {
+		t.Errorf("openpgp after close: %v, expected %v", err, piv.ErrCardClosed)
+	}
+}
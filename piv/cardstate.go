@@ -0,0 +1,344 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CardStateFormatVersion is the version of the CardState format written by this package.
+const CardStateFormatVersion = 1
+
+// CardStateSchema is the JSON schema of the CardState format.
+//
+//go:embed cardstate.schema.json
+var CardStateSchema []byte
+
+// ErrCardStateFormat is returned when recorded card state can't be loaded.
+var ErrCardStateFormat = errors.New("invalid card state")
+
+// CardState is the non-secret state of a card, for asset inventory and compliance checks.
+// Record it with the State methods, store it with Save and compare a live card against it with Diff.
+type CardState struct {
+	FormatVersion int       `json:"formatVersion"`
+	Recorded      time.Time `json:"recorded"`
+	// Reader is informational, Diff ignores it like Recorded.
+	Reader  string            `json:"reader,omitempty"`
+	OpenPGP *OpenPGPCardState `json:"openpgp,omitempty"`
+	PIV     *PIVCardState     `json:"piv,omitempty"`
+}
+
+// OpenPGPCardState is the state of the OpenPGP application.
+type OpenPGPCardState struct {
+	Serial        string `json:"serial"`
+	Manufacturer  string `json:"manufacturer"`
+	Version       string `json:"version"`
+	AppletVersion string `json:"appletVersion,omitempty"`
+	CardHolder    string `json:"cardHolder,omitempty"`
+	// Capabilities are the extended capabilities the card reports.
+	Capabilities map[string]bool `json:"capabilities"`
+	// Keys is keyed by KeyType name, empty slots are left out.
+	Keys map[string]OpenPGPKeyState `json:"keys"`
+}
+
+// OpenPGPKeyState describes one OpenPGP key.
+type OpenPGPKeyState struct {
+	Algorithm   string    `json:"algorithm"`
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+	Origin      string    `json:"origin"`
+	// Certificate is the DER cardholder certificate, on YubiKeys this is the attestation when the key was attested.
+	Certificate []byte `json:"certificate,omitempty"`
+}
+
+// PIVCardState is the state of the PIV application.
+type PIVCardState struct {
+	Serial  uint32 `json:"serial,omitempty"`
+	Version string `json:"version"`
+	// AttestationCertificate is the DER certificate of the attestation key in slot f9.
+	AttestationCertificate []byte `json:"attestationCertificate,omitempty"`
+	// Slots is keyed by the hex slot id, empty slots are left out.
+	Slots map[string]PIVSlotState `json:"slots"`
+}
+
+// PIVSlotState describes the key and certificate in one PIV slot.
+// The policies are only known on cards that support metadata.
+type PIVSlotState struct {
+	Algorithm   string `json:"algorithm,omitempty"`
+	PINPolicy   string `json:"pinPolicy,omitempty"`
+	TouchPolicy string `json:"touchPolicy,omitempty"`
+	Origin      string `json:"origin,omitempty"`
+	// PublicKey is PKIX DER.
+	PublicKey   []byte `json:"publicKey,omitempty"`
+	Certificate []byte `json:"certificate,omitempty"`
+	// Attestation is the DER certificate signed by AttestationCertificate, only generated keys have one.
+	Attestation []byte `json:"attestation,omitempty"`
+}
+
+// CardStateDifference is a value that differs between recorded and live state.
+// A value missing on one side is empty.
+type CardStateDifference struct {
+	// Path is the JSON path of the value, like piv.slots.9a.touchPolicy.
+	Path     string
+	Recorded string
+	Live     string
+}
+
+func (d CardStateDifference) String() string {
+	return fmt.Sprintf("%s: recorded %q, live %q", d.Path, d.Recorded, d.Live)
+}
+
+// NewCardState returns an empty CardState recorded now.
+func NewCardState() *CardState {
+	return &CardState{FormatVersion: CardStateFormatVersion, Recorded: time.Now().UTC()}
+}
+
+// LoadCardState reads a CardState written by Save.
+func LoadCardState(r io.Reader) (*CardState, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var s CardState
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCardStateFormat, err)
+	}
+
+	if s.FormatVersion < 1 || s.FormatVersion > CardStateFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrCardStateFormat, s.FormatVersion)
+	}
+
+	return &s, nil
+}
+
+// Save writes the state as indented JSON.
+func (s *CardState) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(s)
+}
+
+// Diff returns the differences between the recorded state s and live, sorted by path.
+// The recording time, reader and format version are not compared.
+func (s *CardState) Diff(live *CardState) ([]CardStateDifference, error) {
+	recordedValues, err := s.flatten()
+	if err != nil {
+		return nil, err
+	}
+
+	liveValues, err := live.flatten()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []CardStateDifference
+
+	for path, recorded := range recordedValues {
+		if liveValue := liveValues[path]; liveValue != recorded {
+			diffs = append(diffs, CardStateDifference{Path: path, Recorded: recorded, Live: liveValue})
+		}
+	}
+
+	for path, liveValue := range liveValues {
+		if _, ok := recordedValues[path]; !ok {
+			diffs = append(diffs, CardStateDifference{Path: path, Live: liveValue})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+
+	return diffs, nil
+}
+
+// flatten maps the JSON path of every compared value to its JSON encoding.
+func (s *CardState) flatten() (map[string]string, error) {
+	values := map[string]string{}
+	if s == nil {
+		return values, nil
+	}
+
+	compared := struct {
+		OpenPGP *OpenPGPCardState `json:"openpgp,omitempty"`
+		PIV     *PIVCardState     `json:"piv,omitempty"`
+	}{s.OpenPGP, s.PIV}
+
+	data, err := json.Marshal(compared)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, child := range v {
+				walk(strings.TrimPrefix(prefix+"."+k, "."), child)
+			}
+		case string:
+			values[prefix] = v
+		default:
+			b, _ := json.Marshal(v)
+			values[prefix] = string(b)
+		}
+	}
+	walk("", tree)
+
+	return values, nil
+}
+
+// State records the OpenPGP state from the data read when the card was opened and the cardholder certificates.
+func (yk *GPGYubiKey) State() (*OpenPGPCardState, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.State\u001b[0m")
+	}
+
+	g := yk.gpgData
+	if g == nil {
+		return nil, ErrNotFound
+	}
+
+	s := &OpenPGPCardState{
+		Serial:        g.Serial,
+		Manufacturer:  g.Manufacturer,
+		Version:       g.Version,
+		AppletVersion: g.AppletVersion,
+		CardHolder:    g.CardHolder,
+		Capabilities:  g.capabilities(),
+		Keys:          map[string]OpenPGPKeyState{},
+	}
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		if yk.storedFingerprint(keyType) == nil {
+			continue
+		}
+
+		var key OpenPGPKeyState
+
+		key.Fingerprint, _ = g.Fingerprint(keyType)
+		key.Algorithm, _ = g.Algorithm(keyType)
+		key.Created, _ = g.Date(keyType)
+		key.Created = key.Created.UTC()
+
+		if origin, err := g.Origin(keyType); err == nil {
+			key.Origin = origin.String()
+		}
+
+		// cards without SELECT DATA only have a certificate for the authentication key.
+		if g.MaximumCardholderCertificatesLength > 0 && gpgCheckCardholderCertificate(g, keyType) == nil {
+			cert, err := gpgGetCardholderCertificate(yk.tx, keyType, g)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+
+			key.Certificate = cert
+		}
+
+		s.Keys[keyType.String()] = key
+	}
+
+	return s, nil
+}
+
+// State records the PIV state of the standard slots.
+// The policies of the keys are only read on firmware that supports metadata.
+func (yk *YubiKey) State() (*PIVCardState, error) {
+	features := yk.Features()
+	s := &PIVCardState{
+		Version: fmt.Sprintf("%d.%d.%d", yk.version.major, yk.version.minor, yk.version.patch),
+		Slots:   map[string]PIVSlotState{},
+	}
+
+	if serial, err := yk.Serial(); err == nil {
+		s.Serial = serial
+	}
+
+	if features.SupportsAttestation() && !yk.quirks.NoAttestation {
+		cert, err := yk.AttestationCertificate()
+		if err != nil && !pivSlotEmpty(err) {
+			return nil, fmt.Errorf("attestation certificate: %w", err)
+		}
+
+		if cert != nil {
+			s.AttestationCertificate = cert.Raw
+		}
+	}
+
+	for _, slot := range []Slot{SlotAuthentication, SlotSignature, SlotKeyManagement, SlotCardAuthentication} {
+		var slotState PIVSlotState
+
+		if features.SupportsMetadata() {
+			ki, err := yk.KeyInfo(slot)
+			if err != nil && !pivSlotEmpty(err) {
+				return nil, fmt.Errorf("slot %s metadata: %w", slot, err)
+			}
+
+			if err == nil {
+				slotState.Algorithm = ki.Algorithm.String()
+				slotState.PINPolicy = ki.PINPolicy.String()
+				slotState.TouchPolicy = ki.TouchPolicy.String()
+				slotState.Origin = ki.Origin.String()
+
+				if slotState.PublicKey, err = x509.MarshalPKIXPublicKey(ki.PublicKey); err != nil {
+					return nil, fmt.Errorf("slot %s public key: %w", slot, err)
+				}
+			}
+		}
+
+		cert, err := yk.Certificate(slot)
+		if err != nil && !pivSlotEmpty(err) {
+			return nil, fmt.Errorf("slot %s certificate: %w", slot, err)
+		}
+
+		if cert != nil {
+			slotState.Certificate = cert.Raw
+		}
+
+		if s.AttestationCertificate != nil {
+			attestation, err := yk.Attest(slot)
+			if err != nil && !pivSlotEmpty(err) {
+				return nil, fmt.Errorf("slot %s attestation: %w", slot, err)
+			}
+
+			if attestation != nil {
+				slotState.Attestation = attestation.Raw
+			}
+		}
+
+		if slotState.Algorithm != "" || slotState.Certificate != nil || slotState.Attestation != nil {
+			s.Slots[slot.String()] = slotState
+		}
+	}
+
+	return s, nil
+}
+
+// pivSlotEmpty reports whether err is the card saying a slot has no key or object.
+func pivSlotEmpty(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
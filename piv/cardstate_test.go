@@ -0,0 +1,251 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func testCardState() *CardState {
+	s := NewCardState()
+	s.OpenPGP = &OpenPGPCardState{
+		Serial:       "12345678",
+		Manufacturer: "0006Yubico",
+		Version:      "3.4",
+		Capabilities: map[string]bool{"keyImport": true},
+		Keys: map[string]OpenPGPKeyState{
+			"Signature": {Algorithm: "RSA 2048", Fingerprint: strings.Repeat("AB", keyFingerprintLen), Origin: "KeyGeneratedByCard"},
+		},
+	}
+	s.PIV = &PIVCardState{
+		Serial:  12345678,
+		Version: "5.7.1",
+		Slots: map[string]PIVSlotState{
+			"9a": {Algorithm: "EC256", PINPolicy: "once", TouchPolicy: "never", Origin: "generated"},
+			"9c": {Algorithm: "EC256", PINPolicy: "always", TouchPolicy: "always", Origin: "generated"},
+		},
+	}
+
+	return s
+}
+
+func TestCardState_Diff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		modify func(s *CardState)
+		expect []CardStateDifference
+	}{
+		{
+			name:   "same",
+			modify: func(s *CardState) { s.Recorded = s.Recorded.Add(time.Hour); s.Reader = "other reader" },
+		},
+		{
+			name: "changed policy",
+			modify: func(s *CardState) {
+				s.PIV.Slots["9a"] = PIVSlotState{Algorithm: "EC256", PINPolicy: "once", TouchPolicy: "always", Origin: "generated"}
+			},
+			expect: []CardStateDifference{{Path: "piv.slots.9a.touchPolicy", Recorded: "never", Live: "always"}},
+		},
+		{
+			name:   "removed slot",
+			modify: func(s *CardState) { delete(s.PIV.Slots, "9c") },
+			expect: []CardStateDifference{
+				{Path: "piv.slots.9c.algorithm", Recorded: "EC256"},
+				{Path: "piv.slots.9c.origin", Recorded: "generated"},
+				{Path: "piv.slots.9c.pinPolicy", Recorded: "always"},
+				{Path: "piv.slots.9c.touchPolicy", Recorded: "always"},
+			},
+		},
+		{
+			name: "added key and capability",
+			modify: func(s *CardState) {
+				s.OpenPGP.Capabilities["kdf"] = true
+				s.OpenPGP.Keys["Decryption"] = OpenPGPKeyState{Algorithm: "Alg=18  ", Fingerprint: strings.Repeat("CD", keyFingerprintLen), Origin: "KeyImportedToCard"}
+			},
+			expect: []CardStateDifference{
+				{Path: "openpgp.capabilities.kdf", Live: "true"},
+				{Path: "openpgp.keys.Decryption.algorithm", Live: "Alg=18  "},
+				{Path: "openpgp.keys.Decryption.created", Live: "0001-01-01T00:00:00Z"},
+				{Path: "openpgp.keys.Decryption.fingerprint", Live: strings.Repeat("CD", keyFingerprintLen)},
+				{Path: "openpgp.keys.Decryption.origin", Live: "KeyImportedToCard"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			recorded := testCardState()
+			live := testCardState()
+			tc.modify(live)
+
+			diffs, err := recorded.Diff(live)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(diffs, tc.expect) {
+				t.Errorf("got %v expected %v", diffs, tc.expect)
+			}
+		})
+	}
+}
+
+func TestLoadCardState(t *testing.T) {
+	t.Parallel()
+
+	var saved bytes.Buffer
+	if err := testCardState().Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		data      string
+		expectErr error
+	}{
+		{name: "saved", data: saved.String()},
+		{name: "unknown field", data: `{"formatVersion": 1, "serial": 1}`, expectErr: ErrCardStateFormat},
+		{name: "future version", data: `{"formatVersion": 2}`, expectErr: ErrCardStateFormat},
+		{name: "not json", data: `serial: 1`, expectErr: ErrCardStateFormat},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := LoadCardState(strings.NewReader(tc.data))
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			diffs, err := testCardState().Diff(s)
+			if err != nil || len(diffs) != 0 {
+				t.Errorf("loaded state differs: %v %v", diffs, err)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_State(t *testing.T) {
+	t.Parallel()
+
+	cert := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	fingerprints := append(bytes.Repeat([]byte{0xab}, keyFingerprintLen), make([]byte, 2*keyFingerprintLen)...)
+
+	yk := NewTestGpgYubikey(&GpgData{
+		Serial:                              "12345678",
+		Version:                             "3.4",
+		AppletVersion:                       "5.7.1",
+		ManufacturerID:                      ManufacturerYubico,
+		KeyImportSupported:                  true,
+		MaximumCardholderCertificatesLength: 0x800,
+		tlvValues: bertlv.TLVData{
+			keyInformationTag:                  fingerprints,
+			keyDateTag:                         []byte{0x65, 0x93, 0x7d, 0x25, 0, 0, 0, 0, 0, 0, 0, 0},
+			keyAlgorithmSignatureAttributesTag: []byte{0x13, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07},
+		},
+	}, false, map[KeyType]KeyOrigin{SignatureKey: KeyGeneratedByCard})
+	yk.tx = &TestSCTx{
+		APDUList: []apdu{
+			{instruction: insSelectData, param1: 2, param2: 0x04, data: []byte{0x60, 0x04, 0x5c, 0x02, 0x7f, 0x21}},
+			{instruction: insGetDataA, param1: 0x7f, param2: 0x21},
+		},
+		ResponseList: [][]byte{{}, cert},
+	}
+
+	s, err := yk.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := OpenPGPKeyState{
+		Algorithm:   "ECDSA P-256",
+		Fingerprint: strings.Repeat("AB", keyFingerprintLen),
+		Created:     time.Unix(testOpenPGPCreated, 0).UTC(),
+		Origin:      "KeyGeneratedByCard",
+		Certificate: cert,
+	}
+
+	if len(s.Keys) != 1 || !reflect.DeepEqual(s.Keys["Signature"], expected) {
+		t.Errorf("got keys %+v expected Signature %+v", s.Keys, expected)
+	}
+
+	if s.Serial != "12345678" || !s.Capabilities["keyImport"] || s.Capabilities["kdf"] {
+		t.Errorf("got %+v", s)
+	}
+}
+
+// TestCardStateSchema keeps the schema in step with the json tags.
+func TestCardStateSchema(t *testing.T) {
+	t.Parallel()
+
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+
+	if err := json.Unmarshal(CardStateSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		value      any
+		properties map[string]json.RawMessage
+	}{
+		{"CardState", CardState{}, schema.Properties},
+		{"openpgp", OpenPGPCardState{}, schema.Defs["openpgp"].Properties},
+		{"openpgpKey", OpenPGPKeyState{}, schema.Defs["openpgpKey"].Properties},
+		{"piv", PIVCardState{}, schema.Defs["piv"].Properties},
+		{"pivSlot", PIVSlotState{}, schema.Defs["pivSlot"].Properties},
+	} {
+		var tags, properties []string
+
+		typ := reflect.TypeOf(tc.value)
+		for i := 0; i < typ.NumField(); i++ {
+			tags = append(tags, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+		}
+
+		for p := range tc.properties {
+			properties = append(properties, p)
+		}
+
+		sort.Strings(tags)
+		sort.Strings(properties)
+
+		if !reflect.DeepEqual(tags, properties) {
+			t.Errorf("%s: json tags %v schema properties %v", tc.name, tags, properties)
+		}
+	}
+}
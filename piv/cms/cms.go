@@ -0,0 +1,139 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cms creates and opens CMS (RFC 5652) messages, the PKCS#7 format of S/MIME and code signing,
+// with keys on a YubiKey.
+//
+//   - Sign produces SignedData with a crypto.Signer, e.g. the key in the signature slot, and
+//     ParseSignedData with SignedData.Verify checks it.
+//   - Encrypt produces EnvelopedData with a KeyTransRecipientInfo for each RSA recipient certificate and
+//     Decrypt opens it with a crypto.Decrypter, e.g. the RSA key in the key management slot.
+//
+// Messages are DER, `openssl cms` reads them with `-inform DER`. BER with indefinite lengths, which
+// streaming encoders write, isn't supported.
+package cms
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrMalformed is returned for messages that aren't valid DER CMS.
+	ErrMalformed = errors.New("malformed CMS message")
+	// ErrUnsupportedAlgorithm is returned for keys and algorithms that can't be used.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrNoRecipient is returned by Decrypt when the message has no recipient info for the certificate.
+	ErrNoRecipient = errors.New("message isn't encrypted to the certificate")
+	// ErrVerification is returned by Verify when a signature doesn't match.
+	ErrVerification = errors.New("signature verification failed")
+)
+
+// nolint:gochecknoglobals
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// nolint:gochecknoglobals
+var digestAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA256: oidSHA256,
+	crypto.SHA384: oidSHA384,
+	crypto.SHA512: oidSHA512,
+}
+
+// contentInfo is the outer structure of every message.
+// https://www.rfc-editor.org/rfc/rfc5652#section-3
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// issuerAndSerialNumber identifies a certificate.
+// https://www.rfc-editor.org/rfc/rfc5652#section-10.2.4
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+func (i issuerAndSerialNumber) matches(issuer []byte, serial *big.Int) bool {
+	return string(i.Issuer.FullBytes) == string(issuer) && i.SerialNumber.Cmp(serial) == 0
+}
+
+// attribute is a signed attribute, each has a single value here.
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content any) ([]byte, error) {
+	b, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{ContentType: contentType, Content: explicit(0, b)})
+}
+
+func parseContentInfo(der []byte, contentType asn1.ObjectIdentifier, content any) error {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) != 0 {
+		return fmt.Errorf("%w: content info", ErrMalformed)
+	}
+
+	if !ci.ContentType.Equal(contentType) {
+		return fmt.Errorf("%w: content type %s, expected %s", ErrMalformed, ci.ContentType, contentType)
+	}
+
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, content); err != nil || len(rest) != 0 {
+		return fmt.Errorf("%w: %s content", ErrMalformed, contentType)
+	}
+
+	return nil
+}
+
+// explicit tags der, asn1 ignores the explicit field tag when marshaling a RawValue.
+func explicit(tag int, der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der}
+}
+
+// algorithm returns an AlgorithmIdentifier with NULL parameters.
+func algorithm(oid asn1.ObjectIdentifier) pkix.AlgorithmIdentifier {
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}
+}
@@ -0,0 +1,223 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// newCardKey generates a key in slot of a simulated card and returns it with a certificate for it.
+func newCardKey(t *testing.T, slot piv.Slot, alg piv.Algorithm) (*x509.Certificate, crypto.PrivateKey) {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, slot, piv.Key{
+		Algorithm:   alg,
+		PINPolicy:   piv.PINPolicyOnce,
+		TouchPolicy: piv.TouchPolicyNever,
+	})
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	priv, err := yk.PrivateKey(slot, pub, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+	if err != nil {
+		t.Fatalf("private key: %v", err)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// recipients are identified by the issuer and serial number.
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Issuer:       pkix.Name{CommonName: "CA"},
+		Subject:      pkix.Name{CommonName: alg.String()},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, caKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, priv
+}
+
+func TestSignVerify(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("signed by the card")
+
+	tests := []struct {
+		name     string
+		alg      piv.Algorithm
+		detached bool
+	}{
+		{name: "ec256", alg: piv.AlgorithmEC256},
+		{name: "ec384 detached", alg: piv.AlgorithmEC384, detached: true},
+		{name: "rsa2048", alg: piv.AlgorithmRSA2048},
+		{name: "rsa2048 detached", alg: piv.AlgorithmRSA2048, detached: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cert, priv := newCardKey(t, piv.SlotSignature, tc.alg)
+
+			der, err := Sign(content, cert, priv.(crypto.Signer), SignOptions{Detached: tc.detached})
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+
+			sd, err := ParseSignedData(der)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			if (sd.Content == nil) != tc.detached || (!tc.detached && !bytes.Equal(sd.Content, content)) {
+				t.Errorf("content %q, detached %v", sd.Content, tc.detached)
+			}
+
+			signers, err := sd.Verify(content)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+
+			if len(signers) != 1 || !signers[0].Equal(cert) {
+				t.Errorf("signers %v, expected the certificate", signers)
+			}
+
+			if tc.detached {
+				if _, err := sd.Verify([]byte("other content")); !errors.Is(err, ErrVerification) {
+					t.Errorf("verify other content: %v, expected %v", err, ErrVerification)
+				}
+			}
+		})
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	t.Parallel()
+
+	cert, priv := newCardKey(t, piv.SlotSignature, piv.AlgorithmEC256)
+	other, _ := newCardKey(t, piv.SlotSignature, piv.AlgorithmEC256)
+
+	if _, err := Sign([]byte("content"), other, priv.(crypto.Signer), SignOptions{}); !errors.Is(err, ErrCertificateMismatch) {
+		t.Errorf("sign with other certificate: %v, expected %v", err, ErrCertificateMismatch)
+	}
+
+	der, err := Sign([]byte("content"), cert, priv.(crypto.Signer), SignOptions{})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// the content is at the end of the encapsulated content info, before the certificates.
+	tampered := bytes.Replace(der, []byte("content"), []byte("CONTENT"), 1)
+
+	sd, err := ParseSignedData(tampered)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if _, err := sd.Verify(nil); !errors.Is(err, ErrVerification) {
+		t.Errorf("verify tampered: %v, expected %v", err, ErrVerification)
+	}
+
+	if _, err := ParseSignedData(der[:len(der)-1]); !errors.Is(err, ErrMalformed) {
+		t.Errorf("parse truncated: %v, expected %v", err, ErrMalformed)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	cert, priv := newCardKey(t, piv.SlotKeyManagement, piv.AlgorithmRSA2048)
+	other, otherPriv := newCardKey(t, piv.SlotKeyManagement, piv.AlgorithmRSA2048)
+	ecCert, _ := newCardKey(t, piv.SlotKeyManagement, piv.AlgorithmEC256)
+
+	for _, content := range [][]byte{{}, []byte("sixteen bytes!!!"), bytes.Repeat([]byte("enveloped"), 1000)} {
+		der, err := Encrypt(content, other, cert)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+
+		for _, recipient := range []struct {
+			cert *x509.Certificate
+			key  crypto.PrivateKey
+		}{{cert, priv}, {other, otherPriv}} {
+			got, err := Decrypt(der, recipient.cert, recipient.key.(crypto.Decrypter))
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+
+			if !bytes.Equal(got, content) {
+				t.Errorf("decrypted %d bytes, expected %d", len(got), len(content))
+			}
+		}
+	}
+
+	der, err := Encrypt([]byte("content"), other)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	if _, err := Decrypt(der, cert, priv.(crypto.Decrypter)); !errors.Is(err, ErrNoRecipient) {
+		t.Errorf("decrypt for other recipient: %v, expected %v", err, ErrNoRecipient)
+	}
+
+	if _, err := Encrypt([]byte("content"), ecCert); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("encrypt to EC key: %v, expected %v", err, ErrUnsupportedAlgorithm)
+	}
+
+	if _, err := Decrypt([]byte("garbage"), cert, priv.(crypto.Decrypter)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("decrypt garbage: %v, expected %v", err, ErrMalformed)
+	}
+}
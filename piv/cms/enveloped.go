@@ -0,0 +1,204 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+)
+
+// https://www.rfc-editor.org/rfc/rfc5652#section-6.1
+type envelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// https://www.rfc-editor.org/rfc/rfc5652#section-6.2.1
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"optional,tag:0"`
+}
+
+// nolint:gochecknoglobals
+var contentKeySizes = map[string]int{
+	oidAES128CBC.String(): 16,
+	oidAES192CBC.String(): 24,
+	oidAES256CBC.String(): 32,
+}
+
+// Encrypt returns EnvelopedData with content encrypted with AES-256-CBC, the content key is encrypted
+// with PKCS#1 v1.5 to the RSA key of each recipient certificate.
+func Encrypt(content []byte, recipients ...*x509.Certificate) ([]byte, error) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+
+	for _, b := range [][]byte{key, iv} {
+		if _, err := io.ReadFull(rand.Reader, b); err != nil {
+			return nil, err
+		}
+	}
+
+	ed := envelopedData{}
+
+	for _, cert := range recipients {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: recipient key %T", ErrUnsupportedAlgorithm, cert.PublicKey)
+		}
+
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+
+		ri, err := asn1.Marshal(keyTransRecipientInfo{
+			RID:                    issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			KeyEncryptionAlgorithm: algorithm(oidRSAEncryption),
+			EncryptedKey:           encryptedKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ed.RecipientInfos = append(ed.RecipientInfos, asn1.RawValue{FullBytes: ri})
+	}
+
+	if len(ed.RecipientInfos) == 0 {
+		return nil, fmt.Errorf("%w: no recipients", ErrNoRecipient)
+	}
+
+	params, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#7 padding, a full block if the content is aligned.
+	pad := aes.BlockSize - len(content)%aes.BlockSize
+	encrypted := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	ed.EncryptedContentInfo = encryptedContentInfo{
+		ContentType:                oidData,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedContent:           encrypted,
+	}
+
+	return marshalContentInfo(oidEnvelopedData, ed)
+}
+
+// Decrypt returns the content of EnvelopedData encrypted to cert, key decrypts the content key,
+// e.g. the RSA key of the key management slot with the certificate of the slot.
+// The content can be encrypted with AES-128, AES-192 or AES-256 in CBC mode.
+func Decrypt(der []byte, cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ed envelopedData
+	if err := parseContentInfo(der, oidEnvelopedData, &ed); err != nil {
+		return nil, err
+	}
+
+	var ri *keyTransRecipientInfo
+
+	for _, raw := range ed.RecipientInfos {
+		// other recipient info types are tagged.
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			continue
+		}
+
+		var ktri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ktri); err != nil {
+			// a subject key identifier instead of the issuer and serial number.
+			continue
+		}
+
+		if ktri.RID.matches(cert.RawIssuer, cert.SerialNumber) {
+			ri = &ktri
+
+			break
+		}
+	}
+
+	if ri == nil {
+		return nil, ErrNoRecipient
+	}
+
+	if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption) {
+		return nil, fmt.Errorf("%w: key encryption %s", ErrUnsupportedAlgorithm, ri.KeyEncryptionAlgorithm.Algorithm)
+	}
+
+	contentKey, err := key.Decrypt(rand.Reader, ri.EncryptedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt content key: %w", err)
+	}
+
+	eci := ed.EncryptedContentInfo
+
+	keySize, ok := contentKeySizes[eci.ContentEncryptionAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("%w: content encryption %s", ErrUnsupportedAlgorithm, eci.ContentEncryptionAlgorithm.Algorithm)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: content encryption parameters", ErrMalformed)
+	}
+
+	if len(contentKey) != keySize {
+		return nil, fmt.Errorf("%w: content key size %d", ErrMalformed, len(contentKey))
+	}
+
+	encrypted := eci.EncryptedContent
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: encrypted content size %d", ErrMalformed, len(encrypted))
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	content := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, encrypted)
+
+	pad := int(content[len(content)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(content[len(content)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("%w: padding", ErrMalformed)
+	}
+
+	return content[:len(content)-pad], nil
+}
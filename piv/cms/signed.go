@@ -0,0 +1,330 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrCertificateMismatch is returned by Sign when the certificate isn't for the key of the signer.
+var ErrCertificateMismatch = errors.New("certificate doesn't match the signer")
+
+// SignOptions are the options of Sign.
+type SignOptions struct {
+	// Detached leaves the content out of the message, like `openssl cms -sign` without `-nodetach`.
+	Detached bool
+	// Certificates are added to the message after the signer certificate, e.g. intermediates.
+	Certificates []*x509.Certificate
+	// SigningTime is the signing time attribute, the current time if zero.
+	SigningTime time.Time
+}
+
+// https://www.rfc-editor.org/rfc/rfc5652#section-5.1
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"optional,explicit,tag:0"`
+}
+
+// https://www.rfc-editor.org/rfc/rfc5652#section-5.3
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// Sign returns SignedData for content signed by signer, with the content type, message digest and
+// signing time as signed attributes. cert is the certificate of the key of signer, e.g. from
+// YubiKey.Certificate(SlotSignature), it's included in the message. RSA keys sign with PKCS#1 v1.5.
+func Sign(content []byte, cert *x509.Certificate, signer crypto.Signer, opts SignOptions) ([]byte, error) {
+	pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return nil, ErrCertificateMismatch
+	}
+
+	hash, sigAlg, err := signatureAlgorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	signingTime := opts.SigningTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	attrs, err := signedAttributes(
+		attributeValue{oidAttributeContentType, oidData},
+		attributeValue{oidAttributeMessageDigest, digest(hash, content)},
+		attributeValue{oidAttributeSigningTime, signingTime.UTC()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signer.Sign(rand.Reader, digest(hash, attributeSet(attrs)), hash)
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{cert}, opts.Certificates...) {
+		certs = append(certs, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{algorithm(digestAlgorithms[hash])},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			DigestAlgorithm:    algorithm(digestAlgorithms[hash]),
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}
+
+	if !opts.Detached {
+		// an empty content still has to be there.
+		sd.EncapContentInfo.EContent = append([]byte{}, content...)
+	}
+
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// signatureAlgorithm returns the digest and signature algorithm for a key, the digest size follows the curve.
+func signatureAlgorithm(pub crypto.PublicKey) (crypto.Hash, pkix.AlgorithmIdentifier, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return crypto.SHA256, algorithm(oidSHA256WithRSA), nil
+	case *ecdsa.PublicKey:
+		// ECDSA algorithm identifiers have no parameters.
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return crypto.SHA256, pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+		case 384:
+			return crypto.SHA384, pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA384}, nil
+		}
+	}
+
+	return 0, pkix.AlgorithmIdentifier{}, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, pub)
+}
+
+// attributeValue is an attribute before encoding.
+type attributeValue struct {
+	typ   asn1.ObjectIdentifier
+	value any
+}
+
+// signedAttributes returns the DER of the attributes in the order of a DER SET OF.
+func signedAttributes(values ...attributeValue) ([]byte, error) {
+	encoded := make([][]byte, 0, len(values))
+
+	for _, av := range values {
+		v, err := asn1.Marshal(av.value)
+		if err != nil {
+			return nil, err
+		}
+
+		a, err := asn1.Marshal(attribute{Type: av.typ, Values: []asn1.RawValue{{FullBytes: v}}})
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = append(encoded, a)
+	}
+
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+
+	return bytes.Join(encoded, nil), nil
+}
+
+// attributeSet is the DER the signature covers, the attributes with a SET tag instead of the implicit [0].
+func attributeSet(attrs []byte) []byte {
+	b, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+
+	return b
+}
+
+func digest(hash crypto.Hash, b []byte) []byte {
+	h := hash.New()
+	h.Write(b)
+
+	return h.Sum(nil)
+}
+
+// SignedData is a parsed SignedData message.
+type SignedData struct {
+	// Content is nil for detached signatures.
+	Content []byte
+	// Certificates are the certificates in the message, they aren't verified.
+	Certificates []*x509.Certificate
+
+	sd signedData
+}
+
+// ParseSignedData parses a DER SignedData message.
+func ParseSignedData(der []byte) (*SignedData, error) {
+	var sd signedData
+	if err := parseContentInfo(der, oidSignedData, &sd); err != nil {
+		return nil, err
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	return &SignedData{Content: sd.EncapContentInfo.EContent, Certificates: certs, sd: sd}, nil
+}
+
+// Verify checks the signatures of all signers and returns their certificates, which the caller has to
+// verify, e.g. with x509.Certificate.Verify. content is the signed content of a detached signature
+// and ignored otherwise.
+func (s *SignedData) Verify(content []byte) ([]*x509.Certificate, error) {
+	if s.Content != nil {
+		content = s.Content
+	}
+
+	if len(s.sd.SignerInfos) == 0 {
+		return nil, fmt.Errorf("%w: no signers", ErrVerification)
+	}
+
+	signers := make([]*x509.Certificate, 0, len(s.sd.SignerInfos))
+
+	for _, si := range s.sd.SignerInfos {
+		cert, err := s.verify(si, content)
+		if err != nil {
+			return nil, err
+		}
+
+		signers = append(signers, cert)
+	}
+
+	return signers, nil
+}
+
+func (s *SignedData) verify(si signerInfo, content []byte) (*x509.Certificate, error) {
+	var cert *x509.Certificate
+
+	for _, c := range s.Certificates {
+		if si.SID.matches(c.RawIssuer, c.SerialNumber) {
+			cert = c
+		}
+	}
+
+	if cert == nil {
+		return nil, fmt.Errorf("%w: no certificate for the signer", ErrVerification)
+	}
+
+	var hash crypto.Hash
+
+	for h, oid := range digestAlgorithms {
+		if si.DigestAlgorithm.Algorithm.Equal(oid) {
+			hash = h
+		}
+	}
+
+	algorithms := map[x509.PublicKeyAlgorithm]map[crypto.Hash]x509.SignatureAlgorithm{
+		x509.RSA:   {crypto.SHA256: x509.SHA256WithRSA, crypto.SHA384: x509.SHA384WithRSA, crypto.SHA512: x509.SHA512WithRSA},
+		x509.ECDSA: {crypto.SHA256: x509.ECDSAWithSHA256, crypto.SHA384: x509.ECDSAWithSHA384, crypto.SHA512: x509.ECDSAWithSHA512},
+	}
+
+	sigAlg, ok := algorithms[cert.PublicKeyAlgorithm][hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s with %s", ErrUnsupportedAlgorithm, si.DigestAlgorithm.Algorithm, cert.PublicKeyAlgorithm)
+	}
+
+	signed := content
+
+	if len(si.SignedAttrs.Bytes) != 0 {
+		if err := checkSignedAttributes(si.SignedAttrs.Bytes, s.sd.EncapContentInfo.EContentType, digest(hash, content)); err != nil {
+			return nil, err
+		}
+
+		signed = attributeSet(si.SignedAttrs.Bytes)
+	}
+
+	if err := cert.CheckSignature(sigAlg, signed, si.Signature); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrVerification, err)
+	}
+
+	return cert, nil
+}
+
+// checkSignedAttributes checks the content type and message digest attributes, which are required.
+// https://www.rfc-editor.org/rfc/rfc5652#section-5.3
+func checkSignedAttributes(attrs []byte, contentType asn1.ObjectIdentifier, messageDigest []byte) error {
+	var foundType, foundDigest bool
+
+	for rest := attrs; len(rest) != 0; {
+		var (
+			a   attribute
+			err error
+		)
+
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil || len(a.Values) != 1 {
+			return fmt.Errorf("%w: signed attributes", ErrMalformed)
+		}
+
+		switch {
+		case a.Type.Equal(oidAttributeContentType):
+			var oid asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &oid); err != nil || !oid.Equal(contentType) {
+				return fmt.Errorf("%w: content type attribute", ErrVerification)
+			}
+
+			foundType = true
+		case a.Type.Equal(oidAttributeMessageDigest):
+			var md []byte
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &md); err != nil || !bytes.Equal(md, messageDigest) {
+				return fmt.Errorf("%w: message digest", ErrVerification)
+			}
+
+			foundDigest = true
+		}
+	}
+
+	if !foundType || !foundDigest {
+		return fmt.Errorf("%w: missing signed attributes", ErrVerification)
+	}
+
+	return nil
+}
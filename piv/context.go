@@ -0,0 +1,271 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrCommandPending is returned while a command abandoned by a context still waits for the card.
+// Call BeginTransaction, which waits for it, before using the card again.
+var ErrCommandPending = errors.New("a command canceled by its context is still waiting for the card")
+
+// ContextSigner is a crypto.Signer that can be canceled, the private keys of this package implement it.
+type ContextSigner interface {
+	crypto.Signer
+	SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// ContextDecrypter is a crypto.Decrypter that can be canceled, the RSA keys of this package implement it.
+type ContextDecrypter interface {
+	crypto.Decrypter
+	DecryptContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error)
+}
+
+var (
+	_ ContextSigner    = (*ECDSAPrivateKey)(nil)
+	_ ContextSigner    = (*keyEd25519)(nil)
+	_ ContextSigner    = (*keyRSA)(nil)
+	_ ContextDecrypter = (*keyRSA)(nil)
+	_ ContextSigner    = (*openPGPSigner)(nil)
+	_ ContextDecrypter = (*openPGPDecrypter)(nil)
+)
+
+// runContext runs f and returns ctx.Err() when ctx is done first, context.DeadlineExceeded after a timeout.
+// A command sent to the card can't be interrupted, it keeps waiting for a touch in the background and the
+// transaction is ended with end when it returns, so other programs can use the card. *pending is closed
+// after that, BeginTransaction waits for it before the card is used again.
+//
+// *tx is wrapped while f runs. Once f is abandoned, every new command fails with ErrCommandPending
+// until f has returned, the remaining commands of f included, so nothing is sent between the abandoned
+// command and its late answer. waitPending removes the wrapper.
+func runContext[T any](ctx context.Context, tx *SCTx, pending *chan struct{}, end func() error, f func() (T, error)) (T, error) {
+	var zero T
+
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	if *pending != nil {
+		select {
+		case <-*pending:
+			waitPending(pending, tx)
+		default:
+			return zero, ErrCommandPending
+		}
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+
+	guard := &contextTx{SCTx: *tx}
+	*tx = guard
+
+	done := make(chan result, 1)
+
+	go func() {
+		v, err := f()
+		done <- result{v: v, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		*tx = guard.SCTx
+
+		return r.v, r.err
+	case <-ctx.Done():
+	}
+
+	guard.abandon()
+
+	abandoned := make(chan struct{})
+	*pending = abandoned
+
+	go func() {
+		<-done
+		_ = end()
+
+		guard.finish()
+		close(abandoned)
+	}()
+
+	return zero, ctx.Err()
+}
+
+// waitPending waits for a command abandoned by runContext and removes the wrapper from *tx.
+func waitPending(pending *chan struct{}, tx *SCTx) {
+	if *pending == nil {
+		return
+	}
+
+	<-*pending
+	*pending = nil
+
+	if guard, ok := (*tx).(*contextTx); ok {
+		*tx = guard.SCTx
+	}
+}
+
+// contextTx is the transport while runContext runs a command, it refuses new commands
+// after the command was abandoned until it has returned.
+type contextTx struct {
+	SCTx
+
+	mu      sync.Mutex
+	pending bool
+}
+
+func (t *contextTx) abandon() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = true
+}
+
+func (t *contextTx) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = false
+}
+
+func (t *contextTx) check() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending {
+		return ErrCommandPending
+	}
+
+	return nil
+}
+
+func (t *contextTx) Transmit(d apdu) ([]byte, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	return t.SCTx.Transmit(d)
+}
+
+func (t *contextTx) TransmitBytes(req []byte) (bool, []byte, error) {
+	if err := t.check(); err != nil {
+		return false, nil, err
+	}
+
+	return t.SCTx.TransmitBytes(req)
+}
+
+// unwrapContextTx returns the transport wrapped by runContext.
+func unwrapContextTx(tx SCTx) SCTx {
+	if guard, ok := tx.(*contextTx); ok {
+		return guard.SCTx
+	}
+
+	return tx
+}
+
+// AttestContext is Attest that returns ctx.Err() when ctx is done before the card answers.
+// The transaction is ended, call BeginTransaction before using the card again.
+func (yk *YubiKey) AttestContext(ctx context.Context, slot Slot) (*x509.Certificate, error) {
+	return runContext(ctx, &yk.tx, &yk.pending, yk.EndTransaction, func() (*x509.Certificate, error) {
+		return yk.Attest(slot)
+	})
+}
+
+// GenerateKeyContext is GenerateKey that returns ctx.Err() when ctx is done before the card answers,
+// a key with TouchPolicyAlways waits for a touch. The transaction is ended, call BeginTransaction
+// before using the card again.
+func (yk *YubiKey) GenerateKeyContext(ctx context.Context, key [24]byte, slot Slot, opts Key) (crypto.PublicKey, error) {
+	return runContext(ctx, &yk.tx, &yk.pending, yk.EndTransaction, func() (crypto.PublicKey, error) {
+		return yk.GenerateKey(key, slot, opts)
+	})
+}
+
+// SignContext is Sign that returns ctx.Err() when ctx is done before the card answers, for example
+// when nobody touches a key with TouchPolicyAlways. The transaction is ended, call
+// YubiKey.BeginTransaction before using the card again.
+func (k *ECDSAPrivateKey) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return runContext(ctx, &k.yk.tx, &k.yk.pending, k.yk.EndTransaction, func() ([]byte, error) {
+		return k.sign(ctx, digest)
+	})
+}
+
+func (k *keyEd25519) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return runContext(ctx, &k.yk.tx, &k.yk.pending, k.yk.EndTransaction, func() ([]byte, error) {
+		return k.sign(ctx, digest)
+	})
+}
+
+func (k *keyRSA) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return runContext(ctx, &k.yk.tx, &k.yk.pending, k.yk.EndTransaction, func() ([]byte, error) {
+		return k.sign(ctx, rand, digest, opts)
+	})
+}
+
+func (k *keyRSA) DecryptContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return runContext(ctx, &k.yk.tx, &k.yk.pending, k.yk.EndTransaction, func() ([]byte, error) {
+		return k.decrypt(ctx, msg, opts)
+	})
+}
+
+// AttestOpenPGPContext is AttestOpenPGP that returns ctx.Err() when ctx is done before the card answers.
+// The transaction is ended, call BeginTransaction before using the card again.
+func (yk *GPGYubiKey) AttestOpenPGPContext(ctx context.Context, slot KeyType) (*x509.Certificate, error) {
+	return runContext(ctx, &yk.tx, &yk.pending, yk.EndTransaction, func() (*x509.Certificate, error) {
+		return yk.AttestOpenPGP(slot)
+	})
+}
+
+// GenerateOpenPGPKeyContext is GenerateOpenPGPKey that returns ctx.Err() when ctx is done before the card
+// answers. The transaction is ended, call BeginTransaction before using the card again.
+// The card is given at most Quirks().KeyGenerationTimeout to generate the key.
+func (yk *GPGYubiKey) GenerateOpenPGPKeyContext(ctx context.Context, slot KeyType, alg Algorithm) (crypto.PublicKey, error) {
+	if yk.gpgData != nil && yk.gpgData.Quirks.KeyGenerationTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, yk.gpgData.Quirks.KeyGenerationTimeout)
+		defer cancel()
+	}
+
+	return runContext(ctx, &yk.tx, &yk.pending, yk.EndTransaction, func() (crypto.PublicKey, error) {
+		return yk.GenerateOpenPGPKey(slot, alg)
+	})
+}
+
+// SignContext is Sign that returns ctx.Err() when ctx is done before the card answers, for example
+// when nobody touches a key with a touch policy. The transaction is ended, call
+// GPGYubiKey.BeginTransaction before using the card again.
+func (s *openPGPSigner) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return runContext(ctx, &s.yk.tx, &s.yk.pending, s.yk.EndTransaction, func() ([]byte, error) {
+		return s.Sign(rand, digest, opts)
+	})
+}
+
+// DecryptContext is Decrypt that returns ctx.Err() when ctx is done before the card answers.
+// The transaction is ended, call GPGYubiKey.BeginTransaction before using the card again.
+func (d *openPGPDecrypter) DecryptContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return runContext(ctx, &d.yk.tx, &d.yk.pending, d.yk.EndTransaction, func() ([]byte, error) {
+		return d.Decrypt(rand, msg, opts)
+	})
+}
@@ -0,0 +1,154 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingTestTx is a transactionTestTx whose first command waits for release, like a key waiting for a touch.
+type blockingTestTx struct {
+	*transactionTestTx
+	release chan struct{}
+	blocked bool
+}
+
+var errTouchTimeout = errors.New("touch timeout")
+
+func (tx *blockingTestTx) Transmit(d apdu) ([]byte, error) {
+	if !tx.blocked {
+		tx.blocked = true
+		<-tx.release
+
+		return nil, errTouchTimeout
+	}
+
+	return tx.transactionTestTx.Transmit(d)
+}
+
+func TestRunContext(t *testing.T) {
+	t.Parallel()
+
+	var (
+		pending chan struct{}
+		tx      SCTx = &TestSCTx{}
+	)
+
+	end := func() error { return nil }
+
+	v, err := runContext(context.Background(), &tx, &pending, end, func() (int, error) { return 1, nil })
+	if v != 1 || err != nil || pending != nil {
+		t.Errorf("got %d %v %v expected 1", v, err, pending)
+	}
+
+	if _, ok := tx.(*TestSCTx); !ok {
+		t.Errorf("got transport %T after the command", tx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+
+	_, err = runContext(ctx, &tx, &pending, end, func() (int, error) {
+		called = true
+
+		return 1, nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Errorf("got %v called %t expected %v", err, called, context.Canceled)
+	}
+}
+
+func TestGPGYubiKey_AttestOpenPGPContext(t *testing.T) {
+	t.Parallel()
+
+	tx := &blockingTestTx{
+		transactionTestTx: &transactionTestTx{TestSCTx: &TestSCTx{
+			APDUList:     []apdu{{instruction: insSelectApplication, param1: paramOpenGPGASelectApplication, data: aidOpenPGP[:]}},
+			ResponseList: [][]byte{nil},
+		}},
+		release: make(chan struct{}),
+	}
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = tx
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := yk.AttestOpenPGPContext(ctx, SignatureKey); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+
+	// nothing is sent while the abandoned command waits for the card.
+	if _, err := yk.tx.Transmit(apdu{instruction: insGetData}); !errors.Is(err, ErrCommandPending) {
+		t.Errorf("got %v expected %v", err, ErrCommandPending)
+	}
+
+	if _, err := yk.AttestOpenPGPContext(context.Background(), SignatureKey); !errors.Is(err, ErrCommandPending) {
+		t.Errorf("got %v expected %v", err, ErrCommandPending)
+	}
+
+	// the abandoned command finishes, then the transaction is ended before it begins again.
+	close(tx.release)
+
+	if err := yk.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.ended != 1 || tx.begun != 1 || tx.CurrentAPDUIndex != 1 {
+		t.Errorf("got %d ended %d begun %d apdus expected 1 of each", tx.ended, tx.begun, tx.CurrentAPDUIndex)
+	}
+
+	if yk.tx != tx {
+		t.Errorf("got transport %T after BeginTransaction", yk.tx)
+	}
+}
+
+func TestGPGYubiKey_GenerateOpenPGPKeyContext_Timeout(t *testing.T) {
+	t.Parallel()
+
+	tx := &blockingTestTx{
+		transactionTestTx: &transactionTestTx{TestSCTx: &TestSCTx{
+			APDUList:     []apdu{{instruction: insSelectApplication, param1: paramOpenGPGASelectApplication, data: aidOpenPGP[:]}},
+			ResponseList: [][]byte{nil},
+		}},
+		release: make(chan struct{}),
+	}
+
+	yk := NewTestGpgYubikey(&GpgData{AlgorithmAttributesChangeable: true}, false, nil)
+	yk.tx = tx
+	yk.Quirks().KeyGenerationTimeout = 10 * time.Millisecond
+
+	// the caller has no deadline, the quirk gives up on the card.
+	if _, err := yk.GenerateOpenPGPKeyContext(context.Background(), SignatureKey, AlgorithmEC256); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+
+	close(tx.release)
+
+	if err := yk.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.ended != 1 || tx.begun != 1 {
+		t.Errorf("got %d ended %d begun expected 1 of each", tx.ended, tx.begun)
+	}
+}
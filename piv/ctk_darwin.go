@@ -0,0 +1,307 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin && cgo && ctk
+
+package piv
+
+// The CryptoTokenKit backend talks to cards through TKSmartCardSlotManager instead of PCSC.framework,
+// for processes where the PC/SC API is blocked, such as sandboxed apps with the
+// com.apple.security.smartcard entitlement. Build with -tags ctk to include it.
+
+// #cgo CFLAGS: -x objective-c -fobjc-arc
+// #cgo LDFLAGS: -framework CryptoTokenKit -framework Foundation
+// #include <stdlib.h>
+// #import <CryptoTokenKit/CryptoTokenKit.h>
+//
+// enum {
+// 	ctkOK = 0,
+// 	ctkNoManager,
+// 	ctkNoReader,
+// 	ctkNoCard,
+// 	ctkNoSession,
+// 	ctkTransmitFailed,
+// 	ctkBufferTooSmall,
+// };
+//
+// static TKSmartCardSlot *ctkSlot(const char *name, int *status) {
+// 	TKSmartCardSlotManager *m = [TKSmartCardSlotManager defaultManager];
+// 	if (m == nil) {
+// 		*status = ctkNoManager;
+// 		return nil;
+// 	}
+// 	dispatch_semaphore_t done = dispatch_semaphore_create(0);
+// 	__block TKSmartCardSlot *slot = nil;
+// 	[m getSlotWithName:[NSString stringWithUTF8String:name] reply:^(TKSmartCardSlot *s) {
+// 		slot = s;
+// 		dispatch_semaphore_signal(done);
+// 	}];
+// 	dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+// 	*status = slot == nil ? ctkNoReader : ctkOK;
+// 	return slot;
+// }
+//
+// // ctkSlotNames returns the reader names, each ending with NUL, in a buffer to free.
+// static char *ctkSlotNames(size_t *n, int *status) {
+// 	TKSmartCardSlotManager *m = [TKSmartCardSlotManager defaultManager];
+// 	if (m == nil) {
+// 		*status = ctkNoManager;
+// 		return NULL;
+// 	}
+// 	NSMutableData *d = [NSMutableData data];
+// 	for (NSString *s in m.slotNames) {
+// 		const char *c = s.UTF8String;
+// 		[d appendBytes:c length:strlen(c) + 1];
+// 	}
+// 	*n = d.length;
+// 	*status = ctkOK;
+// 	char *rv = malloc(d.length + 1);
+// 	memcpy(rv, d.bytes, d.length);
+// 	return rv;
+// }
+//
+// // ctkATR copies the ATR of the card in the reader to atr, n is 0 without a card.
+// static int ctkATR(const char *name, void *atr, size_t *n) {
+// 	int status;
+// 	TKSmartCardSlot *slot = ctkSlot(name, &status);
+// 	if (slot == nil) {
+// 		return status;
+// 	}
+// 	NSData *b = slot.ATR.bytes;
+// 	if (slot.state != TKSmartCardSlotStateValidCard || b == nil) {
+// 		*n = 0;
+// 		return ctkOK;
+// 	}
+// 	if (b.length > *n) {
+// 		return ctkBufferTooSmall;
+// 	}
+// 	memcpy(atr, b.bytes, b.length);
+// 	*n = b.length;
+// 	return ctkOK;
+// }
+//
+// // ctkConnect returns a retained TKSmartCard with a session, release it with ctkDisconnect.
+// static void *ctkConnect(const char *name, int *status) {
+// 	TKSmartCardSlot *slot = ctkSlot(name, status);
+// 	if (slot == nil) {
+// 		return NULL;
+// 	}
+// 	TKSmartCard *card = slot.state == TKSmartCardSlotStateValidCard ? [slot makeSmartCard] : nil;
+// 	if (card == nil) {
+// 		*status = ctkNoCard;
+// 		return NULL;
+// 	}
+// 	dispatch_semaphore_t done = dispatch_semaphore_create(0);
+// 	__block BOOL ok = NO;
+// 	[card beginSessionWithReply:^(BOOL success, NSError *error) {
+// 		ok = success;
+// 		dispatch_semaphore_signal(done);
+// 	}];
+// 	dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+// 	if (!ok) {
+// 		*status = ctkNoSession;
+// 		return NULL;
+// 	}
+// 	*status = ctkOK;
+// 	return (__bridge_retained void *)card;
+// }
+//
+// static void ctkDisconnect(void *c) {
+// 	TKSmartCard *card = (__bridge_transfer TKSmartCard *)c;
+// 	[card endSession];
+// }
+//
+// static int ctkTransmit(void *c, const void *req, size_t reqLen, void *resp, size_t *respLen) {
+// 	TKSmartCard *card = (__bridge TKSmartCard *)c;
+// 	dispatch_semaphore_t done = dispatch_semaphore_create(0);
+// 	__block NSData *reply = nil;
+// 	[card transmitRequest:[NSData dataWithBytes:req length:reqLen] reply:^(NSData *r, NSError *error) {
+// 		reply = r;
+// 		dispatch_semaphore_signal(done);
+// 	}];
+// 	dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+// 	if (reply == nil) {
+// 		return ctkTransmitFailed;
+// 	}
+// 	if (reply.length > *respLen) {
+// 		return ctkBufferTooSmall;
+// 	}
+// 	memcpy(resp, reply.bytes, reply.length);
+// 	*respLen = reply.length;
+// 	return ctkOK;
+// }
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// ErrCryptoTokenKitUnavailable is returned when the process may not use CryptoTokenKit smart cards,
+// it needs the com.apple.security.smartcard entitlement when sandboxed.
+var ErrCryptoTokenKitUnavailable = errors.New("CryptoTokenKit smart card access unavailable")
+
+const (
+	// maxATRSize is the longest ATR, ISO/IEC 7816-3.
+	maxATRSize = 33
+	// ctkMaxResponseSize is an extended length response of 65536 bytes and the status words.
+	ctkMaxResponseSize = 1<<16 + 2
+)
+
+// CryptoTokenKitConstructor is an SCConstructor using CryptoTokenKit, for Client.SCConstruct:
+//
+//	c := piv.Client{SCConstruct: &piv.CryptoTokenKitConstructor{}}
+type CryptoTokenKitConstructor struct{}
+
+type ctkContext struct{}
+
+type ctkHandle struct {
+	mu   sync.Mutex
+	card unsafe.Pointer
+}
+
+var (
+	_ SCConstructor = (*CryptoTokenKitConstructor)(nil)
+	_ SCContext     = (*ctkContext)(nil)
+	_ SCHandle      = (*ctkHandle)(nil)
+	_ SoftwareCard  = (*ctkHandle)(nil)
+)
+
+func ctkCheck(status C.int) error {
+	switch status {
+	case C.ctkOK:
+		return nil
+	case C.ctkNoManager:
+		return ErrCryptoTokenKitUnavailable
+	case C.ctkNoReader:
+		return &scErr{rc: rcReaderUnavailable}
+	case C.ctkNoCard:
+		return &scErr{rc: rcNoSmartcard}
+	case C.ctkNoSession:
+		return fmt.Errorf("beginning CryptoTokenKit session: %w", &scErr{rc: rcSharingViolation})
+	default:
+		return fmt.Errorf("CryptoTokenKit error %d", int(status))
+	}
+}
+
+// nolint:ireturn
+func (c *CryptoTokenKitConstructor) NewSCContext() (SCContext, error) {
+	return &ctkContext{}, nil
+}
+
+func (c *ctkContext) Close() error {
+	return nil
+}
+
+func (c *ctkContext) ListReaders() ([]string, error) {
+	var (
+		n      C.size_t
+		status C.int
+	)
+
+	names := C.ctkSlotNames(&n, &status)
+	if err := ctkCheck(status); err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(names))
+
+	var readers []string
+
+	for _, name := range bytes.Split(C.GoBytes(unsafe.Pointer(names), C.int(n)), []byte{0}) {
+		if len(name) > 0 {
+			readers = append(readers, string(name))
+		}
+	}
+
+	return readers, nil
+}
+
+func (c *ctkContext) ATR(reader string) ([]byte, error) {
+	name := C.CString(reader)
+	defer C.free(unsafe.Pointer(name))
+
+	var atr [maxATRSize]byte
+
+	n := C.size_t(len(atr))
+	if err := ctkCheck(C.ctkATR(name, unsafe.Pointer(&atr[0]), &n)); err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	return append([]byte(nil), atr[:n]...), nil
+}
+
+// nolint:ireturn
+func (c *ctkContext) Connect(reader string) (SCHandle, error) {
+	name := C.CString(reader)
+	defer C.free(unsafe.Pointer(name))
+
+	var status C.int
+
+	card := C.ctkConnect(name, &status)
+	if err := ctkCheck(status); err != nil {
+		return nil, err
+	}
+
+	return &ctkHandle{card: card}, nil
+}
+
+// Begin returns a transaction on the session, CryptoTokenKit sessions are exclusive already.
+//
+// nolint:ireturn
+func (h *ctkHandle) Begin() (SCTx, error) {
+	return &softwareTx{card: h}, nil
+}
+
+func (h *ctkHandle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.card != nil {
+		C.ctkDisconnect(h.card)
+		h.card = nil
+	}
+
+	return nil
+}
+
+// Transmit sends a command APDU and returns the response with the status words.
+func (h *ctkHandle) Transmit(command []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.card == nil {
+		return nil, ErrCardClosed
+	}
+
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	resp := make([]byte, ctkMaxResponseSize)
+	n := C.size_t(len(resp))
+
+	status := C.ctkTransmit(h.card, unsafe.Pointer(&command[0]), C.size_t(len(command)), unsafe.Pointer(&resp[0]), &n)
+	if err := ctkCheck(status); err != nil {
+		return nil, err
+	}
+
+	return resp[:n], nil
+}
@@ -0,0 +1,126 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+
+	rsafork "github.com/areese/piv-go/third_party/rsa"
+)
+
+// Padding is the RSA encryption padding of a ciphertext.
+type Padding int
+
+const (
+	// PaddingPKCS1v15 is RSAES-PKCS1-v1_5, the default.
+	PaddingPKCS1v15 Padding = iota
+	// PaddingOAEP is RSAES-OAEP.
+	PaddingOAEP
+)
+
+func (p Padding) String() string {
+	switch p {
+	case PaddingPKCS1v15:
+		return "PKCS1v15"
+	case PaddingOAEP:
+		return "OAEP"
+	}
+
+	return fmt.Sprintf("unknown: %d", int(p))
+}
+
+// DecryptOptions selects the padding of an RSA ciphertext.
+// It can be passed as the crypto.DecrypterOpts of a PIV key, *rsa.OAEPOptions and *rsa.PKCS1v15DecryptOptions work too.
+//
+// PIV keys decrypt without removing the padding, so OAEP is removed on the host.
+// OpenPGP cards always remove PKCS#1 v1.5 padding on the card and can't decrypt OAEP.
+type DecryptOptions struct {
+	Padding Padding
+	// Hash is the OAEP hash, also used for MGF1, it defaults to SHA-256.
+	Hash crypto.Hash
+	// Label is the OAEP label, usually empty.
+	Label []byte
+}
+
+func (o *DecryptOptions) hash() crypto.Hash {
+	if o.Hash == 0 {
+		return crypto.SHA256
+	}
+
+	return o.Hash
+}
+
+// decryptOptionsFrom converts the crypto.DecrypterOpts accepted by Decrypt.
+func decryptOptionsFrom(opts crypto.DecrypterOpts) (*DecryptOptions, error) {
+	switch o := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		return &DecryptOptions{Padding: PaddingPKCS1v15}, nil
+	case *rsa.OAEPOptions:
+		if o.MGFHash != 0 && o.MGFHash != o.Hash {
+			return nil, fmt.Errorf("%w: oaep with a different mgf1 hash", ErrNoSuchAlgorithm)
+		}
+
+		return &DecryptOptions{Padding: PaddingOAEP, Hash: o.Hash, Label: o.Label}, nil
+	case *DecryptOptions:
+		return o, nil
+	case DecryptOptions:
+		return &o, nil
+	default:
+		return nil, fmt.Errorf("%w: decrypter options %T", ErrNoSuchAlgorithm, opts)
+	}
+}
+
+// unpad removes the padding of em, the raw RSA decryption with pub.
+func (o *DecryptOptions) unpad(pub *rsa.PublicKey, em []byte) ([]byte, error) {
+	switch o.Padding {
+	case PaddingPKCS1v15:
+		// Decrypted blob contains a bunch of random data. Look for a NULL byte which
+		// indicates where the plain text starts.
+		for i := 2; i+1 < len(em); i++ {
+			if em[i] == 0x00 {
+				return em[i+1:], nil
+			}
+		}
+
+		return nil, fmt.Errorf("invalid pkcs#1 v1.5 padding")
+	case PaddingOAEP:
+		hash := o.hash()
+		if !hash.Available() {
+			return nil, fmt.Errorf("%w: oaep hash %s not available", ErrNoSuchAlgorithm, hash)
+		}
+
+		// the leading zero may be missing.
+		if k := pub.Size(); len(em) < k {
+			em = append(make([]byte, k-len(em)), em...)
+		}
+
+		return rsafork.EMEOAEPDecode(em, hash.New(), o.Label)
+	default:
+		return nil, fmt.Errorf("%w: padding %s", ErrNoSuchAlgorithm, o.Padding)
+	}
+}
+
+// DecryptWithOptions is Decrypt with a choice of padding.
+// The card removes PKCS#1 v1.5 padding itself, OAEP returns ErrNotSupportedByCard.
+func (yk *GPGYubiKey) DecryptWithOptions(data []byte, opts *DecryptOptions) ([]byte, error) {
+	if opts != nil && opts.Padding != PaddingPKCS1v15 {
+		return nil, fmt.Errorf("%s padding: %w", opts.Padding, ErrNotSupportedByCard)
+	}
+
+	return yk.Decrypt(data)
+}
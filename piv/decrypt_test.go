@@ -0,0 +1,113 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // nolint:gosec // OAEP with SHA1 is still common.
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+func TestYkDecryptRSA(t *testing.T) {
+	t.Parallel()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainText := []byte("secret")
+	label := []byte("label")
+
+	pkcs1, err := rsa.EncryptPKCS1v15(rand.Reader, &priv.PublicKey, plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oaepSHA256, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &priv.PublicKey, plainText, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oaepSHA1, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, &priv.PublicKey, plainText, label) // nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+		expectErr  error
+	}{
+		{name: "pkcs1v15 default", ciphertext: pkcs1},
+		{name: "pkcs1v15 options", ciphertext: pkcs1, opts: &rsa.PKCS1v15DecryptOptions{}},
+		{name: "oaep default hash", ciphertext: oaepSHA256, opts: &DecryptOptions{Padding: PaddingOAEP}},
+		{name: "oaep standard options", ciphertext: oaepSHA1, opts: &rsa.OAEPOptions{Hash: crypto.SHA1, Label: label}},
+		{name: "oaep wrong label", ciphertext: oaepSHA1, opts: &DecryptOptions{Padding: PaddingOAEP, Hash: crypto.SHA1}, expectErr: rsa.ErrDecryption},
+		{name: "oaep wrong hash", ciphertext: oaepSHA256, opts: DecryptOptions{Padding: PaddingOAEP, Hash: crypto.SHA1}, expectErr: rsa.ErrDecryption},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts, err := decryptOptionsFrom(tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the card returns the raw RSA decryption, without the leading zero byte.
+			raw := new(big.Int).Exp(new(big.Int).SetBytes(tc.ciphertext), priv.D, priv.N).Bytes()
+
+			tx := &TestSCTx{
+				APDUList: []apdu{{
+					instruction: insAuthenticate,
+					param1:      algRSA1024,
+					param2:      byte(SlotKeyManagement.Key),
+					data:        marshalASN1(0x7c, append([]byte{0x82, 0x00}, marshalASN1(0x81, tc.ciphertext)...)),
+				}},
+				ResponseList: [][]byte{marshalASN1(0x7c, marshalASN1(0x82, raw))},
+			}
+
+			got, err := ykDecryptRSA(tx, SlotKeyManagement, &priv.PublicKey, tc.ciphertext, opts)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !bytes.Equal(got, plainText) {
+				t.Errorf("got %q expected %q", got, plainText)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_DecryptWithOptions(t *testing.T) {
+	t.Parallel()
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = &TestSCTx{APDUList: []apdu{}, ResponseList: [][]byte{}}
+
+	_, err := yk.DecryptWithOptions([]byte{0x01}, &DecryptOptions{Padding: PaddingOAEP})
+	expectedError(t, err, ErrNotSupportedByCard)
+}
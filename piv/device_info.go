@@ -0,0 +1,134 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// formFactorFIPS and formFactorSky are the flags in the high bits of the form factor byte.
+	formFactorFIPS = 0x80
+	formFactorSky  = 0x40
+)
+
+// DeviceInfo is the device info of a YubiKey, for inventory tooling.
+type DeviceInfo struct {
+	// Version is the firmware version.
+	Version Version
+	// Serial is 0 if the YubiKey doesn't report it, such as Security Keys.
+	Serial uint32
+	// Formfactor includes the FIPS flag, as in attestations.
+	Formfactor Formfactor
+	// PartNumber is empty on YubiKeys before 5.7.
+	PartNumber string
+	// NFC is true if the YubiKey has an NFC interface.
+	NFC bool
+	// FIPS is true for FIPS series YubiKeys.
+	FIPS bool
+	// CSPN is true for CSPN series YubiKeys, detected from the part number.
+	CSPN bool
+	// ConfigLocked is true if the configuration is protected by a lock code.
+	ConfigLocked bool
+	// Supported and Enabled are the applications per transport, Enabled has no NFC entry without NFC.
+	Supported map[Transport]Capability
+	Enabled   map[Transport]Capability
+}
+
+// DeviceInfo reads the device info of the YubiKey.
+func (m *Management) DeviceInfo() (*DeviceInfo, error) {
+	info, err := ykReadDeviceInfo(m.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDeviceInfo(info, m.version)
+}
+
+// DeviceInfo reads the device info from the management applet of the YubiKey.
+// The PIV applet is selected again afterwards.
+func (yk *YubiKey) DeviceInfo() (*DeviceInfo, error) {
+	if err := ykSelectApplication(yk.tx, aidManagement[:]); err != nil {
+		return nil, fmt.Errorf("selecting management applet: %w", err)
+	}
+	defer ykSelectApplication(yk.tx, aidPIV[:])
+
+	info, err := ykReadDeviceInfo(yk.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDeviceInfo(info, yk.version)
+}
+
+// parseDeviceInfo converts the TLVs of ykReadDeviceInfo, v is used if they have no version.
+func parseDeviceInfo(info map[byte][]byte, v *version) (*DeviceInfo, error) {
+	d := &DeviceInfo{
+		Supported: map[Transport]Capability{},
+		Enabled:   map[Transport]Capability{},
+	}
+
+	if v != nil {
+		d.Version = v.Version()
+	}
+
+	if b, ok := info[tagMgmtVersion]; ok {
+		if len(b) != 3 {
+			return nil, fmt.Errorf("%w: expected 3 byte version, got %d", ErrDeviceInfoMalformed, len(b))
+		}
+
+		d.Version = Version{Major: int(b[0]), Minor: int(b[1]), Patch: int(b[2])}
+	}
+
+	if b, ok := info[tagMgmtSerial]; ok {
+		if len(b) != 4 {
+			return nil, fmt.Errorf("%w: expected 4 byte serial number, got %d", ErrDeviceInfoMalformed, len(b))
+		}
+
+		d.Serial = binary.BigEndian.Uint32(b)
+	}
+
+	if b := info[tagMgmtFormFactor]; len(b) == 1 {
+		d.Formfactor = Formfactor(b[0] &^ formFactorSky)
+		d.FIPS = b[0]&formFactorFIPS != 0
+	}
+
+	// 5.7 FIPS YubiKeys report the applications that are FIPS capable instead.
+	if b := info[tagMgmtFIPSCapable]; capabilityFromBytes(b) != 0 {
+		d.FIPS = true
+	}
+
+	d.PartNumber = strings.TrimRight(string(info[tagMgmtPartNumber]), "\x00")
+	d.CSPN = strings.Contains(strings.ToUpper(d.PartNumber), "CSPN")
+
+	locked := info[tagMgmtConfigLock]
+	d.ConfigLocked = len(locked) == 1 && locked[0] == 0x01
+
+	for _, t := range []Transport{TransportUSB, TransportNFC} {
+		supported, enabled := transportCapabilities(info, t)
+		if supported == 0 {
+			continue
+		}
+
+		d.Supported[t] = supported
+		d.Enabled[t] = supported & enabled
+	}
+
+	d.NFC = d.Supported[TransportNFC] != 0
+
+	return d, nil
+}
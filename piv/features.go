@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+// FirmwareFeatures reports which optional features a YubiKey firmware version
+// supports. Use Features to create one, or the Features method of an open
+// connection.
+//
+// The version thresholds follow the YubiKey release notes and yubikit:
+// https://github.com/Yubico/yubikey-manager/blob/main/yubikit/piv.py
+type FirmwareFeatures struct {
+	Version Version
+}
+
+// Features returns the features supported by a YubiKey with firmware v.
+func Features(v Version) FirmwareFeatures {
+	return FirmwareFeatures{Version: v}
+}
+
+// Features returns the features supported by the YubiKey, based on the
+// version reported by the PIV applet.
+func (yk *YubiKey) Features() FirmwareFeatures {
+	return Features(yk.Version())
+}
+
+// Features returns the features supported by the YubiKey, based on the
+// version reported by the management applet.
+func (m *Management) Features() FirmwareFeatures {
+	return Features(m.Version())
+}
+
+func (f FirmwareFeatures) atLeast(major, minor, patch int) bool {
+	return supportsVersion(f.Version, major, minor, patch)
+}
+
+// MaxObjectSize returns the largest data object the PIV applet stores, in bytes of the
+// object's value, with the limits of yubico-piv-tool. The NEO has less memory.
+func (f FirmwareFeatures) MaxObjectSize() int {
+	if f.atLeast(4, 0, 0) {
+		return 3063
+	}
+	return 2039
+}
+
+// SupportsAttestation reports whether PIV slots can be attested.
+func (f FirmwareFeatures) SupportsAttestation() bool { return f.atLeast(4, 3, 0) }
+
+// SupportsSerial reports whether the PIV applet reports the serial number.
+// Older keys require the OTP applet.
+func (f FirmwareFeatures) SupportsSerial() bool { return f.atLeast(5, 0, 0) }
+
+// SupportsMetadata reports whether slot metadata (KeyInfo) can be read.
+func (f FirmwareFeatures) SupportsMetadata() bool { return f.atLeast(5, 3, 0) }
+
+// SupportsAESManagementKey reports whether the PIV management key can be AES.
+func (f FirmwareFeatures) SupportsAESManagementKey() bool { return f.atLeast(5, 4, 0) }
+
+// SupportsEd25519 reports whether PIV supports Ed25519 and X25519 keys.
+func (f FirmwareFeatures) SupportsEd25519() bool { return f.atLeast(5, 7, 0) }
+
+// SupportsRSA4096 reports whether PIV supports RSA 3072 and 4096 keys.
+func (f FirmwareFeatures) SupportsRSA4096() bool { return f.atLeast(5, 7, 0) }
+
+// SupportsKeyMove reports whether PIV keys can be moved between slots and deleted.
+func (f FirmwareFeatures) SupportsKeyMove() bool { return f.atLeast(5, 7, 0) }
+
+// SupportsOpenPGPECC reports whether the OpenPGP applet supports ECC keys.
+func (f FirmwareFeatures) SupportsOpenPGPECC() bool { return f.atLeast(5, 2, 0) }
+
+// SupportsAttestationOpenPGP reports whether OpenPGP keys can be attested.
+func (f FirmwareFeatures) SupportsAttestationOpenPGP() bool { return f.atLeast(5, 2, 1) }
+
+// SupportsDeviceInfo reports whether the management applet returns device info.
+func (f FirmwareFeatures) SupportsDeviceInfo() bool { return f.atLeast(4, 1, 0) }
+
+// SupportsConfigLock reports whether the device configuration can be locked.
+func (f FirmwareFeatures) SupportsConfigLock() bool { return f.atLeast(5, 0, 0) }
+
+// SupportsHSMAuth reports whether the YubiHSM Auth applet is available.
+func (f FirmwareFeatures) SupportsHSMAuth() bool { return f.atLeast(5, 4, 3) }
@@ -0,0 +1,46 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import "testing"
+
+func TestFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		version Version
+		feature func(FirmwareFeatures) bool
+		want    bool
+	}{
+		{"neo attestation", Version{1, 0, 4}, FirmwareFeatures.SupportsAttestation, false},
+		{"yk4 attestation", Version{4, 3, 0}, FirmwareFeatures.SupportsAttestation, true},
+		{"yk4 serial", Version{4, 3, 5}, FirmwareFeatures.SupportsSerial, false},
+		{"yk5 serial", Version{5, 0, 0}, FirmwareFeatures.SupportsSerial, true},
+		{"5.2.7 metadata", Version{5, 2, 7}, FirmwareFeatures.SupportsMetadata, false},
+		{"5.3.0 metadata", Version{5, 3, 0}, FirmwareFeatures.SupportsMetadata, true},
+		{"5.4.3 ed25519", Version{5, 4, 3}, FirmwareFeatures.SupportsEd25519, false},
+		{"5.7.0 ed25519", Version{5, 7, 0}, FirmwareFeatures.SupportsEd25519, true},
+		{"5.7.0 key move", Version{5, 7, 0}, FirmwareFeatures.SupportsKeyMove, true},
+		{"5.2.0 openpgp attestation", Version{5, 2, 0}, FirmwareFeatures.SupportsAttestationOpenPGP, false},
+		{"5.2.1 openpgp attestation", Version{5, 2, 1}, FirmwareFeatures.SupportsAttestationOpenPGP, true},
+		{"6.0.0 hsm auth", Version{6, 0, 0}, FirmwareFeatures.SupportsHSMAuth, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.feature(Features(test.version)); got != test.want {
+				t.Errorf("feature for %+v, got=%t, want=%t", test.version, got, test.want)
+			}
+		})
+	}
+}
@@ -57,24 +57,43 @@ func OpenGPG(card string) (*GPGYubiKey, error) {
 
 // OpenGPG connects to a YubiKey OpenGPG smart card.
 func (c *Client) OpenGPG(card string) (*GPGYubiKey, error) {
+	return c.OpenGPGWithOptions(card, GPGOpenOptions{})
+}
+
+// OpenGPGWithOptions connects to a YubiKey OpenGPG smart card using opts.
+func (c *Client) OpenGPGWithOptions(card string, opts GPGOpenOptions) (*GPGYubiKey, error) {
 	ctx, err := c.SCConstruct.NewSCContext()
 	if err != nil {
 		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
 	}
 
-	h, err := ctx.Connect(card)
+	yk, err := openGPGOnContext(ctx, card, opts)
 	if err != nil {
-		// FIXME: add logging to log the close errors
-		ctx.Close()
+		logClose(opts.Logger, "context", ctx.Close())
+
+		return nil, err
+	}
+
+	return yk, nil
+}
 
+// openGPGOnContext connects to card with an existing context and selects the OpenPGP applet.
+// The context isn't closed on failure.
+func openGPGOnContext(ctx SCContext, card string, opts GPGOpenOptions) (*GPGYubiKey, error) {
+	h, err := connectWithOptions(ctx, card, opts.OpenOptions)
+	if err != nil {
 		return nil, fmt.Errorf("connecting to smart card: %w", err)
 	}
 
 	tx, err := h.Begin()
 	if err != nil {
+		h.Close()
+
 		return nil, fmt.Errorf("beginning smart card transaction: %w", err)
 	}
 
+	tx = newLogTx(tx, opts.Logger, card)
+
 	// if DebugOpen was set, set debug on the tx so we can see dumps from here out.
 	if DebugOpen {
 		tx.EnableDebug()
@@ -83,6 +102,7 @@ func (c *Client) OpenGPG(card string) (*GPGYubiKey, error) {
 	err = ykSelectOpenGPGApplication(tx)
 	if err != nil {
 		tx.Close()
+		h.Close()
 
 		return nil, fmt.Errorf("selecting openpgp applet: %w", err)
 	}
@@ -97,13 +117,35 @@ func (c *Client) OpenGPG(card string) (*GPGYubiKey, error) {
 	}
 
 	// tx.EnableDebug()
-	yk.gpgData, err = ykOpenGPGData(tx, card)
+	yk.gpgData, err = ykOpenGPGData(tx, card, opts.ParseMode)
+	if err == nil {
+		err = yk.gpgData.readLoginData(tx)
+	}
+
 	if err != nil {
 		tx.Close()
+		h.Close()
 
 		return nil, fmt.Errorf("selecting openpgp applet: %w", err)
 	}
 
+	if e, ok := tx.(ExtendedLengthTx); ok && yk.gpgData.ExtendedLengthSupported() {
+		e.SetExtendedLength(true)
+
+		if maxCommand, _, ok := yk.gpgData.ExtendedLengthInformation(); ok {
+			e.SetMaxCommandLength(maxCommand)
+		}
+	}
+
+	if opts.SecureMessaging != nil {
+		if yk.tx, err = newSMTx(tx, yk.gpgData, opts.SecureMessaging); err != nil {
+			tx.Close()
+			h.Close()
+
+			return nil, err
+		}
+	}
+
 	return yk, nil
 }
 
@@ -211,45 +253,42 @@ func ykOpenGPGReadKey(tx SCTx, keyType AsymmetricKeyType, generateKey bool) (*be
 }
 
 // ykOpenGPGData calls GET Data for Data Objects 65, 6E, 7A.
-func ykOpenGPGData(tx SCTx, reader string) (*GpgData, error) {
-	gpgData := &GpgData{
-		tlvValues: bertlv.TLVData{},
-		Reader:    reader,
-	}
+func ykOpenGPGData(tx SCTx, reader string, mode ParseMode) (*GpgData, error) {
+	var objects [][]byte
+
+	var sstErr error
 
 	for _, dataByte := range []byte{cardHolderDataTag, applicationRelatedDataTag, securitySupportTemplateTag} {
-		cmd := apdu{
-			instruction: insGetDataA,
-			param1:      0,
-			param2:      dataByte,
-			data:        []byte{},
-		}
+		data, err := gpgGetData(tx, dataByte)
+		if err != nil && dataByte == securitySupportTemplateTag {
+			sstErr = err
 
-		data, err := tx.Transmit(cmd)
-		if err != nil {
-			return nil, fmt.Errorf("command failed: %w", err)
+			continue
 		}
 
-		gpgData.dprintf("data: %s\n", bertlv.MakeJSONString(data))
-
-		rv, err := bertlv.Parse(data, &gpgData.tlvValues)
 		if err != nil {
 			return nil, err
 		}
 
-		gpgData.dprintf("bertlv: %s\n", bertlv.MakeJSONString(rv))
+		objects = append(objects, data)
 	}
 
-	// need to call update to set up the fields.
-	err := gpgData.update()
+	gpgData, err := parseGPGData(reader, mode, objects...)
 	if err != nil {
 		return nil, err
 	}
 
-	// get the applet version
-	gpgData.AppletVersion, err = gpgAppletVersion(tx)
-	if err != nil {
-		return nil, err
+	// Gnuk and other non Yubico cards may not have the security support template.
+	if sstErr != nil && !gpgData.Quirks.NoSecuritySupportTemplate {
+		return nil, sstErr
+	}
+
+	// get the applet version, this is a Yubico extension.
+	if !gpgData.Quirks.NoAppletVersion {
+		gpgData.AppletVersion, err = gpgAppletVersion(tx)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	gpgData.dprintf(gpgData.String())
@@ -257,6 +296,16 @@ func ykOpenGPGData(tx SCTx, reader string) (*GpgData, error) {
 	return gpgData, nil
 }
 
+// gpgGetData calls GET DATA for a simple tag data object.
+func gpgGetData(tx SCTx, tag byte) ([]byte, error) {
+	data, err := tx.Transmit(apdu{instruction: insGetDataA, param2: tag, data: []byte{}})
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	return data, nil
+}
+
 // GetTag will get the results from a tag.
 // if expectedLen is >0 it will verify the length.
 func (g *GpgData) GetTag(key string, expectedLen int) ([]byte, error) {
@@ -313,13 +362,14 @@ func (yk *GPGYubiKey) readOrGeneratePublicKey(keyType AsymmetricKeyType, request
 	}
 
 	// FIXME: not sure origin makes sense for generating keys.
-	keyOrigin, err := yk.gpgData.Origin(keyType.KeyType())
-	if err != nil {
-		return nil, err
-	}
+	// 2.x cards don't record the origin, any key is fine if no origin was requested.
+	if requestedOrigin != KeyOriginAny || yk.gpgData.Features().KeyInformation {
+		keyOrigin, err := yk.gpgData.Origin(keyType.KeyType())
+		if err != nil {
+			return nil, err
+		}
 
-	if requestedOrigin != KeyOriginAny {
-		if keyOrigin != requestedOrigin {
+		if requestedOrigin != KeyOriginAny && keyOrigin != requestedOrigin {
 			return nil, fmt.Errorf("%w: keyOrigin: %s requested, but key origin is %s", ErrKeyNotPresent, requestedOrigin.String(), keyOrigin.String())
 		}
 	}
@@ -509,10 +559,11 @@ func parsePinRetries(data []byte, pwField byte) (byte, error) {
 	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
 	// 4.3 User Verification in the OpenPGP Application
 	// 4.4.1 DOs for GET DATA
+	// PW1 in mode 81 and 82 is the same PIN with one retry counter, byte 6 is the resetting code.
 	switch pwField {
-	case paramOpenGPGVerifyPW1:
+	case paramOpenGPGVerifyPW1, paramOpenGPGVerifyPW2:
 		return getByteWith1BasedIndexing(data, 5)
-	case paramOpenGPGVerifyPW2:
+	case paramOpenGPGResettingCode:
 		return getByteWith1BasedIndexing(data, 6)
 	case paramOpenGPGVerifyPW3:
 		return getByteWith1BasedIndexing(data, 7)
@@ -541,8 +592,7 @@ func gpgLogin(tx SCTx, pin []byte, pwField byte) error {
 	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 52-53.
 	cmd := apdu{instruction: insVerify, param1: 0x00, param2: pwField, data: pin}
 	if _, err := tx.Transmit(cmd); err != nil {
-		e2 := errors.Unwrap(err)
-		if e2 != nil && errors.Is(e2, AuthErr{-1}) {
+		if errors.Is(err, AuthErr{-1}) {
 			// fmt.Printf("Need to check retries\n")
 
 			var data []byte
@@ -574,7 +624,7 @@ func gpgLogin(tx SCTx, pin []byte, pwField byte) error {
 // point the PUK must be used to unblock the PIN.
 //
 // Use DefaultPIN if the PIN hasn't been set.
-// FIXME: this only makes calls against PW2, might have to check PW1 and PW3 for some use cases.
+// AuthPIN verifies PW2, use VerifyPIN for PW1 and PW3.
 func (yk *GPGYubiKey) AuthPIN(pin []byte) error {
 	if yk == nil {
 		return ErrNotFound
@@ -594,7 +644,34 @@ func (yk *GPGYubiKey) AuthPIN(pin []byte) error {
 		return ErrNotFound
 	}
 
-	return gpgLogin(yk.tx, pin, paramOpenGPGVerifyPW2)
+	return yk.login(pin, paramOpenGPGVerifyPW2)
+}
+
+// AuthAdminPIN verifies the admin PIN (PW3), which is required to generate keys.
+// With the AdminLess quirk the token takes the user PIN instead.
+func (yk *GPGYubiKey) AuthAdminPIN(pin []byte) error {
+	if yk == nil {
+		return ErrNotFound
+	}
+
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.AuthAdminPIN\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	minLength := minPW3Length
+	if yk.gpgData.Quirks.AdminLess {
+		minLength = minPW1Length
+	}
+
+	if len(pin) < minLength {
+		return ErrTooShort
+	}
+
+	return yk.adminLogin(pin)
 }
 
 func gpgAppletVersion(tx SCTx) (string, error) {
@@ -606,7 +683,7 @@ func gpgAppletVersion(tx SCTx) (string, error) {
 	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch), nil
 }
 
-func gpgComputeDigitalSignature(tx *scTx, data []byte) ([]byte, error) {
+func gpgComputeDigitalSignature(tx SCTx, data []byte) ([]byte, error) {
 	cmd := apdu{
 		instruction: insPerformSecurityOperation,
 		param1:      securityOperationComputeDigitalSignatureParam1,
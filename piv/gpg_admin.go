@@ -0,0 +1,612 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	// insPutDataDA writes a DO with a tag of up to 2 bytes.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 62
+	// 7.2.8 PUT DATA.
+	insPutDataDA = 0xda
+	// insPutDataDB writes DOs with a constructed tag, used for key import.
+	insPutDataDB = 0xdb
+
+	// insChangeReferenceData changes PW1 or PW3, the data is the old PIN followed by the new one.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 54
+	// 7.2.3 CHANGE REFERENCE DATA.
+	insChangeReferenceData = 0x24
+
+	// insResetRetryCounter sets a new PW1 with the resetting code, or after PW3 was presented.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 55
+	// 7.2.4 RESET RETRY COUNTER.
+	insResetRetryCounter = 0x2c
+
+	// paramOpenGPGResettingCode is not a VERIFY P2, it selects the resetting code salt of the KDF
+	// and the retry counter of the resetting code.
+	paramOpenGPGResettingCode = 0x84
+
+	// DOs for PUT DATA.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 26
+	// 4.4.2 DOs for PUT DATA.
+	putNameTag            = 0x5b
+	putLoginDataTag       = 0x5e
+	putLanguageTag        = 0x5f2d
+	putURLTag             = 0x5f50
+	putPWStatusTag        = 0xc4
+	putResettingCodeTag   = 0xd3
+	putSignatureUIFTag    = 0xd6
+	putFingerprintSigTag  = 0xc7
+	putGenerationDateTag  = 0xce
+	extendedHeaderListTag = 0x4d
+)
+
+// Default OpenPGP PINs.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 17
+// 4.3 User Verification in the OpenPGP Application.
+const (
+	DefaultOpenPGPUserPIN  = "123456"
+	DefaultOpenPGPAdminPIN = "12345678"
+
+	defaultPW1 = DefaultOpenPGPUserPIN
+	defaultPW3 = DefaultOpenPGPAdminPIN
+)
+
+// UIF is the User Interaction Flag of a key, it's the touch policy of YubiKeys.
+// https://developers.yubico.com/PGP/Card_edit.html
+type UIF byte
+
+const (
+	UIFOff UIF = 0x00
+	UIFOn  UIF = 0x01
+	// UIFFixed is UIFOn that can only be removed by resetting the applet.
+	UIFFixed       UIF = 0x02
+	UIFCached      UIF = 0x03
+	UIFCachedFixed UIF = 0x04
+
+	// uifButtonFeature is the second byte of the UIF DOs, general feature management (button).
+	uifButtonFeature = 0x20
+)
+
+func (u UIF) String() string {
+	switch u {
+	case UIFOff:
+		return "off"
+	case UIFOn:
+		return "on"
+	case UIFFixed:
+		return "fixed"
+	case UIFCached:
+		return "cached"
+	case UIFCachedFixed:
+		return "cached-fixed"
+	}
+
+	return fmt.Sprintf("unknown: %d", u)
+}
+
+// gpgPutData writes a DO, most DOs require PW3 has been presented.
+func gpgPutData(tx SCTx, tag uint16, data []byte) error {
+	cmd := apdu{
+		instruction: insPutDataDA,
+		param1:      byte(tag >> 8),
+		param2:      byte(tag),
+		data:        data,
+	}
+
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("put data %04x: %w", tag, err)
+	}
+
+	return nil
+}
+
+// gpgChangeReferenceData changes PW1 (81) or PW3 (83).
+func gpgChangeReferenceData(tx SCTx, pwField byte, oldPIN, newPIN []byte) error {
+	switch pwField {
+	case paramOpenGPGVerifyPW1:
+		if len(newPIN) < minPW1Length {
+			return ErrTooShort
+		}
+	case paramOpenGPGVerifyPW3:
+		if len(newPIN) < minPW3Length {
+			return ErrTooShort
+		}
+	default:
+		return fmt.Errorf("%w: pwField 0x%x", ErrNotFound, pwField)
+	}
+
+	cmd := apdu{
+		instruction: insChangeReferenceData,
+		param2:      pwField,
+		data:        append(append([]byte{}, oldPIN...), newPIN...),
+	}
+
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("change pin 0x%x: %w", pwField, err)
+	}
+
+	return nil
+}
+
+// gpgKeyTag returns the DO of keyType from the first one (the signature key DO).
+func gpgKeyTag(first uint16, keyType KeyType) (uint16, error) {
+	if keyType > KeyTypeLast {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+	}
+
+	return first + uint16(keyType), nil
+}
+
+func gpgPutUIF(tx SCTx, keyType KeyType, uif UIF) error {
+	tag, err := gpgKeyTag(putSignatureUIFTag, keyType)
+	if err != nil {
+		return err
+	}
+
+	return gpgPutData(tx, tag, []byte{byte(uif), uifButtonFeature})
+}
+
+// gpgGetUIF reads the UIF of keyType, cards without the DO don't have a button and return UIFOff.
+func gpgGetUIF(tx SCTx, keyType KeyType) (UIF, error) {
+	tag, err := gpgKeyTag(putSignatureUIFTag, keyType)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := tx.Transmit(apdu{instruction: insGetDataA, param1: byte(tag >> 8), param2: byte(tag)})
+	if err = gpgKeyError(err); errors.Is(err, ErrKeyNotPresent) || errors.Is(err, ErrNotSupportedByCard) {
+		return UIFOff, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("reading %s uif: %w", keyType, err)
+	}
+
+	if len(data) == 0 {
+		return UIFOff, nil
+	}
+
+	return UIF(data[0]), nil
+}
+
+// UIF returns the touch policy of the key in keyType, UIFOff when the card has no button.
+// https://developers.yubico.com/PGP/Card_edit.html
+func (yk *GPGYubiKey) UIF(keyType KeyType) (UIF, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.UIF\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return 0, ErrNotFound
+	}
+
+	if f := yk.gpgData.Features(); !f.UIF {
+		return UIFOff, fmt.Errorf("%w: uif for %s on %s", ErrUnsupportedByCardVersion, keyType, f)
+	}
+
+	return gpgGetUIF(yk.tx, keyType)
+}
+
+// PutFingerprint writes the fingerprint (C7-C9) of the key in slot, gpg compares it to the public key to find the card.
+// The card doesn't compute it, OpenPGPFingerprint does. ImportOpenPGPKey and GenerateOpenPGPKey write it.
+//
+// It requires PW3 (83) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 31
+// 4.4.2 Data Objects for PUT DATA.
+func (yk *GPGYubiKey) PutFingerprint(slot KeyType, fingerprint []byte) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutFingerprint\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if err := gpgPutFingerprint(yk.tx, slot, fingerprint); err != nil {
+		return err
+	}
+
+	yk.gpgData.setFingerprint(slot, fingerprint)
+
+	return nil
+}
+
+// PutKeyCreationTime writes the generation date (CE-D0) of the key in slot, which is part of its fingerprint.
+// ImportOpenPGPKey and GenerateOpenPGPKey write it.
+//
+// It requires PW3 (83) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 31
+// 4.4.2 Data Objects for PUT DATA.
+func (yk *GPGYubiKey) PutKeyCreationTime(slot KeyType, created time.Time) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutKeyCreationTime\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if err := gpgPutKeyCreationTime(yk.tx, slot, created); err != nil {
+		return err
+	}
+
+	yk.gpgData.setKeyCreationTime(slot, created)
+
+	return nil
+}
+
+// gpgPutKeyInformation writes the fingerprint (C7-C9) and the generation date (CE-D0) of a key.
+// The card doesn't compute them, gpg compares them to the public key to find the card.
+func gpgPutKeyInformation(tx SCTx, keyType KeyType, fingerprint []byte, created time.Time) error {
+	if err := gpgPutFingerprint(tx, keyType, fingerprint); err != nil {
+		return err
+	}
+
+	return gpgPutKeyCreationTime(tx, keyType, created)
+}
+
+func gpgPutFingerprint(tx SCTx, keyType KeyType, fingerprint []byte) error {
+	if len(fingerprint) != keyFingerprintLen {
+		return fmt.Errorf("%w: fingerprint is %d bytes", ErrTooShort, len(fingerprint))
+	}
+
+	tag, err := gpgKeyTag(putFingerprintSigTag, keyType)
+	if err != nil {
+		return err
+	}
+
+	return gpgPutData(tx, tag, fingerprint)
+}
+
+func gpgPutKeyCreationTime(tx SCTx, keyType KeyType, created time.Time) error {
+	tag, err := gpgKeyTag(putGenerationDateTag, keyType)
+	if err != nil {
+		return err
+	}
+
+	date := make([]byte, keyDateLen)
+	binary.BigEndian.PutUint32(date, uint32(created.Unix()))
+
+	return gpgPutData(tx, tag, date)
+}
+
+// setKeyInformation updates the cached fingerprint, date and origin of a key after it was written,
+// the rest of the cached data is only read when the card is opened.
+func (g *GpgData) setKeyInformation(keyType KeyType, fingerprint []byte, created time.Time, origin KeyOrigin) {
+	g.setFingerprint(keyType, fingerprint)
+	g.setKeyCreationTime(keyType, created)
+	g.setKeyOrigin(keyType, origin)
+}
+
+func (g *GpgData) setFingerprint(keyType KeyType, fingerprint []byte) {
+	if g == nil || keyType > KeyTypeLast {
+		return
+	}
+
+	if data, ok := g.tlvValues[keyInformationTag]; ok {
+		if offset, expectedLen := getKeyLen(keyType, keyFingerprintLen); len(data) >= expectedLen {
+			copy(data[offset:expectedLen], fingerprint)
+		}
+	}
+}
+
+func (g *GpgData) setKeyCreationTime(keyType KeyType, created time.Time) {
+	if g == nil || keyType > KeyTypeLast {
+		return
+	}
+
+	if data, ok := g.tlvValues[keyDateTag]; ok {
+		if offset, expectedLen := getKeyLen(keyType, keyDateLen); len(data) >= expectedLen {
+			binary.BigEndian.PutUint32(data[offset:expectedLen], uint32(created.Unix()))
+		}
+	}
+}
+
+func (g *GpgData) setKeyOrigin(keyType KeyType, origin KeyOrigin) {
+	if g == nil || keyType > KeyTypeLast {
+		return
+	}
+
+	if data, ok := g.tlvValues[keyOriginAttributesTag]; ok && len(data) > keyType.Offset() {
+		data[keyType.Offset()] = byte(origin)
+	}
+}
+
+// setTag replaces a cached value after it was written to the card.
+func (g *GpgData) setTag(tag string, value []byte) {
+	if g == nil || g.tlvValues == nil {
+		return
+	}
+
+	g.tlvValues[tag] = append([]byte{}, value...)
+}
+
+// setAlgorithmAttributes updates the cached algorithm attributes of a key after they were written.
+func (g *GpgData) setAlgorithmAttributes(keyType KeyType, attributes []byte) {
+	tag, err := keyAlgorithmAttributesTag(keyType)
+	if g == nil || g.tlvValues == nil || err != nil {
+		return
+	}
+
+	g.tlvValues[tag] = append([]byte{}, attributes...)
+}
+
+func gpgControlReferenceTemplate(keyType KeyType) ([]byte, error) {
+	switch keyType {
+	case SignatureKey:
+		return crtDigitalSignature[:], nil
+	case DecryptionKey:
+		return crtConfidentiality[:], nil
+	case AuthenticationKey:
+		return crtAuthentication[:], nil
+	case AttestKey:
+		fallthrough
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+	}
+}
+
+// gpgImportKey writes a private key with the extended header list (4D), this requires PW3.
+// RSA keys use the standard import format (e, p, q), the algorithm attributes of the slot must match the key.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 39
+// 4.4.3.12 Private Key Template.
+func gpgImportKey(tx SCTx, keyType KeyType, priv crypto.PrivateKey) error {
+	crt, err := gpgControlReferenceTemplate(keyType)
+	if err != nil {
+		return err
+	}
+
+	var tags []byte
+
+	var values [][]byte
+
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return fmt.Errorf("%w: rsa key with %d primes", ErrNoSuchAlgorithm, len(k.Primes))
+		}
+
+		tags = []byte{0x91, 0x92, 0x93}
+		values = [][]byte{big.NewInt(int64(k.E)).Bytes(), k.Primes[0].Bytes(), k.Primes[1].Bytes()}
+	case *ecdsa.PrivateKey:
+		tags = []byte{0x92}
+		values = [][]byte{k.D.FillBytes(make([]byte, (k.Curve.Params().BitSize+7)/8))}
+	default:
+		return fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, priv)
+	}
+
+	// 7F48 has only the tags and lengths, 5F48 the concatenated values.
+	var template []byte
+
+	for i, tag := range tags {
+		template = append(template, tag)
+		template = append(template, marshalASN1Length(uint64(len(values[i])))...)
+	}
+
+	data := append([]byte{}, crt...)
+	data = append(data, 0x7f)
+	data = append(data, marshalASN1(0x48, template)...)
+	data = append(data, 0x5f)
+	data = append(data, marshalASN1(0x48, bytes.Join(values, nil))...)
+
+	cmd := apdu{
+		instruction: insPutDataDB,
+		param1:      0x3f,
+		param2:      0xff,
+		data:        marshalASN1(extendedHeaderListTag, data),
+	}
+
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("importing %s key: %w", keyType, err)
+	}
+
+	return nil
+}
+
+// Cardholder certificates (7F21) have one instance per key, SELECT DATA picks the instance for GET and PUT DATA.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 57
+// 7.2.5 SELECT DATA.
+const (
+	cardholderCertificateTag = 0x7f21
+	insYubicoAttest          = 0xfb
+)
+
+// gpgSelectDataLengthPrefix reports whether SELECT DATA needs the extra length byte YubiKeys up to 5.4.3 expect.
+// https://github.com/Yubico/yubikey-manager/blob/main/yubikit/openpgp.py
+func gpgSelectDataLengthPrefix(g *GpgData) bool {
+	if g == nil || g.ManufacturerID != ManufacturerYubico {
+		return false
+	}
+
+	var v Version
+	if _, err := fmt.Sscanf(g.AppletVersion, "%d.%d.%d", &v.Major, &v.Minor, &v.Patch); err != nil {
+		return false
+	}
+
+	return !supportsVersion(v, 5, 4, 4)
+}
+
+// gpgSupportsSelectData reports whether the card has SELECT DATA, added in version 3.0 of the specification.
+func gpgSupportsSelectData(g *GpgData) bool {
+	return g != nil && gpgCheckSelectData(g) == nil
+}
+
+// gpgCheckCardholderCertificate checks g has a certificate for keyType, cards without SELECT DATA only have one
+// for the authentication key.
+func gpgCheckCardholderCertificate(g *GpgData, keyType KeyType) error {
+	if keyType > KeyTypeLast {
+		return fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+	}
+
+	if keyType != AuthenticationKey && !gpgSupportsSelectData(g) {
+		return fmt.Errorf("%s: %w: only the authentication key has a certificate", g.Quirks.Name, ErrNotSupportedByCard)
+	}
+
+	return nil
+}
+
+// gpgSelectCardholderCertificate selects the 7F21 instance of keyType.
+// Instances are counted from the authentication key: AUT 0, DEC 1, SIG 2.
+func gpgSelectCardholderCertificate(tx SCTx, keyType KeyType, lengthPrefix bool) error {
+	if keyType > KeyTypeLast {
+		return fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+	}
+
+	if err := gpgSelectOccurrence(tx, cardholderCertificateTag, int(KeyTypeLast-keyType), lengthPrefix); err != nil {
+		return fmt.Errorf("selecting %s certificate: %w", keyType, err)
+	}
+
+	return nil
+}
+
+// gpgGetCardholderCertificate reads the 7F21 of keyType, it's empty if no certificate was stored.
+func gpgGetCardholderCertificate(tx SCTx, keyType KeyType, g *GpgData) ([]byte, error) {
+	if err := gpgCheckCardholderCertificate(g, keyType); err != nil {
+		return nil, err
+	}
+
+	if gpgSupportsSelectData(g) {
+		if err := gpgSelectCardholderCertificate(tx, keyType, gpgSelectDataLengthPrefix(g)); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd := apdu{
+		instruction: insGetDataA,
+		param1:      byte(cardholderCertificateTag >> 8),
+		param2:      byte(cardholderCertificateTag & 0xff),
+	}
+
+	data, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s certificate: %w", keyType, err)
+	}
+
+	return data, nil
+}
+
+// gpgPutCardholderCertificate writes der as the 7F21 of keyType, an empty der deletes the certificate.
+func gpgPutCardholderCertificate(tx SCTx, keyType KeyType, der []byte, g *GpgData) error {
+	if err := gpgCheckCardholderCertificate(g, keyType); err != nil {
+		return err
+	}
+
+	if g.Quirks.CertificateUpdateBinary {
+		if err := gpgUpdateBinary(tx, gnukCertificateFileID, der); err != nil {
+			return fmt.Errorf("writing %s certificate: %w", keyType, err)
+		}
+
+		return nil
+	}
+
+	if gpgSupportsSelectData(g) {
+		if err := gpgSelectCardholderCertificate(tx, keyType, gpgSelectDataLengthPrefix(g)); err != nil {
+			return err
+		}
+	}
+
+	return gpgPutData(tx, cardholderCertificateTag, der)
+}
+
+// gpgAttest has a YubiKey 5.2+ sign an attestation certificate for keyType with the OpenPGP attestation key.
+// The certificate replaces the cardholder certificate of keyType.
+// https://developers.yubico.com/PGP/Attestation.html
+func gpgAttest(tx SCTx, keyType KeyType, g *GpgData) ([]byte, error) {
+	if keyType > KeyTypeLast {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+	}
+
+	// P1 is the key reference, SIG 1, DEC 2, AUT 3.
+	cmd := apdu{instruction: insYubicoAttest, param1: byte(keyType) + 1}
+	if _, err := tx.Transmit(cmd); err != nil {
+		return nil, fmt.Errorf("attesting %s key: %w", keyType, err)
+	}
+
+	return gpgGetCardholderCertificate(tx, keyType, g)
+}
+
+// Algorithm attributes, C1-C3 for the signature, decryption and authentication key.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 35
+// 4.4.3.9 Algorithm Attributes.
+const (
+	putAlgorithmAttributesSigTag = 0xc1
+
+	// rsaImportExponentBits is the exponent length gpgImportKey sends, 17 bits for 65537.
+	rsaImportExponentBits = 17
+)
+
+// gpgAlgorithmAttributesFor returns the algorithm attributes of a key that gpgImportKey can import.
+func gpgAlgorithmAttributesFor(keyType KeyType, pub crypto.PublicKey) ([]byte, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return gpgRSAAlgorithmAttributes(k.N.BitLen()), nil
+	case *ecdsa.PublicKey:
+		return gpgECAlgorithmAttributes(keyType, k.Curve)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, pub)
+	}
+}
+
+// gpgRSAAlgorithmAttributes returns the attributes of an RSA key in the standard import format.
+func gpgRSAAlgorithmAttributes(bits int) []byte {
+	return RSAAlgorithmAttributes(bits).Bytes()
+}
+
+// gpgECAlgorithmAttributes returns the attributes of an EC key, ECDH in the decryption slot and ECDSA otherwise.
+func gpgECAlgorithmAttributes(keyType KeyType, c elliptic.Curve) ([]byte, error) {
+	attributes, err := ECAlgorithmAttributes(keyType, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return attributes.Bytes(), nil
+}
+
+// gpgSameAlgorithm is true when two algorithm attributes have the same algorithm and key size or curve,
+// the RSA exponent size and the import format don't matter.
+func gpgSameAlgorithm(current, attributes []byte) bool {
+	if len(current) < 3 || len(attributes) < 3 || current[0] != attributes[0] {
+		return false
+	}
+
+	if attributes[0] == openPGPAlgorithmRSA {
+		return current[1] == attributes[1] && current[2] == attributes[2]
+	}
+
+	return bytes.HasPrefix(current[1:], attributes[1:])
+}
+
+// gpgPutAlgorithmAttributes changes the algorithm of a slot, the card deletes the key in it.
+func gpgPutAlgorithmAttributes(tx SCTx, keyType KeyType, attributes []byte) error {
+	tag, err := gpgKeyTag(putAlgorithmAttributesSigTag, keyType)
+	if err != nil {
+		return err
+	}
+
+	return gpgPutData(tx, tag, attributes)
+}
@@ -0,0 +1,306 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+)
+
+// OpenPGP public key algorithms of AlgorithmAttributes.
+// https://www.rfc-editor.org/rfc/rfc4880#section-9.1
+const (
+	OpenPGPAlgorithmRSA   byte = openPGPAlgorithmRSA
+	OpenPGPAlgorithmECDH  byte = openPGPAlgorithmECDH
+	OpenPGPAlgorithmECDSA byte = openPGPAlgorithmECDSA
+	OpenPGPAlgorithmEdDSA byte = openPGPAlgorithmEdDSA
+
+	// openPGPAlgorithmRSAEncryptOnly and openPGPAlgorithmRSASignOnly are deprecated RSA algorithms some cards still report.
+	openPGPAlgorithmRSAEncryptOnly = 2
+	openPGPAlgorithmRSASignOnly    = 3
+
+	// importFormatWithPublicKey is the EC import format that includes the public key.
+	importFormatWithPublicKey = 0xff
+)
+
+// Curve OIDs of the Curve25519 keys, without the 06 tag and length.
+// https://datatracker.ietf.org/doc/html/draft-ietf-openpgp-rfc4880bis-10#section-9.2
+//
+// nolint:gochecknoglobals
+var (
+	oidEd25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	oidX25519  = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+
+	// oidSecp256k1 is the Koblitz curve of some cards, 1.3.132.0.10.
+	oidSecp256k1 = []byte{0x2b, 0x81, 0x04, 0x00, 0x0a}
+)
+
+// namedCurve is the name of a curve OID.
+type namedCurve struct {
+	oid  []byte
+	name string
+}
+
+// namedCurves are the curves of the OpenPGP card specification, the NIST curves and secp256k1 come from openPGPCurves.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 35
+// 4.4.3.9 Algorithm Attributes.
+//
+// nolint:gochecknoglobals
+var namedCurves = []namedCurve{
+	{oid: []byte{0x2b, 0x24, 0x03, 0x03, 0x02, 0x08, 0x01, 0x01, 0x07}, name: "brainpoolP256r1"},
+	{oid: []byte{0x2b, 0x24, 0x03, 0x03, 0x02, 0x08, 0x01, 0x01, 0x0b}, name: "brainpoolP384r1"},
+	{oid: []byte{0x2b, 0x24, 0x03, 0x03, 0x02, 0x08, 0x01, 0x01, 0x0d}, name: "brainpoolP512r1"},
+	{oid: oidEd25519, name: "Ed25519"},
+	{oid: oidX25519, name: "X25519"},
+}
+
+// AlgorithmAttributes are the algorithm attributes of a key slot, C1 to C3.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 35
+// 4.4.3.9 Algorithm Attributes.
+type AlgorithmAttributes struct {
+	// Algorithm is the OpenPGP public key algorithm, 1 RSA, 18 ECDH, 19 ECDSA or 22 EdDSA.
+	Algorithm byte
+	// Bits is the modulus length of RSA keys.
+	Bits int
+	// OID is the curve of EC keys, without the 06 tag and length.
+	OID []byte
+	// ImportFormat is the private key format for imports, 0 is the standard format.
+	ImportFormat byte
+}
+
+// RSAAlgorithmAttributes returns the attributes of an RSA key with a 17 bit exponent in the standard import format.
+func RSAAlgorithmAttributes(bits int) AlgorithmAttributes {
+	return AlgorithmAttributes{Algorithm: openPGPAlgorithmRSA, Bits: bits}
+}
+
+// ECAlgorithmAttributes returns the attributes of a NIST curve or secp256k1 key, ECDH in the decryption slot
+// and ECDSA otherwise. secp256k1 is bitcurves.S256 of github.com/ProtonMail/go-crypto, it's only for ECDSA.
+func ECAlgorithmAttributes(slot KeyType, c elliptic.Curve) (AlgorithmAttributes, error) {
+	curve, err := openPGPCurveFor(c)
+	if err != nil {
+		return AlgorithmAttributes{}, err
+	}
+
+	alg := byte(openPGPAlgorithmECDSA)
+	if slot == DecryptionKey {
+		if curve.ecdh == nil {
+			return AlgorithmAttributes{}, fmt.Errorf("%w: ecdh with %s", ErrNoSuchAlgorithm, c.Params().Name)
+		}
+
+		alg = openPGPAlgorithmECDH
+	}
+
+	return AlgorithmAttributes{Algorithm: alg, OID: curve.oid}, nil
+}
+
+// Ed25519AlgorithmAttributes returns the attributes of an Ed25519 key for the signature or authentication slot.
+func Ed25519AlgorithmAttributes() AlgorithmAttributes {
+	return AlgorithmAttributes{Algorithm: openPGPAlgorithmEdDSA, OID: oidEd25519}
+}
+
+// X25519AlgorithmAttributes returns the attributes of an X25519 key for the decryption slot.
+func X25519AlgorithmAttributes() AlgorithmAttributes {
+	return AlgorithmAttributes{Algorithm: openPGPAlgorithmECDH, OID: oidX25519}
+}
+
+// Bytes encodes the attributes the way the card stores them.
+func (a AlgorithmAttributes) Bytes() []byte {
+	if a.Algorithm == openPGPAlgorithmRSA {
+		return []byte{a.Algorithm, byte(a.Bits >> 8), byte(a.Bits), 0x00, rsaImportExponentBits, a.ImportFormat}
+	}
+
+	b := append([]byte{a.Algorithm}, a.OID...)
+	if a.ImportFormat != 0 {
+		b = append(b, a.ImportFormat)
+	}
+
+	return b
+}
+
+// ParseAlgorithmAttributes parses the algorithm attributes of a key slot as the card returns them.
+// RSA attributes are the modulus and exponent length with an optional import format,
+// EC attributes are the curve OID optionally followed by the FF import format.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 35
+// 4.4.3.9 Algorithm Attributes.
+func ParseAlgorithmAttributes(data []byte) (AlgorithmAttributes, error) {
+	if len(data) == 0 {
+		return AlgorithmAttributes{}, fmt.Errorf("algorithm attributes: %w", ErrTooShort)
+	}
+
+	a := AlgorithmAttributes{Algorithm: data[0]}
+
+	switch a.Algorithm {
+	case openPGPAlgorithmRSA, openPGPAlgorithmRSAEncryptOnly, openPGPAlgorithmRSASignOnly:
+		if len(data) < 5 {
+			return AlgorithmAttributes{}, fmt.Errorf("rsa algorithm attributes are %d bytes: %w", len(data), ErrTooShort)
+		}
+
+		a.Bits = int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) > 5 {
+			a.ImportFormat = data[5]
+		}
+	case openPGPAlgorithmECDH, openPGPAlgorithmECDSA, openPGPAlgorithmEdDSA:
+		oid := data[1:]
+		// the last byte of an OID never has the high bit set.
+		if len(oid) > 0 && oid[len(oid)-1] == importFormatWithPublicKey {
+			a.ImportFormat = importFormatWithPublicKey
+			oid = oid[:len(oid)-1]
+		}
+
+		if len(oid) == 0 {
+			return AlgorithmAttributes{}, fmt.Errorf("ec algorithm attributes without a curve: %w", ErrTooShort)
+		}
+
+		a.OID = append([]byte{}, oid...)
+	default:
+		return AlgorithmAttributes{}, fmt.Errorf("%w: algorithm %d", ErrNoSuchAlgorithm, a.Algorithm)
+	}
+
+	return a, nil
+}
+
+// IsRSA is true for the RSA algorithms.
+func (a AlgorithmAttributes) IsRSA() bool {
+	return a.Algorithm == openPGPAlgorithmRSA || a.Algorithm == openPGPAlgorithmRSAEncryptOnly ||
+		a.Algorithm == openPGPAlgorithmRSASignOnly
+}
+
+// CurveName returns the name of the curve, like P-256 or Ed25519, the dotted OID for unknown curves
+// and an empty string for RSA.
+func (a AlgorithmAttributes) CurveName() string {
+	if len(a.OID) == 0 {
+		return ""
+	}
+
+	for _, c := range openPGPCurves {
+		if bytes.Equal(c.oid, a.OID) {
+			return c.curve.Params().Name
+		}
+	}
+
+	for _, c := range namedCurves {
+		if bytes.Equal(c.oid, a.OID) {
+			return c.name
+		}
+	}
+
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(append([]byte{0x06, byte(len(a.OID))}, a.OID...), &oid); err != nil {
+		return fmt.Sprintf("%X", a.OID)
+	}
+
+	return oid.String()
+}
+
+// Curve returns the curve of NIST curve and secp256k1 keys, the curve of the ecdsa.PublicKey of the card,
+// and nil for other keys.
+func (a AlgorithmAttributes) Curve() elliptic.Curve {
+	if a.Algorithm != openPGPAlgorithmECDSA && a.Algorithm != openPGPAlgorithmECDH {
+		return nil
+	}
+
+	for _, c := range openPGPCurves {
+		if bytes.Equal(c.oid, a.OID) {
+			return c.curve
+		}
+	}
+
+	return nil
+}
+
+// String returns the algorithm and the key size or curve, like RSA 2048 or EdDSA Ed25519.
+func (a AlgorithmAttributes) String() string {
+	switch a.Algorithm {
+	case openPGPAlgorithmRSA, openPGPAlgorithmRSAEncryptOnly, openPGPAlgorithmRSASignOnly:
+		return fmt.Sprintf("RSA %d", a.Bits)
+	case openPGPAlgorithmECDH:
+		return "ECDH " + a.CurveName()
+	case openPGPAlgorithmECDSA:
+		return "ECDSA " + a.CurveName()
+	case openPGPAlgorithmEdDSA:
+		return "EdDSA " + a.CurveName()
+	}
+
+	return fmt.Sprintf("Alg=%d", a.Algorithm)
+}
+
+// validFor checks the algorithm can be used in slot, ECDH only decrypts and the signature algorithms don't.
+func (a AlgorithmAttributes) validFor(slot KeyType) error {
+	switch a.Algorithm {
+	case openPGPAlgorithmRSA:
+		if a.Bits <= 0 {
+			return fmt.Errorf("%w: rsa key without a size", ErrNoSuchAlgorithm)
+		}
+
+		return nil
+	case openPGPAlgorithmECDH:
+		if slot != DecryptionKey {
+			return fmt.Errorf("%w: ecdh key in the %s slot", ErrNoSuchAlgorithm, slot)
+		}
+	case openPGPAlgorithmECDSA, openPGPAlgorithmEdDSA:
+		if slot == DecryptionKey {
+			return fmt.Errorf("%w: signing key in the %s slot", ErrNoSuchAlgorithm, slot)
+		}
+	default:
+		return fmt.Errorf("%w: algorithm %d", ErrNoSuchAlgorithm, a.Algorithm)
+	}
+
+	if len(a.OID) == 0 {
+		return fmt.Errorf("%w: ec key without a curve", ErrNoSuchAlgorithm)
+	}
+
+	return nil
+}
+
+// SetAlgorithmAttributes changes the algorithm of slot, the next key generated or imported there uses it.
+// The card must allow changing the attributes, see AlgorithmAttributesChangeable.
+//
+// It requires PW3 (83) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 35
+// 4.4.3.9 Algorithm Attributes.
+func (yk *GPGYubiKey) SetAlgorithmAttributes(slot KeyType, alg AlgorithmAttributes) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.SetAlgorithmAttributes\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if !yk.gpgData.AlgorithmAttributesChangeable {
+		return ErrNotSupportedByCard
+	}
+
+	if slot > KeyTypeLast {
+		return fmt.Errorf("%w: %s", ErrUnknownKeyType, slot)
+	}
+
+	if err := alg.validFor(slot); err != nil {
+		return err
+	}
+
+	attributes := alg.Bytes()
+	if err := gpgPutAlgorithmAttributes(yk.tx, slot, attributes); err != nil {
+		return fmt.Errorf("%s algorithm attributes: %w", slot, err)
+	}
+
+	yk.gpgData.setAlgorithmAttributes(slot, attributes)
+
+	return nil
+}
@@ -0,0 +1,261 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestAlgorithmAttributes_Bytes(t *testing.T) {
+	t.Parallel()
+
+	p256, err := ECAlgorithmAttributes(DecryptionKey, elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		attrs    AlgorithmAttributes
+		expected []byte
+	}{
+		{name: "rsa2048", attrs: RSAAlgorithmAttributes(2048), expected: []byte{0x01, 0x08, 0x00, 0x00, 0x11, 0x00}},
+		{name: "ecdh p256", attrs: p256, expected: []byte{0x12, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}},
+		{name: "ed25519", attrs: Ed25519AlgorithmAttributes(), expected: append([]byte{0x16}, oidEd25519...)},
+		{name: "x25519", attrs: X25519AlgorithmAttributes(), expected: append([]byte{0x12}, oidX25519...)},
+		{
+			name:     "import format",
+			attrs:    AlgorithmAttributes{Algorithm: openPGPAlgorithmEdDSA, OID: oidEd25519, ImportFormat: 0xff},
+			expected: append(append([]byte{0x16}, oidEd25519...), 0xff),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tc.attrs.Bytes(); !bytes.Equal(got, tc.expected) {
+				t.Errorf("got %x expected %x", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_SetAlgorithmAttributes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		notChangeable bool
+		slot          KeyType
+		attrs         AlgorithmAttributes
+		apdus         []apdu
+		expectErr     error
+	}{
+		{
+			name:  "ed25519 signature key",
+			slot:  SignatureKey,
+			attrs: Ed25519AlgorithmAttributes(),
+			apdus: []apdu{{instruction: insPutDataDA, param2: 0xc1, data: append([]byte{0x16}, oidEd25519...)}},
+		},
+		{
+			name:  "x25519 decryption key",
+			slot:  DecryptionKey,
+			attrs: X25519AlgorithmAttributes(),
+			apdus: []apdu{{instruction: insPutDataDA, param2: 0xc2, data: append([]byte{0x12}, oidX25519...)}},
+		},
+		{
+			name:      "x25519 authentication key",
+			slot:      AuthenticationKey,
+			attrs:     X25519AlgorithmAttributes(),
+			expectErr: ErrNoSuchAlgorithm,
+		},
+		{
+			name:      "ed25519 decryption key",
+			slot:      DecryptionKey,
+			attrs:     Ed25519AlgorithmAttributes(),
+			expectErr: ErrNoSuchAlgorithm,
+		},
+		{
+			name:      "rsa without size",
+			slot:      SignatureKey,
+			attrs:     AlgorithmAttributes{Algorithm: openPGPAlgorithmRSA},
+			expectErr: ErrNoSuchAlgorithm,
+		},
+		{
+			name:      "attest key",
+			slot:      AttestKey,
+			attrs:     RSAAlgorithmAttributes(2048),
+			expectErr: ErrUnknownKeyType,
+		},
+		{
+			name:          "not changeable",
+			notChangeable: true,
+			slot:          SignatureKey,
+			attrs:         RSAAlgorithmAttributes(2048),
+			expectErr:     ErrNotSupportedByCard,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{
+				AlgorithmAttributesChangeable: !tc.notChangeable,
+				tlvValues: bertlv.TLVData{
+					keyAlgorithmSignatureAttributesTag:  RSAAlgorithmAttributes(2048).Bytes(),
+					keyAlgorithmDecryptionAttributesTag: RSAAlgorithmAttributes(2048).Bytes(),
+				},
+			}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: make([][]byte, len(tc.apdus))}
+
+			err := yk.SetAlgorithmAttributes(tc.slot, tc.attrs)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			tag, _ := keyAlgorithmAttributesTag(tc.slot)
+			if got, _ := yk.gpgData.GetTag(tag, 1); !bytes.Equal(got, tc.attrs.Bytes()) {
+				t.Errorf("cached attributes %x expected %x", got, tc.attrs.Bytes())
+			}
+
+			if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tc.apdus))
+			}
+		})
+	}
+}
+
+func TestParseAlgorithmAttributes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		data      []byte
+		expected  AlgorithmAttributes
+		str       string
+		curve     string
+		expectErr error
+	}{
+		{
+			name:     "rsa2048",
+			data:     []byte{0x01, 0x08, 0x00, 0x00, 0x11, 0x00},
+			expected: RSAAlgorithmAttributes(2048),
+			str:      "RSA 2048",
+		},
+		{
+			name:     "rsa4096 crt",
+			data:     []byte{0x01, 0x10, 0x00, 0x00, 0x20, 0x02},
+			expected: AlgorithmAttributes{Algorithm: OpenPGPAlgorithmRSA, Bits: 4096, ImportFormat: 0x02},
+			str:      "RSA 4096",
+		},
+		{
+			name:     "rsa without import format",
+			data:     []byte{0x01, 0x08, 0x00, 0x00, 0x20},
+			expected: RSAAlgorithmAttributes(2048),
+			str:      "RSA 2048",
+		},
+		{
+			name:     "ecdsa p384",
+			data:     []byte{0x13, 0x2b, 0x81, 0x04, 0x00, 0x22},
+			expected: AlgorithmAttributes{Algorithm: OpenPGPAlgorithmECDSA, OID: []byte{0x2b, 0x81, 0x04, 0x00, 0x22}},
+			str:      "ECDSA P-384",
+			curve:    "P-384",
+		},
+		{
+			name:     "ed25519 with public key",
+			data:     append(append([]byte{0x16}, oidEd25519...), 0xff),
+			expected: AlgorithmAttributes{Algorithm: OpenPGPAlgorithmEdDSA, OID: oidEd25519, ImportFormat: 0xff},
+			str:      "EdDSA Ed25519",
+			curve:    "Ed25519",
+		},
+		{
+			name:     "x25519",
+			data:     append([]byte{0x12}, oidX25519...),
+			expected: X25519AlgorithmAttributes(),
+			str:      "ECDH X25519",
+			curve:    "X25519",
+		},
+		{
+			name:     "unknown curve",
+			data:     []byte{0x13, 0x2a, 0x03, 0x04},
+			expected: AlgorithmAttributes{Algorithm: OpenPGPAlgorithmECDSA, OID: []byte{0x2a, 0x03, 0x04}},
+			str:      "ECDSA 1.2.3.4",
+			curve:    "1.2.3.4",
+		},
+		{name: "empty", expectErr: ErrTooShort},
+		{name: "short rsa", data: []byte{0x01, 0x08, 0x00}, expectErr: ErrTooShort},
+		{name: "ec without curve", data: []byte{0x12, 0xff}, expectErr: ErrTooShort},
+		{name: "unknown algorithm", data: []byte{0x42, 0x01}, expectErr: ErrNoSuchAlgorithm},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseAlgorithmAttributes(tc.data)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if got.Algorithm != tc.expected.Algorithm || got.Bits != tc.expected.Bits ||
+				!bytes.Equal(got.OID, tc.expected.OID) || got.ImportFormat != tc.expected.ImportFormat {
+				t.Errorf("got %+v expected %+v", got, tc.expected)
+			}
+
+			if got.String() != tc.str {
+				t.Errorf("got %q expected %q", got.String(), tc.str)
+			}
+
+			if got.CurveName() != tc.curve {
+				t.Errorf("got curve %q expected %q", got.CurveName(), tc.curve)
+			}
+
+			if got.IsRSA() != (tc.curve == "") {
+				t.Errorf("IsRSA is %t", got.IsRSA())
+			}
+		})
+	}
+}
+
+func TestGpgData_AlgorithmAttributes(t *testing.T) {
+	t.Parallel()
+
+	g := &GpgData{tlvValues: bertlv.TLVData{keyAlgorithmAuthenticationAttributesTag: append([]byte{0x16}, oidEd25519...)}}
+
+	a, err := g.AlgorithmAttributes(AuthenticationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Algorithm != OpenPGPAlgorithmEdDSA || a.CurveName() != "Ed25519" {
+		t.Errorf("got %s", a)
+	}
+
+	_, err = g.AlgorithmAttributes(SignatureKey)
+	expectedError(t, err, ErrNoSuchTag)
+}
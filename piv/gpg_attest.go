@@ -0,0 +1,98 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ErrNoAttestationRoots is returned when an OpenPGP attestation is verified without roots.
+var ErrNoAttestationRoots = errors.New("no attestation roots")
+
+// AttestOpenPGP has the card sign an attestation certificate for the key in slot with the attestation key.
+// The certificate replaces the cardholder certificate of slot, verify it with an OpenPGPVerifier
+// and the certificate of the attestation key, GetAttestationCert(AttestKey).
+// It requires YubiKey 5.2 or newer and PW1 (82) has been presented, see VerifyPIN.
+// https://developers.yubico.com/PGP/Attestation.html
+func (yk *GPGYubiKey) AttestOpenPGP(slot KeyType) (*x509.Certificate, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.AttestOpenPGP\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if yk.gpgData.Quirks.NoAttestation {
+		return nil, fmt.Errorf("%s: %w", yk.gpgData.Quirks.Name, ErrNotSupportedByCard)
+	}
+
+	der, err := gpgAttest(yk.tx, slot, yk.gpgData)
+	if err != nil {
+		return nil, gpgKeyError(err)
+	}
+
+	if len(der) == 0 {
+		return nil, fmt.Errorf("%s attestation: %w", slot, ErrNotFound)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s attestation: %w", slot, err)
+	}
+
+	return cert, nil
+}
+
+// OpenPGPVerifier verifies attestations of the OpenPGP applet.
+// The slot certificate is signed by the attestation key of the card,
+// its certificate is signed by the Yubico OpenPGP attestation CA.
+type OpenPGPVerifier struct {
+	// Roots has the Yubico OpenPGP attestation CA, it isn't bundled and must be set.
+	// https://developers.yubico.com/PGP/Attestation.html
+	Roots *x509.CertPool
+	// Intermediates are the CAs between the attestation key certificate and Roots, if any.
+	Intermediates []*x509.Certificate
+}
+
+// Verify proves that the key of slotCert is on the card that attestationCert was issued to,
+// slotCert is from AttestOpenPGP and attestationCert from GetAttestationCert(AttestKey).
+// The firmware version, serial and form factor are parsed out of slotCert.
+func (v *OpenPGPVerifier) Verify(attestationCert, slotCert *x509.Certificate) (*Attestation, error) {
+	if v.Roots == nil {
+		return nil, ErrNoAttestationRoots
+	}
+
+	o := x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	o.Intermediates.AddCert(attestationCert)
+
+	for _, c := range v.Intermediates {
+		o.Intermediates.AddCert(c)
+	}
+
+	if _, err := slotCert.Verify(o); err != nil {
+		return nil, fmt.Errorf("verifying openpgp attestation: %w", err)
+	}
+
+	return parseAttestation(slotCert)
+}
@@ -0,0 +1,202 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// testOpenPGPAttestationChain returns a root, an attestation key certificate and a slot certificate signed by it.
+func testOpenPGPAttestationChain(t *testing.T) (root, attestation, slot *x509.Certificate) {
+	t.Helper()
+
+	create := func(serial int64, cn string, isCA bool, parent *x509.Certificate, parentKey, key *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  isCA,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		}
+
+		if !isCA {
+			tmpl.ExtraExtensions = []pkix.Extension{{Id: extIDFirmwareVersion, Value: []byte{0x05, 0x07, 0x01}}}
+		}
+
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatalf("creating %s: %v", cn, err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", cn, err)
+		}
+
+		return cert
+	}
+
+	var keys [3]*ecdsa.PrivateKey
+
+	for i := range keys {
+		var err error
+		if keys[i], err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+	}
+
+	root = create(1, "Test OpenPGP Attestation CA", true, nil, nil, keys[0])
+	attestation = create(2, "Test OpenPGP Attestation", true, root, keys[0], keys[1])
+	slot = create(3, "Test OpenPGP Attestation SIG", false, attestation, keys[1], keys[2])
+
+	return root, attestation, slot
+}
+
+func TestGPGYubiKey_AttestOpenPGP(t *testing.T) {
+	t.Parallel()
+
+	_, _, slotCert := testOpenPGPAttestationChain(t)
+
+	attestAPDU := apdu{instruction: insYubicoAttest, param1: 0x01}
+	selectCertAPDU := apdu{instruction: insSelectData, param1: 2, param2: 0x04, data: []byte{0x60, 0x04, 0x5c, 0x02, 0x7f, 0x21}}
+	getCertAPDU := apdu{instruction: insGetDataA, param1: 0x7f, param2: 0x21}
+
+	tests := []struct {
+		name        string
+		quirks      CardQuirks
+		apdus       []apdu
+		responses   [][]byte
+		transmitErr []error
+		expectErr   error
+	}{
+		{
+			name:      "attested",
+			apdus:     []apdu{attestAPDU, selectCertAPDU, getCertAPDU},
+			responses: [][]byte{{}, {}, slotCert.Raw},
+		},
+		{
+			name:      "no attestation",
+			quirks:    CardQuirks{Name: "test", NoAttestation: true},
+			expectErr: ErrNotSupportedByCard,
+		},
+		{
+			name:        "pin not verified",
+			apdus:       []apdu{attestAPDU},
+			responses:   [][]byte{{}},
+			transmitErr: []error{&apduErr{0x69, 0x82}},
+			expectErr:   ErrSecurityStatusNotSatisfied,
+		},
+		{
+			name:      "empty certificate",
+			apdus:     []apdu{attestAPDU, selectCertAPDU, getCertAPDU},
+			responses: [][]byte{{}, {}, {}},
+			expectErr: ErrNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{
+				ManufacturerID: ManufacturerYubico,
+				AppletVersion:  "5.7.1",
+				Quirks:         tc.quirks,
+				tlvValues:      bertlv.TLVData{},
+			}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: tc.responses, TransmitErr: tc.transmitErr}
+
+			cert, err := yk.AttestOpenPGP(SignatureKey)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !cert.Equal(slotCert) {
+				t.Errorf("got certificate %s expected %s", cert.Subject, slotCert.Subject)
+			}
+
+			if n := yk.tx.(*TestSCTx).CurrentAPDUIndex; n != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", n, len(tc.apdus))
+			}
+		})
+	}
+}
+
+func TestOpenPGPVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	root, attestation, slot := testOpenPGPAttestationChain(t)
+	otherRoot, otherAttestation, _ := testOpenPGPAttestationChain(t)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
+
+	tests := []struct {
+		name        string
+		roots       *x509.CertPool
+		attestation *x509.Certificate
+		ok          bool
+	}{
+		{name: "valid chain", roots: roots, attestation: attestation, ok: true},
+		{name: "other card", roots: roots, attestation: otherAttestation},
+		{name: "other root", roots: otherRoots, attestation: attestation},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			v := OpenPGPVerifier{Roots: tc.roots}
+
+			a, err := v.Verify(tc.attestation, slot)
+			if (err == nil) != tc.ok {
+				t.Fatalf("Verify returned %v, expected ok %v", err, tc.ok)
+			}
+
+			if tc.ok && a.Version != (Version{Major: 5, Minor: 7, Patch: 1}) {
+				t.Errorf("got version %v", a.Version)
+			}
+		})
+	}
+
+	var v OpenPGPVerifier
+	if _, err := v.Verify(attestation, slot); !errors.Is(err, ErrNoAttestationRoots) {
+		t.Errorf("expected %v got %v", ErrNoAttestationRoots, err)
+	}
+}
@@ -0,0 +1,272 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// gpgCurveAliases are the names gpg --card-status uses for the curves, other curves are printed as ?.
+//
+// nolint:gochecknoglobals
+var gpgCurveAliases = map[string]string{
+	"P-256":           "nistp256",
+	"P-384":           "nistp384",
+	"P-521":           "nistp521",
+	"Ed25519":         "ed25519",
+	"X25519":          "cv25519",
+	"brainpoolP256r1": "brainpoolP256r1",
+	"brainpoolP384r1": "brainpoolP384r1",
+	"brainpoolP512r1": "brainpoolP512r1",
+	"secp256k1":       "secp256k1",
+}
+
+// WriteCardStatus writes the status of the card in the format of gpg --card-status, so scripts that parse it
+// can use this package. There are no keygrip lines and General key info is [none] as there is no keyring.
+func (yk *GPGYubiKey) WriteCardStatus(w io.Writer) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.WriteCardStatus\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	g := yk.gpgData
+
+	aid, err := g.GetTag(applicationIDTag, 14)
+	if err != nil {
+		return err
+	}
+
+	serialNo := UpperCaseHexString(aid)
+
+	url, err := gpgGetOptionalData(yk.tx, putURLTag)
+	if err != nil {
+		return err
+	}
+
+	login, err := gpgGetOptionalData(yk.tx, putLoginDataTag)
+	if err != nil {
+		return err
+	}
+
+	pwStatus, err := g.PWStatus()
+	if err != nil {
+		return err
+	}
+
+	retries, err := yk.PINRetries()
+	if err != nil {
+		return err
+	}
+
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "Reader ...........: %s\n", stringOrNone(g.Reader, "[none]"))
+	fmt.Fprintf(b, "Application ID ...: %s\n", serialNo)
+	fmt.Fprintf(b, "Application type .: OpenPGP\n")
+	fmt.Fprintf(b, "Version ..........: %s.%s\n", gpgVersionDigits(serialNo[12:14]), gpgVersionDigits(serialNo[14:16]))
+	fmt.Fprintf(b, "Manufacturer .....: %s\n", ManufacturerName(g.ManufacturerID))
+	fmt.Fprintf(b, "Serial number ....: %s\n", serialNo[20:28])
+
+	name, _ := g.GetTag(cardHolderNameTag, 0)
+	fmt.Fprintf(b, "Name of cardholder: %s\n", gpgISOName(name))
+
+	language, _ := g.GetTag(languagePrefsTag, 0)
+	fmt.Fprintf(b, "Language prefs ...: %s\n", stringOrNone(string(language), NameNotSet))
+
+	salutation := ""
+	if sex, _ := g.GetTag(salutationTag, 1); len(sex) > 0 {
+		switch sex[0] {
+		case '1':
+			salutation = "Mr."
+		case '2':
+			salutation = "Ms."
+		}
+	}
+
+	fmt.Fprintf(b, "Salutation .......: %s\n", salutation)
+	fmt.Fprintf(b, "URL of public key : %s\n", stringOrNone(string(url), NameNotSet))
+	fmt.Fprintf(b, "Login data .......: %s\n", stringOrNone(string(login), NameNotSet))
+
+	// DO 3 and 4 need a PIN, gpg only shows them when it has been presented.
+	if g.PrivateUseDOsSupported {
+		for n := 1; n <= 2; n++ {
+			if data, err := yk.GetPrivateDO(n); err == nil && len(data) > 0 {
+				fmt.Fprintf(b, "Private DO %d .....: %s\n", n, data)
+			}
+		}
+	}
+
+	if caFingerprints, err := g.GetTag(caFingerprintsTag, 0); err == nil {
+		for i := 0; i+keyFingerprintLen <= len(caFingerprints) && i < 3*keyFingerprintLen; i += keyFingerprintLen {
+			if fp := caFingerprints[i : i+keyFingerprintLen]; !bytes.Equal(fp, make([]byte, keyFingerprintLen)) {
+				fmt.Fprintf(b, "CA fingerprint %d .:%s\n", i/keyFingerprintLen+1, gpgFingerprint(fp))
+			}
+		}
+	}
+
+	signaturePIN := "forced"
+	if pwStatus.PW1ValidForMultipleSignatures {
+		signaturePIN = "not forced"
+	}
+
+	fmt.Fprintf(b, "Signature PIN ....: %s\n", signaturePIN)
+
+	fmt.Fprintf(b, "Key attributes ...:")
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		attributes, err := g.AlgorithmAttributes(keyType)
+		if err != nil {
+			fmt.Fprintf(b, " ?")
+
+			continue
+		}
+
+		if attributes.IsRSA() {
+			fmt.Fprintf(b, " rsa%d", attributes.Bits)
+
+			continue
+		}
+
+		fmt.Fprintf(b, " %s", stringOrNone(gpgCurveAliases[attributes.CurveName()], "?"))
+	}
+
+	fmt.Fprintf(b, "\n")
+	fmt.Fprintf(b, "Max. PIN lengths .: %d %d %d\n", pwStatus.PW1MaxLength, pwStatus.ResetCodeMaxLength, pwStatus.PW3MaxLength)
+	fmt.Fprintf(b, "PIN retry counter : %d %d %d\n", retries.PW1, retries.ResetCode, retries.PW3)
+
+	count, _ := g.SignatureCount()
+	fmt.Fprintf(b, "Signature counter : %d\n", count)
+
+	if g.KDFSupported {
+		kdf, err := gpgKDF(yk.tx, g)
+		if err != nil {
+			return err
+		}
+
+		setting := "off"
+		if kdf != nil {
+			setting = "on"
+		}
+
+		fmt.Fprintf(b, "KDF setting ......: %s\n", setting)
+	}
+
+	if _, err := g.UIF(SignatureKey); err == nil {
+		fmt.Fprintf(b, "UIF setting ......:")
+
+		for keyType, name := range []string{"Sign", "Decrypt", "Auth"} {
+			uif, _ := g.UIF(KeyType(keyType))
+
+			setting := "off"
+			if uif != UIFOff {
+				setting = "on"
+			}
+
+			fmt.Fprintf(b, " %s=%s", name, setting)
+		}
+
+		fmt.Fprintf(b, "\n")
+	}
+
+	for keyType, label := range []string{"Signature key ....:", "Encryption key....:", "Authentication key:"} {
+		fmt.Fprintf(b, "%s", label)
+
+		fp := yk.storedFingerprint(KeyType(keyType))
+		if fp == nil {
+			fmt.Fprintf(b, " [none]\n")
+
+			continue
+		}
+
+		fmt.Fprintf(b, "%s\n", gpgFingerprint(fp))
+
+		if created, err := g.Date(KeyType(keyType)); err == nil && created.Unix() != 0 {
+			fmt.Fprintf(b, "      created ....: %s\n", created.UTC().Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	fmt.Fprintf(b, "General key info..: [none]\n")
+
+	_, err = io.WriteString(w, b.String())
+
+	return err
+}
+
+// gpgGetOptionalData reads a DO that may not be set, it's empty when the card doesn't have it.
+func gpgGetOptionalData(tx SCTx, tag uint16) ([]byte, error) {
+	data, err := tx.Transmit(apdu{instruction: insGetDataA, param1: byte(tag >> 8), param2: byte(tag)})
+	if err = gpgKeyError(err); errors.Is(err, ErrKeyNotPresent) || errors.Is(err, ErrNotSupportedByCard) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get data %04x: %w", tag, err)
+	}
+
+	return data, nil
+}
+
+// gpgFingerprint formats a fingerprint in groups of 4 hex digits with a gap in the middle, like gpg.
+func gpgFingerprint(fp []byte) string {
+	b := strings.Builder{}
+
+	for i := 0; i+1 < len(fp); i += 2 {
+		if i == len(fp)/2 {
+			b.WriteString(" ")
+		}
+
+		fmt.Fprintf(&b, " %02X%02X", fp[i], fp[i+1])
+	}
+
+	return b.String()
+}
+
+// gpgISOName formats the cardholder name (5B) like gpg, the given names before the surname.
+func gpgISOName(name []byte) string {
+	if len(name) == 0 {
+		return NameNotSet
+	}
+
+	surname, given, _ := strings.Cut(string(name), "<<")
+	surname = strings.ReplaceAll(surname, "<", " ")
+	given = strings.ReplaceAll(given, "<", " ")
+
+	if given == "" {
+		return surname
+	}
+
+	return given + " " + surname
+}
+
+// gpgVersionDigits drops the leading 0 of a version byte in hex.
+func gpgVersionDigits(s string) string {
+	return strings.TrimPrefix(s, "0")
+}
+
+func stringOrNone(s, none string) string {
+	if s == "" {
+		return none
+	}
+
+	return s
+}
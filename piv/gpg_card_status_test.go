@@ -0,0 +1,108 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_WriteCardStatus(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.SignatureKey, piv.AlgorithmEd25519); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	if err := yk.PutCardHolderName([]byte(pivtest.DefaultAdminPIN), "Doe", "Jane Q"); err != nil {
+		t.Fatalf("name: %v", err)
+	}
+
+	if err := yk.PutPublicKeyURL([]byte(pivtest.DefaultAdminPIN), "https://example.com/key.asc"); err != nil {
+		t.Fatalf("url: %v", err)
+	}
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	var b strings.Builder
+	if err := yk.WriteCardStatus(&b); err != nil {
+		t.Fatalf("WriteCardStatus: %v", err)
+	}
+
+	fingerprint, err := g.Fingerprint(piv.SignatureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := g.Date(piv.SignatureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var grouped strings.Builder
+	for i := 0; i < len(fingerprint); i += 4 {
+		if i == len(fingerprint)/2 {
+			grouped.WriteString(" ")
+		}
+
+		grouped.WriteString(" " + fingerprint[i:i+4])
+	}
+
+	expected := `Reader ...........: Yubico YubiKey OTP+FIDO+CCID 00
+Application ID ...: D2760001240103040006123456780000
+Application type .: OpenPGP
+Version ..........: 3.4
+Manufacturer .....: Yubico
+Serial number ....: 12345678
+Name of cardholder: Jane Q Doe
+Language prefs ...: [not set]
+Salutation .......: 
+URL of public key : https://example.com/key.asc
+Login data .......: [not set]
+Signature PIN ....: forced
+Key attributes ...: ed25519 rsa2048 rsa2048
+Max. PIN lengths .: 127 127 127
+PIN retry counter : 3 0 3
+Signature counter : 0
+UIF setting ......: Sign=off Decrypt=off Auth=off
+Signature key ....:` + grouped.String() + `
+      created ....: ` + created.UTC().Format("2006-01-02 15:04:05") + `
+Encryption key....: [none]
+Authentication key: [none]
+General key info..: [none]
+`
+
+	if got := b.String(); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+}
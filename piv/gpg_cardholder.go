@@ -0,0 +1,245 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCardholderData is returned when a name, language, URL or certificate can't be stored on the card.
+var ErrCardholderData = errors.New("invalid cardholder data")
+
+const (
+	// maxCardHolderNameLength is the length of the name DO (5B).
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 26
+	// 4.4.2 DOs for PUT DATA.
+	maxCardHolderNameLength = 39
+	// maxLanguages is the number of 2 letter ISO 639 codes in the language preferences DO (5F2D).
+	maxLanguages = 4
+	// nameFiller separates the words of a name, ISO/IEC 7501-1.
+	nameFiller = "<"
+)
+
+// FormatCardHolderName encodes a name in the format ParseCardHolderName decodes,
+// Surname<<Given<Names, spaces are replaced with the < filler.
+// The given names may be empty.
+func FormatCardHolderName(surname, givenNames string) ([]byte, error) {
+	surname = strings.TrimSpace(surname)
+	givenNames = strings.TrimSpace(givenNames)
+
+	if surname == "" {
+		return nil, fmt.Errorf("%w: surname is required", ErrCardholderData)
+	}
+
+	if strings.Contains(surname, nameFiller) || strings.Contains(givenNames, nameFiller) {
+		return nil, fmt.Errorf("%w: names can't contain %q", ErrCardholderData, nameFiller)
+	}
+
+	name := strings.Join(strings.Fields(surname), nameFiller)
+	if givenNames != "" {
+		name += suffix + strings.Join(strings.Fields(givenNames), nameFiller)
+	}
+
+	if len(name) > maxCardHolderNameLength {
+		return nil, fmt.Errorf("%w: name is %d bytes, at most %d fit", ErrCardholderData, len(name), maxCardHolderNameLength)
+	}
+
+	return []byte(name), nil
+}
+
+// PutCardHolderName writes the name of the cardholder (5B), see FormatCardHolderName.
+// adminPIN defaults to the factory PIN.
+func (yk *GPGYubiKey) PutCardHolderName(adminPIN []byte, surname, givenNames string) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutCardHolderName\u001b[0m")
+	}
+
+	name, err := FormatCardHolderName(surname, givenNames)
+	if err != nil {
+		return err
+	}
+
+	if err := yk.putCardholderData(adminPIN, putNameTag, name); err != nil {
+		return fmt.Errorf("name: %w", err)
+	}
+
+	yk.gpgData.CardHolder = ParseCardHolderName(name)
+	yk.gpgData.setTag(cardHolderNameTag, name)
+
+	return nil
+}
+
+// PutLanguagePrefs writes the language preferences (5F2D), up to 4 ISO 639-1 codes like "en", most preferred first.
+// adminPIN defaults to the factory PIN.
+func (yk *GPGYubiKey) PutLanguagePrefs(adminPIN []byte, languages ...string) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutLanguagePrefs\u001b[0m")
+	}
+
+	if len(languages) == 0 || len(languages) > maxLanguages {
+		return fmt.Errorf("%w: %d languages, expected 1 to %d", ErrCardholderData, len(languages), maxLanguages)
+	}
+
+	var prefs []byte
+
+	for _, language := range languages {
+		if len(language) != 2 || !isLowerASCII(language) {
+			return fmt.Errorf("%w: language %q is not an ISO 639-1 code", ErrCardholderData, language)
+		}
+
+		prefs = append(prefs, language...)
+	}
+
+	if err := yk.putCardholderData(adminPIN, putLanguageTag, prefs); err != nil {
+		return fmt.Errorf("language: %w", err)
+	}
+
+	yk.gpgData.setTag(languagePrefsTag, prefs)
+
+	return nil
+}
+
+// PutPublicKeyURL writes the URL gpg --card-edit fetch retrieves the public keys from (5F50),
+// an empty URL deletes it. adminPIN defaults to the factory PIN.
+func (yk *GPGYubiKey) PutPublicKeyURL(adminPIN []byte, url string) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutPublicKeyURL\u001b[0m")
+	}
+
+	if yk.gpgData != nil && yk.gpgData.MaximumSpecialDOsLength > 0 && len(url) > int(yk.gpgData.MaximumSpecialDOsLength) {
+		return fmt.Errorf("%w: url is %d bytes, at most %d fit", ErrCardholderData, len(url), yk.gpgData.MaximumSpecialDOsLength)
+	}
+
+	if err := yk.putCardholderData(adminPIN, putURLTag, []byte(url)); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+
+	yk.gpgData.URL = url
+	yk.gpgData.setTag(urlTag, []byte(url))
+
+	return nil
+}
+
+// PutLoginData writes the login data (5E), the user name for a remote login, an empty login deletes it.
+// adminPIN defaults to the factory PIN.
+func (yk *GPGYubiKey) PutLoginData(adminPIN []byte, login string) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutLoginData\u001b[0m")
+	}
+
+	if yk.gpgData != nil && yk.gpgData.MaximumSpecialDOsLength > 0 && len(login) > int(yk.gpgData.MaximumSpecialDOsLength) {
+		return fmt.Errorf("%w: login data is %d bytes, at most %d fit", ErrCardholderData, len(login), yk.gpgData.MaximumSpecialDOsLength)
+	}
+
+	if err := yk.putCardholderData(adminPIN, putLoginDataTag, []byte(login)); err != nil {
+		return fmt.Errorf("login data: %w", err)
+	}
+
+	yk.gpgData.LoginData = login
+	yk.gpgData.setTag(loginDataTag, []byte(login))
+
+	return nil
+}
+
+// putCardholderData verifies PW3 and writes a DO.
+func (yk *GPGYubiKey) putCardholderData(adminPIN []byte, tag uint16, data []byte) error {
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if err := yk.adminLogin(adminPIN); err != nil {
+		return fmt.Errorf("verify admin pin: %w", err)
+	}
+
+	return gpgPutData(yk.tx, tag, data)
+}
+
+func isLowerASCII(s string) bool {
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetCardholderCertificate reads the certificate stored for slot, it's empty if there is none.
+// The certificate is usually DER encoded X.509 but the card doesn't check.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 57
+// 7.2.5 SELECT DATA.
+func (yk *GPGYubiKey) GetCardholderCertificate(slot KeyType) ([]byte, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.GetCardholderCertificate\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	return gpgGetCardholderCertificate(yk.tx, slot, yk.gpgData)
+}
+
+// PutCardholderCertificate stores der as the certificate of slot, an empty der deletes it.
+// It must fit MaximumCardholderCertificatesLength.
+//
+// It requires PW3 (83) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 57
+// 7.2.5 SELECT DATA.
+func (yk *GPGYubiKey) PutCardholderCertificate(slot KeyType, der []byte) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutCardholderCertificate\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if maxLen := int(yk.gpgData.MaximumCardholderCertificatesLength); maxLen > 0 && len(der) > maxLen {
+		return fmt.Errorf("%w: certificate is %d bytes, at most %d fit", ErrCardholderData, len(der), maxLen)
+	}
+
+	return gpgPutCardholderCertificate(yk.tx, slot, der, yk.gpgData)
+}
+
+// readLoginData reads the login data (5E) and URL (5F50), they aren't part of the cardholder related data.
+// ListCards doesn't need them, so they aren't read with the other data objects.
+func (g *GpgData) readLoginData(tx SCTx) error {
+	for _, do := range []struct {
+		tag uint16
+		key string
+	}{{putLoginDataTag, loginDataTag}, {putURLTag, urlTag}} {
+		data, err := gpgGetOptionalData(tx, do.tag)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(data) > 0 {
+			g.setTag(do.key, data)
+		}
+	}
+
+	g.LoginData = string(g.tlvValues[loginDataTag])
+	g.URL = string(g.tlvValues[urlTag])
+
+	return nil
+}
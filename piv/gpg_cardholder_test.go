@@ -0,0 +1,288 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormatCardHolderName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		surname    string
+		givenNames string
+		expected   string
+		parsed     string
+		expectErr  error
+	}{
+		{name: "surname", surname: "SnowFlake", expected: "SnowFlake", parsed: "SnowFlake"},
+		{name: "given names", surname: "Van Der Berg", givenNames: "Anna  Maria", expected: "Van<Der<Berg<<Anna<Maria", parsed: "Anna<Maria\nVan Der Berg"},
+		{name: "no surname", givenNames: "Anna", expectErr: ErrCardholderData},
+		{name: "filler", surname: "Snow<Flake", expectErr: ErrCardholderData},
+		{name: "too long", surname: strings.Repeat("a", 40), expectErr: ErrCardholderData},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := FormatCardHolderName(tc.surname, tc.givenNames)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if string(got) != tc.expected {
+				t.Errorf("got %q expected %q", got, tc.expected)
+			}
+
+			if parsed := ParseCardHolderName(got); parsed != tc.parsed {
+				t.Errorf("parsed %q expected %q", parsed, tc.parsed)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_PutCardholderData(t *testing.T) {
+	t.Parallel()
+
+	verify := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW3, data: []byte(defaultPW3)}
+
+	tests := []struct {
+		name      string
+		put       func(yk *GPGYubiKey) error
+		apdus     []apdu
+		expectErr error
+	}{
+		{
+			name: "name",
+			put:  func(yk *GPGYubiKey) error { return yk.PutCardHolderName(nil, "Flake", "Snow") },
+			apdus: []apdu{
+				verify,
+				{instruction: insPutDataDA, param2: putNameTag, data: []byte("Flake<<Snow")},
+			},
+		},
+		{
+			name: "languages",
+			put:  func(yk *GPGYubiKey) error { return yk.PutLanguagePrefs(nil, "en", "de") },
+			apdus: []apdu{
+				verify,
+				{instruction: insPutDataDA, param1: 0x5f, param2: 0x2d, data: []byte("ende")},
+			},
+		},
+		{
+			name:      "bad language",
+			put:       func(yk *GPGYubiKey) error { return yk.PutLanguagePrefs(nil, "EN") },
+			expectErr: ErrCardholderData,
+		},
+		{
+			name: "url",
+			put:  func(yk *GPGYubiKey) error { return yk.PutPublicKeyURL(nil, "https://example.com/key.asc") },
+			apdus: []apdu{
+				verify,
+				{instruction: insPutDataDA, param1: 0x5f, param2: 0x50, data: []byte("https://example.com/key.asc")},
+			},
+		},
+		{
+			name:      "url too long",
+			put:       func(yk *GPGYubiKey) error { return yk.PutPublicKeyURL(nil, strings.Repeat("a", 256)) },
+			expectErr: ErrCardholderData,
+		},
+		{
+			name:      "wrong admin pin",
+			put:       func(yk *GPGYubiKey) error { return yk.PutPublicKeyURL([]byte("wrong pin"), "") },
+			apdus:     []apdu{{instruction: insVerify, param2: paramOpenGPGVerifyPW3, data: []byte("wrong pin")}},
+			expectErr: AuthErr{2},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var transmitErr []error
+			if tc.expectErr != nil && len(tc.apdus) > 0 {
+				transmitErr = []error{&apduErr{0x63, 0xc2}}
+			}
+
+			yk := NewTestGpgYubikey(&GpgData{MaximumSpecialDOsLength: 255}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: make([][]byte, len(tc.apdus)), TransmitErr: transmitErr}
+
+			if !expectedError(t, tc.put(yk), tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tc.apdus))
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_CardholderCertificate(t *testing.T) {
+	t.Parallel()
+
+	der := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	selectData := []byte{0x60, 0x04, 0x5c, 0x02, 0x7f, 0x21}
+	getCert := apdu{instruction: insGetDataA, param1: 0x7f, param2: 0x21}
+
+	tests := []struct {
+		name          string
+		appletVersion string
+		f             func(yk *GPGYubiKey) ([]byte, error)
+		apdus         []apdu
+		responses     [][]byte
+		expected      []byte
+		expectErr     error
+	}{
+		{
+			name:          "get",
+			appletVersion: "5.7.1",
+			f:             func(yk *GPGYubiKey) ([]byte, error) { return yk.GetCardholderCertificate(DecryptionKey) },
+			apdus:         []apdu{{instruction: insSelectData, param1: 1, param2: 0x04, data: selectData}, getCert},
+			responses:     [][]byte{{}, der},
+			expected:      der,
+		},
+		{
+			name:          "put on old firmware",
+			appletVersion: "5.4.3",
+			f:             func(yk *GPGYubiKey) ([]byte, error) { return nil, yk.PutCardholderCertificate(AuthenticationKey, der) },
+			apdus: []apdu{
+				{instruction: insSelectData, param2: 0x04, data: append([]byte{0x06}, selectData...)},
+				{instruction: insPutDataDA, param1: 0x7f, param2: 0x21, data: der},
+			},
+			responses: [][]byte{{}, {}},
+		},
+		{
+			name:          "delete",
+			appletVersion: "5.7.1",
+			f:             func(yk *GPGYubiKey) ([]byte, error) { return nil, yk.PutCardholderCertificate(SignatureKey, nil) },
+			apdus: []apdu{
+				{instruction: insSelectData, param1: 2, param2: 0x04, data: selectData},
+				{instruction: insPutDataDA, param1: 0x7f, param2: 0x21},
+			},
+			responses: [][]byte{{}, {}},
+		},
+		{
+			name:          "too long",
+			appletVersion: "5.7.1",
+			f: func(yk *GPGYubiKey) ([]byte, error) {
+				return nil, yk.PutCardholderCertificate(SignatureKey, make([]byte, 2049))
+			},
+			expectErr: ErrCardholderData,
+		},
+		{
+			name:          "unknown slot",
+			appletVersion: "5.7.1",
+			f:             func(yk *GPGYubiKey) ([]byte, error) { return yk.GetCardholderCertificate(AttestKey) },
+			expectErr:     ErrUnknownKeyType,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{
+				ManufacturerID:                      ManufacturerYubico,
+				AppletVersion:                       tc.appletVersion,
+				MaximumCardholderCertificatesLength: 2048,
+			}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: tc.responses}
+
+			got, err := tc.f(yk)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !bytes.Equal(got, tc.expected) {
+				t.Errorf("got %x expected %x", got, tc.expected)
+			}
+
+			if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tc.apdus))
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_CardholderCertificate_Gnuk(t *testing.T) {
+	t.Parallel()
+
+	der := bytes.Repeat([]byte{0x01}, updateBinaryChunkLen+3)
+
+	newGnuk := func(apdus []apdu, responses [][]byte) *GPGYubiKey {
+		g := &GpgData{
+			ManufacturerID:                      ManufacturerFSIJ,
+			MaximumCardholderCertificatesLength: 2048,
+			Quirks:                              quirksFor(ManufacturerFSIJ, "Free Software Initiative of Japan Gnuk"),
+		}
+
+		yk := NewTestGpgYubikey(g, false, nil)
+		yk.tx = &TestSCTx{APDUList: apdus, ResponseList: responses}
+
+		return yk
+	}
+
+	t.Run("put", func(t *testing.T) {
+		t.Parallel()
+
+		apdus := []apdu{
+			{instruction: insUpdateBinary, param1: 0x80 | gnukCertificateFileID, data: der[:updateBinaryChunkLen]},
+			{instruction: insUpdateBinary, param2: updateBinaryChunkLen, data: der[updateBinaryChunkLen:]},
+		}
+
+		yk := newGnuk(apdus, [][]byte{{}, {}})
+		if !expectedError(t, yk.PutCardholderCertificate(AuthenticationKey, der), nil) {
+			return
+		}
+
+		if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(apdus) {
+			t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(apdus))
+		}
+	})
+
+	t.Run("get without select data", func(t *testing.T) {
+		t.Parallel()
+
+		yk := newGnuk([]apdu{{instruction: insGetDataA, param1: 0x7f, param2: 0x21}}, [][]byte{der})
+
+		got, err := yk.GetCardholderCertificate(AuthenticationKey)
+		if !expectedError(t, err, nil) {
+			return
+		}
+
+		if !bytes.Equal(got, der) {
+			t.Errorf("got %x expected %x", got, der)
+		}
+	})
+
+	t.Run("signature key", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newGnuk(nil, nil).GetCardholderCertificate(SignatureKey)
+		expectedError(t, err, ErrNotSupportedByCard)
+	})
+}
@@ -0,0 +1,167 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// newTestCurve25519Card generates Ed25519 signature and X25519 decryption keys on a simulated card.
+func newTestCurve25519Card(t *testing.T) (*piv.GPGYubiKey, ed25519.PublicKey, *ecdh.PublicKey) {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	sigKey, err := yk.GenerateOpenPGPKey(piv.SignatureKey, piv.AlgorithmEd25519)
+	if err != nil {
+		t.Fatalf("generate signature key: %v", err)
+	}
+
+	decKey, err := yk.GenerateOpenPGPKey(piv.DecryptionKey, piv.AlgorithmEd25519)
+	if err != nil {
+		t.Fatalf("generate decryption key: %v", err)
+	}
+
+	edKey, ok := sigKey.(ed25519.PublicKey)
+	if !ok {
+		t.Fatalf("signature key is %T", sigKey)
+	}
+
+	xKey, ok := decKey.(*ecdh.PublicKey)
+	if !ok || xKey.Curve() != ecdh.X25519() {
+		t.Fatalf("decryption key is %T", decKey)
+	}
+
+	if _, err := yk.GPGData(); err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	return yk, edKey, xKey
+}
+
+func TestGPGYubiKey_Curve25519(t *testing.T) {
+	t.Parallel()
+
+	yk, edKey, xKey := newTestCurve25519Card(t)
+	auth := piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)}
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for keyType, expected := range map[piv.KeyType]string{piv.SignatureKey: "EdDSA Ed25519", piv.DecryptionKey: "ECDH X25519"} {
+		if alg, err := g.Algorithm(keyType); err != nil || alg != expected {
+			t.Errorf("%s algorithm %q, %v expected %q", keyType, alg, err, expected)
+		}
+	}
+
+	t.Run("sign", func(t *testing.T) {
+		priv, err := yk.OpenPGPPrivateKey(piv.SignatureKey, auth)
+		if err != nil {
+			t.Fatalf("private key: %v", err)
+		}
+
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			t.Fatalf("%T is not a crypto.Signer", priv)
+		}
+
+		if pub, ok := signer.Public().(ed25519.PublicKey); !ok || !pub.Equal(edKey) {
+			t.Fatalf("public key %v expected %v", signer.Public(), edKey)
+		}
+
+		message := []byte("attack at dawn")
+
+		sig, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+
+		if !ed25519.Verify(edKey, message, sig) {
+			t.Error("signature doesn't verify")
+		}
+
+		if _, err := signer.Sign(rand.Reader, message, crypto.SHA512); err == nil {
+			t.Error("Ed25519ph signed")
+		}
+	})
+
+	t.Run("key agreement", func(t *testing.T) {
+		ephemeral, secret, err := piv.ECDHKeyAgreement(rand.Reader, xKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := yk.VerifyPIN(piv.PW2, []byte(pivtest.DefaultPIN)); err != nil {
+			t.Fatalf("verify pin: %v", err)
+		}
+
+		cardSecret, err := yk.DecryptECDH(ephemeral)
+		if err != nil {
+			t.Fatalf("decrypt ecdh: %v", err)
+		}
+
+		if !bytes.Equal(cardSecret, secret) {
+			t.Errorf("card secret %X expected %X", cardSecret, secret)
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		var msg bytes.Buffer
+
+		w, err := yk.EncryptOpenPGPMessage(&msg, piv.OpenPGPMessageOptions{})
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+
+		if _, err := io.WriteString(w, "attack at dawn"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := yk.DecryptOpenPGPMessage(&msg, auth)
+		if err != nil {
+			t.Fatalf("decrypt: %v", err)
+		}
+
+		plainText, err := io.ReadAll(r)
+		if err != nil || string(plainText) != "attack at dawn" {
+			t.Errorf("decrypted %q, %v", plainText, err)
+		}
+	})
+}
@@ -57,8 +57,8 @@ func (g *GpgData) update() error {
 	//        aid = card.tv['6E.4F']
 	// where 0x6E is a tag
 
-	// make sure aid is long enough.
-	aid, err := g.GetTag("6E.4F", 13)
+	// make sure aid is long enough for the serial in bytes 11-14.
+	aid, err := g.GetTag(applicationIDTag, 14)
 	if err != nil {
 		return err
 	}
@@ -71,12 +71,18 @@ func (g *GpgData) update() error {
 	g.LongName = fmt.Sprintf("%s SN %s OpenPGP %X.%X", g.Reader, g.Serial, aid[6], aid[7])
 
 	// card.cardholder = ''.join(chr(x) for x in card.tv['65.5B'])
-	cardHolderBytes, _ := g.GetTag("65.5B", 0)
+	cardHolderBytes, _ := g.GetTag(cardHolderNameTag, 0)
 	// cardholder is UTF-8.
 	// However, we need to fix it up a little.
 	// < becomes space, and the first << becomes a newline.
 	g.CardHolder = ParseCardHolderName(cardHolderBytes)
 
+	loginData, _ := g.GetTag(loginDataTag, 0)
+	g.LoginData = string(loginData)
+
+	url, _ := g.GetTag(urlTag, 0)
+	g.URL = string(url)
+
 	// if args.fingerprint is not None and (
 	//	args.fingerprint == keyfingerprint(card, 0) or
 	// args.fingerprint == keyfingerprint(card, 2) ):
@@ -93,18 +99,15 @@ func (g *GpgData) update() error {
 
 	g.Version = fmt.Sprintf("%X.%X", aid[6], aid[7])
 
-	mfg := ""
-	if aid[8] == 0 && aid[9] == 6 {
-		mfg = " (YubiCo)"
-	}
-
-	g.Manufacturer = fmt.Sprintf("%02X%02X%s", aid[8], aid[9], mfg)
+	g.Manufacturer = fmt.Sprintf("%02X%02X (%s)", aid[8], aid[9], ManufacturerName(binary.BigEndian.Uint16(aid[8:10])))
 
 	err = g.loadExtendedData()
 	if err != nil {
 		return err
 	}
 
+	g.applyQuirks(aid)
+
 	return nil
 }
 
@@ -118,8 +121,27 @@ const infoTemplate = `
   Cardholder Name: {{.CardHolder}}
 `
 
+// fullInfoTemplate adds the keys to infoTemplate like print_keys, with the layout of gpg --card-status.
+const fullInfoTemplate = infoTemplate + `{{define "key"}}{{with .}}{{.Algorithm}}  {{.ID}}  {{.Fingerprint}}  {{.Created.Format "2006-01-02 15:04:05"}}  {{.Origin}}{{with .UIF}}  uif={{.}}{{end}}{{else}}[none]{{end}}{{end -}}
+  Signature key:   {{template "key" index .Keys "Signature"}}
+  Encryption key:  {{template "key" index .Keys "Decryption"}}
+  Authentication:  {{template "key" index .Keys "Authentication"}}
+`
+
 // String makes a GpgData struct readable.
 func (g *GpgData) String() (string, error) {
+	return g.StringWithTemplate(infoTemplate)
+}
+
+// FullString is String with the algorithm, ID, fingerprint, date, origin and UIF of each key,
+// like gpg --card-status.
+func (g *GpgData) FullString() (string, error) {
+	return g.StringWithTemplate(fullInfoTemplate)
+}
+
+// StringWithTemplate renders g with the text/template tmpl, which is executed with the GpgDataJSON of g.
+// Keys is keyed by KeyType name and has no entry for empty slots, {{index .Keys "Signature"}} is nil for them.
+func (g *GpgData) StringWithTemplate(tmpl string) (string, error) {
 	if g == nil {
 		err := fmt.Errorf("nil key for String: %w", ErrKeyNotPresent)
 
@@ -127,7 +149,7 @@ func (g *GpgData) String() (string, error) {
 	}
 
 	t1 := template.New("GpgData")
-	t1, err := t1.Parse(infoTemplate)
+	t1, err := t1.Parse(tmpl)
 	if err != nil {
 		err = fmt.Errorf("failed to parse template for String: %w", err)
 
@@ -136,7 +158,7 @@ func (g *GpgData) String() (string, error) {
 
 	b := strings.Builder{}
 
-	err = t1.Execute(&b, *g)
+	err = t1.Execute(&b, g.JSON())
 	if err != nil {
 		err = fmt.Errorf("failed to execute template for String: %w", err)
 
@@ -146,7 +168,24 @@ func (g *GpgData) String() (string, error) {
 	return b.String(), nil
 }
 
-// Algorithm returns the Algorithm of the key at index.
+// keyAlgorithmAttributesTag returns the algorithm attributes tag (C1-C3) of a key.
+func keyAlgorithmAttributesTag(keyType KeyType) (string, error) {
+	switch keyType {
+	case SignatureKey:
+		return keyAlgorithmSignatureAttributesTag, nil
+	case DecryptionKey:
+		return keyAlgorithmDecryptionAttributesTag, nil
+	case AuthenticationKey:
+		return keyAlgorithmAuthenticationAttributesTag, nil
+	case AttestKey:
+		fallthrough
+	default:
+		return "", fmt.Errorf("%w : unknown value: %d", ErrNoSuchTag, keyType)
+	}
+}
+
+// Algorithm returns the Algorithm of the key at index, AlgorithmAttributes has the parsed attributes.
+// EC keys are the algorithm and curve, like EdDSA Ed25519, unknown algorithms are Alg=n.
 //
 //	def keyalg(card, n):
 //	   ka = card.tv[f'6E.73.C{n+1}']
@@ -161,19 +200,9 @@ func (g *GpgData) Algorithm(keyType KeyType) (string, error) {
 		return "", err
 	}
 
-	var key string
-
-	switch keyType {
-	case SignatureKey:
-		key = keyAlgorithmSignatureAttributesTag
-	case DecryptionKey:
-		key = keyAlgorithmDecryptionAttributesTag
-	case AuthenticationKey:
-		key = keyAlgorithmAuthenticationAttributesTag
-	case AttestKey:
-		fallthrough
-	default:
-		return "", fmt.Errorf("%w : unknown value: %d", ErrNoSuchTag, keyType)
+	key, err := keyAlgorithmAttributesTag(keyType)
+	if err != nil {
+		return "", err
 	}
 
 	// We expect between 1 and 4 bytes.
@@ -183,7 +212,7 @@ func (g *GpgData) Algorithm(keyType KeyType) (string, error) {
 	}
 
 	// RSA
-	if data[0] > 0 && data[0] > 3 {
+	if data[0] >= 1 && data[0] <= 3 {
 		if len(data) < 3 {
 			return "", fmt.Errorf("%w: expected length [%d] > [3]", ErrNoSuchAlgorithm, len(data))
 		}
@@ -193,9 +222,32 @@ func (g *GpgData) Algorithm(keyType KeyType) (string, error) {
 		return fmt.Sprintf("RSA %d", algorithmNum), nil
 	}
 
+	if attributes, err := ParseAlgorithmAttributes(data); err == nil {
+		return attributes.String(), nil
+	}
+
 	return fmt.Sprintf("Alg=%-*d", 4, data[0]), nil
 }
 
+// AlgorithmAttributes returns the parsed algorithm attributes of the key.
+func (g *GpgData) AlgorithmAttributes(keyType KeyType) (AlgorithmAttributes, error) {
+	if g == nil {
+		return AlgorithmAttributes{}, fmt.Errorf("nil key for AlgorithmAttributes: %w", ErrKeyNotPresent)
+	}
+
+	key, err := keyAlgorithmAttributesTag(keyType)
+	if err != nil {
+		return AlgorithmAttributes{}, err
+	}
+
+	data, err := g.GetTag(key, 1)
+	if err != nil {
+		return AlgorithmAttributes{}, err
+	}
+
+	return ParseAlgorithmAttributes(data)
+}
+
 // Fingerprint returns the Fingerprint of the key at index.
 //
 //	def keyfingerprint(card, n):
@@ -326,12 +378,16 @@ func (g *GpgData) Origin(keyType KeyType) (KeyOrigin, error) {
 		return rv, err
 	}
 
+	if f := g.Features(); !f.KeyInformation {
+		return KeyNotPresent, fmt.Errorf("%w: key information for %s on %s", ErrUnsupportedByCardVersion, keyType, f)
+	}
+
 	if tagLen, hasDate := g.HasTag(keyOriginAttributesTag); tagLen == 0 || !hasDate {
 		// If the tag doesn't exist it's not present.
 		return KeyNotPresent, fmt.Errorf("%w: key type %s not present", ErrKeyNotPresent, keyType)
 	}
 
-	data, err := g.GetTag(keyOriginAttributesTag, KeyTypeSize)
+	data, err := g.GetTag(keyOriginAttributesTag, max(KeyTypeSize, keyType.Offset()+1))
 	if err != nil {
 		return KeyNotPresent, err
 	}
@@ -346,6 +402,164 @@ func (g *GpgData) Origin(keyType KeyType) (KeyOrigin, error) {
 	return KeyOrigin(keyValue), nil
 }
 
+// keyUIFTag returns the UIF tag (D6-D8) of a key.
+func keyUIFTag(keyType KeyType) (string, error) {
+	switch keyType {
+	case SignatureKey:
+		return keySignatureUIFTag, nil
+	case DecryptionKey:
+		return keyDecryptionUIFTag, nil
+	case AuthenticationKey:
+		return keyAuthenticationUIFTag, nil
+	case AttestKey:
+		fallthrough
+	default:
+		return "", fmt.Errorf("%w : unknown value: %d", ErrNoSuchTag, keyType)
+	}
+}
+
+// UIF returns the UIF of the key at index from the application related data,
+// it's ErrNoSuchTag for cards that don't report it there, GPGYubiKey.UIF reads it from the card.
+func (g *GpgData) UIF(keyType KeyType) (UIF, error) {
+	if g == nil {
+		err := fmt.Errorf("nil key for UIF: %w", ErrKeyNotPresent)
+
+		return UIFOff, err
+	}
+
+	if f := g.Features(); !f.UIF {
+		return UIFOff, fmt.Errorf("%w: uif for %s on %s", ErrUnsupportedByCardVersion, keyType, f)
+	}
+
+	key, err := keyUIFTag(keyType)
+	if err != nil {
+		return UIFOff, err
+	}
+
+	data, err := g.GetTag(key, 1)
+	if err != nil {
+		return UIFOff, err
+	}
+
+	return UIF(data[0]), nil
+}
+
+// OpenPGPKeyInfo is the status of one key reference in the key information DO (DE).
+type OpenPGPKeyInfo struct {
+	// KeyRef is 1 for the signature, 2 for the decryption and 3 for the authentication key,
+	// higher references are additional keys.
+	KeyRef byte
+	Status KeyOrigin
+}
+
+// KeyType returns the key type of the signature, decryption and authentication key references.
+func (k OpenPGPKeyInfo) KeyType() (KeyType, bool) {
+	if k.KeyRef < 1 || k.KeyRef > byte(KeyTypeLast)+1 {
+		return KeyTypeUnknown, false
+	}
+
+	return KeyType(k.KeyRef - 1), true
+}
+
+// KeyInfo returns every key reference in the key information DO (DE) with its status,
+// including the additional keys of cards that have them.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 24.
+// 4.4.1 DOs for GET DATA.
+func (g *GpgData) KeyInfo() ([]OpenPGPKeyInfo, error) {
+	if g == nil {
+		return nil, fmt.Errorf("nil key for KeyInfo: %w", ErrKeyNotPresent)
+	}
+
+	if f := g.Features(); !f.KeyInformation {
+		return nil, fmt.Errorf("%w: key information on %s", ErrUnsupportedByCardVersion, f)
+	}
+
+	data, err := g.GetTag(keyOriginAttributesTag, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("key information has odd length [%d]: %w", len(data), ErrTooShort)
+	}
+
+	info := make([]OpenPGPKeyInfo, 0, len(data)/2)
+
+	for i := 0; i < len(data); i += 2 {
+		if data[i+1] > byte(KeyOriginLast) {
+			return nil, fmt.Errorf("status: [%d] of key reference [%d]: %w", data[i+1], data[i], ErrUnknownKeyOrigin)
+		}
+
+		info = append(info, OpenPGPKeyInfo{KeyRef: data[i], Status: KeyOrigin(data[i+1])})
+	}
+
+	return info, nil
+}
+
+// SignatureCount returns the digital signature counter (93) of the security support template (7A),
+// the number of signatures the signature key has made since it was generated or imported.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 24.
+// 4.4.1 DOs for GET DATA.
+func (g *GpgData) SignatureCount() (int, error) {
+	if g == nil {
+		return 0, fmt.Errorf("nil key for SignatureCount: %w", ErrKeyNotPresent)
+	}
+
+	data, err := g.GetTag(signatureCounterTag, signatureCounterLen)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, b := range data[:signatureCounterLen] {
+		count = count<<8 | int(b)
+	}
+
+	return count, nil
+}
+
+// countSignature keeps the cached signature counter in step with the card after a successful
+// PSO: COMPUTE DIGITAL SIGNATURE, which increments it by one, saving a GET DATA of 7A.
+// The cached counter stops at FFFFFF rather than wrapping.
+func (g *GpgData) countSignature() {
+	if g == nil {
+		return
+	}
+
+	data, err := g.GetTag(signatureCounterTag, signatureCounterLen)
+	if err != nil {
+		return
+	}
+
+	count := make([]byte, signatureCounterLen)
+	copy(count, data)
+
+	for i := signatureCounterLen - 1; i >= 0; i-- {
+		if count[i] != 0xff {
+			count[i]++
+			g.tlvValues[signatureCounterTag] = count
+
+			return
+		}
+
+		count[i] = 0
+	}
+}
+
+// resetSignatureCount zeroes the cached signature counter after the signature key is generated,
+// which resets it on the card.
+func (g *GpgData) resetSignatureCount() {
+	if g == nil {
+		return
+	}
+
+	if _, err := g.GetTag(signatureCounterTag, 0); err != nil {
+		return
+	}
+
+	g.tlvValues[signatureCounterTag] = make([]byte, signatureCounterLen)
+}
+
 func (g *GpgData) dprintf(format string, a ...any) {
 	if !g.debug {
 		return
@@ -383,7 +597,7 @@ func ExportRsaPublicKeyAsPemStr(publicKey *rsa.PublicKey) (string, error) {
 // We expect 2,3 to return b[1,2] which is 2 bytes #1 and #2.
 func getBytesWith1BasedIndexing(b []byte, start, end int) ([]byte, error) {
 	// since start is 1 shorter, end is 1 longer.
-	if b == nil || start > len(b) || end > len(b) {
+	if b == nil || start < 1 || start > end || end > len(b) {
 		return nil, ErrNotFound
 	}
 
@@ -394,7 +608,7 @@ func getBytesWith1BasedIndexing(b []byte, start, end int) ([]byte, error) {
 // The spec is 1 based.
 // we expect 2,3 to return b[1,2] which is 2 bytes #1 and #2.
 func getByteWith1BasedIndexing(b []byte, index int) (byte, error) {
-	if b == nil || index > len(b) {
+	if b == nil || index < 1 || index > len(b) {
 		return 0, ErrNotFound
 	}
 
@@ -521,6 +735,60 @@ func (g *GpgData) loadExtendedData() error {
 	return nil
 }
 
+// ExtendedLengthSupported reports whether the card capabilities in the historical bytes allow extended Lc and Le.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 30
+// 4.4.3.4 Historical bytes, the card capabilities are described in ISO 7816-4 8.1.1.2.7.
+func (g *GpgData) ExtendedLengthSupported() bool {
+	historical, err := g.GetTag(historicalBytesTag, 1)
+	if err != nil {
+		return false
+	}
+
+	var objects []byte
+
+	switch historical[0] {
+	case 0x00:
+		// compact-TLV objects followed by a 3 byte status indicator.
+		if len(historical) < 4 {
+			return false
+		}
+
+		objects = historical[1 : len(historical)-3]
+	case 0x80:
+		objects = historical[1:]
+	default:
+		return false
+	}
+
+	for len(objects) > 0 {
+		tag, length := objects[0]>>4, int(objects[0]&0x0f)
+		if len(objects) < 1+length {
+			return false
+		}
+
+		// card capabilities, the third byte has extended Lc and Le in bit 7.
+		if tag == 0x07 && length >= 3 {
+			return isSupported(objects[3], 0x40)
+		}
+
+		objects = objects[1+length:]
+	}
+
+	return false
+}
+
+// ExtendedLengthInformation returns the maximum number of bytes in a command and a response APDU from 7F66,
+// ok is false if the card doesn't have it.
+// The DO is defined in ISO 7816-4, two unsigned integers in 02 DOs.
+func (g *GpgData) ExtendedLengthInformation() (maxCommand, maxResponse int, ok bool) {
+	info, err := g.GetTag(extendedLengthInformationTag, 8)
+	if err != nil || info[0] != 0x02 || info[1] != 0x02 || info[4] != 0x02 || info[5] != 0x02 {
+		return 0, 0, false
+	}
+
+	return int(binary.BigEndian.Uint16(info[2:4])), int(binary.BigEndian.Uint16(info[6:8])), true
+}
+
 func (g *GpgData) setSecureMessaging(capabilitiesByte, smByte byte) error {
 	if g == nil {
 		err := fmt.Errorf("nil key for setSecureMessaging: %w", ErrKeyNotPresent)
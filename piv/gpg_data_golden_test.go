@@ -0,0 +1,137 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// appDataGolden is what is compared for each application related data blob in testdata/appdata.
+type appDataGolden struct {
+	Data          *piv.GpgDataJSON `json:"data"`
+	Features      piv.FeatureSet   `json:"features"`
+	Quirks        piv.CardQuirks   `json:"quirks"`
+	MalformedTags []string         `json:"malformedTags"`
+}
+
+// readHexFile reads hex split over lines, lines starting with # are comments.
+func readHexFile(t *testing.T, path string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "#") {
+			sb.WriteString(line)
+		}
+	}
+
+	data, err := hex.DecodeString(sb.String())
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	return data
+}
+
+// TestParseApplicationRelatedData_Golden parses the responses to GET DATA 6E of different cards and compares
+// them to the .golden.json next to them. Run with -update to rewrite the golden files after a deliberate change.
+func TestParseApplicationRelatedData_Golden(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob(filepath.Join("testdata", "appdata", "*.hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		t.Fatal("no application related data in testdata/appdata")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".hex")
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			g, err := piv.ParseApplicationRelatedData(readHexFile(t, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(appDataGolden{
+				Data:          g.JSON(),
+				Features:      g.Features(),
+				Quirks:        g.Quirks,
+				MalformedTags: g.MalformedTags,
+			}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, '\n')
+			golden := strings.TrimSuffix(file, ".hex") + ".golden.json"
+
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0o600); err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run go test -run TestParseApplicationRelatedData_Golden -update", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from %s:\n%s", name, golden, got)
+			}
+		})
+	}
+}
+
+func TestParseApplicationRelatedData(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range [][]byte{nil, {0x65, 0x00}, {0x6e, 0x02, 0x73, 0x00}} {
+		if _, err := piv.ParseApplicationRelatedData(data); err == nil {
+			t.Errorf("%x parsed", data)
+		}
+	}
+
+	_, err := piv.ParseApplicationRelatedData([]byte{0x65, 0x00})
+	if !errors.Is(err, piv.ErrMalformedData) {
+		t.Errorf("got %v expected %v", err, piv.ErrMalformedData)
+	}
+}
@@ -0,0 +1,156 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// ErrMalformedData is returned in ParseStrict mode for application related data that doesn't follow the specification.
+var ErrMalformedData = errors.New("malformed OpenPGP data object")
+
+// ParseMode is how strictly the data objects read when an OpenPGP card is opened are checked.
+type ParseMode int
+
+const (
+	// ParseLenient drops data objects with an unexpected length, so cards with firmware bugs can still be used.
+	// Reading a dropped data object returns ErrNoSuchTag, GpgData.MalformedTags lists them.
+	// Only a malformed application identifier fails, the card can't be identified without it.
+	ParseLenient ParseMode = iota
+	// ParseStrict fails to open cards whose data objects have an unexpected length.
+	ParseStrict
+)
+
+func (m ParseMode) String() string {
+	switch m {
+	case ParseLenient:
+		return "lenient"
+	case ParseStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("ParseMode(%d)", int(m))
+	}
+}
+
+// gpgDataLength is the length a data object must have, max is 0 when there is no upper bound.
+type gpgDataLength struct {
+	tag      string
+	min, max int
+	// required data objects are never dropped, they're needed to open the card.
+	required bool
+}
+
+// gpgDataLengths are the lengths of the data objects the application related data is parsed from.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+var gpgDataLengths = []gpgDataLength{
+	{tag: applicationIDTag, min: 16, max: 16, required: true},
+	{tag: historicalBytesTag, min: 1},
+	{tag: extendedLengthInformationTag, min: 8, max: 8},
+	{tag: extendedCapabilitiesTag, min: 10, max: 10},
+	{tag: keyAlgorithmSignatureAttributesTag, min: 1},
+	{tag: keyAlgorithmDecryptionAttributesTag, min: 1},
+	{tag: keyAlgorithmAuthenticationAttributesTag, min: 1},
+	{tag: pwStatusTag, min: pwStatusLen},
+	// more keys are allowed for cards with additional keys.
+	{tag: keyInformationTag, min: 3 * keyFingerprintLen},
+	{tag: caFingerprintsTag, min: 3 * keyFingerprintLen},
+	{tag: keyDateTag, min: 3 * keyDateLen},
+	{tag: keySignatureUIFTag, min: 1, max: 2},
+	{tag: keyDecryptionUIFTag, min: 1, max: 2},
+	{tag: keyAuthenticationUIFTag, min: 1, max: 2},
+	{tag: signatureCounterTag, min: signatureCounterLen, max: signatureCounterLen},
+}
+
+// parseGPGData parses the cardholder related data, application related data and security support template
+// read from the card in reader into a GpgData.
+func parseGPGData(reader string, mode ParseMode, objects ...[]byte) (*GpgData, error) {
+	gpgData := &GpgData{
+		tlvValues: bertlv.TLVData{},
+		Reader:    reader,
+		parseMode: mode,
+	}
+
+	for _, data := range objects {
+		rv, err := bertlv.Parse(data, &gpgData.tlvValues)
+		if err != nil {
+			return nil, err
+		}
+
+		gpgData.dprintf("bertlv: %s\n", bertlv.MakeJSONString(rv))
+	}
+
+	if err := gpgData.validate(); err != nil {
+		return nil, err
+	}
+
+	// need to call update to set up the fields.
+	if err := gpgData.update(); err != nil {
+		return nil, err
+	}
+
+	return gpgData, nil
+}
+
+// ParseApplicationRelatedData parses the response to GET DATA 6E, without the status word, the way
+// OpenGPG does in ParseLenient mode. Nothing is read from a card, so the cardholder data, signature
+// counter and applet version are left empty. It's for tools and tests that work on saved responses.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+func ParseApplicationRelatedData(data []byte) (*GpgData, error) {
+	if len(data) == 0 || data[0] != applicationRelatedDataTag {
+		return nil, fmt.Errorf("%w: not application related data", ErrMalformedData)
+	}
+
+	return parseGPGData("", ParseLenient, data)
+}
+
+// validate checks the lengths of the data objects before they're used, in ParseLenient mode
+// the malformed ones are dropped.
+func (g *GpgData) validate() error {
+	for _, l := range gpgDataLengths {
+		value, ok := g.tlvValues[l.tag]
+		if !ok || len(value) >= l.min && (l.max == 0 || len(value) <= l.max) {
+			continue
+		}
+
+		if g.parseMode == ParseStrict {
+			return fmt.Errorf("%w: %s has length [%d], expected [%d, %d]", ErrMalformedData, l.tag, len(value), l.min, l.max)
+		}
+
+		if l.required {
+			// update checks what it needs.
+			continue
+		}
+
+		g.dprintf("dropping malformed %s of length [%d]\n", l.tag, len(value))
+		delete(g.tlvValues, l.tag)
+		g.MalformedTags = append(g.MalformedTags, l.tag)
+	}
+
+	if info, ok := g.tlvValues[keyOriginAttributesTag]; ok && len(info)%2 != 0 {
+		if g.parseMode == ParseStrict {
+			return fmt.Errorf("%w: %s has odd length [%d]", ErrMalformedData, keyOriginAttributesTag, len(info))
+		}
+
+		delete(g.tlvValues, keyOriginAttributesTag)
+		g.MalformedTags = append(g.MalformedTags, keyOriginAttributesTag)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// testTLV returns a BER-TLV data object with a one byte length, or 81 and the length.
+func testTLV(tag []byte, value ...byte) []byte {
+	b := append([]byte{}, tag...)
+	if len(value) >= 0x80 {
+		b = append(b, 0x81)
+	}
+
+	return append(append(b, byte(len(value))), value...)
+}
+
+// testAppData returns application related data with aid and the discretionary data objects in 73.
+func testAppData(aid []byte, discretionary ...[]byte) []byte {
+	var dos []byte
+	for _, do := range discretionary {
+		dos = append(dos, do...)
+	}
+
+	return testTLV([]byte{0x6e}, append(testTLV([]byte{0x4f}, aid...), testTLV([]byte{0x73}, dos...)...)...)
+}
+
+var testAID = []byte{0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x03, 0x04, 0x00, 0x06, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00}
+
+// testResponses are the responses of basicOpenGPGTx to the GET DATA of 65, 6E and 7A without the status.
+func testResponses() [][]byte {
+	var rv [][]byte
+	for _, resp := range basicOpenGPGTx().ResponseList[1:4] {
+		rv = append(rv, resp[:len(resp)-2])
+	}
+
+	return rv
+}
+
+func TestParseGPGData(t *testing.T) {
+	t.Parallel()
+
+	extendedCapabilities := testTLV([]byte{0xc0}, 0x7d, 0x00, 0x0b, 0xfe, 0x08, 0x00, 0x00, 0xff, 0x00, 0x00)
+
+	tests := []struct {
+		name          string
+		appData       []byte
+		lenientErr    error
+		strictErr     error
+		wantMalformed []string
+	}{
+		{
+			name:    "valid",
+			appData: testAppData(testAID, extendedCapabilities),
+		},
+		{
+			name:          "short extended capabilities",
+			appData:       testAppData(testAID, testTLV([]byte{0xc0}, 0x7d, 0x00)),
+			strictErr:     ErrMalformedData,
+			wantMalformed: []string{extendedCapabilitiesTag},
+		},
+		{
+			name:          "short fingerprints and odd key information",
+			appData:       testAppData(testAID, extendedCapabilities, testTLV([]byte{0xc5}, 1, 2, 3), testTLV([]byte{0xde}, 1, 2, 3)),
+			strictErr:     ErrMalformedData,
+			wantMalformed: []string{keyInformationTag, keyOriginAttributesTag},
+		},
+		{
+			name:       "short aid",
+			appData:    testAppData(testAID[:13], extendedCapabilities),
+			lenientErr: ErrTooShort,
+			strictErr:  ErrMalformedData,
+		},
+		{
+			name:       "no aid",
+			appData:    testTLV([]byte{0x6e}, testTLV([]byte{0x73}, extendedCapabilities...)...),
+			lenientErr: ErrNoSuchTag,
+			strictErr:  ErrNoSuchTag,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			g, err := parseGPGData("reader", ParseLenient, test.appData)
+			if !errors.Is(err, test.lenientErr) {
+				t.Fatalf("lenient got %v expected %v", err, test.lenientErr)
+			}
+
+			if err == nil && !slices.Equal(g.MalformedTags, test.wantMalformed) {
+				t.Errorf("malformed %q expected %q", g.MalformedTags, test.wantMalformed)
+			}
+
+			if _, err := parseGPGData("reader", ParseStrict, test.appData); !errors.Is(err, test.strictErr) {
+				t.Errorf("strict got %v expected %v", err, test.strictErr)
+			}
+		})
+	}
+
+	t.Run("card", func(t *testing.T) {
+		t.Parallel()
+
+		g, err := parseGPGData("reader", ParseStrict, testResponses()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g.Serial != "3506994" {
+			t.Errorf("got serial %s", g.Serial)
+		}
+	})
+}
+
+// FuzzParseGPGData checks malformed data objects from a card can't panic, when they're parsed
+// and when the parsed data is used.
+func FuzzParseGPGData(f *testing.F) {
+	responses := testResponses()
+	f.Add(responses[0], responses[1], responses[2])
+	f.Add([]byte{}, testAppData(testAID, testTLV([]byte{0xc0}, 0xff, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)), []byte{})
+	f.Add([]byte{}, testAppData(testAID[:14], testTLV([]byte{0xde}, 1, 1, 2), testTLV([]byte{0x7f, 0x66}, 2, 2)), []byte{0x7a, 0x02, 0x93, 0x00})
+
+	f.Fuzz(func(t *testing.T, cardholder, appData, securitySupport []byte) {
+		for _, mode := range []ParseMode{ParseLenient, ParseStrict} {
+			g, err := parseGPGData("reader", mode, cardholder, appData, securitySupport)
+			if err != nil {
+				continue
+			}
+
+			useGPGData(g)
+		}
+	})
+}
+
+// useGPGData calls everything that reads the data objects of g.
+func useGPGData(g *GpgData) {
+	for _, keyType := range []KeyType{SignatureKey, DecryptionKey, AuthenticationKey, AttestKey, KeyTypeUnknown} {
+		_, _ = g.Algorithm(keyType)
+		_, _ = g.AlgorithmAttributes(keyType)
+		_, _ = g.Fingerprint(keyType)
+		_, _ = g.ID(keyType)
+		_, _ = g.Date(keyType)
+		_, _ = g.Origin(keyType)
+		_, _ = g.UIF(keyType)
+	}
+
+	_, _ = g.String()
+	_, _ = g.FullString()
+	_, _ = g.MarshalJSON()
+	_, _ = g.KeyInfo()
+	_, _ = g.SignatureCount()
+	_, _ = g.PWStatus()
+	_, _ = g.ecdhCurve()
+	_ = g.ExtendedLengthSupported()
+	_, _, _ = g.ExtendedLengthInformation()
+
+	g.countSignature()
+}
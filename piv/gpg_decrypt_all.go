@@ -0,0 +1,93 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"fmt"
+)
+
+// DecryptAll decrypts every ciphertext with the decryption key in the card's transaction, for tools that
+// unwrap hundreds of wrapped keys. The OpenPGP applet is selected once and PW1 stays verified for decryption
+// until the applet is selected again, so the PIN is presented at most once: auth.PINPrompt is called the
+// first time the card asks for the PIN and its PIN is reused if the card asks again.
+//
+// The ciphertexts are what OpenPGPDecrypter's Decrypt takes, PKCS#1 v1.5 ciphertexts for RSA keys and
+// the sender's ephemeral public keys for ECDH keys, whose shared secrets are returned.
+// DecryptAll stops at the first failure or when ctx is done, the plaintexts decrypted before that are
+// returned with the error. When ctx is done while the card waits for a touch the transaction is ended,
+// call BeginTransaction before using the card again.
+func (yk *GPGYubiKey) DecryptAll(ctx context.Context, ciphertexts [][]byte, auth OpenPGPKeyAuth) ([][]byte, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.DecryptAll\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if len(ciphertexts) == 0 {
+		return nil, nil
+	}
+
+	auth.PINPrompt = promptOnce(auth.PINPrompt)
+
+	dec, err := yk.OpenPGPDecrypter(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	// another program may have selected a different applet between transactions, so select it once here.
+	if err := ykSelectOpenGPGApplication(yk.tx); err != nil {
+		return nil, fmt.Errorf("selecting openpgp applet: %w", err)
+	}
+
+	plaintexts := make([][]byte, 0, len(ciphertexts))
+
+	for i, ciphertext := range ciphertexts {
+		plaintext, err := runContext(ctx, &yk.tx, &yk.pending, yk.EndTransaction, func() ([]byte, error) {
+			return dec.Decrypt(nil, ciphertext, nil)
+		})
+		if err != nil {
+			return plaintexts, fmt.Errorf("ciphertext %d: %w", i, err)
+		}
+
+		plaintexts = append(plaintexts, plaintext)
+	}
+
+	return plaintexts, nil
+}
+
+// promptOnce returns a prompt that calls prompt the first time and returns the same PIN after that.
+func promptOnce(prompt func() ([]byte, error)) func() ([]byte, error) {
+	if prompt == nil {
+		return nil
+	}
+
+	var pin []byte
+
+	return func() ([]byte, error) {
+		if pin != nil {
+			return pin, nil
+		}
+
+		p, err := prompt()
+		if err == nil {
+			pin = p
+		}
+
+		return p, err
+	}
+}
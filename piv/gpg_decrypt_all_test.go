@@ -0,0 +1,136 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_DecryptAll(t *testing.T) {
+	t.Parallel()
+
+	yk, _, xKey := newTestCurve25519Card(t)
+
+	var (
+		ciphertexts [][]byte
+		secrets     [][]byte
+	)
+
+	for i := 0; i < 20; i++ {
+		ephemeral, secret, err := piv.ECDHKeyAgreement(rand.Reader, xKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertexts = append(ciphertexts, ephemeral.Bytes())
+		secrets = append(secrets, secret)
+	}
+
+	prompts := 0
+	auth := piv.OpenPGPKeyAuth{PINPrompt: func() ([]byte, error) {
+		prompts++
+
+		return []byte(pivtest.DefaultPIN), nil
+	}}
+
+	plaintexts, err := yk.DecryptAll(context.Background(), ciphertexts, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prompts != 1 {
+		t.Errorf("PIN prompted %d times", prompts)
+	}
+
+	for i := range secrets {
+		if !bytes.Equal(plaintexts[i], secrets[i]) {
+			t.Errorf("secret %d is %X expected %X", i, plaintexts[i], secrets[i])
+		}
+	}
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		bad := append([][]byte{}, ciphertexts[:3]...)
+		bad = append(bad, []byte{1, 2, 3}, ciphertexts[3])
+
+		plaintexts, err := yk.DecryptAll(context.Background(), bad, auth)
+		if err == nil {
+			t.Fatal("invalid ciphertext decrypted")
+		}
+
+		if len(plaintexts) != 3 {
+			t.Errorf("got %d plaintexts expected 3", len(plaintexts))
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := yk.DecryptAll(ctx, ciphertexts, auth); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v expected %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestGPGYubiKey_DecryptAllRSA(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	pub, err := yk.GenerateOpenPGPKey(piv.DecryptionKey, piv.AlgorithmRSA2048)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	keys := [][]byte{[]byte("wrapped key one"), []byte("wrapped key two")}
+
+	var ciphertexts [][]byte
+
+	for _, key := range keys {
+		ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, pub.(*rsa.PublicKey), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+
+	plaintexts, err := yk.DecryptAll(context.Background(), ciphertexts, piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range keys {
+		if !bytes.Equal(plaintexts[i], keys[i]) {
+			t.Errorf("plaintext %d is %q expected %q", i, plaintexts[i], keys[i])
+		}
+	}
+}
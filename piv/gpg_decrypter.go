@@ -0,0 +1,274 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	apduerr "github.com/areese/piv-go/piv/apdu"
+)
+
+var (
+	// ErrPINRequired is returned when the card needs PW1 and OpenPGPKeyAuth can't provide it.
+	ErrPINRequired = errors.New("pin required but wasn't provided")
+	// ErrPINBlocked is returned when the error counter of the PIN is zero, it has to be reset with the reset code or admin PIN.
+	ErrPINBlocked = apduerr.ErrPINBlocked
+	// ErrSecurityStatusNotSatisfied is returned when the card refuses a key operation after the PIN was presented,
+	// e.g. the touch policy wasn't satisfied.
+	ErrSecurityStatusNotSatisfied = apduerr.ErrSecurityStatusNotSatisfied
+	// ErrConditionsNotSatisfied is returned when the key can't be used in the current state of the card.
+	ErrConditionsNotSatisfied = apduerr.ErrConditionsNotSatisfied
+	// ErrDecryptionFailed is returned when the card rejects a ciphertext.
+	ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// OpenPGPKeyAuth is used to authenticate against the OpenPGP applet on each signing and
+// decryption request.
+type OpenPGPKeyAuth struct {
+	// PIN, if provided, is a static PIN used to authenticate against the key.
+	// If provided, PINPrompt is ignored.
+	PIN []byte
+	// PINPrompt can be used to interactively request the PIN from the user. The
+	// method is only called when the card asks for the PIN, usually once per session
+	// unless the signature PIN is only valid for one signature.
+	PINPrompt func() (pin []byte, err error)
+	// TouchCallback, if provided, is called before a key with a UIF other than UIFOff is
+	// used, so a UI can ask the user to tap the YubiKey.
+	TouchCallback func()
+}
+
+func (k OpenPGPKeyAuth) authTx(yk *GPGYubiKey, pwField byte) error {
+	pin := k.PIN
+	if len(pin) == 0 && k.PINPrompt != nil {
+		p, err := k.PINPrompt()
+		if err != nil {
+			return fmt.Errorf("pin prompt: %w", err)
+		}
+
+		pin = p
+	}
+
+	if len(pin) == 0 {
+		return ErrPINRequired
+	}
+
+	if len(pin) < minPW1Length {
+		return ErrTooShort
+	}
+
+	return gpgKeyError(yk.login(pin, pwField))
+}
+
+// touch calls TouchCallback when uif requires a touch.
+func (k OpenPGPKeyAuth) touch(uif UIF) {
+	if k.TouchCallback != nil && uif != UIFOff {
+		k.TouchCallback()
+	}
+}
+
+// uif reads the UIF of keyType when there's a TouchCallback to call.
+func (k OpenPGPKeyAuth) uif(yk *GPGYubiKey, keyType KeyType) (UIF, error) {
+	if k.TouchCallback == nil || !yk.gpgData.Features().UIF {
+		return UIFOff, nil
+	}
+
+	return gpgGetUIF(yk.tx, keyType)
+}
+
+// do runs f, presenting the PIN and running it again if the card says the PIN is needed.
+func (k OpenPGPKeyAuth) do(yk *GPGYubiKey, pwField byte, f func(tx SCTx) ([]byte, error)) ([]byte, error) {
+	out, err := f(yk.tx)
+	if !gpgPINNeeded(err) {
+		return out, gpgKeyError(err)
+	}
+
+	if err := k.authTx(yk, pwField); err != nil {
+		return nil, err
+	}
+
+	out, err = f(yk.tx)
+
+	return out, gpgKeyError(err)
+}
+
+// gpgPINNeeded is true when a key operation failed because PW1 wasn't presented.
+func gpgPINNeeded(err error) bool {
+	var e *apduErr
+
+	return errors.As(err, &e) && e.Status() == 0x6982
+}
+
+// gpgKeyError maps the status words of key operations to errors, the card error is still wrapped.
+// ErrSecurityStatusNotSatisfied, ErrPINBlocked and ErrConditionsNotSatisfied already come from the *apdu.Error.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 51
+// 7.1 Status Bytes.
+func gpgKeyError(err error) error {
+	var e *apduerr.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+
+	var mapped error
+
+	switch e.Status() {
+	case 0x6a80:
+		mapped = ErrDecryptionFailed
+	case 0x6a88:
+		mapped = ErrKeyNotPresent
+	case 0x6a81, 0x6d00, 0x6e00:
+		mapped = ErrNotSupportedByCard
+	default:
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", mapped, err)
+}
+
+// openPGPDecrypter is the decryption key of the OpenPGP applet.
+type openPGPDecrypter struct {
+	yk *GPGYubiKey
+	// key is the key PSO:DECIPHER uses, see ManageSecurityEnvironment.
+	key  KeyType
+	pub  crypto.PublicKey
+	auth OpenPGPKeyAuth
+	uif  UIF
+}
+
+var _ crypto.Decrypter = (*openPGPDecrypter)(nil)
+
+// OpenPGPDecrypter returns the decryption key of the card as a crypto.Decrypter.
+// The public key is an *rsa.PublicKey or, for ECDH keys, an *ecdh.PublicKey.
+//
+// RSA keys decrypt PKCS#1 v1.5 ciphertexts, the card removes the padding and OAEP
+// returns ErrNotSupportedByCard.
+// For ECDH keys msg is the encoded ephemeral public key of the sender and Decrypt returns
+// the shared secret, see DecryptECDH.
+//
+// The PIN is presented from auth when the card asks for it, the errors of the card are
+// mapped to ErrPINRequired, ErrPINBlocked, AuthErr and the other errors of this package.
+// The UIF of the key is read here when auth has a TouchCallback.
+//
+// After ManageSecurityEnvironment chose the authentication key for SecurityOperationDecipher,
+// the authentication key decrypts instead.
+func (yk *GPGYubiKey) OpenPGPDecrypter(auth OpenPGPKeyAuth) (crypto.Decrypter, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.OpenPGPDecrypter\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	key := yk.securityEnvironmentKey(SecurityOperationDecipher)
+	if key != DecryptionKey {
+		return yk.openPGPDecrypterWithKey(key, auth)
+	}
+
+	var pub crypto.PublicKey
+
+	pub, err := yk.ReadECDHPublicKey()
+	if errors.Is(err, ErrNotECDHKey) {
+		pub, err = yk.ReadPublicKey(AsymmetricConfidentiality)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read decryption key: %w", err)
+	}
+
+	uif, err := auth.uif(yk, DecryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openPGPDecrypter{yk: yk, key: DecryptionKey, pub: pub, auth: auth, uif: uif}, nil
+}
+
+// openPGPDecrypterWithKey returns a decrypter for the key chosen with ManageSecurityEnvironment,
+// EC keys made for signing are used for ECDH on their curve.
+func (yk *GPGYubiKey) openPGPDecrypterWithKey(key KeyType, auth OpenPGPKeyAuth) (crypto.Decrypter, error) {
+	pub, err := yk.ReadOpenPGPPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("read %s key: %w", key, err)
+	}
+
+	switch p := pub.(type) {
+	case *rsa.PublicKey, *ecdh.PublicKey:
+	case *ecdsa.PublicKey:
+		if pub, err = p.ECDH(); err != nil {
+			return nil, fmt.Errorf("%w: %s key: %w", ErrNoSuchAlgorithm, key, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s key %T can't decrypt", ErrNoSuchAlgorithm, key, pub)
+	}
+
+	uif, err := auth.uif(yk, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openPGPDecrypter{yk: yk, key: key, pub: pub, auth: auth, uif: uif}, nil
+}
+
+func (d *openPGPDecrypter) Public() crypto.PublicKey {
+	return d.pub
+}
+
+func (d *openPGPDecrypter) Decrypt(_ io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	switch pub := d.pub.(type) {
+	case *rsa.PublicKey:
+		o, err := decryptOptionsFrom(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.Padding != PaddingPKCS1v15 {
+			return nil, fmt.Errorf("%s padding: %w", o.Padding, ErrNotSupportedByCard)
+		}
+
+		if len(msg) == 0 {
+			return nil, ErrTooShort
+		}
+
+		d.auth.touch(d.uif)
+
+		return d.auth.do(d.yk, paramOpenGPGVerifyPW2, gpgWithSecurityEnvironment(SecurityOperationDecipher, d.key, func(tx SCTx) ([]byte, error) {
+			return gpgDecipher(tx, msg)
+		}))
+	case *ecdh.PublicKey:
+		if opts != nil {
+			return nil, fmt.Errorf("%w: decrypter options %T for an ecdh key", ErrNoSuchAlgorithm, opts)
+		}
+
+		peer, err := pub.Curve().NewPublicKey(msg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: peer key is not on the curve of the decryption key: %w", ErrNoSuchAlgorithm, err)
+		}
+
+		d.auth.touch(d.uif)
+
+		return d.auth.do(d.yk, paramOpenGPGVerifyPW2, gpgWithSecurityEnvironment(SecurityOperationDecipher, d.key, func(tx SCTx) ([]byte, error) {
+			return gpgDecipherECDH(tx, peer.Bytes())
+		}))
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnknownKeyType, d.pub)
+	}
+}
@@ -0,0 +1,222 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestOpenPGPDecrypter_RSA(t *testing.T) {
+	t.Parallel()
+
+	ciphertext := []byte{0x01, 0x02, 0x03}
+	plainText := []byte("secret")
+	pin := []byte("123456")
+
+	decipher := apdu{
+		instruction: insPerformSecurityOperation,
+		param1:      securityOperationDecipherParam1,
+		param2:      securityOperationDecipherParam2,
+		data:        append([]byte{0x00}, ciphertext...),
+	}
+	verify := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW2, data: pin}
+	pinNeeded := &apduErr{0x69, 0x82}
+
+	tests := []struct {
+		name         string
+		auth         OpenPGPKeyAuth
+		opts         crypto.DecrypterOpts
+		apdus        []apdu
+		responses    [][]byte
+		transmitErr  []error
+		expectPrompt int
+		expectErr    error
+	}{
+		{
+			name:      "pin already presented",
+			apdus:     []apdu{decipher},
+			responses: [][]byte{plainText},
+		},
+		{
+			name:        "static pin",
+			auth:        OpenPGPKeyAuth{PIN: pin},
+			apdus:       []apdu{decipher, verify, decipher},
+			responses:   [][]byte{nil, nil, plainText},
+			transmitErr: []error{pinNeeded, nil, nil},
+		},
+		{
+			name:         "pin prompt",
+			auth:         OpenPGPKeyAuth{PINPrompt: func() ([]byte, error) { return pin, nil }},
+			apdus:        []apdu{decipher, verify, decipher},
+			responses:    [][]byte{nil, nil, plainText},
+			transmitErr:  []error{pinNeeded, nil, nil},
+			expectPrompt: 1,
+		},
+		{
+			name:        "no pin",
+			apdus:       []apdu{decipher},
+			responses:   [][]byte{nil},
+			transmitErr: []error{pinNeeded},
+			expectErr:   ErrPINRequired,
+		},
+		{
+			name:        "wrong pin",
+			auth:        OpenPGPKeyAuth{PIN: pin},
+			apdus:       []apdu{decipher, verify},
+			responses:   [][]byte{nil, nil},
+			transmitErr: []error{pinNeeded, &apduErr{0x63, 0xc2}},
+			expectErr:   AuthErr{2},
+		},
+		{
+			name:        "pin blocked",
+			apdus:       []apdu{decipher},
+			responses:   [][]byte{nil},
+			transmitErr: []error{&apduErr{0x69, 0x83}},
+			expectErr:   ErrPINBlocked,
+		},
+		{
+			name:        "bad ciphertext",
+			apdus:       []apdu{decipher},
+			responses:   [][]byte{nil},
+			transmitErr: []error{&apduErr{0x6a, 0x80}},
+			expectErr:   ErrDecryptionFailed,
+		},
+		{
+			name:      "oaep",
+			opts:      &rsa.OAEPOptions{},
+			apdus:     []apdu{},
+			responses: [][]byte{},
+			expectErr: ErrNotSupportedByCard,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			prompts := 0
+			auth := tc.auth
+
+			if auth.PINPrompt != nil {
+				prompt := auth.PINPrompt
+				auth.PINPrompt = func() ([]byte, error) {
+					prompts++
+
+					return prompt()
+				}
+			}
+
+			yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: tc.responses, TransmitErr: tc.transmitErr}
+
+			d := &openPGPDecrypter{yk: yk, key: DecryptionKey, pub: &rsa.PublicKey{}, auth: auth}
+
+			got, err := d.Decrypt(rand.Reader, ciphertext, tc.opts)
+
+			if prompts != tc.expectPrompt {
+				t.Errorf("got %d pin prompts expected %d", prompts, tc.expectPrompt)
+			}
+
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !bytes.Equal(got, plainText) {
+				t.Errorf("got %q expected %q", got, plainText)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_OpenPGPDecrypterECDH(t *testing.T) {
+	t.Parallel()
+
+	cardKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ephemeral, secret, err := ECDHKeyAgreement(rand.Reader, cardKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := []byte("123456")
+	readKey := append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, cardKey.PublicKey().Bytes()))...)
+	decipher := apdu{
+		instruction: insPerformSecurityOperation,
+		param1:      securityOperationDecipherParam1,
+		param2:      securityOperationDecipherParam2,
+		data:        marshalASN1(0xa6, append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, ephemeral.Bytes()))...)),
+	}
+
+	yk := NewTestGpgYubikey(&GpgData{
+		tlvValues: bertlv.TLVData{keyAlgorithmDecryptionAttributesTag: mustHex(t, "12 2a8648ce3d030107")},
+	}, false, nil)
+	yk.tx = &TestSCTx{
+		APDUList: []apdu{
+			{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricRead, data: crtConfidentiality[:]},
+			decipher,
+			{instruction: insVerify, param2: paramOpenGPGVerifyPW2, data: pin},
+			decipher,
+		},
+		ResponseList: [][]byte{readKey, nil, nil, secret},
+		TransmitErr:  []error{nil, &apduErr{0x69, 0x82}, nil, nil},
+	}
+
+	prompted := false
+
+	d, err := yk.OpenPGPDecrypter(OpenPGPKeyAuth{PINPrompt: func() ([]byte, error) {
+		prompted = true
+
+		return pin, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, ok := d.Public().(*ecdh.PublicKey)
+	if !ok || !pub.Equal(cardKey.PublicKey()) {
+		t.Fatalf("got public key %v expected %x", d.Public(), cardKey.PublicKey().Bytes())
+	}
+
+	got, err := d.Decrypt(rand.Reader, ephemeral.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !prompted {
+		t.Error("expected a pin prompt")
+	}
+
+	if !bytes.Equal(got, secret) {
+		t.Errorf("got secret %x expected %x", got, secret)
+	}
+
+	if _, err := d.Decrypt(rand.Reader, []byte{0x04}, nil); !errors.Is(err, ErrNoSuchAlgorithm) {
+		t.Errorf("got %v expected %v", err, ErrNoSuchAlgorithm)
+	}
+}
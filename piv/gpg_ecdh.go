@@ -0,0 +1,173 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"fmt"
+	"io"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// openPGPCurve25519OID is Curve25519 for ECDH, 1.3.6.1.4.1.3029.1.5.1.
+// https://www.rfc-editor.org/rfc/rfc9580#section-9.2
+var openPGPCurve25519OID = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01} // nolint:gochecknoglobals
+
+// openPGPNativePointPrefix prefixes X25519 points in OpenPGP, some cards include it in the public key.
+const openPGPNativePointPrefix = 0x40
+
+// ecdhCurveFor returns the curve of the algorithm attributes of an ECDH key.
+// The OID may be followed by the import format byte.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 35
+// 4.4.3.9 Algorithm Attributes.
+func ecdhCurveFor(attributes []byte) (ecdh.Curve, error) {
+	if len(attributes) < 2 || attributes[0] != openPGPAlgorithmECDH {
+		return nil, ErrNotECDHKey
+	}
+
+	oid := attributes[1:]
+	if bytes.HasPrefix(oid, openPGPCurve25519OID) {
+		return ecdh.X25519(), nil
+	}
+
+	for _, c := range openPGPCurves {
+		if bytes.HasPrefix(oid, c.oid) && c.ecdh != nil {
+			return c.ecdh, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: curve oid %X", ErrNoSuchAlgorithm, oid)
+}
+
+// ecdhCurve returns the curve of the decryption key, ErrNotECDHKey if it's not an ECDH key.
+func (g *GpgData) ecdhCurve() (ecdh.Curve, error) {
+	attributes, err := g.GetTag(keyAlgorithmDecryptionAttributesTag, 1)
+	if err != nil {
+		return nil, fmt.Errorf("decryption key attributes: %w", err)
+	}
+
+	return ecdhCurveFor(attributes)
+}
+
+// parseECDHPublicKey parses the 86 point of a public key template.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 75
+// 7.2.14 GENERATE ASYMMETRIC KEY PAIR.
+func parseECDHPublicKey(tlvDataPtr *bertlv.TLVData, curve ecdh.Curve) (*ecdh.PublicKey, error) {
+	point, ok := (*tlvDataPtr)[openGpgECPointTag]
+	if !ok {
+		return nil, ErrNoPublicKeyPoint
+	}
+
+	if curve == ecdh.X25519() && len(point) == 33 && point[0] == openPGPNativePointPrefix {
+		point = point[1:]
+	}
+
+	pub, err := curve.NewPublicKey(point)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoPublicKeyPoint, err)
+	}
+
+	return pub, nil
+}
+
+// ReadECDHPublicKey returns the public key of the decryption key when it's an ECDH key, X25519 or a NIST curve.
+// It returns ErrNotECDHKey for RSA decryption keys, use ReadPublicKey for those.
+func (yk *GPGYubiKey) ReadECDHPublicKey() (*ecdh.PublicKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ReadECDHPublicKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	curve, err := yk.gpgData.ecdhCurve()
+	if err != nil {
+		return nil, err
+	}
+
+	tlvData, err := ykOpenGPGReadKey(yk.tx, AsymmetricConfidentiality, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseECDHPublicKey(tlvData, curve)
+}
+
+// DecryptECDH has the card compute the shared secret of the decryption key and peer, the ephemeral key of the sender.
+// For NIST curves the secret is the x coordinate of the shared point, as crypto/ecdh returns it.
+// It requires PW1 (82) has been presented, see AuthPIN.
+func (yk *GPGYubiKey) DecryptECDH(peer *ecdh.PublicKey) ([]byte, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.DecryptECDH\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	curve, err := yk.gpgData.ecdhCurve()
+	if err != nil {
+		return nil, err
+	}
+
+	if peer == nil || peer.Curve() != curve {
+		return nil, fmt.Errorf("%w: peer key is not on the curve of the decryption key", ErrNoSuchAlgorithm)
+	}
+
+	return gpgDecipherECDH(yk.tx, peer.Bytes())
+}
+
+// gpgDecipherECDH requires PW1 (82) has been presented.
+// The data is the public key template with the peer point, A6 { 7F49 { 86 point } }.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 69
+// 7.2.11 PSO: DECIPHER.
+func gpgDecipherECDH(tx SCTx, point []byte) ([]byte, error) {
+	template := append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, point))...)
+
+	cmd := apdu{
+		instruction: insPerformSecurityOperation,
+		param1:      securityOperationDecipherParam1,
+		param2:      securityOperationDecipherParam2,
+		data:        marshalASN1(0xa6, template),
+	}
+
+	secret, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// ECDHKeyAgreement is the sending side of DecryptECDH.
+// It generates an ephemeral key on the curve of recipient and returns its public key and the shared secret.
+// Derive the message key from the secret and send the ephemeral public key with the message.
+func ECDHKeyAgreement(rand io.Reader, recipient *ecdh.PublicKey) (*ecdh.PublicKey, []byte, error) {
+	ephemeral, err := recipient.Curve().GenerateKey(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ephemeral.PublicKey(), secret, nil
+}
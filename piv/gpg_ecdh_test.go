@@ -0,0 +1,148 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGPGYubiKey_DecryptECDH(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		curve      ecdh.Curve
+		attributes []byte
+		// nativePrefix has the card return the X25519 key with the OpenPGP 40 prefix.
+		nativePrefix bool
+		expectErr    error
+	}{
+		{
+			name:       "P-256",
+			curve:      ecdh.P256(),
+			attributes: mustHex(t, "12 2a8648ce3d030107"),
+		},
+		{
+			name:       "P-384 with import format",
+			curve:      ecdh.P384(),
+			attributes: mustHex(t, "12 2b81040022 ff"),
+		},
+		{
+			name:       "X25519",
+			curve:      ecdh.X25519(),
+			attributes: mustHex(t, "12 2b060104019755010501"),
+		},
+		{
+			name:         "X25519 with native prefix",
+			curve:        ecdh.X25519(),
+			attributes:   mustHex(t, "12 2b060104019755010501"),
+			nativePrefix: true,
+		},
+		{
+			name:       "RSA",
+			curve:      ecdh.P256(),
+			attributes: mustHex(t, "01 0800 0011 00"),
+			expectErr:  ErrNotECDHKey,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cardKey, err := tc.curve.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ephemeral, secret, err := ECDHKeyAgreement(rand.Reader, cardKey.PublicKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cardSecret, err := cardKey.ECDH(ephemeral)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			point := cardKey.PublicKey().Bytes()
+			if tc.nativePrefix {
+				point = append([]byte{openPGPNativePointPrefix}, point...)
+			}
+
+			readKey := append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, point))...)
+			decipher := append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, ephemeral.Bytes()))...)
+
+			yk := NewTestGpgYubikey(&GpgData{
+				tlvValues: bertlv.TLVData{keyAlgorithmDecryptionAttributesTag: tc.attributes},
+			}, false, nil)
+			yk.tx = &TestSCTx{
+				APDUList: []apdu{
+					{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricRead, data: crtConfidentiality[:]},
+					{
+						instruction: insPerformSecurityOperation,
+						param1:      securityOperationDecipherParam1,
+						param2:      securityOperationDecipherParam2,
+						data:        marshalASN1(0xa6, decipher),
+					},
+				},
+				ResponseList: [][]byte{readKey, cardSecret},
+			}
+
+			pub, err := yk.ReadECDHPublicKey()
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !pub.Equal(cardKey.PublicKey()) {
+				t.Fatalf("got public key %x expected %x", pub.Bytes(), cardKey.PublicKey().Bytes())
+			}
+
+			got, err := yk.DecryptECDH(ephemeral)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, secret) {
+				t.Errorf("got secret %x expected %x", got, secret)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_DecryptECDHWrongCurve(t *testing.T) {
+	t.Parallel()
+
+	peer, err := ecdh.P384().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yk := NewTestGpgYubikey(&GpgData{
+		tlvValues: bertlv.TLVData{keyAlgorithmDecryptionAttributesTag: mustHex(t, "12 2a8648ce3d030107")},
+	}, false, nil)
+	yk.tx = &TestSCTx{APDUList: []apdu{}, ResponseList: [][]byte{}}
+
+	_, err = yk.DecryptECDH(peer.PublicKey())
+	expectedError(t, err, ErrNoSuchAlgorithm)
+}
@@ -0,0 +1,298 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1" // nolint:gosec // OpenPGP v4 fingerprints are SHA1.
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// OpenPGP packet tags and signature types.
+// https://www.rfc-editor.org/rfc/rfc4880#section-4.3
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.2.1
+const (
+	openPGPTagSignature       = 2
+	openPGPTagPublicKey       = 6
+	openPGPTagUserID          = 13
+	openPGPTagPublicSubkey    = 14
+	openPGPSignatureVersion4  = 4
+	openPGPPositiveCertify    = 0x13
+	openPGPSubkeyBinding      = 0x18
+	openPGPSubpacketCreated   = 2
+	openPGPSubpacketIssuer    = 16
+	openPGPSubpacketKeyFlags  = 27
+	openPGPSubpacketIssuerFpr = 33
+
+	// key flags of the slots.
+	openPGPKeyFlagsSignature      = 0x03 // certify and sign.
+	openPGPKeyFlagsAuthentication = 0x21 // certify and authenticate.
+	openPGPKeyFlagsDecryption     = 0x0c // encrypt communications and storage.
+)
+
+// openPGPHashIDs are the hash algorithm IDs of self-signatures.
+// https://www.rfc-editor.org/rfc/rfc4880#section-9.4
+//
+// nolint:gochecknoglobals
+var openPGPHashIDs = map[crypto.Hash]byte{crypto.SHA256: 8, crypto.SHA384: 9, crypto.SHA512: 10}
+
+// ExportOpenPGPPublicKey returns the armored public key of slot with the user ID uid, which `gpg --import` reads.
+// The packets are built from the public key and the creation date on the card and have the fingerprint on the
+// card, see ErrFingerprintMismatch, the private key never needs to have been off the card.
+//
+// The signature and authentication keys are exported as a primary key, the decryption key is exported as a
+// subkey of the signature key. The user ID and the subkey are certified by the primary key on the card, which
+// asks for the PIN from auth and increments the signature counter for the signature key.
+// https://www.rfc-editor.org/rfc/rfc4880#section-11.1
+func (yk *GPGYubiKey) ExportOpenPGPPublicKey(slot KeyType, uid string, auth OpenPGPKeyAuth) ([]byte, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ExportOpenPGPPublicKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	primarySlot, flags := slot, byte(openPGPKeyFlagsSignature)
+
+	switch slot {
+	case SignatureKey:
+	case AuthenticationKey:
+		flags = openPGPKeyFlagsAuthentication
+	case DecryptionKey:
+		primarySlot = SignatureKey
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyType, slot)
+	}
+
+	primary, err := yk.openPGPCardPublicKeyBody(primarySlot)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := yk.OpenPGPPrivateKey(primarySlot, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnknownKeyType, priv)
+	}
+
+	userID := []byte(uid)
+	// a user ID is hashed with B4 and a four byte length.
+	hashedUserID := append([]byte{0xb4, 0, 0, 0, 0}, userID...)
+	binary.BigEndian.PutUint32(hashedUserID[1:5], uint32(len(userID)))
+
+	certification, err := openPGPSelfSignature(signer, primary, openPGPPositiveCertify, flags, hashedUserID)
+	if err != nil {
+		return nil, fmt.Errorf("certify user id: %w", err)
+	}
+
+	packets := append(openPGPPacket(openPGPTagPublicKey, primary), openPGPPacket(openPGPTagUserID, userID)...)
+	packets = append(packets, openPGPPacket(openPGPTagSignature, certification)...)
+
+	if slot == DecryptionKey {
+		subkey, err := yk.openPGPCardPublicKeyBody(DecryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		binding, err := openPGPSelfSignature(signer, primary, openPGPSubkeyBinding, openPGPKeyFlagsDecryption, openPGPHashedKey(subkey))
+		if err != nil {
+			return nil, fmt.Errorf("bind subkey: %w", err)
+		}
+
+		packets = append(packets, openPGPPacket(openPGPTagPublicSubkey, subkey)...)
+		packets = append(packets, openPGPPacket(openPGPTagSignature, binding)...)
+	}
+
+	var b bytes.Buffer
+
+	w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(packets); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// openPGPCardPublicKeyBody returns the public key packet body of the key in slot with the creation date on the card,
+// it has to have the fingerprint on the card.
+func (yk *GPGYubiKey) openPGPCardPublicKeyBody(slot KeyType) ([]byte, error) {
+	pub, err := yk.ReadOpenPGPPublicKey(slot)
+	if err != nil {
+		return nil, fmt.Errorf("read %s key: %w", slot, err)
+	}
+
+	created, err := yk.gpgData.Date(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := openPGPPublicKeyBody(slot, pub, created)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := yk.gpgData.Fingerprint(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	if !openPGPFingerprintMatches(slot, pub, body, fingerprint) {
+		return nil, fmt.Errorf("%w: %s", ErrFingerprintMismatch, fingerprint)
+	}
+
+	return body, nil
+}
+
+// openPGPHashedKey is a public key packet body as it's hashed in signatures and fingerprints, 99 and a two byte length.
+func openPGPHashedKey(body []byte) []byte {
+	return append([]byte{openPGPPublicKeyPacket, byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// openPGPPacket returns a packet with a new format header.
+// https://www.rfc-editor.org/rfc/rfc4880#section-4.2.2
+func openPGPPacket(tag byte, body []byte) []byte {
+	p := []byte{0xc0 | tag}
+
+	switch n := len(body); {
+	case n < 192:
+		p = append(p, byte(n))
+	case n < 8384:
+		n -= 192
+		p = append(p, byte(n>>8)+192, byte(n))
+	default:
+		p = append(p, 0xff, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return append(p, body...)
+}
+
+// openPGPSelfSignature returns the body of a v4 signature packet the primary key on the card makes over
+// its public key and data, the user ID or the subkey, with the creation time, key flags and issuer.
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.2.3
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.2.4
+func openPGPSelfSignature(signer crypto.Signer, primary []byte, sigType, flags byte, data []byte) ([]byte, error) {
+	hash := crypto.SHA256
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		// the hash has to be at least as long as the curve.
+		switch bits := pub.Curve.Params().BitSize; {
+		case bits > 384:
+			hash = crypto.SHA512
+		case bits > 256:
+			hash = crypto.SHA384
+		}
+	}
+
+	fingerprint := sha1.Sum(openPGPHashedKey(primary)) // nolint:gosec
+
+	created := make([]byte, 4)
+	binary.BigEndian.PutUint32(created, uint32(time.Now().Unix()))
+
+	hashed := openPGPSubpacket(openPGPSubpacketCreated, created)
+	hashed = append(hashed, openPGPSubpacket(openPGPSubpacketKeyFlags, []byte{flags})...)
+	hashed = append(hashed, openPGPSubpacket(openPGPSubpacketIssuerFpr, append([]byte{openPGPKeyVersion4}, fingerprint[:]...))...)
+	unhashed := openPGPSubpacket(openPGPSubpacketIssuer, fingerprint[12:])
+
+	// version, type, public key and hash algorithms, hashed subpackets.
+	sig := []byte{openPGPSignatureVersion4, sigType, primary[5], openPGPHashIDs[hash], byte(len(hashed) >> 8), byte(len(hashed))}
+	sig = append(sig, hashed...)
+
+	h := hash.New()
+	h.Write(openPGPHashedKey(primary))
+	h.Write(data)
+	h.Write(sig)
+
+	trailer := []byte{openPGPSignatureVersion4, 0xff, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(sig)))
+	h.Write(trailer)
+
+	digest := h.Sum(nil)
+
+	sig = append(sig, byte(len(unhashed)>>8), byte(len(unhashed)))
+	sig = append(sig, unhashed...)
+	sig = append(sig, digest[:2]...)
+
+	mpis, err := openPGPSignatureMPIs(signer, hash, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(sig, mpis...), nil
+}
+
+// openPGPSubpacket returns a signature subpacket with a one byte length, which all self-signature subpackets fit.
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.2.3.1
+func openPGPSubpacket(typ byte, data []byte) []byte {
+	return append([]byte{byte(1 + len(data)), typ}, data...)
+}
+
+// openPGPSignatureMPIs signs digest with the card and returns the algorithm specific MPIs of the signature,
+// RSA m^d, ECDSA r and s, EdDSA R and S.
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.2.2
+func openPGPSignatureMPIs(signer crypto.Signer, hash crypto.Hash, digest []byte) ([]byte, error) {
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		// EdDSA signs the digest itself.
+		sig, err := signer.Sign(rand.Reader, digest, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+
+		return append(openPGPMPI(sig[:32]), openPGPMPI(sig[32:])...), nil
+	case *ecdsa.PublicKey:
+		der, err := signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &rs); err != nil {
+			return nil, err
+		}
+
+		return append(openPGPMPI(rs.R.Bytes()), openPGPMPI(rs.S.Bytes())...), nil
+	default:
+		sig, err := signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		return openPGPMPI(sig), nil
+	}
+}
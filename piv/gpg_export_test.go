@@ -0,0 +1,113 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_ExportOpenPGPPublicKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		slot piv.KeyType
+		alg  piv.Algorithm
+	}{
+		{name: "rsa signature", slot: piv.SignatureKey, alg: piv.AlgorithmRSA2048},
+		{name: "p256 authentication", slot: piv.AuthenticationKey, alg: piv.AlgorithmEC256},
+		{name: "p384 decryption", slot: piv.DecryptionKey, alg: piv.AlgorithmEC384},
+		{name: "ed25519 signature", slot: piv.SignatureKey, alg: piv.AlgorithmEd25519},
+		{name: "x25519 decryption", slot: piv.DecryptionKey, alg: piv.AlgorithmEd25519},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+			if err != nil {
+				t.Fatalf("open gpg: %v", err)
+			}
+
+			t.Cleanup(func() { yk.Close() })
+
+			if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+				t.Fatalf("verify admin pin: %v", err)
+			}
+
+			slots := []piv.KeyType{tc.slot}
+			if tc.slot == piv.DecryptionKey {
+				slots = append(slots, piv.SignatureKey)
+			}
+
+			for _, slot := range slots {
+				if _, err := yk.GenerateOpenPGPKey(slot, tc.alg); err != nil {
+					t.Fatalf("generate %s: %v", slot, err)
+				}
+			}
+
+			g, err := yk.GPGData()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			armored, err := yk.ExportOpenPGPPublicKey(tc.slot, "Test <test@example.com>", piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+			if err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			// go-crypto drops user IDs and subkeys whose signatures don't verify.
+			keyRing, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+			if err != nil {
+				t.Fatalf("read key ring: %v", err)
+			}
+
+			if len(keyRing) != 1 {
+				t.Fatalf("%d keys", len(keyRing))
+			}
+
+			e := keyRing[0]
+			if _, ok := e.Identities["Test <test@example.com>"]; !ok {
+				t.Errorf("identities %v", e.Identities)
+			}
+
+			primarySlot := slots[len(slots)-1]
+			if fingerprint, _ := g.Fingerprint(primarySlot); fingerprint != piv.UpperCaseHexString(e.PrimaryKey.Fingerprint) {
+				t.Errorf("primary fingerprint %X expected %s", e.PrimaryKey.Fingerprint, fingerprint)
+			}
+
+			if tc.slot != piv.DecryptionKey {
+				return
+			}
+
+			if len(e.Subkeys) != 1 {
+				t.Fatalf("%d subkeys", len(e.Subkeys))
+			}
+
+			if fingerprint, _ := g.Fingerprint(piv.DecryptionKey); fingerprint != piv.UpperCaseHexString(e.Subkeys[0].PublicKey.Fingerprint) {
+				t.Errorf("subkey fingerprint %X expected %s", e.Subkeys[0].PublicKey.Fingerprint, fingerprint)
+			}
+		})
+	}
+}
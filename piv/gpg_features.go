@@ -0,0 +1,90 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ErrUnsupportedByCardVersion is returned for data objects and operations the OpenPGP card
+// specification version of the card doesn't have. It is also an ErrNotSupportedByCard.
+var ErrUnsupportedByCardVersion = fmt.Errorf("%w: not in the OpenPGP version of the card", ErrNotSupportedByCard)
+
+// FeatureSet reports which parts of the OpenPGP card specification a card implements,
+// derived from the version in its AID. Use GpgData.Features to get one.
+//
+// 2.x cards, such as older YubiKeys and Gnuk, don't have the 3.x data objects.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 9
+// 1.4 Changes to older versions.
+type FeatureSet struct {
+	// Major and Minor are the version of the specification from bytes 7-8 of the AID.
+	Major, Minor int
+	// KeyInformation is set if the card has the key information DO (DE) with the key origins.
+	KeyInformation bool
+	// UIF is set if the card has the user interaction flags (D6-D8).
+	UIF bool
+	// KDF is set if the card can have a KDF-DO (F9) for hashing the PINs.
+	KDF bool
+	// AESDecipher is set if PSO:DECIPHER and ENCIPHER may use an AES key.
+	AESDecipher bool
+	// ExtendedLengthInformation is set if the card has the extended length information DO (7F66).
+	ExtendedLengthInformation bool
+	// MSE is set if the card can have MSE change the key used for decryption and authentication.
+	MSE bool
+	// SelectData is set if SELECT DATA can choose an instance of DOs like the cardholder certificate.
+	SelectData bool
+}
+
+// featureSetFor returns the features of a card implementing version major.minor of the specification.
+func featureSetFor(major, minor int, manufacturer uint16) FeatureSet {
+	v3 := major >= 3
+	// the YubiKey 4 reports 2.1 and has the user interaction flags.
+	uif := v3 || manufacturer == ManufacturerYubico && major == 2 && minor >= 1
+	// Gnuk reports 2.0 and has the KDF-DO.
+	kdf := v3 || manufacturer == ManufacturerFSIJ
+
+	return FeatureSet{
+		Major:                     major,
+		Minor:                     minor,
+		KeyInformation:            v3,
+		UIF:                       uif,
+		KDF:                       kdf,
+		AESDecipher:               v3,
+		ExtendedLengthInformation: v3,
+		MSE:                       v3,
+		SelectData:                v3,
+	}
+}
+
+// Features returns what the card supports according to the version in its AID.
+// Everything is supported when there is no AID to read the version from.
+func (g *GpgData) Features() FeatureSet {
+	if g == nil {
+		return featureSetFor(3, 4, 0)
+	}
+
+	aid, err := g.GetTag(applicationIDTag, 10)
+	if err != nil {
+		return featureSetFor(3, 4, 0)
+	}
+
+	return featureSetFor(int(aid[6]), int(aid[7]), binary.BigEndian.Uint16(aid[8:10]))
+}
+
+// String returns the version like gpg --card-status.
+func (f FeatureSet) String() string {
+	return fmt.Sprintf("%d.%d", f.Major, f.Minor)
+}
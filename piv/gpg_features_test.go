@@ -0,0 +1,105 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGpgData_Features(t *testing.T) {
+	t.Parallel()
+
+	aid := func(major, minor, mfgHigh, mfgLow byte) []byte {
+		return []byte{0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, major, minor, mfgHigh, mfgLow, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}
+	}
+
+	// KDF and PSO:DEC/ENC with AES bits set, which are RFU on 2.x cards.
+	extendedCapabilities := []byte{0x73, 0x00, 0x00, 0x80, 0x08, 0x00, 0x08, 0x00, 0x04, 0x00}
+
+	cases := []struct {
+		name string
+		aid  []byte
+		// keyInformation, uif and kdf are the expected FeatureSet fields.
+		keyInformation bool
+		uif            bool
+		kdf            bool
+	}{
+		{name: "YubiKey 5", aid: aid(3, 4, 0x00, 0x06), keyInformation: true, uif: true, kdf: true},
+		{name: "YubiKey 4", aid: aid(2, 1, 0x00, 0x06), uif: true},
+		{name: "Gnuk", aid: aid(2, 0, 0xf5, 0x17), kdf: true},
+		{name: "2.1 card", aid: aid(2, 1, 0x12, 0x34)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := &GpgData{
+				tlvValues: bertlv.TLVData{
+					applicationIDTag:        tc.aid,
+					extendedCapabilitiesTag: extendedCapabilities,
+					keyOriginAttributesTag:  {0x01, 0x01, 0x02, 0x02, 0x03, 0x00},
+					keyInformationTag:       make([]byte, 3*keyFingerprintLen),
+					keySignatureUIFTag:      {0x01, 0x20},
+					keyDecryptionUIFTag:     {0x00, 0x20},
+					keyAuthenticationUIFTag: {0x00, 0x20},
+				},
+			}
+
+			if !expectedError(t, g.update(), nil) {
+				t.FailNow()
+			}
+
+			f := g.Features()
+			if f.KeyInformation != tc.keyInformation || f.UIF != tc.uif || f.KDF != tc.kdf {
+				t.Fatalf("got %+v", f)
+			}
+
+			if g.KDFSupported != tc.kdf {
+				t.Errorf("got KDFSupported %t expected %t", g.KDFSupported, tc.kdf)
+			}
+
+			if g.SupportsPSODecryptionEncryptionWithAES != f.AESDecipher {
+				t.Errorf("got SupportsPSODecryptionEncryptionWithAES %t expected %t", g.SupportsPSODecryptionEncryptionWithAES, f.AESDecipher)
+			}
+
+			origin, err := g.Origin(SignatureKey)
+			if tc.keyInformation && (err != nil || origin != KeyGeneratedByCard) {
+				t.Errorf("got origin %s, %v", origin, err)
+			}
+
+			if !tc.keyInformation && !errors.Is(err, ErrUnsupportedByCardVersion) {
+				t.Errorf("got origin error %v expected %v", err, ErrUnsupportedByCardVersion)
+			}
+
+			if _, err := g.KeyInfo(); tc.keyInformation == errors.Is(err, ErrUnsupportedByCardVersion) {
+				t.Errorf("got key information error %v", err)
+			}
+
+			uif, err := g.UIF(SignatureKey)
+			if tc.uif && (err != nil || uif != UIFOn) {
+				t.Errorf("got uif %s, %v", uif, err)
+			}
+
+			if !tc.uif && !(errors.Is(err, ErrUnsupportedByCardVersion) && errors.Is(err, ErrNotSupportedByCard)) {
+				t.Errorf("got uif error %v expected %v", err, ErrUnsupportedByCardVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// maxPublicKeySize limits the download of FetchPublicKey, keyservers return keys with many signatures in well under this.
+const maxPublicKeySize = 1 << 20
+
+// ErrPublicKeyURL is returned by FetchPublicKey when the URL DO is empty or doesn't have the keys of the card.
+var ErrPublicKeyURL = errors.New("no usable public key URL")
+
+// FetchPublicKey downloads the public key from the URL DO (5F50) like gpg --card-edit fetch.
+// The download may be ASCII armored or binary, the key holding one of the card's keys is returned,
+// or the first key if the card has none. client is http.DefaultClient if nil.
+func (yk *GPGYubiKey) FetchPublicKey(ctx context.Context, client *http.Client) (*openpgp.Entity, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.FetchPublicKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if yk.gpgData.URL == "" {
+		return nil, fmt.Errorf("%w: the url is not set", ErrPublicKeyURL)
+	}
+
+	entities, err := fetchOpenPGPKeys(ctx, client, yk.gpgData.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints [][]byte
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		if fp := yk.storedFingerprint(keyType); fp != nil {
+			fingerprints = append(fingerprints, fp)
+		}
+	}
+
+	if len(fingerprints) == 0 {
+		return entities[0], nil
+	}
+
+	for _, e := range entities {
+		if openPGPEntityHasKey(e, fingerprints) {
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s has no key of the card", ErrPublicKeyURL, yk.gpgData.URL)
+}
+
+// fetchOpenPGPKeys downloads and parses the keys at url.
+func fetchOpenPGPKeys(ctx context.Context, client *http.Client, url string) (openpgp.EntityList, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching public key: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching public key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching public key: %s", resp.Status)
+	}
+
+	r := bufio.NewReader(io.LimitReader(resp.Body, maxPublicKeySize))
+
+	var entities openpgp.EntityList
+
+	// armored keys start with -----BEGIN, binary ones with a packet tag.
+	if start, _ := r.Peek(1); bytes.Equal(start, []byte("-")) {
+		entities, err = openpgp.ReadArmoredKeyRing(r)
+	} else {
+		entities, err = openpgp.ReadKeyRing(r)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key from %s: %w", url, err)
+	}
+
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("%w: no keys at %s", ErrPublicKeyURL, url)
+	}
+
+	return entities, nil
+}
+
+// openPGPEntityHasKey reports whether the primary key or a subkey of e has one of the fingerprints.
+func openPGPEntityHasKey(e *openpgp.Entity, fingerprints [][]byte) bool {
+	has := func(fp []byte) bool {
+		return slices.ContainsFunc(fingerprints, func(f []byte) bool { return bytes.Equal(f, fp) })
+	}
+
+	if e.PrimaryKey != nil && has(e.PrimaryKey.Fingerprint) {
+		return true
+	}
+
+	return slices.ContainsFunc(e.Subkeys, func(k openpgp.Subkey) bool {
+		return k.PublicKey != nil && has(k.PublicKey.Fingerprint)
+	})
+}
@@ -0,0 +1,148 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// exportTestKey generates a signature key on card and returns its armored public key.
+func exportTestKey(t *testing.T, card *pivtest.Card) []byte {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(card).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.SignatureKey, piv.AlgorithmEd25519); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	armored, err := yk.ExportOpenPGPPublicKey(piv.SignatureKey, "Test <test@example.com>", piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	return armored
+}
+
+func TestGPGYubiKey_FetchPublicKey(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{})
+	armored := exportTestKey(t, card)
+	other := exportTestKey(t, pivtest.NewCard(pivtest.Options{}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/card.asc", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(armored) })
+	mux.HandleFunc("/other.asc", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(other) })
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		path string
+		// expectErr is checked with errors.Is if it is set.
+		fails     bool
+		expectErr error
+	}{
+		{name: "card key", path: "/card.asc"},
+		{name: "other key", path: "/other.asc", fails: true, expectErr: piv.ErrPublicKeyURL},
+		{name: "not found", path: "/missing.asc", fails: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			yk, err := pivtest.NewClient(card).OpenGPG(pivtest.Reader(0))
+			if err != nil {
+				t.Fatalf("open gpg: %v", err)
+			}
+			defer yk.Close()
+
+			if err := yk.PutPublicKeyURL(nil, srv.URL+tc.path); err != nil {
+				t.Fatalf("put url: %v", err)
+			}
+
+			e, err := yk.FetchPublicKey(context.Background(), srv.Client())
+			if tc.fails {
+				if err == nil || tc.expectErr != nil && !errors.Is(err, tc.expectErr) {
+					t.Fatalf("got %v expected %v", err, tc.expectErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			g, _ := yk.GPGData()
+			if fingerprint, _ := g.Fingerprint(piv.SignatureKey); fingerprint != piv.UpperCaseHexString(e.PrimaryKey.Fingerprint) {
+				t.Errorf("got %X expected %s", e.PrimaryKey.Fingerprint, fingerprint)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_LoginDataAndURL(t *testing.T) {
+	t.Parallel()
+
+	card := pivtest.NewCard(pivtest.Options{})
+
+	yk, err := pivtest.NewClient(card).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	if err := yk.PutLoginData(nil, "alice"); err != nil {
+		t.Fatalf("put login data: %v", err)
+	}
+
+	if err := yk.PutPublicKeyURL(nil, "https://example.com/alice.asc"); err != nil {
+		t.Fatalf("put url: %v", err)
+	}
+
+	yk.Close()
+
+	// the data objects are read again when the card is opened.
+	yk, err = pivtest.NewClient(card).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.LoginData != "alice" || g.URL != "https://example.com/alice.asc" {
+		t.Errorf("got login data %q url %q", g.LoginData, g.URL)
+	}
+}
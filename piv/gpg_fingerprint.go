@@ -0,0 +1,240 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1" // nolint:gosec // OpenPGP v4 fingerprints are SHA1.
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/bitcurves"
+)
+
+// OpenPGP public key algorithms.
+// https://www.rfc-editor.org/rfc/rfc4880#section-9.1
+// https://www.rfc-editor.org/rfc/rfc6637#section-5
+const (
+	openPGPAlgorithmRSA   = 1
+	openPGPAlgorithmECDH  = 18
+	openPGPAlgorithmECDSA = 19
+	openPGPAlgorithmEdDSA = 22
+
+	openPGPKeyVersion4     = 4
+	openPGPPublicKeyPacket = 0x99
+)
+
+// openPGPCurve has the OID and the ECDH KDF parameters (hash, cipher) of a curve.
+// secp256k1 has no crypto/ecdh curve, it's only used for ECDSA.
+// https://www.rfc-editor.org/rfc/rfc6637#section-11
+type openPGPCurve struct {
+	oid   []byte
+	kdf   []byte
+	curve elliptic.Curve
+	ecdh  ecdh.Curve
+}
+
+// nolint:gochecknoglobals
+var openPGPCurves = []openPGPCurve{
+	{oid: []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}, kdf: []byte{0x08, 0x07}, curve: elliptic.P256(), ecdh: ecdh.P256()},
+	{oid: []byte{0x2b, 0x81, 0x04, 0x00, 0x22}, kdf: []byte{0x09, 0x08}, curve: elliptic.P384(), ecdh: ecdh.P384()},
+	{oid: []byte{0x2b, 0x81, 0x04, 0x00, 0x23}, kdf: []byte{0x0a, 0x09}, curve: elliptic.P521(), ecdh: ecdh.P521()},
+	{oid: oidSecp256k1, kdf: []byte{0x08, 0x07}, curve: bitcurves.S256()},
+}
+
+// openPGPCurve25519 is Curve25519 for ECDH, with the KDF parameters of RFC 6637 for 256 bit curves.
+// https://www.rfc-editor.org/rfc/rfc9580#section-9.2
+//
+// nolint:gochecknoglobals
+var openPGPCurve25519 = openPGPCurve{oid: oidX25519, kdf: []byte{0x08, 0x07}, ecdh: ecdh.X25519()}
+
+func openPGPCurveFor(curve elliptic.Curve) (*openPGPCurve, error) {
+	for i := range openPGPCurves {
+		if openPGPCurves[i].curve == curve {
+			return &openPGPCurves[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: curve %s", ErrNoSuchAlgorithm, curve.Params().Name)
+}
+
+// openPGPMPI encodes a multiprecision integer, the bit count followed by the big endian value.
+func openPGPMPI(b []byte) []byte {
+	n := new(big.Int).SetBytes(b)
+	b = n.Bytes()
+
+	mpi := make([]byte, 2, 2+len(b))
+	binary.BigEndian.PutUint16(mpi, uint16(n.BitLen()))
+
+	return append(mpi, b...)
+}
+
+// openPGPPublicKeyBody returns the body of the v4 public key packet of a card key.
+// EC keys in the decryption slot are ECDH, others ECDSA. Ed25519 keys are EdDSA and *ecdh.PublicKey
+// keys are ECDH, the Curve25519 points have the 40 prefix of native points.
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.5.2
+func openPGPPublicKeyBody(keyType KeyType, pub crypto.PublicKey, created time.Time) ([]byte, error) {
+	body := make([]byte, 6, 64)
+	body[0] = openPGPKeyVersion4
+	binary.BigEndian.PutUint32(body[1:5], uint32(created.Unix()))
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		body[5] = openPGPAlgorithmRSA
+		body = append(body, openPGPMPI(k.N.Bytes())...)
+		body = append(body, openPGPMPI(big.NewInt(int64(k.E)).Bytes())...)
+	case *ecdsa.PublicKey:
+		curve, err := openPGPCurveFor(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+
+		body[5] = openPGPAlgorithmECDSA
+		if keyType == DecryptionKey {
+			body[5] = openPGPAlgorithmECDH
+		}
+
+		// nolint:staticcheck // the uncompressed point is what OpenPGP wants.
+		point := elliptic.Marshal(k.Curve, k.X, k.Y)
+
+		body = append(body, byte(len(curve.oid)))
+		body = append(body, curve.oid...)
+		body = append(body, openPGPMPI(point)...)
+
+		if keyType == DecryptionKey {
+			// KDF parameters: length, reserved 01, hash, cipher.
+			body = append(body, 0x03, 0x01)
+			body = append(body, curve.kdf...)
+		}
+	case ed25519.PublicKey:
+		body[5] = openPGPAlgorithmEdDSA
+		body = append(body, byte(len(oidEd25519)))
+		body = append(body, oidEd25519...)
+		body = append(body, openPGPMPI(append([]byte{openPGPNativePointPrefix}, k...))...)
+	case *ecdh.PublicKey:
+		if k.Curve() != ecdh.X25519() {
+			ecdsaKey, err := ecdsaFromECDH(k)
+			if err != nil {
+				return nil, err
+			}
+
+			return openPGPPublicKeyBody(DecryptionKey, ecdsaKey, created)
+		}
+
+		body[5] = openPGPAlgorithmECDH
+		body = append(body, byte(len(openPGPCurve25519.oid)))
+		body = append(body, openPGPCurve25519.oid...)
+		body = append(body, openPGPMPI(append([]byte{openPGPNativePointPrefix}, k.Bytes()...))...)
+		body = append(body, 0x03, 0x01)
+		body = append(body, openPGPCurve25519.kdf...)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, pub)
+	}
+
+	return body, nil
+}
+
+// OpenPGPFingerprint computes the v4 fingerprint of a card key, which the card stores but doesn't compute.
+// created is the key creation time that's part of the fingerprint, see GpgData.Date.
+// https://www.rfc-editor.org/rfc/rfc4880#section-12.2
+func OpenPGPFingerprint(keyType KeyType, pub crypto.PublicKey, created time.Time) ([]byte, error) {
+	body, err := openPGPPublicKeyBody(keyType, pub, created)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.New() // nolint:gosec
+	h.Write([]byte{openPGPPublicKeyPacket, byte(len(body) >> 8), byte(len(body))})
+	h.Write(body)
+
+	return h.Sum(nil), nil
+}
+
+// VerifyFingerprint recomputes the v4 fingerprint of pub created at created and compares it to the fingerprint
+// the card stores for slot, it returns ErrFingerprintMismatch for a stale fingerprint or a key of another slot.
+// ECDH keys match with the KDF parameters of any implementation, not only the defaults of OpenPGPFingerprint.
+func (yk *GPGYubiKey) VerifyFingerprint(slot KeyType, pub crypto.PublicKey, created time.Time) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.VerifyFingerprint\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	stored, err := yk.gpgData.Fingerprint(slot)
+	if err != nil {
+		return err
+	}
+
+	body, err := openPGPPublicKeyBody(slot, pub, created)
+	if err != nil {
+		return err
+	}
+
+	if !openPGPFingerprintMatches(slot, pub, body, stored) {
+		computed := sha1.Sum(openPGPHashedKey(body)) // nolint:gosec
+
+		return fmt.Errorf("%w: %s key is %X, the card has %s", ErrFingerprintMismatch, slot, computed, stored)
+	}
+
+	return nil
+}
+
+// openPGPFingerprintMatches is true when the public key packet body of pub has fingerprint. Other implementations
+// don't always use the default KDF parameters of ECDH keys, they're changed in body to the ones that match.
+func openPGPFingerprintMatches(slot KeyType, pub crypto.PublicKey, body []byte, fingerprint string) bool {
+	header := []byte{openPGPPublicKeyPacket, byte(len(body) >> 8), byte(len(body))}
+	if openPGPBodyMatches(header, body, fingerprint) {
+		return true
+	}
+
+	_, isRSA := pub.(*rsa.PublicKey)
+
+	return slot == DecryptionKey && !isRSA && openPGPFindKDF(header, body, fingerprint)
+}
+
+// openPGPFindKDF sets the KDF parameters at the end of an ECDH key body to the ones that give the fingerprint,
+// other implementations don't always use the defaults of the curve.
+func openPGPFindKDF(header, body []byte, fingerprint string) bool {
+	kdf := body[len(body)-2:]
+
+	for hash := byte(0x08); hash <= 0x0a; hash++ {
+		for cipher := byte(0x07); cipher <= 0x09; cipher++ {
+			kdf[0], kdf[1] = hash, cipher
+			if openPGPBodyMatches(header, body, fingerprint) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func openPGPBodyMatches(header, body []byte, fingerprint string) bool {
+	h := sha1.New() // nolint:gosec
+	h.Write(header)
+	h.Write(body)
+
+	return UpperCaseHexString(h.Sum(nil)) == fingerprint
+}
@@ -0,0 +1,143 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"fmt"
+	"time"
+)
+
+// GenerateOpenPGPKey generates a key of alg in a slot of the OpenPGP applet and returns the public key,
+// an *rsa.PublicKey, an *ecdsa.PublicKey or an ed25519.PublicKey. EC keys in the decryption slot are ECDH
+// keys, use ecdsa.PublicKey.ECDH to convert them. AlgorithmEd25519 is an X25519 key in the decryption slot,
+// returned as an *ecdh.PublicKey.
+//
+// The algorithm attributes of the slot are changed first when the card allows it. The card doesn't
+// compute the fingerprint, it's written with now as the creation time, see OpenPGPFingerprint.
+//
+// It requires PW3 (83) has been presented, generating the signature key resets the signature counter.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 74
+// 7.2.14 GENERATE ASYMMETRIC KEY PAIR.
+func (yk *GPGYubiKey) GenerateOpenPGPKey(slot KeyType, alg Algorithm) (crypto.PublicKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.GenerateOpenPGPKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	key, err := yk.generateKey(slot, alg, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return key.PublicKey, nil
+}
+
+// generateKey generates a key with the algorithm attributes for alg and writes the fingerprint and creation date.
+func (yk *GPGYubiKey) generateKey(keyType KeyType, alg Algorithm, created time.Time) (*ProvisionedKey, error) {
+	var (
+		attributes []byte
+		curve      elliptic.Curve
+		err        error
+	)
+
+	switch alg {
+	case AlgorithmRSA1024:
+		attributes = gpgRSAAlgorithmAttributes(1024)
+	case AlgorithmRSA2048:
+		attributes = gpgRSAAlgorithmAttributes(2048)
+	case AlgorithmEC256:
+		curve = elliptic.P256()
+	case AlgorithmEC384:
+		curve = elliptic.P384()
+	case AlgorithmEd25519:
+		attributes = Ed25519AlgorithmAttributes().Bytes()
+		if keyType == DecryptionKey {
+			attributes = X25519AlgorithmAttributes().Bytes()
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchAlgorithm, alg)
+	}
+
+	if curve != nil {
+		if attributes, err = gpgECAlgorithmAttributes(keyType, curve); err != nil {
+			return nil, err
+		}
+	}
+
+	tag, err := keyAlgorithmAttributesTag(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	// a missing tag is an unknown algorithm.
+	current, _ := yk.gpgData.GetTag(tag, 1)
+	if !gpgSameAlgorithm(current, attributes) {
+		if !yk.gpgData.AlgorithmAttributesChangeable {
+			return nil, fmt.Errorf("%s key is %X, can't change it to %s: %w", keyType, current, alg, ErrNotSupportedByCard)
+		}
+
+		if err := gpgPutAlgorithmAttributes(yk.tx, keyType, attributes); err != nil {
+			return nil, err
+		}
+
+		yk.gpgData.setAlgorithmAttributes(keyType, attributes)
+	}
+
+	// KeyType and AsymmetricKeyType share the values of the three slots.
+	tlvData, err := ykOpenGPGReadKey(yk.tx, AsymmetricKeyType(keyType), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyType == SignatureKey {
+		yk.gpgData.resetSignatureCount()
+	}
+
+	key := &ProvisionedKey{Created: created, Origin: KeyGeneratedByCard}
+
+	switch {
+	case curve != nil:
+		key.PublicKey, err = parseECDSAPublicKey(tlvData, curve)
+	case alg == AlgorithmEd25519 && keyType == DecryptionKey:
+		key.PublicKey, err = parseECDHPublicKey(tlvData, ecdh.X25519())
+	case alg == AlgorithmEd25519:
+		key.PublicKey, err = parseEd25519PublicKey(tlvData)
+	default:
+		key.PublicKey, err = parsePublicKey(tlvData)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Fingerprint, err = OpenPGPFingerprint(keyType, key.PublicKey, created); err != nil {
+		return nil, err
+	}
+
+	if err := gpgPutKeyInformation(yk.tx, keyType, key.Fingerprint, created); err != nil {
+		return nil, err
+	}
+
+	yk.gpgData.setKeyInformation(keyType, key.Fingerprint, created, key.Origin)
+
+	return key, nil
+}
@@ -0,0 +1,158 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGPGYubiKey_GenerateOpenPGPKey(t *testing.T) {
+	t.Parallel()
+
+	created := time.Unix(testOpenPGPCreated, 0)
+	date := []byte{0x65, 0x93, 0x7d, 0x25}
+
+	tests := []struct {
+		name       string
+		keyType    KeyType
+		alg        Algorithm
+		curve      elliptic.Curve
+		current    []byte
+		changeable bool
+		// putAttributes is the PUT DATA of the algorithm attributes, if they are changed.
+		putAttributes []byte
+		expectErr     error
+	}{
+		{
+			name:    "signature P-256 already set",
+			keyType: SignatureKey,
+			alg:     AlgorithmEC256,
+			curve:   elliptic.P256(),
+			current: mustHex(t, "13 2a8648ce3d030107"),
+		},
+		{
+			name:          "decryption P-384 from RSA",
+			keyType:       DecryptionKey,
+			alg:           AlgorithmEC384,
+			curve:         elliptic.P384(),
+			current:       mustHex(t, "01 0800 0020 00"),
+			changeable:    true,
+			putAttributes: mustHex(t, "12 2b81040022"),
+		},
+		{
+			name:      "attributes not changeable",
+			keyType:   AuthenticationKey,
+			alg:       AlgorithmEC256,
+			current:   mustHex(t, "01 0800 0020 00"),
+			expectErr: ErrNotSupportedByCard,
+		},
+		{
+			name:      "unsupported algorithm",
+			keyType:   SignatureKey,
+			alg:       Algorithm(0),
+			expectErr: ErrNoSuchAlgorithm,
+		},
+		{
+			name:      "attestation slot",
+			keyType:   AttestKey,
+			alg:       AlgorithmEC256,
+			expectErr: ErrNoSuchTag,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tag, _ := keyAlgorithmAttributesTag(tc.keyType)
+			tlvValues := bertlv.TLVData{signatureCounterTag: {0x00, 0x00, 0x05}}
+
+			if tc.current != nil {
+				tlvValues[tag] = tc.current
+			}
+
+			yk := NewTestGpgYubikey(&GpgData{AlgorithmAttributesChangeable: tc.changeable, tlvValues: tlvValues}, false, nil)
+
+			apdus := []apdu{}
+			responses := [][]byte{}
+
+			var fingerprint []byte
+
+			if tc.expectErr == nil {
+				cardKey, err := ecdsa.GenerateKey(tc.curve, rand.Reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if fingerprint, err = OpenPGPFingerprint(tc.keyType, &cardKey.PublicKey, created); err != nil {
+					t.Fatal(err)
+				}
+
+				if tc.putAttributes != nil {
+					apdus = append(apdus, apdu{instruction: insPutDataDA, param2: putAlgorithmAttributesSigTag + byte(tc.keyType), data: tc.putAttributes})
+					responses = append(responses, nil)
+				}
+
+				crt, _ := gpgControlReferenceTemplate(tc.keyType)
+				point := elliptic.Marshal(tc.curve, cardKey.X, cardKey.Y) // nolint:staticcheck
+
+				apdus = append(apdus,
+					apdu{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricGenerate, data: crt},
+					apdu{instruction: insPutDataDA, param2: putFingerprintSigTag + byte(tc.keyType), data: fingerprint},
+					apdu{instruction: insPutDataDA, param2: putGenerationDateTag + byte(tc.keyType), data: date},
+				)
+				responses = append(responses, append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, point))...), nil, nil)
+			}
+
+			yk.tx = &TestSCTx{APDUList: apdus, ResponseList: responses}
+
+			key, err := yk.generateKey(tc.keyType, tc.alg, created)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !bytes.Equal(key.Fingerprint, fingerprint) {
+				t.Errorf("got fingerprint %X expected %X", key.Fingerprint, fingerprint)
+			}
+
+			if key.Origin != KeyGeneratedByCard {
+				t.Errorf("got origin %s expected %s", key.Origin, KeyGeneratedByCard)
+			}
+
+			if tc.putAttributes != nil && !bytes.Equal(tlvValues[tag], tc.putAttributes) {
+				t.Errorf("got cached attributes %X expected %X", tlvValues[tag], tc.putAttributes)
+			}
+
+			expectedCount := 5
+			if tc.keyType == SignatureKey {
+				expectedCount = 0
+			}
+
+			if count, _ := yk.gpgData.SignatureCount(); count != expectedCount {
+				t.Errorf("got signature count %d expected %d", count, expectedCount)
+			}
+		})
+	}
+}
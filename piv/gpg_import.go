@@ -0,0 +1,287 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgpecdh "github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	pgpecdsa "github.com/ProtonMail/go-crypto/openpgp/ecdsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var (
+	// ErrArmoredKey is returned when an armored key can't be imported.
+	ErrArmoredKey = errors.New("unusable armored key")
+	// ErrKeyImportNotSupported is returned when the card doesn't allow importing keys.
+	ErrKeyImportNotSupported = errors.New("card does not support key import")
+)
+
+// ImportArmoredKey imports the keys of an ASCII armored transferable secret key, like `gpg --export-secret-keys --armor` writes,
+// it's what `gpg --edit-key` keytocard does for each key.
+// The newest usable key with the sign, encrypt and authenticate flag goes to the signature, decryption and authentication key.
+// passphrase unlocks the secret keys, adminPIN defaults to the factory PIN.
+// The fingerprints and creation dates are written so gpg finds the keys on the card.
+func (yk *GPGYubiKey) ImportArmoredKey(armored io.Reader, passphrase, adminPIN []byte) (map[KeyType]ProvisionedKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ImportArmoredKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if !yk.gpgData.KeyImportSupported {
+		return nil, ErrKeyImportNotSupported
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(armored)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrArmoredKey, err)
+	}
+
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("%w: expected one key, got %d", ErrArmoredKey, len(entities))
+	}
+
+	slots := openPGPCardSlots(entities[0], time.Now())
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("%w: no key can sign, encrypt or authenticate", ErrArmoredKey)
+	}
+
+	// unlock and convert everything before the card is changed.
+	privateKeys := map[KeyType]crypto.PrivateKey{}
+
+	for keyType, key := range slots {
+		if privateKeys[keyType], err = openPGPPrivateKey(key.PrivateKey, passphrase); err != nil {
+			return nil, fmt.Errorf("%s key %s: %w", keyType, key.PublicKey.KeyIdString(), err)
+		}
+	}
+
+	if err := yk.adminLogin(adminPIN); err != nil {
+		return nil, fmt.Errorf("verify admin pin: %w", err)
+	}
+
+	imported := map[KeyType]ProvisionedKey{}
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		key, ok := slots[keyType]
+		if !ok {
+			continue
+		}
+
+		k, err := yk.importKey(keyType, privateKeys[keyType], key.PublicKey.Fingerprint, key.PublicKey.CreationTime)
+		if err != nil {
+			return imported, fmt.Errorf("%s key: %w", keyType, err)
+		}
+
+		imported[keyType] = *k
+	}
+
+	return imported, nil
+}
+
+// ImportOpenPGPKey imports an *rsa.PrivateKey or an *ecdsa.PrivateKey into a slot of the OpenPGP applet,
+// EC keys in the decryption slot become ECDH keys. RSA keys must have two primes.
+// The key is written with the extended header list (4D) after changing the algorithm attributes of the slot,
+// cards that can't change them only import keys matching them.
+// The card doesn't compute the fingerprint, it's written with now as the creation time by PutFingerprint and
+// PutKeyCreationTime, see OpenPGPFingerprint.
+//
+// It requires PW3 (83) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 39
+// 4.4.3.12 Private Key Template.
+func (yk *GPGYubiKey) ImportOpenPGPKey(slot KeyType, priv crypto.PrivateKey) (*ProvisionedKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ImportOpenPGPKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if !yk.gpgData.KeyImportSupported {
+		return nil, ErrKeyImportNotSupported
+	}
+
+	return yk.importOpenPGPKey(slot, priv, time.Now())
+}
+
+// importOpenPGPKey checks the key can be imported before the card is changed, then imports it.
+func (yk *GPGYubiKey) importOpenPGPKey(keyType KeyType, priv crypto.PrivateKey, created time.Time) (*ProvisionedKey, error) {
+	tag, err := keyAlgorithmAttributesTag(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub crypto.PublicKey
+
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("%w: rsa key with %d primes", ErrNoSuchAlgorithm, len(k.Primes))
+		}
+
+		pub = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		pub = &k.PublicKey
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, priv)
+	}
+
+	attributes, err := gpgAlgorithmAttributesFor(keyType, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if !yk.gpgData.AlgorithmAttributesChangeable {
+		// a missing tag is an unknown algorithm.
+		if current, _ := yk.gpgData.GetTag(tag, 1); !gpgSameAlgorithm(current, attributes) {
+			return nil, fmt.Errorf("%s key is %X, can't import a %X key: %w", keyType, current, attributes, ErrNotSupportedByCard)
+		}
+	}
+
+	fingerprint, err := OpenPGPFingerprint(keyType, pub, created)
+	if err != nil {
+		return nil, err
+	}
+
+	return yk.importKey(keyType, priv, fingerprint, created)
+}
+
+// importKey imports a key with the algorithm attributes for it and writes the fingerprint and creation date.
+func (yk *GPGYubiKey) importKey(keyType KeyType, priv crypto.PrivateKey, fingerprint []byte, created time.Time) (*ProvisionedKey, error) {
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, priv)
+	}
+
+	key := &ProvisionedKey{PublicKey: signer.Public(), Fingerprint: fingerprint, Created: created, Origin: KeyImportedToCard}
+
+	// cards that can't change the attributes only import keys matching them, the import fails otherwise.
+	if yk.gpgData.AlgorithmAttributesChangeable {
+		attributes, err := gpgAlgorithmAttributesFor(keyType, key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := gpgPutAlgorithmAttributes(yk.tx, keyType, attributes); err != nil {
+			return nil, err
+		}
+
+		yk.gpgData.setAlgorithmAttributes(keyType, attributes)
+	}
+
+	if err := gpgImportKey(yk.tx, keyType, priv); err != nil {
+		return nil, err
+	}
+
+	if err := yk.PutFingerprint(keyType, fingerprint); err != nil {
+		return nil, err
+	}
+
+	if err := yk.PutKeyCreationTime(keyType, created); err != nil {
+		return nil, err
+	}
+
+	yk.gpgData.setKeyOrigin(keyType, key.Origin)
+
+	return key, nil
+}
+
+// openPGPCardSlots picks the newest usable key for each card slot.
+func openPGPCardSlots(e *openpgp.Entity, now time.Time) map[KeyType]openpgp.Key {
+	var candidates []openpgp.Key
+
+	if selfSig, _ := e.PrimarySelfSignature(); selfSig != nil {
+		candidates = append(candidates, openpgp.Key{Entity: e, PublicKey: e.PrimaryKey, PrivateKey: e.PrivateKey, SelfSignature: selfSig, Revocations: e.Revocations})
+	}
+
+	for _, sub := range e.Subkeys {
+		candidates = append(candidates, openpgp.Key{Entity: e, PublicKey: sub.PublicKey, PrivateKey: sub.PrivateKey, SelfSignature: sub.Sig, Revocations: sub.Revocations})
+	}
+
+	slots := map[KeyType]openpgp.Key{}
+
+	for _, key := range candidates {
+		sig := key.SelfSignature
+		if sig == nil || !sig.FlagsValid || key.PrivateKey == nil || key.Revoked(now) ||
+			sig.SigExpired(now) || key.PublicKey.KeyExpired(sig, now) {
+			continue
+		}
+
+		for keyType, flag := range map[KeyType]bool{
+			SignatureKey:      sig.FlagSign,
+			DecryptionKey:     sig.FlagEncryptCommunications || sig.FlagEncryptStorage,
+			AuthenticationKey: sig.FlagAuthenticate,
+		} {
+			if current, ok := slots[keyType]; flag && (!ok || key.PublicKey.CreationTime.After(current.PublicKey.CreationTime)) {
+				slots[keyType] = key
+			}
+		}
+	}
+
+	return slots
+}
+
+// openPGPPrivateKey unlocks a secret key and converts it to the crypto package types gpgImportKey takes.
+func openPGPPrivateKey(pk *packet.PrivateKey, passphrase []byte) (crypto.PrivateKey, error) {
+	if pk.Dummy() {
+		return nil, fmt.Errorf("%w: secret key is a stub, it may already be on a card", ErrArmoredKey)
+	}
+
+	if pk.Encrypted {
+		if err := pk.Decrypt(passphrase); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrArmoredKey, err)
+		}
+	}
+
+	switch k := pk.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *pgpecdsa.PrivateKey:
+		return openPGPECPrivateKey(k.GetCurve().GetCurveName(), k.D.Bytes())
+	case *pgpecdh.PrivateKey:
+		return openPGPECPrivateKey(k.GetCurve().GetCurveName(), k.D)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, pk.PrivateKey)
+	}
+}
+
+func openPGPECPrivateKey(curveName string, d []byte) (*ecdsa.PrivateKey, error) {
+	for _, c := range openPGPCurves {
+		if c.curve.Params().Name != curveName {
+			continue
+		}
+
+		priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+		priv.Curve = c.curve
+		// nolint:staticcheck // the public key is needed for the crypto.Signer.
+		priv.X, priv.Y = c.curve.ScalarBaseMult(d)
+
+		return priv, nil
+	}
+
+	return nil, fmt.Errorf("%w: curve %s", ErrNoSuchAlgorithm, curveName)
+}
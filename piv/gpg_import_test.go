@@ -0,0 +1,366 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgpecdh "github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	pgpecdsa "github.com/ProtonMail/go-crypto/openpgp/ecdsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/areese/piv-go/bertlv"
+)
+
+// testOpenPGPEntity generates a P-256 key with an ECDSA primary key and an ECDH subkey,
+// it returns the key and the import APDUs for both.
+func testOpenPGPEntity(tb testing.TB, passphrase []byte) (*openpgp.Entity, []apdu) {
+	tb.Helper()
+
+	created := time.Unix(testOpenPGPCreated, 0)
+	config := &packet.Config{
+		Algorithm: packet.PubKeyAlgoECDSA,
+		Curve:     packet.CurveNistP256,
+		Time:      func() time.Time { return created },
+	}
+
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	sigD := e.PrivateKey.PrivateKey.(*pgpecdsa.PrivateKey).D.FillBytes(make([]byte, 32))
+	decD := e.Subkeys[0].PrivateKey.PrivateKey.(*pgpecdh.PrivateKey).D
+
+	if passphrase != nil {
+		if err := e.EncryptPrivateKeys(passphrase, nil); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	date := []byte{0x65, 0x93, 0x7d, 0x25}
+	p256 := mustHex(tb, "2a8648ce3d030107")
+
+	apdus := []apdu{
+		{instruction: insVerify, param2: paramOpenGPGVerifyPW3, data: []byte(defaultPW3)},
+		{instruction: insPutDataDA, param2: 0xc1, data: append([]byte{openPGPAlgorithmECDSA}, p256...)},
+		{instruction: insPutDataDB, param1: 0x3f, param2: 0xff, data: append(mustHex(tb, "4d 2a b600 7f48 02 9220 5f48 20"), sigD...)},
+		{instruction: insPutDataDA, param2: putFingerprintSigTag, data: e.PrimaryKey.Fingerprint},
+		{instruction: insPutDataDA, param2: putGenerationDateTag, data: date},
+		{instruction: insPutDataDA, param2: 0xc2, data: append([]byte{openPGPAlgorithmECDH}, p256...)},
+		{instruction: insPutDataDB, param1: 0x3f, param2: 0xff, data: append(mustHex(tb, "4d 2a b800 7f48 02 9220 5f48 20"), decD...)},
+		{instruction: insPutDataDA, param2: putFingerprintSigTag + 1, data: e.Subkeys[0].PublicKey.Fingerprint},
+		{instruction: insPutDataDA, param2: putGenerationDateTag + 1, data: date},
+	}
+
+	return e, apdus
+}
+
+// testArmoredOpenPGPKeys armors the secret keys of entities in one block.
+func testArmoredOpenPGPKeys(tb testing.TB, entities ...*openpgp.Entity) string {
+	tb.Helper()
+
+	var buf bytes.Buffer
+
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for _, e := range entities {
+		if err := e.SerializePrivateWithoutSigning(w, nil); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestGPGYubiKey_ImportArmoredKey(t *testing.T) {
+	t.Parallel()
+
+	passphrase := []byte("correct horse")
+	plainEntity, plainAPDUs := testOpenPGPEntity(t, nil)
+	encryptedEntity, encryptedAPDUs := testOpenPGPEntity(t, passphrase)
+	otherEntity, _ := testOpenPGPEntity(t, nil)
+
+	plain := testArmoredOpenPGPKeys(t, plainEntity)
+	encrypted := testArmoredOpenPGPKeys(t, encryptedEntity)
+
+	tests := []struct {
+		name             string
+		armored          string
+		passphrase       []byte
+		importNotAllowed bool
+		apdus            []apdu
+		expectErr        error
+	}{
+		{
+			name:    "unencrypted",
+			armored: plain,
+			apdus:   plainAPDUs,
+		},
+		{
+			name:       "encrypted",
+			armored:    encrypted,
+			passphrase: passphrase,
+			apdus:      encryptedAPDUs,
+		},
+		{
+			name:       "wrong passphrase",
+			armored:    encrypted,
+			passphrase: []byte("wrong"),
+			apdus:      []apdu{},
+			expectErr:  ErrArmoredKey,
+		},
+		{
+			name:      "two keys",
+			armored:   testArmoredOpenPGPKeys(t, plainEntity, otherEntity),
+			apdus:     []apdu{},
+			expectErr: ErrArmoredKey,
+		},
+		{
+			name:             "import not supported",
+			armored:          plain,
+			importNotAllowed: true,
+			apdus:            []apdu{},
+			expectErr:        ErrKeyImportNotSupported,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{
+				KeyImportSupported:            !tc.importNotAllowed,
+				AlgorithmAttributesChangeable: true,
+				tlvValues: bertlv.TLVData{
+					keyInformationTag: make([]byte, 3*keyFingerprintLen),
+					keyDateTag:        make([]byte, 3*keyDateLen),
+				},
+			}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: make([][]byte, len(tc.apdus))}
+
+			imported, err := yk.ImportArmoredKey(strings.NewReader(tc.armored), tc.passphrase, nil)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if len(imported) != 2 {
+				t.Fatalf("expected 2 imported keys got %d", len(imported))
+			}
+
+			for keyType, key := range imported {
+				if key.Origin != KeyImportedToCard {
+					t.Errorf("%s: got origin %s", keyType, key.Origin)
+				}
+
+				if !key.Created.Equal(time.Unix(testOpenPGPCreated, 0)) {
+					t.Errorf("%s: got created %s", keyType, key.Created)
+				}
+
+				fp, _ := yk.gpgData.Fingerprint(keyType)
+				if fp != UpperCaseHexString(key.Fingerprint) {
+					t.Errorf("%s: cached fingerprint not updated: %s", keyType, fp)
+				}
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_ImportOpenPGPKey(t *testing.T) {
+	t.Parallel()
+
+	created := time.Unix(testOpenPGPCreated, 0)
+	date := []byte{0x65, 0x93, 0x7d, 0x25}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threePrimes := *rsaKey
+	threePrimes.Primes = append(append([]*big.Int{}, rsaKey.Primes...), big.NewInt(3))
+
+	rsaFingerprint, _ := OpenPGPFingerprint(SignatureKey, &rsaKey.PublicKey, created)
+	ecFingerprint, _ := OpenPGPFingerprint(DecryptionKey, &ecKey.PublicKey, created)
+
+	// e is 3 bytes, p and q 64 bytes each.
+	rsaTemplate := mustHex(t, "4d 8192 b600 7f48 06 9103 9240 9340 5f48 8183 010001")
+	rsaTemplate = append(append(rsaTemplate, rsaKey.Primes[0].Bytes()...), rsaKey.Primes[1].Bytes()...)
+
+	tests := []struct {
+		name       string
+		keyType    KeyType
+		priv       crypto.PrivateKey
+		current    []byte
+		changeable bool
+		apdus      []apdu
+		expectErr  error
+	}{
+		{
+			name:       "rsa signature",
+			keyType:    SignatureKey,
+			priv:       rsaKey,
+			changeable: true,
+			apdus: []apdu{
+				{instruction: insPutDataDA, param2: 0xc1, data: mustHex(t, "01 0400 0011 00")},
+				{instruction: insPutDataDB, param1: 0x3f, param2: 0xff, data: rsaTemplate},
+				{instruction: insPutDataDA, param2: putFingerprintSigTag, data: rsaFingerprint},
+				{instruction: insPutDataDA, param2: putGenerationDateTag, data: date},
+			},
+		},
+		{
+			name:    "ecdh decryption with matching attributes",
+			keyType: DecryptionKey,
+			priv:    ecKey,
+			current: mustHex(t, "12 2a8648ce3d030107 ff"),
+			apdus: []apdu{
+				{instruction: insPutDataDB, param1: 0x3f, param2: 0xff, data: append(mustHex(t, "4d 2a b800 7f48 02 9220 5f48 20"), ecKey.D.FillBytes(make([]byte, 32))...)},
+				{instruction: insPutDataDA, param2: putFingerprintSigTag + 1, data: ecFingerprint},
+				{instruction: insPutDataDA, param2: putGenerationDateTag + 1, data: date},
+			},
+		},
+		{
+			name:      "attributes not changeable",
+			keyType:   SignatureKey,
+			priv:      rsaKey,
+			current:   mustHex(t, "13 2a8648ce3d030107"),
+			expectErr: ErrNotSupportedByCard,
+		},
+		{
+			name:       "three primes",
+			keyType:    SignatureKey,
+			priv:       &threePrimes,
+			changeable: true,
+			expectErr:  ErrNoSuchAlgorithm,
+		},
+		{
+			name:       "attestation slot",
+			keyType:    AttestKey,
+			priv:       ecKey,
+			changeable: true,
+			expectErr:  ErrNoSuchTag,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tlvValues := bertlv.TLVData{}
+			if tag, err := keyAlgorithmAttributesTag(tc.keyType); err == nil && tc.current != nil {
+				tlvValues[tag] = tc.current
+			}
+
+			yk := NewTestGpgYubikey(&GpgData{
+				KeyImportSupported:            true,
+				AlgorithmAttributesChangeable: tc.changeable,
+				tlvValues:                     tlvValues,
+			}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: make([][]byte, len(tc.apdus))}
+
+			key, err := yk.importOpenPGPKey(tc.keyType, tc.priv, created)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if key.Origin != KeyImportedToCard {
+				t.Errorf("got origin %s expected %s", key.Origin, KeyImportedToCard)
+			}
+
+			if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tc.apdus))
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_ImportOpenPGPKeyNotSupported(t *testing.T) {
+	t.Parallel()
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = &TestSCTx{APDUList: []apdu{}, ResponseList: [][]byte{}}
+
+	_, err := yk.ImportOpenPGPKey(SignatureKey, nil)
+	expectedError(t, err, ErrKeyImportNotSupported)
+}
+
+func TestGPGYubiKey_PutFingerprint(t *testing.T) {
+	t.Parallel()
+
+	fingerprint := bytes.Repeat([]byte{0xab}, keyFingerprintLen)
+	created := time.Unix(testOpenPGPCreated, 0)
+
+	yk := NewTestGpgYubikey(&GpgData{
+		tlvValues: bertlv.TLVData{
+			keyInformationTag: make([]byte, 3*keyFingerprintLen),
+			keyDateTag:        make([]byte, 3*keyDateLen),
+		},
+	}, false, nil)
+	yk.tx = &TestSCTx{
+		APDUList: []apdu{
+			{instruction: insPutDataDA, param2: putFingerprintSigTag + byte(AuthenticationKey), data: fingerprint},
+			{instruction: insPutDataDA, param2: putGenerationDateTag + byte(AuthenticationKey), data: []byte{0x65, 0x93, 0x7d, 0x25}},
+		},
+		ResponseList: [][]byte{nil, nil},
+	}
+
+	if err := yk.PutFingerprint(AuthenticationKey, fingerprint[:19]); !errors.Is(err, ErrTooShort) {
+		t.Errorf("short fingerprint: got %v expected %v", err, ErrTooShort)
+	}
+
+	if err := yk.PutFingerprint(AuthenticationKey, fingerprint); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := yk.PutKeyCreationTime(AuthenticationKey, created); err != nil {
+		t.Fatal(err)
+	}
+
+	if fp, _ := yk.gpgData.Fingerprint(AuthenticationKey); fp != UpperCaseHexString(fingerprint) {
+		t.Errorf("cached fingerprint %s", fp)
+	}
+
+	if date, _ := yk.gpgData.Date(AuthenticationKey); !date.Equal(created) {
+		t.Errorf("cached date %s expected %s", date, created)
+	}
+}
@@ -0,0 +1,203 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// GpgDataJSON is the JSON and YAML form of GpgData, with stable field names for --json output.
+// Raw data objects are uppercase hex keyed by their path, like 6E.73.C5.
+type GpgDataJSON struct {
+	Serial                              string `json:"serial" yaml:"serial"`
+	SerialInt                           uint32 `json:"serialInt" yaml:"serialInt"`
+	LongName                            string `json:"longName,omitempty" yaml:"longName,omitempty"`
+	CardHolder                          string `json:"cardHolder,omitempty" yaml:"cardHolder,omitempty"`
+	LoginData                           string `json:"loginData,omitempty" yaml:"loginData,omitempty"`
+	URL                                 string `json:"url,omitempty" yaml:"url,omitempty"`
+	Rid                                 string `json:"rid" yaml:"rid"`
+	Application                         string `json:"application" yaml:"application"`
+	Version                             string `json:"version" yaml:"version"`
+	AppletVersion                       string `json:"appletVersion,omitempty" yaml:"appletVersion,omitempty"`
+	Manufacturer                        string `json:"manufacturer" yaml:"manufacturer"`
+	ManufacturerID                      uint16 `json:"manufacturerID" yaml:"manufacturerID"`
+	Reader                              string `json:"reader,omitempty" yaml:"reader,omitempty"`
+	SecureMessaging                     string `json:"secureMessaging" yaml:"secureMessaging"`
+	MaximumChallengeLength              uint16 `json:"maximumChallengeLength" yaml:"maximumChallengeLength"`
+	MaximumCardholderCertificatesLength uint16 `json:"maximumCardholderCertificatesLength" yaml:"maximumCardholderCertificatesLength"`
+	MaximumSpecialDOsLength             uint16 `json:"maximumSpecialDOsLength" yaml:"maximumSpecialDOsLength"`
+	// Capabilities are the extended capabilities, with the names used by OpenPGPCardState.
+	Capabilities map[string]bool `json:"capabilities" yaml:"capabilities"`
+	// Keys is keyed by KeyType name, empty slots are left out.
+	Keys    map[string]*GpgKeyJSON `json:"keys" yaml:"keys"`
+	Objects map[string]string      `json:"objects" yaml:"objects"`
+}
+
+// GpgKeyJSON describes one key in GpgDataJSON.
+type GpgKeyJSON struct {
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// ID is the last 8 bytes of the fingerprint.
+	ID          string    `json:"id" yaml:"id"`
+	Fingerprint string    `json:"fingerprint" yaml:"fingerprint"`
+	Created     time.Time `json:"created" yaml:"created"`
+	Origin      string    `json:"origin,omitempty" yaml:"origin,omitempty"`
+	// UIF is only set when the card reports it in the application related data.
+	UIF string `json:"uif,omitempty" yaml:"uif,omitempty"`
+}
+
+// JSON returns the GpgDataJSON of g, pass it to a YAML encoder for YAML output.
+func (g *GpgData) JSON() *GpgDataJSON {
+	if g == nil {
+		return nil
+	}
+
+	j := &GpgDataJSON{
+		Serial:                              g.Serial,
+		SerialInt:                           g.SerialInt,
+		LongName:                            g.LongName,
+		CardHolder:                          g.CardHolder,
+		LoginData:                           g.LoginData,
+		URL:                                 g.URL,
+		Rid:                                 g.Rid,
+		Application:                         g.Application,
+		Version:                             g.Version,
+		AppletVersion:                       g.AppletVersion,
+		Manufacturer:                        g.Manufacturer,
+		ManufacturerID:                      g.ManufacturerID,
+		Reader:                              g.Reader,
+		SecureMessaging:                     g.SecureMessaging.String(),
+		MaximumChallengeLength:              g.MaximumChallengeLength,
+		MaximumCardholderCertificatesLength: g.MaximumCardholderCertificatesLength,
+		MaximumSpecialDOsLength:             g.MaximumSpecialDOsLength,
+		Capabilities:                        g.capabilities(),
+		Keys:                                map[string]*GpgKeyJSON{},
+		Objects:                             map[string]string{},
+	}
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		fingerprint, err := g.Fingerprint(keyType)
+		if err != nil {
+			continue
+		}
+
+		// an empty slot has a zero fingerprint.
+		if fp, err := hex.DecodeString(fingerprint); err != nil || bytes.Equal(fp, make([]byte, keyFingerprintLen)) {
+			continue
+		}
+
+		key := GpgKeyJSON{Fingerprint: fingerprint}
+		key.Algorithm, _ = g.Algorithm(keyType)
+		key.ID, _ = g.ID(keyType)
+		key.Created, _ = g.Date(keyType)
+		key.Created = key.Created.UTC()
+
+		if origin, err := g.Origin(keyType); err == nil {
+			key.Origin = origin.String()
+		}
+
+		if uif, err := g.UIF(keyType); err == nil {
+			key.UIF = uif.String()
+		}
+
+		j.Keys[keyType.String()] = &key
+	}
+
+	g.Walk(func(path string, value []byte) {
+		j.Objects[path] = UpperCaseHexString(value)
+	})
+
+	return j
+}
+
+// MarshalJSON encodes g as GpgDataJSON.
+func (g *GpgData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.JSON())
+}
+
+// capabilities returns the extended capabilities by name.
+func (g *GpgData) capabilities() map[string]bool {
+	return map[string]bool{
+		"secureMessaging":               g.SecureMessagingSupported,
+		"getChallenge":                  g.GetChallengeSupported,
+		"keyImport":                     g.KeyImportSupported,
+		"pwStatusChangeable":            g.PWStatusChangeable,
+		"privateUseDOs":                 g.PrivateUseDOsSupported,
+		"algorithmAttributesChangeable": g.AlgorithmAttributesChangeable,
+		"aes":                           g.SupportsPSODecryptionEncryptionWithAES,
+		"kdf":                           g.KDFSupported,
+		"pinBlock2":                     g.PinBlock2Supported,
+		"mse":                           g.MSECommandSupported,
+	}
+}
+
+// CardInfoJSON is the JSON and YAML form of CardInfo, with stable field names for --json output.
+type CardInfoJSON struct {
+	Reader string `json:"reader" yaml:"reader"`
+	// ATR is uppercase hex, empty if it couldn't be decoded.
+	ATR          string   `json:"atr,omitempty" yaml:"atr,omitempty"`
+	Family       string   `json:"family" yaml:"family"`
+	Model        string   `json:"model,omitempty" yaml:"model,omitempty"`
+	Applications string   `json:"applications,omitempty" yaml:"applications,omitempty"`
+	Serial       uint32   `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Version      string   `json:"version,omitempty" yaml:"version,omitempty"`
+	Applets      []string `json:"applets" yaml:"applets"`
+	// OpenPGP is left out for cards without the OpenPGP applet.
+	OpenPGP *GpgDataJSON `json:"openpgp,omitempty" yaml:"openpgp,omitempty"`
+	Error   string       `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// JSON returns the CardInfoJSON of c, pass it to a YAML encoder for YAML output.
+func (c CardInfo) JSON() *CardInfoJSON {
+	j := &CardInfoJSON{
+		Reader:  c.Reader,
+		Family:  c.Identity.Family.String(),
+		Model:   c.Identity.Model,
+		Serial:  c.Serial,
+		Applets: c.Applets,
+		OpenPGP: c.OpenPGP.JSON(),
+	}
+
+	if c.ATR != nil {
+		j.ATR = UpperCaseHexString(c.ATR.Raw)
+	}
+
+	if c.Identity.Applications != 0 {
+		j.Applications = c.Identity.Applications.String()
+	}
+
+	if c.Version != (Version{}) {
+		j.Version = fmt.Sprintf("%d.%d.%d", c.Version.Major, c.Version.Minor, c.Version.Patch)
+	}
+
+	if j.Applets == nil {
+		j.Applets = []string{}
+	}
+
+	if c.Err != nil {
+		j.Error = c.Err.Error()
+	}
+
+	return j
+}
+
+// MarshalJSON encodes c as CardInfoJSON, the error as its message.
+func (c CardInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.JSON())
+}
@@ -0,0 +1,173 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGpgData_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.SignatureKey, piv.AlgorithmEC256); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	data, err := json.Marshal(g)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var got piv.GpgDataJSON
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+
+	fingerprint, err := g.Fingerprint(piv.SignatureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Serial != g.Serial || got.Version != g.Version || !got.Capabilities["keyImport"] {
+		t.Errorf("got %+v", got)
+	}
+
+	if len(got.Keys) != 1 {
+		t.Fatalf("got keys %v, expected only the signature key", got.Keys)
+	}
+
+	key := got.Keys[piv.SignatureKey.String()]
+	if key == nil {
+		t.Fatalf("got keys %v, expected the signature key", got.Keys)
+	}
+	if key.Fingerprint != fingerprint || key.Algorithm != "ECDSA P-256" || key.Origin != piv.KeyGeneratedByCard.String() {
+		t.Errorf("got key %+v", key)
+	}
+
+	if got.Objects["6E.73.C5"][:len(fingerprint)] != fingerprint {
+		t.Errorf("got fingerprints %s, expected %s first", got.Objects["6E.73.C5"], fingerprint)
+	}
+}
+
+func TestGpgData_FullString(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.DecryptionKey, piv.AlgorithmRSA2048); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	s, err := g.FullString()
+	if err != nil {
+		t.Fatalf("FullString: %v", err)
+	}
+
+	fingerprint, err := g.Fingerprint(piv.DecryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"Serial Number:   " + g.Serial,
+		"Signature key:   [none]",
+		"Encryption key:  RSA 2048  " + fingerprint[len(fingerprint)-16:] + "  " + fingerprint,
+		"KeyGeneratedByCard  uif=off",
+		"Authentication:  [none]",
+	} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("got %s, expected %q", s, expected)
+		}
+	}
+
+	s, err = g.StringWithTemplate(`{{.Serial}} {{len .Keys}}`)
+	if err != nil {
+		t.Fatalf("StringWithTemplate: %v", err)
+	}
+
+	if expected := g.Serial + " 1"; s != expected {
+		t.Errorf("got %q, expected %q", s, expected)
+	}
+
+	if _, err := g.StringWithTemplate(`{{.NoSuchField}}`); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestCardInfo_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	infos, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).ListCards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos = append(infos, piv.CardInfo{Reader: "empty", Err: piv.ErrNotFound})
+
+	data, err := json.Marshal(infos)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var got []piv.CardInfoJSON
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+
+	if len(got) != 2 || got[0].Serial != pivtest.DefaultSerial || got[0].Version != "5.4.3" || got[0].OpenPGP == nil {
+		t.Fatalf("got %s", data)
+	}
+
+	if got[1].Error != piv.ErrNotFound.Error() || got[1].Applets == nil || got[1].OpenPGP != nil {
+		t.Errorf("got %+v", got[1])
+	}
+}
@@ -0,0 +1,279 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 18
+// 4.3.2 Key Derived Format.
+// With KDF the card only ever sees a salted and iterated hash of the PIN.
+const (
+	kdfTag = 0xf9
+
+	kdfAlgorithmTag     = 0x81
+	kdfHashTag          = 0x82
+	kdfIterationsTag    = 0x83
+	kdfSaltPW1Tag       = 0x84
+	kdfSaltResetCodeTag = 0x85
+	kdfSaltPW3Tag       = 0x86
+	kdfInitialPW1Tag    = 0x87
+	kdfInitialPW3Tag    = 0x88
+
+	kdfAlgorithmNone          = 0x00
+	kdfAlgorithmIterSaltedS2K = 0x03
+	kdfHashSHA256             = 0x08
+	kdfHashSHA512             = 0x0a
+	kdfSaltLen                = 8
+	defaultKDFIterations      = 0x780000
+)
+
+// KDF is the Key Derived Format (DO F9) of an OpenPGP card.
+type KDF struct {
+	// Hash is crypto.SHA256 or crypto.SHA512.
+	Hash       crypto.Hash
+	Iterations uint32
+	SaltPW1    []byte
+	// SaltResetCode is optional, SaltPW1 is used if it's empty.
+	SaltResetCode []byte
+	SaltPW3       []byte
+}
+
+// NewKDF returns an iterated and salted SHA256 KDF with random salts, like gpg and ykman use.
+func NewKDF(rand io.Reader) (*KDF, error) {
+	k := &KDF{Hash: crypto.SHA256, Iterations: defaultKDFIterations}
+
+	for _, salt := range []*[]byte{&k.SaltPW1, &k.SaltResetCode, &k.SaltPW3} {
+		*salt = make([]byte, kdfSaltLen)
+		if _, err := io.ReadFull(rand, *salt); err != nil {
+			return nil, fmt.Errorf("generating kdf salt: %w", err)
+		}
+	}
+
+	return k, nil
+}
+
+func (k *KDF) hashByte() (byte, error) {
+	switch k.Hash {
+	case crypto.SHA256:
+		return kdfHashSHA256, nil
+	case crypto.SHA512:
+		return kdfHashSHA512, nil
+	default:
+		return 0, fmt.Errorf("%w: kdf hash %s", ErrNoSuchAlgorithm, k.Hash)
+	}
+}
+
+// Marshal encodes the KDF as the value of DO F9, including the hashes of the default PINs.
+func (k *KDF) Marshal() ([]byte, error) {
+	h, err := k.hashByte()
+	if err != nil {
+		return nil, err
+	}
+
+	iterations := make([]byte, 4)
+	binary.BigEndian.PutUint32(iterations, k.Iterations)
+
+	data := marshalASN1(kdfAlgorithmTag, []byte{kdfAlgorithmIterSaltedS2K})
+	data = append(data, marshalASN1(kdfHashTag, []byte{h})...)
+	data = append(data, marshalASN1(kdfIterationsTag, iterations)...)
+	data = append(data, marshalASN1(kdfSaltPW1Tag, k.SaltPW1)...)
+
+	if len(k.SaltResetCode) > 0 {
+		data = append(data, marshalASN1(kdfSaltResetCodeTag, k.SaltResetCode)...)
+	}
+
+	data = append(data, marshalASN1(kdfSaltPW3Tag, k.SaltPW3)...)
+	data = append(data, marshalASN1(kdfInitialPW1Tag, k.Derive([]byte(defaultPW1), paramOpenGPGVerifyPW1))...)
+	data = append(data, marshalASN1(kdfInitialPW3Tag, k.Derive([]byte(defaultPW3), paramOpenGPGVerifyPW3))...)
+
+	return data, nil
+}
+
+// Derive returns what has to be sent to the card instead of the PIN.
+// pwField is the VERIFY P2 of the PIN, or paramOpenGPGResettingCode for the resetting code.
+// A nil KDF returns the PIN unchanged.
+func (k *KDF) Derive(pin []byte, pwField byte) []byte {
+	if k == nil {
+		return pin
+	}
+
+	salt := k.SaltPW1
+
+	switch pwField {
+	case paramOpenGPGVerifyPW3:
+		salt = k.SaltPW3
+	case paramOpenGPGResettingCode:
+		if len(k.SaltResetCode) > 0 {
+			salt = k.SaltResetCode
+		}
+	}
+
+	h := sha256.New()
+	if k.Hash == crypto.SHA512 {
+		h = sha512.New()
+	}
+
+	// OpenPGP (RFC 4880 3.7.1.3) iterated and salted S2K, the salt and PIN are repeated until count bytes are hashed.
+	data := append(append([]byte{}, salt...), pin...)
+
+	count := int(k.Iterations)
+	if count < len(data) {
+		count = len(data)
+	}
+
+	for count > len(data) {
+		h.Write(data)
+		count -= len(data)
+	}
+
+	h.Write(data[:count])
+
+	return h.Sum(nil)
+}
+
+// ParseKDF decodes the KDF DO (F9), with or without the F9 tag.
+// It returns nil when the KDF is off, Derive on a nil KDF returns the PIN unchanged.
+func ParseKDF(data []byte) (*KDF, error) {
+	if len(data) > 0 && data[0] == kdfTag {
+		value, _, err := unmarshalASN1(data, 3, kdfTag&0x1f)
+		if err != nil {
+			return nil, fmt.Errorf("kdf: %w", err)
+		}
+
+		data = value
+	}
+
+	k := &KDF{}
+	algorithm := -1
+
+	for len(data) > 0 {
+		if len(data) < 2 || int(data[1]) > len(data)-2 || data[1]&0x80 != 0 {
+			return nil, fmt.Errorf("kdf: %w", ErrTooShort)
+		}
+
+		tag, value := data[0], data[2:2+data[1]]
+		data = data[2+data[1]:]
+
+		switch tag {
+		case kdfAlgorithmTag:
+			if len(value) != 1 {
+				return nil, fmt.Errorf("kdf algorithm: %w", ErrTooShort)
+			}
+
+			algorithm = int(value[0])
+		case kdfHashTag:
+			switch {
+			case len(value) == 1 && value[0] == kdfHashSHA256:
+				k.Hash = crypto.SHA256
+			case len(value) == 1 && value[0] == kdfHashSHA512:
+				k.Hash = crypto.SHA512
+			default:
+				return nil, fmt.Errorf("%w: kdf hash %X", ErrNoSuchAlgorithm, value)
+			}
+		case kdfIterationsTag:
+			if len(value) != 4 {
+				return nil, fmt.Errorf("kdf iterations: %w", ErrTooShort)
+			}
+
+			k.Iterations = binary.BigEndian.Uint32(value)
+		case kdfSaltPW1Tag:
+			k.SaltPW1 = value
+		case kdfSaltResetCodeTag:
+			k.SaltResetCode = value
+		case kdfSaltPW3Tag:
+			k.SaltPW3 = value
+		}
+	}
+
+	switch algorithm {
+	case kdfAlgorithmNone:
+		return nil, nil
+	case kdfAlgorithmIterSaltedS2K:
+	default:
+		return nil, fmt.Errorf("%w: kdf algorithm %d", ErrNoSuchAlgorithm, algorithm)
+	}
+
+	if k.Hash == 0 || len(k.SaltPW1) == 0 || len(k.SaltPW3) == 0 {
+		return nil, fmt.Errorf("%w: kdf without hash or salts", ErrNoSuchTag)
+	}
+
+	return k, nil
+}
+
+// gpgKDF reads the KDF DO, it returns nil without reading when the card doesn't support KDF.
+func gpgKDF(tx SCTx, g *GpgData) (*KDF, error) {
+	if g == nil || !g.KDFSupported {
+		return nil, nil
+	}
+
+	data, err := tx.Transmit(apdu{instruction: insGetDataA, param2: kdfTag})
+	if err != nil {
+		return nil, fmt.Errorf("get kdf: %w", err)
+	}
+
+	return ParseKDF(data)
+}
+
+// KDF returns the KDF of the card, nil when the card doesn't support it or it's off.
+// The PIN methods hash the PINs with it, there's no need to call Derive.
+func (yk *GPGYubiKey) KDF() (*KDF, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.KDF\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	return gpgKDF(yk.tx, yk.gpgData)
+}
+
+// login verifies a PIN, hashed with the KDF of the card when it's on.
+func (yk *GPGYubiKey) login(pin []byte, pwField byte) error {
+	kdf, err := gpgKDF(yk.tx, yk.gpgData)
+	if err != nil {
+		return err
+	}
+
+	return gpgLogin(yk.tx, kdf.Derive(pin, pwField), pwField)
+}
+
+// adminLogin verifies the admin PIN, the factory PIN if adminPIN is empty.
+func (yk *GPGYubiKey) adminLogin(adminPIN []byte) error {
+	kdf, err := gpgKDF(yk.tx, yk.gpgData)
+	if err != nil {
+		return err
+	}
+
+	return gpgLogin(yk.tx, yk.deriveAdminPIN(kdf, adminPIN), paramOpenGPGVerifyPW3)
+}
+
+// deriveAdminPIN returns the admin PIN the way VERIFY 83 takes it, the factory PIN if adminPIN is empty.
+// Admin-less Gnuk tokens compare it with PW1, so it's hashed with the PW1 salt and defaults to the user PIN.
+func (yk *GPGYubiKey) deriveAdminPIN(kdf *KDF, adminPIN []byte) []byte {
+	if yk.gpgData.Quirks.AdminLess {
+		return kdf.Derive(pinOrDefault(adminPIN, defaultPW1), paramOpenGPGVerifyPW1)
+	}
+
+	return kdf.Derive(pinOrDefault(adminPIN, defaultPW3), paramOpenGPGVerifyPW3)
+}
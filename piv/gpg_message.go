@@ -0,0 +1,511 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/aes/keywrap"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var (
+	// ErrFingerprintMismatch is returned when the public key read from the card doesn't have the fingerprint
+	// stored on the card, e.g. the key generation date isn't set.
+	ErrFingerprintMismatch = errors.New("public key doesn't match the fingerprint on the card")
+	// ErrNotForThisCard is returned when none of the encrypted session keys of a message are for the card.
+	ErrNotForThisCard = errors.New("message isn't encrypted to the decryption key of the card")
+	// ErrMalformedMessage is returned for OpenPGP messages that can't be parsed or use unsupported packets.
+	ErrMalformedMessage = errors.New("malformed OpenPGP message")
+)
+
+// openPGPMessageType is the armor type of an OpenPGP message.
+const openPGPMessageType = "PGP MESSAGE"
+
+// OpenPGPMessageOptions are the options of EncryptOpenPGPMessage.
+type OpenPGPMessageOptions struct {
+	// Armor writes an ASCII armored message, like `gpg --armor`.
+	Armor bool
+	// FileName is stored in the literal data packet.
+	FileName string
+}
+
+// EncryptOpenPGPMessage returns a writer that encrypts to the decryption key of the card, the message is a
+// public key encrypted session key packet and a symmetrically encrypted integrity protected data packet
+// with AES-256, that `gpg --decrypt` reads. The message is complete once the writer is closed.
+// https://www.rfc-editor.org/rfc/rfc4880#section-11.3
+//
+// Only the public key is read from the card, the fingerprint on the card has to match it, see ErrFingerprintMismatch.
+func (yk *GPGYubiKey) EncryptOpenPGPMessage(w io.Writer, opts OpenPGPMessageOptions) (io.WriteCloser, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.EncryptOpenPGPMessage\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	pub, err := yk.openPGPDecryptionPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptOpenPGPMessage(w, pub, opts)
+}
+
+func encryptOpenPGPMessage(w io.Writer, pub *packet.PublicKey, opts OpenPGPMessageOptions) (io.WriteCloser, error) {
+	config := &packet.Config{DefaultCipher: packet.CipherAES256}
+
+	sessionKey := make([]byte, packet.CipherAES256.KeySize())
+	if _, err := io.ReadFull(rand.Reader, sessionKey); err != nil {
+		return nil, err
+	}
+
+	m := &openPGPMessageWriter{}
+
+	if opts.Armor {
+		armored, err := armor.Encode(w, openPGPMessageType, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		m.armor, w = armored, armored
+	}
+
+	if err := packet.SerializeEncryptedKey(w, pub, packet.CipherAES256, sessionKey, config); err != nil {
+		return nil, fmt.Errorf("encrypt session key: %w", err)
+	}
+
+	encrypted, err := packet.SerializeSymmetricallyEncrypted(w, packet.CipherAES256, false, packet.CipherSuite{}, sessionKey, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.literal, err = packet.SerializeLiteral(encrypted, true, opts.FileName, uint32(time.Now().Unix())); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// openPGPMessageWriter closes the literal data, which closes the encrypted data, and the armor.
+type openPGPMessageWriter struct {
+	literal io.WriteCloser
+	armor   io.WriteCloser
+}
+
+func (m *openPGPMessageWriter) Write(p []byte) (int, error) {
+	return m.literal.Write(p)
+}
+
+func (m *openPGPMessageWriter) Close() error {
+	if err := m.literal.Close(); err != nil {
+		return err
+	}
+
+	if m.armor != nil {
+		return m.armor.Close()
+	}
+
+	return nil
+}
+
+// DecryptOpenPGPMessage decrypts an armored or binary OpenPGP message encrypted to the decryption key of the card,
+// e.g. by `gpg --encrypt`, and returns a reader for the literal data. The card decrypts the session key, it requires
+// PW1 (82), which auth presents.
+//
+// The integrity of the data is only checked at the end, a read error means the data read so far has to be discarded.
+// Signatures of signed messages are skipped, not verified.
+func (yk *GPGYubiKey) DecryptOpenPGPMessage(r io.Reader, auth OpenPGPKeyAuth) (io.Reader, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.DecryptOpenPGPMessage\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	pub, err := yk.openPGPDecryptionPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter, err := yk.OpenPGPDecrypter(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err = dearmorOpenPGPMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptOpenPGPMessage(r, pub, decrypter)
+}
+
+// dearmorOpenPGPMessage removes the armor of a message if it has one.
+func dearmorOpenPGPMessage(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	start, _ := br.Peek(len(armorStart))
+	if string(start) != armorStart {
+		return br, nil
+	}
+
+	block, err := armor.Decode(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	if block.Type != openPGPMessageType {
+		return nil, fmt.Errorf("%w: armor type %s", ErrMalformedMessage, block.Type)
+	}
+
+	return block.Body, nil
+}
+
+const armorStart = "-----BEGIN PGP"
+
+func decryptOpenPGPMessage(r io.Reader, pub *packet.PublicKey, decrypter crypto.Decrypter) (io.Reader, error) {
+	var (
+		packets    = packet.NewReader(r)
+		cipherFunc packet.CipherFunction
+		sessionKey []byte
+	)
+
+	for {
+		p, err := packets.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: no encrypted data", ErrMalformedMessage)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+		}
+
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			// a zero key ID is an anonymous recipient.
+			if sessionKey != nil || (p.KeyId != 0 && p.KeyId != pub.KeyId) || p.Algo != pub.PubKeyAlgo {
+				continue
+			}
+
+			if cipherFunc, sessionKey, err = openPGPSessionKey(p, pub, decrypter); err != nil {
+				return nil, err
+			}
+		case *packet.SymmetricKeyEncrypted:
+			// a passphrase recipient.
+			continue
+		case *packet.SymmetricallyEncrypted:
+			if sessionKey == nil {
+				return nil, ErrNotForThisCard
+			}
+
+			if !p.IntegrityProtected {
+				return nil, fmt.Errorf("%w: data isn't integrity protected", ErrMalformedMessage)
+			}
+
+			decrypted, err := p.Decrypt(cipherFunc, sessionKey)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+			}
+
+			return openPGPLiteralData(decrypted)
+		default:
+			return nil, fmt.Errorf("%w: unexpected packet %T", ErrMalformedMessage, p)
+		}
+	}
+}
+
+// openPGPLiteralData returns the reader of the literal data packet in the decrypted data.
+func openPGPLiteralData(decrypted io.ReadCloser) (io.Reader, error) {
+	packets := packet.NewReader(decrypted)
+
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+		}
+
+		switch p := p.(type) {
+		case *packet.Compressed:
+			if err := packets.Push(p.Body); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+			}
+		case *packet.OnePassSignature, *packet.Signature:
+			continue
+		case *packet.LiteralData:
+			return &openPGPMessageReader{body: p.Body, decrypted: decrypted}, nil
+		default:
+			return nil, fmt.Errorf("%w: unexpected packet %T", ErrMalformedMessage, p)
+		}
+	}
+}
+
+// openPGPMessageReader checks the modification detection code once the literal data is read.
+type openPGPMessageReader struct {
+	body      io.Reader
+	decrypted io.ReadCloser
+}
+
+func (m *openPGPMessageReader) Read(p []byte) (int, error) {
+	n, err := m.body.Read(p)
+	if errors.Is(err, io.EOF) {
+		// Close reads the rest of the data and checks the MDC.
+		if closeErr := m.decrypted.Close(); closeErr != nil {
+			return n, fmt.Errorf("%w: %w", ErrMalformedMessage, closeErr)
+		}
+	}
+
+	return n, err
+}
+
+// openPGPSessionKey has the card decrypt the session key of a public key encrypted session key packet.
+// https://www.rfc-editor.org/rfc/rfc4880#section-5.1
+// https://www.rfc-editor.org/rfc/rfc6637#section-8
+func openPGPSessionKey(ek *packet.EncryptedKey, pub *packet.PublicKey, decrypter crypto.Decrypter) (packet.CipherFunction, []byte, error) {
+	// the MPIs aren't exported, they're read back from the packet.
+	var buf bytes.Buffer
+	if err := ek.Serialize(&buf); err != nil {
+		return 0, nil, err
+	}
+
+	body, err := openPGPPacketBody(buf.Bytes())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// version, key ID and algorithm.
+	const fixed = 1 + 8 + 1
+	if len(body) < fixed {
+		return 0, nil, fmt.Errorf("%w: short encrypted session key", ErrMalformedMessage)
+	}
+
+	mpi, rest, err := readOpenPGPMPI(body[fixed:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var m []byte
+
+	switch k := pub.PublicKey.(type) {
+	case *rsa.PublicKey:
+		// the card wants the ciphertext with the size of the modulus.
+		m, err = decrypter.Decrypt(rand.Reader, new(big.Int).SetBytes(mpi).FillBytes(make([]byte, k.Size())), nil)
+	default:
+		m, err = openPGPECDHSessionKey(pub, mpi, rest, decrypter)
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// cipher, key and a 2 byte checksum.
+	if len(m) < 3 {
+		return 0, nil, fmt.Errorf("%w: short session key", ErrMalformedMessage)
+	}
+
+	key, checksum := m[1:len(m)-2], binary.BigEndian.Uint16(m[len(m)-2:])
+
+	var sum uint16
+	for _, b := range key {
+		sum += uint16(b)
+	}
+
+	cipherFunc := packet.CipherFunction(m[0])
+	if sum != checksum || !cipherFunc.IsSupported() || cipherFunc.KeySize() != len(key) {
+		return 0, nil, fmt.Errorf("%w: invalid session key", ErrMalformedMessage)
+	}
+
+	return cipherFunc, key, nil
+}
+
+// openPGPECDHSessionKey unwraps the session key with the KEK derived from the shared secret the card computes.
+// https://www.rfc-editor.org/rfc/rfc6637#section-7
+func openPGPECDHSessionKey(pub *packet.PublicKey, point, rest []byte, decrypter crypto.Decrypter) ([]byte, error) {
+	if len(rest) < 1 || len(rest) != 1+int(rest[0]) {
+		return nil, fmt.Errorf("%w: wrapped session key", ErrMalformedMessage)
+	}
+
+	ecdhKey, ok := decrypter.Public().(*ecdh.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNoSuchAlgorithm, decrypter.Public())
+	}
+
+	curve, err := openPGPCurveForECDH(ecdhKey.Curve())
+	if err != nil {
+		return nil, err
+	}
+
+	if curve.ecdh == ecdh.X25519() && len(point) == 33 && point[0] == openPGPNativePointPrefix {
+		point = point[1:]
+	}
+
+	secret, err := decrypter.Decrypt(rand.Reader, point, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the KDF parameters are the last bytes of the public key.
+	var buf bytes.Buffer
+	if err := pub.Serialize(&buf); err != nil {
+		return nil, err
+	}
+
+	body, err := openPGPPacketBody(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := openPGPKEK(curve, body[len(body)-2:], secret, pub.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := keywrap.Unwrap(kek, rest[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	// PKCS#5 padding.
+	pad := int(m[len(m)-1])
+	if pad == 0 || pad > len(m) || !bytes.Equal(m[len(m)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("%w: session key padding", ErrMalformedMessage)
+	}
+
+	return m[:len(m)-pad], nil
+}
+
+// openPGPKEK is the key encryption key, KDF(Z, Param) with the KDF parameters (hash, cipher) of the key.
+// https://www.rfc-editor.org/rfc/rfc6637#section-8
+func openPGPKEK(curve *openPGPCurve, kdf, secret, fingerprint []byte) ([]byte, error) {
+	hashes := map[byte]crypto.Hash{0x08: crypto.SHA256, 0x09: crypto.SHA384, 0x0a: crypto.SHA512}
+	keySizes := map[byte]int{0x07: 16, 0x08: 24, 0x09: 32}
+
+	hash, keySize := hashes[kdf[0]], keySizes[kdf[1]]
+	if !hash.Available() || keySize == 0 {
+		return nil, fmt.Errorf("%w: KDF parameters %x", ErrNoSuchAlgorithm, kdf)
+	}
+
+	param := append([]byte{byte(len(curve.oid))}, curve.oid...)
+	param = append(param, openPGPAlgorithmECDH, 0x03, 0x01)
+	param = append(param, kdf...)
+	param = append(param, "Anonymous Sender    "...)
+	param = append(param, fingerprint...)
+
+	h := hash.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(secret)
+	h.Write(param)
+
+	return h.Sum(nil)[:keySize], nil
+}
+
+// openPGPPacketBody strips the new format header go-crypto writes.
+// https://www.rfc-editor.org/rfc/rfc4880#section-4.2.2
+func openPGPPacketBody(p []byte) ([]byte, error) {
+	if len(p) < 2 || p[0]&0xc0 != 0xc0 {
+		return nil, fmt.Errorf("%w: packet header", ErrMalformedMessage)
+	}
+
+	switch l := p[1]; {
+	case l < 192:
+		return p[2:], nil
+	case l < 224 && len(p) >= 3:
+		return p[3:], nil
+	case l == 255 && len(p) >= 6:
+		return p[6:], nil
+	}
+
+	return nil, fmt.Errorf("%w: packet length", ErrMalformedMessage)
+}
+
+// readOpenPGPMPI returns the value of the multiprecision integer at the start of b and the rest of b.
+func readOpenPGPMPI(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, fmt.Errorf("%w: short MPI", ErrMalformedMessage)
+	}
+
+	n := (int(binary.BigEndian.Uint16(b)) + 7) / 8
+	if len(b) < 2+n {
+		return nil, nil, fmt.Errorf("%w: short MPI", ErrMalformedMessage)
+	}
+
+	return b[2 : 2+n], b[2+n:], nil
+}
+
+// openPGPDecryptionPublicKey returns the public key packet of the decryption key, with the creation date on the card.
+func (yk *GPGYubiKey) openPGPDecryptionPublicKey() (*packet.PublicKey, error) {
+	body, err := yk.openPGPCardPublicKeyBody(DecryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := packet.Read(bytes.NewReader(openPGPPacket(openPGPTagPublicKey, body)))
+	if err != nil {
+		return nil, err
+	}
+
+	pk, ok := p.(*packet.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrMalformedMessage, p)
+	}
+
+	return pk, nil
+}
+
+func openPGPCurveForECDH(curve ecdh.Curve) (*openPGPCurve, error) {
+	if curve == ecdh.X25519() {
+		return &openPGPCurve25519, nil
+	}
+
+	for i := range openPGPCurves {
+		if openPGPCurves[i].ecdh == curve {
+			return &openPGPCurves[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: curve %s", ErrNoSuchAlgorithm, curve)
+}
+
+// ecdsaFromECDH converts a NIST curve ECDH key to the *ecdsa.PublicKey OpenPGP public key packets are built from.
+func ecdsaFromECDH(pub *ecdh.PublicKey) (*ecdsa.PublicKey, error) {
+	curve, err := openPGPCurveForECDH(pub.Curve())
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := elliptic.Unmarshal(curve.curve, pub.Bytes()) // nolint:staticcheck // ecdsa.PublicKey still needs X and Y.
+	if x == nil {
+		return nil, fmt.Errorf("%w: invalid point", ErrNoPublicKeyPoint)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve.curve, X: x, Y: y}, nil
+}
@@ -0,0 +1,256 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// newTestMessageCard imports a new software key into a simulated card and returns both.
+func newTestMessageCard(t *testing.T, config *packet.Config) (*piv.GPGYubiKey, *openpgp.Entity) {
+	t.Helper()
+
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var armored bytes.Buffer
+
+	w, err := armor.Encode(&armored, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if _, err := yk.ImportArmoredKey(&armored, nil, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	// reload the fingerprints and dates of the new keys.
+	if _, err := yk.GPGData(); err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	return yk, e
+}
+
+func TestGPGYubiKey_OpenPGPMessage(t *testing.T) {
+	t.Parallel()
+
+	auth := piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)}
+	plainText := bytes.Repeat([]byte("attack at dawn "), 10_000)
+
+	tests := []struct {
+		name   string
+		config *packet.Config
+		armor  bool
+	}{
+		{
+			name:   "rsa",
+			config: &packet.Config{Algorithm: packet.PubKeyAlgoRSA, RSABits: 2048},
+		},
+		{
+			name:   "p256 armored",
+			config: &packet.Config{Algorithm: packet.PubKeyAlgoECDSA, Curve: packet.CurveNistP256},
+			armor:  true,
+		},
+		{
+			name:   "p384",
+			config: &packet.Config{Algorithm: packet.PubKeyAlgoECDSA, Curve: packet.CurveNistP384},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk, e := newTestMessageCard(t, tc.config)
+
+			// card to software.
+			var msg bytes.Buffer
+
+			w, err := yk.EncryptOpenPGPMessage(&msg, piv.OpenPGPMessageOptions{Armor: tc.armor, FileName: "dawn.txt"})
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+
+			if _, err := w.Write(plainText); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.HasPrefix(msg.String(), "-----BEGIN PGP MESSAGE-----"); got != tc.armor {
+				t.Errorf("armored %v, expected %v", got, tc.armor)
+			}
+
+			r := io.Reader(bytes.NewReader(msg.Bytes()))
+			if tc.armor {
+				block, err := armor.Decode(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				r = block.Body
+			}
+
+			md, err := openpgp.ReadMessage(r, openpgp.EntityList{e}, nil, nil)
+			if err != nil {
+				t.Fatalf("read message: %v", err)
+			}
+
+			got, err := io.ReadAll(md.UnverifiedBody)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+
+			if !bytes.Equal(got, plainText) || md.LiteralData.FileName != "dawn.txt" {
+				t.Errorf("decrypted %d bytes of %q, expected %d", len(got), md.LiteralData.FileName, len(plainText))
+			}
+
+			// software to card, and the card decrypts its own messages.
+			var sw bytes.Buffer
+
+			w, err = openpgp.Encrypt(&sw, openpgp.EntityList{e}, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := w.Write(plainText); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, m := range [][]byte{sw.Bytes(), msg.Bytes()} {
+				r, err := yk.DecryptOpenPGPMessage(bytes.NewReader(m), auth)
+				if err != nil {
+					t.Fatalf("decrypt: %v", err)
+				}
+
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+
+				if !bytes.Equal(got, plainText) {
+					t.Errorf("decrypted %d bytes, expected %d", len(got), len(plainText))
+				}
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_DecryptOpenPGPMessageErrors(t *testing.T) {
+	t.Parallel()
+
+	auth := piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)}
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoECDSA, Curve: packet.CurveNistP256}
+	yk, e := newTestMessageCard(t, config)
+
+	other, err := openpgp.NewEntity("Other", "", "other@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypt := func(to *openpgp.Entity) []byte {
+		var buf bytes.Buffer
+
+		w, err := openpgp.Encrypt(&buf, openpgp.EntityList{to}, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write([]byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	tampered := encrypt(e)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name      string
+		message   []byte
+		expectErr error
+	}{
+		{
+			name:      "other recipient",
+			message:   encrypt(other),
+			expectErr: piv.ErrNotForThisCard,
+		},
+		{
+			name:      "tampered",
+			message:   tampered,
+			expectErr: piv.ErrMalformedMessage,
+		},
+		{
+			name:      "garbage",
+			message:   []byte("not a message"),
+			expectErr: piv.ErrMalformedMessage,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := yk.DecryptOpenPGPMessage(bytes.NewReader(tc.message), auth)
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+
+			if !errors.Is(err, tc.expectErr) {
+				t.Errorf("error %v, expected %v", err, tc.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"fmt"
+)
+
+// SecurityOperation is the operation whose key MANAGE SECURITY ENVIRONMENT changes,
+// the tag of its control reference template.
+type SecurityOperation byte
+
+const (
+	// SecurityOperationDecipher is PSO:DECIPHER, the decryption key by default.
+	SecurityOperationDecipher SecurityOperation = 0xb8
+	// SecurityOperationAuthenticate is INTERNAL AUTHENTICATE, the authentication key by default.
+	SecurityOperationAuthenticate SecurityOperation = 0xa4
+)
+
+const (
+	// mseSetParam1 sets the template for computation, decipherment and internal authentication.
+	mseSetParam1 = 0x41
+	// mseKeyReferenceTag is followed by the key reference, 02 for the decryption key and 03 for the authentication key.
+	mseKeyReferenceTag = 0x83
+)
+
+func (op SecurityOperation) String() string {
+	switch op {
+	case SecurityOperationDecipher:
+		return "decipher"
+	case SecurityOperationAuthenticate:
+		return "authenticate"
+	default:
+		return fmt.Sprintf("SecurityOperation(%02x)", byte(op))
+	}
+}
+
+// defaultKey is the key the card uses for op after the applet is selected.
+func (op SecurityOperation) defaultKey() KeyType {
+	if op == SecurityOperationDecipher {
+		return DecryptionKey
+	}
+
+	return AuthenticationKey
+}
+
+// ManageSecurityEnvironment has the card use keyType for op, so the authentication key can decrypt
+// with PSO:DECIPHER and the decryption key can sign with INTERNAL AUTHENTICATE. Only DecryptionKey and
+// AuthenticationKey can be chosen, the key must have attributes that can do op.
+//
+// The card forgets the choice when the applet is selected again, OpenPGPDecrypter and OpenPGPPrivateKey
+// remember it and send MANAGE SECURITY ENVIRONMENT again before each operation.
+// It returns ErrUnsupportedByCardVersion for 2.x cards and ErrNotSupportedByCard for cards
+// that don't announce MSE in the extended capabilities.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 79
+// 7.2.18 MANAGE SECURITY ENVIRONMENT.
+func (yk *GPGYubiKey) ManageSecurityEnvironment(op SecurityOperation, keyType KeyType) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ManageSecurityEnvironment\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if !yk.gpgData.Features().MSE {
+		return fmt.Errorf("manage security environment: %w", ErrUnsupportedByCardVersion)
+	}
+
+	if !yk.gpgData.MSECommandSupported {
+		return fmt.Errorf("manage security environment: %w", ErrNotSupportedByCard)
+	}
+
+	switch op {
+	case SecurityOperationDecipher, SecurityOperationAuthenticate:
+	default:
+		return fmt.Errorf("manage security environment for %s: %w", op, ErrNotSupportedByCard)
+	}
+
+	switch keyType {
+	case DecryptionKey, AuthenticationKey:
+	default:
+		return fmt.Errorf("%w: %s can't be used to %s", ErrUnknownKeyType, keyType, op)
+	}
+
+	if err := gpgManageSecurityEnvironment(yk.tx, op, keyType); err != nil {
+		return fmt.Errorf("manage security environment: %w", gpgKeyError(err))
+	}
+
+	if yk.mse == nil {
+		yk.mse = map[SecurityOperation]KeyType{}
+	}
+
+	yk.mse[op] = keyType
+
+	return nil
+}
+
+// securityEnvironmentKey returns the key the card uses for op, set with ManageSecurityEnvironment.
+func (yk *GPGYubiKey) securityEnvironmentKey(op SecurityOperation) KeyType {
+	if keyType, ok := yk.mse[op]; ok {
+		return keyType
+	}
+
+	return op.defaultKey()
+}
+
+// gpgManageSecurityEnvironment sets the key of op, the key reference is one more than the KeyType.
+func gpgManageSecurityEnvironment(tx SCTx, op SecurityOperation, keyType KeyType) error {
+	cmd := apdu{
+		instruction: insManageSecurityEnvironment,
+		param1:      mseSetParam1,
+		param2:      byte(op),
+		data:        []byte{mseKeyReferenceTag, 0x01, byte(keyType) + 1},
+	}
+
+	if _, err := tx.Transmit(cmd); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// gpgWithSecurityEnvironment runs f after choosing keyType for op again, when it isn't the key the
+// card uses after the applet is selected.
+func gpgWithSecurityEnvironment(op SecurityOperation, keyType KeyType, f func(tx SCTx) ([]byte, error)) func(tx SCTx) ([]byte, error) {
+	if keyType == op.defaultKey() {
+		return f
+	}
+
+	return func(tx SCTx) ([]byte, error) {
+		if err := gpgManageSecurityEnvironment(tx, op, keyType); err != nil {
+			return nil, err
+		}
+
+		return f(tx)
+	}
+}
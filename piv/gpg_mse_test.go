@@ -0,0 +1,124 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_ManageSecurityEnvironment(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatal(err)
+	}
+
+	decPub, err := yk.GenerateOpenPGPKey(piv.DecryptionKey, piv.AlgorithmRSA2048)
+	if err != nil {
+		t.Fatalf("generate decryption key: %v", err)
+	}
+
+	autPub, err := yk.GenerateOpenPGPKey(piv.AuthenticationKey, piv.AlgorithmRSA2048)
+	if err != nil {
+		t.Fatalf("generate authentication key: %v", err)
+	}
+
+	auth := piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)}
+
+	if err := yk.ManageSecurityEnvironment(piv.SecurityOperationDecipher, piv.AuthenticationKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := yk.ManageSecurityEnvironment(piv.SecurityOperationAuthenticate, piv.DecryptionKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// selecting the applet again makes the card forget the keys, they're chosen again for each operation.
+	if err := yk.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("decipher with the authentication key", func(t *testing.T) {
+		dec, err := yk.OpenPGPDecrypter(auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !autPub.(*rsa.PublicKey).Equal(dec.Public()) {
+			t.Fatal("decrypter doesn't have the authentication key")
+		}
+
+		secret := []byte("wrapped session key")
+
+		ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, autPub.(*rsa.PublicKey), secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext, err := dec.Decrypt(nil, ciphertext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(plaintext, secret) {
+			t.Errorf("got %x expected %x", plaintext, secret)
+		}
+	})
+
+	t.Run("authenticate with the decryption key", func(t *testing.T) {
+		priv, err := yk.OpenPGPPrivateKey(piv.AuthenticationKey, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signer, ok := priv.(crypto.Signer)
+		if !ok || !decPub.(*rsa.PublicKey).Equal(signer.Public()) {
+			t.Fatal("signer doesn't have the decryption key")
+		}
+
+		digest := sha256.Sum256([]byte("challenge"))
+
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := rsa.VerifyPKCS1v15(decPub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+	})
+
+	t.Run("signature key", func(t *testing.T) {
+		err := yk.ManageSecurityEnvironment(piv.SecurityOperationDecipher, piv.SignatureKey)
+		if !errors.Is(err, piv.ErrUnknownKeyType) {
+			t.Errorf("got %v expected %v", err, piv.ErrUnknownKeyType)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"fmt"
+)
+
+// PW is an OpenPGP password, the reference of VERIFY.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 16
+// 4.3 User Verification in the OpenPGP Application.
+type PW byte
+
+const (
+	// PW1 is the user PIN in mode 81, it allows PSO: COMPUTE DIGITAL SIGNATURE.
+	// Depending on the PW status it's only valid for one signature.
+	PW1 PW = paramOpenGPGVerifyPW1
+	// PW2 is the user PIN in mode 82, it allows PSO: DECIPHER, INTERNAL AUTHENTICATE and the private DOs.
+	// It's the same PIN and retry counter as PW1, only the verification state is separate.
+	PW2 PW = paramOpenGPGVerifyPW2
+	// PW3 is the admin PIN.
+	PW3 PW = paramOpenGPGVerifyPW3
+)
+
+func (pw PW) String() string {
+	switch pw {
+	case PW1:
+		return "PW1"
+	case PW2:
+		return "PW2"
+	case PW3:
+		return "PW3"
+	}
+
+	return fmt.Sprintf("unknown: 0x%x", byte(pw))
+}
+
+// minLength is the shortest PIN the card accepts for pw.
+func (pw PW) minLength() int {
+	if pw == PW3 {
+		return minPW3Length
+	}
+
+	return minPW1Length
+}
+
+// PINRetries are the remaining attempts of the OpenPGP PINs, 0 is blocked.
+// ResetCode is 0 when no resetting code is set.
+type PINRetries struct {
+	PW1       int
+	ResetCode int
+	PW3       int
+}
+
+// PWStatus are the PW status bytes (C4).
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+type PWStatus struct {
+	// PW1ValidForMultipleSignatures is false when PW1 must be presented again for every signature.
+	PW1ValidForMultipleSignatures bool
+	// PW1MaxLength, ResetCodeMaxLength and PW3MaxLength are the longest PINs the card accepts.
+	PW1MaxLength       int
+	ResetCodeMaxLength int
+	PW3MaxLength       int
+	// PW1PINBlock2 and PW3PINBlock2 are set when the PIN is sent in PIN block 2 format instead of UTF-8.
+	PW1PINBlock2 bool
+	PW3PINBlock2 bool
+	// Retries are the retry counters.
+	Retries PINRetries
+}
+
+// pinBlock2Format is bit 8 of the max length bytes of PW1 and PW3.
+const pinBlock2Format = 0x80
+
+// parsePWStatus parses the 7 PW status bytes.
+func parsePWStatus(data []byte) (*PWStatus, error) {
+	if len(data) < pwStatusLen {
+		return nil, fmt.Errorf("pw status too short, got [%d] needed at least [%d], %w", len(data), pwStatusLen, ErrTooShort)
+	}
+
+	return &PWStatus{
+		PW1ValidForMultipleSignatures: data[0] != 0,
+		PW1MaxLength:                  int(data[1] &^ pinBlock2Format),
+		PW1PINBlock2:                  data[1]&pinBlock2Format != 0,
+		ResetCodeMaxLength:            int(data[2]),
+		PW3MaxLength:                  int(data[3] &^ pinBlock2Format),
+		PW3PINBlock2:                  data[3]&pinBlock2Format != 0,
+		Retries: PINRetries{
+			PW1:       int(data[4]),
+			ResetCode: int(data[5]),
+			PW3:       int(data[6]),
+		},
+	}, nil
+}
+
+// PWStatus returns the PW status bytes (C4) read when the card was opened, the retry counters
+// aren't refreshed, use PINRetries for the current ones.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+func (g *GpgData) PWStatus() (*PWStatus, error) {
+	if g == nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := g.GetTag(pwStatusTag, pwStatusLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePWStatus(data)
+}
+
+// VerifyPIN presents a PIN, see PW1 and PW2 for what each mode of the user PIN allows.
+// A wrong PIN returns an AuthErr with the remaining retries. PW3 is verified with AuthAdminPIN,
+// admin-less tokens take the user PIN for it.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 52
+// 7.2.2 VERIFY.
+func (yk *GPGYubiKey) VerifyPIN(pw PW, pin []byte) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.VerifyPIN\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if pw == PW3 {
+		return yk.AuthAdminPIN(pin)
+	}
+
+	if len(pin) < pw.minLength() {
+		return ErrTooShort
+	}
+
+	return yk.login(pin, byte(pw))
+}
+
+// ChangePIN changes the user PIN (PW1 or PW2, they are the same PIN) or the admin PIN (PW3).
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 54
+// 7.2.3 CHANGE REFERENCE DATA.
+func (yk *GPGYubiKey) ChangePIN(pw PW, oldPIN, newPIN []byte) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ChangePIN\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	// CHANGE REFERENCE DATA only knows mode 81.
+	if pw == PW2 {
+		pw = PW1
+	}
+
+	// the hashes of the KDF are long enough, check the PINs first.
+	if len(oldPIN) < pw.minLength() || len(newPIN) < pw.minLength() {
+		return ErrTooShort
+	}
+
+	kdf, err := gpgKDF(yk.tx, yk.gpgData)
+	if err != nil {
+		return err
+	}
+
+	return gpgChangeReferenceData(yk.tx, byte(pw), kdf.Derive(oldPIN, byte(pw)), kdf.Derive(newPIN, byte(pw)))
+}
+
+// UnblockPIN sets a new user PIN and resets its retry counter with the resetting code.
+// With an empty resetCode it uses the admin PIN instead, which must have been presented, see VerifyPIN.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 55
+// 7.2.4 RESET RETRY COUNTER.
+func (yk *GPGYubiKey) UnblockPIN(resetCode, newPIN []byte) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.UnblockPIN\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	// the resetting code has the same minimum as PW1.
+	if len(newPIN) < minPW1Length || (len(resetCode) > 0 && len(resetCode) < minPW1Length) {
+		return ErrTooShort
+	}
+
+	kdf, err := gpgKDF(yk.tx, yk.gpgData)
+	if err != nil {
+		return err
+	}
+
+	newPIN = kdf.Derive(newPIN, paramOpenGPGVerifyPW1)
+
+	cmd := apdu{
+		instruction: insResetRetryCounter,
+		param1:      0x02,
+		param2:      paramOpenGPGVerifyPW1,
+		data:        newPIN,
+	}
+
+	if len(resetCode) > 0 {
+		cmd.param1 = 0x00
+		cmd.data = append(kdf.Derive(resetCode, paramOpenGPGResettingCode), newPIN...)
+	}
+
+	if _, err := yk.tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("reset retry counter: %w", err)
+	}
+
+	return nil
+}
+
+// PINRetries returns the remaining attempts of the PINs from the PW status bytes (C4).
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+func (yk *GPGYubiKey) PINRetries() (*PINRetries, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PINRetries\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := getPinRetries(yk.tx)
+	if err != nil {
+		return nil, fmt.Errorf("pw status: %w", err)
+	}
+
+	retries := &PINRetries{}
+
+	for _, r := range []struct {
+		pwField byte
+		count   *int
+	}{
+		{paramOpenGPGVerifyPW1, &retries.PW1},
+		{paramOpenGPGResettingCode, &retries.ResetCode},
+		{paramOpenGPGVerifyPW3, &retries.PW3},
+	} {
+		n, err := parsePinRetries(data, r.pwField)
+		if err != nil {
+			return nil, fmt.Errorf("pw status: %w", err)
+		}
+
+		*r.count = int(n)
+	}
+
+	return retries, nil
+}
+
+// SetPW1ValidForMultipleSignatures sets whether a verified PW1 (81) stays valid after a signature,
+// when it's false the user PIN must be presented for every PSO: COMPUTE DIGITAL SIGNATURE.
+// The card must allow changing the PW status, see PWStatusChangeable.
+//
+// It requires PW3 (83) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 26
+// 4.4.2 DOs for PUT DATA.
+func (yk *GPGYubiKey) SetPW1ValidForMultipleSignatures(valid bool) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.SetPW1ValidForMultipleSignatures\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if !yk.gpgData.PWStatusChangeable {
+		return ErrNotSupportedByCard
+	}
+
+	status := byte(0x00)
+	if valid {
+		status = 0x01
+	}
+
+	if err := gpgPutData(yk.tx, putPWStatusTag, []byte{status}); err != nil {
+		return fmt.Errorf("pw status: %w", err)
+	}
+
+	if data, err := yk.gpgData.GetTag(pwStatusTag, pwStatusLen); err == nil {
+		data = append([]byte{}, data...)
+		data[0] = status
+		yk.gpgData.tlvValues[pwStatusTag] = data
+	}
+
+	return nil
+}
@@ -0,0 +1,324 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGPGYubiKey_PINs(t *testing.T) {
+	t.Parallel()
+
+	pin := []byte("123456")
+	newPIN := []byte("654321")
+	adminPIN := []byte("12345678")
+
+	tests := []struct {
+		name      string
+		run       func(yk *GPGYubiKey) error
+		apdus     []apdu
+		sw        *apduErr
+		expectErr error
+	}{
+		{
+			name:  "verify pw1",
+			run:   func(yk *GPGYubiKey) error { return yk.VerifyPIN(PW1, pin) },
+			apdus: []apdu{{instruction: insVerify, param2: 0x81, data: pin}},
+		},
+		{
+			name:  "verify pw3",
+			run:   func(yk *GPGYubiKey) error { return yk.VerifyPIN(PW3, adminPIN) },
+			apdus: []apdu{{instruction: insVerify, param2: 0x83, data: adminPIN}},
+		},
+		{
+			name:      "verify pw3 too short",
+			run:       func(yk *GPGYubiKey) error { return yk.VerifyPIN(PW3, pin) },
+			expectErr: ErrTooShort,
+		},
+		{
+			name:      "verify pw2 wrong pin",
+			run:       func(yk *GPGYubiKey) error { return yk.VerifyPIN(PW2, pin) },
+			apdus:     []apdu{{instruction: insVerify, param2: 0x82, data: pin}},
+			sw:        &apduErr{0x63, 0xc1},
+			expectErr: AuthErr{1},
+		},
+		{
+			name:  "change pw2 changes pw1",
+			run:   func(yk *GPGYubiKey) error { return yk.ChangePIN(PW2, pin, newPIN) },
+			apdus: []apdu{{instruction: insChangeReferenceData, param2: 0x81, data: append(append([]byte{}, pin...), newPIN...)}},
+		},
+		{
+			name:      "change pw3 too short",
+			run:       func(yk *GPGYubiKey) error { return yk.ChangePIN(PW3, adminPIN, pin) },
+			expectErr: ErrTooShort,
+		},
+		{
+			name:  "unblock with resetting code",
+			run:   func(yk *GPGYubiKey) error { return yk.UnblockPIN(adminPIN, newPIN) },
+			apdus: []apdu{{instruction: insResetRetryCounter, param2: 0x81, data: append(append([]byte{}, adminPIN...), newPIN...)}},
+		},
+		{
+			name:  "unblock with admin pin",
+			run:   func(yk *GPGYubiKey) error { return yk.UnblockPIN(nil, newPIN) },
+			apdus: []apdu{{instruction: insResetRetryCounter, param1: 0x02, param2: 0x81, data: newPIN}},
+		},
+		{
+			name:      "unblock blocked resetting code",
+			run:       func(yk *GPGYubiKey) error { return yk.UnblockPIN(adminPIN, newPIN) },
+			apdus:     []apdu{{instruction: insResetRetryCounter, param2: 0x81, data: append(append([]byte{}, adminPIN...), newPIN...)}},
+			sw:        &apduErr{0x69, 0x83},
+			expectErr: AuthErr{0},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var transmitErr []error
+			if tc.sw != nil {
+				transmitErr = []error{tc.sw}
+			}
+
+			yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: make([][]byte, len(tc.apdus)), TransmitErr: transmitErr}
+
+			if !expectedError(t, tc.run(yk), tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tc.apdus))
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_PINRetries(t *testing.T) {
+	t.Parallel()
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = &TestSCTx{
+		APDUList:     []apdu{{instruction: insGetDataA, param2: paramOpenGPGGetRetries}},
+		ResponseList: [][]byte{mustHex(t, "01 7f 7f 7f 02 00 03")},
+	}
+
+	retries, err := yk.PINRetries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := (PINRetries{PW1: 2, ResetCode: 0, PW3: 3}); *retries != expected {
+		t.Errorf("got %+v expected %+v", *retries, expected)
+	}
+}
+
+func TestGpgData_PWStatus(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected PWStatus
+		err      error
+	}{
+		{name: "missing", err: ErrNoSuchTag},
+		{name: "too short", data: mustHex(t, "01 7f 7f 7f 03 00"), err: ErrTooShort},
+		{
+			name: "yubikey defaults",
+			data: mustHex(t, "00 7f 7f 7f 03 00 03"),
+			expected: PWStatus{
+				PW1MaxLength:       127,
+				ResetCodeMaxLength: 127,
+				PW3MaxLength:       127,
+				Retries:            PINRetries{PW1: 3, ResetCode: 0, PW3: 3},
+			},
+		},
+		{
+			name: "pin block 2",
+			data: mustHex(t, "01 8c 08 88 02 01 00"),
+			expected: PWStatus{
+				PW1ValidForMultipleSignatures: true,
+				PW1MaxLength:                  12,
+				PW1PINBlock2:                  true,
+				ResetCodeMaxLength:            8,
+				PW3MaxLength:                  8,
+				PW3PINBlock2:                  true,
+				Retries:                       PINRetries{PW1: 2, ResetCode: 1, PW3: 0},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := GpgData{tlvValues: bertlv.TLVData{}}
+			if tc.data != nil {
+				g.tlvValues[pwStatusTag] = tc.data
+			}
+
+			status, err := g.PWStatus()
+			if !expectedError(t, err, tc.err) || tc.err != nil {
+				return
+			}
+
+			if *status != tc.expected {
+				t.Errorf("got %+v expected %+v", *status, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_SetPW1ValidForMultipleSignatures(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		valid      bool
+		changeable bool
+		expected   []byte
+		expectErr  error
+	}{
+		{name: "valid", valid: true, changeable: true, expected: mustHex(t, "01 7f 7f 7f 03 00 03")},
+		{name: "once", valid: false, changeable: true, expected: mustHex(t, "00 7f 7f 7f 03 00 03")},
+		{name: "not changeable", valid: true, expectErr: ErrNotSupportedByCard},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			status := byte(0x00)
+			if tc.valid {
+				status = 0x01
+			}
+
+			cached := mustHex(t, "00 7f 7f 7f 03 00 03")
+			yk := NewTestGpgYubikey(&GpgData{
+				PWStatusChangeable: tc.changeable,
+				tlvValues:          bertlv.TLVData{pwStatusTag: cached},
+			}, false, nil)
+			yk.tx = &TestSCTx{
+				APDUList:     []apdu{{instruction: insPutDataDA, param2: putPWStatusTag, data: []byte{status}}},
+				ResponseList: [][]byte{nil},
+			}
+
+			err := yk.SetPW1ValidForMultipleSignatures(tc.valid)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if got, _ := yk.gpgData.GetTag(pwStatusTag, pwStatusLen); !bytes.Equal(got, tc.expected) {
+				t.Errorf("got cached pw status %X expected %X", got, tc.expected)
+			}
+
+			if cached[0] != 0x00 {
+				t.Error("the cached pw status was modified in place")
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_VerifyPINWithKDF(t *testing.T) {
+	t.Parallel()
+
+	kdf := &KDF{Hash: crypto.SHA256, Iterations: 1024, SaltPW1: []byte("saltpw1!"), SaltPW3: []byte("saltpw3!")}
+
+	marshaled, err := kdf.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := []byte("123456")
+	getKDF := apdu{instruction: insGetDataA, param2: kdfTag}
+
+	yk := NewTestGpgYubikey(&GpgData{KDFSupported: true}, false, nil)
+	yk.tx = &TestSCTx{
+		APDUList: []apdu{
+			getKDF,
+			{instruction: insVerify, param2: 0x82, data: kdf.Derive(pin, paramOpenGPGVerifyPW2)},
+			getKDF,
+			{instruction: insResetRetryCounter, param2: 0x81, data: append(kdf.Derive(pin, paramOpenGPGResettingCode), kdf.Derive(pin, paramOpenGPGVerifyPW1)...)},
+		},
+		ResponseList: [][]byte{marshaled, nil, marshaled, nil},
+	}
+
+	if err := yk.VerifyPIN(PW2, pin); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := yk.UnblockPIN(pin, pin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPGYubiKey_AdminLess(t *testing.T) {
+	t.Parallel()
+
+	kdf := &KDF{Hash: crypto.SHA256, Iterations: 1024, SaltPW1: []byte("saltpw1!"), SaltPW3: []byte("saltpw3!")}
+
+	marshaled, err := kdf.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := []byte(defaultPW1)
+	getKDF := apdu{instruction: insGetDataA, param2: kdfTag}
+
+	yk := NewTestGpgYubikey(&GpgData{KDFSupported: true, MaximumSpecialDOsLength: 255}, false, nil)
+
+	// the user PIN is too short for PW3 unless the token is admin-less.
+	expectedError(t, yk.VerifyPIN(PW3, pin), ErrTooShort)
+
+	yk.Quirks().AdminLess = true
+
+	// PW3 is verified with the user PIN, hashed like PW1, which is also the default admin PIN.
+	verify := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW3, data: kdf.Derive(pin, paramOpenGPGVerifyPW1)}
+	tx := &TestSCTx{
+		APDUList: []apdu{
+			getKDF,
+			verify,
+			getKDF,
+			verify,
+			{instruction: insPutDataDA, param2: putNameTag, data: []byte("Flake<<Snow")},
+		},
+		ResponseList: [][]byte{marshaled, nil, marshaled, nil, nil},
+	}
+	yk.tx = tx
+
+	if err := yk.VerifyPIN(PW3, pin); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := yk.PutCardHolderName(nil, "Flake", "Snow"); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.CurrentAPDUIndex != len(tx.APDUList) {
+		t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tx.APDUList))
+	}
+}
@@ -0,0 +1,115 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPrivateDO is returned for a private use DO that doesn't exist or data that doesn't fit.
+var ErrPrivateDO = errors.New("invalid private use DO")
+
+// privateDOTag is the tag of private use DO 1, DOs 2 to 4 follow it.
+const privateDOTag = 0x0101
+
+// privateDOAccess returns the PW needed to read and write private use DO n, 0 if anyone can read it.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+func privateDOAccess(n int) (read, write PW, err error) {
+	switch n {
+	case 1:
+		return 0, PW2, nil
+	case 2:
+		return 0, PW3, nil
+	case 3:
+		return PW2, PW2, nil
+	case 4:
+		return PW3, PW3, nil
+	}
+
+	return 0, 0, fmt.Errorf("%w: %d, must be 1 to 4", ErrPrivateDO, n)
+}
+
+// GetPrivateDO reads private use DO n, 0101 to 0104.
+// DO 3 needs PW1 in mode 82 (VerifyPIN(PW2, ...)) and DO 4 needs PW3 presented first, DO 1 and 2 can always be read.
+func (yk *GPGYubiKey) GetPrivateDO(n int) ([]byte, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.GetPrivateDO\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if !yk.gpgData.PrivateUseDOsSupported {
+		return nil, ErrNotSupportedByCard
+	}
+
+	read, _, err := privateDOAccess(n)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := uint16(privateDOTag + n - 1)
+
+	data, err := yk.tx.Transmit(apdu{instruction: insGetDataA, param1: byte(tag >> 8), param2: byte(tag)})
+	if err != nil {
+		return nil, privateDOError(n, read, err)
+	}
+
+	return data, nil
+}
+
+// PutPrivateDO writes data to private use DO n, 0101 to 0104, it must fit MaximumSpecialDOsLength.
+// DO 1 and 3 need PW1 in mode 82 (VerifyPIN(PW2, ...)) presented first, DO 2 and 4 need PW3.
+func (yk *GPGYubiKey) PutPrivateDO(n int, data []byte) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.PutPrivateDO\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if !yk.gpgData.PrivateUseDOsSupported {
+		return ErrNotSupportedByCard
+	}
+
+	_, write, err := privateDOAccess(n)
+	if err != nil {
+		return err
+	}
+
+	if maxLen := int(yk.gpgData.MaximumSpecialDOsLength); maxLen > 0 && len(data) > maxLen {
+		return fmt.Errorf("%w: %d bytes, at most %d fit", ErrPrivateDO, len(data), maxLen)
+	}
+
+	if err := gpgPutData(yk.tx, uint16(privateDOTag+n-1), data); err != nil {
+		return privateDOError(n, write, err)
+	}
+
+	return nil
+}
+
+// privateDOError says which PW is missing when the card refuses access.
+func privateDOError(n int, pw PW, err error) error {
+	if errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		return fmt.Errorf("private DO %d needs %s: %w", n, pw, err)
+	}
+
+	return fmt.Errorf("private DO %d: %w", n, err)
+}
@@ -0,0 +1,100 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGPGYubiKey_PrivateDO(t *testing.T) {
+	t.Parallel()
+
+	blob := []byte("config")
+
+	tests := []struct {
+		name        string
+		unsupported bool
+		f           func(yk *GPGYubiKey) ([]byte, error)
+		apdus       []apdu
+		responses   [][]byte
+		transmitErr []error
+		expected    []byte
+		expectErr   error
+	}{
+		{
+			name:      "get",
+			f:         func(yk *GPGYubiKey) ([]byte, error) { return yk.GetPrivateDO(1) },
+			apdus:     []apdu{{instruction: insGetDataA, param1: 0x01, param2: 0x01}},
+			responses: [][]byte{blob},
+			expected:  blob,
+		},
+		{
+			name:        "get without pin",
+			f:           func(yk *GPGYubiKey) ([]byte, error) { return yk.GetPrivateDO(3) },
+			apdus:       []apdu{{instruction: insGetDataA, param1: 0x01, param2: 0x03}},
+			responses:   [][]byte{nil},
+			transmitErr: []error{&apduErr{0x69, 0x82}},
+			expectErr:   ErrSecurityStatusNotSatisfied,
+		},
+		{
+			name:      "put",
+			f:         func(yk *GPGYubiKey) ([]byte, error) { return nil, yk.PutPrivateDO(4, blob) },
+			apdus:     []apdu{{instruction: insPutDataDA, param1: 0x01, param2: 0x04, data: blob}},
+			responses: [][]byte{{}},
+		},
+		{
+			name:      "too long",
+			f:         func(yk *GPGYubiKey) ([]byte, error) { return nil, yk.PutPrivateDO(2, make([]byte, 256)) },
+			expectErr: ErrPrivateDO,
+		},
+		{
+			name:      "no such do",
+			f:         func(yk *GPGYubiKey) ([]byte, error) { return yk.GetPrivateDO(5) },
+			expectErr: ErrPrivateDO,
+		},
+		{
+			name:        "not supported",
+			unsupported: true,
+			f:           func(yk *GPGYubiKey) ([]byte, error) { return yk.GetPrivateDO(1) },
+			expectErr:   ErrNotSupportedByCard,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{PrivateUseDOsSupported: !tc.unsupported, MaximumSpecialDOsLength: 255}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: tc.responses, TransmitErr: tc.transmitErr}
+
+			got, err := tc.f(yk)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !bytes.Equal(got, tc.expected) {
+				t.Errorf("got %x expected %x", got, tc.expected)
+			}
+
+			if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != len(tc.apdus) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tc.apdus))
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProvisionSpec is returned when a ProvisionSpec can't be applied.
+var ErrProvisionSpec = errors.New("invalid provisioning spec")
+
+// ProvisionKey is how to get a key into a slot.
+type ProvisionKey struct {
+	// Generate generates the key on the card.
+	Generate bool
+	// Import is an *rsa.PrivateKey or *ecdsa.PrivateKey to import when Generate isn't set.
+	// The algorithm attributes of the slot must already match the key.
+	Import crypto.PrivateKey
+}
+
+// ProvisionSpec describes the complete setup of an OpenPGP card, empty fields are left alone.
+type ProvisionSpec struct {
+	// CurrentUserPIN and CurrentAdminPIN default to the factory PINs.
+	CurrentUserPIN  []byte
+	CurrentAdminPIN []byte
+
+	NewUserPIN  []byte
+	NewAdminPIN []byte
+	ResetCode   []byte
+
+	// KDF is written first, the PINs must be the factory PINs for that.
+	KDF *KDF
+
+	// Name is in the card format, Surname<<Given<Names.
+	Name     string
+	Language string
+	URL      string
+	Login    string
+
+	Keys map[KeyType]ProvisionKey
+	UIF  map[KeyType]UIF
+
+	// Created is the creation time written for the keys, it defaults to now.
+	Created time.Time
+}
+
+// ProvisionedKey is a key written during provisioning.
+type ProvisionedKey struct {
+	PublicKey   crypto.PublicKey
+	Fingerprint []byte
+	Created     time.Time
+	Origin      KeyOrigin
+}
+
+// ProvisionReport records what Provision did.
+type ProvisionReport struct {
+	// Steps are the completed steps in order.
+	Steps []string
+	Keys  map[KeyType]ProvisionedKey
+}
+
+func (r *ProvisionReport) done(step string) {
+	r.Steps = append(r.Steps, step)
+}
+
+// Provision runs the whole key ceremony on the card:
+// KDF, cardholder data, keys with their fingerprints and dates, UIF, resetting code and finally the new PINs.
+// The PINs are changed last so a failure part way can be retried with the same spec.
+// The report has the steps that completed, also when an error is returned.
+// The fingerprints and dates in GPGData are updated, open the card again to read the rest of the new state.
+func (yk *GPGYubiKey) Provision(spec ProvisionSpec) (*ProvisionReport, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.Provision\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	if f := yk.gpgData.Features(); spec.KDF != nil && !f.KDF || len(spec.UIF) > 0 && !f.UIF {
+		return nil, fmt.Errorf("%w: kdf or uif on %s", ErrUnsupportedByCardVersion, f)
+	}
+
+	report := &ProvisionReport{Keys: map[KeyType]ProvisionedKey{}}
+
+	userPIN := pinOrDefault(spec.CurrentUserPIN, defaultPW1)
+
+	kdf, err := gpgKDF(yk.tx, yk.gpgData)
+	if err != nil {
+		return report, err
+	}
+
+	if err := gpgLogin(yk.tx, yk.deriveAdminPIN(kdf, spec.CurrentAdminPIN), paramOpenGPGVerifyPW3); err != nil {
+		return report, fmt.Errorf("verify admin pin: %w", err)
+	}
+
+	report.done("verify admin pin")
+
+	if spec.KDF != nil {
+		data, err := spec.KDF.Marshal()
+		if err != nil {
+			return report, err
+		}
+
+		if err := gpgPutData(yk.tx, kdfTag, data); err != nil {
+			return report, fmt.Errorf("kdf: %w", err)
+		}
+
+		kdf = spec.KDF
+
+		// the card only accepts derived PINs from here on.
+		if err := gpgLogin(yk.tx, yk.deriveAdminPIN(kdf, spec.CurrentAdminPIN), paramOpenGPGVerifyPW3); err != nil {
+			return report, fmt.Errorf("verify admin pin after kdf: %w", err)
+		}
+
+		report.done("kdf")
+	}
+
+	for _, do := range []struct {
+		name  string
+		tag   uint16
+		value string
+	}{
+		{"name", putNameTag, spec.Name},
+		{"language", putLanguageTag, spec.Language},
+		{"url", putURLTag, spec.URL},
+		{"login", putLoginDataTag, spec.Login},
+	} {
+		if do.value == "" {
+			continue
+		}
+
+		if err := gpgPutData(yk.tx, do.tag, []byte(do.value)); err != nil {
+			return report, fmt.Errorf("%s: %w", do.name, err)
+		}
+
+		report.done(do.name)
+	}
+
+	created := spec.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		k, ok := spec.Keys[keyType]
+		if !ok {
+			continue
+		}
+
+		key, err := yk.provisionKey(keyType, k, created)
+		if err != nil {
+			return report, fmt.Errorf("%s key: %w", keyType, err)
+		}
+
+		report.Keys[keyType] = *key
+		report.done(fmt.Sprintf("%s key", keyType))
+	}
+
+	for keyType := SignatureKey; keyType <= KeyTypeLast; keyType++ {
+		uif, ok := spec.UIF[keyType]
+		if !ok {
+			continue
+		}
+
+		if err := gpgPutUIF(yk.tx, keyType, uif); err != nil {
+			return report, fmt.Errorf("%s uif: %w", keyType, err)
+		}
+
+		report.done(fmt.Sprintf("%s uif", keyType))
+	}
+
+	if len(spec.ResetCode) > 0 {
+		if err := gpgPutData(yk.tx, putResettingCodeTag, kdf.Derive(spec.ResetCode, paramOpenGPGResettingCode)); err != nil {
+			return report, fmt.Errorf("reset code: %w", err)
+		}
+
+		report.done("reset code")
+	}
+
+	if len(spec.NewUserPIN) > 0 {
+		err := gpgChangeReferenceData(yk.tx, paramOpenGPGVerifyPW1,
+			kdf.Derive(userPIN, paramOpenGPGVerifyPW1), kdf.Derive(spec.NewUserPIN, paramOpenGPGVerifyPW1))
+		if err != nil {
+			return report, fmt.Errorf("user pin: %w", err)
+		}
+
+		report.done("user pin")
+	}
+
+	if len(spec.NewAdminPIN) > 0 {
+		err := gpgChangeReferenceData(yk.tx, paramOpenGPGVerifyPW3,
+			yk.deriveAdminPIN(kdf, spec.CurrentAdminPIN), kdf.Derive(spec.NewAdminPIN, paramOpenGPGVerifyPW3))
+		if err != nil {
+			return report, fmt.Errorf("admin pin: %w", err)
+		}
+
+		report.done("admin pin")
+	}
+
+	return report, nil
+}
+
+// validate catches what the card would only reject after earlier steps already changed it.
+func (spec *ProvisionSpec) validate() error {
+	if len(spec.NewUserPIN) > 0 && len(spec.NewUserPIN) < minPW1Length {
+		return fmt.Errorf("%w: user pin: %w", ErrProvisionSpec, ErrTooShort)
+	}
+
+	if len(spec.NewAdminPIN) > 0 && len(spec.NewAdminPIN) < minPW3Length {
+		return fmt.Errorf("%w: admin pin: %w", ErrProvisionSpec, ErrTooShort)
+	}
+
+	// the resetting code has the same minimum as PW1.
+	if len(spec.ResetCode) > 0 && len(spec.ResetCode) < minPW1Length {
+		return fmt.Errorf("%w: reset code: %w", ErrProvisionSpec, ErrTooShort)
+	}
+
+	for keyType, k := range spec.Keys {
+		if keyType > KeyTypeLast {
+			return fmt.Errorf("%w: %w: %s", ErrProvisionSpec, ErrUnknownKeyType, keyType)
+		}
+
+		if k.Generate == (k.Import != nil) {
+			return fmt.Errorf("%w: %s key needs exactly one of Generate or Import", ErrProvisionSpec, keyType)
+		}
+	}
+
+	for keyType := range spec.UIF {
+		if keyType > KeyTypeLast {
+			return fmt.Errorf("%w: %w: uif %s", ErrProvisionSpec, ErrUnknownKeyType, keyType)
+		}
+	}
+
+	return nil
+}
+
+func pinOrDefault(pin []byte, def string) []byte {
+	if len(pin) == 0 {
+		return []byte(def)
+	}
+
+	return pin
+}
+
+// provisionKey generates or imports a key and writes its fingerprint and creation date.
+func (yk *GPGYubiKey) provisionKey(keyType KeyType, k ProvisionKey, created time.Time) (*ProvisionedKey, error) {
+	key := &ProvisionedKey{Created: created}
+
+	if k.Generate {
+		// KeyType and AsymmetricKeyType share the values of the three slots.
+		tlvData, err := ykOpenGPGReadKey(yk.tx, AsymmetricKeyType(keyType), true)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.PublicKey, err = parsePublicKey(tlvData); err != nil {
+			return nil, err
+		}
+
+		key.Origin = KeyGeneratedByCard
+	} else {
+		if err := gpgImportKey(yk.tx, keyType, k.Import); err != nil {
+			return nil, err
+		}
+
+		switch priv := k.Import.(type) {
+		case *rsa.PrivateKey:
+			key.PublicKey = &priv.PublicKey
+		case *ecdsa.PrivateKey:
+			key.PublicKey = &priv.PublicKey
+		}
+
+		key.Origin = KeyImportedToCard
+	}
+
+	var err error
+
+	if key.Fingerprint, err = OpenPGPFingerprint(keyType, key.PublicKey, created); err != nil {
+		return nil, err
+	}
+
+	if err := gpgPutKeyInformation(yk.tx, keyType, key.Fingerprint, created); err != nil {
+		return nil, err
+	}
+
+	yk.gpgData.setKeyInformation(keyType, key.Fingerprint, created, key.Origin)
+
+	return key, nil
+}
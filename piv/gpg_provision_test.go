@@ -0,0 +1,350 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test keys were made with gpg --faked-system-time 20240102T030405 --quick-gen-key.
+const (
+	testOpenPGPCreated    = 1704164645
+	testOpenPGPRSAModulus = "C2860DCC55B541B059E066FEF13CCF1DA2A487A9D1FD719463B15A357BAD2CA4E08347E88893B47A7700C99E59B617C30B8F893C3AF395F4D80AE35C6FC51E0C52786D684BEEDFF304658ED34DB1E5B41D53A19C2F928BCC0EE8397D4339E26DF6BC2015FC3355F7343CC428423EE78289C2033557828EF102E8D533D5F44B19"
+)
+
+func testECPublicKey(tb testing.TB, point string) *ecdsa.PublicKey {
+	tb.Helper()
+
+	// nolint:staticcheck // test keys are uncompressed points from gpg.
+	x, y := elliptic.Unmarshal(elliptic.P256(), mustHex(tb, point))
+	if x == nil {
+		tb.Fatalf("bad point %s", point)
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+}
+
+// testOpenPGPGeneratedRSA is the GENERATE ASYMMETRIC KEY PAIR response for the RSA test key.
+func testOpenPGPGeneratedRSA(tb testing.TB) []byte {
+	tb.Helper()
+
+	return bytes.Join([][]byte{mustHex(tb, "7f49 8188 81 8180"), mustHex(tb, testOpenPGPRSAModulus), mustHex(tb, "82 03 010001")}, nil)
+}
+
+func TestOpenPGPFingerprint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		keyType  KeyType
+		pub      crypto.PublicKey
+		expected string
+	}{
+		{
+			name:     "rsa",
+			keyType:  SignatureKey,
+			pub:      &rsa.PublicKey{N: new(big.Int).SetBytes(mustHex(t, testOpenPGPRSAModulus)), E: 65537},
+			expected: "256773822D6F98AC5BC1CE739841BDE415D30B84",
+		},
+		{
+			name:     "ecdsa",
+			keyType:  SignatureKey,
+			pub:      testECPublicKey(t, "04F1B3BDF9FA94783CD7536A4419EF0D5754685E7F6A7871E3399B2A48051A73E77334E471B88A8A4280ABBC2F01BD3373CC300156BCD632D52498BC733BD773B9"),
+			expected: "962413EAD28087127985570790FCDFBD7C77A923",
+		},
+		{
+			name:     "ecdh",
+			keyType:  DecryptionKey,
+			pub:      testECPublicKey(t, "04311B50D81C16C42FF11FCECCB7452ADF1AA1680FCDA22CA9900D383606D0BBB55CE7DFBE9BB38B4E7BD74E6D81E0D8F88D3D805DCC5B5B30C424B2720C31B712"),
+			expected: "ACE097B6ED451E435662179E6D25BDD98377D08B",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fp, err := OpenPGPFingerprint(tc.keyType, tc.pub, time.Unix(testOpenPGPCreated, 0))
+			if !expectedError(t, err, nil) {
+				return
+			}
+
+			if got := UpperCaseHexString(fp); got != tc.expected {
+				t.Errorf("got %s expected %s", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestKDF_Derive(t *testing.T) {
+	t.Parallel()
+
+	kdf := &KDF{
+		Hash:          crypto.SHA256,
+		Iterations:    defaultKDFIterations,
+		SaltPW1:       mustHex(t, "0001020304050607"),
+		SaltResetCode: mustHex(t, "0001020304050607"),
+		SaltPW3:       mustHex(t, "08090a0b0c0d0e0f"),
+	}
+
+	tests := []struct {
+		name     string
+		kdf      *KDF
+		pin      string
+		pwField  byte
+		expected string
+	}{
+		{"pw1", kdf, "123456", paramOpenGPGVerifyPW1, "c5d6f1ada01c4ed3dc90675496d419982045549d9177e68b9a28e2322d081033"},
+		{"pw3", kdf, "12345678", paramOpenGPGVerifyPW3, "3744a8849b4d89bcb87f9a540df89664dfced63d7ecc9a47f5085e885bd98a33"},
+		{"reset code", kdf, "123456", paramOpenGPGResettingCode, "c5d6f1ada01c4ed3dc90675496d419982045549d9177e68b9a28e2322d081033"},
+		{"count below data", &KDF{Hash: crypto.SHA256, Iterations: 10, SaltPW1: kdf.SaltPW1}, "123456", paramOpenGPGVerifyPW1, "6647d0b9d6a81a7c13d4adbab6a7b53c53fbb1cd468dbcf7fbcd2c6a4996ee20"},
+		{"no kdf", nil, "123456", paramOpenGPGVerifyPW1, "313233343536"},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tc.kdf.Derive([]byte(tc.pin), tc.pwField)
+			if !bytes.Equal(got, mustHex(t, tc.expected)) {
+				t.Errorf("got %x expected %s", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_DeriveAdminPIN(t *testing.T) {
+	t.Parallel()
+
+	kdf := &KDF{
+		Hash:       crypto.SHA256,
+		Iterations: defaultKDFIterations,
+		SaltPW1:    mustHex(t, "0001020304050607"),
+		SaltPW3:    mustHex(t, "08090a0b0c0d0e0f"),
+	}
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+
+	// the factory admin PIN, c.f. TestKDF_Derive.
+	if got := yk.deriveAdminPIN(kdf, nil); !bytes.Equal(got, mustHex(t, "3744a8849b4d89bcb87f9a540df89664dfced63d7ecc9a47f5085e885bd98a33")) {
+		t.Errorf("got %x for the factory admin pin", got)
+	}
+
+	// admin-less tokens take the factory user PIN, hashed like PW1.
+	yk.Quirks().AdminLess = true
+
+	if got := yk.deriveAdminPIN(kdf, nil); !bytes.Equal(got, mustHex(t, "c5d6f1ada01c4ed3dc90675496d419982045549d9177e68b9a28e2322d081033")) {
+		t.Errorf("got %x for the admin-less factory pin", got)
+	}
+
+	if got := yk.deriveAdminPIN(nil, []byte("654321")); string(got) != "654321" {
+		t.Errorf("got %q without kdf", got)
+	}
+}
+
+func TestParseKDF(t *testing.T) {
+	t.Parallel()
+
+	kdf := &KDF{
+		Hash:          crypto.SHA512,
+		Iterations:    defaultKDFIterations,
+		SaltPW1:       mustHex(t, "0001020304050607"),
+		SaltResetCode: mustHex(t, "1011121314151617"),
+		SaltPW3:       mustHex(t, "08090a0b0c0d0e0f"),
+	}
+
+	marshaled, err := kdf.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		expected  *KDF
+		expectErr error
+	}{
+		{name: "value", data: marshaled, expected: kdf},
+		{name: "with tag", data: marshalASN1(kdfTag, marshaled), expected: kdf},
+		{name: "off", data: mustHex(t, "810100")},
+		{name: "unknown algorithm", data: mustHex(t, "810101"), expectErr: ErrNoSuchAlgorithm},
+		{name: "unknown hash", data: mustHex(t, "810103 820102"), expectErr: ErrNoSuchAlgorithm},
+		{name: "no salts", data: mustHex(t, "810103 820108 830400780000"), expectErr: ErrNoSuchTag},
+		{name: "truncated", data: mustHex(t, "810103 8208"), expectErr: ErrTooShort},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseKDF(tc.data)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %+v expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_Provision(t *testing.T) {
+	t.Parallel()
+
+	kdf := &KDF{Hash: crypto.SHA256, Iterations: 1024, SaltPW1: []byte("saltpw1!"), SaltPW3: []byte("saltpw3!")}
+
+	kdfData, err := kdf.Marshal()
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	created := time.Unix(testOpenPGPCreated, 0)
+	date := []byte{0x65, 0x93, 0x7d, 0x25}
+
+	sigKey := &ecdsa.PrivateKey{
+		PublicKey: *testECPublicKey(t, "04F1B3BDF9FA94783CD7536A4419EF0D5754685E7F6A7871E3399B2A48051A73E77334E471B88A8A4280ABBC2F01BD3373CC300156BCD632D52498BC733BD773B9"),
+		D:         big.NewInt(0x1234),
+	}
+	sigImport := append(mustHex(t, "4d 2a b600 7f48 02 9220 5f48 20"), sigKey.D.FillBytes(make([]byte, 32))...)
+
+	modulus := mustHex(t, testOpenPGPRSAModulus)
+
+	pinAPDU := func(ins, pwField byte, data ...[]byte) apdu {
+		return apdu{instruction: ins, param2: pwField, data: bytes.Join(data, nil)}
+	}
+	putAPDU := func(tag uint16, data []byte) apdu {
+		return apdu{instruction: insPutDataDA, param1: byte(tag >> 8), param2: byte(tag), data: data}
+	}
+
+	pw1 := kdf.Derive([]byte(defaultPW1), paramOpenGPGVerifyPW1)
+	pw3 := kdf.Derive([]byte(defaultPW3), paramOpenGPGVerifyPW3)
+
+	apdus := []apdu{
+		pinAPDU(insVerify, paramOpenGPGVerifyPW3, []byte(defaultPW3)),
+		putAPDU(kdfTag, kdfData),
+		pinAPDU(insVerify, paramOpenGPGVerifyPW3, pw3),
+		putAPDU(putNameTag, []byte("Doe<<Jane")),
+		putAPDU(putLanguageTag, []byte("en")),
+		{instruction: insPutDataDB, param1: 0x3f, param2: 0xff, data: sigImport},
+		putAPDU(putFingerprintSigTag, mustHex(t, "962413EAD28087127985570790FCDFBD7C77A923")),
+		putAPDU(putGenerationDateTag, date),
+		{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricGenerate, data: crtConfidentiality[:]},
+		putAPDU(putFingerprintSigTag+1, mustHex(t, "256773822D6F98AC5BC1CE739841BDE415D30B84")),
+		putAPDU(putGenerationDateTag+1, date),
+		putAPDU(putSignatureUIFTag, []byte{byte(UIFOn), uifButtonFeature}),
+		putAPDU(putResettingCodeTag, kdf.Derive([]byte("87654321"), paramOpenGPGResettingCode)),
+		pinAPDU(insChangeReferenceData, paramOpenGPGVerifyPW1, pw1, kdf.Derive([]byte("654321"), paramOpenGPGVerifyPW1)),
+		pinAPDU(insChangeReferenceData, paramOpenGPGVerifyPW3, pw3, kdf.Derive([]byte("87654321"), paramOpenGPGVerifyPW3)),
+	}
+
+	responses := make([][]byte, len(apdus))
+	for i := range responses {
+		responses[i] = []byte{}
+	}
+
+	responses[8] = testOpenPGPGeneratedRSA(t)
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = &TestSCTx{APDUList: apdus, ResponseList: responses}
+
+	report, err := yk.Provision(ProvisionSpec{
+		NewUserPIN:  []byte("654321"),
+		NewAdminPIN: []byte("87654321"),
+		ResetCode:   []byte("87654321"),
+		KDF:         kdf,
+		Name:        "Doe<<Jane",
+		Language:    "en",
+		Keys: map[KeyType]ProvisionKey{
+			SignatureKey:  {Import: sigKey},
+			DecryptionKey: {Generate: true},
+		},
+		UIF:     map[KeyType]UIF{SignatureKey: UIFOn},
+		Created: created,
+	})
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	expectedSteps := []string{
+		"verify admin pin", "kdf", "name", "language", "Signature key", "Decryption key",
+		"Signature uif", "reset code", "user pin", "admin pin",
+	}
+
+	if len(report.Steps) != len(expectedSteps) {
+		t.Fatalf("got steps %v expected %v", report.Steps, expectedSteps)
+	}
+
+	for i, step := range expectedSteps {
+		if report.Steps[i] != step {
+			t.Errorf("step %d: got %s expected %s", i, report.Steps[i], step)
+		}
+	}
+
+	dec := report.Keys[DecryptionKey]
+	if pub, ok := dec.PublicKey.(*rsa.PublicKey); !ok || !bytes.Equal(pub.N.Bytes(), modulus) || dec.Origin != KeyGeneratedByCard {
+		t.Errorf("unexpected decryption key %+v", dec)
+	}
+
+	if sig := report.Keys[SignatureKey]; sig.Origin != KeyImportedToCard {
+		t.Errorf("unexpected signature key %+v", sig)
+	}
+}
+
+func TestProvisionSpec_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		spec ProvisionSpec
+	}{
+		{"short user pin", ProvisionSpec{NewUserPIN: []byte("123")}},
+		{"short admin pin", ProvisionSpec{NewAdminPIN: []byte("123456")}},
+		{"short reset code", ProvisionSpec{ResetCode: []byte("1234")}},
+		{"no key source", ProvisionSpec{Keys: map[KeyType]ProvisionKey{SignatureKey: {}}}},
+		{"attest key", ProvisionSpec{Keys: map[KeyType]ProvisionKey{AttestKey: {Generate: true}}}},
+		{"attest uif", ProvisionSpec{UIF: map[KeyType]UIF{AttestKey: UIFOn}}},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// nothing may be sent to the card for an invalid spec.
+			yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+			yk.tx = &TestSCTx{APDUList: []apdu{}, ResponseList: [][]byte{}}
+
+			_, err := yk.Provision(tc.spec)
+			expectedError(t, err, ErrProvisionSpec)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"fmt"
+)
+
+// ReadOpenPGPPublicKey reads the public key of slot from the card with GENERATE ASYMMETRIC KEY PAIR in read mode,
+// without a keyring. The algorithm attributes of the slot decide how the 7F49 template is parsed:
+// RSA keys are 81 modulus and 82 exponent and EC keys the 86 point.
+//
+// It returns the public key types of GenerateOpenPGPKey, an *rsa.PublicKey, an *ecdsa.PublicKey for NIST curve
+// and secp256k1 keys, an ed25519.PublicKey for Ed25519 keys or an *ecdh.PublicKey for X25519 keys.
+// Reading a public key needs no PIN.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 74
+// 7.2.14 GENERATE ASYMMETRIC KEY PAIR.
+func (yk *GPGYubiKey) ReadOpenPGPPublicKey(slot KeyType) (crypto.PublicKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ReadOpenPGPPublicKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	attributes, err := yk.gpgData.AlgorithmAttributes(slot)
+	if err != nil {
+		return nil, fmt.Errorf("%s key attributes: %w", slot, err)
+	}
+
+	// KeyType and AsymmetricKeyType share the values of the three slots.
+	tlvData, err := ykOpenGPGReadKey(yk.tx, AsymmetricKeyType(slot), false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case attributes.IsRSA():
+		return parsePublicKey(tlvData)
+	case attributes.Curve() != nil:
+		return parseECDSAPublicKey(tlvData, attributes.Curve())
+	case attributes.Algorithm == openPGPAlgorithmEdDSA && bytes.Equal(attributes.OID, oidEd25519):
+		return parseEd25519PublicKey(tlvData)
+	case attributes.Algorithm == openPGPAlgorithmECDH && bytes.Equal(attributes.OID, oidX25519):
+		return parseECDHPublicKey(tlvData, ecdh.X25519())
+	}
+
+	return nil, fmt.Errorf("%w: %s key is %s", ErrNoSuchAlgorithm, slot, attributes)
+}
@@ -0,0 +1,126 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_ReadOpenPGPPublicKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		slot piv.KeyType
+		alg  piv.Algorithm
+	}{
+		{name: "rsa signature", slot: piv.SignatureKey, alg: piv.AlgorithmRSA2048},
+		{name: "rsa decryption", slot: piv.DecryptionKey, alg: piv.AlgorithmRSA2048},
+		{name: "p256 authentication", slot: piv.AuthenticationKey, alg: piv.AlgorithmEC256},
+		{name: "p384 decryption", slot: piv.DecryptionKey, alg: piv.AlgorithmEC384},
+		{name: "ed25519 signature", slot: piv.SignatureKey, alg: piv.AlgorithmEd25519},
+		{name: "x25519 decryption", slot: piv.DecryptionKey, alg: piv.AlgorithmEd25519},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+			if err != nil {
+				t.Fatalf("open gpg: %v", err)
+			}
+
+			t.Cleanup(func() { yk.Close() })
+
+			if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+				t.Fatalf("verify admin pin: %v", err)
+			}
+
+			generated, err := yk.GenerateOpenPGPKey(tc.slot, tc.alg)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			pub, err := yk.ReadOpenPGPPublicKey(tc.slot)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+
+			if k, ok := pub.(interface{ Equal(x crypto.PublicKey) bool }); !ok || !k.Equal(generated) {
+				t.Errorf("read %T %v expected %T %v", pub, pub, generated, generated)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_VerifyFingerprint(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	keys := map[piv.KeyType]crypto.PublicKey{}
+
+	for _, slot := range []piv.KeyType{piv.SignatureKey, piv.DecryptionKey} {
+		if keys[slot], err = yk.GenerateOpenPGPKey(slot, piv.AlgorithmEC256); err != nil {
+			t.Fatalf("generate %s: %v", slot, err)
+		}
+	}
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := g.Date(piv.DecryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		pub       crypto.PublicKey
+		created   time.Time
+		expectErr error
+	}{
+		{name: "match", pub: keys[piv.DecryptionKey], created: created},
+		{name: "stale date", pub: keys[piv.DecryptionKey], created: created.Add(-time.Hour), expectErr: piv.ErrFingerprintMismatch},
+		{name: "other key", pub: keys[piv.SignatureKey], created: created, expectErr: piv.ErrFingerprintMismatch},
+	}
+
+	for _, tc := range tests {
+		if err := yk.VerifyFingerprint(piv.DecryptionKey, tc.pub, tc.created); !errors.Is(err, tc.expectErr) {
+			t.Errorf("%s: got %v expected %v", tc.name, err, tc.expectErr)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Manufacturer ids from the AID (bytes 9-10) of OpenPGP cards that need special handling.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 15
+// 4.2.1 Application Identifier (AID).
+const (
+	// ManufacturerZeitControl is used by the Nitrokey Pro.
+	ManufacturerZeitControl uint16 = 0x0005
+	ManufacturerYubico      uint16 = 0x0006
+	ManufacturerNitrokey    uint16 = 0x000F
+	// ManufacturerFSIJ is used by Gnuk, which the Nitrokey Start runs.
+	ManufacturerFSIJ     uint16 = 0xF517
+	ManufacturerCanoKeys uint16 = 0xF1D0
+)
+
+// manufacturerNames are the names gpg --card-status shows for the registered manufacturer ids.
+// https://git.gnupg.org/cgi-bin/gitweb.cgi?p=gnupg.git;a=blob;f=g10/card-util.c
+//
+// nolint:gochecknoglobals
+var manufacturerNames = map[uint16]string{
+	0x0001:                  "PPC Card Systems",
+	0x0002:                  "Prism",
+	0x0003:                  "OpenFortress",
+	0x0004:                  "Wewid",
+	ManufacturerZeitControl: "ZeitControl",
+	ManufacturerYubico:      "Yubico",
+	0x0007:                  "OpenKMS",
+	0x0008:                  "LogoEmail",
+	0x0009:                  "Fidesmo",
+	0x000A:                  "VivoKey",
+	0x000B:                  "Feitian Technologies",
+	0x000D:                  "Dangerous Things",
+	0x000E:                  "Excelsecu",
+	ManufacturerNitrokey:    "Nitrokey",
+	0x002A:                  "Magrathea",
+	0x0042:                  "GnuPG e.V.",
+	0x1337:                  "Warsaw Hackerspace",
+	0x2342:                  "warpzone",
+	0x4354:                  "Confidential Technologies",
+	0x5343:                  "SSE Carte à puce",
+	0x5443:                  "TIF-IT e.V.",
+	0x63AF:                  "Trustica",
+	0xBA53:                  "c-base e.V.",
+	0xBD0E:                  "Paranoidlabs",
+	ManufacturerCanoKeys:    "CanoKeys",
+	ManufacturerFSIJ:        "FSIJ",
+	0xF5EC:                  "F-Secure",
+	0x0000:                  "test card",
+	0xFFFF:                  "test card",
+}
+
+// ManufacturerName returns the name of a manufacturer id like gpg does,
+// unknown for unregistered ids and unmanaged S/N range for FFxx.
+func ManufacturerName(id uint16) string {
+	if name, ok := manufacturerNames[id]; ok {
+		return name
+	}
+
+	if id&0xff00 == 0xff00 {
+		return "unmanaged S/N range"
+	}
+
+	return "unknown"
+}
+
+const (
+	// defaultMaxAPDULength is the short APDU length this package uses unless the card says otherwise.
+	defaultMaxAPDULength = 0xff
+
+	// gnukKeyGenerationTimeout is how long Gnuk may take to generate an RSA key.
+	gnukKeyGenerationTimeout = 5 * time.Minute
+	// defaultKeyGenerationTimeout is enough for on card RSA 4096 generation on a YubiKey.
+	defaultKeyGenerationTimeout = 30 * time.Second
+)
+
+// Gnuk stores the cardholder certificate in an EF, it's read with GET DATA 7F21 but written with UPDATE BINARY.
+// Writing at offset 0 erases the EF first.
+// https://salsa.debian.org/gnuk-team/gnuk/gnuk/-/blob/master/src/openpgp.c
+const (
+	insUpdateBinary       = 0xd6
+	gnukCertificateFileID = 0x05
+	// updateBinaryChunkLen keeps each UPDATE BINARY a short APDU.
+	updateBinaryChunkLen = 0x80
+)
+
+// ErrNotSupportedByCard is returned for operations the card is known not to implement.
+var ErrNotSupportedByCard = errors.New("operation not supported by this card")
+
+// CardQuirks describes where an OpenPGP card differs from the YubiKey behaviour this package assumes.
+type CardQuirks struct {
+	// Name is the product family the quirks were chosen for.
+	Name string
+	// NoAppletVersion is set if the card doesn't implement the Yubico applet version instruction (F1).
+	NoAppletVersion bool
+	// NoSecuritySupportTemplate is set if GET DATA for 7A may fail, the card is opened without it.
+	NoSecuritySupportTemplate bool
+	// NoAttestation is set if the card can't attest keys.
+	NoAttestation bool
+	// NoOTPApplet is set if the card has no Yubico OTP applet, so the serial number must come from PIV.
+	NoOTPApplet bool
+	// NoSelectData is set if the card has no SELECT DATA even though its version has it.
+	// Such cards have a single cardholder certificate, for the authentication key.
+	NoSelectData bool
+	// CertificateUpdateBinary is set if the cardholder certificate is written with UPDATE BINARY instead of PUT DATA.
+	CertificateUpdateBinary bool
+	// AdminLess is set for Gnuk tokens whose admin PIN was never set, PW1 is then also used for admin operations
+	// and AuthAdminPIN takes the user PIN.
+	// It can't be detected from the card, so callers set it when they know the token is in admin-less mode.
+	AdminLess bool
+	// MaxCommandLength and MaxResponseLength are the largest APDU data sizes, from the extended capabilities of 2.x cards.
+	MaxCommandLength  int
+	MaxResponseLength int
+	// KeyGenerationTimeout is how long GenerateOpenPGPKeyContext waits for on card key generation.
+	KeyGenerationTimeout time.Duration
+}
+
+// quirksFor returns the quirks for a card from its manufacturer and reader name.
+func quirksFor(manufacturer uint16, reader string) CardQuirks {
+	q := CardQuirks{
+		Name:                 "YubiKey",
+		MaxCommandLength:     defaultMaxAPDULength,
+		MaxResponseLength:    defaultMaxAPDULength,
+		KeyGenerationTimeout: defaultKeyGenerationTimeout,
+	}
+
+	lowerReader := strings.ToLower(reader)
+
+	switch {
+	case manufacturer == ManufacturerYubico:
+		return q
+	case manufacturer == ManufacturerCanoKeys || isCanoKeyReader(reader):
+		q.Name = "CanoKey"
+		q.NoOTPApplet = true
+	case manufacturer == ManufacturerFSIJ || strings.Contains(lowerReader, "gnuk") || strings.Contains(lowerReader, "nitrokey start"):
+		q.Name = "Gnuk"
+		q.KeyGenerationTimeout = gnukKeyGenerationTimeout
+		q.NoSelectData = true
+		q.CertificateUpdateBinary = true
+	case manufacturer == ManufacturerZeitControl || strings.Contains(lowerReader, "nitrokey pro"):
+		q.Name = "Nitrokey Pro"
+	case manufacturer == ManufacturerNitrokey || strings.Contains(lowerReader, "nitrokey"):
+		q.Name = "Nitrokey"
+	default:
+		q.Name = "OpenPGP card"
+	}
+
+	q.NoAppletVersion = true
+	q.NoAttestation = true
+	q.NoSecuritySupportTemplate = true
+
+	return q
+}
+
+// pivQuirksFor returns the quirks for the PIV applet of the card in reader.
+// PIV has no manufacturer id, so anything that isn't detected from the reader name is treated as a YubiKey.
+func pivQuirksFor(reader string) CardQuirks {
+	if isCanoKeyReader(reader) {
+		return quirksFor(ManufacturerCanoKeys, reader)
+	}
+
+	return quirksFor(ManufacturerYubico, reader)
+}
+
+// isCanoKeyReader reports whether reader is a CanoKey, for example "CanoKeys CanoKey [OpenPGP PIV OATH] 00 00".
+func isCanoKeyReader(reader string) bool {
+	return strings.Contains(strings.ToLower(reader), "canokey")
+}
+
+// applyQuirks detects the card and fixes up fields whose meaning differs on other implementations.
+// It must run after loadExtendedData.
+func (g *GpgData) applyQuirks(aid []byte) {
+	g.ManufacturerID = binary.BigEndian.Uint16(aid[8:10])
+	g.Quirks = quirksFor(g.ManufacturerID, g.Reader)
+
+	// bits 1 and 2 of the first extended capabilities byte are RFU before 3.0.
+	f := g.Features()
+	g.KDFSupported = g.KDFSupported && f.KDF
+	g.SupportsPSODecryptionEncryptionWithAES = g.SupportsPSODecryptionEncryptionWithAES && f.AESDecipher
+
+	if aid[6] >= 3 {
+		return
+	}
+
+	// 2.x cards don't have the 3.x special DO length, PIN block 2 and MSE flags in bytes 7-10 of the
+	// extended capabilities, they have the max command and response lengths instead.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-2.0.pdf Page 22
+	// 4.4.3.7 Extended Capabilities.
+	tag, err := g.GetTag(extendedCapabilitiesTag, 10)
+	if err != nil {
+		return
+	}
+
+	g.Quirks.MaxCommandLength = int(binary.BigEndian.Uint16(tag[6:8]))
+	g.Quirks.MaxResponseLength = int(binary.BigEndian.Uint16(tag[8:10]))
+	g.MaximumSpecialDOsLength = 0
+	g.PinBlock2Supported = false
+	g.MSECommandSupported = false
+}
+
+// Quirks returns the detected card quirks.
+// The returned pointer may be used to adjust quirks which can't be detected, such as AdminLess.
+func (yk *GPGYubiKey) Quirks() *CardQuirks {
+	if yk == nil || yk.gpgData == nil {
+		return nil
+	}
+
+	return &yk.gpgData.Quirks
+}
+
+// Quirks returns the quirks of the card, detected from the reader name.
+func (yk *YubiKey) Quirks() CardQuirks {
+	return yk.quirks
+}
+
+// gpgUpdateBinary writes data to the EF with the short identifier fileID.
+// The first command selects the EF in P1, the rest give the offset in P1-P2.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 48
+// 7.1 Usage of ISO Standard Commands.
+func gpgUpdateBinary(tx SCTx, fileID byte, data []byte) error {
+	for offset := 0; offset == 0 || offset < len(data); offset += updateBinaryChunkLen {
+		cmd := apdu{
+			instruction: insUpdateBinary,
+			param1:      byte(offset >> 8),
+			param2:      byte(offset),
+			data:        data[offset:min(len(data), offset+updateBinaryChunkLen)],
+		}
+
+		if offset == 0 {
+			cmd.param1 = 0x80 | fileID
+		}
+
+		if _, err := tx.Transmit(cmd); err != nil {
+			return fmt.Errorf("update binary at offset [%d]: %w", offset, err)
+		}
+	}
+
+	return nil
+}
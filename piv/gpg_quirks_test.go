@@ -0,0 +1,276 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGpgData_Quirks(t *testing.T) {
+	t.Parallel()
+
+	aid := func(major, mfgHigh, mfgLow byte) []byte {
+		return []byte{0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, major, 0x00, mfgHigh, mfgLow, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}
+	}
+
+	// 2.x: max command length 0x0800, max response length 0x0400.
+	// 3.x: max special DOs length 0x00ff, PIN block 2 and MSE supported.
+	extendedCapabilities := []byte{0x70, 0x00, 0x00, 0x80, 0x08, 0x00, 0x08, 0x00, 0x04, 0x00}
+	extendedCapabilities3 := []byte{0x70, 0x00, 0x00, 0x80, 0x08, 0x00, 0x00, 0xff, 0x01, 0x01}
+
+	cases := []struct {
+		name            string
+		reader          string
+		aid             []byte
+		capabilities    []byte
+		expectedName    string
+		manufacturer    uint16
+		manufacturerStr string
+		noSelectData    bool
+		noAppletVersion bool
+		maxCommand      int
+		maxResponse     int
+		mse             bool
+	}{
+		{
+			name:            "YubiKey",
+			reader:          "Yubico YubiKey OTP+FIDO+CCID",
+			aid:             aid(3, 0x00, 0x06),
+			capabilities:    extendedCapabilities3,
+			expectedName:    "YubiKey",
+			manufacturer:    ManufacturerYubico,
+			manufacturerStr: "0006 (Yubico)",
+			maxCommand:      defaultMaxAPDULength,
+			maxResponse:     defaultMaxAPDULength,
+			mse:             true,
+		},
+		{
+			name:            "Gnuk",
+			reader:          "Free Software Initiative of Japan Gnuk",
+			aid:             aid(2, 0xf5, 0x17),
+			capabilities:    extendedCapabilities,
+			expectedName:    "Gnuk",
+			manufacturer:    ManufacturerFSIJ,
+			manufacturerStr: "F517 (FSIJ)",
+			noSelectData:    true,
+			noAppletVersion: true,
+			maxCommand:      0x0800,
+			maxResponse:     0x0400,
+		},
+		{
+			name:            "Nitrokey Pro",
+			reader:          "Nitrokey Nitrokey Pro",
+			aid:             aid(3, 0x00, 0x05),
+			capabilities:    extendedCapabilities3,
+			expectedName:    "Nitrokey Pro",
+			manufacturer:    ManufacturerZeitControl,
+			manufacturerStr: "0005 (ZeitControl)",
+			noAppletVersion: true,
+			maxCommand:      defaultMaxAPDULength,
+			maxResponse:     defaultMaxAPDULength,
+			mse:             true,
+		},
+		{
+			name:            "Nitrokey Start by reader",
+			reader:          "Nitrokey Nitrokey Start",
+			aid:             aid(2, 0xff, 0xfe),
+			expectedName:    "Gnuk",
+			manufacturer:    0xfffe,
+			manufacturerStr: "FFFE (unmanaged S/N range)",
+			noSelectData:    true,
+			noAppletVersion: true,
+			maxCommand:      defaultMaxAPDULength,
+			maxResponse:     defaultMaxAPDULength,
+		},
+		{
+			name:            "CanoKey",
+			reader:          "CanoKeys CanoKey [OpenPGP PIV OATH] 00 00",
+			aid:             aid(3, 0xf1, 0xd0),
+			capabilities:    extendedCapabilities3,
+			expectedName:    "CanoKey",
+			manufacturer:    ManufacturerCanoKeys,
+			manufacturerStr: "F1D0 (CanoKeys)",
+			noAppletVersion: true,
+			maxCommand:      defaultMaxAPDULength,
+			maxResponse:     defaultMaxAPDULength,
+			mse:             true,
+		},
+		{
+			name:            "unknown",
+			reader:          "Some Reader",
+			aid:             aid(3, 0x12, 0x34),
+			expectedName:    "OpenPGP card",
+			manufacturer:    0x1234,
+			manufacturerStr: "1234 (unknown)",
+			noAppletVersion: true,
+			maxCommand:      defaultMaxAPDULength,
+			maxResponse:     defaultMaxAPDULength,
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := &GpgData{
+				Reader:    tc.reader,
+				tlvValues: bertlv.TLVData{"6E.4F": tc.aid},
+			}
+
+			if tc.capabilities != nil {
+				g.tlvValues[extendedCapabilitiesTag] = tc.capabilities
+			}
+
+			if !expectedError(t, g.update(), nil) {
+				t.FailNow()
+			}
+
+			if g.Quirks.Name != tc.expectedName {
+				t.Errorf("got name %s expected %s", g.Quirks.Name, tc.expectedName)
+			}
+
+			if g.ManufacturerID != tc.manufacturer {
+				t.Errorf("got manufacturer %04x expected %04x", g.ManufacturerID, tc.manufacturer)
+			}
+
+			if g.Manufacturer != tc.manufacturerStr {
+				t.Errorf("got manufacturer %q expected %q", g.Manufacturer, tc.manufacturerStr)
+			}
+
+			if g.Quirks.NoSelectData != tc.noSelectData || g.Quirks.CertificateUpdateBinary != tc.noSelectData {
+				t.Errorf("got NoSelectData %t CertificateUpdateBinary %t expected %t",
+					g.Quirks.NoSelectData, g.Quirks.CertificateUpdateBinary, tc.noSelectData)
+			}
+
+			if g.Quirks.NoAppletVersion != tc.noAppletVersion {
+				t.Errorf("got NoAppletVersion %t expected %t", g.Quirks.NoAppletVersion, tc.noAppletVersion)
+			}
+
+			if g.Quirks.MaxCommandLength != tc.maxCommand || g.Quirks.MaxResponseLength != tc.maxResponse {
+				t.Errorf("got max lengths %d/%d expected %d/%d",
+					g.Quirks.MaxCommandLength, g.Quirks.MaxResponseLength, tc.maxCommand, tc.maxResponse)
+			}
+
+			if g.MSECommandSupported != tc.mse {
+				t.Errorf("got MSECommandSupported %t expected %t", g.MSECommandSupported, tc.mse)
+			}
+		})
+	}
+}
+
+func TestOpenGPGData_NoSecuritySupportTemplate(t *testing.T) {
+	t.Parallel()
+
+	aid := func(mfgHigh, mfgLow byte) []byte {
+		return []byte{0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x02, 0x00, mfgHigh, mfgLow, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}
+	}
+
+	cases := []struct {
+		name      string
+		reader    string
+		aid       []byte
+		expectErr error
+	}{
+		{name: "Gnuk", reader: "Free Software Initiative of Japan Gnuk", aid: aid(0xf5, 0x17)},
+		{name: "YubiKey", reader: "Yubico YubiKey OTP+FIDO+CCID", aid: aid(0x00, 0x06), expectErr: ErrNotFound},
+	}
+
+	for _, tc := range cases {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			application := append([]byte{0x6e, byte(len(tc.aid) + 2), 0x4f, byte(len(tc.aid))}, tc.aid...)
+
+			// the script ends before GET DATA 7A, which fails with ErrNotFound.
+			tx := &TestSCTx{
+				APDUList: []apdu{
+					{instruction: insGetDataA, param2: cardHolderDataTag},
+					{instruction: insGetDataA, param2: applicationRelatedDataTag},
+				},
+				ResponseList: [][]byte{{0x65, 0x06, 0x5b, 0x04, 'S', 'n', 'o', 'w'}, application},
+			}
+
+			g, err := ykOpenGPGData(tx, tc.reader, ParseLenient)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if !g.Quirks.NoSecuritySupportTemplate || g.Quirks.Name != tc.name {
+				t.Errorf("got quirks %+v", g.Quirks)
+			}
+		})
+	}
+}
+
+func TestGPGYubiKey_AuthAdminPIN_AdminLess(t *testing.T) {
+	t.Parallel()
+
+	pin := []byte("123456")
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = &TestSCTx{
+		APDUList:     []apdu{{instruction: insVerify, param2: paramOpenGPGVerifyPW3, data: pin}},
+		ResponseList: [][]byte{nil},
+	}
+
+	// the user PIN is too short for PW3 unless the token is admin-less.
+	expectedError(t, yk.AuthAdminPIN(pin), ErrTooShort)
+
+	yk.Quirks().AdminLess = true
+
+	expectedError(t, yk.AuthAdminPIN(pin), nil)
+
+	if tx := yk.tx.(*TestSCTx); tx.CurrentAPDUIndex != 1 {
+		t.Errorf("sent %d apdus expected 1", tx.CurrentAPDUIndex)
+	}
+}
+
+func TestPIVQuirks_CanoKey(t *testing.T) {
+	t.Parallel()
+
+	if q := pivQuirksFor("Yubico YubiKey OTP+FIDO+CCID 00 00"); q.NoAttestation || q.NoOTPApplet {
+		t.Errorf("unexpected YubiKey quirks %+v", q)
+	}
+
+	q := pivQuirksFor("CanoKeys CanoKey [OpenPGP PIV OATH] 00 00")
+	if !q.NoAttestation || !q.NoOTPApplet {
+		t.Errorf("unexpected CanoKey quirks %+v", q)
+	}
+
+	// CanoKey has no OTP applet, the serial must come from PIV even for old versions.
+	tx := &TestSCTx{
+		APDUList:     []apdu{{instruction: insGetSerial}},
+		ResponseList: [][]byte{{0x00, 0x00, 0x30, 0x39}},
+	}
+
+	serial, err := ykSerial(tx, &version{4, 0, 0}, q)
+	expectedError(t, err, nil)
+
+	if serial != 12345 {
+		t.Errorf("got serial %d", serial)
+	}
+
+	yk := &YubiKey{quirks: q}
+
+	_, err = yk.Attest(SlotAuthentication)
+	expectedError(t, err, ErrNotSupportedByCard)
+}
@@ -0,0 +1,119 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrResetNotConfirmed is returned by ResetOpenPGP when the reset wasn't confirmed.
+var ErrResetNotConfirmed = errors.New("reset not confirmed")
+
+const (
+	// insTerminateDF puts the OpenPGP application in the termination state, it's only allowed when
+	// PW3 is blocked, or PW1 and PW3 are blocked on cards that support it.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf
+	// 7.2.16 TERMINATE DF.
+	insTerminateDF = 0xe6
+
+	// insActivateFile resets the OpenPGP application in the termination state to its factory settings.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf
+	// 7.2.17 ACTIVATE FILE.
+	insActivateFile = 0x44
+)
+
+// invalidResetPIN is presented until PW1 and PW3 are blocked, it's long enough for PW3.
+var invalidResetPIN = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+
+// ResetOpenPGPOptions guard ResetOpenPGP.
+type ResetOpenPGPOptions struct {
+	// Confirm must be set, the reset can't be undone.
+	Confirm bool
+}
+
+// ResetOpenPGP resets the OpenPGP applet to its factory settings like ykman openpgp reset,
+// deleting the keys, certificates and cardholder data and setting the default PINs.
+// It doesn't affect the other applets, such as PIV.
+//
+// PW1 and PW3 are blocked with wrong PINs first, so the PINs aren't needed. GpgData is read again
+// afterwards.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf
+// 7.2.16 TERMINATE DF.
+// 7.2.17 ACTIVATE FILE.
+func (yk *GPGYubiKey) ResetOpenPGP(opts ResetOpenPGPOptions) error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.ResetOpenPGP\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return ErrNotFound
+	}
+
+	if !opts.Confirm {
+		return ErrResetNotConfirmed
+	}
+
+	if err := gpgReset(yk.tx); err != nil {
+		return err
+	}
+
+	gpgData, err := ykOpenGPGData(yk.tx, yk.gpgData.Reader, yk.gpgData.parseMode)
+	if err == nil {
+		err = gpgData.readLoginData(yk.tx)
+	}
+
+	if err != nil {
+		return fmt.Errorf("reading reset openpgp applet: %w", err)
+	}
+
+	yk.gpgData = gpgData
+	// the applet is selected again with the default keys.
+	yk.mse = nil
+
+	return nil
+}
+
+func gpgReset(tx SCTx) error {
+	data, err := getPinRetries(tx)
+	if err != nil {
+		return fmt.Errorf("pw status: %w", err)
+	}
+
+	for _, pwField := range []byte{paramOpenGPGVerifyPW1, paramOpenGPGVerifyPW3} {
+		retries, err := parsePinRetries(data, pwField)
+		if err != nil {
+			return fmt.Errorf("pw status: %w", err)
+		}
+
+		for ; retries > 0; retries-- {
+			cmd := apdu{instruction: insVerify, param2: pwField, data: invalidResetPIN}
+			if _, err := tx.Transmit(cmd); err == nil {
+				return fmt.Errorf("blocking %s: expected error with invalid pin", PW(pwField))
+			}
+		}
+	}
+
+	if _, err := tx.Transmit(apdu{instruction: insTerminateDF}); err != nil {
+		return fmt.Errorf("terminate df: %w", err)
+	}
+
+	if _, err := tx.Transmit(apdu{instruction: insActivateFile}); err != nil {
+		return fmt.Errorf("activate file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"testing"
+)
+
+func TestGPGYubiKey_ResetOpenPGP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		confirm   bool
+		pwStatus  []byte
+		expectErr error
+	}{
+		{name: "not confirmed", expectErr: ErrResetNotConfirmed},
+		{name: "reset", confirm: true, pwStatus: mustHex(t, "00 7f 7f 7f 02 00 01")},
+		{name: "already blocked", confirm: true, pwStatus: mustHex(t, "00 7f 7f 7f 00 00 00")},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tx := &TestSCTx{}
+
+			if tc.pwStatus != nil {
+				tx.APDUList = append(tx.APDUList, apdu{instruction: insGetDataA, param2: paramOpenGPGGetRetries})
+				tx.ResponseList = append(tx.ResponseList, tc.pwStatus)
+				tx.TransmitErr = append(tx.TransmitErr, nil)
+
+				for _, r := range []struct {
+					pwField byte
+					retries byte
+				}{
+					{paramOpenGPGVerifyPW1, tc.pwStatus[4]},
+					{paramOpenGPGVerifyPW3, tc.pwStatus[6]},
+				} {
+					for i := r.retries; i > 0; i-- {
+						tx.APDUList = append(tx.APDUList, apdu{instruction: insVerify, param2: r.pwField, data: invalidResetPIN})
+						tx.ResponseList = append(tx.ResponseList, nil)
+						tx.TransmitErr = append(tx.TransmitErr, &apduErr{0x63, 0xc0 | (i - 1)})
+					}
+				}
+
+				tx.APDUList = append(tx.APDUList, apdu{instruction: insTerminateDF}, apdu{instruction: insActivateFile})
+				tx.ResponseList = append(tx.ResponseList, nil, nil)
+				tx.TransmitErr = append(tx.TransmitErr, nil, nil)
+
+				// GpgData is read again, without selecting the applet.
+				reopen := basicOpenGPGTx()
+				tx.APDUList = append(tx.APDUList, reopen.APDUList[1:]...)
+				tx.ResponseList = append(tx.ResponseList, reopen.ResponseList[1:]...)
+			}
+
+			yk := NewTestGpgYubikey(&GpgData{Serial: "stale"}, false, nil)
+			yk.tx = tx
+
+			err := yk.ResetOpenPGP(ResetOpenPGPOptions{Confirm: tc.confirm})
+			if !expectedError(t, err, tc.expectErr) {
+				return
+			}
+
+			if tx.CurrentAPDUIndex != len(tx.APDUList) {
+				t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tx.APDUList))
+			}
+
+			if tc.expectErr == nil && yk.gpgData.Serial == "stale" {
+				t.Error("GpgData wasn't read again")
+			}
+		})
+	}
+}
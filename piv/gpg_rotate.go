@@ -0,0 +1,145 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// RotateOptions selects what RotateKeys replaces.
+type RotateOptions struct {
+	// AdminPIN defaults to the factory PIN.
+	AdminPIN []byte
+	// Keys defaults to all three keys.
+	Keys []KeyType
+	// Attest has the card attest each new key, this replaces the cardholder certificate of the key.
+	Attest bool
+	// Certificate returns the DER certificate to store for a new key.
+	// Without it the old certificate is deleted, unless it was replaced by the attestation.
+	Certificate func(keyType KeyType, pub crypto.PublicKey) ([]byte, error)
+	// Created is the creation time written for the keys, it defaults to now.
+	Created time.Time
+}
+
+// RotatedKey has what's needed to revoke the old key and publish the new one.
+type RotatedKey struct {
+	KeyType KeyType
+	// OldFingerprint is nil if the slot was empty.
+	OldFingerprint []byte
+	NewFingerprint []byte
+	PublicKey      crypto.PublicKey
+	Created        time.Time
+	// Attestation is the DER attestation certificate when RotateOptions.Attest is set.
+	Attestation []byte
+}
+
+// RotateKeys generates new keys on the card and replaces their fingerprints, dates and certificates.
+// Keys rotated before an error are returned with it, they are already replaced on the card.
+func (yk *GPGYubiKey) RotateKeys(opts RotateOptions) ([]RotatedKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.RotateKeys\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	keyTypes := opts.Keys
+	if len(keyTypes) == 0 {
+		keyTypes = []KeyType{SignatureKey, DecryptionKey, AuthenticationKey}
+	}
+
+	for _, keyType := range keyTypes {
+		if keyType > KeyTypeLast {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+		}
+	}
+
+	// check before any key is replaced.
+	if opts.Attest && yk.gpgData.Quirks.NoAttestation {
+		return nil, fmt.Errorf("%s: %w", yk.gpgData.Quirks.Name, ErrNotSupportedByCard)
+	}
+
+	if err := yk.adminLogin(opts.AdminPIN); err != nil {
+		return nil, fmt.Errorf("verify admin pin: %w", err)
+	}
+
+	created := opts.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	rotated := make([]RotatedKey, 0, len(keyTypes))
+
+	for _, keyType := range keyTypes {
+		r := RotatedKey{KeyType: keyType, OldFingerprint: yk.storedFingerprint(keyType)}
+
+		key, err := yk.provisionKey(keyType, ProvisionKey{Generate: true}, created)
+		if err != nil {
+			return rotated, fmt.Errorf("%s key: %w", keyType, err)
+		}
+
+		r.NewFingerprint = key.Fingerprint
+		r.PublicKey = key.PublicKey
+		r.Created = key.Created
+
+		if opts.Attest {
+			if r.Attestation, err = gpgAttest(yk.tx, keyType, yk.gpgData); err != nil {
+				return rotated, err
+			}
+		}
+
+		switch {
+		case opts.Certificate != nil:
+			der, err := opts.Certificate(keyType, key.PublicKey)
+			if err != nil {
+				return rotated, fmt.Errorf("%s certificate: %w", keyType, err)
+			}
+
+			if err := gpgPutCardholderCertificate(yk.tx, keyType, der, yk.gpgData); err != nil {
+				return rotated, err
+			}
+		case !opts.Attest && gpgCheckCardholderCertificate(yk.gpgData, keyType) == nil:
+			// the old certificate is for the old key.
+			if err := gpgPutCardholderCertificate(yk.tx, keyType, nil, yk.gpgData); err != nil {
+				return rotated, err
+			}
+		}
+
+		rotated = append(rotated, r)
+	}
+
+	return rotated, nil
+}
+
+// storedFingerprint returns the fingerprint the card has for keyType, nil if the slot is empty.
+func (yk *GPGYubiKey) storedFingerprint(keyType KeyType) []byte {
+	s, err := yk.gpgData.Fingerprint(keyType)
+	if err != nil {
+		return nil
+	}
+
+	fp, err := hex.DecodeString(s)
+	if err != nil || bytes.Equal(fp, make([]byte, keyFingerprintLen)) {
+		return nil
+	}
+
+	return fp
+}
@@ -0,0 +1,148 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGPGYubiKey_RotateKeys(t *testing.T) {
+	t.Parallel()
+
+	oldFingerprint := bytes.Repeat([]byte{0x11}, keyFingerprintLen)
+	newFingerprint := mustHex(t, "256773822D6F98AC5BC1CE739841BDE415D30B84")
+	date := []byte{0x65, 0x93, 0x7d, 0x25}
+	cert := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	attestation := []byte{0x30, 0x03, 0x02, 0x01, 0x02}
+
+	verifyAPDU := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW3, data: []byte(defaultPW3)}
+	generateAPDU := apdu{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricGenerate, data: crtDigitalSignature[:]}
+	fingerprintAPDU := apdu{instruction: insPutDataDA, param2: putFingerprintSigTag, data: newFingerprint}
+	dateAPDU := apdu{instruction: insPutDataDA, param2: putGenerationDateTag, data: date}
+	putCertAPDU := func(der []byte) apdu {
+		return apdu{instruction: insPutDataDA, param1: 0x7f, param2: 0x21, data: der}
+	}
+	selectCertAPDU := func(prefix bool) apdu {
+		data := []byte{0x60, 0x04, 0x5c, 0x02, 0x7f, 0x21}
+		if prefix {
+			data = append([]byte{0x06}, data...)
+		}
+
+		return apdu{instruction: insSelectData, param1: 2, param2: 0x04, data: data}
+	}
+
+	tests := []struct {
+		name          string
+		appletVersion string
+		quirks        CardQuirks
+		opts          RotateOptions
+		apdus         []apdu
+		responses     [][]byte
+		expectErr     error
+		attestation   []byte
+	}{
+		{
+			name:          "certificate is deleted",
+			appletVersion: "5.7.1",
+			opts:          RotateOptions{Keys: []KeyType{SignatureKey}},
+			apdus:         []apdu{verifyAPDU, generateAPDU, fingerprintAPDU, dateAPDU, selectCertAPDU(false), putCertAPDU(nil)},
+			responses:     [][]byte{{}, testOpenPGPGeneratedRSA(t), {}, {}, {}, {}},
+		},
+		{
+			name:          "attested and certificate stored on old firmware",
+			appletVersion: "5.4.3",
+			opts: RotateOptions{
+				Keys:   []KeyType{SignatureKey},
+				Attest: true,
+				Certificate: func(keyType KeyType, pub crypto.PublicKey) ([]byte, error) {
+					return cert, nil
+				},
+			},
+			apdus: []apdu{
+				verifyAPDU, generateAPDU, fingerprintAPDU, dateAPDU,
+				{instruction: insYubicoAttest, param1: 0x01},
+				selectCertAPDU(true),
+				{instruction: insGetDataA, param1: 0x7f, param2: 0x21},
+				selectCertAPDU(true),
+				putCertAPDU(cert),
+			},
+			responses:   [][]byte{{}, testOpenPGPGeneratedRSA(t), {}, {}, {}, {}, attestation, {}, {}},
+			attestation: attestation,
+		},
+		{
+			name:      "attestation not supported",
+			quirks:    CardQuirks{Name: "Gnuk", NoAttestation: true},
+			opts:      RotateOptions{Attest: true},
+			apdus:     []apdu{},
+			responses: [][]byte{},
+			expectErr: ErrNotSupportedByCard,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := NewTestGpgYubikey(&GpgData{
+				ManufacturerID: ManufacturerYubico,
+				AppletVersion:  tc.appletVersion,
+				Quirks:         tc.quirks,
+				tlvValues: bertlv.TLVData{
+					keyInformationTag: bytes.Repeat(oldFingerprint, 3),
+					keyDateTag:        make([]byte, 3*keyDateLen),
+				},
+			}, false, nil)
+			yk.tx = &TestSCTx{APDUList: tc.apdus, ResponseList: tc.responses}
+
+			opts := tc.opts
+			opts.Created = time.Unix(testOpenPGPCreated, 0)
+
+			rotated, err := yk.RotateKeys(opts)
+			if !expectedError(t, err, tc.expectErr) || tc.expectErr != nil {
+				return
+			}
+
+			if len(rotated) != 1 {
+				t.Fatalf("expected 1 rotated key got %d", len(rotated))
+			}
+
+			r := rotated[0]
+			if !bytes.Equal(r.OldFingerprint, oldFingerprint) || !bytes.Equal(r.NewFingerprint, newFingerprint) {
+				t.Errorf("got fingerprints %x -> %x", r.OldFingerprint, r.NewFingerprint)
+			}
+
+			if !bytes.Equal(r.Attestation, tc.attestation) {
+				t.Errorf("got attestation %x expected %x", r.Attestation, tc.attestation)
+			}
+
+			fp, _ := yk.gpgData.Fingerprint(SignatureKey)
+			if fp != UpperCaseHexString(newFingerprint) {
+				t.Errorf("cached fingerprint not updated: %s", fp)
+			}
+
+			if created, _ := yk.gpgData.Date(SignatureKey); !created.Equal(opts.Created) {
+				t.Errorf("cached date not updated: %s", created)
+			}
+		})
+	}
+}
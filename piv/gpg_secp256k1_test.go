@@ -0,0 +1,96 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/bitcurves"
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_Secp256k1(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(bitcurves.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := yk.ImportOpenPGPKey(piv.SignatureKey, key); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	g, err := yk.GPGData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := g.AlgorithmAttributes(piv.SignatureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attributes.String() != "ECDSA secp256k1" || attributes.Curve() != bitcurves.S256() {
+		t.Errorf("attributes %s curve %v", attributes, attributes.Curve())
+	}
+
+	priv, err := yk.OpenPGPPrivateKey(piv.SignatureKey, piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatalf("private key: %v", err)
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		t.Fatalf("%T is not a crypto.Signer", priv)
+	}
+
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || pub.Curve != bitcurves.S256() || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		t.Fatalf("public key %v expected %v", signer.Public(), key.Public())
+	}
+
+	digest := sha256.Sum256([]byte("attack at dawn"))
+
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		t.Error("signature doesn't verify")
+	}
+
+	if _, err := piv.ECAlgorithmAttributes(piv.DecryptionKey, bitcurves.S256()); err == nil {
+		t.Error("secp256k1 ECDH attributes")
+	}
+}
@@ -0,0 +1,188 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+
+	apduerr "github.com/areese/piv-go/piv/apdu"
+)
+
+// ErrNoSuchOccurrence is returned for an occurrence of a data object the card doesn't have.
+var ErrNoSuchOccurrence = errors.New("no such data object occurrence")
+
+const (
+	// selectDataFirst is P2 of SELECT DATA, the DO after skipping P1 occurrences.
+	selectDataFirst = 0x04
+	// maxOccurrence is the largest occurrence number P1 of SELECT DATA can hold.
+	maxOccurrence = 0xff
+)
+
+// dataOccurrences are the number of instances of the data objects the specification defines with several.
+//
+// nolint:gochecknoglobals
+var dataOccurrences = map[uint16]int{cardholderCertificateTag: 3}
+
+// DOCursor addresses the occurrences of a data object that has several instances, such as the
+// three cardholder certificates (7F21). SELECT DATA picks the occurrence before each GET or PUT DATA,
+// so other commands in between don't change which instance is read or written.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 57
+// 7.2.5 SELECT DATA.
+type DOCursor struct {
+	yk         *GPGYubiKey
+	tag        uint16
+	occurrence int
+}
+
+// SelectData returns a cursor at the first occurrence of the data object tag.
+// Cards without SELECT DATA return ErrUnsupportedByCardVersion or ErrNotSupportedByCard.
+func (yk *GPGYubiKey) SelectData(tag uint16) (*DOCursor, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.SelectData\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	if err := gpgCheckSelectData(yk.gpgData); err != nil {
+		return nil, err
+	}
+
+	return &DOCursor{yk: yk, tag: tag}, nil
+}
+
+// Tag returns the data object of the cursor.
+func (c *DOCursor) Tag() uint16 {
+	return c.tag
+}
+
+// Occurrence returns the occurrence the cursor is at, counted from 0.
+func (c *DOCursor) Occurrence() int {
+	return c.occurrence
+}
+
+// Seek moves the cursor to occurrence, counted from 0.
+// For the cardholder certificates 0 is the authentication, 1 the decryption and 2 the signature key.
+func (c *DOCursor) Seek(occurrence int) error {
+	limit := maxOccurrence + 1
+	if n, ok := dataOccurrences[c.tag]; ok {
+		limit = n
+	}
+
+	if occurrence < 0 || occurrence >= limit {
+		return fmt.Errorf("%w: %04x has no occurrence [%d]", ErrNoSuchOccurrence, c.tag, occurrence)
+	}
+
+	c.occurrence = occurrence
+
+	return nil
+}
+
+// Next moves the cursor to the next occurrence, it returns ErrNoSuchOccurrence after the last one the
+// specification defines. For other data objects the card decides, see All.
+func (c *DOCursor) Next() error {
+	return c.Seek(c.occurrence + 1)
+}
+
+// Get reads the data object at the cursor, it's empty if nothing was stored.
+func (c *DOCursor) Get() ([]byte, error) {
+	if err := c.selectOccurrence(); err != nil {
+		return nil, err
+	}
+
+	data, err := c.yk.tx.Transmit(apdu{instruction: insGetDataA, param1: byte(c.tag >> 8), param2: byte(c.tag)})
+	if err != nil {
+		return nil, fmt.Errorf("get data %04x occurrence [%d]: %w", c.tag, c.occurrence, err)
+	}
+
+	return data, nil
+}
+
+// Put writes data to the data object at the cursor, most data objects require PW3 has been presented.
+func (c *DOCursor) Put(data []byte) error {
+	if err := c.selectOccurrence(); err != nil {
+		return err
+	}
+
+	return gpgPutData(c.yk.tx, c.tag, data)
+}
+
+// All reads every occurrence from the first and leaves the cursor at the last one read.
+// Data objects the specification doesn't define several occurrences for are read until the card
+// refuses to select the next one.
+func (c *DOCursor) All() ([][]byte, error) {
+	var all [][]byte
+
+	for occurrence := 0; c.Seek(occurrence) == nil; occurrence++ {
+		data, err := c.Get()
+
+		var e *apduerr.Error
+		if _, known := dataOccurrences[c.tag]; !known && occurrence > 0 && errors.As(err, &e) {
+			_ = c.Seek(occurrence - 1)
+
+			break
+		}
+
+		if err != nil {
+			return all, err
+		}
+
+		all = append(all, data)
+	}
+
+	return all, nil
+}
+
+func (c *DOCursor) selectOccurrence() error {
+	err := gpgSelectOccurrence(c.yk.tx, c.tag, c.occurrence, gpgSelectDataLengthPrefix(c.yk.gpgData))
+	if err != nil {
+		return fmt.Errorf("selecting %04x occurrence [%d]: %w", c.tag, c.occurrence, err)
+	}
+
+	return nil
+}
+
+// gpgCheckSelectData returns why g can't use SELECT DATA, or nil.
+func gpgCheckSelectData(g *GpgData) error {
+	if f := g.Features(); !f.SelectData {
+		return fmt.Errorf("%w: select data on %s", ErrUnsupportedByCardVersion, f)
+	}
+
+	if g.Quirks.NoSelectData {
+		return fmt.Errorf("%s: %w: select data", g.Quirks.Name, ErrNotSupportedByCard)
+	}
+
+	return nil
+}
+
+// gpgSelectOccurrence sends SELECT DATA for an occurrence of tag, with a tag list (60) of the tag (5C).
+// lengthPrefix adds the extra length byte YubiKeys up to 5.4.3 expect, see gpgSelectDataLengthPrefix.
+func gpgSelectOccurrence(tx SCTx, tag uint16, occurrence int, lengthPrefix bool) error {
+	tagBytes := []byte{byte(tag)}
+	if tag > 0xff {
+		tagBytes = []byte{byte(tag >> 8), byte(tag)}
+	}
+
+	data := append([]byte{0x60, byte(len(tagBytes) + 2), 0x5c, byte(len(tagBytes))}, tagBytes...)
+	if lengthPrefix {
+		data = append([]byte{byte(len(data))}, data...)
+	}
+
+	_, err := gpgSelectData(tx, byte(occurrence), selectDataFirst, data)
+
+	return err
+}
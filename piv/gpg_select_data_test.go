@@ -0,0 +1,79 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestGPGYubiKey_SelectData(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := yk.SelectData(0x7f21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := [][]byte{{0x30, 0x01, 0x00}, {0x30, 0x01, 0x01}, {0x30, 0x01, 0x02}}
+
+	for i, cert := range certs {
+		if err := cursor.Seek(i); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cursor.Put(cert); err != nil {
+			t.Fatalf("put occurrence %d: %v", i, err)
+		}
+	}
+
+	if err := cursor.Next(); !errors.Is(err, piv.ErrNoSuchOccurrence) {
+		t.Errorf("got %v expected %v", err, piv.ErrNoSuchOccurrence)
+	}
+
+	all, err := cursor.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != len(certs) || cursor.Occurrence() != len(certs)-1 {
+		t.Fatalf("got %d occurrences, at %d", len(all), cursor.Occurrence())
+	}
+
+	for i := range certs {
+		if !bytes.Equal(all[i], certs[i]) {
+			t.Errorf("occurrence %d got %x expected %x", i, all[i], certs[i])
+		}
+	}
+
+	// the signature key certificate is the third occurrence.
+	if cert, err := yk.GetCardholderCertificate(piv.SignatureKey); err != nil || !bytes.Equal(cert, certs[2]) {
+		t.Errorf("got %x, %v expected %x", cert, err, certs[2])
+	}
+}
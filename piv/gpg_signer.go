@@ -0,0 +1,239 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// openPGPSigner is the signature or authentication key of the OpenPGP applet.
+type openPGPSigner struct {
+	yk      *GPGYubiKey
+	keyType KeyType
+	// key is the key that signs, see ManageSecurityEnvironment.
+	key  KeyType
+	pub  crypto.PublicKey
+	auth OpenPGPKeyAuth
+	uif  UIF
+}
+
+var _ crypto.Signer = (*openPGPSigner)(nil)
+
+// OpenPGPPrivateKey returns a key of the card, like PrivateKey does for PIV slots.
+// SignatureKey and AuthenticationKey return a crypto.Signer for RSA, ECDSA and Ed25519 keys,
+// DecryptionKey returns the crypto.Decrypter of OpenPGPDecrypter.
+//
+// RSA keys sign PKCS#1 v1.5 signatures, PSS returns ErrNotSupportedByCard.
+// Ed25519 keys sign the message itself, with crypto.Hash(0) as the options, like ed25519.PrivateKey.
+// The signature key uses PSO: COMPUTE DIGITAL SIGNATURE, which increments the signature counter,
+// the authentication key uses INTERNAL AUTHENTICATE and suits TLS client authentication.
+//
+// The PIN is presented from auth when the card asks for it, which is on every signature if the
+// signature PIN is only valid for one signature. The UIF of the key is read here when auth has a TouchCallback.
+//
+// After ManageSecurityEnvironment chose the decryption key for SecurityOperationAuthenticate,
+// AuthenticationKey signs with the decryption key.
+func (yk *GPGYubiKey) OpenPGPPrivateKey(keyType KeyType, auth OpenPGPKeyAuth) (crypto.PrivateKey, error) {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.OpenPGPPrivateKey\u001b[0m")
+	}
+
+	if yk.gpgData == nil {
+		return nil, ErrNotFound
+	}
+
+	switch keyType {
+	case SignatureKey, AuthenticationKey:
+	case DecryptionKey:
+		return yk.OpenPGPDecrypter(auth)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyType, keyType)
+	}
+
+	key := keyType
+	if keyType == AuthenticationKey {
+		key = yk.securityEnvironmentKey(SecurityOperationAuthenticate)
+	}
+
+	pub, err := yk.ReadOpenPGPPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("read %s key: %w", key, err)
+	}
+
+	uif, err := auth.uif(yk, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openPGPSigner{yk: yk, keyType: keyType, key: key, pub: pub, auth: auth, uif: uif}, nil
+}
+
+// parseECDSAPublicKey parses the 86 point of a public key template.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 75
+// 7.2.14 GENERATE ASYMMETRIC KEY PAIR.
+func parseECDSAPublicKey(tlvDataPtr *bertlv.TLVData, curve elliptic.Curve) (*ecdsa.PublicKey, error) {
+	point, ok := (*tlvDataPtr)[openGpgECPointTag]
+	if !ok {
+		return nil, ErrNoPublicKeyPoint
+	}
+
+	x, y := elliptic.Unmarshal(curve, point) // nolint:staticcheck // ecdsa.PublicKey still needs X and Y.
+	if x == nil {
+		return nil, fmt.Errorf("%w: invalid point for %s", ErrNoPublicKeyPoint, curve.Params().Name)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// parseEd25519PublicKey parses the 86 point of an Ed25519 public key template,
+// without or with the 40 prefix of native points.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 75
+// 7.2.14 GENERATE ASYMMETRIC KEY PAIR.
+func parseEd25519PublicKey(tlvDataPtr *bertlv.TLVData) (ed25519.PublicKey, error) {
+	point, ok := (*tlvDataPtr)[openGpgECPointTag]
+	if !ok {
+		return nil, ErrNoPublicKeyPoint
+	}
+
+	if len(point) == ed25519.PublicKeySize+1 && point[0] == openPGPNativePointPrefix {
+		point = point[1:]
+	}
+
+	if len(point) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: ed25519 point length %d", ErrNoPublicKeyPoint, len(point))
+	}
+
+	return append(ed25519.PublicKey{}, point...), nil
+}
+
+func (s *openPGPSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign implements crypto.Signer.
+func (s *openPGPSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var data []byte
+
+	switch pub := s.pub.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, fmt.Errorf("pss: %w", ErrNotSupportedByCard)
+		}
+
+		hash := opts.HashFunc()
+		if len(digest) != hash.Size() {
+			return nil, fmt.Errorf("input must be hashed")
+		}
+
+		prefix, ok := hashPrefixes[hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm: crypto.Hash(%d)", hash)
+		}
+
+		// the card adds the PKCS#1 padding to the DigestInfo.
+		data = append(append([]byte{}, prefix...), digest...)
+	case *ecdsa.PublicKey:
+		// Same as the standard library, truncate the digest to the size of the curve.
+		if orderBytes := (pub.Params().BitSize + 7) / 8; len(digest) > orderBytes {
+			digest = digest[:orderBytes]
+		}
+
+		data = digest
+	case ed25519.PublicKey:
+		// the card hashes the message, Ed25519ph isn't supported.
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, fmt.Errorf("%w: ed25519 with %s", ErrNotSupportedByCard, opts.HashFunc())
+		}
+
+		data = digest
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnknownKeyType, s.pub)
+	}
+
+	pwField := byte(paramOpenGPGVerifyPW1)
+
+	f := func(tx SCTx) ([]byte, error) {
+		return gpgComputeDigitalSignature(tx, data)
+	}
+
+	if s.keyType == AuthenticationKey {
+		pwField = paramOpenGPGVerifyPW2
+		f = gpgWithSecurityEnvironment(SecurityOperationAuthenticate, s.key, func(tx SCTx) ([]byte, error) {
+			return gpgInternalAuthenticate(tx, data)
+		})
+	}
+
+	s.auth.touch(s.uif)
+
+	sig, err := s.auth.do(s.yk, pwField, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.keyType == SignatureKey {
+		s.yk.gpgData.countSignature()
+	}
+
+	if pub, ok := s.pub.(*ecdsa.PublicKey); ok {
+		return ecdsaSignatureASN1(pub, sig)
+	}
+
+	return sig, nil
+}
+
+// ecdsaSignatureASN1 converts the r || s signature of the card to the ASN.1 signature of crypto/ecdsa.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 63
+// 7.2.10 PSO: COMPUTE DIGITAL SIGNATURE.
+func ecdsaSignatureASN1(pub *ecdsa.PublicKey, sig []byte) ([]byte, error) {
+	size := (pub.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return nil, fmt.Errorf("%w: ecdsa signature length %d expected %d", ErrTooShort, len(sig), 2*size)
+	}
+
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(sig[:size]),
+		S: new(big.Int).SetBytes(sig[size:]),
+	})
+}
+
+// gpgInternalAuthenticate requires PW1 (82) has been presented.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 72
+// 7.2.13 INTERNAL AUTHENTICATE.
+func gpgInternalAuthenticate(tx SCTx, data []byte) ([]byte, error) {
+	cmd := apdu{
+		instruction: insInternalAuthenticate,
+		data:        data,
+	}
+
+	signature, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
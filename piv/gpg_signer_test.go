@@ -0,0 +1,256 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGPGYubiKey_OpenPGPPrivateKeyECDSA(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		keyType KeyType
+		tag     string
+		crt     []byte
+		sign    apdu
+		verify  apdu
+		// count is the signature counter after signing, only PSO: COMPUTE DIGITAL SIGNATURE increments it.
+		count int
+	}{
+		{
+			name:    "signature",
+			keyType: SignatureKey,
+			tag:     keyAlgorithmSignatureAttributesTag,
+			crt:     crtDigitalSignature[:],
+			sign: apdu{
+				instruction: insPerformSecurityOperation,
+				param1:      securityOperationComputeDigitalSignatureParam1,
+				param2:      securityOperationComputeDigitalSignatureParam2,
+			},
+			verify: apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW1, data: []byte("123456")},
+			count:  8,
+		},
+		{
+			name:    "authentication",
+			keyType: AuthenticationKey,
+			tag:     keyAlgorithmAuthenticationAttributesTag,
+			crt:     crtAuthentication[:],
+			sign:    apdu{instruction: insInternalAuthenticate},
+			verify:  apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW2, data: []byte("123456")},
+			count:   7,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cardKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256([]byte("message"))
+
+			r, s, err := ecdsa.Sign(rand.Reader, cardKey, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the card returns r || s.
+			rs := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+			point := elliptic.Marshal(elliptic.P256(), cardKey.X, cardKey.Y) // nolint:staticcheck
+			readKey := append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, point))...)
+
+			sign := tc.sign
+			sign.data = digest[:]
+
+			yk := NewTestGpgYubikey(&GpgData{
+				tlvValues: bertlv.TLVData{
+					tc.tag:              mustHex(t, "13 2a8648ce3d030107"),
+					signatureCounterTag: {0x00, 0x00, 0x07},
+				},
+			}, false, nil)
+			yk.tx = &TestSCTx{
+				APDUList: []apdu{
+					{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricRead, data: tc.crt},
+					sign,
+					tc.verify,
+					sign,
+				},
+				ResponseList: [][]byte{readKey, nil, nil, rs},
+				TransmitErr:  []error{nil, &apduErr{0x69, 0x82}, nil, nil},
+			}
+
+			priv, err := yk.OpenPGPPrivateKey(tc.keyType, OpenPGPKeyAuth{PIN: []byte("123456")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer, ok := priv.(crypto.Signer)
+			if !ok {
+				t.Fatalf("got %T expected a crypto.Signer", priv)
+			}
+
+			if !cardKey.PublicKey.Equal(signer.Public()) {
+				t.Fatalf("got public key %v expected %v", signer.Public(), cardKey.Public())
+			}
+
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ecdsa.VerifyASN1(&cardKey.PublicKey, digest[:], sig) {
+				t.Error("signature did not verify")
+			}
+
+			if count, _ := yk.gpgData.SignatureCount(); count != tc.count {
+				t.Errorf("got signature count %d expected %d", count, tc.count)
+			}
+		})
+	}
+}
+
+func TestOpenPGPSigner_RSA(t *testing.T) {
+	t.Parallel()
+
+	cardKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+
+	expected, err := rsa.SignPKCS1v15(rand.Reader, cardKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = &TestSCTx{
+		APDUList: []apdu{{
+			instruction: insPerformSecurityOperation,
+			param1:      securityOperationComputeDigitalSignatureParam1,
+			param2:      securityOperationComputeDigitalSignatureParam2,
+			data:        append(append([]byte{}, hashPrefixes[crypto.SHA256]...), digest[:]...),
+		}},
+		ResponseList: [][]byte{expected},
+	}
+
+	signer := &openPGPSigner{yk: yk, keyType: SignatureKey, pub: &cardKey.PublicKey}
+
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rsa.VerifyPKCS1v15(&cardKey.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Error(err)
+	}
+
+	_, err = signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{Hash: crypto.SHA256})
+	expectedError(t, err, ErrNotSupportedByCard)
+}
+
+func TestOpenPGPSigner_TouchCallback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		uif     []byte
+		uifErr  error
+		touches int
+	}{
+		{name: "on", uif: []byte{byte(UIFOn), uifButtonFeature}, touches: 1},
+		{name: "off", uif: []byte{byte(UIFOff), uifButtonFeature}},
+		{name: "no button", uifErr: &apduErr{0x6a, 0x88}},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cardKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256([]byte("message"))
+
+			r, s, err := ecdsa.Sign(rand.Reader, cardKey, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rs := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+			point := elliptic.Marshal(elliptic.P256(), cardKey.X, cardKey.Y) // nolint:staticcheck
+			readKey := append([]byte{0x7f}, marshalASN1(0x49, marshalASN1(0x86, point))...)
+
+			yk := NewTestGpgYubikey(&GpgData{
+				tlvValues: bertlv.TLVData{
+					keyAlgorithmAuthenticationAttributesTag: mustHex(t, "13 2a8648ce3d030107"),
+				},
+			}, false, nil)
+			yk.tx = &TestSCTx{
+				APDUList: []apdu{
+					{instruction: insGenerateAsymmetric, param1: paramOpenGPGAsymmetricRead, data: crtAuthentication[:]},
+					{instruction: insGetDataA, param2: putSignatureUIFTag + byte(AuthenticationKey)},
+					{instruction: insInternalAuthenticate, data: digest[:]},
+				},
+				ResponseList: [][]byte{readKey, tc.uif, rs},
+				TransmitErr:  []error{nil, tc.uifErr, nil},
+			}
+
+			touches := 0
+
+			priv, err := yk.OpenPGPPrivateKey(AuthenticationKey, OpenPGPKeyAuth{
+				PIN:           []byte("123456"),
+				TouchCallback: func() { touches++ },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer, ok := priv.(crypto.Signer)
+			if !ok {
+				t.Fatalf("got %T expected a crypto.Signer", priv)
+			}
+
+			if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+				t.Fatal(err)
+			}
+
+			if touches != tc.touches {
+				t.Errorf("got %d touches expected %d", touches, tc.touches)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// ErrSecureMessaging is returned when secure messaging can't be used or a protected response is invalid.
+var ErrSecureMessaging = errors.New("secure messaging")
+
+// Secure messaging data objects.
+// ISO/IEC 7816-4 10.2 Basic secure messaging data objects.
+const (
+	smClass         = 0x0c
+	smCryptogramTag = 0x87
+	smLeTag         = 0x97
+	smStatusTag     = 0x99
+	smMACTag        = 0x8e
+
+	// smPaddingIndicator is the first byte of 87, the plain data is padded with 80 00...
+	smPaddingIndicator = 0x01
+	smMACLen           = 8
+)
+
+// SecureMessagingKeys are the static AES keys stored on the card as SM-Key-ENC (D1) and SM-Key-MAC (D2).
+// They are 16 bytes for AES128bit and 32 bytes for AES256bit.
+type SecureMessagingKeys struct {
+	Enc []byte
+	MAC []byte
+}
+
+// GPGOpenOptions are options for OpenGPGWithOptions.
+type GPGOpenOptions struct {
+	// OpenOptions are how the card is connected to.
+	OpenOptions
+
+	// SecureMessaging protects every command after the application data was read,
+	// the card must advertise AES secure messaging with the key size.
+	SecureMessaging *SecureMessagingKeys
+
+	// ParseMode is how strictly the data objects of the card are checked, ParseLenient if it's not set.
+	ParseMode ParseMode
+}
+
+// smTx protects every APDU with secure messaging.
+// Commands are sent with CLA 0C, the data encrypted with AES-CBC in 87, Le in 97 and an AES-CMAC in 8E.
+// The IV is the send sequence counter encrypted with the ENC key, the counter is incremented before every command and response.
+// The MAC is over the counter, the padded header and the padded data objects.
+// ISO/IEC 7816-4 10 Secure messaging, the keys are the SM keys of the OpenPGP card.
+type smTx struct {
+	SCTx
+	enc cipher.Block
+	mac cipher.Block
+	ssc []byte
+}
+
+var _ SCTx = (*smTx)(nil)
+
+func newSMTx(tx SCTx, g *GpgData, keys *SecureMessagingKeys) (*smTx, error) {
+	if !g.SecureMessagingSupported {
+		return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, ErrNotSupportedByCard)
+	}
+
+	var keyLen int
+
+	switch g.SecureMessaging {
+	case AES128bit:
+		keyLen = 16
+	case AES256bit:
+		keyLen = 32
+	default:
+		// SCP11b needs a key agreement with the card certificate first.
+		return nil, fmt.Errorf("%w: %s: %w", ErrSecureMessaging, g.SecureMessaging, ErrNotSupportedByCard)
+	}
+
+	if len(keys.Enc) != keyLen || len(keys.MAC) != keyLen {
+		return nil, fmt.Errorf("%w: %s needs %d byte keys", ErrSecureMessaging, g.SecureMessaging, keyLen)
+	}
+
+	enc, err := aes.NewCipher(keys.Enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+	}
+
+	mac, err := aes.NewCipher(keys.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+	}
+
+	return &smTx{SCTx: tx, enc: enc, mac: mac, ssc: make([]byte, aes.BlockSize)}, nil
+}
+
+// Transmit sends d protected and returns the verified and decrypted response.
+func (s *smTx) Transmit(d apdu) ([]byte, error) {
+	resp, err := s.SCTx.Transmit(s.wrap(d))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.unwrap(resp)
+}
+
+func (s *smTx) wrap(d apdu) apdu {
+	s.increment()
+
+	var dos []byte
+
+	if len(d.data) > 0 {
+		dos = marshalASN1(smCryptogramTag, append([]byte{smPaddingIndicator}, s.encrypt(d.data)...))
+	}
+
+	// any response length.
+	dos = append(dos, smLeTag, 0x01, 0x00)
+
+	header := smPad([]byte{smClass, d.instruction, d.param1, d.param2})
+	mac := smCMAC(s.mac, smConcat(s.ssc, header, smPad(dos)))
+
+	return apdu{
+		class:       smClass,
+		instruction: d.instruction,
+		param1:      d.param1,
+		param2:      d.param2,
+		data:        append(dos, marshalASN1(smMACTag, mac[:smMACLen])...),
+	}
+}
+
+// unwrap checks the MAC of resp, returns the status in 99 as an error and decrypts 87.
+func (s *smTx) unwrap(resp []byte) ([]byte, error) {
+	s.increment()
+
+	var cryptogram, status, mac, covered []byte
+
+	for rest := resp; len(rest) > 0; {
+		offset := len(resp) - len(rest)
+
+		var v asn1.RawValue
+
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+		}
+
+		switch v.FullBytes[0] {
+		case smCryptogramTag:
+			cryptogram = v.Bytes
+		case smStatusTag:
+			status = v.Bytes
+		case smMACTag:
+			mac = v.Bytes
+			covered = resp[:offset]
+		}
+	}
+
+	if len(status) != 2 || mac == nil {
+		return nil, fmt.Errorf("%w: response without status or mac", ErrSecureMessaging)
+	}
+
+	expected := smCMAC(s.mac, smConcat(s.ssc, smPad(covered)))
+	if subtle.ConstantTimeCompare(mac, expected[:smMACLen]) != 1 {
+		return nil, fmt.Errorf("%w: response mac mismatch", ErrSecureMessaging)
+	}
+
+	if status[0] != 0x90 || status[1] != 0x00 {
+		return nil, &apduErr{status[0], status[1]}
+	}
+
+	if len(cryptogram) == 0 {
+		return nil, nil
+	}
+
+	if cryptogram[0] != smPaddingIndicator {
+		return nil, fmt.Errorf("%w: padding indicator %02x", ErrSecureMessaging, cryptogram[0])
+	}
+
+	return s.decrypt(cryptogram[1:])
+}
+
+func (s *smTx) increment() {
+	for i := len(s.ssc) - 1; i >= 0; i-- {
+		s.ssc[i]++
+		if s.ssc[i] != 0 {
+			return
+		}
+	}
+}
+
+func (s *smTx) iv() []byte {
+	iv := make([]byte, aes.BlockSize)
+	s.enc.Encrypt(iv, s.ssc)
+
+	return iv
+}
+
+func (s *smTx) encrypt(data []byte) []byte {
+	out := smPad(data)
+	cipher.NewCBCEncrypter(s.enc, s.iv()).CryptBlocks(out, out)
+
+	return out
+}
+
+func (s *smTx) decrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: cryptogram length %d", ErrSecureMessaging, len(data))
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(s.enc, s.iv()).CryptBlocks(out, data)
+
+	return smUnpad(out)
+}
+
+// smPad pads data with 80 00... to a multiple of the block size.
+// ISO/IEC 9797-1 padding method 2.
+func smPad(data []byte) []byte {
+	out := make([]byte, len(data)+aes.BlockSize-len(data)%aes.BlockSize)
+	copy(out, data)
+	out[len(data)] = 0x80
+
+	return out
+}
+
+func smUnpad(data []byte) ([]byte, error) {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0x00:
+		case 0x80:
+			return data[:i], nil
+		default:
+			return nil, fmt.Errorf("%w: bad padding", ErrSecureMessaging)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: bad padding", ErrSecureMessaging)
+}
+
+// smCMAC is the AES-CMAC of msg.
+// https://www.rfc-editor.org/rfc/rfc4493 2.4 MAC Generation Algorithm.
+func smCMAC(b cipher.Block, msg []byte) []byte {
+	k1 := make([]byte, aes.BlockSize)
+	b.Encrypt(k1, k1)
+	k1 = cmacDouble(k1)
+	k2 := cmacDouble(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, aes.BlockSize)
+	tail := msg[(n-1)*aes.BlockSize:]
+
+	if len(tail) == aes.BlockSize {
+		subtle.XORBytes(last, tail, k1)
+	} else {
+		copy(last, tail)
+		last[len(tail)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		b.Encrypt(x, x)
+	}
+
+	subtle.XORBytes(x, x, last)
+	b.Encrypt(x, x)
+
+	return x
+}
+
+func cmacDouble(in []byte) []byte {
+	out := make([]byte, len(in))
+
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+
+	if in[0]&0x80 != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+
+	return out
+}
+
+func smConcat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+
+	return out
+}
@@ -0,0 +1,256 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestSMCMAC(t *testing.T) {
+	t.Parallel()
+
+	// https://www.rfc-editor.org/rfc/rfc4493 4. Test Vectors.
+	block, err := aes.NewCipher(mustHex(t, "2b7e151628aed2a6abf7158809cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		msg  string
+		mac  string
+	}{
+		{name: "empty", msg: "", mac: "bb1d6929e95937287fa37d129b756746"},
+		{name: "one block", msg: "6bc1bee22e409f96e93d7e117393172a", mac: "070a16b46b4d4144f79bdd9dd04a287c"},
+		{
+			name: "partial block",
+			msg:  "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411",
+			mac:  "dfa66747de9ae63030ca32611497c827",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, _ := hex.DecodeString(tc.msg)
+			if got := smCMAC(block, msg); hex.EncodeToString(got) != tc.mac {
+				t.Errorf("got %x expected %s", got, tc.mac)
+			}
+		})
+	}
+}
+
+// smCard is the card side of a secure messaging session.
+type smCard struct {
+	*smTx
+}
+
+// unwrapCommand checks the MAC of a protected command and decrypts its data.
+func (c *smCard) unwrapCommand(t *testing.T, d apdu) []byte {
+	t.Helper()
+
+	c.increment()
+
+	macAt := bytes.LastIndex(d.data, []byte{smMACTag, smMACLen})
+	if d.class != smClass || macAt < 0 {
+		t.Fatalf("not a protected command: %+v", d)
+	}
+
+	dos := d.data[:macAt]
+	header := smPad([]byte{d.class, d.instruction, d.param1, d.param2})
+
+	mac := smCMAC(c.mac, smConcat(c.ssc, header, smPad(dos)))
+	if !bytes.Equal(mac[:smMACLen], d.data[macAt+2:]) {
+		t.Fatalf("command mac mismatch")
+	}
+
+	if !bytes.HasSuffix(dos, []byte{smLeTag, 0x01, 0x00}) {
+		t.Fatalf("command without le: %x", dos)
+	}
+
+	if len(dos) == 3 {
+		return nil
+	}
+
+	data, err := c.decrypt(dos[3 : len(dos)-3])
+	if err != nil {
+		t.Fatalf("decrypting command: %v", err)
+	}
+
+	return data
+}
+
+// wrapResponse protects a response the way the card does.
+func (c *smCard) wrapResponse(data []byte, sw1, sw2 byte) []byte {
+	c.increment()
+
+	var dos []byte
+	if len(data) > 0 {
+		dos = marshalASN1(smCryptogramTag, append([]byte{smPaddingIndicator}, c.encrypt(data)...))
+	}
+
+	dos = append(dos, smStatusTag, 0x02, sw1, sw2)
+	mac := smCMAC(c.mac, smConcat(c.ssc, smPad(dos)))
+
+	return append(dos, marshalASN1(smMACTag, mac[:smMACLen])...)
+}
+
+func TestSMTx_Transmit(t *testing.T) {
+	t.Parallel()
+
+	gpgData := &GpgData{SecureMessagingSupported: true, SecureMessaging: AES128bit}
+	keys := &SecureMessagingKeys{Enc: bytes.Repeat([]byte{0x01}, 16), MAC: bytes.Repeat([]byte{0x02}, 16)}
+
+	newCard := func() *smCard {
+		s, err := newSMTx(nil, gpgData, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &smCard{s}
+	}
+
+	verify := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW1, data: []byte(defaultPW1)}
+	getData := apdu{instruction: insGetDataA, param2: 0x5e}
+	login := []byte("login")
+
+	// the expected commands come from the same counter the host uses, it's incremented for each response too.
+	expected := newCard()
+
+	var apdus []apdu
+	for _, d := range []apdu{verify, getData, getData} {
+		apdus = append(apdus, expected.wrap(d))
+		expected.increment()
+	}
+
+	card := newCard()
+	if got := card.unwrapCommand(t, apdus[0]); !bytes.Equal(got, verify.data) {
+		t.Fatalf("card got %x expected %x", got, verify.data)
+	}
+
+	verifyResp := card.wrapResponse(nil, 0x90, 0x00)
+
+	card.unwrapCommand(t, apdus[1])
+	getDataResp := card.wrapResponse(login, 0x90, 0x00)
+
+	card.unwrapCommand(t, apdus[2])
+	tamperedResp := card.wrapResponse(login, 0x90, 0x00)
+	tamperedResp[len(tamperedResp)-1] ^= 0xff
+
+	host, err := newSMTx(&TestSCTx{APDUList: apdus, ResponseList: [][]byte{verifyResp, getDataResp, tamperedResp}}, gpgData, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := host.Transmit(verify); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	got, err := host.Transmit(getData)
+	if err != nil {
+		t.Fatalf("get data: %v", err)
+	}
+
+	if !bytes.Equal(got, login) {
+		t.Errorf("got %q expected %q", got, login)
+	}
+
+	_, err = host.Transmit(getData)
+	expectedError(t, err, ErrSecureMessaging)
+}
+
+func TestSMTx_TransmitStatus(t *testing.T) {
+	t.Parallel()
+
+	gpgData := &GpgData{SecureMessagingSupported: true, SecureMessaging: AES256bit}
+	keys := &SecureMessagingKeys{Enc: bytes.Repeat([]byte{0x03}, 32), MAC: bytes.Repeat([]byte{0x04}, 32)}
+
+	expected, err := newSMTx(nil, gpgData, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := apdu{instruction: insVerify, param2: paramOpenGPGVerifyPW1, data: []byte("wrong pin")}
+	apdus := []apdu{expected.wrap(cmd)}
+	resp := (&smCard{expected}).wrapResponse(nil, 0x69, 0x82)
+
+	host, err := newSMTx(&TestSCTx{APDUList: apdus, ResponseList: [][]byte{resp}}, gpgData, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = host.Transmit(cmd)
+
+	var e *apduErr
+	if !errors.As(err, &e) || e.Status() != 0x6982 {
+		t.Errorf("expected 6982 got %v", err)
+	}
+}
+
+func TestNewSMTx(t *testing.T) {
+	t.Parallel()
+
+	key16 := bytes.Repeat([]byte{0x01}, 16)
+
+	tests := []struct {
+		name      string
+		gpgData   *GpgData
+		keys      *SecureMessagingKeys
+		expectErr error
+	}{
+		{
+			name:      "not supported",
+			gpgData:   &GpgData{},
+			keys:      &SecureMessagingKeys{Enc: key16, MAC: key16},
+			expectErr: ErrNotSupportedByCard,
+		},
+		{
+			name:      "scp11b",
+			gpgData:   &GpgData{SecureMessagingSupported: true, SecureMessaging: SCP11b},
+			keys:      &SecureMessagingKeys{Enc: key16, MAC: key16},
+			expectErr: ErrNotSupportedByCard,
+		},
+		{
+			name:      "key size",
+			gpgData:   &GpgData{SecureMessagingSupported: true, SecureMessaging: AES256bit},
+			keys:      &SecureMessagingKeys{Enc: key16, MAC: key16},
+			expectErr: ErrSecureMessaging,
+		},
+		{
+			name:    "aes128",
+			gpgData: &GpgData{SecureMessagingSupported: true, SecureMessaging: AES128bit},
+			keys:    &SecureMessagingKeys{Enc: key16, MAC: key16},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newSMTx(&TestSCTx{}, tc.gpgData, tc.keys)
+			expectedError(t, err, tc.expectErr)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Tags returns the paths of the data objects read from the card, like 6E.73.C5, sorted.
+func (g *GpgData) Tags() []string {
+	if g == nil {
+		return nil
+	}
+
+	tags := make([]string, 0, len(g.tlvValues))
+	for tag := range g.tlvValues {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+// Walk calls fn with the path and value of every data object in the order of Tags, to dump or diff the tree.
+// fn must not modify the value.
+func (g *GpgData) Walk(fn func(path string, value []byte)) {
+	for _, tag := range g.Tags() {
+		fn(tag, g.tlvValues[tag])
+	}
+}
+
+// GetTags returns the data objects whose path matches pattern, like 6E.73.* for the discretionary data objects.
+// Each component of pattern is matched against one component of the path with the syntax of path.Match,
+// so * matches one level of the tree. It returns ErrNoSuchTag when nothing matches.
+func (g *GpgData) GetTags(pattern string) (map[string][]byte, error) {
+	patterns := strings.Split(pattern, ".")
+
+	// check the syntax once, path.Match only reports it for the components it gets to.
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("pattern %s: %w", pattern, err)
+		}
+	}
+
+	matches := map[string][]byte{}
+
+	g.Walk(func(tag string, value []byte) {
+		if tagMatches(patterns, strings.Split(tag, ".")) {
+			matches[tag] = value
+		}
+	})
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchTag, pattern)
+	}
+
+	return matches, nil
+}
+
+// tagMatches matches the components of a path to the components of a pattern, hex digits ignore case.
+func tagMatches(patterns, components []string) bool {
+	if len(patterns) != len(components) {
+		return false
+	}
+
+	for i, p := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(p), components[i]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,104 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+func TestGpgData_Tags(t *testing.T) {
+	t.Parallel()
+
+	g := &GpgData{tlvValues: bertlv.TLVData{
+		"6E.73.C5": {0xc5},
+		"6E.73.C1": {0xc1},
+		"6E.4F":    {0x4f},
+		"65.5B":    {0x5b},
+		"6E.73":    {0x73},
+	}}
+
+	expected := []string{"65.5B", "6E.4F", "6E.73", "6E.73.C1", "6E.73.C5"}
+	if tags := g.Tags(); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("got tags %v expected %v", tags, expected)
+	}
+
+	var walked []string
+
+	g.Walk(func(path string, value []byte) {
+		if !reflect.DeepEqual(value, g.tlvValues[path]) {
+			t.Errorf("%s: got %X", path, value)
+		}
+
+		walked = append(walked, path)
+	})
+
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("walked %v expected %v", walked, expected)
+	}
+
+	var nilData *GpgData
+	if tags := nilData.Tags(); tags != nil {
+		t.Errorf("nil data has tags %v", tags)
+	}
+}
+
+func TestGpgData_GetTags(t *testing.T) {
+	t.Parallel()
+
+	g := &GpgData{tlvValues: bertlv.TLVData{
+		"6E.73.C5":    {0xc5},
+		"6E.73.C1":    {0xc1},
+		"6E.73.C1.01": {0x01},
+		"6E.4F":       {0x4f},
+	}}
+
+	tests := []struct {
+		pattern   string
+		expected  []string
+		expectErr error
+	}{
+		{pattern: "6E.73.*", expected: []string{"6E.73.C1", "6E.73.C5"}},
+		{pattern: "6e.73.c?", expected: []string{"6E.73.C1", "6E.73.C5"}},
+		{pattern: "6E.*.C1.*", expected: []string{"6E.73.C1.01"}},
+		{pattern: "6E.4F", expected: []string{"6E.4F"}},
+		{pattern: "65.*", expectErr: ErrNoSuchTag},
+		{pattern: "6E.[", expectErr: path.ErrBadPattern},
+	}
+
+	for _, tc := range tests {
+		matches, err := g.GetTags(tc.pattern)
+		if !errors.Is(err, tc.expectErr) {
+			t.Errorf("%s: got error %v expected %v", tc.pattern, err, tc.expectErr)
+
+			continue
+		}
+
+		if len(matches) != len(tc.expected) {
+			t.Errorf("%s: got %d matches expected %v", tc.pattern, len(matches), tc.expected)
+		}
+
+		for _, tag := range tc.expected {
+			if _, ok := matches[tag]; !ok {
+				t.Errorf("%s: %s doesn't match", tc.pattern, tag)
+			}
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/areese/piv-go/bertlv"
@@ -248,53 +249,7 @@ func BasicSCHandle(tb testing.TB) *Client {
 
 	rv := &TestSCHandle{
 		BeginErr: nil,
-		Ctx: &TestSCTx{
-			APDUList: []apdu{
-				{
-					instruction: insSelectApplication,
-					param1:      paramOpenGPGASelectApplication,
-					data:        aidOpenPGP[:],
-				},
-				{
-					instruction: insGetDataA,
-					param2:      cardHolderDataTag,
-				},
-				{
-					instruction: insGetDataA,
-					param2:      applicationRelatedDataTag,
-				},
-				{
-					instruction: insGetDataA,
-					param2:      securitySupportTemplateTag,
-				},
-				{
-					instruction: insGetGPGAppletVersion,
-				},
-			},
-			ResponseList: [][]byte{
-				{}, // no result from select.
-				{0x65, 0x09, 0x5b, 0x00, 0x5f, 0x2d, 0x00, 0x5f, 0x35, 0x01, 0x39, 0x90, 0x00},
-				{
-					0x6e, 0x81, 0xde, 0x4f, 0x10, 0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x02, 0x00, 0x00, 0x06, 0x03,
-					0x50, 0x69, 0x94, 0x00, 0x00, 0x5f, 0x52, 0x0f, 0x00, 0x73, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x73, 0x81, 0xb7, 0xc0, 0x0a, 0xf0, 0x00, 0x00, 0xff,
-					0x04, 0xc0, 0x00, 0xff, 0x00, 0xff, 0xc1, 0x06, 0x01, 0x08, 0x00, 0x00, 0x11, 0x03, 0xc2, 0x06,
-					0x01, 0x08, 0x00, 0x00, 0x11, 0x03, 0xc3, 0x06, 0x01, 0x08, 0x00, 0x00, 0x11, 0x03, 0xc4, 0x07,
-					0x00, 0x7f, 0x7f, 0x7f, 0x00, 0x03, 0x03, 0xc5, 0x3c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0xc6, 0x3c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x00, 0x00, 0xcd, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-					0x00, 0x90, 0x00,
-				},
-				{0x7a, 0x05, 0x93, 0x03, 0x00, 0x00, 0x00, 0x90, 0x00},
-				{0x05, 0x02, 0x06},
-			},
-		},
+		Ctx:      basicOpenGPGTx(),
 	}
 
 	c := CreateTestClient(tb, nil, nil, rv)
@@ -302,6 +257,57 @@ func BasicSCHandle(tb testing.TB) *Client {
 	return c
 }
 
+// basicOpenGPGTx is a card that answers the SELECT and the reads of OpenGPG.
+func basicOpenGPGTx() *TestSCTx {
+	return &TestSCTx{
+		APDUList: []apdu{
+			{
+				instruction: insSelectApplication,
+				param1:      paramOpenGPGASelectApplication,
+				data:        aidOpenPGP[:],
+			},
+			{
+				instruction: insGetDataA,
+				param2:      cardHolderDataTag,
+			},
+			{
+				instruction: insGetDataA,
+				param2:      applicationRelatedDataTag,
+			},
+			{
+				instruction: insGetDataA,
+				param2:      securitySupportTemplateTag,
+			},
+			{
+				instruction: insGetGPGAppletVersion,
+			},
+		},
+		ResponseList: [][]byte{
+			{}, // no result from select.
+			{0x65, 0x09, 0x5b, 0x00, 0x5f, 0x2d, 0x00, 0x5f, 0x35, 0x01, 0x39, 0x90, 0x00},
+			{
+				0x6e, 0x81, 0xde, 0x4f, 0x10, 0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x02, 0x00, 0x00, 0x06, 0x03,
+				0x50, 0x69, 0x94, 0x00, 0x00, 0x5f, 0x52, 0x0f, 0x00, 0x73, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x73, 0x81, 0xb7, 0xc0, 0x0a, 0xf0, 0x00, 0x00, 0xff,
+				0x04, 0xc0, 0x00, 0xff, 0x00, 0xff, 0xc1, 0x06, 0x01, 0x08, 0x00, 0x00, 0x11, 0x03, 0xc2, 0x06,
+				0x01, 0x08, 0x00, 0x00, 0x11, 0x03, 0xc3, 0x06, 0x01, 0x08, 0x00, 0x00, 0x11, 0x03, 0xc4, 0x07,
+				0x00, 0x7f, 0x7f, 0x7f, 0x00, 0x03, 0x03, 0xc5, 0x3c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0xc6, 0x3c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0xcd, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x90, 0x00,
+			},
+			{0x7a, 0x05, 0x93, 0x03, 0x00, 0x00, 0x00, 0x90, 0x00},
+			{0x05, 0x02, 0x06},
+		},
+	}
+}
+
 func TestGpg_Good(t *testing.T) {
 	t.Parallel()
 
@@ -576,6 +582,69 @@ func TestGpgData_Origin(t *testing.T) {
 	}
 }
 
+func TestGpgData_KeyInfo(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []OpenPGPKeyInfo
+		err      error
+	}{
+		{name: "missing", err: ErrNoSuchTag},
+		{name: "odd length", data: []byte{0x01, 0x01, 0x02}, err: ErrTooShort},
+		{name: "unknown status", data: []byte{0x01, 0x03}, err: ErrUnknownKeyOrigin},
+		{
+			name: "three keys",
+			data: []byte{0x01, 0x01, 0x02, 0x02, 0x03, 0x00},
+			expected: []OpenPGPKeyInfo{
+				{KeyRef: 1, Status: KeyGeneratedByCard},
+				{KeyRef: 2, Status: KeyImportedToCard},
+				{KeyRef: 3, Status: KeyNotPresent},
+			},
+		},
+		{
+			name: "additional key",
+			data: []byte{0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x81, 0x02},
+			expected: []OpenPGPKeyInfo{
+				{KeyRef: 1, Status: KeyNotPresent},
+				{KeyRef: 2, Status: KeyNotPresent},
+				{KeyRef: 3, Status: KeyNotPresent},
+				{KeyRef: 0x81, Status: KeyImportedToCard},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := GpgData{tlvValues: bertlv.TLVData{}}
+			if tc.data != nil {
+				g.tlvValues[keyOriginAttributesTag] = tc.data
+			}
+
+			info, err := g.KeyInfo()
+			if !expectedError(t, err, tc.err) || tc.err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(info, tc.expected) {
+				t.Errorf("got %+v expected %+v", info, tc.expected)
+			}
+
+			for _, k := range info {
+				keyType, ok := k.KeyType()
+				if ok != (k.KeyRef <= 3) || (ok && keyType != KeyType(k.KeyRef-1)) {
+					t.Errorf("key ref %d is %s %t", k.KeyRef, keyType, ok)
+				}
+			}
+		})
+	}
+}
+
 func Test_gpgLogin(t *testing.T) {
 	t.Parallel()
 
@@ -798,3 +867,122 @@ func Test_ParseCardHolderName(t *testing.T) {
 		})
 	}
 }
+
+func TestGpgData_SignatureCount(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected int
+		counted  int
+		err      error
+	}{
+		{name: "missing", err: ErrNoSuchTag},
+		{name: "too short", data: []byte{0x00, 0x01}, err: ErrTooShort},
+		{name: "zero", data: []byte{0x00, 0x00, 0x00}, expected: 0, counted: 1},
+		{name: "carry", data: []byte{0x00, 0x01, 0xff}, expected: 0x1ff, counted: 0x200},
+		{name: "saturated", data: []byte{0xff, 0xff, 0xff}, expected: 0xffffff, counted: 0xffffff},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := GpgData{tlvValues: bertlv.TLVData{}}
+			if tc.data != nil {
+				g.tlvValues[signatureCounterTag] = tc.data
+			}
+
+			count, err := g.SignatureCount()
+			if !expectedError(t, err, tc.err) || tc.err != nil {
+				return
+			}
+
+			if count != tc.expected {
+				t.Errorf("got %d expected %d", count, tc.expected)
+			}
+
+			g.countSignature()
+
+			count, err = g.SignatureCount()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != tc.counted {
+				t.Errorf("after signing got %d expected %d", count, tc.counted)
+			}
+		})
+	}
+}
+
+func TestGpgData_ExtendedLengthSupported(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		historical []byte
+		expected   bool
+	}{
+		{name: "missing"},
+		{name: "yubikey", historical: []byte{0x00, 0x73, 0x00, 0x00, 0x80, 0x05, 0x90, 0x00}},
+		{name: "extended", historical: []byte{0x00, 0x31, 0xc5, 0x73, 0xc0, 0x01, 0x40, 0x05, 0x90, 0x00}, expected: true},
+		{name: "category 80", historical: []byte{0x80, 0x73, 0x00, 0x00, 0xc0}, expected: true},
+		{name: "truncated", historical: []byte{0x80, 0x73, 0x00}},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := GpgData{tlvValues: bertlv.TLVData{}}
+			if tc.historical != nil {
+				g.tlvValues[historicalBytesTag] = tc.historical
+			}
+
+			if got := g.ExtendedLengthSupported(); got != tc.expected {
+				t.Errorf("got %t expected %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGpgData_ExtendedLengthInformation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		info        []byte
+		maxCommand  int
+		maxResponse int
+		ok          bool
+	}{
+		{name: "missing"},
+		{name: "yubikey", info: []byte{0x02, 0x02, 0x08, 0x00, 0x02, 0x02, 0x08, 0x00}, maxCommand: 2048, maxResponse: 2048, ok: true},
+		{name: "short", info: []byte{0x02, 0x02, 0x08, 0x00}},
+		{name: "wrong tag", info: []byte{0x04, 0x02, 0x08, 0x00, 0x02, 0x02, 0x08, 0x00}},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := GpgData{tlvValues: bertlv.TLVData{}}
+			if tc.info != nil {
+				g.tlvValues[extendedLengthInformationTag] = tc.info
+			}
+
+			maxCommand, maxResponse, ok := g.ExtendedLengthInformation()
+			if maxCommand != tc.maxCommand || maxResponse != tc.maxResponse || ok != tc.ok {
+				t.Errorf("got %d %d %t expected %d %d %t", maxCommand, maxResponse, ok, tc.maxCommand, tc.maxResponse, tc.ok)
+			}
+		})
+	}
+}
@@ -78,6 +78,17 @@ const (
 	securityOperationEncipherParam1 = 0x86 // 86 = Return enciphered data with Padding indicator byte
 	securityOperationEncipherParam2 = 0x80 // 80 = Plain data present in the data field
 
+	// insInternalAuthenticate signs with the authentication key.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 72
+	// 7.2.13 INTERNAL AUTHENTICATE.
+	// must have performed PW2 auth first.
+	insInternalAuthenticate = 0x88
+
+	// insManageSecurityEnvironment changes the key used by PSO:DECIPHER or INTERNAL AUTHENTICATE.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 79
+	// 7.2.18 MANAGE SECURITY ENVIRONMENT.
+	insManageSecurityEnvironment = 0x22
+
 	// cardHolderDataTag is used with insGetDataA to get the Cardholder Related Data.
 	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 22.
 	// 4.4.1 DOs for GET DATA.
@@ -100,6 +111,16 @@ const (
 	// This tag has bits to determine what is supported in 4.4.3.7 Extended Capabilities.
 	extendedCapabilitiesTag = "6E.73.C0"
 
+	// historicalBytesTag is 5F52, the historical bytes of the ATR.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 30
+	// 4.4.3.4 Historical bytes.
+	historicalBytesTag = "6E.2FD2"
+
+	// extendedLengthInformationTag is 7F66, the maximum number of bytes in a command and response APDU.
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+	// 4.4.1 DOs for GET DATA.
+	extendedLengthInformationTag = "6E.3FE6"
+
 	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23.
 	// 4.4.1 DOs for GET DATA.
 	// Application Related Data.
@@ -128,6 +149,14 @@ const (
 	keyInformationTag = "6E.73.C5"
 	keyFingerprintLen = 20
 
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23.
+	// 4.4.1 DOs for GET DATA.
+	// Application Related Data.
+	// 6E.73.C4 == PW Status Bytes, 7 bytes: PW1 valid for several signatures, max length and format of PW1,
+	// max length of the resetting code, max length and format of PW3, retry counters of PW1, RC and PW3.
+	pwStatusTag = "6E.73.C4"
+	pwStatusLen = 7
+
 	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 24.
 	// 4.4.1 DOs for GET DATA.
 	// Application Related Data.
@@ -149,6 +178,36 @@ const (
 	// 02 = Key imported into the card.
 	keyOriginAttributesTag = "6E.73.DE"
 
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23.
+	// 4.4.1 DOs for GET DATA.
+	// Cardholder Related Data.
+	// 65.5B == Name, 65.5F2D == Language preferences, 65.5F35 == Salutation.
+	cardHolderNameTag = "65.5B"
+	languagePrefsTag  = "65.5F2D"
+	salutationTag     = "65.5F35"
+	caFingerprintsTag = "6E.73.C6"
+	applicationIDTag  = "6E.4F"
+
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23.
+	// 4.4.1 DOs for GET DATA.
+	// 5E == Login data, 5F50 == URL of the public keys, they're read on their own.
+	loginDataTag = "5E"
+	urlTag       = "5F50"
+
+	// https://developers.yubico.com/PGP/Card_edit.html
+	// Application Related Data.
+	// 6E.73.D6-D8 == User Interaction Flag of the Sig, Dec and Aut key, YubiKeys with a button report them.
+	keySignatureUIFTag      = "6E.73.D6"
+	keyDecryptionUIFTag     = "6E.73.D7"
+	keyAuthenticationUIFTag = "6E.73.D8"
+
+	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 24.
+	// 4.4.1 DOs for GET DATA.
+	// Security support template.
+	// 7A.93 == Digital signature counter, 3 bytes binary, counts usage of Compute Digital Signature command.
+	signatureCounterTag = "7A.93"
+	signatureCounterLen = 3
+
 	// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 74
 	// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=95
 	paramOpenGPGAsymmetricGenerate = paramAsymmetricCryptoMechanism // 0x80
@@ -162,6 +221,7 @@ const (
 	// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=95
 	openGpgModulusTag  = "3FC9.81"
 	openGpgExponentTag = "3FC9.82"
+	openGpgECPointTag  = "3FC9.86"
 
 	// 7.2 Commands in Detail.
 	// 7.2.2 VERIFY.
@@ -441,6 +501,8 @@ var (
 	ErrNoPublicKeyExponent = errors.New("crypto/rsa: missing public exponent")
 	ErrPublicExponentSmall = errors.New("crypto/rsa: public exponent too small")
 	ErrPublicExponentLarge = errors.New("crypto/rsa: public exponent too large")
+	ErrNoPublicKeyPoint    = errors.New("missing public key point")
+	ErrNotECDHKey          = errors.New("key is not an ECDH key")
 )
 
 // GpgData holds data about the GPG functionality of the card.
@@ -483,9 +545,13 @@ type GpgData struct {
 	// SerialInt is an integer of the serial number.
 	SerialInt uint32
 	// Serial is a hex string of the serial number as displayed by ykman list.
-	Serial      string
-	LongName    string
-	CardHolder  string
+	Serial     string
+	LongName   string
+	CardHolder string
+	// LoginData is the login data DO (5E), the user name for a remote login.
+	LoginData string
+	// URL is where the public keys can be downloaded (5F50), see FetchPublicKey.
+	URL         string
 	Rid         string
 	Application string
 	Version     string
@@ -497,6 +563,14 @@ type GpgData struct {
 	MaximumChallengeLength              uint16
 	MaximumCardholderCertificatesLength uint16
 	MaximumSpecialDOsLength             uint16
+	// ManufacturerID is the manufacturer from bytes 9-10 of the AID.
+	ManufacturerID uint16
+	// Quirks holds where the card differs from a YubiKey.
+	Quirks CardQuirks
+	// MalformedTags are the data objects dropped because of their length, see ParseLenient.
+	MalformedTags []string
+	// parseMode is how the data objects were checked, it's used again when they're reread.
+	parseMode ParseMode
 	// tlvValues holds the raw data from the card.
 	tlvValues bertlv.TLVData
 }
@@ -521,6 +595,8 @@ func (g *GpgData) Copy(src *GpgData) {
 	g.Serial = src.Serial
 	g.LongName = src.LongName
 	g.CardHolder = src.CardHolder
+	g.LoginData = src.LoginData
+	g.URL = src.URL
 	g.Rid = src.Rid
 	g.Application = src.Application
 	g.Version = src.Version
@@ -531,6 +607,8 @@ func (g *GpgData) Copy(src *GpgData) {
 	g.MaximumChallengeLength = src.MaximumChallengeLength
 	g.MaximumCardholderCertificatesLength = src.MaximumCardholderCertificatesLength
 	g.MaximumSpecialDOsLength = src.MaximumSpecialDOsLength
+	g.ManufacturerID = src.ManufacturerID
+	g.Quirks = src.Quirks
 	if src.tlvValues != nil {
 		g.tlvValues = src.tlvValues
 	} else {
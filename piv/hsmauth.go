@@ -0,0 +1,372 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// The YubiHSM Auth applet stores long lived YubiHSM 2 credentials on the YubiKey and derives
+// the SCP03 session keys on card, so the credential itself never leaves the YubiKey.
+// https://docs.yubico.com/yesdk/users-manual/application-yubihsm-auth/yubihsm-auth-overview.html
+// https://github.com/Yubico/yubikey-manager/blob/main/yubikit/hsmauth.py
+
+// nolint:gochecknoglobals
+var aidHSMAuth = [...]byte{0xa0, 0x00, 0x00, 0x05, 0x27, 0x21, 0x07, 0x01}
+
+const (
+	insHSMAuthPut                     = 0x01
+	insHSMAuthDelete                  = 0x02
+	insHSMAuthCalculate               = 0x03
+	insHSMAuthGetChallenge            = 0x04
+	insHSMAuthList                    = 0x05
+	insHSMAuthReset                   = 0x06
+	insHSMAuthGetVersion              = 0x07
+	insHSMAuthPutManagementKey        = 0x08
+	insHSMAuthGetManagementKeyRetries = 0x09
+
+	tagHSMAuthLabel              = 0x71
+	tagHSMAuthLabelList          = 0x72
+	tagHSMAuthCredentialPassword = 0x73
+	tagHSMAuthAlgorithm          = 0x74
+	tagHSMAuthKeyEnc             = 0x75
+	tagHSMAuthKeyMac             = 0x76
+	tagHSMAuthContext            = 0x77
+	tagHSMAuthTouch              = 0x7a
+	tagHSMAuthManagementKey      = 0x7b
+
+	// HSMAuthKeyLen is the length of the management key, credential password and the SCP03 keys.
+	HSMAuthKeyLen = 16
+
+	hsmAuthMinLabelLen = 1
+	hsmAuthMaxLabelLen = 64
+
+	// hsmAuthContextLen is the host challenge followed by the card challenge.
+	hsmAuthContextLen = 16
+)
+
+// HSMAuthAlgorithm is the algorithm of a YubiHSM Auth credential.
+type HSMAuthAlgorithm byte
+
+const (
+	// HSMAuthAES128 is a symmetric credential, a pair of AES-128 keys (ENC and MAC).
+	HSMAuthAES128 HSMAuthAlgorithm = 38
+	// HSMAuthECP256 is an asymmetric credential, a private key on curve P-256.
+	HSMAuthECP256 HSMAuthAlgorithm = 39
+)
+
+func (a HSMAuthAlgorithm) String() string {
+	switch a {
+	case HSMAuthAES128:
+		return "AES128-YUBICO-AUTHENTICATION"
+	case HSMAuthECP256:
+		return "EC-P256-YUBICO-AUTHENTICATION"
+	default:
+		return fmt.Sprintf("HSMAuthAlgorithm(%d)", byte(a))
+	}
+}
+
+var (
+	ErrHSMAuthBadLabel    = errors.New("credential label must be between 1 and 64 bytes")
+	ErrHSMAuthBadPassword = errors.New("credential password must be at most 16 bytes")
+	ErrHSMAuthBadContext  = errors.New("context must be 16 bytes, host challenge followed by card challenge")
+)
+
+// DefaultHSMAuthManagementKey is the management key of a YubiHSM Auth applet that has not been configured.
+// nolint:gochecknoglobals
+var DefaultHSMAuthManagementKey = [HSMAuthKeyLen]byte{}
+
+// HSMAuth is an exclusive open connection to the YubiHSM Auth applet of a YubiKey.
+//
+// To release the connection, call the Close method.
+type HSMAuth struct {
+	ctx     SCContext
+	h       SCHandle
+	tx      SCTx
+	version *version
+}
+
+// HSMAuthCredential describes a credential stored in the YubiHSM Auth applet.
+type HSMAuthCredential struct {
+	Label     string
+	Algorithm HSMAuthAlgorithm
+	// Touch is true if the YubiKey must be touched to calculate session keys.
+	Touch bool
+	// Retries is the number of credential password attempts left.
+	Retries int
+}
+
+// HSMAuthSessionKeys are the SCP03 session keys for a YubiHSM 2 session.
+type HSMAuthSessionKeys struct {
+	SENC  [HSMAuthKeyLen]byte
+	SMAC  [HSMAuthKeyLen]byte
+	SRMAC [HSMAuthKeyLen]byte
+}
+
+// OpenHSMAuth connects to the YubiHSM Auth applet of a YubiKey.
+func OpenHSMAuth(card string) (*HSMAuth, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenHSMAuth(card)
+}
+
+// OpenHSMAuth connects to the YubiHSM Auth applet of a YubiKey.
+func (c *Client) OpenHSMAuth(card string) (*HSMAuth, error) {
+	ctx, h, tx, _, err := c.connectApplet(card, aidHSMAuth[:])
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := loadYkVersion(tx, insHSMAuthGetVersion)
+	if err != nil {
+		tx.Close()
+		closeHandles(ctx, h)
+
+		return nil, fmt.Errorf("getting yubihsm auth version: %w", err)
+	}
+
+	return &HSMAuth{
+		ctx:     ctx,
+		h:       h,
+		tx:      tx,
+		version: v,
+	}, nil
+}
+
+// Close releases the connection to the smart card.
+func (a *HSMAuth) Close() error {
+	return closeHandles(a.ctx, a.h)
+}
+
+// Version returns the version reported by the YubiHSM Auth applet.
+func (a *HSMAuth) Version() Version {
+	return a.version.Version()
+}
+
+// Credentials lists the credentials stored in the applet.
+func (a *HSMAuth) Credentials() ([]HSMAuthCredential, error) {
+	return hsmAuthList(a.tx)
+}
+
+// SessionKeys derives the SCP03 session keys of a symmetric credential.
+// context is the 8 byte host challenge followed by the 8 byte card challenge, password is the credential password.
+// If the credential was stored with touch required this call blocks until the YubiKey is touched.
+func (a *HSMAuth) SessionKeys(label string, context, password []byte) (*HSMAuthSessionKeys, error) {
+	return hsmAuthCalculate(a.tx, label, context, password)
+}
+
+// Challenge returns the card challenge for a credential.
+// For asymmetric credentials this is the ephemeral public key of the YubiKey.
+func (a *HSMAuth) Challenge(label string) ([]byte, error) {
+	data, err := hsmAuthLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.tx.Transmit(apdu{instruction: insHSMAuthGetChallenge, data: data})
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// PutSymmetricCredential stores an AES-128 credential under label.
+func (a *HSMAuth) PutSymmetricCredential(managementKey [HSMAuthKeyLen]byte, label string, keyEnc, keyMac [HSMAuthKeyLen]byte, password []byte, touch bool) error {
+	return hsmAuthPut(a.tx, managementKey, label, keyEnc, keyMac, password, touch)
+}
+
+// DeleteCredential removes the credential stored under label.
+func (a *HSMAuth) DeleteCredential(managementKey [HSMAuthKeyLen]byte, label string) error {
+	l, err := hsmAuthLabel(label)
+	if err != nil {
+		return err
+	}
+
+	data := append(marshalASN1(tagHSMAuthManagementKey, managementKey[:]), l...)
+	if _, err := a.tx.Transmit(apdu{instruction: insHSMAuthDelete, data: data}); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
+
+// SetManagementKey replaces the management key of the applet.
+func (a *HSMAuth) SetManagementKey(oldKey, newKey [HSMAuthKeyLen]byte) error {
+	data := append(marshalASN1(tagHSMAuthManagementKey, oldKey[:]), marshalASN1(tagHSMAuthManagementKey, newKey[:])...)
+	if _, err := a.tx.Transmit(apdu{instruction: insHSMAuthPutManagementKey, data: data}); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
+
+// ManagementKeyRetries returns the number of management key attempts left.
+func (a *HSMAuth) ManagementKeyRetries() (int, error) {
+	resp, err := a.tx.Transmit(apdu{instruction: insHSMAuthGetManagementKeyRetries})
+	if err != nil {
+		return 0, fmt.Errorf("command failed: %w", err)
+	}
+
+	if len(resp) != 1 {
+		return 0, fmt.Errorf("expected 1 byte response, got %d: %w", len(resp), ErrTooShort)
+	}
+
+	return int(resp[0]), nil
+}
+
+// Reset deletes all credentials and restores the default management key.
+func (a *HSMAuth) Reset() error {
+	if _, err := a.tx.Transmit(apdu{instruction: insHSMAuthReset, param1: 0xde, param2: 0xad}); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
+
+func hsmAuthLabel(label string) ([]byte, error) {
+	if len(label) < hsmAuthMinLabelLen || len(label) > hsmAuthMaxLabelLen {
+		return nil, fmt.Errorf("%w: got %d", ErrHSMAuthBadLabel, len(label))
+	}
+
+	return marshalASN1(tagHSMAuthLabel, []byte(label)), nil
+}
+
+// hsmAuthPassword pads the credential password with zeros to 16 bytes.
+func hsmAuthPassword(password []byte) ([]byte, error) {
+	if len(password) > HSMAuthKeyLen {
+		return nil, fmt.Errorf("%w: got %d", ErrHSMAuthBadPassword, len(password))
+	}
+
+	padded := make([]byte, HSMAuthKeyLen)
+	copy(padded, password)
+
+	return marshalASN1(tagHSMAuthCredentialPassword, padded), nil
+}
+
+func hsmAuthList(tx SCTx) ([]HSMAuthCredential, error) {
+	resp, err := tx.Transmit(apdu{instruction: insHSMAuthList})
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	return parseHSMAuthCredentials(resp)
+}
+
+// parseHSMAuthCredentials parses the list response, each entry is
+// 72 len algorithm touch label... retries.
+func parseHSMAuthCredentials(b []byte) ([]HSMAuthCredential, error) {
+	var creds []HSMAuthCredential
+
+	for len(b) > 0 {
+		var v asn1.RawValue
+
+		rest, err := asn1.Unmarshal(b, &v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing credential list: %w", err)
+		}
+
+		b = rest
+
+		if v.FullBytes[0] != tagHSMAuthLabelList {
+			continue
+		}
+
+		if len(v.Bytes) < 3+hsmAuthMinLabelLen {
+			return nil, fmt.Errorf("credential entry of %d bytes: %w", len(v.Bytes), ErrTooShort)
+		}
+
+		creds = append(creds, HSMAuthCredential{
+			Algorithm: HSMAuthAlgorithm(v.Bytes[0]),
+			Touch:     v.Bytes[1] != 0,
+			Label:     string(v.Bytes[2 : len(v.Bytes)-1]),
+			Retries:   int(v.Bytes[len(v.Bytes)-1]),
+		})
+	}
+
+	return creds, nil
+}
+
+func hsmAuthCalculate(tx SCTx, label string, context, password []byte) (*HSMAuthSessionKeys, error) {
+	if len(context) != hsmAuthContextLen {
+		return nil, fmt.Errorf("%w: got %d", ErrHSMAuthBadContext, len(context))
+	}
+
+	data, err := hsmAuthLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := hsmAuthPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	data = append(data, marshalASN1(tagHSMAuthContext, context)...)
+	data = append(data, pw...)
+
+	resp, err := tx.Transmit(apdu{instruction: insHSMAuthCalculate, data: data})
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	if len(resp) < 3*HSMAuthKeyLen {
+		return nil, fmt.Errorf("session keys of %d bytes: %w", len(resp), ErrTooShort)
+	}
+
+	keys := &HSMAuthSessionKeys{}
+	copy(keys.SENC[:], resp[:HSMAuthKeyLen])
+	copy(keys.SMAC[:], resp[HSMAuthKeyLen:2*HSMAuthKeyLen])
+	copy(keys.SRMAC[:], resp[2*HSMAuthKeyLen:3*HSMAuthKeyLen])
+
+	return keys, nil
+}
+
+func hsmAuthPut(tx SCTx, managementKey [HSMAuthKeyLen]byte, label string, keyEnc, keyMac [HSMAuthKeyLen]byte, password []byte, touch bool) error {
+	l, err := hsmAuthLabel(label)
+	if err != nil {
+		return err
+	}
+
+	pw, err := hsmAuthPassword(password)
+	if err != nil {
+		return err
+	}
+
+	var touchByte byte
+	if touch {
+		touchByte = 1
+	}
+
+	data := marshalASN1(tagHSMAuthManagementKey, managementKey[:])
+	data = append(data, l...)
+	data = append(data, marshalASN1(tagHSMAuthAlgorithm, []byte{byte(HSMAuthAES128)})...)
+	data = append(data, marshalASN1(tagHSMAuthKeyEnc, keyEnc[:])...)
+	data = append(data, marshalASN1(tagHSMAuthKeyMac, keyMac[:])...)
+	data = append(data, pw...)
+	data = append(data, marshalASN1(tagHSMAuthTouch, []byte{touchByte})...)
+
+	if _, err := tx.Transmit(apdu{instruction: insHSMAuthPut, data: data}); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
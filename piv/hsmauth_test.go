@@ -0,0 +1,157 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func hsmAuthTestClient(tb testing.TB, apdus []apdu, responses [][]byte) *Client {
+	tb.Helper()
+
+	apdus = append([]apdu{
+		{instruction: insSelectApplication, param1: 0x04, data: aidHSMAuth[:]},
+		{instruction: insHSMAuthGetVersion},
+	}, apdus...)
+	responses = append([][]byte{{}, {0x05, 0x04, 0x03}}, responses...)
+
+	return CreateTestClient(tb, nil, nil, &TestSCHandle{
+		Ctx: &TestSCTx{
+			APDUList:     apdus,
+			ResponseList: responses,
+		},
+	})
+}
+
+func TestHSMAuth_Open(t *testing.T) {
+	t.Parallel()
+
+	a, err := hsmAuthTestClient(t, nil, nil).OpenHSMAuth("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if v := a.Version(); v != (Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("unexpected version %+v", v)
+	}
+}
+
+func TestHSMAuth_Credentials(t *testing.T) {
+	t.Parallel()
+
+	list := []byte{
+		0x72, 0x07, byte(HSMAuthAES128), 0x01, 'a', 'b', 'c', 'd', 0x08,
+		0x72, 0x04, byte(HSMAuthECP256), 0x00, 'x', 0x00,
+	}
+
+	a, err := hsmAuthTestClient(t, []apdu{{instruction: insHSMAuthList}}, [][]byte{list}).OpenHSMAuth("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	creds, err := a.Credentials()
+	expectedError(t, err, nil)
+
+	expected := []HSMAuthCredential{
+		{Label: "abcd", Algorithm: HSMAuthAES128, Touch: true, Retries: 8},
+		{Label: "x", Algorithm: HSMAuthECP256, Touch: false, Retries: 0},
+	}
+
+	if !reflect.DeepEqual(creds, expected) {
+		t.Errorf("got %+v expected %+v", creds, expected)
+	}
+}
+
+func TestHSMAuth_ParseCredentials_Fail(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseHSMAuthCredentials([]byte{0x72, 0x02, 0x26, 0x00})
+	expectedError(t, err, ErrTooShort)
+}
+
+func TestHSMAuth_SessionKeys(t *testing.T) {
+	t.Parallel()
+
+	context := bytes.Repeat([]byte{0xc0}, hsmAuthContextLen)
+	password := []byte("password")
+	padded := make([]byte, HSMAuthKeyLen)
+	copy(padded, password)
+
+	data := marshalASN1(tagHSMAuthLabel, []byte("label"))
+	data = append(data, marshalASN1(tagHSMAuthContext, context)...)
+	data = append(data, marshalASN1(tagHSMAuthCredentialPassword, padded)...)
+
+	resp := append(bytes.Repeat([]byte{0x01}, HSMAuthKeyLen), bytes.Repeat([]byte{0x02}, HSMAuthKeyLen)...)
+	resp = append(resp, bytes.Repeat([]byte{0x03}, HSMAuthKeyLen)...)
+
+	a, err := hsmAuthTestClient(t, []apdu{{instruction: insHSMAuthCalculate, data: data}}, [][]byte{resp}).OpenHSMAuth("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	keys, err := a.SessionKeys("label", context, password)
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if keys.SENC[0] != 0x01 || keys.SMAC[0] != 0x02 || keys.SRMAC[HSMAuthKeyLen-1] != 0x03 {
+		t.Errorf("unexpected session keys %+v", keys)
+	}
+}
+
+func TestHSMAuth_SessionKeys_Fail(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		label    string
+		context  []byte
+		password []byte
+		err      error
+	}{
+		{
+			name:    "short context",
+			label:   "label",
+			context: []byte{0x01},
+			err:     ErrHSMAuthBadContext,
+		},
+		{
+			name:    "empty label",
+			context: make([]byte, hsmAuthContextLen),
+			err:     ErrHSMAuthBadLabel,
+		},
+		{
+			name:     "long password",
+			label:    "label",
+			context:  make([]byte, hsmAuthContextLen),
+			password: make([]byte, HSMAuthKeyLen+1),
+			err:      ErrHSMAuthBadPassword,
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := hsmAuthCalculate(&TestSCTx{}, tc.label, tc.context, tc.password)
+			expectedError(t, err, tc.err)
+		})
+	}
+}
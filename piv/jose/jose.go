@@ -0,0 +1,305 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jose signs JWS and JWT with keys on a YubiKey and describes their public keys as JWK.
+//
+// A Signer wraps the crypto.Signer of a PIV slot or of the OpenPGP applet with the JWS algorithm
+// for its key: RS256 (or PS256) for RSA, ES256 and ES384 for P-256 and P-384, EdDSA for Ed25519.
+// Its SignPayload has the shape of the opaque signers of JOSE libraries, e.g. go-jose's
+// OpaqueSigner, so they can be adapted without this package depending on them.
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// JWS algorithms of the keys a card has.
+const (
+	RS256 = "RS256"
+	RS384 = "RS384"
+	RS512 = "RS512"
+	PS256 = "PS256"
+	PS384 = "PS384"
+	PS512 = "PS512"
+	ES256 = "ES256"
+	ES384 = "ES384"
+	EdDSA = "EdDSA"
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned for keys and algorithms that can't be used together.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrMalformed is returned by Verify for tokens that aren't compact JWS.
+	ErrMalformed = errors.New("malformed JWS")
+	// ErrVerification is returned by Verify when the signature doesn't match.
+	ErrVerification = errors.New("signature verification failed")
+)
+
+// nolint:gochecknoglobals
+var algorithmHashes = map[string]crypto.Hash{
+	RS256: crypto.SHA256, RS384: crypto.SHA384, RS512: crypto.SHA512,
+	PS256: crypto.SHA256, PS384: crypto.SHA384, PS512: crypto.SHA512,
+	ES256: crypto.SHA256, ES384: crypto.SHA384,
+}
+
+// Signer signs JWS with a card key.
+type Signer struct {
+	crypto.Signer
+	// KeyID is the kid header, the thumbprint of the public key by default.
+	KeyID string
+
+	algorithm string
+}
+
+// NewSigner returns a signer with the default algorithm for the key of signer, RS256 for RSA keys.
+func NewSigner(signer crypto.Signer) (*Signer, error) {
+	var algorithm string
+
+	switch k := signer.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = RS256
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			algorithm = ES256
+		case 384:
+			algorithm = ES384
+		}
+	case ed25519.PublicKey:
+		algorithm = EdDSA
+	}
+
+	if algorithm == "" {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, signer.Public())
+	}
+
+	return NewSignerWithAlgorithm(signer, algorithm)
+}
+
+// NewSignerWithAlgorithm returns a signer for a JWS algorithm other than the default, e.g. PS256.
+func NewSignerWithAlgorithm(signer crypto.Signer, algorithm string) (*Signer, error) {
+	if err := checkAlgorithm(signer.Public(), algorithm); err != nil {
+		return nil, err
+	}
+
+	kid, err := Thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{Signer: signer, KeyID: kid, algorithm: algorithm}, nil
+}
+
+// checkAlgorithm checks that a JWS algorithm is for the key.
+func checkAlgorithm(pub crypto.PublicKey, algorithm string) error {
+	ok := false
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		ok = strings.HasPrefix(algorithm, "RS") || strings.HasPrefix(algorithm, "PS")
+	case *ecdsa.PublicKey:
+		ok = algorithm == ES256 && k.Curve.Params().BitSize == 256 || algorithm == ES384 && k.Curve.Params().BitSize == 384
+	case ed25519.PublicKey:
+		ok = algorithm == EdDSA
+	}
+
+	if _, known := algorithmHashes[algorithm]; !ok || (!known && algorithm != EdDSA) {
+		return fmt.Errorf("%w: %s with %T", ErrUnsupportedAlgorithm, algorithm, pub)
+	}
+
+	return nil
+}
+
+// Algorithm returns the alg header of the signatures.
+func (s *Signer) Algorithm() string {
+	return s.algorithm
+}
+
+// Algs returns the algorithm of the signer, the one SignPayload accepts.
+func (s *Signer) Algs() []string {
+	return []string{s.algorithm}
+}
+
+// JWK returns the public key as a JWK with the kid and alg of the signer.
+func (s *Signer) JWK() (*JWK, error) {
+	jwk, err := NewJWK(s.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	jwk.KeyID, jwk.Algorithm, jwk.Use = s.KeyID, s.algorithm, "sig"
+
+	return jwk, nil
+}
+
+// SignPayload returns the JWS signature of the signing input, which the card computes. ECDSA
+// signatures are r || s as JWS wants, not DER.
+func (s *Signer) SignPayload(payload []byte, algorithm string) ([]byte, error) {
+	if algorithm != s.algorithm {
+		return nil, fmt.Errorf("%w: %s, the signer uses %s", ErrUnsupportedAlgorithm, algorithm, s.algorithm)
+	}
+
+	if algorithm == EdDSA {
+		return s.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+
+	hash := algorithmHashes[algorithm]
+	h := hash.New()
+	h.Write(payload)
+	digest := h.Sum(nil)
+
+	var opts crypto.SignerOpts = hash
+	if strings.HasPrefix(algorithm, "PS") {
+		opts = &rsa.PSSOptions{Hash: hash, SaltLength: rsa.PSSSaltLengthEqualsHash}
+	}
+
+	sig, err := s.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if pub, ok := s.Public().(*ecdsa.PublicKey); ok {
+		return ecdsaRawSignature(pub, sig)
+	}
+
+	return sig, nil
+}
+
+// ecdsaRawSignature converts a DER signature to r || s, each the size of the curve.
+func ecdsaRawSignature(pub *ecdsa.PublicKey, der []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("parse signature: %w", err)
+	}
+
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+
+	return raw, nil
+}
+
+// header is the protected header of the JWS of Sign.
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// SignCompact returns the compact serialization of a JWS of payload.
+func (s *Signer) SignCompact(payload []byte) (string, error) {
+	return s.signCompact(payload, "")
+}
+
+// SignJWT returns a JWT of claims, which are marshaled to JSON.
+func (s *Signer) SignJWT(claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return s.signCompact(payload, "JWT")
+}
+
+func (s *Signer) signCompact(payload []byte, typ string) (string, error) {
+	h, err := json.Marshal(header{Algorithm: s.algorithm, KeyID: s.KeyID, Type: typ})
+	if err != nil {
+		return "", err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sig, err := s.SignPayload([]byte(input), s.algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks a compact JWS signed by the key of pub and returns its payload. The alg header has
+// to be an algorithm for the key, other headers aren't checked.
+func Verify(compact string, pub crypto.PublicKey) ([]byte, error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: %d parts", ErrMalformed, len(parts))
+	}
+
+	decoded := make([][]byte, 3)
+
+	for i, part := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+
+		decoded[i] = b
+	}
+
+	var h header
+	if err := json.Unmarshal(decoded[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrMalformed, err)
+	}
+
+	if err := checkAlgorithm(pub, h.Algorithm); err != nil {
+		return nil, err
+	}
+
+	if !verify(pub, h.Algorithm, []byte(parts[0]+"."+parts[1]), decoded[2]) {
+		return nil, ErrVerification
+	}
+
+	return decoded[1], nil
+}
+
+func verify(pub crypto.PublicKey, algorithm string, input, sig []byte) bool {
+	if k, ok := pub.(ed25519.PublicKey); ok {
+		return ed25519.Verify(k, input, sig)
+	}
+
+	hash := algorithmHashes[algorithm]
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(algorithm, "PS") {
+			return rsa.VerifyPSS(k, hash, digest, sig, nil) == nil
+		}
+
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+
+		return ecdsa.Verify(k, digest, new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:]))
+	}
+
+	return false
+}
@@ -0,0 +1,219 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jose
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// testSigner generates a key in the authentication slot of a test card.
+func testSigner(t *testing.T, alg piv.Algorithm) crypto.Signer {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotAuthentication, piv.Key{
+		Algorithm:   alg,
+		PINPolicy:   piv.PINPolicyOnce,
+		TouchPolicy: piv.TouchPolicyNever,
+	})
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	priv, err := yk.PrivateKey(piv.SlotAuthentication, pub, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+	if err != nil {
+		t.Fatalf("private key: %v", err)
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		t.Fatalf("%T is not a crypto.Signer", priv)
+	}
+
+	return signer
+}
+
+func TestSignJWT(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		alg       piv.Algorithm
+		algorithm string
+		kty       string
+	}{
+		{name: "ec256", alg: piv.AlgorithmEC256, algorithm: ES256, kty: "EC"},
+		{name: "ec384", alg: piv.AlgorithmEC384, algorithm: ES384, kty: "EC"},
+		{name: "rsa2048", alg: piv.AlgorithmRSA2048, algorithm: RS256, kty: "RSA"},
+		{name: "ed25519", alg: piv.AlgorithmEd25519, algorithm: EdDSA, kty: "OKP"},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			signer, err := NewSigner(testSigner(t, tc.alg))
+			if err != nil {
+				t.Fatalf("NewSigner: %v", err)
+			}
+
+			if signer.Algorithm() != tc.algorithm {
+				t.Errorf("algorithm %s, expected %s", signer.Algorithm(), tc.algorithm)
+			}
+
+			token, err := signer.SignJWT(map[string]string{"sub": "service"})
+			if err != nil {
+				t.Fatalf("SignJWT: %v", err)
+			}
+
+			payload, err := Verify(token, signer.Public())
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if string(payload) != `{"sub":"service"}` {
+				t.Errorf("payload %s", payload)
+			}
+
+			jwk, err := signer.JWK()
+			if err != nil {
+				t.Fatalf("JWK: %v", err)
+			}
+
+			if jwk.KeyType != tc.kty || jwk.Algorithm != tc.algorithm || jwk.KeyID != signer.KeyID {
+				t.Errorf("jwk %+v", jwk)
+			}
+
+			// the header names the key of the JWK.
+			var h header
+			if err := json.Unmarshal(decodeHeader(t, token), &h); err != nil {
+				t.Fatal(err)
+			}
+
+			if h.KeyID != jwk.KeyID || h.Type != "JWT" || h.Algorithm != tc.algorithm {
+				t.Errorf("header %+v", h)
+			}
+
+			tampered := token[:len(token)-4] + "AAAA"
+			if _, err := Verify(tampered, signer.Public()); !errors.Is(err, ErrVerification) {
+				t.Errorf("tampered token: %v, expected ErrVerification", err)
+			}
+		})
+	}
+}
+
+// decodeHeader returns the decoded header of a compact JWS.
+func decodeHeader(t *testing.T, token string) []byte {
+	t.Helper()
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestSignerWithAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	rsaSigner := testSigner(t, piv.AlgorithmRSA2048)
+
+	signer, err := NewSignerWithAlgorithm(rsaSigner, PS256)
+	if err != nil {
+		t.Fatalf("NewSignerWithAlgorithm: %v", err)
+	}
+
+	token, err := signer.SignCompact([]byte("payload"))
+	if err != nil {
+		t.Fatalf("SignCompact: %v", err)
+	}
+
+	if _, err := Verify(token, rsaSigner.Public()); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	if _, err := signer.SignPayload([]byte("payload"), RS256); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("SignPayload with another algorithm: %v", err)
+	}
+
+	if _, err := NewSignerWithAlgorithm(rsaSigner, ES256); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("ES256 with an RSA key: %v", err)
+	}
+
+	if _, err := Verify("a.b", rsaSigner.Public()); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Verify of two parts: %v", err)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7638 section 3.1.
+	n := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+
+	jwk := JWK{KeyType: "RSA", N: n, E: "AQAB", Algorithm: RS256, KeyID: "2011-04-29"}
+	if got, want := jwk.Thumbprint(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint %s, expected %s", got, want)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, err := Thumbprint(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(mustJWK(t, &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), `"kid":"`+kid+`"`) || !strings.Contains(string(b), `"e":"AQAB"`) {
+		t.Errorf("jwk %s", b)
+	}
+}
+
+func mustJWK(t *testing.T, pub crypto.PublicKey) *JWK {
+	t.Helper()
+
+	jwk, err := NewJWK(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jwk
+}
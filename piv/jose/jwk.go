@@ -0,0 +1,106 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key as a JSON Web Key, RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+}
+
+// NewJWK returns the JWK of an RSA, P-256, P-384 or Ed25519 public key, with the thumbprint as kid.
+func NewJWK(pub crypto.PublicKey) (*JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	var jwk JWK
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{KeyType: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{KeyType: "EC", Curve: k.Curve.Params().Name, X: b64(k.X.FillBytes(make([]byte, size))), Y: b64(k.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		jwk = JWK{KeyType: "OKP", Curve: "Ed25519", X: b64(k)}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, pub)
+	}
+
+	jwk.KeyID = jwk.Thumbprint()
+
+	return &jwk, nil
+}
+
+// Thumbprint returns the base64url SHA-256 thumbprint of the required members of the key, RFC 7638.
+func (j *JWK) Thumbprint() string {
+	// the required members in lexicographic order, without whitespace.
+	var members any
+
+	switch j.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.KeyType, j.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Curve, j.KeyType, j.X, j.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Curve, j.KeyType, j.X}
+	}
+
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of a public key, the kid of its JWK.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := NewJWK(pub)
+	if err != nil {
+		return "", err
+	}
+
+	return jwk.KeyID, nil
+}
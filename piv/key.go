@@ -16,6 +16,7 @@ package piv
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
@@ -450,6 +451,23 @@ const (
 	AlgorithmRSA2048
 )
 
+var algorithmStrings = map[Algorithm]string{
+	AlgorithmEC256:   "EC256",
+	AlgorithmEC384:   "EC384",
+	AlgorithmEd25519: "Ed25519",
+	AlgorithmRSA1024: "RSA1024",
+	AlgorithmRSA2048: "RSA2048",
+}
+
+// String returns the name of the algorithm, or a fallback value for an
+// unknown algorithm.
+func (a Algorithm) String() string {
+	if s, ok := algorithmStrings[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(a))
+}
+
 // PINPolicy represents PIN requirements when signing or decrypting with an
 // asymmetric key in a given slot.
 type PINPolicy int
@@ -465,6 +483,21 @@ const (
 	PINPolicyAlways
 )
 
+var pinPolicyStrings = map[PINPolicy]string{
+	PINPolicyNever:  "never",
+	PINPolicyOnce:   "once",
+	PINPolicyAlways: "always",
+}
+
+// String returns the name of the PIN policy, or a fallback value for an
+// unknown policy.
+func (p PINPolicy) String() string {
+	if s, ok := pinPolicyStrings[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(p))
+}
+
 // TouchPolicy represents proof-of-presence requirements when signing or
 // decrypting with asymmetric key in a given slot.
 type TouchPolicy int
@@ -476,6 +509,21 @@ const (
 	TouchPolicyCached
 )
 
+var touchPolicyStrings = map[TouchPolicy]string{
+	TouchPolicyNever:  "never",
+	TouchPolicyAlways: "always",
+	TouchPolicyCached: "cached",
+}
+
+// String returns the name of the touch policy, or a fallback value for an
+// unknown policy.
+func (t TouchPolicy) String() string {
+	if s, ok := touchPolicyStrings[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
 // Origin represents whether a key was generated on the hardware, or has been
 // imported into it.
 type Origin int
@@ -486,6 +534,20 @@ const (
 	OriginImported
 )
 
+var originStrings = map[Origin]string{
+	OriginGenerated: "generated",
+	OriginImported:  "imported",
+}
+
+// String returns where the key came from, or a fallback value for an unknown
+// origin.
+func (o Origin) String() string {
+	if s, ok := originStrings[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(o))
+}
+
 const (
 	tagPINPolicy   = 0xaa
 	tagTouchPolicy = 0xab
@@ -560,12 +622,15 @@ func (yk *YubiKey) AttestationCertificate() (*x509.Certificate, error) {
 //
 // If the slot doesn't have a key, the returned error wraps ErrNotFound.
 func (yk *YubiKey) Attest(slot Slot) (*x509.Certificate, error) {
+	if yk.quirks.NoAttestation {
+		return nil, fmt.Errorf("%s: %w", yk.quirks.Name, ErrNotSupportedByCard)
+	}
 	cert, err := ykAttest(yk.tx, slot)
 	if err == nil {
 		return cert, nil
 	}
 	var e *apduErr
-	if errors.As(err, &e) && e.sw1 == 0x6A && e.sw2 == 0x80 {
+	if errors.As(err, &e) && e.Status() == 0x6a80 {
 		return nil, ErrNotFound
 	}
 	return nil, err
@@ -662,6 +727,8 @@ func (ki *KeyInfo) unmarshal(b []byte) error {
 
 // KeyInfo returns public information about the given key slot. It is only
 // supported by YubiKeys with a version >= 5.3.0.
+//
+// If the slot doesn't have a key, the returned error wraps ErrNotFound.
 func (yk *YubiKey) KeyInfo(slot Slot) (KeyInfo, error) {
 	// https://developers.yubico.com/PIV/Introduction/Yubico_extensions.html#_get_metadata
 	cmd := apdu{
@@ -670,6 +737,10 @@ func (yk *YubiKey) KeyInfo(slot Slot) (KeyInfo, error) {
 		param2:      byte(slot.Key),
 	}
 	resp, err := yk.tx.Transmit(cmd)
+	if apduStatus(err) == 0x6a88 {
+		// GET METADATA answers referenced data not found for an empty slot.
+		return KeyInfo{}, fmt.Errorf("command failed: %w: %w", ErrNotFound, err)
+	}
 	if err != nil {
 		return KeyInfo{}, fmt.Errorf("command failed: %w", err)
 	}
@@ -744,7 +815,7 @@ func marshalASN1(tag byte, data []byte) []byte {
 // certificate isn't required to use the associated key for signing or
 // decryption.
 func (yk *YubiKey) SetCertificate(key [24]byte, slot Slot, cert *x509.Certificate) error {
-	if err := ykAuthenticate(yk.tx, key, yk.rand); err != nil {
+	if err := yk.authManagementKey(key); err != nil {
 		return fmt.Errorf("authenticating with management key: %w", err)
 	}
 	return ykStoreCertificate(yk.tx, slot, cert)
@@ -798,7 +869,7 @@ type Key struct {
 // GenerateKey generates an asymmetric key on the card, returning the key's
 // public key.
 func (yk *YubiKey) GenerateKey(key [24]byte, slot Slot, opts Key) (crypto.PublicKey, error) {
-	if err := ykAuthenticate(yk.tx, key, yk.rand); err != nil {
+	if err := yk.authManagementKey(key); err != nil {
 		return nil, fmt.Errorf("authenticating with management key: %w", err)
 	}
 	return ykGenerateKey(yk.tx, slot, opts)
@@ -881,6 +952,9 @@ type KeyAuth struct {
 	// PINPrompt can be used to interactively request the PIN from the user. The
 	// method is only called when needed. For example, if a key specifies
 	// PINPolicyOnce, PINPrompt will only be called once per YubiKey struct.
+	//
+	// Without PIN and PINPrompt, the prompt and cache set with
+	// YubiKey.SetPINPrompt are used.
 	PINPrompt func() (pin string, err error)
 
 	// PINPolicy can be used to specify the PIN caching strategy for the slot. If
@@ -889,9 +963,18 @@ type KeyAuth struct {
 	// This field is required on older (<4.3.0) YubiKeys when using PINPrompt,
 	// as well as for keys imported to the card.
 	PINPolicy PINPolicy
+
+	// TouchCallback, if provided, is called before a key that requires a touch is
+	// used, so a UI can ask the user to tap the YubiKey. For TouchPolicyCached it's
+	// called even if the touch is still cached.
+	TouchCallback func()
+
+	// TouchPolicy can be used to specify the touch policy of the slot for
+	// TouchCallback. If not provided, this will be inferred like PINPolicy.
+	TouchPolicy TouchPolicy
 }
 
-func (k KeyAuth) authTx(yk *YubiKey, pp PINPolicy) error {
+func (k KeyAuth) authTx(ctx context.Context, yk *YubiKey, pp PINPolicy) error {
 	// PINPolicyNever shouldn't require a PIN.
 	if pp == PINPolicyNever {
 		return nil
@@ -904,29 +987,55 @@ func (k KeyAuth) authTx(yk *YubiKey, pp PINPolicy) error {
 		return nil
 	}
 
-	pin := k.PIN
-	if pin == "" && k.PINPrompt != nil {
-		p, err := k.PINPrompt()
-		if err != nil {
-			return fmt.Errorf("pin prompt: %v", err)
-		}
-		pin = p
+	pin, cached, err := k.pin(ctx, yk)
+	if err != nil {
+		return err
 	}
 	if pin == "" {
 		return fmt.Errorf("pin required but wasn't provided")
 	}
-	return ykLogin(yk.tx, pin)
+	if err := ykLogin(yk.tx, pin); err != nil {
+		yk.pins.cache.forget(yk.pins.card, PINRefPIV)
+		return err
+	}
+	if !cached && k.PIN == "" && k.PINPrompt == nil {
+		yk.pins.cache.put(yk.pins.card, PINRefPIV, pin)
+	}
+	return nil
+}
+
+// pin returns the PIN from KeyAuth, or from the prompt and cache of the YubiKey
+// when KeyAuth has neither PIN nor PINPrompt.
+func (k KeyAuth) pin(ctx context.Context, yk *YubiKey) (pin string, cached bool, err error) {
+	if k.PIN != "" {
+		return k.PIN, false, nil
+	}
+	if k.PINPrompt != nil {
+		p, err := k.PINPrompt()
+		if err != nil {
+			return "", false, fmt.Errorf("pin prompt: %v", err)
+		}
+		return p, false, nil
+	}
+	return yk.pins.pin(ctx, PINRefPIV)
 }
 
 func (k KeyAuth) do(yk *YubiKey, pp PINPolicy, f func(tx SCTx) ([]byte, error)) ([]byte, error) {
-	if err := k.authTx(yk, pp); err != nil {
+	return k.doContext(context.Background(), yk, pp, f)
+}
+
+func (k KeyAuth) doContext(ctx context.Context, yk *YubiKey, pp PINPolicy, f func(tx SCTx) ([]byte, error)) ([]byte, error) {
+	if err := k.authTx(ctx, yk, pp); err != nil {
 		return nil, err
 	}
+	if k.TouchCallback != nil && k.TouchPolicy != TouchPolicyNever {
+		k.TouchCallback()
+	}
 	return f(yk.tx)
 }
 
 func pinPolicy(yk *YubiKey, slot Slot) (PINPolicy, error) {
-	if supportsVersion(yk.Version(), 5, 3, 0) {
+	if yk.Features().SupportsMetadata() {
 		info, err := yk.KeyInfo(slot)
 		if err != nil {
 			return 0, fmt.Errorf("get key info: %v", err)
@@ -936,7 +1045,7 @@ func pinPolicy(yk *YubiKey, slot Slot) (PINPolicy, error) {
 	cert, err := yk.Attest(slot)
 	if err != nil {
 		var e *apduErr
-		if errors.As(err, &e) && e.sw1 == 0x6d && e.sw2 == 0x00 {
+		if errors.As(err, &e) && e.Status() == 0x6d00 {
 			// Attestation cert command not supported, probably an older YubiKey.
 			// Guess PINPolicyAlways.
 			//
@@ -955,6 +1064,31 @@ func pinPolicy(yk *YubiKey, slot Slot) (PINPolicy, error) {
 	return PINPolicyOnce, nil
 }
 
+func touchPolicy(yk *YubiKey, slot Slot) (TouchPolicy, error) {
+	if yk.Features().SupportsMetadata() {
+		info, err := yk.KeyInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("get key info: %v", err)
+		}
+		return info.TouchPolicy, nil
+	}
+	cert, err := yk.Attest(slot)
+	if err != nil {
+		var e *apduErr
+		if errors.As(err, &e) && e.Status() == 0x6d00 {
+			// Attestation cert command not supported, probably an older YubiKey.
+			// Guess TouchPolicyAlways so the callback isn't missed.
+			return TouchPolicyAlways, nil
+		}
+		return 0, fmt.Errorf("get attestation cert: %v", err)
+	}
+	a, err := parseAttestation(cert)
+	if err != nil {
+		return 0, fmt.Errorf("parse attestation cert: %v", err)
+	}
+	return a.TouchPolicy, nil
+}
+
 // PrivateKey is used to access signing and decryption options for the key
 // stored in the slot. The returned key implements crypto.Signer and/or
 // crypto.Decrypter depending on the key type.
@@ -973,7 +1107,7 @@ func (yk *YubiKey) PrivateKey(slot Slot, public crypto.PublicKey, auth KeyAuth)
 		// If the PIN policy is manually specified, trust that value instead of
 		// trying to use the attestation certificate.
 		pp = auth.PINPolicy
-	} else if auth.PIN != "" || auth.PINPrompt != nil {
+	} else if auth.PIN != "" || auth.PINPrompt != nil || yk.pins.prompt != nil || yk.pins.cache != nil {
 		// Attempt to determine the key's PIN policy. This helps inform the
 		// strategy for when to prompt for a PIN.
 		policy, err := pinPolicy(yk, slot)
@@ -982,6 +1116,13 @@ func (yk *YubiKey) PrivateKey(slot Slot, public crypto.PublicKey, auth KeyAuth)
 		}
 		pp = policy
 	}
+	if _, ok := touchPolicyMap[auth.TouchPolicy]; !ok && auth.TouchCallback != nil {
+		policy, err := touchPolicy(yk, slot)
+		if err != nil {
+			return nil, err
+		}
+		auth.TouchPolicy = policy
+	}
 
 	switch pub := public.(type) {
 	case *ecdsa.PublicKey:
@@ -1073,7 +1214,7 @@ func (yk *YubiKey) SetPrivateKeyInsecure(key [24]byte, slot Slot, private crypto
 		tags = append(tags, param...)
 	}
 
-	if err := ykAuthenticate(yk.tx, key, yk.rand); err != nil {
+	if err := yk.authManagementKey(key); err != nil {
 		return fmt.Errorf("authenticating with management key: %w", err)
 	}
 
@@ -1136,7 +1277,11 @@ var _ crypto.Signer = (*ECDSAPrivateKey)(nil)
 
 // Sign implements crypto.Signer.
 func (k *ECDSAPrivateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
-	return k.auth.do(k.yk, k.pp, func(tx SCTx) ([]byte, error) {
+	return k.sign(context.Background(), digest)
+}
+
+func (k *ECDSAPrivateKey) sign(ctx context.Context, digest []byte) ([]byte, error) {
+	return k.auth.doContext(ctx, k.yk, k.pp, func(tx SCTx) ([]byte, error) {
 		return ykSignECDSA(tx, k.slot, k.pub, digest)
 	})
 }
@@ -1206,7 +1351,11 @@ func (k *keyEd25519) Public() crypto.PublicKey {
 }
 
 func (k *keyEd25519) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
-	return k.auth.do(k.yk, k.pp, func(tx SCTx) ([]byte, error) {
+	return k.sign(context.Background(), digest)
+}
+
+func (k *keyEd25519) sign(ctx context.Context, digest []byte) ([]byte, error) {
+	return k.auth.doContext(ctx, k.yk, k.pp, func(tx SCTx) ([]byte, error) {
 		return skSignEd25519(tx, k.slot, k.pub, digest)
 	})
 }
@@ -1224,14 +1373,28 @@ func (k *keyRSA) Public() crypto.PublicKey {
 }
 
 func (k *keyRSA) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
-	return k.auth.do(k.yk, k.pp, func(tx SCTx) ([]byte, error) {
+	return k.sign(context.Background(), rand, digest, opts)
+}
+
+func (k *keyRSA) sign(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.auth.doContext(ctx, k.yk, k.pp, func(tx SCTx) ([]byte, error) {
 		return ykSignRSA(tx, rand, k.slot, k.pub, digest, opts)
 	})
 }
 
+// Decrypt accepts nil, *rsa.PKCS1v15DecryptOptions, *rsa.OAEPOptions or *DecryptOptions as opts.
+// The card returns the padded plain text, the padding is removed on the host.
 func (k *keyRSA) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
-	return k.auth.do(k.yk, k.pp, func(tx SCTx) ([]byte, error) {
-		return ykDecryptRSA(tx, k.slot, k.pub, msg)
+	return k.decrypt(context.Background(), msg, opts)
+}
+
+func (k *keyRSA) decrypt(ctx context.Context, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	o, err := decryptOptionsFrom(opts)
+	if err != nil {
+		return nil, err
+	}
+	return k.auth.doContext(ctx, k.yk, k.pp, func(tx SCTx) ([]byte, error) {
+		return ykDecryptRSA(tx, k.slot, k.pub, msg, o)
 	})
 }
 
@@ -1387,7 +1550,7 @@ func rsaAlg(pub *rsa.PublicKey) (byte, error) {
 	}
 }
 
-func ykDecryptRSA(tx SCTx, slot Slot, pub *rsa.PublicKey, data []byte) ([]byte, error) {
+func ykDecryptRSA(tx SCTx, slot Slot, pub *rsa.PublicKey, data []byte, opts *DecryptOptions) ([]byte, error) {
 	alg, err := rsaAlg(pub)
 	if err != nil {
 		return nil, err
@@ -1413,14 +1576,7 @@ func ykDecryptRSA(tx SCTx, slot Slot, pub *rsa.PublicKey, data []byte) ([]byte,
 	if err != nil {
 		return nil, fmt.Errorf("unmarshal response signature: %v", err)
 	}
-	// Decrypted blob contains a bunch of random data. Look for a NULL byte which
-	// indicates where the plain text starts.
-	for i := 2; i+1 < len(decrypted); i++ {
-		if decrypted[i] == 0x00 {
-			return decrypted[i+1:], nil
-		}
-	}
-	return nil, fmt.Errorf("invalid pkcs#1 v1.5 padding")
+	return opts.unpad(pub, decrypted)
 }
 
 // PKCS#1 v15 is largely informed by the standard library
@@ -1432,10 +1588,6 @@ func ykSignRSA(tx SCTx, rand io.Reader, slot Slot, pub *rsa.PublicKey, digest []
 		return nil, fmt.Errorf("input must be a hashed message")
 	}
 
-	if _, ok := opts.(*rsa.PSSOptions); ok {
-		return nil, fmt.Errorf("rsassa-pss signatures not supported")
-	}
-
 	alg, err := rsaAlg(pub)
 	if err != nil {
 		return nil, err
@@ -1354,3 +1354,23 @@ func ephemeralKey(t *testing.T, alg Algorithm) privateKey {
 	}
 	return key
 }
+
+func TestKeyAuthTouchCallback(t *testing.T) {
+	for _, test := range []struct {
+		policy  TouchPolicy
+		touches int
+	}{
+		{TouchPolicyNever, 0},
+		{TouchPolicyAlways, 1},
+		{TouchPolicyCached, 1},
+	} {
+		touches := 0
+		auth := KeyAuth{TouchPolicy: test.policy, TouchCallback: func() { touches++ }}
+		if _, err := auth.do(&YubiKey{}, PINPolicyNever, func(tx SCTx) ([]byte, error) { return nil, nil }); err != nil {
+			t.Fatalf("do() = %v", err)
+		}
+		if touches != test.touches {
+			t.Errorf("%s: got %d touches, want %d", test.policy, touches, test.touches)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// listedApplets are the applets ListCards tries to select, in order.
+// nolint:gochecknoglobals
+var listedApplets = []struct {
+	name string
+	aid  []byte
+}{
+	{"PIV", aidPIV[:]},
+	{"OpenPGP", aidOpenPGP[:]},
+	{"OATH", aidOATH[:]},
+	{"OTP", aidYubiKey[:]},
+	{"YubiHSM Auth", aidHSMAuth[:]},
+}
+
+// ListCards lists the cards in every reader with their serial, version and applets, see Client.ListCards.
+func ListCards(ctx context.Context) ([]CardInfo, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.ListCards(ctx)
+}
+
+// ListCards lists the cards in every reader with their serial, version and applets, so a CLI can
+// present a picker when several YubiKeys are attached. Every card is connected to and closed again.
+// A card that can't be read, for example because another program uses it exclusively, has Err set.
+// Empty readers are left out.
+func (c *Client) ListCards(ctx context.Context) ([]CardInfo, error) {
+	scCtx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+	defer scCtx.Close()
+
+	readers, err := scCtx.ListReaders()
+	if err != nil {
+		return nil, fmt.Errorf("listing readers: %w", err)
+	}
+
+	infos := make([]CardInfo, 0, len(readers))
+
+	for _, reader := range readers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		raw, err := scCtx.ATR(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading ATR of %s: %w", reader, err)
+		}
+
+		if raw == nil {
+			continue
+		}
+
+		info := CardInfo{Reader: reader}
+
+		if atr, err := ParseATR(raw); err == nil {
+			info.ATR = atr
+			info.Identity = atr.Identify()
+		}
+
+		info.Err = c.readCardInfo(&info)
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// readCardInfo connects to the card of info.Reader and selects every applet in listedApplets.
+// The serial and version come from the management applet, or else from the OpenPGP and PIV applets.
+func (c *Client) readCardInfo(info *CardInfo) error {
+	scCtx, h, tx, err := c.connectCard(info.Reader)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		tx.Close()
+		closeHandles(scCtx, h)
+	}()
+
+	if resp, err := ykSelectApplicationResponse(tx, aidManagement[:]); err == nil {
+		info.Version = parseManagementVersion(resp).Version()
+
+		if deviceInfo, err := ykReadDeviceInfo(tx); err == nil {
+			if serial := deviceInfo[tagMgmtSerial]; len(serial) == 4 {
+				info.Serial = binary.BigEndian.Uint32(serial)
+			}
+
+			if v := deviceInfo[tagMgmtVersion]; len(v) == 3 {
+				info.Version = Version{Major: int(v[0]), Minor: int(v[1]), Patch: int(v[2])}
+			}
+		}
+	}
+
+	for _, applet := range listedApplets {
+		if err := ykSelectApplication(tx, applet.aid); err != nil {
+			continue
+		}
+
+		info.Applets = append(info.Applets, applet.name)
+
+		switch applet.name {
+		case "PIV":
+			if info.Version != (Version{}) {
+				break
+			}
+
+			if v, err := ykVersion(tx); err == nil {
+				info.Version = v.Version()
+			}
+		case "OpenPGP":
+			gpgData, err := ykOpenGPGData(tx, info.Reader, ParseLenient)
+			if err != nil {
+				return fmt.Errorf("reading openpgp applet: %w", err)
+			}
+
+			info.OpenPGP = gpgData
+
+			if aid, err := gpgData.GetTag("6E.4F", 14); err == nil && info.Serial == 0 {
+				info.Serial, _ = parseOpenPGPSerial(aid)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestClient_ListCards(t *testing.T) {
+	t.Parallel()
+
+	const (
+		yk    = "Yubico YubiKey OTP+FIDO+CCID 00 00"
+		empty = "ACS ACR1252 Dual Reader PICC"
+		busy  = "Yubico YubiKey OTP+FIDO+CCID 01 00"
+	)
+
+	ykATR := mustHex(t, "3B FD 13 00 00 81 31 FE 15 80 73 C0 21 C0 57 59 75 62 69 4B 65 79 40")
+	errBusy := &scErr{rc: 0x8010000B}
+	notFound := &apduErr{0x6a, 0x82}
+
+	gpg := basicOpenGPGTx()
+	tx := &TestSCTx{
+		APDUList: []apdu{
+			{instruction: insSelectApplication, param1: 0x04, data: aidManagement[:]},
+			{instruction: insManagementReadConfig},
+			{instruction: insSelectApplication, param1: 0x04, data: aidPIV[:]},
+		},
+		ResponseList: [][]byte{
+			[]byte("Virtual mgmt - FW version 5.4.3"),
+			mustHex(t, "0b 02 04 00bc614e 05 03 050403"),
+			nil,
+		},
+	}
+	tx.APDUList = append(tx.APDUList, gpg.APDUList...)
+	tx.ResponseList = append(tx.ResponseList, gpg.ResponseList...)
+	tx.APDUList = append(tx.APDUList,
+		apdu{instruction: insSelectApplication, param1: 0x04, data: aidOATH[:]},
+		apdu{instruction: insSelectApplication, param1: 0x04, data: aidYubiKey[:]},
+		apdu{instruction: insSelectApplication, param1: 0x04, data: aidHSMAuth[:]},
+	)
+	tx.ResponseList = append(tx.ResponseList, nil, nil, nil)
+	tx.TransmitErr = make([]error, len(tx.APDUList))
+	// OATH is there, OTP and YubiHSM Auth aren't.
+	tx.TransmitErr[len(tx.APDUList)-2] = notFound
+	tx.TransmitErr[len(tx.APDUList)-1] = notFound
+
+	c := &Client{
+		client: &client{},
+		SCConstruct: &TestSCConstructor{Ctx: TestSCContext{
+			Readers: []string{yk, empty, busy},
+			ATRs:    map[string][]byte{yk: ykATR, busy: ykATR},
+			ConnectFunc: func(reader string) (SCHandle, error) {
+				if reader == busy {
+					return nil, errBusy
+				}
+
+				return &TestSCHandle{Ctx: tx}, nil
+			},
+		}},
+	}
+
+	infos, err := c.ListCards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.CurrentAPDUIndex != len(tx.APDUList) {
+		t.Errorf("sent %d apdus expected %d", tx.CurrentAPDUIndex, len(tx.APDUList))
+	}
+
+	if len(infos) != 2 || infos[0].Reader != yk || infos[1].Reader != busy {
+		t.Fatalf("got %+v expected %s and %s", infos, yk, busy)
+	}
+
+	info := infos[0]
+	if info.Err != nil {
+		t.Fatal(info.Err)
+	}
+
+	if info.Serial != 12345678 || info.Version != (Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("got serial %d version %+v expected 12345678 5.4.3", info.Serial, info.Version)
+	}
+
+	if expected := []string{"PIV", "OpenPGP", "OATH"}; !reflect.DeepEqual(info.Applets, expected) {
+		t.Errorf("got applets %v expected %v", info.Applets, expected)
+	}
+
+	if info.OpenPGP == nil || info.OpenPGP.Serial != "3506994" {
+		t.Errorf("got openpgp %v expected serial 3506994", info.OpenPGP)
+	}
+
+	if !errors.Is(infos[1].Err, errBusy) || infos[1].Identity.Family != CardFamilyYubiKey {
+		t.Errorf("got %v %s expected %v and the identity from the ATR", infos[1].Err, infos[1].Identity.Family, errBusy)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.ListCards(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,150 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// logTx logs the commands sent with Transmit and the transactions to a logger, at debug level.
+// Secrets are redacted like in a trace, see TraceEntry. TransmitBytes is passed through.
+type logTx struct {
+	SCTx
+	logger *slog.Logger
+	reader string
+	// selected is the AID of the selected applet, nil when it isn't known.
+	selected []byte
+}
+
+var (
+	_ TransactionTx    = (*logTx)(nil)
+	_ ExtendedLengthTx = (*logTx)(nil)
+)
+
+// newLogTx returns tx logging to logger, or tx if logger is nil.
+// nolint:ireturn
+func newLogTx(tx SCTx, logger *slog.Logger, reader string) SCTx {
+	if logger == nil {
+		return tx
+	}
+
+	return &logTx{SCTx: tx, logger: logger.With(slog.String("reader", reader)), reader: reader}
+}
+
+func (t *logTx) Transmit(d apdu) ([]byte, error) {
+	if !t.logger.Enabled(context.Background(), slog.LevelDebug) {
+		resp, err := t.SCTx.Transmit(d)
+		t.selected = selectedAID(t.selected, d, err)
+
+		return resp, err
+	}
+
+	start := time.Now()
+	resp, err := t.SCTx.Transmit(d)
+
+	e := newTraceEntry(t.reader, t.selected, d, resp, err)
+	t.selected = selectedAID(t.selected, d, err)
+
+	attrs := []slog.Attr{
+		slog.String("command", e.Command),
+		slog.String("status", statusString(e.Status)),
+		slog.Int("responseLength", len(resp)),
+		slog.Duration("duration", time.Since(start)),
+	}
+
+	if e.Response != "" {
+		attrs = append(attrs, slog.String("response", e.Response))
+	}
+
+	if e.CommandRedacted || e.ResponseRedacted {
+		attrs = append(attrs, slog.Bool("redacted", true))
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+
+	t.logger.LogAttrs(context.Background(), slog.LevelDebug, "apdu", attrs...)
+
+	return resp, err
+}
+
+func (t *logTx) BeginTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.BeginTransaction()
+	t.logger.Debug("begin transaction", slog.Any("error", err))
+
+	return err
+}
+
+func (t *logTx) EndTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.EndTransaction()
+	t.logger.Debug("end transaction", slog.Any("error", err))
+
+	return err
+}
+
+func (t *logTx) Close() error {
+	err := t.SCTx.Close()
+	t.logger.Debug("close", slog.Any("error", err))
+
+	return err
+}
+
+func (t *logTx) SetExtendedLength(enabled bool) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetExtendedLength(enabled)
+	}
+}
+
+func (t *logTx) ExtendedLength() bool {
+	e, ok := t.SCTx.(ExtendedLengthTx)
+
+	return ok && e.ExtendedLength()
+}
+
+func (t *logTx) SetMaxCommandLength(n int) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetMaxCommandLength(n)
+	}
+}
+
+// statusString returns SW1 SW2 in hex, or none when the transport failed.
+func statusString(sw uint16) string {
+	if sw == 0 {
+		return "none"
+	}
+
+	return fmt.Sprintf("%04x", sw)
+}
+
+// logClose logs the error closing a context or handle after a failed open.
+func logClose(logger *slog.Logger, what string, err error) {
+	if logger != nil && err != nil {
+		logger.Warn("closing after a failed open", slog.String("closing", what), slog.Any("error", err))
+	}
+}
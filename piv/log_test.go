@@ -0,0 +1,85 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestOpenOptions_Logger(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPGWithOptions(pivtest.Reader(0), piv.GPGOpenOptions{
+		OpenOptions: piv.OpenOptions{Logger: logger},
+	})
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := yk.VerifyPIN(piv.PW1, []byte("000000")); err == nil {
+		t.Fatal("wrong PIN verified")
+	}
+
+	if strings.Contains(buf.String(), hex.EncodeToString([]byte(pivtest.DefaultAdminPIN))) {
+		t.Errorf("PIN logged:\n%s", buf.String())
+	}
+
+	var verifies []map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+
+		if entry["msg"] != "apdu" || entry["reader"] != pivtest.Reader(0) {
+			continue
+		}
+
+		// VERIFY is INS 20.
+		if command, _ := entry["command"].(string); strings.HasPrefix(command, "0020") {
+			verifies = append(verifies, entry)
+		}
+	}
+
+	if len(verifies) != 2 {
+		t.Fatalf("got %d VERIFY entries expected 2:\n%s", len(verifies), buf.String())
+	}
+
+	if verifies[0]["status"] != "9000" || verifies[0]["redacted"] != true {
+		t.Errorf("got %v", verifies[0])
+	}
+
+	if status, _ := verifies[1]["status"].(string); !strings.HasPrefix(status, "6") || verifies[1]["error"] == nil {
+		t.Errorf("got %v", verifies[1])
+	}
+}
@@ -0,0 +1,528 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The management applet (aidManagement) reads and writes the device wide configuration of a YubiKey.
+// https://github.com/Yubico/yubikey-manager/blob/main/yubikit/management.py
+
+const (
+	insManagementWriteConfig = 0x1c
+	insManagementReadConfig  = 0x1d
+
+	// Tags of the device info / device config TLVs, each tag and length is a single byte.
+	tagMgmtUSBSupported     = 0x01
+	tagMgmtSerial           = 0x02
+	tagMgmtUSBEnabled       = 0x03
+	tagMgmtFormFactor       = 0x04
+	tagMgmtVersion          = 0x05
+	tagMgmtAutoEjectTimeout = 0x06
+	tagMgmtChalRespTimeout  = 0x07
+	tagMgmtDeviceFlags      = 0x08
+	tagMgmtConfigLock       = 0x0a
+	tagMgmtUnlock           = 0x0b
+	tagMgmtReboot           = 0x0c
+	tagMgmtNFCSupported     = 0x0d
+	tagMgmtNFCEnabled       = 0x0e
+	tagMgmtMoreData         = 0x10
+	tagMgmtPartNumber       = 0x13
+	tagMgmtFIPSCapable      = 0x14
+
+	// LockCodeSize is the size of the configuration lock code.
+	LockCodeSize = 16
+
+	mgmtMaxConfigSize = 0xff
+	mgmtMaxPages      = 0x10
+)
+
+var (
+	ErrConfigTooLarge          = errors.New("device configuration too large")
+	ErrDeviceInfoMalformed     = errors.New("device info malformed")
+	ErrApplicationNotSupported = errors.New("application not supported")
+	ErrApplicationDisabled     = errors.New("application disabled")
+	ErrNoApplicationsEnabled   = errors.New("no applications enabled")
+	ErrLockCodeMalformed       = errors.New("lock code malformed")
+)
+
+// Capability is a bit mask of YubiKey applications.
+type Capability uint16
+
+const (
+	CapabilityOTP     Capability = 0x01
+	CapabilityU2F     Capability = 0x02
+	CapabilityOpenPGP Capability = 0x08
+	CapabilityPIV     Capability = 0x10
+	CapabilityOATH    Capability = 0x20
+	CapabilityHSMAuth Capability = 0x100
+	CapabilityFIDO2   Capability = 0x200
+)
+
+// nolint:gochecknoglobals
+var capabilityNames = []struct {
+	capability Capability
+	name       string
+}{
+	{CapabilityOTP, "OTP"},
+	{CapabilityU2F, "U2F"},
+	{CapabilityOpenPGP, "OpenPGP"},
+	{CapabilityPIV, "PIV"},
+	{CapabilityOATH, "OATH"},
+	{CapabilityHSMAuth, "YubiHSM Auth"},
+	{CapabilityFIDO2, "FIDO2"},
+}
+
+// Capabilities returns the individual applications set in c.
+func (c Capability) Capabilities() []Capability {
+	var rv []Capability
+
+	for _, n := range capabilityNames {
+		if c&n.capability != 0 {
+			rv = append(rv, n.capability)
+		}
+	}
+
+	return rv
+}
+
+func (c Capability) String() string {
+	var names []string
+
+	for _, n := range capabilityNames {
+		if c&n.capability != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Sprintf("Capability(0x%x)", uint16(c))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Transport is the interface a YubiKey is connected over.
+type Transport int
+
+const (
+	TransportUSB Transport = iota
+	TransportNFC
+)
+
+// TransportForReader guesses the transport from the PC/SC reader name.
+// A YubiKey connected over USB is its own reader, anything else is an NFC reader.
+func TransportForReader(reader string) Transport {
+	if strings.Contains(strings.ToLower(reader), "yubico yubikey") {
+		return TransportUSB
+	}
+
+	return TransportNFC
+}
+
+func (t Transport) String() string {
+	switch t {
+	case TransportUSB:
+		return "USB"
+	case TransportNFC:
+		return "NFC"
+	default:
+		return fmt.Sprintf("Transport(%d)", int(t))
+	}
+}
+
+// DeviceConfig holds the settings to write with WriteDeviceConfig.
+// Nil fields are left unchanged on the device.
+type DeviceConfig struct {
+	// EnabledCapabilities sets the enabled applications per transport.
+	EnabledCapabilities map[Transport]Capability
+	// AutoEjectTimeout in seconds, only used when the device flag for eject is set.
+	AutoEjectTimeout *uint16
+	// ChallengeResponseTimeout is the number of seconds to wait for touch in OTP challenge-response.
+	ChallengeResponseTimeout *byte
+	DeviceFlags              *byte
+}
+
+// marshal builds the write config payload, prefixed by its length.
+func (c *DeviceConfig) marshal(reboot bool, currentLockCode, newLockCode *[LockCodeSize]byte) ([]byte, error) {
+	var buf []byte
+
+	if reboot {
+		buf = append(buf, tagMgmtReboot, 0x00)
+	}
+
+	if currentLockCode != nil {
+		buf = append(buf, marshalASN1(tagMgmtUnlock, currentLockCode[:])...)
+	}
+
+	if c != nil {
+		for _, t := range []struct {
+			transport Transport
+			tag       byte
+		}{{TransportUSB, tagMgmtUSBEnabled}, {TransportNFC, tagMgmtNFCEnabled}} {
+			if caps, ok := c.EnabledCapabilities[t.transport]; ok {
+				buf = append(buf, t.tag, 0x02, byte(caps>>8), byte(caps))
+			}
+		}
+
+		if c.AutoEjectTimeout != nil {
+			buf = append(buf, tagMgmtAutoEjectTimeout, 0x02, byte(*c.AutoEjectTimeout>>8), byte(*c.AutoEjectTimeout))
+		}
+
+		if c.ChallengeResponseTimeout != nil {
+			buf = append(buf, tagMgmtChalRespTimeout, 0x01, *c.ChallengeResponseTimeout)
+		}
+
+		if c.DeviceFlags != nil {
+			buf = append(buf, tagMgmtDeviceFlags, 0x01, *c.DeviceFlags)
+		}
+	}
+
+	if newLockCode != nil {
+		buf = append(buf, marshalASN1(tagMgmtConfigLock, newLockCode[:])...)
+	}
+
+	if len(buf) > mgmtMaxConfigSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrConfigTooLarge, len(buf))
+	}
+
+	return append([]byte{byte(len(buf))}, buf...), nil
+}
+
+// Management is an exclusive open connection to the management applet of a YubiKey.
+//
+// To release the connection, call the Close method.
+type Management struct {
+	ctx       SCContext
+	h         SCHandle
+	tx        SCTx
+	version   *version
+	transport Transport
+}
+
+// OpenManagement connects to the management applet of a YubiKey.
+func OpenManagement(card string) (*Management, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenManagement(card)
+}
+
+// OpenManagement connects to the management applet of a YubiKey.
+func (c *Client) OpenManagement(card string) (*Management, error) {
+	ctx, h, tx, resp, err := c.connectApplet(card, aidManagement[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Management{
+		ctx:       ctx,
+		h:         h,
+		tx:        tx,
+		version:   parseManagementVersion(resp),
+		transport: TransportForReader(card),
+	}, nil
+}
+
+// Close releases the connection to the smart card.
+func (m *Management) Close() error {
+	return closeHandles(m.ctx, m.h)
+}
+
+// Version returns the firmware version reported when selecting the management applet.
+func (m *Management) Version() Version {
+	return m.version.Version()
+}
+
+// Transport returns the transport the YubiKey is connected over, guessed from the reader name.
+func (m *Management) Transport() Transport {
+	return m.transport
+}
+
+// nolint:gochecknoglobals
+var managementVersionRe = regexp.MustCompile(`\b(\d+)\.(\d+)\.(\d+)\b`)
+
+// parseManagementVersion parses the select response, a string such as "Virtual mgmt - FW version 5.4.3".
+func parseManagementVersion(b []byte) *version {
+	m := managementVersionRe.FindSubmatch(b)
+	if m == nil {
+		return &version{}
+	}
+
+	v := &version{}
+
+	for i, p := range []*byte{&v.major, &v.minor, &v.patch} {
+		n, err := strconv.Atoi(string(m[i+1]))
+		if err != nil || n > 0xff {
+			return &version{}
+		}
+
+		*p = byte(n)
+	}
+
+	return v
+}
+
+// ConfigLocked returns true if the device configuration is protected by a lock code.
+func (m *Management) ConfigLocked() (bool, error) {
+	info, err := ykReadDeviceInfo(m.tx)
+	if err != nil {
+		return false, err
+	}
+
+	locked, ok := info[tagMgmtConfigLock]
+
+	return ok && len(locked) == 1 && locked[0] == 0x01, nil
+}
+
+// ApplicationStatus reports if an application is present and enabled over a transport.
+type ApplicationStatus struct {
+	Capability Capability
+	Supported  bool
+	Enabled    bool
+}
+
+// Err returns nil if the application can be used, otherwise an error explaining why not,
+// such as "OpenPGP is disabled over NFC".
+func (s ApplicationStatus) Err(t Transport) error {
+	switch {
+	case !s.Supported:
+		return fmt.Errorf("%w: %s is not available over %s", ErrApplicationNotSupported, s.Capability, t)
+	case !s.Enabled:
+		return fmt.Errorf("%w: %s is disabled over %s", ErrApplicationDisabled, s.Capability, t)
+	default:
+		return nil
+	}
+}
+
+// Applications reports which applications are supported and enabled over transport t.
+func (m *Management) Applications(t Transport) ([]ApplicationStatus, error) {
+	info, err := ykReadDeviceInfo(m.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return applicationStatus(info, t), nil
+}
+
+// CheckApplication returns nil if the application c can be used over the current transport.
+func (m *Management) CheckApplication(c Capability) error {
+	apps, err := m.Applications(m.transport)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range apps {
+		if a.Capability == c {
+			return a.Err(m.transport)
+		}
+	}
+
+	return ApplicationStatus{Capability: c}.Err(m.transport)
+}
+
+// EnabledApplications returns the applications enabled over transport t, like ykman info.
+func (m *Management) EnabledApplications(t Transport) (Capability, error) {
+	info, err := ykReadDeviceInfo(m.tx)
+	if err != nil {
+		return 0, err
+	}
+
+	supported, enabled := transportCapabilities(info, t)
+
+	return supported & enabled, nil
+}
+
+// SetApplications enables enable and disables disable over transport t and leaves the other applications
+// as they are, like ykman config usb --enable and --disable. lockCode must be given if the configuration is locked.
+// The YubiKey reboots for the change to take effect, which ends this connection.
+// Disabling every application over USB is refused as the YubiKey couldn't be configured any more.
+func (m *Management) SetApplications(t Transport, enable, disable Capability, lockCode *[LockCodeSize]byte) error {
+	info, err := ykReadDeviceInfo(m.tx)
+	if err != nil {
+		return err
+	}
+
+	supported, enabled := transportCapabilities(info, t)
+
+	if unsupported := enable &^ supported; unsupported != 0 {
+		return fmt.Errorf("%w: %s is not available over %s", ErrApplicationNotSupported, unsupported, t)
+	}
+
+	enabled = (enabled | enable) &^ disable & supported
+	if t == TransportUSB && enabled == 0 {
+		return fmt.Errorf("%w: over %s", ErrNoApplicationsEnabled, t)
+	}
+
+	config := &DeviceConfig{EnabledCapabilities: map[Transport]Capability{t: enabled}}
+
+	return ykWriteDeviceConfig(m.tx, config, true, lockCode, nil)
+}
+
+// ParseLockCode parses a lock code of 32 hex digits, the format of ykman config set-lock-code.
+func ParseLockCode(s string) ([LockCodeSize]byte, error) {
+	var lockCode [LockCodeSize]byte
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != LockCodeSize {
+		return lockCode, fmt.Errorf("%w: expected %d hex digits", ErrLockCodeMalformed, 2*LockCodeSize)
+	}
+
+	copy(lockCode[:], b)
+
+	return lockCode, nil
+}
+
+// transportCapabilities returns the supported and enabled applications over t.
+func transportCapabilities(info map[byte][]byte, t Transport) (supported, enabled Capability) {
+	supportedTag, enabledTag := byte(tagMgmtUSBSupported), byte(tagMgmtUSBEnabled)
+	if t == TransportNFC {
+		supportedTag, enabledTag = tagMgmtNFCSupported, tagMgmtNFCEnabled
+	}
+
+	supported = capabilityFromBytes(info[supportedTag])
+
+	// Devices without an enabled field have everything supported enabled.
+	enabled = supported
+	if b, ok := info[enabledTag]; ok {
+		enabled = capabilityFromBytes(b)
+	}
+
+	return supported, enabled
+}
+
+func applicationStatus(info map[byte][]byte, t Transport) []ApplicationStatus {
+	supported, enabled := transportCapabilities(info, t)
+
+	rv := make([]ApplicationStatus, 0, len(capabilityNames))
+
+	for _, n := range capabilityNames {
+		rv = append(rv, ApplicationStatus{
+			Capability: n.capability,
+			Supported:  supported&n.capability != 0,
+			Enabled:    supported&enabled&n.capability != 0,
+		})
+	}
+
+	return rv
+}
+
+// capabilityFromBytes decodes a 1 or 2 byte big endian capability mask.
+func capabilityFromBytes(b []byte) Capability {
+	switch len(b) {
+	case 1:
+		return Capability(b[0])
+	case 2:
+		return Capability(binary.BigEndian.Uint16(b))
+	default:
+		return 0
+	}
+}
+
+// WriteDeviceConfig updates the device configuration.
+// lockCode must be given if the configuration is locked, reboot restarts the YubiKey so the changes take effect.
+func (m *Management) WriteDeviceConfig(config *DeviceConfig, reboot bool, lockCode *[LockCodeSize]byte) error {
+	return ykWriteDeviceConfig(m.tx, config, reboot, lockCode, nil)
+}
+
+// SetLockCode protects the device configuration with newLockCode.
+// currentLockCode is nil if the configuration isn't locked yet.
+func (m *Management) SetLockCode(currentLockCode *[LockCodeSize]byte, newLockCode [LockCodeSize]byte) error {
+	return ykWriteDeviceConfig(m.tx, nil, false, currentLockCode, &newLockCode)
+}
+
+// ClearLockCode removes the lock code protecting the device configuration.
+func (m *Management) ClearLockCode(currentLockCode [LockCodeSize]byte) error {
+	// An all zero lock code unlocks the configuration.
+	var cleared [LockCodeSize]byte
+
+	return ykWriteDeviceConfig(m.tx, nil, false, &currentLockCode, &cleared)
+}
+
+func ykWriteDeviceConfig(tx SCTx, config *DeviceConfig, reboot bool, currentLockCode, newLockCode *[LockCodeSize]byte) error {
+	data, err := config.marshal(reboot, currentLockCode, newLockCode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Transmit(apdu{instruction: insManagementWriteConfig, data: data}); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
+
+// ykReadDeviceInfo reads all pages of the device info and returns the TLVs keyed by tag.
+func ykReadDeviceInfo(tx SCTx) (map[byte][]byte, error) {
+	info := map[byte][]byte{}
+
+	for page := byte(0); page < mgmtMaxPages; page++ {
+		resp, err := tx.Transmit(apdu{instruction: insManagementReadConfig, param1: page})
+		if err != nil {
+			return nil, fmt.Errorf("command failed: %w", err)
+		}
+
+		more, err := parseDeviceInfoPage(resp, info)
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// parseDeviceInfoPage parses one page, a length byte followed by single byte tag and length TLVs.
+// It returns true if the device has more pages.
+func parseDeviceInfoPage(b []byte, info map[byte][]byte) (bool, error) {
+	if len(b) == 0 || int(b[0]) != len(b)-1 {
+		return false, fmt.Errorf("%w: length mismatch", ErrDeviceInfoMalformed)
+	}
+
+	more := false
+
+	for b = b[1:]; len(b) > 0; {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return false, fmt.Errorf("%w: truncated tag 0x%02x", ErrDeviceInfoMalformed, b[0])
+		}
+
+		tag, value := b[0], b[2:2+int(b[1])]
+		b = b[2+int(b[1]):]
+
+		if tag == tagMgmtMoreData {
+			more = bytes.Equal(value, []byte{0x01})
+
+			continue
+		}
+
+		info[tag] = value
+	}
+
+	return more, nil
+}
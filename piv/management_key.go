@@ -0,0 +1,302 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// ManagementKeyAlgorithm is the algorithm of the PIV management key. YubiKeys before 5.4 only
+// have 3DES, 5.7 ships with an AES-192 key that has the bytes of DefaultManagementKey.
+// https://developers.yubico.com/PIV/Introduction/Yubico_extensions.html
+type ManagementKeyAlgorithm byte
+
+const (
+	ManagementKey3DES   ManagementKeyAlgorithm = alg3DES
+	ManagementKeyAES128 ManagementKeyAlgorithm = 0x08
+	ManagementKeyAES192 ManagementKeyAlgorithm = 0x0a
+	ManagementKeyAES256 ManagementKeyAlgorithm = 0x0c
+)
+
+// ErrManagementKeyLength is returned for keys whose length doesn't match the algorithm of the management key.
+var ErrManagementKeyLength = errors.New("management key length doesn't match its algorithm")
+
+func (a ManagementKeyAlgorithm) String() string {
+	switch a {
+	case ManagementKey3DES:
+		return "3DES"
+	case ManagementKeyAES128:
+		return "AES128"
+	case ManagementKeyAES192:
+		return "AES192"
+	case ManagementKeyAES256:
+		return "AES256"
+	default:
+		return fmt.Sprintf("ManagementKeyAlgorithm(%#x)", byte(a))
+	}
+}
+
+// KeyLen returns the length of keys of the algorithm in bytes, 0 for unknown algorithms.
+func (a ManagementKeyAlgorithm) KeyLen() int {
+	switch a {
+	case ManagementKeyAES128:
+		return 16
+	case ManagementKey3DES, ManagementKeyAES192:
+		return 24
+	case ManagementKeyAES256:
+		return 32
+	default:
+		return 0
+	}
+}
+
+func (a ManagementKeyAlgorithm) cipher(key []byte) (cipher.Block, error) {
+	if n := a.KeyLen(); n == 0 || len(key) != n {
+		return nil, fmt.Errorf("%w: %d byte %s key", ErrManagementKeyLength, len(key), a)
+	}
+
+	if a == ManagementKey3DES {
+		return des.NewTripleDESCipher(key)
+	}
+
+	return aes.NewCipher(key)
+}
+
+// ManagementKeyMetadata describes the management key, from the Yubico GET METADATA instruction.
+type ManagementKeyMetadata struct {
+	Algorithm   ManagementKeyAlgorithm
+	TouchPolicy TouchPolicy
+	// Default is true while the key is DefaultManagementKey.
+	Default bool
+}
+
+// ManagementKeyMetadata reads the algorithm, touch policy and whether the management key is the default.
+// It requires firmware 5.3.0. The algorithm is remembered for authenticating with the management key.
+func (yk *YubiKey) ManagementKeyMetadata() (*ManagementKeyMetadata, error) {
+	if !yk.Features().SupportsMetadata() {
+		return nil, fmt.Errorf("reading management key metadata: %w", ErrNotSupportedByCard)
+	}
+
+	m, err := ykManagementKeyMetadata(yk.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	yk.mgmtAlg = m.Algorithm
+
+	return m, nil
+}
+
+func ykManagementKeyMetadata(tx SCTx) (*ManagementKeyMetadata, error) {
+	cmd := apdu{
+		instruction: insGetMetadata,
+		param2:      keyCardManagement,
+	}
+	resp, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	objs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("parsing management key metadata: %w", err)
+	}
+
+	m := &ManagementKeyMetadata{Algorithm: ManagementKey3DES, TouchPolicy: TouchPolicyNever}
+
+	if alg, ok := bertlv.Find(objs, 0x01); ok && len(alg.Value) == 1 {
+		m.Algorithm = ManagementKeyAlgorithm(alg.Value[0])
+	}
+
+	if policy, ok := bertlv.Find(objs, 0x02); ok && len(policy.Value) == 2 {
+		if tp, ok := touchPolicyMapInv[policy.Value[1]]; ok {
+			m.TouchPolicy = tp
+		}
+	}
+
+	if def, ok := bertlv.Find(objs, 0x05); ok && len(def.Value) == 1 {
+		m.Default = def.Value[0] != 0
+	}
+
+	return m, nil
+}
+
+// managementKeyAlgorithm returns the algorithm of the management key, read from the metadata of YubiKeys
+// that can have AES keys. The others, and cards without the metadata, have a 3DES key.
+func (yk *YubiKey) managementKeyAlgorithm() ManagementKeyAlgorithm {
+	if yk.mgmtAlg != 0 {
+		return yk.mgmtAlg
+	}
+
+	yk.mgmtAlg = ManagementKey3DES
+
+	if yk.Features().SupportsAESManagementKey() {
+		if m, err := ykManagementKeyMetadata(yk.tx); err == nil {
+			yk.mgmtAlg = m.Algorithm
+		}
+	}
+
+	return yk.mgmtAlg
+}
+
+// AuthenticateManagementKey authenticates with a management key of any algorithm, the methods
+// that take a [24]byte key authenticate themselves and only take 3DES and AES-192 keys.
+// The authentication lasts until another applet is selected.
+func (yk *YubiKey) AuthenticateManagementKey(key []byte) error {
+	return ykAuthenticateAlgorithm(yk.tx, yk.managementKeyAlgorithm(), key, yk.rand)
+}
+
+// SetManagementKeyAlgorithm replaces the management key with newKey of algorithm alg, which must
+// have alg.KeyLen() bytes. AES keys require firmware 5.4.0. With touch the key must be touched for
+// each authentication.
+func (yk *YubiKey) SetManagementKeyAlgorithm(oldKey []byte, alg ManagementKeyAlgorithm, newKey []byte, touch bool) error {
+	if alg != ManagementKey3DES && !yk.Features().SupportsAESManagementKey() {
+		return fmt.Errorf("%s management key: %w", alg, ErrNotSupportedByCard)
+	}
+
+	if n := alg.KeyLen(); n == 0 || len(newKey) != n {
+		return fmt.Errorf("%w: %d byte %s key", ErrManagementKeyLength, len(newKey), alg)
+	}
+
+	if err := yk.AuthenticateManagementKey(oldKey); err != nil {
+		return fmt.Errorf("authenticating with old key: %w", err)
+	}
+
+	if err := ykSetManagementKeyAlgorithm(yk.tx, alg, newKey, touch); err != nil {
+		return err
+	}
+
+	yk.mgmtAlg = alg
+
+	return nil
+}
+
+// authManagementKey attempts to authenticate against the card with the provided
+// management key. The management key is required to generate new keys or add
+// certificates to slots.
+//
+// Use DefaultManagementKey if the management key hasn't been set.
+func (yk *YubiKey) authManagementKey(key [24]byte) error {
+	return ykAuthenticateAlgorithm(yk.tx, yk.managementKeyAlgorithm(), key[:], yk.rand)
+}
+
+// ykAuthenticateAlgorithm is the mutual authentication with the management key, the witness and
+// challenge have the block size of the algorithm.
+func ykAuthenticateAlgorithm(tx SCTx, alg ManagementKeyAlgorithm, key []byte, rand io.Reader) error {
+	// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=92
+	// https://tsapps.nist.gov/publication/get_pdf.cfm?pub_id=918402#page=114
+	block, err := alg.cipher(key)
+	if err != nil {
+		return fmt.Errorf("creating %s block cipher: %w", alg, err)
+	}
+
+	n := block.BlockSize()
+
+	// request a witness
+	cmd := apdu{
+		instruction: insAuthenticate,
+		param1:      byte(alg),
+		param2:      keyCardManagement,
+		data: []byte{
+			0x7c, // Dynamic Authentication Template tag
+			0x02, // Length of object
+			0x80, // 'Witness'
+			0x00, // Return encrypted random
+		},
+	}
+	resp, err := tx.Transmit(cmd)
+	if err != nil {
+		return fmt.Errorf("get auth challenge: %w", err)
+	}
+	if l := len(resp); l < 4+n {
+		return fmt.Errorf("challenge didn't return enough bytes: %d", l)
+	}
+	if !bytes.Equal(resp[:4], []byte{
+		0x7c,
+		byte(2 + n),
+		0x80, // 'Witness'
+		byte(n),
+	}) {
+		return fmt.Errorf("invalid authentication object header: %x", resp[:4])
+	}
+
+	cardChallenge := resp[4 : 4+n]
+	cardResponse := make([]byte, n)
+	block.Decrypt(cardResponse, cardChallenge)
+
+	challenge := make([]byte, n)
+	if _, err := io.ReadFull(rand, challenge); err != nil {
+		return fmt.Errorf("reading rand data: %v", err)
+	}
+	response := make([]byte, n)
+	block.Encrypt(response, challenge)
+
+	data := append(marshalASN1(0x80, cardResponse), marshalASN1(0x81, challenge)...)
+
+	cmd = apdu{
+		instruction: insAuthenticate,
+		param1:      byte(alg),
+		param2:      keyCardManagement,
+		data:        marshalASN1(0x7c, data),
+	}
+	resp, err = tx.Transmit(cmd)
+	if err != nil {
+		return fmt.Errorf("auth challenge: %w", err)
+	}
+	if l := len(resp); l < 4+n {
+		return fmt.Errorf("challenge response didn't return enough bytes: %d", l)
+	}
+	if !bytes.Equal(resp[:4], []byte{
+		0x7c,
+		byte(2 + n),
+		0x82, // 'Response'
+		byte(n),
+	}) {
+		return fmt.Errorf("response invalid authentication object header: %x", resp[:4])
+	}
+	if !bytes.Equal(resp[4:4+n], response) {
+		return fmt.Errorf("challenge failed")
+	}
+
+	return nil
+}
+
+// ykSetManagementKeyAlgorithm updates the management key to a new key. This requires
+// authenticating with the existing management key.
+func ykSetManagementKeyAlgorithm(tx SCTx, alg ManagementKeyAlgorithm, key []byte, touch bool) error {
+	cmd := apdu{
+		instruction: insSetMGMKey,
+		param1:      0xff,
+		param2:      0xff,
+		data: append([]byte{
+			byte(alg), keyCardManagement, byte(len(key)),
+		}, key...),
+	}
+	if touch {
+		cmd.param2 = 0xfe
+	}
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
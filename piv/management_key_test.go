@@ -0,0 +1,164 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+)
+
+var testMgmtKeyOpts = piv.Key{Algorithm: piv.AlgorithmEC256, PINPolicy: piv.PINPolicyNever, TouchPolicy: piv.TouchPolicyNever}
+
+func TestYubiKey_SetManagementKeyAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+	mk := piv.DefaultManagementKey
+	newKey := bytes.Repeat([]byte{0x42}, 32)
+
+	if err := yk.SetManagementKeyAlgorithm(mk[:], piv.ManagementKeyAES256, newKey, false); err != nil {
+		t.Fatalf("setting AES-256 key: %v", err)
+	}
+
+	m, err := yk.ManagementKeyMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Algorithm != piv.ManagementKeyAES256 || m.Default {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+
+	if err := yk.AuthenticateManagementKey(newKey); err != nil {
+		t.Errorf("authenticating with AES-256 key: %v", err)
+	}
+
+	if err := yk.AuthenticateManagementKey(mk[:]); !errors.Is(err, piv.ErrManagementKeyLength) {
+		t.Errorf("authenticating with 3DES key: %v", err)
+	}
+
+	if _, err := yk.GenerateKey(mk, piv.SlotAuthentication, testMgmtKeyOpts); err == nil {
+		t.Errorf("generated a key with a [24]byte key")
+	}
+
+	if err := yk.SetManagementKeyAlgorithm(newKey, piv.ManagementKeyAES128, newKey, false); !errors.Is(err, piv.ErrManagementKeyLength) {
+		t.Errorf("setting a 32 byte AES-128 key: %v", err)
+	}
+
+	if err := yk.SetManagementKeyAlgorithm(newKey, piv.ManagementKey3DES, mk[:], false); err != nil {
+		t.Fatalf("restoring 3DES key: %v", err)
+	}
+
+	if _, err := yk.GenerateKey(mk, piv.SlotAuthentication, testMgmtKeyOpts); err != nil {
+		t.Errorf("generating key with 3DES key: %v", err)
+	}
+}
+
+func TestYubiKey_ManagementKeyAES192Default(t *testing.T) {
+	t.Parallel()
+
+	// 5.7 ships with an AES-192 key, the [24]byte methods detect it.
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 7, Patch: 0})
+	mk := piv.DefaultManagementKey
+
+	if _, err := yk.GenerateKey(mk, piv.SlotAuthentication, testMgmtKeyOpts); err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	newKey := [24]byte{1, 2, 3}
+	if err := yk.SetManagementKey(mk, newKey); err != nil {
+		t.Fatalf("setting key: %v", err)
+	}
+
+	m, err := yk.ManagementKeyMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Algorithm != piv.ManagementKeyAES192 || m.Default {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+
+	if err := yk.AuthenticateManagementKey(newKey[:]); err != nil {
+		t.Errorf("authenticating with new key: %v", err)
+	}
+}
+
+func TestYubiKey_SetManagementKeyAlgorithm_NotSupported(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 2, Patch: 7})
+	mk := piv.DefaultManagementKey
+
+	err := yk.SetManagementKeyAlgorithm(mk[:], piv.ManagementKeyAES128, make([]byte, 16), false)
+	if !errors.Is(err, piv.ErrNotSupportedByCard) {
+		t.Errorf("expected ErrNotSupportedByCard, got %v", err)
+	}
+}
+
+func TestYubiKey_ManagementKeyMode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		mode   piv.ManagementKeyMode
+		enable func(yk *piv.YubiKey) ([24]byte, error)
+	}{
+		{piv.ManagementKeyModeProtected, func(yk *piv.YubiKey) ([24]byte, error) {
+			return yk.EnablePINOnly(piv.DefaultManagementKey, piv.DefaultPIN)
+		}},
+		{piv.ManagementKeyModeDerived, func(yk *piv.YubiKey) ([24]byte, error) {
+			return yk.EnablePINDerived(piv.DefaultManagementKey, piv.DefaultPIN)
+		}},
+	} {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			t.Parallel()
+
+			yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 7, Patch: 0})
+
+			if mode, err := yk.ManagementKeyMode(); err != nil || mode != piv.ManagementKeyModeManual {
+				t.Fatalf("mode of a new card: %s %v", mode, err)
+			}
+
+			key, err := tc.enable(yk)
+			if err != nil {
+				t.Fatalf("enabling: %v", err)
+			}
+
+			if mode, err := yk.ManagementKeyMode(); err != nil || mode != tc.mode {
+				t.Errorf("mode: %s %v", mode, err)
+			}
+
+			got, err := yk.PINManagementKey(piv.DefaultPIN)
+			if err != nil || !bytes.Equal(got, key[:]) {
+				t.Errorf("management key: %x %v, expected %x", got, err, key)
+			}
+
+			if _, err := yk.GenerateKeyWithPIN(piv.DefaultPIN, piv.SlotAuthentication, testMgmtKeyOpts); err != nil {
+				t.Errorf("generating key with pin: %v", err)
+			}
+
+			if err := yk.SetPINDerived(piv.DefaultPIN, "654321"); err != nil {
+				t.Fatalf("changing pin: %v", err)
+			}
+
+			if _, err := yk.GenerateKeyWithPIN("654321", piv.SlotSignature, testMgmtKeyOpts); err != nil {
+				t.Errorf("generating key with new pin: %v", err)
+			}
+		})
+	}
+}
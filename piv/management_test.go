@@ -0,0 +1,311 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func managementTestClient(tb testing.TB, apdus []apdu, responses [][]byte) *Client {
+	tb.Helper()
+
+	apdus = append([]apdu{{instruction: insSelectApplication, param1: 0x04, data: aidManagement[:]}}, apdus...)
+	responses = append([][]byte{[]byte("Virtual mgmt - FW version 5.4.3")}, responses...)
+
+	return CreateTestClient(tb, nil, nil, &TestSCHandle{
+		Ctx: &TestSCTx{
+			APDUList:     apdus,
+			ResponseList: responses,
+		},
+	})
+}
+
+func TestManagement_Version(t *testing.T) {
+	t.Parallel()
+
+	m, err := managementTestClient(t, nil, nil).OpenManagement("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if v := m.Version(); v != (Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("unexpected version %+v", v)
+	}
+
+	if v := parseManagementVersion([]byte("no version")); *v != (version{}) {
+		t.Errorf("unexpected version %+v", v)
+	}
+}
+
+func TestManagement_ConfigLocked(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		pages    [][]byte
+		expected bool
+		err      error
+	}{
+		{
+			name:     "locked",
+			pages:    [][]byte{{0x06, 0x0a, 0x01, 0x01, 0x02, 0x01, 0x05}},
+			expected: true,
+		},
+		{
+			name:  "unlocked over two pages",
+			pages: [][]byte{{0x06, 0x02, 0x01, 0x05, 0x10, 0x01, 0x01}, {0x03, 0x0a, 0x01, 0x00}},
+		},
+		{
+			name:  "bad length",
+			pages: [][]byte{{0x07, 0x0a, 0x01, 0x01}},
+			err:   ErrDeviceInfoMalformed,
+		},
+		{
+			name:  "truncated",
+			pages: [][]byte{{0x03, 0x0a, 0x05, 0x01}},
+			err:   ErrDeviceInfoMalformed,
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			apdus := make([]apdu, len(tc.pages))
+			for i := range tc.pages {
+				apdus[i] = apdu{instruction: insManagementReadConfig, param1: byte(i)}
+			}
+
+			m, err := managementTestClient(t, apdus, tc.pages).OpenManagement("")
+			if !expectedError(t, err, nil) {
+				t.FailNow()
+			}
+
+			locked, err := m.ConfigLocked()
+			expectedError(t, err, tc.err)
+
+			if locked != tc.expected {
+				t.Errorf("got locked %t expected %t", locked, tc.expected)
+			}
+		})
+	}
+}
+
+func TestManagement_LockCode(t *testing.T) {
+	t.Parallel()
+
+	var current, next [LockCodeSize]byte
+	for i := range current {
+		current[i] = 0x11
+		next[i] = 0x22
+	}
+
+	setData := append([]byte{0x24}, marshalASN1(tagMgmtUnlock, current[:])...)
+	setData = append(setData, marshalASN1(tagMgmtConfigLock, next[:])...)
+
+	clearData := append([]byte{0x24}, marshalASN1(tagMgmtUnlock, next[:])...)
+	clearData = append(clearData, marshalASN1(tagMgmtConfigLock, make([]byte, LockCodeSize))...)
+
+	m, err := managementTestClient(t,
+		[]apdu{
+			{instruction: insManagementWriteConfig, data: setData},
+			{instruction: insManagementWriteConfig, data: clearData},
+		},
+		[][]byte{{}, {}},
+	).OpenManagement("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	expectedError(t, m.SetLockCode(&current, next), nil)
+	expectedError(t, m.ClearLockCode(next), nil)
+}
+
+func TestDeviceConfig_Marshal(t *testing.T) {
+	t.Parallel()
+
+	timeout := uint16(0x0102)
+	lock := [LockCodeSize]byte{}
+	c := &DeviceConfig{
+		EnabledCapabilities: map[Transport]Capability{
+			TransportUSB: CapabilityPIV | CapabilityFIDO2,
+			TransportNFC: CapabilityOATH,
+		},
+		AutoEjectTimeout: &timeout,
+	}
+
+	got, err := c.marshal(true, &lock, nil)
+	expectedError(t, err, nil)
+
+	want := []byte{0x20, 0x0c, 0x00}
+	want = append(want, marshalASN1(tagMgmtUnlock, lock[:])...)
+	want = append(want, 0x03, 0x02, 0x02, 0x10, 0x0e, 0x02, 0x00, 0x20, 0x06, 0x02, 0x01, 0x02)
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x want %x", got, want)
+	}
+}
+
+func TestManagement_Applications(t *testing.T) {
+	t.Parallel()
+
+	// USB supports OTP|U2F|OpenPGP|PIV|OATH|FIDO2, NFC the same but OpenPGP is disabled over NFC.
+	page := []byte{0x10, 0x01, 0x02, 0x02, 0x3b, 0x03, 0x02, 0x02, 0x3b, 0x0d, 0x02, 0x02, 0x3b, 0x0e, 0x02, 0x02, 0x33}
+
+	m, err := managementTestClient(t,
+		[]apdu{{instruction: insManagementReadConfig}, {instruction: insManagementReadConfig}},
+		[][]byte{page, page},
+	).OpenManagement("ACS ACR122U PICC Interface")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if m.Transport() != TransportNFC {
+		t.Errorf("expected NFC transport, got %s", m.Transport())
+	}
+
+	apps, err := m.Applications(TransportUSB)
+	expectedError(t, err, nil)
+
+	for _, a := range apps {
+		if a.Capability == CapabilityOpenPGP && (!a.Supported || !a.Enabled) {
+			t.Errorf("expected OpenPGP enabled over USB: %+v", a)
+		}
+
+		if a.Capability == CapabilityHSMAuth && a.Supported {
+			t.Errorf("expected YubiHSM Auth not supported: %+v", a)
+		}
+	}
+
+	err = m.CheckApplication(CapabilityOpenPGP)
+	expectedError(t, err, ErrApplicationDisabled)
+
+	if err == nil || err.Error() != "application disabled: OpenPGP is disabled over NFC" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestManagement_SetApplications(t *testing.T) {
+	t.Parallel()
+
+	// the page of TestManagement_Applications, OpenPGP is disabled over NFC.
+	page := []byte{0x10, 0x01, 0x02, 0x02, 0x3b, 0x03, 0x02, 0x02, 0x3b, 0x0d, 0x02, 0x02, 0x3b, 0x0e, 0x02, 0x02, 0x33}
+	read := apdu{instruction: insManagementReadConfig}
+
+	// reboot and enable everything but OTP over NFC.
+	write := apdu{instruction: insManagementWriteConfig, data: []byte{0x06, 0x0c, 0x00, 0x0e, 0x02, 0x02, 0x3a}}
+
+	m, err := managementTestClient(t,
+		[]apdu{read, read, write, read, read},
+		[][]byte{page, page, {}, page, page},
+	).OpenManagement("ACS ACR122U PICC Interface")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	enabled, err := m.EnabledApplications(TransportNFC)
+	expectedError(t, err, nil)
+
+	if enabled&CapabilityOpenPGP != 0 || enabled&CapabilityPIV == 0 {
+		t.Errorf("got enabled %s", enabled)
+	}
+
+	expectedError(t, m.SetApplications(TransportNFC, CapabilityOpenPGP, CapabilityOTP, nil), nil)
+	expectedError(t, m.SetApplications(TransportUSB, 0, 0xffff, nil), ErrNoApplicationsEnabled)
+	expectedError(t, m.SetApplications(TransportUSB, CapabilityHSMAuth, 0, nil), ErrApplicationNotSupported)
+}
+
+func TestParseLockCode(t *testing.T) {
+	t.Parallel()
+
+	lockCode, err := ParseLockCode("00112233445566778899aabbccddeeff")
+	expectedError(t, err, nil)
+
+	if lockCode[0] != 0x00 || lockCode[15] != 0xff {
+		t.Errorf("got %x", lockCode)
+	}
+
+	for _, s := range []string{"", "0011", "zz112233445566778899aabbccddeeff"} {
+		_, err := ParseLockCode(s)
+		expectedError(t, err, ErrLockCodeMalformed)
+	}
+}
+
+func TestCapability_String(t *testing.T) {
+	t.Parallel()
+
+	if s := (CapabilityPIV | CapabilityOpenPGP).String(); s != "OpenPGP|PIV" {
+		t.Errorf("got %s", s)
+	}
+
+	if s := Capability(0x4000).String(); s != "Capability(0x4000)" {
+		t.Errorf("got %s", s)
+	}
+}
+
+func TestManagement_DeviceInfo(t *testing.T) {
+	t.Parallel()
+
+	// serial 12345678, USB-C keychain FIPS, firmware 5.7.1, PIV and OATH over USB and NFC, NFC OATH disabled.
+	page := []byte{0x1d,
+		0x02, 0x04, 0x00, 0xbc, 0x61, 0x4e,
+		0x04, 0x01, 0x83,
+		0x05, 0x03, 0x05, 0x07, 0x01,
+		0x01, 0x02, 0x00, 0x30,
+		0x0d, 0x02, 0x00, 0x30,
+		0x0e, 0x02, 0x00, 0x10,
+		0x0a, 0x01, 0x00,
+	}
+	page[0] = byte(len(page) - 1)
+
+	m, err := managementTestClient(t,
+		[]apdu{{instruction: insManagementReadConfig}},
+		[][]byte{page},
+	).OpenManagement("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	info, err := m.DeviceInfo()
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if info.Serial != 12345678 || info.Version != (Version{Major: 5, Minor: 7, Patch: 1}) {
+		t.Errorf("got serial %d version %+v", info.Serial, info.Version)
+	}
+
+	if info.Formfactor != FormfactorUSBCKeychainFIPS || !info.FIPS || info.CSPN || !info.NFC || info.ConfigLocked {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	if info.Enabled[TransportNFC] != CapabilityPIV || info.Supported[TransportUSB] != CapabilityPIV|CapabilityOATH {
+		t.Errorf("got supported %v enabled %v", info.Supported, info.Enabled)
+	}
+
+	_, err = parseDeviceInfo(map[byte][]byte{tagMgmtSerial: {0x01}}, nil)
+	expectedError(t, err, ErrDeviceInfoMalformed)
+
+	info, err = parseDeviceInfo(map[byte][]byte{tagMgmtPartNumber: []byte("5C-NFC-CSPN\x00")}, &version{5, 4, 3})
+	expectedError(t, err, nil)
+
+	if !info.CSPN || info.NFC || info.Version != (Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
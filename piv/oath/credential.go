@@ -0,0 +1,373 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oath
+
+import (
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the kind of one-time password of a credential.
+type Type byte
+
+const (
+	// TypeHOTP is a counter based credential, RFC 4226.
+	TypeHOTP Type = 0x10
+	// TypeTOTP is a time based credential, RFC 6238.
+	TypeTOTP Type = 0x20
+
+	typeMask = 0xf0
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeHOTP:
+		return "HOTP"
+	case TypeTOTP:
+		return "TOTP"
+	default:
+		return fmt.Sprintf("Type(%#x)", byte(t))
+	}
+}
+
+// Algorithm is the HMAC hash of a credential.
+type Algorithm byte
+
+const (
+	SHA1   Algorithm = 0x01
+	SHA256 Algorithm = 0x02
+	SHA512 Algorithm = 0x03
+
+	algorithmMask = 0x0f
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case SHA1:
+		return "SHA1"
+	case SHA256:
+		return "SHA256"
+	case SHA512:
+		return "SHA512"
+	default:
+		return fmt.Sprintf("Algorithm(%#x)", byte(a))
+	}
+}
+
+func (a Algorithm) hash() func() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+const (
+	// DefaultPeriod is the period of TOTP credentials whose ID doesn't have one.
+	DefaultPeriod = 30 * time.Second
+	// DefaultDigits is the length of codes when CredentialData.Digits is zero.
+	DefaultDigits = 6
+
+	// maxIDLen is the longest ID the applet stores.
+	maxIDLen = 64
+	// minKeyLen is the length secrets are padded to, HMAC_MINIMUM_KEY_SIZE of the applet.
+	minKeyLen = 14
+)
+
+var (
+	// ErrInvalidCredential is returned by Put for credentials the applet can't store.
+	ErrInvalidCredential = errors.New("invalid oath credential")
+	// ErrInvalidURI is returned by ParseURI for URIs that aren't otpauth credentials.
+	ErrInvalidURI = errors.New("invalid otpauth uri")
+)
+
+// Credential is a credential of the applet, known by its ID: [period/][issuer:]name.
+type Credential struct {
+	Type      Type
+	Algorithm Algorithm
+	Issuer    string
+	Name      string
+	// Period of TOTP credentials, DefaultPeriod when their ID has none.
+	Period time.Duration
+	// Touch is true for credentials that require the YubiKey to be touched for each code.
+	Touch bool
+}
+
+// ID returns the name the applet stores the credential under, the period is only in it when it isn't 30 seconds.
+func (c Credential) ID() []byte {
+	var id string
+
+	if c.Type == TypeTOTP && c.period() != DefaultPeriod {
+		id = fmt.Sprintf("%d/", int(c.period()/time.Second))
+	}
+
+	if c.Issuer != "" {
+		id += c.Issuer + ":"
+	}
+
+	return []byte(id + c.Name)
+}
+
+func (c Credential) String() string {
+	return string(c.ID())
+}
+
+func (c Credential) period() time.Duration {
+	if c.Period <= 0 {
+		return DefaultPeriod
+	}
+
+	return c.Period
+}
+
+// parseCredentialID splits the ID of a credential into its period, issuer and name.
+func parseCredentialID(id []byte, typ Type) Credential {
+	cred := Credential{Type: typ, Name: string(id)}
+
+	if typ == TypeTOTP {
+		cred.Period = DefaultPeriod
+
+		if i := strings.IndexByte(cred.Name, '/'); i > 0 {
+			if p, err := strconv.Atoi(cred.Name[:i]); err == nil && p > 0 {
+				cred.Period = time.Duration(p) * time.Second
+				cred.Name = cred.Name[i+1:]
+			}
+		}
+	}
+
+	if i := strings.IndexByte(cred.Name, ':'); i > 0 {
+		cred.Issuer, cred.Name = cred.Name[:i], cred.Name[i+1:]
+	}
+
+	return cred
+}
+
+// CredentialData is a credential with its secret, for Put.
+type CredentialData struct {
+	Type      Type
+	Algorithm Algorithm
+	Issuer    string
+	Name      string
+	Secret    []byte
+	// Digits is the length of the codes, 6 to 8, DefaultDigits if zero.
+	Digits int
+	// Period of TOTP credentials, DefaultPeriod if zero.
+	Period time.Duration
+	// Counter is the initial counter of HOTP credentials.
+	Counter uint32
+}
+
+// Credential returns the credential without its secret.
+func (d CredentialData) Credential() Credential {
+	cred := Credential{
+		Type:      d.Type,
+		Algorithm: d.Algorithm,
+		Issuer:    d.Issuer,
+		Name:      d.Name,
+	}
+
+	if d.Type == TypeTOTP {
+		cred.Period = Credential{Period: d.Period}.period()
+	}
+
+	return cred
+}
+
+func (d *CredentialData) validate() error {
+	if d.Type != TypeHOTP && d.Type != TypeTOTP {
+		return fmt.Errorf("%w: type %s", ErrInvalidCredential, d.Type)
+	}
+
+	if d.Algorithm == 0 {
+		d.Algorithm = SHA1
+	}
+
+	if d.Algorithm != SHA1 && d.Algorithm != SHA256 && d.Algorithm != SHA512 {
+		return fmt.Errorf("%w: algorithm %s", ErrInvalidCredential, d.Algorithm)
+	}
+
+	if d.Digits == 0 {
+		d.Digits = DefaultDigits
+	}
+
+	if d.Digits < 6 || d.Digits > 8 {
+		return fmt.Errorf("%w: %d digits, expected 6 to 8", ErrInvalidCredential, d.Digits)
+	}
+
+	if d.Name == "" {
+		return fmt.Errorf("%w: no name", ErrInvalidCredential)
+	}
+
+	if n := len(d.Credential().ID()); n > maxIDLen {
+		return fmt.Errorf("%w: id of %d bytes, at most %d", ErrInvalidCredential, n, maxIDLen)
+	}
+
+	if len(d.Secret) == 0 {
+		return fmt.Errorf("%w: no secret", ErrInvalidCredential)
+	}
+
+	return nil
+}
+
+// key is the secret as HMAC uses it: hashed when longer than the block size and padded to minKeyLen.
+func (d CredentialData) key() []byte {
+	h := d.Algorithm.hash()
+
+	key := d.Secret
+	if len(key) > h().BlockSize() {
+		hh := h()
+		hh.Write(key)
+		key = hh.Sum(nil)
+	}
+
+	if len(key) < minKeyLen {
+		key = append(append([]byte{}, key...), make([]byte, minKeyLen-len(key))...)
+	}
+
+	return key
+}
+
+// ParseURI parses an otpauth:// URI, the content of the QR codes of Google Authenticator.
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func ParseURI(uri string) (*CredentialData, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURI, err)
+	}
+
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("%w: scheme %q", ErrInvalidURI, u.Scheme)
+	}
+
+	d := &CredentialData{Algorithm: SHA1, Digits: DefaultDigits}
+
+	switch strings.ToLower(u.Host) {
+	case "totp":
+		d.Type = TypeTOTP
+	case "hotp":
+		d.Type = TypeHOTP
+	default:
+		return nil, fmt.Errorf("%w: type %q", ErrInvalidURI, u.Host)
+	}
+
+	d.Name = strings.TrimPrefix(u.Path, "/")
+	if i := strings.IndexByte(d.Name, ':'); i >= 0 {
+		d.Issuer, d.Name = strings.TrimSpace(d.Name[:i]), strings.TrimSpace(d.Name[i+1:])
+	}
+
+	q := u.Query()
+
+	if issuer := q.Get("issuer"); issuer != "" {
+		d.Issuer = issuer
+	}
+
+	secret := strings.ToUpper(strings.ReplaceAll(q.Get("secret"), " ", ""))
+	if d.Secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "=")); err != nil {
+		return nil, fmt.Errorf("%w: secret: %w", ErrInvalidURI, err)
+	}
+
+	switch strings.ToUpper(q.Get("algorithm")) {
+	case "", "SHA1":
+	case "SHA256":
+		d.Algorithm = SHA256
+	case "SHA512":
+		d.Algorithm = SHA512
+	default:
+		return nil, fmt.Errorf("%w: algorithm %q", ErrInvalidURI, q.Get("algorithm"))
+	}
+
+	for _, p := range []struct {
+		name string
+		set  func(int)
+	}{
+		{"digits", func(v int) { d.Digits = v }},
+		{"period", func(v int) { d.Period = time.Duration(v) * time.Second }},
+		{"counter", func(v int) { d.Counter = uint32(v) }},
+	} {
+		s := q.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("%w: %s %q", ErrInvalidURI, p.name, s)
+		}
+
+		p.set(v)
+	}
+
+	return d, nil
+}
+
+// Code is a one-time password, TOTP codes are valid from ValidFrom until ValidTo.
+type Code struct {
+	Value     string
+	ValidFrom time.Time
+	ValidTo   time.Time
+}
+
+func (c Code) String() string {
+	return c.Value
+}
+
+// CredentialCode is a credential returned by CalculateAll with its code, nil when it wasn't calculated.
+type CredentialCode struct {
+	Credential
+	Code *Code
+}
+
+// newCode formats the truncated response, the number of digits followed by 4 bytes.
+func newCode(r []byte, cred Credential, t time.Time) (*Code, error) {
+	if len(r) != 5 {
+		return nil, fmt.Errorf("%w: truncated response of %d bytes", ErrMalformedResponse, len(r))
+	}
+
+	digits := int(r[0])
+	if digits < 6 || digits > 10 {
+		return nil, fmt.Errorf("%w: %d digits", ErrMalformedResponse, digits)
+	}
+
+	v := uint64(binary.BigEndian.Uint32(r[1:]) & 0x7fffffff)
+
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	code := &Code{Value: fmt.Sprintf("%0*d", digits, v%mod)}
+
+	if cred.Type == TypeTOTP {
+		step := int64(cred.period() / time.Second)
+		from := t.Unix() / step * step
+		code.ValidFrom = time.Unix(from, 0)
+		code.ValidTo = time.Unix(from+step, 0)
+	}
+
+	return code, nil
+}
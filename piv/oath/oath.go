@@ -0,0 +1,437 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oath speaks the YubiKey OATH applet protocol, the TOTP and HOTP credentials shown by
+// Yubico Authenticator, over a piv.Card shared with the PIV and OpenPGP applets.
+//
+//	card, err := piv.OpenCard(reader)
+//	o, err := oath.Open(card)
+//	if o.Locked() {
+//		err = o.Unlock([]byte(password))
+//	}
+//	codes, err := o.CalculateAll(time.Now())
+//
+// Selecting another applet of the card forgets the password, call Unlock again after using it.
+// https://developers.yubico.com/OATH/YKOATH_Protocol.html
+package oath
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+	"github.com/areese/piv-go/piv"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	insPut           = 0x01
+	insDelete        = 0x02
+	insSetCode       = 0x03
+	insReset         = 0x04
+	insList          = 0xa1
+	insCalculate     = 0xa2
+	insValidate      = 0xa3
+	insCalculateAll  = 0xa4
+	insSendRemaining = 0xa5
+
+	tagName      bertlv.Tag = 0x71
+	tagNameList  bertlv.Tag = 0x72
+	tagKey       bertlv.Tag = 0x73
+	tagChallenge bertlv.Tag = 0x74
+	tagResponse  bertlv.Tag = 0x75
+	tagTruncated bertlv.Tag = 0x76
+	tagHOTP      bertlv.Tag = 0x77
+	tagProperty  bertlv.Tag = 0x78
+	tagVersion   bertlv.Tag = 0x79
+	tagIMF       bertlv.Tag = 0x7a
+	tagTouch     bertlv.Tag = 0x7c
+
+	propertyTouch = 0x02
+
+	// p2Truncate asks CALCULATE for the 4 bytes of RFC 4226 dynamic truncation instead of the HMAC.
+	p2Truncate = 0x01
+
+	challengeLen  = 8
+	keyLen        = 16
+	keyIterations = 1000
+)
+
+var (
+	// ErrWrongPassword is returned by Unlock when the password isn't the one of the applet.
+	ErrWrongPassword = errors.New("wrong oath password")
+	// ErrMalformedResponse is returned for responses of the applet that can't be parsed.
+	ErrMalformedResponse = errors.New("malformed oath response")
+)
+
+// OATH is the OATH applet of a Card. Close the Card to release it.
+type OATH struct {
+	card *piv.Card
+	aid  []byte
+
+	version   piv.Version
+	salt      []byte
+	challenge []byte
+
+	// TouchCallback, if set, is called before calculating a code that waits for the YubiKey to be touched.
+	TouchCallback func()
+	// Rand is the source of the VALIDATE and SET CODE challenges, crypto/rand.Reader if nil.
+	Rand io.Reader
+}
+
+// Open selects the OATH applet of card.
+func Open(card *piv.Card) (*OATH, error) {
+	o := &OATH{card: card, aid: piv.AppletOATH.AID()}
+
+	if err := o.selectApplet(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// selectApplet sends SELECT even when the applet is selected, its challenge changes with each one.
+func (o *OATH) selectApplet() error {
+	resp, err := o.transmit(piv.APDU{Instruction: 0xa4, Param1: 0x04, Data: o.aid})
+	if err != nil {
+		return fmt.Errorf("selecting oath applet: %w", err)
+	}
+
+	tlvs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	v, ok := bertlv.Find(tlvs, tagVersion)
+	if !ok || len(v.Value) != 3 {
+		return fmt.Errorf("%w: no version", ErrMalformedResponse)
+	}
+
+	salt, ok := bertlv.Find(tlvs, tagName)
+	if !ok {
+		return fmt.Errorf("%w: no salt", ErrMalformedResponse)
+	}
+
+	o.version = piv.Version{Major: int(v.Value[0]), Minor: int(v.Value[1]), Patch: int(v.Value[2])}
+	o.salt = append([]byte{}, salt.Value...)
+	o.challenge = nil
+
+	if c, ok := bertlv.Find(tlvs, tagChallenge); ok {
+		o.challenge = append([]byte{}, c.Value...)
+	}
+
+	return nil
+}
+
+func (o *OATH) transmit(cmd piv.APDU) ([]byte, error) {
+	cmd.SendRemaining = insSendRemaining
+
+	return o.card.Transmit(o.aid, cmd)
+}
+
+func (o *OATH) rand() io.Reader {
+	if o.Rand != nil {
+		return o.Rand
+	}
+
+	return rand.Reader
+}
+
+// Version returns the version reported by the OATH applet.
+func (o *OATH) Version() piv.Version {
+	return o.version
+}
+
+// DeviceID identifies the applet like Yubico Authenticator does, it changes with Reset.
+func (o *OATH) DeviceID() string {
+	sum := sha256.Sum256(o.salt)
+
+	return base64.RawStdEncoding.EncodeToString(sum[:16])
+}
+
+// Locked is true when the applet has a password that hasn't been given to Unlock.
+func (o *OATH) Locked() bool {
+	return o.challenge != nil
+}
+
+// DeriveKey returns the key the applet stores for password, PBKDF2-HMAC-SHA1 salted with the device salt.
+func (o *OATH) DeriveKey(password []byte) []byte {
+	return pbkdf2.Key(password, o.salt, keyIterations, keyLen, sha1.New)
+}
+
+// Unlock validates password, the applet then lists and calculates until another applet is selected.
+func (o *OATH) Unlock(password []byte) error {
+	if err := o.selectApplet(); err != nil {
+		return err
+	}
+
+	if o.challenge == nil {
+		return nil
+	}
+
+	key := o.DeriveKey(password)
+
+	challenge := make([]byte, challengeLen)
+	if _, err := io.ReadFull(o.rand(), challenge); err != nil {
+		return fmt.Errorf("generating challenge: %w", err)
+	}
+
+	data, _ := bertlv.Marshal(
+		bertlv.TLV{Tag: tagResponse, Value: hmacSHA1(key, o.challenge)},
+		bertlv.TLV{Tag: tagChallenge, Value: challenge},
+	)
+
+	resp, err := o.transmit(piv.APDU{Instruction: insValidate, Data: data})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWrongPassword, err)
+	}
+
+	tlvs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	// the applet proves it has the key too.
+	r, ok := bertlv.Find(tlvs, tagResponse)
+	if !ok || !hmac.Equal(r.Value, hmacSHA1(key, challenge)) {
+		return fmt.Errorf("%w: response of the applet doesn't match", ErrMalformedResponse)
+	}
+
+	o.challenge = nil
+
+	return nil
+}
+
+// SetPassword protects the applet with password, the applet must be unlocked.
+func (o *OATH) SetPassword(password []byte) error {
+	key := o.DeriveKey(password)
+
+	challenge := make([]byte, challengeLen)
+	if _, err := io.ReadFull(o.rand(), challenge); err != nil {
+		return fmt.Errorf("generating challenge: %w", err)
+	}
+
+	data, _ := bertlv.Marshal(
+		bertlv.TLV{Tag: tagKey, Value: append([]byte{byte(TypeTOTP) | byte(SHA1)}, key...)},
+		bertlv.TLV{Tag: tagChallenge, Value: challenge},
+		bertlv.TLV{Tag: tagResponse, Value: hmacSHA1(key, challenge)},
+	)
+
+	if _, err := o.transmit(piv.APDU{Instruction: insSetCode, Data: data}); err != nil {
+		return fmt.Errorf("setting password: %w", err)
+	}
+
+	return nil
+}
+
+// ClearPassword removes the password of the applet, the applet must be unlocked.
+func (o *OATH) ClearPassword() error {
+	data, _ := bertlv.Marshal(bertlv.TLV{Tag: tagKey})
+
+	if _, err := o.transmit(piv.APDU{Instruction: insSetCode, Data: data}); err != nil {
+		return fmt.Errorf("clearing password: %w", err)
+	}
+
+	return nil
+}
+
+// Reset deletes all credentials and the password, the device ID changes.
+func (o *OATH) Reset() error {
+	if _, err := o.transmit(piv.APDU{Instruction: insReset, Param1: 0xde, Param2: 0xad}); err != nil {
+		return fmt.Errorf("resetting oath applet: %w", err)
+	}
+
+	return o.selectApplet()
+}
+
+// Credentials lists the credentials of the applet, Touch isn't known from the list and is false.
+func (o *OATH) Credentials() ([]Credential, error) {
+	resp, err := o.transmit(piv.APDU{Instruction: insList})
+	if err != nil {
+		return nil, fmt.Errorf("listing credentials: %w", err)
+	}
+
+	tlvs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	var creds []Credential
+
+	for _, obj := range tlvs {
+		if obj.Tag != tagNameList || len(obj.Value) < 2 {
+			continue
+		}
+
+		cred := parseCredentialID(obj.Value[1:], Type(obj.Value[0]&typeMask))
+		cred.Algorithm = Algorithm(obj.Value[0] & algorithmMask)
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+// Put stores a credential, replacing the one with the same ID, and returns it.
+func (o *OATH) Put(data CredentialData, touch bool) (Credential, error) {
+	if err := data.validate(); err != nil {
+		return Credential{}, err
+	}
+
+	cred := data.Credential()
+	cred.Touch = touch
+
+	b, _ := bertlv.Marshal(
+		bertlv.TLV{Tag: tagName, Value: cred.ID()},
+		bertlv.TLV{Tag: tagKey, Value: append([]byte{byte(data.Type) | byte(data.Algorithm), byte(data.Digits)}, data.key()...)},
+	)
+
+	if touch {
+		// the property has no length.
+		b = append(b, byte(tagProperty), propertyTouch)
+	}
+
+	if data.Type == TypeHOTP && data.Counter > 0 {
+		imf, _ := bertlv.Marshal(bertlv.TLV{Tag: tagIMF, Value: binary.BigEndian.AppendUint32(nil, data.Counter)})
+		b = append(b, imf...)
+	}
+
+	if _, err := o.transmit(piv.APDU{Instruction: insPut, Data: b}); err != nil {
+		return Credential{}, fmt.Errorf("putting credential %q: %w", cred.ID(), err)
+	}
+
+	return cred, nil
+}
+
+// Delete removes cred from the applet.
+func (o *OATH) Delete(cred Credential) error {
+	data, _ := bertlv.Marshal(bertlv.TLV{Tag: tagName, Value: cred.ID()})
+
+	if _, err := o.transmit(piv.APDU{Instruction: insDelete, Data: data}); err != nil {
+		return fmt.Errorf("deleting credential %q: %w", cred.ID(), err)
+	}
+
+	return nil
+}
+
+// Calculate returns the code of cred at t, t is ignored for HOTP credentials whose counter is on the card.
+// TouchCallback is called first for credentials with Touch, the applet waits for the touch.
+func (o *OATH) Calculate(cred Credential, t time.Time) (*Code, error) {
+	var challenge []byte
+	if cred.Type == TypeTOTP {
+		challenge = timeStep(t, cred.period())
+	}
+
+	data, _ := bertlv.Marshal(
+		bertlv.TLV{Tag: tagName, Value: cred.ID()},
+		bertlv.TLV{Tag: tagChallenge, Value: challenge},
+	)
+
+	if cred.Touch && o.TouchCallback != nil {
+		o.TouchCallback()
+	}
+
+	resp, err := o.transmit(piv.APDU{Instruction: insCalculate, Param2: p2Truncate, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("calculating %q: %w", cred.ID(), err)
+	}
+
+	tlvs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	r, ok := bertlv.Find(tlvs, tagTruncated)
+	if !ok {
+		return nil, fmt.Errorf("%w: no truncated response", ErrMalformedResponse)
+	}
+
+	return newCode(r.Value, cred, t)
+}
+
+// CalculateAll returns the credentials with the codes of the TOTP credentials at t. The Code of HOTP
+// credentials and of credentials requiring touch is nil, these are calculated one at a time with Calculate.
+// Touch is set on the credentials that require it.
+func (o *OATH) CalculateAll(t time.Time) ([]CredentialCode, error) {
+	data, _ := bertlv.Marshal(bertlv.TLV{Tag: tagChallenge, Value: timeStep(t, DefaultPeriod)})
+
+	resp, err := o.transmit(piv.APDU{Instruction: insCalculateAll, Param2: p2Truncate, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("calculating codes: %w", err)
+	}
+
+	tlvs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	if len(tlvs)%2 != 0 {
+		return nil, fmt.Errorf("%w: %d objects, expected name and response pairs", ErrMalformedResponse, len(tlvs))
+	}
+
+	var codes []CredentialCode
+
+	for i := 0; i < len(tlvs); i += 2 {
+		name, r := tlvs[i], tlvs[i+1]
+		if name.Tag != tagName {
+			return nil, fmt.Errorf("%w: expected name, got tag %s", ErrMalformedResponse, name.Tag)
+		}
+
+		typ := TypeTOTP
+		if r.Tag == tagHOTP {
+			typ = TypeHOTP
+		}
+
+		cc := CredentialCode{Credential: parseCredentialID(name.Value, typ)}
+
+		switch r.Tag {
+		case tagTouch:
+			cc.Touch = true
+		case tagTruncated:
+			// the challenge was for 30 seconds, other periods need their own.
+			if cc.period() != DefaultPeriod {
+				cc.Code, err = o.Calculate(cc.Credential, t)
+			} else {
+				cc.Code, err = newCode(r.Value, cc.Credential, t)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		codes = append(codes, cc)
+	}
+
+	return codes, nil
+}
+
+// timeStep is the TOTP challenge, the number of periods since the epoch.
+func timeStep(t time.Time, period time.Duration) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.Unix()/int64(period/time.Second)))
+}
+
+func hmacSHA1(key, message []byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,419 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oath
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/apdu"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors of RFC 4226 and RFC 6238.
+const rfcSecret = "12345678901234567890"
+
+func openTestOATH(t *testing.T) (*OATH, *piv.Card) {
+	t.Helper()
+
+	card, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenCard(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("opening card: %v", err)
+	}
+
+	t.Cleanup(func() { card.Close() })
+
+	o, err := Open(card)
+	if err != nil {
+		t.Fatalf("opening oath applet: %v", err)
+	}
+
+	return o, card
+}
+
+func TestOpen(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	if v := o.Version(); v != (piv.Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("unexpected version %+v", v)
+	}
+
+	if o.Locked() {
+		t.Errorf("new applet is locked")
+	}
+
+	if o.DeviceID() == "" {
+		t.Errorf("no device id")
+	}
+}
+
+func TestCalculateTOTP(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	cred, err := o.Put(CredentialData{
+		Type:   TypeTOTP,
+		Issuer: "Example",
+		Name:   "alice@example.com",
+		Secret: []byte(rfcSecret),
+		Digits: 8,
+	}, false)
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// RFC 6238 Appendix B.
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{2000000000, "69279037"},
+	} {
+		code, err := o.Calculate(cred, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatalf("calculate: %v", err)
+		}
+
+		if code.Value != tc.code {
+			t.Errorf("code at %d: got %s expected %s", tc.unix, code.Value, tc.code)
+		}
+
+		if code.ValidTo.Sub(code.ValidFrom) != DefaultPeriod || code.ValidFrom.After(time.Unix(tc.unix, 0)) {
+			t.Errorf("code at %d valid from %v to %v", tc.unix, code.ValidFrom, code.ValidTo)
+		}
+	}
+}
+
+func TestCalculateHOTP(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	cred, err := o.Put(CredentialData{Type: TypeHOTP, Name: "counter", Secret: []byte(rfcSecret)}, false)
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// RFC 4226 Appendix D.
+	for _, expected := range []string{"755224", "287082", "359152"} {
+		code, err := o.Calculate(cred, time.Time{})
+		if err != nil {
+			t.Fatalf("calculate: %v", err)
+		}
+
+		if code.Value != expected {
+			t.Errorf("got %s expected %s", code.Value, expected)
+		}
+	}
+}
+
+func TestCalculateAll(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	for _, d := range []struct {
+		data  CredentialData
+		touch bool
+	}{
+		{CredentialData{Type: TypeTOTP, Name: "plain", Secret: []byte(rfcSecret), Digits: 8}, false},
+		{CredentialData{Type: TypeTOTP, Name: "touch", Secret: []byte(rfcSecret)}, true},
+		{CredentialData{Type: TypeTOTP, Name: "minute", Secret: []byte(rfcSecret), Period: time.Minute}, false},
+		{CredentialData{Type: TypeHOTP, Name: "counter", Secret: []byte(rfcSecret)}, false},
+	} {
+		if _, err := o.Put(d.data, d.touch); err != nil {
+			t.Fatalf("put %s: %v", d.data.Name, err)
+		}
+	}
+
+	codes, err := o.CalculateAll(time.Unix(59, 0))
+	if err != nil {
+		t.Fatalf("calculate all: %v", err)
+	}
+
+	if len(codes) != 4 {
+		t.Fatalf("got %d codes, expected 4", len(codes))
+	}
+
+	if codes[0].Code == nil || codes[0].Code.Value != "94287082" {
+		t.Errorf("unexpected code of %s: %+v", codes[0].Credential, codes[0].Code)
+	}
+
+	if !codes[1].Touch || codes[1].Code != nil {
+		t.Errorf("touch credential: %+v", codes[1])
+	}
+
+	if codes[2].Period != time.Minute || codes[2].Code == nil || codes[2].Code.ValidTo != time.Unix(60, 0) {
+		t.Errorf("minute credential: %+v", codes[2])
+	}
+
+	if codes[3].Type != TypeHOTP || codes[3].Code != nil {
+		t.Errorf("hotp credential: %+v", codes[3])
+	}
+
+	touched := false
+	o.TouchCallback = func() { touched = true }
+
+	if _, err := o.Calculate(codes[1].Credential, time.Now()); err != nil {
+		t.Fatalf("calculate touch credential: %v", err)
+	}
+
+	if !touched {
+		t.Errorf("touch callback not called")
+	}
+}
+
+func TestCredentialsAndDelete(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	cred, err := o.Put(CredentialData{
+		Type:      TypeTOTP,
+		Algorithm: SHA256,
+		Issuer:    "Example",
+		Name:      "bob",
+		Secret:    []byte(rfcSecret),
+		Period:    15 * time.Second,
+	}, false)
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if id := string(cred.ID()); id != "15/Example:bob" {
+		t.Errorf("unexpected id %q", id)
+	}
+
+	creds, err := o.Credentials()
+	if err != nil {
+		t.Fatalf("credentials: %v", err)
+	}
+
+	if !reflect.DeepEqual(creds, []Credential{cred}) {
+		t.Errorf("got %+v expected %+v", creds, []Credential{cred})
+	}
+
+	if err := o.Delete(cred); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	if err := o.Delete(cred); !errors.Is(err, apdu.ErrDataNotFound) {
+		t.Errorf("deleting twice: %v", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	o, card := openTestOATH(t)
+
+	if _, err := o.Put(CredentialData{Type: TypeTOTP, Name: "a", Secret: []byte(rfcSecret)}, false); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := o.SetPassword([]byte("secret")); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+
+	// another applet resets the validation.
+	if _, err := card.PIV(); err != nil {
+		t.Fatalf("selecting piv: %v", err)
+	}
+
+	o, err := Open(card)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if !o.Locked() {
+		t.Fatalf("applet with a password isn't locked")
+	}
+
+	if _, err := o.Credentials(); !errors.Is(err, apdu.ErrSecurityStatusNotSatisfied) {
+		t.Errorf("listing locked applet: %v", err)
+	}
+
+	if err := o.Unlock([]byte("wrong")); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("unlocking with the wrong password: %v", err)
+	}
+
+	if err := o.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+
+	if creds, err := o.Credentials(); err != nil || len(creds) != 1 {
+		t.Errorf("credentials after unlock: %v %v", creds, err)
+	}
+
+	if err := o.ClearPassword(); err != nil {
+		t.Fatalf("clear password: %v", err)
+	}
+
+	if err := o.Unlock(nil); err != nil || o.Locked() {
+		t.Errorf("applet without password: locked %v %v", o.Locked(), err)
+	}
+}
+
+func TestPut_TraceRedacted(t *testing.T) {
+	var trace bytes.Buffer
+
+	client := pivtest.NewClient(pivtest.NewCard(pivtest.Options{}))
+	client.SCConstruct = piv.NewTraceRecorder(client.SCConstruct, &trace)
+
+	card, err := client.OpenCard(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("opening card: %v", err)
+	}
+	defer card.Close()
+
+	o, err := Open(card)
+	if err != nil {
+		t.Fatalf("opening oath applet: %v", err)
+	}
+
+	if _, err := o.Put(CredentialData{Type: TypeTOTP, Name: "a", Secret: []byte(rfcSecret)}, false); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := o.SetPassword([]byte("secret")); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+
+	var put, setCode bool
+
+	for _, line := range strings.Split(strings.TrimSpace(trace.String()), "\n") {
+		var e piv.TraceEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("trace entry %s: %v", line, err)
+		}
+
+		if strings.Contains(e.Command, hex.EncodeToString([]byte(rfcSecret))) {
+			t.Errorf("trace has the secret: %s", line)
+		}
+
+		switch {
+		case strings.HasPrefix(e.Command, "00010000"):
+			put = true
+		case strings.HasPrefix(e.Command, "00030000"):
+			setCode = true
+		default:
+			continue
+		}
+
+		if data := strings.TrimLeft(e.Command[8:], "0"); !e.CommandRedacted || data != "" {
+			t.Errorf("command isn't zeroed: %s", line)
+		}
+	}
+
+	if !put || !setCode {
+		t.Errorf("trace has put %t set code %t:\n%s", put, setCode, trace.String())
+	}
+}
+
+func TestReset(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	if _, err := o.Put(CredentialData{Type: TypeTOTP, Name: "a", Secret: []byte(rfcSecret)}, false); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := o.SetPassword([]byte("secret")); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+
+	id := o.DeviceID()
+
+	if err := o.Reset(); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+
+	if o.Locked() || o.DeviceID() == id {
+		t.Errorf("after reset: locked %v, device id %s was %s", o.Locked(), o.DeviceID(), id)
+	}
+
+	if creds, err := o.Credentials(); err != nil || len(creds) != 0 {
+		t.Errorf("credentials after reset: %v %v", creds, err)
+	}
+}
+
+func TestPut_Invalid(t *testing.T) {
+	o, _ := openTestOATH(t)
+
+	for _, d := range []CredentialData{
+		{Type: TypeTOTP, Name: "a"},
+		{Type: TypeTOTP, Secret: []byte(rfcSecret)},
+		{Type: 0x30, Name: "a", Secret: []byte(rfcSecret)},
+		{Type: TypeTOTP, Name: "a", Secret: []byte(rfcSecret), Digits: 9},
+		{Type: TypeTOTP, Name: string(make([]byte, 65)), Secret: []byte(rfcSecret)},
+	} {
+		if _, err := o.Put(d, false); !errors.Is(err, ErrInvalidCredential) {
+			t.Errorf("put %+v: %v", d, err)
+		}
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	d, err := ParseURI("otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example&digits=8&period=60&algorithm=SHA256")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	expected := &CredentialData{
+		Type:      TypeTOTP,
+		Algorithm: SHA256,
+		Issuer:    "Example",
+		Name:      "alice@example.com",
+		Secret:    []byte("Hello!\xde\xad\xbe\xef"),
+		Digits:    8,
+		Period:    time.Minute,
+	}
+
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("got %+v expected %+v", d, expected)
+	}
+
+	for _, uri := range []string{
+		"https://totp/a?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://motp/a?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/a?secret=1",
+		"otpauth://totp/a?secret=JBSWY3DPEHPK3PXP&digits=x",
+	} {
+		if _, err := ParseURI(uri); !errors.Is(err, ErrInvalidURI) {
+			t.Errorf("%s: %v", uri, err)
+		}
+	}
+}
+
+func TestParseCredentialID(t *testing.T) {
+	for _, tc := range []struct {
+		id       string
+		typ      Type
+		expected Credential
+	}{
+		{"name", TypeTOTP, Credential{Type: TypeTOTP, Name: "name", Period: DefaultPeriod}},
+		{"60/Issuer:name", TypeTOTP, Credential{Type: TypeTOTP, Issuer: "Issuer", Name: "name", Period: time.Minute}},
+		{"Issuer:a:b", TypeHOTP, Credential{Type: TypeHOTP, Issuer: "Issuer", Name: "a:b"}},
+		{"60/name", TypeHOTP, Credential{Type: TypeHOTP, Name: "60/name"}},
+	} {
+		cred := parseCredentialID([]byte(tc.id), tc.typ)
+		if cred != tc.expected {
+			t.Errorf("%s: got %+v expected %+v", tc.id, cred, tc.expected)
+		}
+
+		if string(cred.ID()) != tc.id {
+			t.Errorf("%s: id round trips to %s", tc.id, cred.ID())
+		}
+	}
+}
@@ -0,0 +1,482 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// Data objects of SP 800-73-4 Part 1, Table 3, that Windows and other middleware read to
+// recognize a card. YubiKeys ship without them.
+// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=30
+const (
+	ObjectCCC                = 0x5fc107
+	ObjectCHUID              = 0x5fc102
+	ObjectPrintedInformation = 0x5fc109
+)
+
+// chuidExpirationLayout is the YYYYMMDD format of the expiration date of the CHUID.
+const chuidExpirationLayout = "20060102"
+
+var (
+	// DefaultFASCN is the FASC-N of the CHUID that YubiKey manager writes, agency code 9999
+	// which marks it as not issued by a federal agency.
+	DefaultFASCN = []byte{
+		0xd4, 0xe7, 0x39, 0xda, 0x73, 0x9c, 0xed, 0x39, 0xce, 0x73, 0x9d, 0x83, 0x68,
+		0x58, 0x21, 0x08, 0x42, 0x10, 0x84, 0x21, 0xc8, 0x42, 0x10, 0xc3, 0xeb,
+	}
+
+	// cccCardIDPrefix is the GSC-RID and manufacturer of the card identifier of the CCC.
+	cccCardIDPrefix = []byte{0xa0, 0x00, 0x00, 0x01, 0x16, 0xff, 0x02}
+)
+
+var (
+	// ErrInvalidObjectTag is returned for tags that aren't PIV or Yubico data objects.
+	ErrInvalidObjectTag = errors.New("invalid data object tag")
+	// ErrObjectTooLarge is returned for data larger than FirmwareFeatures.MaxObjectSize.
+	ErrObjectTooLarge = errors.New("data object too large")
+)
+
+// ErrPrintedInformationInUse is returned by SetPrintedInformation when the object holds the
+// PIN protected management key, see EnablePINOnly. YubiKeys store that metadata in the same object.
+var ErrPrintedInformationInUse = errors.New("printed information object holds the protected management key")
+
+// CHUID is the Card Holder Unique Identifier, SP 800-73-4 Part 1, 3.1.2.
+type CHUID struct {
+	// FASCN is the Federal Agency Smart Credential Number, 25 bytes.
+	FASCN []byte
+	// GUID identifies the card, Windows uses it to tell cards apart.
+	GUID [16]byte
+	// Expiration is the date the CHUID expires, without the time of day.
+	Expiration time.Time
+}
+
+// NewCHUID returns a CHUID with DefaultFASCN, a random GUID and expiring in 2030, like YubiKey manager.
+func NewCHUID(rand io.Reader) (*CHUID, error) {
+	c := &CHUID{
+		FASCN:      DefaultFASCN,
+		Expiration: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	if _, err := io.ReadFull(rand, c.GUID[:]); err != nil {
+		return nil, fmt.Errorf("generating guid: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *CHUID) marshal() ([]byte, error) {
+	return bertlv.Marshal(
+		bertlv.TLV{Tag: 0x30, Value: c.FASCN},
+		bertlv.TLV{Tag: 0x34, Value: c.GUID[:]},
+		bertlv.TLV{Tag: 0x35, Value: []byte(c.Expiration.Format(chuidExpirationLayout))},
+		// the issuer asymmetric signature is empty, the CHUID isn't signed.
+		bertlv.TLV{Tag: 0x3e},
+		bertlv.TLV{Tag: 0xfe},
+	)
+}
+
+func (c *CHUID) unmarshal(b []byte) error {
+	objs, err := bertlv.Unmarshal(b)
+	if err != nil {
+		return err
+	}
+
+	if fascn, ok := bertlv.Find(objs, 0x30); ok {
+		c.FASCN = append([]byte(nil), fascn.Value...)
+	}
+
+	guid, ok := bertlv.Find(objs, 0x34)
+	if !ok || len(guid.Value) != len(c.GUID) {
+		return fmt.Errorf("invalid guid")
+	}
+
+	copy(c.GUID[:], guid.Value)
+
+	if exp, ok := bertlv.Find(objs, 0x35); ok {
+		if c.Expiration, err = time.Parse(chuidExpirationLayout, string(exp.Value)); err != nil {
+			return fmt.Errorf("parsing expiration date: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CHUID reads the CHUID object. It returns an error wrapping ErrNotFound when the card has none.
+func (yk *YubiKey) CHUID() (*CHUID, error) {
+	b, err := ykGetObject(yk.tx, ObjectCHUID)
+	if err != nil {
+		return nil, fmt.Errorf("reading chuid: %w", err)
+	}
+
+	var c CHUID
+	if err := c.unmarshal(b); err != nil {
+		return nil, fmt.Errorf("parsing chuid: %w", err)
+	}
+
+	return &c, nil
+}
+
+// SetCHUID writes the CHUID object, use NewCHUID for a new one.
+func (yk *YubiKey) SetCHUID(key [24]byte, c *CHUID) error {
+	b, err := c.marshal()
+	if err != nil {
+		return fmt.Errorf("encoding chuid: %w", err)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	return ykPutObject(yk.tx, ObjectCHUID, b)
+}
+
+// CCC is the Card Capability Container, SP 800-73-4 Part 1, 3.1.1. Its card identifier
+// changes when the card is reprovisioned, which makes Windows drop its cached certificates.
+type CCC struct {
+	// CardIdentifier is the GSC-RID, manufacturer and card identifier, 21 bytes.
+	CardIdentifier []byte
+	// ContainerVersion and GrammarVersion are the capability container and grammar versions.
+	ContainerVersion byte
+	GrammarVersion   byte
+}
+
+// NewCCC returns a CCC with a random card identifier, like YubiKey manager.
+func NewCCC(rand io.Reader) (*CCC, error) {
+	id := make([]byte, 21)
+	copy(id, cccCardIDPrefix)
+
+	if _, err := io.ReadFull(rand, id[len(cccCardIDPrefix):]); err != nil {
+		return nil, fmt.Errorf("generating card identifier: %w", err)
+	}
+
+	return &CCC{CardIdentifier: id, ContainerVersion: 0x21, GrammarVersion: 0x21}, nil
+}
+
+func (c *CCC) marshal() ([]byte, error) {
+	return bertlv.Marshal(
+		bertlv.TLV{Tag: 0xf0, Value: c.CardIdentifier},
+		bertlv.TLV{Tag: 0xf1, Value: []byte{c.ContainerVersion}},
+		bertlv.TLV{Tag: 0xf2, Value: []byte{c.GrammarVersion}},
+		bertlv.TLV{Tag: 0xf3},
+		// PKCS#15 isn't supported.
+		bertlv.TLV{Tag: 0xf4, Value: []byte{0x00}},
+		// the data model of SP 800-73.
+		bertlv.TLV{Tag: 0xf5, Value: []byte{0x10}},
+		bertlv.TLV{Tag: 0xf6},
+		bertlv.TLV{Tag: 0xf7},
+		bertlv.TLV{Tag: 0xfa},
+		bertlv.TLV{Tag: 0xfb},
+		bertlv.TLV{Tag: 0xfc},
+		bertlv.TLV{Tag: 0xfd},
+		bertlv.TLV{Tag: 0xfe},
+	)
+}
+
+func (c *CCC) unmarshal(b []byte) error {
+	objs, err := bertlv.Unmarshal(b)
+	if err != nil {
+		return err
+	}
+
+	id, ok := bertlv.Find(objs, 0xf0)
+	if !ok {
+		return fmt.Errorf("no card identifier")
+	}
+
+	c.CardIdentifier = append([]byte(nil), id.Value...)
+
+	if v, ok := bertlv.Find(objs, 0xf1); ok && len(v.Value) == 1 {
+		c.ContainerVersion = v.Value[0]
+	}
+
+	if v, ok := bertlv.Find(objs, 0xf2); ok && len(v.Value) == 1 {
+		c.GrammarVersion = v.Value[0]
+	}
+
+	return nil
+}
+
+// CCC reads the Card Capability Container. It returns an error wrapping ErrNotFound when the card has none.
+func (yk *YubiKey) CCC() (*CCC, error) {
+	b, err := ykGetObject(yk.tx, ObjectCCC)
+	if err != nil {
+		return nil, fmt.Errorf("reading ccc: %w", err)
+	}
+
+	var c CCC
+	if err := c.unmarshal(b); err != nil {
+		return nil, fmt.Errorf("parsing ccc: %w", err)
+	}
+
+	return &c, nil
+}
+
+// SetCCC writes the Card Capability Container, use NewCCC for a new one.
+func (yk *YubiKey) SetCCC(key [24]byte, c *CCC) error {
+	b, err := c.marshal()
+	if err != nil {
+		return fmt.Errorf("encoding ccc: %w", err)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	return ykPutObject(yk.tx, ObjectCCC, b)
+}
+
+// ProvisionIdentifiers writes a new CHUID and CCC, which Windows and some ssh-agent middleware
+// need before they use the certificates of a card.
+func (yk *YubiKey) ProvisionIdentifiers(key [24]byte) error {
+	chuid, err := NewCHUID(yk.rand)
+	if err != nil {
+		return err
+	}
+
+	ccc, err := NewCCC(yk.rand)
+	if err != nil {
+		return err
+	}
+
+	if err := yk.SetCHUID(key, chuid); err != nil {
+		return err
+	}
+
+	return yk.SetCCC(key, ccc)
+}
+
+// PrintedInformation is the information printed on the card, SP 800-73-4 Part 1, 3.1.5.
+// Each field has a maximum length, longer values are rejected by SetPrintedInformation.
+type PrintedInformation struct {
+	Name                     string
+	EmployeeAffiliation      string
+	Expiration               string
+	AgencyCardSerial         string
+	IssuerIdentification     string
+	OrganizationAffiliation1 string
+	OrganizationAffiliation2 string
+}
+
+// fields returns the tags, values and maximum lengths of the printed information.
+func (p *PrintedInformation) fields() []struct {
+	tag   bertlv.Tag
+	value *string
+	max   int
+} {
+	return []struct {
+		tag   bertlv.Tag
+		value *string
+		max   int
+	}{
+		{0x01, &p.Name, 125},
+		{0x02, &p.EmployeeAffiliation, 20},
+		{0x04, &p.Expiration, 9},
+		{0x05, &p.AgencyCardSerial, 20},
+		{0x06, &p.IssuerIdentification, 15},
+		{0x07, &p.OrganizationAffiliation1, 20},
+		{0x08, &p.OrganizationAffiliation2, 20},
+	}
+}
+
+func (p *PrintedInformation) marshal() ([]byte, error) {
+	var objs []bertlv.TLV
+
+	for _, f := range p.fields() {
+		if *f.value == "" {
+			continue
+		}
+
+		if len(*f.value) > f.max {
+			return nil, fmt.Errorf("field %s is %d bytes, at most %d are allowed", f.tag, len(*f.value), f.max)
+		}
+
+		objs = append(objs, bertlv.TLV{Tag: f.tag, Value: []byte(*f.value)})
+	}
+
+	return bertlv.Marshal(append(objs, bertlv.TLV{Tag: 0xfe})...)
+}
+
+func (p *PrintedInformation) unmarshal(b []byte) error {
+	objs, err := bertlv.Unmarshal(b)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range p.fields() {
+		if v, ok := bertlv.Find(objs, f.tag); ok {
+			*f.value = string(v.Value)
+		}
+	}
+
+	return nil
+}
+
+// PrintedInformation reads the printed information object, which requires the PIN.
+// It returns an error wrapping ErrNotFound when the card has none.
+func (yk *YubiKey) PrintedInformation(pin string) (*PrintedInformation, error) {
+	if err := ykLogin(yk.tx, pin); err != nil {
+		return nil, fmt.Errorf("authenticating with pin: %w", err)
+	}
+
+	b, err := ykGetObject(yk.tx, ObjectPrintedInformation)
+	if err != nil {
+		return nil, fmt.Errorf("reading printed information: %w", err)
+	}
+
+	var p PrintedInformation
+	if err := p.unmarshal(b); err != nil {
+		return nil, fmt.Errorf("parsing printed information: %w", err)
+	}
+
+	return &p, nil
+}
+
+// SetPrintedInformation writes the printed information object. It returns ErrPrintedInformationInUse
+// on cards that store the management key PIN protected, which would be overwritten.
+func (yk *YubiKey) SetPrintedInformation(key [24]byte, p *PrintedInformation) error {
+	if mode, err := yk.ManagementKeyMode(); err != nil {
+		return fmt.Errorf("reading management key mode: %w", err)
+	} else if mode == ManagementKeyModeProtected {
+		return ErrPrintedInformationInUse
+	}
+
+	b, err := p.marshal()
+	if err != nil {
+		return fmt.Errorf("encoding printed information: %w", err)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	return ykPutObject(yk.tx, ObjectPrintedInformation, b)
+}
+
+// ValidObjectTag reports whether tag is a data object of the PIV applet: the SP 800-73-4
+// objects 5FC101 to 5FC123, the discovery object 7E, the biometric group template 7F61,
+// or the range 5FFF00 to 5FFFFF that YubiKeys keep for their own and vendor objects.
+func ValidObjectTag(tag uint32) bool {
+	switch {
+	case tag == 0x7e, tag == 0x7f61:
+		return true
+	case tag >= 0x5fc101 && tag <= 0x5fc123:
+		return true
+	default:
+		return tag >= 0x5fff00 && tag <= 0x5fffff
+	}
+}
+
+// GetObject reads the data object tag and returns its value, without the 53 tag the card
+// wraps it in. It returns an error wrapping ErrNotFound when the object is empty.
+// Some objects, like ObjectPrintedInformation, require the PIN.
+func (yk *YubiKey) GetObject(tag uint32) ([]byte, error) {
+	if !ValidObjectTag(tag) {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidObjectTag, tag)
+	}
+
+	b, err := ykGetObject(yk.tx, tag)
+	if err != nil {
+		return nil, fmt.Errorf("reading object %x: %w", tag, err)
+	}
+
+	return b, nil
+}
+
+// PutObject writes data as the value of the data object tag, which stores custom data without
+// a fork of this package. Empty data deletes the object. The data can't be larger than the
+// MaxObjectSize of the firmware.
+func (yk *YubiKey) PutObject(key [24]byte, tag uint32, data []byte) error {
+	if !ValidObjectTag(tag) {
+		return fmt.Errorf("%w: %x", ErrInvalidObjectTag, tag)
+	}
+
+	if max := yk.Features().MaxObjectSize(); len(data) > max {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrObjectTooLarge, len(data), max)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	if err := ykPutObject(yk.tx, tag, data); err != nil {
+		return fmt.Errorf("writing object %x: %w", tag, err)
+	}
+
+	return nil
+}
+
+// ykGetObject reads a data object and returns the value of its 53 tag.
+func ykGetObject(tx SCTx, object uint32) ([]byte, error) {
+	data, err := bertlv.Marshal(objectTagList(object))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := apdu{
+		instruction: insGetData,
+		param1:      0x3f,
+		param2:      0xff,
+		data:        data,
+	}
+	resp, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	objs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	obj, ok := bertlv.Find(objs, 0x53)
+	if !ok {
+		return nil, fmt.Errorf("unmarshaling response: no data object")
+	}
+
+	return obj.Value, nil
+}
+
+// ykPutObject writes data as the value of the 53 tag of a data object, the management key
+// must have been authenticated on tx.
+func ykPutObject(tx SCTx, object uint32, data []byte) error {
+	b, err := bertlv.Marshal(objectTagList(object), bertlv.TLV{Tag: 0x53, Value: data})
+	if err != nil {
+		return err
+	}
+
+	cmd := apdu{
+		instruction: insPutData,
+		param1:      0x3f,
+		param2:      0xff,
+		data:        b,
+	}
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
+
+// objectTagList is the 5C tag list naming a data object, the discovery object 7E is one byte,
+// the biometric group template 7F61 two and the others three.
+func objectTagList(object uint32) bertlv.TLV {
+	return bertlv.TLV{Tag: 0x5c, Value: bertlv.Tag(object).Bytes()}
+}
@@ -0,0 +1,137 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+)
+
+func TestYubiKey_ProvisionIdentifiers(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+
+	if _, err := yk.CHUID(); !errors.Is(err, piv.ErrNotFound) {
+		t.Fatalf("chuid of a new card: %v", err)
+	}
+
+	if err := yk.ProvisionIdentifiers(piv.DefaultManagementKey); err != nil {
+		t.Fatalf("provisioning: %v", err)
+	}
+
+	chuid, err := yk.CHUID()
+	if err != nil {
+		t.Fatalf("reading chuid: %v", err)
+	}
+
+	if chuid.GUID == [16]byte{} || !bytes.Equal(chuid.FASCN, piv.DefaultFASCN) || chuid.Expiration.Year() != 2030 {
+		t.Errorf("unexpected chuid %+v", chuid)
+	}
+
+	ccc, err := yk.CCC()
+	if err != nil {
+		t.Fatalf("reading ccc: %v", err)
+	}
+
+	if len(ccc.CardIdentifier) != 21 || ccc.CardIdentifier[0] != 0xa0 || ccc.ContainerVersion != 0x21 || ccc.GrammarVersion != 0x21 {
+		t.Errorf("unexpected ccc %+v", ccc)
+	}
+
+	// a new CHUID gets a new GUID.
+	if err := yk.ProvisionIdentifiers(piv.DefaultManagementKey); err != nil {
+		t.Fatalf("provisioning again: %v", err)
+	}
+
+	if again, err := yk.CHUID(); err != nil || again.GUID == chuid.GUID {
+		t.Errorf("guid after provisioning again: %v", err)
+	}
+}
+
+func TestYubiKey_PrintedInformation(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+
+	p := &piv.PrintedInformation{Name: "Alice Example", EmployeeAffiliation: "Employee", Expiration: "2030JAN01"}
+	if err := yk.SetPrintedInformation(piv.DefaultManagementKey, p); err != nil {
+		t.Fatalf("setting: %v", err)
+	}
+
+	got, err := yk.PrintedInformation(piv.DefaultPIN)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+
+	if *got != *p {
+		t.Errorf("got %+v expected %+v", got, p)
+	}
+
+	if err := yk.SetPrintedInformation(piv.DefaultManagementKey, &piv.PrintedInformation{IssuerIdentification: "an issuer that is too long"}); err == nil {
+		t.Errorf("set a field longer than its maximum")
+	}
+
+	// the printed information object holds the protected management key.
+	yk = openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+	if _, err := yk.EnablePINOnly(piv.DefaultManagementKey, piv.DefaultPIN); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := yk.SetPrintedInformation(piv.DefaultManagementKey, p); !errors.Is(err, piv.ErrPrintedInformationInUse) {
+		t.Errorf("setting with a protected management key: %v", err)
+	}
+}
+
+func TestYubiKey_PutObject(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+	mk := piv.DefaultManagementKey
+	data := bytes.Repeat([]byte("custom data "), 200)
+
+	for _, tag := range []uint32{0x5fff10, 0x5fc105, 0x7e} {
+		if err := yk.PutObject(mk, tag, data); err != nil {
+			t.Fatalf("putting %x: %v", tag, err)
+		}
+
+		got, err := yk.GetObject(tag)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("getting %x: %d bytes, %v", tag, len(got), err)
+		}
+
+		if err := yk.PutObject(mk, tag, nil); err != nil {
+			t.Fatalf("deleting %x: %v", tag, err)
+		}
+
+		if _, err := yk.GetObject(tag); !errors.Is(err, piv.ErrNotFound) {
+			t.Errorf("getting deleted %x: %v", tag, err)
+		}
+	}
+
+	if err := yk.PutObject(mk, 0x5fc201, data); !errors.Is(err, piv.ErrInvalidObjectTag) {
+		t.Errorf("putting an invalid tag: %v", err)
+	}
+
+	if _, err := yk.GetObject(0x9a); !errors.Is(err, piv.ErrInvalidObjectTag) {
+		t.Errorf("getting an invalid tag: %v", err)
+	}
+
+	if err := yk.PutObject(mk, 0x5fff10, make([]byte, 3064)); !errors.Is(err, piv.ErrObjectTooLarge) {
+		t.Errorf("putting a large object: %v", err)
+	}
+}
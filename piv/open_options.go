@@ -0,0 +1,231 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrTransactionNotSupported is returned when the transport can't end and begin transactions.
+var ErrTransactionNotSupported = errors.New("transactions not supported by the transport")
+
+// SCardConnect share modes and protocols, they are the same for pcsc-lite, macOS and Windows.
+// https://pcsclite.apdu.fr/api/group__API.html#ga4e515829752e0a8dbc4d630696a8d6a5
+const (
+	scardShareExclusive = 0x0001
+	scardShareShared    = 0x0002
+	scardProtocolT0     = 0x0001
+	scardProtocolT1     = 0x0002
+)
+
+// ShareMode is how a card is shared with other programs.
+type ShareMode int
+
+const (
+	// ShareExclusive keeps other programs from connecting to the card while it's open.
+	ShareExclusive ShareMode = iota
+	// ShareShared lets other programs, such as gpg-agent and scdaemon, connect to the card too.
+	// Access is serialized with transactions, see BeginTransaction and EndTransaction.
+	ShareShared
+)
+
+func (m ShareMode) pcsc() uint32 {
+	if m == ShareShared {
+		return scardShareShared
+	}
+
+	return scardShareExclusive
+}
+
+func (m ShareMode) String() string {
+	switch m {
+	case ShareExclusive:
+		return "exclusive"
+	case ShareShared:
+		return "shared"
+	default:
+		return fmt.Sprintf("ShareMode(%d)", int(m))
+	}
+}
+
+// Protocol is a set of transmission protocols the card may be connected with.
+type Protocol int
+
+const (
+	ProtocolT0 Protocol = 1 << iota
+	ProtocolT1
+)
+
+// pcsc returns the protocols for SCardConnect, T=1 if none are set.
+func (p Protocol) pcsc() uint32 {
+	var protocols uint32
+
+	if p&ProtocolT0 != 0 {
+		protocols |= scardProtocolT0
+	}
+
+	if p&ProtocolT1 != 0 || protocols == 0 {
+		protocols |= scardProtocolT1
+	}
+
+	return protocols
+}
+
+// OpenOptions are how a card is connected to, the zero value connects exclusively with T=1.
+type OpenOptions struct {
+	ShareMode ShareMode
+	// Protocols the card may use, T=1 if none are set.
+	Protocols Protocol
+	// Logger, if set, gets the APDUs exchanged with the card at debug level, with PINs, keys and
+	// decrypted data redacted, and errors closing the connection after a failed open.
+	Logger *slog.Logger
+}
+
+// optionsContext is implemented by contexts that can connect with OpenOptions.
+type optionsContext interface {
+	connectWithOptions(reader string, opts OpenOptions) (SCHandle, error)
+}
+
+// TransactionTx is implemented by transports whose transaction can be ended and begun again.
+type TransactionTx interface {
+	BeginTransaction() error
+	EndTransaction() error
+}
+
+// connectWithOptions connects to card, only contexts that implement optionsContext can use options.
+// nolint:ireturn
+func connectWithOptions(ctx SCContext, card string, opts OpenOptions) (SCHandle, error) {
+	// the logger is for the transaction, not the connection.
+	opts.Logger = nil
+
+	if c, ok := ctx.(optionsContext); ok {
+		return c.connectWithOptions(card, opts)
+	}
+
+	if opts != (OpenOptions{}) {
+		return nil, fmt.Errorf("%w: %T can't connect with options", ErrNotSupportedByCard, ctx)
+	}
+
+	return ctx.Connect(card)
+}
+
+// transactionTx returns the transaction of tx, looking through secure messaging.
+// nolint:ireturn
+func transactionTx(tx SCTx) (TransactionTx, error) {
+	tx = unwrapContextTx(tx)
+
+	switch sm := tx.(type) {
+	case *smTx:
+		tx = sm.SCTx
+	case *pivSMTx:
+		tx = sm.SCTx
+	}
+
+	t, ok := tx.(TransactionTx)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrTransactionNotSupported, tx)
+	}
+
+	return t, nil
+}
+
+// EndTransaction ends the transaction begun when the card was opened, so other programs can use a card
+// opened with ShareShared. Call BeginTransaction before using the card again.
+// It isn't possible with secure messaging, selecting the applet again would end the session.
+func (yk *YubiKey) EndTransaction() error {
+	if _, ok := unwrapContextTx(yk.tx).(*pivSMTx); ok {
+		return fmt.Errorf("%w: ending the transaction", ErrSecureMessaging)
+	}
+
+	yk.pins.cache.endTransaction(yk.pins.card)
+
+	t, err := transactionTx(yk.tx)
+	if err != nil {
+		return err
+	}
+
+	return t.EndTransaction()
+}
+
+// BeginTransaction begins a transaction after EndTransaction and selects the PIV applet again,
+// another program may have selected a different one. The PIN has to be verified again if it
+// was used in between. It waits for a command abandoned by a context to finish first.
+func (yk *YubiKey) BeginTransaction() error {
+	waitPending(&yk.pending, &yk.tx)
+
+	t, err := transactionTx(yk.tx)
+	if err != nil {
+		return err
+	}
+
+	if err := t.BeginTransaction(); err != nil {
+		return fmt.Errorf("beginning smart card transaction: %w", err)
+	}
+
+	if err := ykSelectApplication(yk.tx, aidPIV[:]); err != nil {
+		return fmt.Errorf("selecting piv applet: %w", err)
+	}
+
+	return nil
+}
+
+// EndTransaction ends the transaction begun when the card was opened, so other programs can use a card
+// opened with ShareShared. Call BeginTransaction before using the card again.
+// It isn't possible with secure messaging, selecting the applet again would end the session.
+func (yk *GPGYubiKey) EndTransaction() error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.EndTransaction\u001b[0m")
+	}
+
+	if _, ok := unwrapContextTx(yk.tx).(*smTx); ok {
+		return fmt.Errorf("%w: ending the transaction", ErrSecureMessaging)
+	}
+
+	t, err := transactionTx(yk.tx)
+	if err != nil {
+		return err
+	}
+
+	return t.EndTransaction()
+}
+
+// BeginTransaction begins a transaction after EndTransaction and selects the OpenPGP applet again,
+// another program may have selected a different one. The PINs have to be verified again if they
+// were used in between. It waits for a command abandoned by a context to finish first.
+func (yk *GPGYubiKey) BeginTransaction() error {
+	if yk.trace {
+		fmt.Println("\u001b[31mGPGYubiKey.BeginTransaction\u001b[0m")
+	}
+
+	waitPending(&yk.pending, &yk.tx)
+
+	t, err := transactionTx(yk.tx)
+	if err != nil {
+		return err
+	}
+
+	if err := t.BeginTransaction(); err != nil {
+		return fmt.Errorf("beginning smart card transaction: %w", err)
+	}
+
+	if err := ykSelectOpenGPGApplication(yk.tx); err != nil {
+		return fmt.Errorf("selecting openpgp applet: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpenOptions_PCSC(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		opts      OpenOptions
+		share     uint32
+		protocols uint32
+	}{
+		{opts: OpenOptions{}, share: scardShareExclusive, protocols: scardProtocolT1},
+		{opts: OpenOptions{ShareMode: ShareShared}, share: scardShareShared, protocols: scardProtocolT1},
+		{opts: OpenOptions{Protocols: ProtocolT0}, share: scardShareExclusive, protocols: scardProtocolT0},
+		{opts: OpenOptions{Protocols: ProtocolT0 | ProtocolT1}, share: scardShareExclusive, protocols: scardProtocolT0 | scardProtocolT1},
+	}
+
+	for _, tc := range tests {
+		if share := tc.opts.ShareMode.pcsc(); share != tc.share {
+			t.Errorf("%+v: got share mode 0x%x expected 0x%x", tc.opts, share, tc.share)
+		}
+
+		if protocols := tc.opts.Protocols.pcsc(); protocols != tc.protocols {
+			t.Errorf("%+v: got protocols 0x%x expected 0x%x", tc.opts, protocols, tc.protocols)
+		}
+	}
+}
+
+func TestConnectWithOptions(t *testing.T) {
+	t.Parallel()
+
+	handle := &TestSCHandle{}
+	ctx := &TestSCContext{Handle: handle}
+	ctx.ConnectFunc = ctx.SimpleConnect
+
+	h, err := connectWithOptions(ctx, "", OpenOptions{})
+	if err != nil || h != handle {
+		t.Errorf("got %v %v expected the handle", h, err)
+	}
+
+	// the test context can't connect in shared mode.
+	if _, err := connectWithOptions(ctx, "", OpenOptions{ShareMode: ShareShared}); !errors.Is(err, ErrNotSupportedByCard) {
+		t.Errorf("got %v expected %v", err, ErrNotSupportedByCard)
+	}
+}
+
+// transactionTestTx is a TestSCTx that counts transactions.
+type transactionTestTx struct {
+	*TestSCTx
+	begun, ended int
+}
+
+func (tx *transactionTestTx) BeginTransaction() error {
+	tx.begun++
+
+	return nil
+}
+
+func (tx *transactionTestTx) EndTransaction() error {
+	tx.ended++
+
+	return nil
+}
+
+func TestGPGYubiKey_Transaction(t *testing.T) {
+	t.Parallel()
+
+	tx := &transactionTestTx{TestSCTx: &TestSCTx{
+		APDUList:     []apdu{{instruction: insSelectApplication, param1: paramOpenGPGASelectApplication, data: aidOpenPGP[:]}},
+		ResponseList: [][]byte{nil},
+	}}
+
+	yk := NewTestGpgYubikey(&GpgData{}, false, nil)
+	yk.tx = tx
+
+	if err := yk.EndTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the applet is selected again when the transaction begins.
+	if err := yk.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.begun != 1 || tx.ended != 1 || tx.CurrentAPDUIndex != 1 {
+		t.Errorf("got %d begun %d ended %d apdus expected 1 of each", tx.begun, tx.ended, tx.CurrentAPDUIndex)
+	}
+
+	yk.tx = &smTx{SCTx: tx}
+	if err := yk.EndTransaction(); !errors.Is(err, ErrSecureMessaging) {
+		t.Errorf("got %v expected %v", err, ErrSecureMessaging)
+	}
+
+	yk.tx = &TestSCTx{}
+	if err := yk.BeginTransaction(); !errors.Is(err, ErrTransactionNotSupported) {
+		t.Errorf("got %v expected %v", err, ErrTransactionNotSupported)
+	}
+}
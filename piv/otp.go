@@ -0,0 +1,460 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The OTP applet (aidYubiKey) exposes the two keyboard slots of the YubiKey over CCID.
+// Every slot command is INS 0x01 with the slot command in P1.
+// https://github.com/Yubico/yubikey-personalization/blob/master/ykcore/ykdef.h
+// https://github.com/Yubico/yubikey-manager/blob/main/yubikit/yubiotp.py
+
+const (
+	insOTPConfig = 0x01
+
+	otpCmdConfig1      = 0x01
+	otpCmdConfig2      = 0x03
+	otpCmdDeviceSerial = 0x10
+	otpCmdChalHMAC1    = 0x30
+	otpCmdChalHMAC2    = 0x38
+
+	// Sizes of the fields of the configuration structure.
+	otpFixedSize      = 16
+	otpUIDSize        = 6
+	otpKeySize        = 16
+	OTPAccessCodeSize = 6
+	otpConfigSize     = 52
+
+	// otpCRCOkResidual is the crc16 of a configuration including its (inverted) crc.
+	otpCRCOkResidual = 0xf0b8
+
+	// Ticket flags.
+	otpTktAppendCR = 0x20
+	otpTktChalResp = 0x40
+
+	// Config flags.
+	otpCfgShortTicket = 0x02
+	otpCfgChalHMAC    = 0x22
+	otpCfgHMACLT64    = 0x04
+	otpCfgChalBtnTrig = 0x08
+
+	// Extended flags.
+	otpExtSerialAPIVisible = 0x04
+	otpExtAllowUpdate      = 0x20
+
+	// Status touch level bits.
+	otpStatusConfig1Valid = 0x01
+	otpStatusConfig2Valid = 0x02
+
+	otpHMACKeySize       = 20
+	otpHMACChallengeSize = 64
+	otpHMACResponseSize  = 20
+	otpMaxStaticCodeSize = otpFixedSize + otpUIDSize + otpKeySize
+)
+
+// OTPSlot is one of the two keyboard slots, short press (1) or long press (2).
+type OTPSlot byte
+
+const (
+	OTPSlot1 OTPSlot = 1
+	OTPSlot2 OTPSlot = 2
+)
+
+func (s OTPSlot) challengeCommand() (byte, error) {
+	switch s {
+	case OTPSlot1:
+		return otpCmdChalHMAC1, nil
+	case OTPSlot2:
+		return otpCmdChalHMAC2, nil
+	default:
+		return 0, fmt.Errorf("%w: %d", ErrOTPBadSlot, byte(s))
+	}
+}
+
+func (s OTPSlot) configCommand() (byte, error) {
+	switch s {
+	case OTPSlot1:
+		return otpCmdConfig1, nil
+	case OTPSlot2:
+		return otpCmdConfig2, nil
+	default:
+		return 0, fmt.Errorf("%w: %d", ErrOTPBadSlot, byte(s))
+	}
+}
+
+var (
+	ErrOTPBadSlot         = errors.New("otp slot must be 1 or 2")
+	ErrOTPBadAccessCode   = errors.New("otp access code must be 6 bytes")
+	ErrOTPBadKey          = errors.New("otp key has the wrong length")
+	ErrOTPBadScanCodes    = errors.New("static password must be between 1 and 38 scan codes")
+	ErrOTPBadPublicID     = errors.New("yubico otp public id must be at most 16 bytes")
+	ErrOTPUnmappableChar  = errors.New("character has no us keyboard scan code")
+	ErrOTPConfigRejected  = errors.New("otp configuration was not applied, is the slot protected by an access code")
+	ErrOTPStatusMalformed = errors.New("otp status too short")
+	ErrOTPBadChallenge    = errors.New("hmac-sha1 challenge must be at most 64 bytes")
+	ErrOTPBadResponse     = errors.New("hmac-sha1 response too short")
+)
+
+// OTPStatus is the status returned by the OTP applet.
+type OTPStatus struct {
+	Version    Version
+	Sequence   byte
+	TouchLevel uint16
+}
+
+// SlotConfigured returns true if the slot holds a configuration.
+func (s OTPStatus) SlotConfigured(slot OTPSlot) bool {
+	if slot == OTPSlot1 {
+		return s.TouchLevel&otpStatusConfig1Valid != 0
+	}
+
+	return s.TouchLevel&otpStatusConfig2Valid != 0
+}
+
+// OTPSlotConfig is a configuration to be written to an OTP slot.
+// Use StaticPasswordConfig, HMACSHA1Config or YubicoOTPConfig to create one.
+type OTPSlotConfig struct {
+	fixed []byte
+	uid   [otpUIDSize]byte
+	key   [otpKeySize]byte
+	ext   byte
+	tkt   byte
+	cfg   byte
+}
+
+// StaticPasswordConfig returns a configuration typing the given keyboard scan codes.
+// USScanCodes converts a string for a US keyboard layout.
+func StaticPasswordConfig(scanCodes []byte) (*OTPSlotConfig, error) {
+	if len(scanCodes) == 0 || len(scanCodes) > otpMaxStaticCodeSize {
+		return nil, fmt.Errorf("%w: got %d", ErrOTPBadScanCodes, len(scanCodes))
+	}
+
+	codes := make([]byte, otpMaxStaticCodeSize)
+	copy(codes, scanCodes)
+
+	c := &OTPSlotConfig{
+		fixed: codes[:otpFixedSize],
+		ext:   otpExtSerialAPIVisible | otpExtAllowUpdate,
+		tkt:   otpTktAppendCR,
+		cfg:   otpCfgShortTicket,
+	}
+	copy(c.uid[:], codes[otpFixedSize:])
+	copy(c.key[:], codes[otpFixedSize+otpUIDSize:])
+
+	return c, nil
+}
+
+// HMACSHA1Config returns a challenge-response configuration using a 20 byte HMAC-SHA1 secret.
+func HMACSHA1Config(key []byte, requireTouch bool) (*OTPSlotConfig, error) {
+	if len(key) != otpHMACKeySize {
+		return nil, fmt.Errorf("%w: hmac-sha1 key must be %d bytes, got %d", ErrOTPBadKey, otpHMACKeySize, len(key))
+	}
+
+	c := &OTPSlotConfig{
+		ext: otpExtSerialAPIVisible | otpExtAllowUpdate,
+		tkt: otpTktChalResp,
+		cfg: otpCfgChalHMAC | otpCfgHMACLT64,
+	}
+
+	// The first 16 bytes go into the key field, the remaining 4 into the uid.
+	copy(c.key[:], key[:otpKeySize])
+	copy(c.uid[:], key[otpKeySize:])
+
+	if requireTouch {
+		c.cfg |= otpCfgChalBtnTrig
+	}
+
+	return c, nil
+}
+
+// YubicoOTPConfig returns a Yubico OTP configuration.
+func YubicoOTPConfig(publicID []byte, privateID [otpUIDSize]byte, key [otpKeySize]byte) (*OTPSlotConfig, error) {
+	if len(publicID) > otpFixedSize {
+		return nil, fmt.Errorf("%w: got %d", ErrOTPBadPublicID, len(publicID))
+	}
+
+	return &OTPSlotConfig{
+		fixed: append([]byte{}, publicID...),
+		uid:   privateID,
+		key:   key,
+		ext:   otpExtSerialAPIVisible | otpExtAllowUpdate,
+		tkt:   otpTktAppendCR,
+	}, nil
+}
+
+// marshal builds the 52 byte configuration structure, see ykdef.h struct config_st.
+func (c *OTPSlotConfig) marshal(accessCode []byte) []byte {
+	buf := make([]byte, otpConfigSize)
+	off := 0
+
+	copy(buf[off:], c.fixed)
+	off += otpFixedSize
+	copy(buf[off:], c.uid[:])
+	off += otpUIDSize
+	copy(buf[off:], c.key[:])
+	off += otpKeySize
+	copy(buf[off:], accessCode)
+	off += OTPAccessCodeSize
+	buf[off] = byte(len(c.fixed))
+	buf[off+1] = c.ext
+	buf[off+2] = c.tkt
+	buf[off+3] = c.cfg
+	// 2 bytes rfu, then the crc.
+	off += 6
+	binary.LittleEndian.PutUint16(buf[off:], ^otpCRC16(buf[:off]))
+
+	return buf
+}
+
+// otpCRC16 is the ISO 13239 crc used by the YubiKey configuration.
+func otpCRC16(data []byte) uint16 {
+	crc := uint16(0xffff)
+
+	for _, b := range data {
+		crc ^= uint16(b)
+
+		for i := 0; i < 8; i++ {
+			j := crc & 1
+			crc >>= 1
+
+			if j == 1 {
+				crc ^= 0x8408
+			}
+		}
+	}
+
+	return crc
+}
+
+// USScanCodes converts s into HID keyboard scan codes for a US layout.
+// nolint:cyclop
+func USScanCodes(s string) ([]byte, error) {
+	const shift = 0x80
+
+	codes := make([]byte, 0, len(s))
+
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			codes = append(codes, byte(0x04+r-'a'))
+		case r >= 'A' && r <= 'Z':
+			codes = append(codes, byte(0x04+r-'A')|shift)
+		case r >= '1' && r <= '9':
+			codes = append(codes, byte(0x1e+r-'1'))
+		case r == '0':
+			codes = append(codes, 0x27)
+		default:
+			code, ok := usScanCodeSymbols[r]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrOTPUnmappableChar, r)
+			}
+
+			codes = append(codes, code)
+		}
+	}
+
+	return codes, nil
+}
+
+// nolint:gochecknoglobals
+var usScanCodeSymbols = map[rune]byte{
+	' ': 0x2c, '-': 0x2d, '=': 0x2e, '[': 0x2f, ']': 0x30, '\\': 0x31, ';': 0x33, '\'': 0x34, '`': 0x35,
+	',': 0x36, '.': 0x37, '/': 0x38, '!': 0x9e, '@': 0x9f, '#': 0xa0, '$': 0xa1, '%': 0xa2, '^': 0xa3,
+	'&': 0xa4, '*': 0xa5, '(': 0xa6, ')': 0xa7, '_': 0xad, '+': 0xae, '{': 0xaf, '}': 0xb0, '|': 0xb1,
+	':': 0xb3, '"': 0xb4, '~': 0xb5, '<': 0xb6, '>': 0xb7, '?': 0xb8,
+}
+
+// OTP is an exclusive open connection to the OTP applet of a YubiKey.
+//
+// To release the connection, call the Close method.
+type OTP struct {
+	ctx    SCContext
+	h      SCHandle
+	tx     SCTx
+	status *OTPStatus
+}
+
+// OpenOTP connects to the OTP applet of a YubiKey.
+func OpenOTP(card string) (*OTP, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenOTP(card)
+}
+
+// OpenOTP connects to the OTP applet of a YubiKey.
+func (c *Client) OpenOTP(card string) (*OTP, error) {
+	ctx, h, tx, resp, err := c.connectApplet(card, aidYubiKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The select response carries the status.
+	status, err := parseOTPStatus(resp)
+	if err != nil {
+		tx.Close()
+		closeHandles(ctx, h)
+
+		return nil, fmt.Errorf("reading otp status: %w", err)
+	}
+
+	return &OTP{
+		ctx:    ctx,
+		h:      h,
+		tx:     tx,
+		status: status,
+	}, nil
+}
+
+// Close releases the connection to the smart card.
+func (o *OTP) Close() error {
+	return closeHandles(o.ctx, o.h)
+}
+
+// Status returns the last status reported by the OTP applet.
+func (o *OTP) Status() OTPStatus {
+	return *o.status
+}
+
+// Serial returns the YubiKey's serial number.
+func (o *OTP) Serial() (uint32, error) {
+	resp, err := o.tx.Transmit(apdu{instruction: insOTPConfig, param1: otpCmdDeviceSerial})
+	if err != nil {
+		return 0, fmt.Errorf("smart card command: %w", err)
+	}
+
+	if n := len(resp); n != 4 {
+		return 0, fmt.Errorf("expected 4 byte serial number, got %d", n)
+	}
+
+	return binary.BigEndian.Uint32(resp), nil
+}
+
+// PutConfig writes config to slot.
+// accessCode protects the slot from now on, currentAccessCode must match the code currently protecting the slot.
+// Either may be nil for no access code.
+func (o *OTP) PutConfig(slot OTPSlot, config *OTPSlotConfig, accessCode, currentAccessCode []byte) error {
+	if err := checkOTPAccessCode(accessCode); err != nil {
+		return err
+	}
+
+	return o.writeConfig(slot, config.marshal(accessCode), currentAccessCode)
+}
+
+// DeleteSlot removes the configuration of slot.
+func (o *OTP) DeleteSlot(slot OTPSlot, currentAccessCode []byte) error {
+	return o.writeConfig(slot, make([]byte, otpConfigSize), currentAccessCode)
+}
+
+func (o *OTP) writeConfig(slot OTPSlot, config, currentAccessCode []byte) error {
+	cmd, err := slot.configCommand()
+	if err != nil {
+		return err
+	}
+
+	if err := checkOTPAccessCode(currentAccessCode); err != nil {
+		return err
+	}
+
+	data := make([]byte, 0, otpConfigSize+OTPAccessCodeSize)
+	data = append(data, config...)
+	data = append(data, currentAccessCode...)
+	data = append(data, make([]byte, otpConfigSize+OTPAccessCodeSize-len(data))...)
+
+	resp, err := o.tx.Transmit(apdu{instruction: insOTPConfig, param1: cmd, data: data})
+	if err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	status, err := parseOTPStatus(resp)
+	if err != nil {
+		return err
+	}
+
+	// The program sequence is bumped on success, and reset to 0 when no slot is left configured.
+	prev := o.status.Sequence
+	o.status = status
+
+	if status.Sequence != prev+1 && !(status.Sequence == 0 && status.TouchLevel&(otpStatusConfig1Valid|otpStatusConfig2Valid) == 0) {
+		return ErrOTPConfigRejected
+	}
+
+	return nil
+}
+
+// CalculateHMACSHA1 sends challenge to slot, which must hold a HMACSHA1Config, and returns the 20 byte response.
+// The challenge is padded to 64 bytes like ykman does, with a byte different from its last byte,
+// the slot configuration ignores the padding. The YubiKey waits for a touch if the slot requires it.
+func (o *OTP) CalculateHMACSHA1(slot OTPSlot, challenge []byte) ([]byte, error) {
+	cmd, err := slot.challengeCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(challenge) > otpHMACChallengeSize {
+		return nil, fmt.Errorf("%w: got %d", ErrOTPBadChallenge, len(challenge))
+	}
+
+	var pad byte
+	if len(challenge) > 0 && challenge[len(challenge)-1] == 0 {
+		pad = 1
+	}
+
+	data := make([]byte, otpHMACChallengeSize)
+	copy(data, challenge)
+
+	for i := len(challenge); i < len(data); i++ {
+		data[i] = pad
+	}
+
+	resp, err := o.tx.Transmit(apdu{instruction: insOTPConfig, param1: cmd, data: data})
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+
+	if len(resp) < otpHMACResponseSize {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrOTPBadResponse, len(resp))
+	}
+
+	return resp[:otpHMACResponseSize], nil
+}
+
+func checkOTPAccessCode(code []byte) error {
+	if code != nil && len(code) != OTPAccessCodeSize {
+		return fmt.Errorf("%w: got %d", ErrOTPBadAccessCode, len(code))
+	}
+
+	return nil
+}
+
+// parseOTPStatus parses version (3 bytes), program sequence and touch level (little endian).
+func parseOTPStatus(b []byte) (*OTPStatus, error) {
+	if len(b) < 6 {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrOTPStatusMalformed, len(b))
+	}
+
+	return &OTPStatus{
+		Version:    Version{Major: int(b[0]), Minor: int(b[1]), Patch: int(b[2])},
+		Sequence:   b[3],
+		TouchLevel: binary.LittleEndian.Uint16(b[4:6]),
+	}, nil
+}
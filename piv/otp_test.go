@@ -0,0 +1,219 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+func otpTestClient(tb testing.TB, apdus []apdu, responses [][]byte) *Client {
+	tb.Helper()
+
+	selectAPDU := apdu{instruction: insSelectApplication, param1: 0x04, data: aidYubiKey[:]}
+	status := []byte{0x05, 0x04, 0x03, 0x07, 0x01, 0x00}
+
+	apdus = append([]apdu{selectAPDU}, apdus...)
+	responses = append([][]byte{status}, responses...)
+
+	return CreateTestClient(tb, nil, nil, &TestSCHandle{
+		Ctx: &TestSCTx{
+			APDUList:     apdus,
+			ResponseList: responses,
+		},
+	})
+}
+
+func TestOTP_ConfigCRC(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{0x42}, otpHMACKeySize)
+
+	c, err := HMACSHA1Config(key, true)
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	buf := c.marshal(nil)
+	if len(buf) != otpConfigSize {
+		t.Fatalf("config is %d bytes", len(buf))
+	}
+
+	if crc := otpCRC16(buf); crc != otpCRCOkResidual {
+		t.Errorf("crc residual 0x%04x", crc)
+	}
+
+	if !bytes.Equal(buf[otpFixedSize+otpUIDSize:otpFixedSize+otpUIDSize+otpKeySize], key[:otpKeySize]) {
+		t.Errorf("key not in key field")
+	}
+
+	if buf[47] != otpCfgChalHMAC|otpCfgHMACLT64|otpCfgChalBtnTrig {
+		t.Errorf("unexpected cfg flags 0x%02x", buf[47])
+	}
+}
+
+func TestOTP_StaticPasswordConfig(t *testing.T) {
+	t.Parallel()
+
+	codes, err := USScanCodes("password")
+	expectedError(t, err, nil)
+
+	c, err := StaticPasswordConfig(codes)
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	// ykman StaticPasswordSlotConfiguration: the scan codes padded to 38 bytes across fixed, uid and key,
+	// fixed size 16, EXTFLAG SERIAL_API_VISIBLE|ALLOW_UPDATE, TKTFLAG APPEND_CR and CFGFLAG SHORT_TICKET.
+	buf := c.marshal(nil)
+	want := make([]byte, otpMaxStaticCodeSize)
+	copy(want, codes)
+
+	if !bytes.Equal(buf[:otpMaxStaticCodeSize], want) {
+		t.Errorf("scan codes %x", buf[:otpMaxStaticCodeSize])
+	}
+
+	if got := buf[44:48]; !bytes.Equal(got, []byte{otpFixedSize, 0x24, 0x20, 0x02}) {
+		t.Errorf("fixed size and flags %x, expected 10242002", got)
+	}
+}
+
+func TestOTP_ConfigFail(t *testing.T) {
+	t.Parallel()
+
+	_, err := HMACSHA1Config([]byte{0x01}, false)
+	expectedError(t, err, ErrOTPBadKey)
+
+	_, err = StaticPasswordConfig(nil)
+	expectedError(t, err, ErrOTPBadScanCodes)
+
+	_, err = YubicoOTPConfig(make([]byte, otpFixedSize+1), [otpUIDSize]byte{}, [otpKeySize]byte{})
+	expectedError(t, err, ErrOTPBadPublicID)
+
+	_, err = USScanCodes("€")
+	expectedError(t, err, ErrOTPUnmappableChar)
+}
+
+func TestOTP_USScanCodes(t *testing.T) {
+	t.Parallel()
+
+	codes, err := USScanCodes("aZ0!")
+	expectedError(t, err, nil)
+
+	if !bytes.Equal(codes, []byte{0x04, 0x9d, 0x27, 0x9e}) {
+		t.Errorf("unexpected scan codes %x", codes)
+	}
+}
+
+func TestOTP_PutConfig(t *testing.T) {
+	t.Parallel()
+
+	codes, err := USScanCodes("password")
+	expectedError(t, err, nil)
+
+	config, err := StaticPasswordConfig(codes)
+	expectedError(t, err, nil)
+
+	accessCode := []byte{1, 2, 3, 4, 5, 6}
+	data := append(config.marshal(accessCode), make([]byte, OTPAccessCodeSize)...)
+
+	cases := []struct {
+		name     string
+		response []byte
+		err      error
+	}{
+		{
+			name:     "applied",
+			response: []byte{0x05, 0x04, 0x03, 0x08, 0x03, 0x00},
+		},
+		{
+			name:     "rejected",
+			response: []byte{0x05, 0x04, 0x03, 0x07, 0x01, 0x00},
+			err:      ErrOTPConfigRejected,
+		},
+	}
+
+	for _, tc := range cases {
+		// avoid aliasing due to test.parallel.
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			o, err := otpTestClient(t,
+				[]apdu{{instruction: insOTPConfig, param1: otpCmdConfig2, data: data}},
+				[][]byte{tc.response},
+			).OpenOTP("")
+			if !expectedError(t, err, nil) {
+				t.FailNow()
+			}
+
+			if !o.Status().SlotConfigured(OTPSlot1) || o.Status().SlotConfigured(OTPSlot2) {
+				t.Errorf("unexpected status %+v", o.Status())
+			}
+
+			err = o.PutConfig(OTPSlot2, config, accessCode, nil)
+			expectedError(t, err, tc.err)
+		})
+	}
+}
+
+func TestOTP_CalculateHMACSHA1(t *testing.T) {
+	t.Parallel()
+
+	// RFC 2202 test case 1, key 0x0b * 20.
+	challenge := []byte("Hi There")
+	response, _ := hex.DecodeString("b617318655057264e28bc0b6fb378c8ef146be00")
+
+	padded := append(append([]byte{}, challenge...), make([]byte, otpHMACChallengeSize-len(challenge))...)
+	// a challenge ending with 0 is padded with 1 so the slot can find its end.
+	zeroEnded := bytes.Repeat([]byte{0x01}, otpHMACChallengeSize)
+	zeroEnded[0] = 0x00
+
+	o, err := otpTestClient(t,
+		[]apdu{
+			{instruction: insOTPConfig, param1: otpCmdChalHMAC1, data: padded},
+			{instruction: insOTPConfig, param1: otpCmdChalHMAC2, data: zeroEnded},
+		},
+		[][]byte{response, response},
+	).OpenOTP("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	got, err := o.CalculateHMACSHA1(OTPSlot1, challenge)
+	expectedError(t, err, nil)
+
+	key := bytes.Repeat([]byte{0x0b}, otpHMACKeySize)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(challenge)
+
+	if !bytes.Equal(got, response) || !hmac.Equal(got, mac.Sum(nil)) {
+		t.Errorf("got %x expected %x", got, response)
+	}
+
+	if _, err := o.CalculateHMACSHA1(OTPSlot2, []byte{0x00}); !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	_, err = o.CalculateHMACSHA1(OTPSlot(3), challenge)
+	expectedError(t, err, ErrOTPBadSlot)
+
+	_, err = o.CalculateHMACSHA1(OTPSlot1, make([]byte, otpHMACChallengeSize+1))
+	expectedError(t, err, ErrOTPBadChallenge)
+}
@@ -0,0 +1,237 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package p11 exposes the PIV applet with the session, object and mechanism model of PKCS#11,
+// so code written against a PKCS#11 module such as YKCS11 can use piv-go with few changes.
+//
+// Objects have the CKA_ID and CKA_LABEL of YKCS11: the IDs 1 to 4 are the slots 9a, 9c, 9d and 9e,
+// 5 to 24 the retired slots 82 to 95, and the labels are like "Private key for PIV Authentication".
+// Mechanism values are the CKM_ constants, signatures and decryptions take and return the same
+// bytes as C_Sign and C_Decrypt, e.g. CKM_ECDSA signatures are r || s rather than DER.
+package p11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/areese/piv-go/piv"
+)
+
+var (
+	// ErrMechanismInvalid is returned for mechanisms that aren't supported, CKR_MECHANISM_INVALID.
+	ErrMechanismInvalid = errors.New("mechanism invalid")
+	// ErrKeyTypeInconsistent is returned when the mechanism doesn't fit the key, CKR_KEY_TYPE_INCONSISTENT.
+	ErrKeyTypeInconsistent = errors.New("key type inconsistent")
+	// ErrKeyHandleInvalid is returned for objects that aren't private keys, CKR_KEY_HANDLE_INVALID.
+	ErrKeyHandleInvalid = errors.New("key handle invalid")
+	// ErrDataInvalid is returned for input the mechanism can't use, CKR_DATA_INVALID.
+	ErrDataInvalid = errors.New("data invalid")
+)
+
+// ObjectClass is the CKA_CLASS of an object.
+type ObjectClass uint
+
+// Object classes with their CKO_ values, ClassAny matches every class in a Template.
+const (
+	ClassAny         ObjectClass = 0xffffffff
+	ClassCertificate ObjectClass = 0x1
+	ClassPublicKey   ObjectClass = 0x2
+	ClassPrivateKey  ObjectClass = 0x3
+)
+
+func (c ObjectClass) String() string {
+	switch c {
+	case ClassCertificate:
+		return "certificate"
+	case ClassPublicKey:
+		return "public key"
+	case ClassPrivateKey:
+		return "private key"
+	case ClassAny:
+		return "any"
+	}
+
+	return fmt.Sprintf("unknown: %#x", uint(c))
+}
+
+// KeyType is the CKA_KEY_TYPE of a key, certificates have the type of their key.
+type KeyType uint
+
+// Key types with their CKK_ values.
+const (
+	KeyRSA     KeyType = 0x0
+	KeyEC      KeyType = 0x3
+	KeyEdwards KeyType = 0x40
+)
+
+func (k KeyType) String() string {
+	switch k {
+	case KeyRSA:
+		return "RSA"
+	case KeyEC:
+		return "EC"
+	case KeyEdwards:
+		return "EC_EDWARDS"
+	}
+
+	return fmt.Sprintf("unknown: %#x", uint(k))
+}
+
+// MechanismType is a CKM_ value.
+type MechanismType uint
+
+// Mechanisms the card supports, with their CKM_ values.
+const (
+	MechanismRSAPKCS          MechanismType = 0x1
+	MechanismRSAPKCSOAEP      MechanismType = 0x9
+	MechanismRSAPKCSPSS       MechanismType = 0xd
+	MechanismSHA256RSAPKCS    MechanismType = 0x40
+	MechanismSHA384RSAPKCS    MechanismType = 0x41
+	MechanismSHA512RSAPKCS    MechanismType = 0x42
+	MechanismSHA256RSAPKCSPSS MechanismType = 0x43
+	MechanismSHA384RSAPKCSPSS MechanismType = 0x44
+	MechanismSHA512RSAPKCSPSS MechanismType = 0x45
+	MechanismECDSA            MechanismType = 0x1041
+	MechanismECDSASHA256      MechanismType = 0x1044
+	MechanismECDSASHA384      MechanismType = 0x1045
+	MechanismECDSASHA512      MechanismType = 0x1046
+	MechanismECDH1Derive      MechanismType = 0x1050
+	MechanismEDDSA            MechanismType = 0x1057
+)
+
+// nolint:gochecknoglobals
+var mechanismNames = map[MechanismType]string{
+	MechanismRSAPKCS:          "CKM_RSA_PKCS",
+	MechanismRSAPKCSOAEP:      "CKM_RSA_PKCS_OAEP",
+	MechanismRSAPKCSPSS:       "CKM_RSA_PKCS_PSS",
+	MechanismSHA256RSAPKCS:    "CKM_SHA256_RSA_PKCS",
+	MechanismSHA384RSAPKCS:    "CKM_SHA384_RSA_PKCS",
+	MechanismSHA512RSAPKCS:    "CKM_SHA512_RSA_PKCS",
+	MechanismSHA256RSAPKCSPSS: "CKM_SHA256_RSA_PKCS_PSS",
+	MechanismSHA384RSAPKCSPSS: "CKM_SHA384_RSA_PKCS_PSS",
+	MechanismSHA512RSAPKCSPSS: "CKM_SHA512_RSA_PKCS_PSS",
+	MechanismECDSA:            "CKM_ECDSA",
+	MechanismECDSASHA256:      "CKM_ECDSA_SHA256",
+	MechanismECDSASHA384:      "CKM_ECDSA_SHA384",
+	MechanismECDSASHA512:      "CKM_ECDSA_SHA512",
+	MechanismECDH1Derive:      "CKM_ECDH1_DERIVE",
+	MechanismEDDSA:            "CKM_EDDSA",
+}
+
+func (m MechanismType) String() string {
+	if name, ok := mechanismNames[m]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown: %#x", uint(m))
+}
+
+// nolint:gochecknoglobals
+var keyMechanisms = map[KeyType][]MechanismType{
+	KeyRSA: {
+		MechanismRSAPKCS, MechanismRSAPKCSOAEP, MechanismRSAPKCSPSS,
+		MechanismSHA256RSAPKCS, MechanismSHA384RSAPKCS, MechanismSHA512RSAPKCS,
+		MechanismSHA256RSAPKCSPSS, MechanismSHA384RSAPKCSPSS, MechanismSHA512RSAPKCSPSS,
+	},
+	KeyEC:      {MechanismECDSA, MechanismECDSASHA256, MechanismECDSASHA384, MechanismECDSASHA512, MechanismECDH1Derive},
+	KeyEdwards: {MechanismEDDSA},
+}
+
+// Mechanisms returns the mechanisms for a key type, like C_GetMechanismList for the key.
+func Mechanisms(keyType KeyType) []MechanismType {
+	return append([]MechanismType{}, keyMechanisms[keyType]...)
+}
+
+// Mechanism is a mechanism with its parameters, like CK_MECHANISM.
+type Mechanism struct {
+	Type MechanismType
+	// Hash is the hashAlg of CK_RSA_PKCS_PSS_PARAMS and CK_RSA_PKCS_OAEP_PARAMS, the MGF uses the same hash.
+	// For CKM_RSA_PKCS_PSS the digest size selects it when zero, CKM_RSA_PKCS_OAEP defaults to SHA-1.
+	Hash crypto.Hash
+}
+
+// Object is a certificate, public key or private key on the card.
+type Object struct {
+	Class   ObjectClass
+	KeyType KeyType
+	// ID is CKA_ID, it's the same for the certificate and keys of a slot.
+	ID    []byte
+	Label string
+	Slot  piv.Slot
+	// Certificate is set for certificate objects.
+	Certificate *x509.Certificate
+	PublicKey   crypto.PublicKey
+}
+
+// Template selects objects like the template of C_FindObjectsInit, zero fields match every object.
+type Template struct {
+	// Class is ClassAny, or zero, for every class.
+	Class ObjectClass
+	ID    []byte
+	Label string
+}
+
+func (t Template) matches(o Object) bool {
+	if t.Class != 0 && t.Class != ClassAny && t.Class != o.Class {
+		return false
+	}
+
+	if t.ID != nil && string(t.ID) != string(o.ID) {
+		return false
+	}
+
+	return t.Label == "" || t.Label == o.Label
+}
+
+// slotObject is the ID and label suffix of a slot.
+type slotObject struct {
+	slot piv.Slot
+	id   byte
+	name string
+}
+
+// slotObjects are the slots in the order of their IDs.
+func slotObjects() []slotObject {
+	objects := []slotObject{
+		{piv.SlotAuthentication, 1, "PIV Authentication"},
+		{piv.SlotSignature, 2, "Digital Signature"},
+		{piv.SlotKeyManagement, 3, "Key Management"},
+		{piv.SlotCardAuthentication, 4, "Card Authentication"},
+	}
+
+	for i := uint32(0); i < 20; i++ {
+		slot, _ := piv.RetiredKeyManagementSlot(0x82 + i)
+		objects = append(objects, slotObject{slot, byte(5 + i), fmt.Sprintf("Retired Key %d", i+1)})
+	}
+
+	return objects
+}
+
+func keyTypeOf(pub crypto.PublicKey) (KeyType, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return KeyRSA, nil
+	case *ecdsa.PublicKey:
+		return KeyEC, nil
+	case ed25519.PublicKey:
+		return KeyEdwards, nil
+	}
+
+	return 0, fmt.Errorf("%w: %T", ErrKeyTypeInconsistent, pub)
+}
@@ -0,0 +1,368 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p11
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// newTestSession returns a session for a card with an EC key and certificate in 9a, an RSA key and
+// certificate in 9d and an Ed25519 key without a certificate in the first retired slot.
+func newTestSession(t *testing.T) *Session {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	retired, _ := piv.RetiredKeyManagementSlot(0x82)
+
+	for _, k := range []struct {
+		slot piv.Slot
+		alg  piv.Algorithm
+		cert bool
+	}{
+		{piv.SlotAuthentication, piv.AlgorithmEC256, true},
+		{piv.SlotKeyManagement, piv.AlgorithmRSA2048, true},
+		{retired, piv.AlgorithmEd25519, false},
+	} {
+		pub, err := yk.GenerateKey(piv.DefaultManagementKey, k.slot, piv.Key{
+			Algorithm:   k.alg,
+			PINPolicy:   piv.PINPolicyOnce,
+			TouchPolicy: piv.TouchPolicyNever,
+		})
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+
+		if !k.cert {
+			continue
+		}
+
+		_, caKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: k.alg.String()},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := yk.SetCertificate(piv.DefaultManagementKey, k.slot, cert); err != nil {
+			t.Fatalf("set certificate: %v", err)
+		}
+	}
+
+	s := OpenSession(yk)
+	if err := s.Login(pivtest.DefaultPIN); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	return s
+}
+
+// findKey returns the only private key with id.
+func findKey(t *testing.T, s *Session, id byte) Object {
+	t.Helper()
+
+	objects, err := s.FindObjects(Template{Class: ClassPrivateKey, ID: []byte{id}})
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("find private key %d: %d objects, %v", id, len(objects), err)
+	}
+
+	return objects[0]
+}
+
+func TestSession_FindObjects(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSession(t)
+
+	tests := []struct {
+		name     string
+		template Template
+		expected []string
+	}{
+		{
+			name:     "by label",
+			template: Template{Label: "Private key for PIV Authentication"},
+			expected: []string{"Private key for PIV Authentication"},
+		},
+		{
+			name:     "by id",
+			template: Template{ID: []byte{3}},
+			expected: []string{"X.509 Certificate for Key Management", "Public key for Key Management", "Private key for Key Management"},
+		},
+		{
+			name:     "certificates",
+			template: Template{Class: ClassCertificate},
+			expected: []string{"X.509 Certificate for PIV Authentication", "X.509 Certificate for Key Management"},
+		},
+		{
+			name:     "key without certificate",
+			template: Template{ID: []byte{5}, Class: ClassAny},
+			expected: []string{"Public key for Retired Key 1", "Private key for Retired Key 1"},
+		},
+		{
+			name:     "empty slot",
+			template: Template{ID: []byte{2}},
+		},
+	}
+
+	for _, tc := range tests {
+		objects, err := s.FindObjects(tc.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		labels := make([]string, 0, len(objects))
+		for _, o := range objects {
+			labels = append(labels, o.Label)
+		}
+
+		if len(labels) != len(tc.expected) {
+			t.Errorf("%s: found %q, expected %q", tc.name, labels, tc.expected)
+
+			continue
+		}
+
+		for i := range labels {
+			if labels[i] != tc.expected[i] {
+				t.Errorf("%s: found %q, expected %q", tc.name, labels, tc.expected)
+			}
+		}
+	}
+
+	all, err := s.FindObjects(Template{})
+	if err != nil || len(all) != 8 {
+		t.Errorf("found %d objects, expected 8: %v", len(all), err)
+	}
+}
+
+func TestSession_Sign(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSession(t)
+	ecKey, rsaKey, edKey := findKey(t, s, 1), findKey(t, s, 3), findKey(t, s, 5)
+
+	message := []byte("message")
+	digest := sha256.Sum256(message)
+	digestInfo := append(append([]byte{}, digestInfoPrefixes[crypto.SHA256]...), digest[:]...)
+
+	tests := []struct {
+		name   string
+		key    Object
+		m      Mechanism
+		data   []byte
+		verify func(sig []byte) error
+	}{
+		{
+			name: "rsa pkcs", key: rsaKey, m: Mechanism{Type: MechanismRSAPKCS}, data: digestInfo,
+			verify: func(sig []byte) error {
+				return rsa.VerifyPKCS1v15(rsaKey.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+			},
+		},
+		{
+			name: "sha256 rsa pkcs", key: rsaKey, m: Mechanism{Type: MechanismSHA256RSAPKCS}, data: message,
+			verify: func(sig []byte) error {
+				return rsa.VerifyPKCS1v15(rsaKey.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+			},
+		},
+		{
+			name: "rsa pss", key: rsaKey, m: Mechanism{Type: MechanismRSAPKCSPSS}, data: digest[:],
+			verify: func(sig []byte) error {
+				return rsa.VerifyPSS(rsaKey.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig, nil)
+			},
+		},
+		{
+			name: "sha512 rsa pss", key: rsaKey, m: Mechanism{Type: MechanismSHA512RSAPKCSPSS}, data: message,
+			verify: func(sig []byte) error {
+				d := sha512.Sum512(message)
+
+				return rsa.VerifyPSS(rsaKey.PublicKey.(*rsa.PublicKey), crypto.SHA512, d[:], sig, nil)
+			},
+		},
+		{
+			name: "ecdsa", key: ecKey, m: Mechanism{Type: MechanismECDSA}, data: digest[:],
+			verify: func(sig []byte) error {
+				return verifyRaw(ecKey.PublicKey.(*ecdsa.PublicKey), digest[:], sig)
+			},
+		},
+		{
+			name: "ecdsa sha256", key: ecKey, m: Mechanism{Type: MechanismECDSASHA256}, data: message,
+			verify: func(sig []byte) error {
+				return verifyRaw(ecKey.PublicKey.(*ecdsa.PublicKey), digest[:], sig)
+			},
+		},
+		{
+			name: "eddsa", key: edKey, m: Mechanism{Type: MechanismEDDSA}, data: message,
+			verify: func(sig []byte) error {
+				if !ed25519.Verify(edKey.PublicKey.(ed25519.PublicKey), message, sig) {
+					return errors.New("invalid signature")
+				}
+
+				return nil
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		sig, err := s.Sign(tc.key, tc.m, tc.data)
+		if err != nil {
+			t.Fatalf("%s: sign: %v", tc.name, err)
+		}
+
+		if err := tc.verify(sig); err != nil {
+			t.Errorf("%s: verify: %v", tc.name, err)
+		}
+	}
+}
+
+func verifyRaw(pub *ecdsa.PublicKey, digest, sig []byte) error {
+	if len(sig) != 64 {
+		return errors.New("signature isn't r || s")
+	}
+
+	if !ecdsa.Verify(pub, digest, new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+func TestSession_DecryptDerive(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSession(t)
+	ecKey, rsaKey := findKey(t, s, 1), findKey(t, s, 3)
+	rsaPub := rsaKey.PublicKey.(*rsa.PublicKey)
+	secret := []byte("secret")
+
+	pkcs1, err := rsa.EncryptPKCS1v15(rand.Reader, rsaPub, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oaep, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		m          Mechanism
+		ciphertext []byte
+	}{
+		{Mechanism{Type: MechanismRSAPKCS}, pkcs1},
+		{Mechanism{Type: MechanismRSAPKCSOAEP, Hash: crypto.SHA256}, oaep},
+	} {
+		got, err := s.Decrypt(rsaKey, tc.m, tc.ciphertext)
+		if err != nil || string(got) != string(secret) {
+			t.Errorf("decrypt %s: %q, %v", tc.m.Type, got, err)
+		}
+	}
+
+	peer, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared, err := s.Derive(ecKey, Mechanism{Type: MechanismECDH1Derive}, peer.PublicKey().Bytes())
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+
+	cardPub, err := ecKey.PublicKey.(*ecdsa.PublicKey).ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := peer.ECDH(cardPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(shared) != string(expected) {
+		t.Errorf("shared secret %x, expected %x", shared, expected)
+	}
+}
+
+func TestSession_Errors(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSession(t)
+	ecKey, rsaKey := findKey(t, s, 1), findKey(t, s, 3)
+
+	certs, err := s.FindObjects(Template{Class: ClassCertificate, ID: []byte{1}})
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("find certificate: %d, %v", len(certs), err)
+	}
+
+	tests := []struct {
+		name      string
+		key       Object
+		m         MechanismType
+		data      []byte
+		expectErr error
+	}{
+		{name: "ecdsa with rsa key", key: rsaKey, m: MechanismECDSA, data: make([]byte, 32), expectErr: ErrKeyTypeInconsistent},
+		{name: "rsa with ec key", key: ecKey, m: MechanismSHA256RSAPKCS, data: []byte("m"), expectErr: ErrKeyTypeInconsistent},
+		{name: "certificate", key: certs[0], m: MechanismECDSA, data: make([]byte, 32), expectErr: ErrKeyHandleInvalid},
+		{name: "not a digest info", key: rsaKey, m: MechanismRSAPKCS, data: make([]byte, 32), expectErr: ErrDataInvalid},
+		{name: "unknown mechanism", key: rsaKey, m: 0x9999, data: []byte("m"), expectErr: ErrMechanismInvalid},
+		{name: "decryption mechanism", key: rsaKey, m: MechanismRSAPKCSOAEP, data: []byte("m"), expectErr: ErrMechanismInvalid},
+	}
+
+	for _, tc := range tests {
+		if _, err := s.Sign(tc.key, Mechanism{Type: tc.m}, tc.data); !errors.Is(err, tc.expectErr) {
+			t.Errorf("%s: %v, expected %v", tc.name, err, tc.expectErr)
+		}
+	}
+
+	if err := s.Login("000000"); err == nil {
+		t.Error("logged in with the wrong PIN")
+	}
+}
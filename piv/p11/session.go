@@ -0,0 +1,306 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// nolint:gochecknoglobals
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// nolint:gochecknoglobals
+var mechanismHashes = map[MechanismType]crypto.Hash{
+	MechanismSHA256RSAPKCS:    crypto.SHA256,
+	MechanismSHA384RSAPKCS:    crypto.SHA384,
+	MechanismSHA512RSAPKCS:    crypto.SHA512,
+	MechanismSHA256RSAPKCSPSS: crypto.SHA256,
+	MechanismSHA384RSAPKCSPSS: crypto.SHA384,
+	MechanismSHA512RSAPKCSPSS: crypto.SHA512,
+	MechanismECDSASHA256:      crypto.SHA256,
+	MechanismECDSASHA384:      crypto.SHA384,
+	MechanismECDSASHA512:      crypto.SHA512,
+}
+
+// Session is a session with the PIV applet of a card, like C_OpenSession. It doesn't own the YubiKey,
+// which has to stay open while the session is used.
+type Session struct {
+	yk *piv.YubiKey
+
+	mu   sync.Mutex
+	auth piv.KeyAuth
+}
+
+// OpenSession returns a session for yk, keys use the PIN prompt of yk until Login.
+func OpenSession(yk *piv.YubiKey) *Session {
+	return &Session{yk: yk}
+}
+
+// Login verifies the user PIN, like C_Login with CKU_USER, and presents it for keys that need it.
+func (s *Session) Login(pin string) error {
+	if err := s.yk.VerifyPIN(pin); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auth = piv.KeyAuth{PIN: pin}
+
+	return nil
+}
+
+// Logout forgets the PIN of Login, the card stays verified until it's reset or the PIN is needed again.
+func (s *Session) Logout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auth = piv.KeyAuth{}
+}
+
+// FindObjects returns the objects matching t, like C_FindObjects. A slot with a certificate has a
+// certificate, public key and private key object, a slot with only a key has key objects when the
+// card can read its public key, which needs firmware 5.3 or later.
+func (s *Session) FindObjects(t Template) ([]Object, error) {
+	var objects []Object
+
+	for _, so := range slotObjects() {
+		found, err := s.slotObjects(so)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range found {
+			if t.matches(o) {
+				objects = append(objects, o)
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+func (s *Session) slotObjects(so slotObject) ([]Object, error) {
+	cert, err := s.yk.Certificate(so.slot)
+	if err != nil && !errors.Is(err, piv.ErrNotFound) {
+		return nil, fmt.Errorf("certificate of slot %s: %w", so.slot, err)
+	}
+
+	var pub crypto.PublicKey
+
+	if cert != nil {
+		pub = cert.PublicKey
+	} else if ki, err := s.yk.KeyInfo(so.slot); err == nil {
+		pub = ki.PublicKey
+	} else {
+		// an empty slot, or a card without metadata.
+		return nil, nil
+	}
+
+	keyType, err := keyTypeOf(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	id := []byte{so.id}
+	objects := []Object{
+		{Class: ClassPublicKey, KeyType: keyType, ID: id, Label: "Public key for " + so.name, Slot: so.slot, PublicKey: pub},
+		{Class: ClassPrivateKey, KeyType: keyType, ID: id, Label: "Private key for " + so.name, Slot: so.slot, PublicKey: pub},
+	}
+
+	if cert != nil {
+		objects = append([]Object{{
+			Class: ClassCertificate, KeyType: keyType, ID: id, Label: "X.509 Certificate for " + so.name, Slot: so.slot,
+			Certificate: cert, PublicKey: pub,
+		}}, objects...)
+	}
+
+	return objects, nil
+}
+
+// privateKey checks that m can be used with key and returns the key of the card.
+func (s *Session) privateKey(key Object, m MechanismType) (crypto.PrivateKey, error) {
+	if key.Class != ClassPrivateKey {
+		return nil, fmt.Errorf("%w: %s", ErrKeyHandleInvalid, key.Label)
+	}
+
+	if _, ok := mechanismNames[m]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMechanismInvalid, m)
+	}
+
+	supported := false
+
+	for _, km := range keyMechanisms[key.KeyType] {
+		supported = supported || km == m
+	}
+
+	if !supported {
+		return nil, fmt.Errorf("%w: %s with %s key", ErrKeyTypeInconsistent, m, key.KeyType)
+	}
+
+	s.mu.Lock()
+	auth := s.auth
+	s.mu.Unlock()
+
+	return s.yk.PrivateKey(key.Slot, key.PublicKey, auth)
+}
+
+// Sign signs data like C_Sign. CKM_RSA_PKCS and CKM_ECDSA take a DigestInfo and a digest,
+// the hashing mechanisms take the message, CKM_ECDSA signatures are r || s.
+func (s *Session) Sign(key Object, m Mechanism, data []byte) ([]byte, error) {
+	priv, err := s.privateKey(key, m.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can't sign", ErrKeyHandleInvalid, key.Label)
+	}
+
+	if hash, ok := mechanismHashes[m.Type]; ok {
+		h := hash.New()
+		h.Write(data)
+		data = h.Sum(nil)
+		m.Hash = hash
+	}
+
+	switch m.Type {
+	case MechanismRSAPKCS:
+		for hash, prefix := range digestInfoPrefixes {
+			if len(data) == len(prefix)+hash.Size() && bytes.HasPrefix(data, prefix) {
+				return signer.Sign(rand.Reader, data[len(prefix):], hash)
+			}
+		}
+
+		return nil, fmt.Errorf("%w: not a DigestInfo", ErrDataInvalid)
+	case MechanismSHA256RSAPKCS, MechanismSHA384RSAPKCS, MechanismSHA512RSAPKCS:
+		return signer.Sign(rand.Reader, data, m.Hash)
+	case MechanismRSAPKCSPSS, MechanismSHA256RSAPKCSPSS, MechanismSHA384RSAPKCSPSS, MechanismSHA512RSAPKCSPSS:
+		hash := m.Hash
+		if hash == 0 {
+			hash = hashForSize(len(data))
+		}
+
+		if hash == 0 || hash.Size() != len(data) {
+			return nil, fmt.Errorf("%w: digest size %d", ErrDataInvalid, len(data))
+		}
+
+		return signer.Sign(rand.Reader, data, &rsa.PSSOptions{Hash: hash, SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case MechanismECDSA, MechanismECDSASHA256, MechanismECDSASHA384, MechanismECDSASHA512:
+		der, err := signer.Sign(rand.Reader, data, m.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		return ecdsaRawSignature(key.PublicKey.(*ecdsa.PublicKey), der)
+	case MechanismEDDSA:
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	return nil, fmt.Errorf("%w: %s can't sign", ErrMechanismInvalid, m.Type)
+}
+
+func hashForSize(size int) crypto.Hash {
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if hash.Size() == size {
+			return hash
+		}
+	}
+
+	return 0
+}
+
+// ecdsaRawSignature converts a DER signature to r || s, each the size of the curve.
+func ecdsaRawSignature(pub *ecdsa.PublicKey, der []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("parse signature: %w", err)
+	}
+
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+
+	return raw, nil
+}
+
+// Decrypt decrypts data like C_Decrypt with CKM_RSA_PKCS or CKM_RSA_PKCS_OAEP.
+func (s *Session) Decrypt(key Object, m Mechanism, data []byte) ([]byte, error) {
+	priv, err := s.privateKey(key, m.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter, ok := priv.(crypto.Decrypter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can't decrypt", ErrKeyHandleInvalid, key.Label)
+	}
+
+	switch m.Type {
+	case MechanismRSAPKCS:
+		return decrypter.Decrypt(rand.Reader, data, nil)
+	case MechanismRSAPKCSOAEP:
+		hash := m.Hash
+		if hash == 0 {
+			hash = crypto.SHA1
+		}
+
+		return decrypter.Decrypt(rand.Reader, data, &rsa.OAEPOptions{Hash: hash})
+	}
+
+	return nil, fmt.Errorf("%w: %s can't decrypt", ErrMechanismInvalid, m.Type)
+}
+
+// Derive returns the shared secret of CKM_ECDH1_DERIVE with the null KDF, peer is the
+// uncompressed point of the other public key, the pPublicData of CK_ECDH1_DERIVE_PARAMS.
+func (s *Session) Derive(key Object, m Mechanism, peer []byte) ([]byte, error) {
+	priv, err := s.privateKey(key, m.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := priv.(*piv.ECDSAPrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can't derive", ErrKeyHandleInvalid, key.Label)
+	}
+
+	curve := key.PublicKey.(*ecdsa.PublicKey).Curve
+
+	x, y := elliptic.Unmarshal(curve, peer) // nolint:staticcheck // SharedKey takes an *ecdsa.PublicKey.
+	if x == nil {
+		return nil, fmt.Errorf("%w: peer point", ErrDataInvalid)
+	}
+
+	return k.SharedKey(&ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	"github.com/areese/piv-go/bertlv"
+	apduerr "github.com/areese/piv-go/piv/apdu"
 )
 
 type scErr struct {
@@ -55,12 +56,14 @@ func (v AuthErr) Error() string {
 }
 
 // ErrNotFound is returned when the requested object on the smart card is not found.
-var ErrNotFound = errors.New("data object or application not found")
+// It's the same error as apdu.ErrDataNotFound, status 6A82. Status 6A88, a wrong key or
+// PIN reference, wraps apdu.ErrReferenceNotFound instead.
+var ErrNotFound = apduerr.ErrDataNotFound
 
 // apduErr is an error interacting with the PIV application on the smart card.
-// This error may wrap more accessible errors, like ErrNotFound or an instance
-// of AuthErr, so callers are encouraged to use errors.Is and errors.As for
-// these common cases.
+// This error wraps an *apdu.Error with the status words and may wrap more
+// accessible errors, like ErrNotFound or an instance of AuthErr, so callers
+// are encouraged to use errors.Is and errors.As for these common cases.
 type apduErr struct {
 	sw1 byte
 	sw2 byte
@@ -72,117 +75,52 @@ func (a *apduErr) Status() uint16 {
 }
 
 func (a *apduErr) Error() string {
-	var msg string
-	if u := a.Unwrap(); u != nil {
-		msg = u.Error()
-	}
-
-	if a.sw1 == 0x61 {
-		msg = fmt.Sprintf("0x%02x bytes available", a.sw2)
-	}
-
-	if a.sw1 == 0x63 && a.sw2&0xf0 == 0xc0 {
-		msg = retries(int(a.Status() & 0x0f))
-	}
-
-	switch a.Status() {
-	case 0x6581:
-		msg = "decryption failed"
-
-	case 0x6600:
-		msg = "security-related issues (reserved for UIF in this application)"
-
-	case 0x6700:
-		msg = "wrong length (Lc and/or Le)"
-
-	// 0x6300 is "verification failed", represented as AuthErr{0}
-	// 0x63Cn is "verification failed" with retry, represented as AuthErr{n}
-	case 0x6881:
-		msg = "logical channel not supported"
-	case 0x6882:
-		msg = "secure messaging not supported"
-	case 0x6883:
-		msg = "last command of the chain expected"
-	case 0x6884:
-		msg = "command chaining not supported"
-
-	case 0x6982:
-		// Security status not satisfied PW wrong PW not checked (command not allowed) Secure messaging incorrect (checksum and/or cryptogram)
-		msg = "security status not satisfied"
-	case 0x6983:
-		// This will also be AuthErr{0} but we override the message here
-		// so that it's clear that the reason is a block rather than a simple
-		// failed authentication verification.
-		// Authentication method blocked PW blocked (error counter zero)
-		msg = "authentication method blocked"
-	case 0x6985:
-		msg = "Condition of use not satisfied"
-	case 0x6987:
-		// Expected secure messaging DOs missing (e. g. SM-key)
-		msg = "expected secure messaging data objects are missing"
-	case 0x6988:
-		// SM data objects incorrect (e. g. wrong TLV-structure in command data)
-		msg = "secure messaging data objects are incorrect"
-	case 0x6a80:
-		msg = "incorrect parameter in command data field"
-	case 0x6a81:
-		msg = "function not supported"
-	// 0x6a82 is "data object or application not found" aka ErrNotFound
-	case 0x6a84:
-		msg = "not enough memory"
-	case 0x6a86:
-		msg = "incorrect parameter in P1 or P2"
-	case 0x6a88:
-		// Referenced data, reference data or DO not found
-		msg = "referenced data or reference data not found"
-	case 0x6b00:
-		msg = "Wrong parameters P1-P2"
-	case 0x6d00:
-		msg = "Instruction code (INS) not supported or invalid"
-	case 0x6e00:
-		msg = "Class (CLA) not supported"
-	case 0x6f00:
-		msg = "No precise diagnosis"
-	case 0x9000:
-		msg = "Command correct"
-	}
-
-	if msg != "" {
-		msg = ": " + msg
-	}
-	return fmt.Sprintf("smart card error %04x%s", a.Status(), msg)
-}
-
-// Unwrap retrieves an accessible error type, if able.
-func (a *apduErr) Unwrap() error {
+	return a.apdu().Error()
+}
+
+func (a *apduErr) apdu() *apduerr.Error {
+	return &apduerr.Error{SW1: a.sw1, SW2: a.sw2}
+}
+
+// Unwrap retrieves the *apdu.Error and an AuthErr for failed or blocked
+// authentication.
+func (a *apduErr) Unwrap() []error {
+	errs := []error{a.apdu()}
 	st := a.Status()
 	switch {
-	case st == 0x6a82:
-		return ErrNotFound
-	case st == 0x6300:
-		return AuthErr{0}
 	case st == 0x6982:
 		// odd, gpg returns 0x6982 but no retries number.
-		return AuthErr{-1}
+		errs = append(errs, AuthErr{-1})
 	case st == 0x6983:
-		return AuthErr{0}
-	case st&0xfff0 == 0x63c0:
-		return AuthErr{int(st & 0xf)}
-	case st&0xfff0 == 0x6300:
+		errs = append(errs, AuthErr{0})
+	case st&0xfff0 == 0x63c0, st&0xfff0 == 0x6300:
 		// Older YubiKeys sometimes return sw1=0x63 and sw2=0x0N to indicate the
 		// number of retries. This isn't spec compliant, but support it anyway.
 		//
 		// https://github.com/areese/piv-go/issues/60
-		return AuthErr{int(st & 0xf)}
+		errs = append(errs, AuthErr{int(st & 0xf)})
 	}
-	return nil
+	return errs
 }
 
 type apdu struct {
+	// class is or'ed into CLA, 0C for secure messaging.
+	class       byte
 	instruction byte
 	param1      byte
 	param2      byte
 	data        []byte
+	// sendRemaining is the instruction that reads the rest of a long response, GET RESPONSE when 0.
+	sendRemaining byte
+}
+
+// getResponse returns the instruction that reads the rest of the response to d.
+func (d apdu) getResponse() byte {
+	if d.sendRemaining != 0 {
+		return d.sendRemaining
+	}
+
+	return insGetResponseAPDU
 }
 
 func (t *scTx) Transmit(d apdu) ([]byte, error) {
@@ -195,7 +133,7 @@ func (t *scTx) Transmit(d apdu) ([]byte, error) {
 	const maxAPDUDataSize = 0xff
 	for len(data) > maxAPDUDataSize {
 		req := make([]byte, 5+maxAPDUDataSize)
-		req[0] = 0x10 // ISO/IEC 7816-4 5.1.1
+		req[0] = 0x10 | d.class // ISO/IEC 7816-4 5.1.1
 		req[1] = d.instruction
 		req[2] = d.param1
 		req[3] = d.param2
@@ -214,12 +152,13 @@ func (t *scTx) Transmit(d apdu) ([]byte, error) {
 	}
 
 	req := make([]byte, 5+len(data))
+	req[0] = d.class
 	req[1] = d.instruction
 	req[2] = d.param1
 	req[3] = d.param2
 	req[4] = byte(len(data))
 	copy(req[5:], data)
-	hasMore, r, err := t.transmit(req)
+	hasMore, r, err := transmitWithLe(t.transmit, req)
 	if err != nil {
 		if t.debug {
 			fmt.Printf("Transmit failed: %v hasmore: %t\nreq:\n%s\nresp:\n%s\n", err, hasMore, hex.Dump(req), hex.Dump(r))
@@ -231,7 +170,7 @@ func (t *scTx) Transmit(d apdu) ([]byte, error) {
 
 	for hasMore {
 		req := make([]byte, 5)
-		req[1] = insGetResponseAPDU
+		req[1] = d.getResponse()
 		var r []byte
 		hasMore, r, err = t.transmit(req)
 		if err != nil {
@@ -250,3 +189,170 @@ func (t *scTx) Transmit(d apdu) ([]byte, error) {
 
 	return resp, nil
 }
+
+// transmitWithLe sends a short APDU, when the card answers 6CXX, wrong Le, the APDU is sent again with
+// Le set to XX as the card asks.
+// ISO/IEC 7816-4 5.6 Status bytes.
+func transmitWithLe(transmit func(req []byte) (bool, []byte, error), req []byte) (bool, []byte, error) {
+	more, resp, err := transmit(req)
+
+	var e *apduErr
+	if !errors.As(err, &e) || e.sw1 != 0x6c || len(req) < 5 {
+		return more, resp, err
+	}
+
+	reissue := append([]byte{}, req...)
+
+	switch lc := int(req[4]); {
+	case len(req) == 5:
+		// no data, the fifth byte is Le.
+		reissue[4] = e.sw2
+	case len(req) == 5+lc:
+		reissue = append(reissue, e.sw2)
+	default:
+		reissue[len(reissue)-1] = e.sw2
+	}
+
+	return transmit(reissue)
+}
+
+// maxShortAPDUDataSize is the most data a short APDU can carry.
+const maxShortAPDUDataSize = 0xff
+
+// maxExtendedAPDUDataSize is the most data an extended length APDU can carry.
+const maxExtendedAPDUDataSize = 0xffff
+
+// extendedAPDUOverhead is the header, Lc and Le of an extended length APDU.
+const extendedAPDUOverhead = 4 + 3 + 2
+
+// transmitExtended sends d as extended length APDUs with Le 0000,
+// so the whole response comes back at once unless the card answers 6CXX.
+// Data longer than maxData is sent with command chaining.
+// ISO/IEC 7816-4 5.1 Command-response pairs.
+func transmitExtended(transmit func(req []byte) (bool, []byte, error), d apdu, maxData int) ([]byte, error) {
+	if maxData <= 0 || maxData > maxExtendedAPDUDataSize {
+		maxData = maxExtendedAPDUDataSize
+	}
+
+	data := d.data
+	for len(data) > maxData {
+		req := []byte{0x10 | d.class, d.instruction, d.param1, d.param2, 0x00, byte(maxData >> 8), byte(maxData)}
+		req = append(req, data[:maxData]...)
+		req = append(req, 0x00, 0x00)
+
+		if _, _, err := transmit(req); err != nil {
+			return nil, fmt.Errorf("transmitting chained command: %w", err)
+		}
+
+		data = data[maxData:]
+	}
+
+	req := []byte{d.class, d.instruction, d.param1, d.param2, 0x00}
+	if len(data) > 0 {
+		req = append(req, byte(len(data)>>8), byte(len(data)))
+		req = append(req, data...)
+	}
+	req = append(req, 0x00, 0x00)
+
+	hasMore, resp, err := transmit(req)
+
+	// 6CXX, wrong Le, the APDU is sent again with Le set to XX as the card asks, 00 is 256.
+	var e *apduErr
+	if errors.As(err, &e) && e.sw1 == 0x6c {
+		le := int(e.sw2)
+		if le == 0 {
+			le = 0x100
+		}
+
+		req[len(req)-2], req[len(req)-1] = byte(le>>8), byte(le)
+		hasMore, resp, err = transmit(req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for hasMore {
+		var r []byte
+		hasMore, r, err = transmit([]byte{0x00, d.getResponse(), 0x00, 0x00, 0x00, 0x00, 0x00})
+		if err != nil {
+			return nil, fmt.Errorf("reading further response: %w", err)
+		}
+		resp = append(resp, r...)
+	}
+
+	return resp, nil
+}
+
+// PC/SC return codes of readers that can't send an APDU that long.
+// https://pcsclite.apdu.fr/api/group__ErrorCodes.html
+const (
+	rcInvalidParameter   int64 = 0x80100004 // SCARD_E_INVALID_PARAMETER
+	rcInsufficientBuffer int64 = 0x80100008 // SCARD_E_INSUFFICIENT_BUFFER
+	rcInvalidValue       int64 = 0x80100011 // SCARD_E_INVALID_VALUE
+)
+
+// extendedLengthRejected reports whether err means the reader or card can't
+// handle extended length APDUs, rather than the command itself failing.
+// Other reader errors, such as a removed card or a sharing violation, aren't a
+// reason to send the command again, it may not be safe to repeat.
+func extendedLengthRejected(err error) bool {
+	var sc *scErr
+	if errors.As(err, &sc) {
+		switch sc.rc {
+		case rcInvalidParameter, rcInsufficientBuffer, rcInvalidValue:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// wrong length.
+	var e *apduErr
+	return errors.As(err, &e) && e.Status() == 0x6700
+}
+
+// extendedFallback sends APDUs whose data doesn't fit a short APDU as extended
+// length APDUs while enabled. The first time the reader or card rejects one it
+// falls back to short APDUs with chaining for the rest of the transaction, some
+// NFC readers reject extended APDUs that work over USB.
+type extendedFallback struct {
+	enabled bool
+	// maxCommand is the most bytes in a command APDU, 0 if the card didn't say.
+	maxCommand int
+}
+
+// maxData is the most data in one extended length APDU, longer data is chained.
+func (f *extendedFallback) maxData() int {
+	if f.maxCommand <= extendedAPDUOverhead {
+		return maxExtendedAPDUDataSize
+	}
+
+	return f.maxCommand - extendedAPDUOverhead
+}
+
+// transmit sends d, short commands are always sent as short APDUs so a 6700 for
+// them is the command's own error. Only a rejection of the first extended APDU
+// falls back, after part of a chain was accepted the card is mid command and
+// sending it again from the start isn't safe.
+func (f *extendedFallback) transmit(d apdu, raw func([]byte) (bool, []byte, error), short func(apdu) ([]byte, error)) ([]byte, error) {
+	if !f.enabled || len(d.data) <= maxShortAPDUDataSize {
+		return short(d)
+	}
+
+	sent := 0
+	counted := func(req []byte) (bool, []byte, error) {
+		sent++
+
+		return raw(req)
+	}
+
+	resp, err := transmitExtended(counted, d, f.maxData())
+	if err == nil || sent > 1 || !extendedLengthRejected(err) {
+		return resp, err
+	}
+
+	f.enabled = false
+
+	return short(d)
+}
@@ -15,7 +15,13 @@
 
 package piv
 
-import "github.com/areese/piv-go/bertlv"
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/areese/piv-go/bertlv"
+)
 
 // The interfaces here are for wrapping the pcsc code.
 // This allows us to better test the parts of piv by returning various errors from the pcsc stack.
@@ -49,6 +55,16 @@ type SCContext interface {
 	Close() error
 	Connect(reader string) (SCHandle, error)
 	ListReaders() ([]string, error)
+	ATR(reader string) ([]byte, error)
+}
+
+// ExtendedLengthTx is implemented by transports that can send extended length APDUs.
+// If the reader or card rejects one, the transport falls back to short APDUs with chaining.
+// Longer command data is chained, limited by the maximum command length of the card.
+type ExtendedLengthTx interface {
+	SetExtendedLength(enabled bool)
+	ExtendedLength() bool
+	SetMaxCommandLength(n int)
 }
 
 // SCConstructor is a constructor for SCContext.
@@ -59,6 +75,12 @@ type SCConstructor interface {
 type Client struct {
 	client      *client
 	SCConstruct SCConstructor
+
+	// PINPrompt, if set, is used by the YubiKeys opened with Open when a key needs the PIN,
+	// see YubiKey.SetPINPrompt.
+	PINPrompt PINPromptFunc
+	// PINCache keeps the PINs returned by PINPrompt, it may be nil.
+	PINCache *PINCache
 }
 
 type PCSCConstructor struct{}
@@ -72,8 +94,9 @@ type PCSCHandle struct {
 }
 
 type PCSCTx struct {
-	tx    *scTx
-	debug bool
+	tx       *scTx
+	debug    bool
+	extended extendedFallback
 }
 
 var (
@@ -82,14 +105,108 @@ var (
 	_ SCContext       = (*PCSCContext)(nil)
 	_ SCHandle        = (*PCSCHandle)(nil)
 	_ SCTx            = (*PCSCTx)(nil)
+
+	_ ExtendedLengthTx = (*PCSCTx)(nil)
+
+	_ statusChangeContext = (*PCSCContext)(nil)
+	_ optionsContext      = (*PCSCContext)(nil)
+	_ TransactionTx       = (*PCSCTx)(nil)
 )
 
 func (c Client) Open(card string) (*YubiKey, error) {
-	return c.client.Open(card)
+	return c.OpenWithOptions(card, OpenOptions{})
+}
+
+// OpenWithOptions connects to a YubiKey PIV smart card using opts, through SCConstruct when it's set.
+func (c Client) OpenWithOptions(card string, opts OpenOptions) (*YubiKey, error) {
+	construct := c.SCConstruct
+	if construct == nil {
+		construct = &PCSCConstructor{}
+	}
+
+	ctx, err := construct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+
+	var r io.Reader
+	if c.client != nil {
+		r = c.client.Rand
+	}
+
+	yk, err := openPIVOnContext(ctx, card, opts, r)
+	if err != nil {
+		logClose(opts.Logger, "context", ctx.Close())
+
+		return nil, err
+	}
+
+	yk.SetPINPrompt(c.PINPrompt, c.PINCache)
+
+	return yk, nil
 }
 
+// Cards lists the readers of SCConstruct, or of PC/SC when it isn't set.
 func (c Client) Cards() ([]string, error) {
-	return c.client.Cards()
+	if c.SCConstruct == nil {
+		return c.client.Cards()
+	}
+
+	ctx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+	defer ctx.Close()
+
+	return ctx.ListReaders()
+}
+
+// connectApplet connects to card, begins a transaction and selects the applet with the given aid.
+// The response to the select is returned, on failure everything that was opened is closed again.
+func (c *Client) connectApplet(card string, aid []byte) (SCContext, SCHandle, SCTx, []byte, error) {
+	ctx, h, tx, err := c.connectCard(card)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	resp, err := ykSelectApplicationResponse(tx, aid)
+	if err != nil {
+		tx.Close()
+		closeHandles(ctx, h)
+
+		return nil, nil, nil, nil, fmt.Errorf("selecting applet %x: %w", aid, err)
+	}
+
+	return ctx, h, tx, resp, nil
+}
+
+// connectCard connects to a card and begins a transaction without selecting an applet.
+func (c *Client) connectCard(card string) (SCContext, SCHandle, SCTx, error) {
+	ctx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+
+	h, err := ctx.Connect(card)
+	if err != nil {
+		ctx.Close()
+
+		return nil, nil, nil, fmt.Errorf("connecting to smart card: %w", err)
+	}
+
+	tx, err := h.Begin()
+	if err != nil {
+		closeHandles(ctx, h)
+
+		return nil, nil, nil, fmt.Errorf("beginning smart card transaction: %w", err)
+	}
+
+	// if DebugOpen was set, set debug on the tx so we can see dumps from here out.
+	if DebugOpen {
+		tx.EnableDebug()
+	}
+
+	return ctx, h, tx, nil
 }
 
 // nolint:ireturn
@@ -118,10 +235,26 @@ func (p *PCSCContext) Connect(reader string) (SCHandle, error) {
 	return &rv, err
 }
 
+// nolint:ireturn
+func (p *PCSCContext) connectWithOptions(reader string, opts OpenOptions) (SCHandle, error) {
+	var err error
+	rv := PCSCHandle{}
+	rv.h, err = p.ctx.connect(reader, opts)
+	return &rv, err
+}
+
 func (p *PCSCContext) ListReaders() ([]string, error) {
 	return p.ctx.ListReaders()
 }
 
+func (p *PCSCContext) ATR(reader string) ([]byte, error) {
+	return p.ctx.ATR(reader)
+}
+
+func (p *PCSCContext) getStatusChange(states []readerState, timeout time.Duration) error {
+	return p.ctx.getStatusChange(states, timeout)
+}
+
 func (p *PCSCContext) String() string {
 	return bertlv.MakeJSONString(p)
 }
@@ -143,6 +276,16 @@ func (p *PCSCTx) Close() error {
 	return p.tx.Close()
 }
 
+// BeginTransaction begins a transaction after EndTransaction.
+func (p *PCSCTx) BeginTransaction() error {
+	return p.tx.begin()
+}
+
+// EndTransaction ends the transaction without closing the card, BeginTransaction begins another one.
+func (p *PCSCTx) EndTransaction() error {
+	return p.tx.Close()
+}
+
 func (p *PCSCTx) DisableDebug() {
 	p.tx.DisableDebug()
 }
@@ -154,7 +297,22 @@ func (p *PCSCTx) EnableDebug() {
 func (p *PCSCTx) Transmit(d apdu) ([]byte, error) {
 	// FIXME: this and transmitBytes don't overlap correctly.
 	// tx.Transmit will call tx.transmit() without calling transmit bytes.
-	return p.tx.Transmit(d)
+	return p.extended.transmit(d, p.tx.transmit, p.tx.Transmit)
+}
+
+// SetExtendedLength enables extended length APDUs until the reader or card rejects one.
+func (p *PCSCTx) SetExtendedLength(enabled bool) {
+	p.extended.enabled = enabled
+}
+
+// SetMaxCommandLength limits extended length APDUs to n bytes, 0 is no limit.
+func (p *PCSCTx) SetMaxCommandLength(n int) {
+	p.extended.maxCommand = n
+}
+
+// ExtendedLength reports whether extended length APDUs are used, this is false after a fallback to short APDUs.
+func (p *PCSCTx) ExtendedLength() bool {
+	return p.extended.enabled
 }
 
 func (p *PCSCTx) TransmitBytes(req []byte) (more bool, b []byte, err error) {
@@ -27,6 +27,7 @@ import (
 
 var (
 	ErrApduMismatch       = errors.New("src != expected ")
+	ErrApduBadClass       = errors.New("src.class != expected.class ")
 	ErrApduBadInstruction = errors.New("src.instruction != expected.instruction ")
 	ErrApduBadParam       = errors.New("src.param != expected.param ")
 	ErrApduBadData        = errors.New("src.data != expected.data ")
@@ -45,6 +46,8 @@ type TestSCContext struct {
 	ConnectErr     error
 	ListReadersErr error
 	Readers        []string
+	ATRErr         error
+	ATRs           map[string][]byte
 }
 
 type TestSCHandle struct {
@@ -103,6 +106,10 @@ func (p *TestSCContext) ListReaders() ([]string, error) {
 	return p.Readers, p.ListReadersErr
 }
 
+func (p *TestSCContext) ATR(reader string) ([]byte, error) {
+	return p.ATRs[reader], p.ATRErr
+}
+
 func (p *TestSCContext) String() string {
 	return bertlv.MakeJSONString(p)
 }
@@ -158,6 +165,10 @@ func (p *TestSCTx) Transmit(d apdu) ([]byte, error) {
 	defer func() { p.CurrentAPDUIndex++ }()
 
 	if p.CurrentAPDUIndex < len(p.ResponseList) {
+		if err := p.getTransmitError(); err != nil {
+			return nil, err
+		}
+
 		rv := p.ResponseList[p.CurrentAPDUIndex]
 
 		return rv, nil
@@ -194,6 +205,10 @@ func apdusMatch(src *apdu, expected *apdu) (bool, error) {
 		return false, fmt.Errorf("src == %p expected == nil: %w", src, ErrApduMismatch)
 	}
 
+	if src.class != expected.class {
+		errorsFound = append(errorsFound, fmt.Errorf("src.class == [0x%x] expected.class == [0x%x]: %w", src.class, expected.class, ErrApduBadClass))
+	}
+
 	if src.instruction != expected.instruction {
 		errorsFound = append(errorsFound, fmt.Errorf("src.instruction == [0x%x] expected.instruction == [0x%x]: %w", src.instruction, expected.instruction, ErrApduBadInstruction))
 	}
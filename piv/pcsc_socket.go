@@ -0,0 +1,245 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build (linux || freebsd || openbsd) && (pcscsocket || !cgo)
+
+package piv
+
+// The PC/SC backend without cgo talks to pcscd over its socket, so static binaries don't need
+// libpcsclite. It's used when building with -tags pcscsocket or CGO_ENABLED=0.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const rcSuccess = 0
+
+// SCARD_STATE flags of SCardGetStatusChange, see watch.go for the rest.
+const (
+	scardStateUnknown = 0x0004
+	scardStateEmpty   = 0x0010
+	scardStateInUse   = 0x0100
+	scardStateMute    = 0x0200
+
+	// statusChangeInterval is how often getStatusChange reads the reader states.
+	statusChangeInterval = 100 * time.Millisecond
+)
+
+type scContext struct {
+	conn *pcscdConn
+}
+
+func newSCContext() (*scContext, error) {
+	conn, err := dialPCSCD(pcscdSocket())
+	if err != nil {
+		return nil, err
+	}
+	return &scContext{conn: conn}, nil
+}
+
+func (c *scContext) Close() error {
+	return c.conn.Close()
+}
+
+func (c *scContext) ListReaders() ([]string, error) {
+	states, err := c.conn.readerStates()
+	if err != nil {
+		return nil, err
+	}
+
+	var readers []string
+	for _, s := range states {
+		readers = append(readers, s.name())
+	}
+	return readers, nil
+}
+
+// ATR returns the ATR of the card in reader without connecting to it, or nil
+// if the reader is empty.
+func (c *scContext) ATR(reader string) ([]byte, error) {
+	states, err := c.conn.readerStates()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range states {
+		if s.name() != reader {
+			continue
+		}
+		if s.State&pcscdStatePresent == 0 {
+			return nil, nil
+		}
+		return s.atr(), nil
+	}
+	return nil, &scErr{rc: rcReaderUnavailable}
+}
+
+// eventState converts the state of a reader to SCARD_STATE flags, the event counter is in the
+// upper 16 bits like pcsc-lite does so removing and inserting a card is a change.
+func eventState(s *pcscdReaderState) uint32 {
+	var state uint32
+	switch {
+	case s.State&pcscdStatePresent != 0:
+		state = readerStatePresent
+	case s.State&pcscdStateAbsent != 0:
+		state = scardStateEmpty
+	default:
+		state = scardStateMute
+	}
+	if s.Sharing != 0 {
+		state |= scardStateInUse
+	}
+	return state | s.EventCounter<<16
+}
+
+// getStatusChange waits until the state of a reader differs from its current state, or timeout passes.
+// pcscd is polled for the reader states, like SCardGetStatusChange of pcsc-lite does for new readers.
+func (c *scContext) getStatusChange(states []readerState, timeout time.Duration) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		readers, err := c.conn.readerStates()
+		if err != nil {
+			return err
+		}
+
+		byName := make(map[string]*pcscdReaderState, len(readers))
+		for i := range readers {
+			byName[readers[i].name()] = &readers[i]
+		}
+
+		changed := false
+		for i := range states {
+			event := uint32(scardStateUnknown)
+			states[i].atr = nil
+			if r, ok := byName[states[i].reader]; ok {
+				event = eventState(r)
+				states[i].atr = r.atr()
+			}
+			if event != states[i].current&^readerStateChanged {
+				event |= readerStateChanged
+				changed = true
+			}
+			states[i].event = event
+		}
+
+		if changed {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return &scErr{rc: rcTimeout}
+		}
+		time.Sleep(min(statusChangeInterval, time.Until(deadline)))
+	}
+}
+
+type scHandle struct {
+	conn     *pcscdConn
+	card     int32
+	protocol uint32
+}
+
+func (c *scContext) Connect(reader string) (*scHandle, error) {
+	return c.connect(reader, OpenOptions{})
+}
+
+func (c *scContext) connect(reader string, opts OpenOptions) (*scHandle, error) {
+	card, protocol, err := c.conn.connect(reader, opts.ShareMode.pcsc(), opts.Protocols.pcsc())
+	if err != nil {
+		return nil, err
+	}
+	return &scHandle{conn: c.conn, card: card, protocol: protocol}, nil
+}
+
+func (h *scHandle) Close() error {
+	return h.conn.disconnect(h.card)
+}
+
+type scTx struct {
+	conn     *pcscdConn
+	card     int32
+	protocol uint32
+	// debug will dump the contents of the sent and received apdu's to stdout.
+	// this is very useful if you are comparing to another tool you know works.
+	debug bool
+}
+
+// nolint:ireturn
+func (h *scHandle) Begin() (SCTx, error) {
+	if err := h.conn.beginTransaction(h.card); err != nil {
+		return nil, err
+	}
+	return &PCSCTx{
+		tx: &scTx{
+			conn:     h.conn,
+			card:     h.card,
+			protocol: h.protocol,
+			debug:    false,
+		},
+	}, nil
+}
+
+func (t *scTx) Close() error {
+	return t.conn.endTransaction(t.card)
+}
+
+// begin begins a new transaction after Close ended the last one.
+func (t *scTx) begin() error {
+	return t.conn.beginTransaction(t.card)
+}
+
+// EnableDebug will cause the contents of every apdu to be dumped to console until DisableDebug is called.
+func (t *scTx) EnableDebug() {
+	t.debug = true
+}
+
+// DisableDebug will stop dumping the contents of every apdu to console.
+func (t *scTx) DisableDebug() {
+	t.debug = false
+}
+
+func (t *scTx) transmit(req []byte) (more bool, b []byte, err error) {
+	if t.debug {
+		fmt.Printf("<-- apdu=%s", hex.Dump(req[:]))
+	}
+
+	resp, err := t.conn.transmit(t.card, t.protocol, req)
+	if err != nil {
+		return false, nil, fmt.Errorf("transmitting request: %w", err)
+	}
+	respN := len(resp)
+	if respN < 2 {
+		return false, nil, fmt.Errorf("scard response too short: %d", respN)
+	}
+	sw1 := resp[respN-2]
+	sw2 := resp[respN-1]
+
+	if t.debug {
+		e := &apduErr{sw1, sw2}
+		fmt.Printf("--> sw=0x%02x%02x %d bytes:\n%s\n reason: %s\n", sw1, sw2, respN, hex.Dump(resp), e.Error())
+	}
+
+	if sw1 == 0x90 && sw2 == 0x00 {
+		return false, resp[:respN-2], nil
+	}
+	if sw1 == 0x61 {
+		return true, resp[:respN-2], nil
+	}
+	return false, nil, &apduErr{sw1, sw2}
+}
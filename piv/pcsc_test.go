@@ -16,8 +16,11 @@ package piv
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
+
+	apduerr "github.com/areese/piv-go/piv/apdu"
 )
 
 func runContextTest(t *testing.T, f func(t *testing.T, c *scContext)) {
@@ -137,3 +140,265 @@ func TestErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorsStatusWords(t *testing.T) {
+	err := fmt.Errorf("verify: %w", &apduErr{0x63, 0xc2})
+
+	var e *apduerr.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("%v should be an *apdu.Error", err)
+	}
+	if e.SW1 != 0x63 || e.SW2 != 0xc2 {
+		t.Errorf("got SW1=%02x SW2=%02x, want 63c2", e.SW1, e.SW2)
+	}
+
+	var wrong apduerr.ErrWrongPIN
+	if !errors.As(err, &wrong) || wrong.RetriesLeft != 2 {
+		t.Errorf("%v should be ErrWrongPIN{2}, got %v", err, wrong)
+	}
+
+	var authErr AuthErr
+	if !errors.As(err, &authErr) || authErr.Retries != 2 {
+		t.Errorf("%v should be AuthErr{2}, got %v", err, authErr)
+	}
+
+	if !errors.Is(&apduErr{0x6a, 0x82}, apduerr.ErrDataNotFound) {
+		t.Errorf("ErrNotFound should be apdu.ErrDataNotFound")
+	}
+	if !errors.Is(&apduErr{0x69, 0x82}, ErrSecurityStatusNotSatisfied) {
+		t.Errorf("6982 should be ErrSecurityStatusNotSatisfied")
+	}
+}
+
+func TestExtendedFallback(t *testing.T) {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	d := apdu{instruction: 0xda, param1: 0x7f, param2: 0x21, data: data}
+
+	tests := []struct {
+		name         string
+		rawErr       error
+		wantExtended bool
+		wantShort    bool
+		wantErr      bool
+	}{
+		{"extended", nil, true, false, false},
+		{"wrong length", &apduErr{0x67, 0x00}, false, true, false},
+		{"reader rejected", &scErr{rcInsufficientBuffer}, false, true, false},
+		{"invalid parameter", &scErr{rcInvalidParameter}, false, true, false},
+		{"command failed", &apduErr{0x69, 0x82}, true, false, true},
+		{"card removed", &scErr{rcRemovedCard}, true, false, true},
+		{"sharing violation", &scErr{0x8010000B}, true, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotReq []byte
+			raw := func(req []byte) (bool, []byte, error) {
+				gotReq = req
+				return false, []byte{0x01}, test.rawErr
+			}
+			shortSent := false
+			short := func(apdu) ([]byte, error) {
+				shortSent = true
+				return []byte{0x02}, nil
+			}
+
+			f := &extendedFallback{enabled: true}
+			resp, err := f.transmit(d, raw, short)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("transmit: %v", err)
+			}
+			if test.wantErr && test.rawErr != nil && !errors.Is(err, test.rawErr) {
+				t.Errorf("got error %v, want %v", err, test.rawErr)
+			}
+			if shortSent != test.wantShort {
+				t.Errorf("short APDUs sent %t, want %t", shortSent, test.wantShort)
+			}
+			if f.enabled != test.wantExtended {
+				t.Errorf("extended enabled %t, want %t", f.enabled, test.wantExtended)
+			}
+			if test.wantShort && (len(resp) != 1 || resp[0] != 0x02) {
+				t.Errorf("expected short response, got %x", resp)
+			}
+
+			want := append([]byte{0x00, 0xda, 0x7f, 0x21, 0x00, 0x01, 0x2c}, data...)
+			want = append(want, 0x00, 0x00)
+			if string(gotReq) != string(want) {
+				t.Errorf("got request %x, want %x", gotReq, want)
+			}
+		})
+	}
+}
+
+func TestExtendedFallbackShortData(t *testing.T) {
+	wrongLength := &apduErr{0x67, 0x00}
+	raw := func([]byte) (bool, []byte, error) {
+		t.Fatalf("short data sent as an extended APDU")
+		return false, nil, nil
+	}
+	short := func(apdu) ([]byte, error) {
+		return nil, wrongLength
+	}
+
+	f := &extendedFallback{enabled: true}
+	_, err := f.transmit(apdu{instruction: 0xda, data: make([]byte, 0xff)}, raw, short)
+	if !errors.Is(err, wrongLength) {
+		t.Errorf("got error %v, want 6700", err)
+	}
+	if !f.enabled {
+		t.Errorf("extended disabled by a short command")
+	}
+}
+
+func TestExtendedFallbackPartialChain(t *testing.T) {
+	wrongLength := &apduErr{0x67, 0x00}
+	sent := 0
+	raw := func([]byte) (bool, []byte, error) {
+		sent++
+		if sent == 2 {
+			return false, nil, wrongLength
+		}
+		return false, nil, nil
+	}
+	short := func(apdu) ([]byte, error) {
+		t.Fatalf("command resent as short APDUs after part of the chain was accepted")
+		return nil, nil
+	}
+
+	f := &extendedFallback{enabled: true, maxCommand: extendedAPDUOverhead + 0x100}
+	_, err := f.transmit(apdu{instruction: 0xda, data: make([]byte, 0x180)}, raw, short)
+	if !errors.Is(err, wrongLength) {
+		t.Errorf("got error %v, want 6700", err)
+	}
+	if sent != 2 {
+		t.Errorf("sent %d extended APDUs, want 2", sent)
+	}
+	if !f.enabled {
+		t.Errorf("extended disabled after part of the chain was accepted")
+	}
+}
+
+func TestTransmitExtendedChaining(t *testing.T) {
+	data := make([]byte, 5)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var reqs [][]byte
+	raw := func(req []byte) (bool, []byte, error) {
+		reqs = append(reqs, req)
+		if len(reqs) == 3 {
+			// the last command has more response data.
+			return true, []byte{0x01}, nil
+		}
+		if len(reqs) == 4 {
+			return false, []byte{0x02}, nil
+		}
+		return false, nil, nil
+	}
+
+	resp, err := transmitExtended(raw, apdu{instruction: 0xda, param1: 0x7f, param2: 0x21, data: data}, 2)
+	if err != nil {
+		t.Fatalf("transmit: %v", err)
+	}
+	if string(resp) != "\x01\x02" {
+		t.Errorf("got response %x", resp)
+	}
+
+	want := [][]byte{
+		{0x10, 0xda, 0x7f, 0x21, 0x00, 0x00, 0x02, 0x00, 0x01, 0x00, 0x00},
+		{0x10, 0xda, 0x7f, 0x21, 0x00, 0x00, 0x02, 0x02, 0x03, 0x00, 0x00},
+		{0x00, 0xda, 0x7f, 0x21, 0x00, 0x00, 0x01, 0x04, 0x00, 0x00},
+		{0x00, insGetResponseAPDU, 0x00, 0x00, 0x00, 0x00, 0x00},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(reqs), len(want))
+	}
+	for i := range want {
+		if string(reqs[i]) != string(want[i]) {
+			t.Errorf("request %d got %x, want %x", i, reqs[i], want[i])
+		}
+	}
+}
+
+func TestTransmitExtendedWrongLe(t *testing.T) {
+	var reqs [][]byte
+	raw := func(req []byte) (bool, []byte, error) {
+		reqs = append(reqs, append([]byte{}, req...))
+		if len(reqs) == 1 {
+			return false, nil, &apduErr{0x6c, 0x10}
+		}
+		return false, []byte{0x01}, nil
+	}
+
+	resp, err := transmitExtended(raw, apdu{instruction: insGetData, data: []byte{0x5c, 0x01, 0x7e}}, 0)
+	if err != nil {
+		t.Fatalf("transmit: %v", err)
+	}
+	if string(resp) != "\x01" {
+		t.Errorf("got response %x", resp)
+	}
+
+	want := [][]byte{
+		{0x00, insGetData, 0x00, 0x00, 0x00, 0x00, 0x03, 0x5c, 0x01, 0x7e, 0x00, 0x00},
+		{0x00, insGetData, 0x00, 0x00, 0x00, 0x00, 0x03, 0x5c, 0x01, 0x7e, 0x00, 0x10},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(reqs), len(want))
+	}
+	for i := range want {
+		if string(reqs[i]) != string(want[i]) {
+			t.Errorf("request %d got %x, want %x", i, reqs[i], want[i])
+		}
+	}
+}
+
+func TestTransmitWithLe(t *testing.T) {
+	tests := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{"no data", []byte{0x00, 0xca, 0x00, 0x6e, 0x00}, []byte{0x00, 0xca, 0x00, 0x6e, 0x10}},
+		{"data without le", []byte{0x00, 0xcb, 0x3f, 0xff, 0x01, 0x5c}, []byte{0x00, 0xcb, 0x3f, 0xff, 0x01, 0x5c, 0x10}},
+		{"data and le", []byte{0x00, 0xcb, 0x3f, 0xff, 0x01, 0x5c, 0x00}, []byte{0x00, 0xcb, 0x3f, 0xff, 0x01, 0x5c, 0x10}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reqs [][]byte
+			raw := func(req []byte) (bool, []byte, error) {
+				reqs = append(reqs, req)
+				if len(reqs) == 1 {
+					return false, nil, &apduErr{0x6c, 0x10}
+				}
+				return false, []byte{0x01}, nil
+			}
+
+			_, resp, err := transmitWithLe(raw, test.req)
+			if err != nil {
+				t.Fatalf("transmit: %v", err)
+			}
+			if string(resp) != "\x01" {
+				t.Errorf("got response %x", resp)
+			}
+			if len(reqs) != 2 {
+				t.Fatalf("got %d requests, want 2", len(reqs))
+			}
+			if string(reqs[1]) != string(test.want) {
+				t.Errorf("reissued %x, want %x", reqs[1], test.want)
+			}
+		})
+	}
+
+	// other errors are returned as they are.
+	calls := 0
+	_, _, err := transmitWithLe(func(req []byte) (bool, []byte, error) {
+		calls++
+		return false, nil, &apduErr{0x6a, 0x82}
+	}, []byte{0x00, 0xca, 0x00, 0x6e, 0x00})
+	if !errors.Is(err, ErrNotFound) || calls != 1 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+}
@@ -12,8 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build darwin || linux || freebsd || openbsd
-// +build darwin linux freebsd openbsd
+//go:build darwin || ((linux || freebsd || openbsd) && !pcscsocket)
 
 package piv
 
@@ -29,6 +28,7 @@ package piv
 // #cgo openbsd CFLAGS: -I/usr/local/include/PCSC
 // #cgo openbsd LDFLAGS: -L/usr/local/lib/
 // #cgo openbsd LDFLAGS: -lpcsclite
+// #include <stdlib.h>
 // #include <PCSC/winscard.h>
 // #include <PCSC/wintypes.h>
 import "C"
@@ -37,6 +37,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"time"
 	"unsafe"
 )
 
@@ -89,17 +90,69 @@ func (c *scContext) ListReaders() ([]string, error) {
 	return readers, nil
 }
 
+// ATR returns the ATR of the card in reader without connecting to it, or nil
+// if the reader is empty.
+func (c *scContext) ATR(reader string) ([]byte, error) {
+	cReader := C.CString(reader)
+	defer C.free(unsafe.Pointer(cReader))
+
+	var state C.SCARD_READERSTATE
+	state.szReader = cReader
+	state.dwCurrentState = C.SCARD_STATE_UNAWARE
+	rc := C.SCardGetStatusChange(c.ctx, 0, &state, 1)
+	if err := scCheck(rc); err != nil {
+		return nil, err
+	}
+	if state.dwEventState&C.SCARD_STATE_PRESENT == 0 {
+		return nil, nil
+	}
+	return C.GoBytes(unsafe.Pointer(&state.rgbAtr[0]), C.int(state.cbAtr)), nil
+}
+
+// getStatusChange waits until the state of a reader differs from its current state, or timeout passes.
+func (c *scContext) getStatusChange(states []readerState, timeout time.Duration) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	cStates := make([]C.SCARD_READERSTATE, len(states))
+	for i := range states {
+		cStates[i].szReader = C.CString(states[i].reader)
+		defer C.free(unsafe.Pointer(cStates[i].szReader))
+		cStates[i].dwCurrentState = C.DWORD(states[i].current)
+	}
+
+	rc := C.SCardGetStatusChange(c.ctx, C.DWORD(timeout.Milliseconds()), &cStates[0], C.DWORD(len(cStates)))
+	if err := scCheck(rc); err != nil {
+		return err
+	}
+
+	for i := range states {
+		states[i].event = uint32(cStates[i].dwEventState)
+		states[i].atr = C.GoBytes(unsafe.Pointer(&cStates[i].rgbAtr[0]), C.int(cStates[i].cbAtr))
+	}
+
+	return nil
+}
+
 type scHandle struct {
 	h C.SCARDHANDLE
 }
 
 func (c *scContext) Connect(reader string) (*scHandle, error) {
+	return c.connect(reader, OpenOptions{})
+}
+
+func (c *scContext) connect(reader string, opts OpenOptions) (*scHandle, error) {
 	var (
 		handle         C.SCARDHANDLE
 		activeProtocol C.DWORD
 	)
-	rc := C.SCardConnect(c.ctx, C.CString(reader),
-		C.SCARD_SHARE_EXCLUSIVE, C.SCARD_PROTOCOL_T1,
+	cReader := C.CString(reader)
+	defer C.free(unsafe.Pointer(cReader))
+
+	rc := C.SCardConnect(c.ctx, cReader,
+		C.DWORD(opts.ShareMode.pcsc()), C.DWORD(opts.Protocols.pcsc()),
 		&handle, &activeProtocol)
 	if err := scCheck(rc); err != nil {
 		return nil, err
@@ -135,6 +188,11 @@ func (t *scTx) Close() error {
 	return scCheck(C.SCardEndTransaction(t.h, C.SCARD_LEAVE_CARD))
 }
 
+// begin begins a new transaction after Close ended the last one.
+func (t *scTx) begin() error {
+	return scCheck(C.SCardBeginTransaction(t.h))
+}
+
 // EnableDebug will cause the contents of every apdu to be dumped to console until DisableDebug is called.
 func (t *scTx) EnableDebug() {
 	t.debug = true
@@ -18,6 +18,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -31,16 +32,20 @@ var (
 	procSCardBeginTransaction = winscard.NewProc("SCardBeginTransaction")
 	procSCardEndTransaction   = winscard.NewProc("SCardEndTransaction")
 	procSCardTransmit         = winscard.NewProc("SCardTransmit")
+	procSCardGetStatusChangeW = winscard.NewProc("SCardGetStatusChangeW")
+
+	// The SCARD_IO_REQUESTs exported by winscard, SCardTransmit needs the one of the active protocol.
+	procSCardT0Pci = winscard.NewProc("g_rgSCardT0Pci")
+	procSCardT1Pci = winscard.NewProc("g_rgSCardT1Pci")
 )
 
 const (
 	scardScopeSystem      = 2
-	scardShareExclusive   = 1
 	scardLeaveCard        = 0
-	scardProtocolT1       = 2
-	scardPCIT1            = 0
 	maxBufferSizeExtended = (4 + 3 + (1 << 16) + 3 + 2)
 	rcSuccess             = 0
+	scardStateUnaware     = 0x0000
+	scardStatePresent     = 0x0020
 )
 
 func scCheck(rc uintptr) error {
@@ -50,8 +55,15 @@ func scCheck(rc uintptr) error {
 	return &scErr{int64(rc)}
 }
 
+// isRCNoReaders is true for SCARD_E_NO_READERS_AVAILABLE, and for SCARD_E_NO_SERVICE and
+// SCARD_E_SERVICE_STOPPED as Windows stops the smart card service when the last reader is removed.
 func isRCNoReaders(rc uintptr) bool {
-	return rc == 0x8010002E
+	switch rc {
+	case 0x8010002E, 0x8010001D, 0x8010001E:
+		return true
+	default:
+		return false
+	}
 }
 
 type scContext struct {
@@ -106,27 +118,31 @@ func (c *scContext) ListReaders() ([]string, error) {
 		return nil, err
 	}
 
+	// d is a multi-string, UTF-16 names each ending with NUL and an empty name ending the list.
 	var readers []string
 	j := 0
-	for i := 0; i < len(d); i++ {
+	for i := 0; i < int(n) && i < len(d); i++ {
 		if d[i] != 0 {
 			continue
 		}
-		readers = append(readers, syscall.UTF16ToString(d[j:i]))
-		j = i + 1
-
-		if d[i+1] == 0 {
+		if i == j {
 			break
 		}
+		readers = append(readers, syscall.UTF16ToString(d[j:i]))
+		j = i + 1
 	}
 
 	return readers, nil
 }
 
 func (c *scContext) Connect(reader string) (*scHandle, error) {
+	return c.connect(reader, OpenOptions{})
+}
+
+func (c *scContext) connect(reader string, opts OpenOptions) (*scHandle, error) {
 	var (
 		handle         syscall.Handle
-		activeProtocol uint16
+		activeProtocol uint32
 	)
 	readerPtr, err := syscall.UTF16PtrFromString(reader)
 	if err != nil {
@@ -135,19 +151,91 @@ func (c *scContext) Connect(reader string) (*scHandle, error) {
 	r0, _, _ := procSCardConnectW.Call(
 		uintptr(c.ctx),
 		uintptr(unsafe.Pointer(readerPtr)),
-		scardShareExclusive,
-		scardProtocolT1,
+		uintptr(opts.ShareMode.pcsc()),
+		uintptr(opts.Protocols.pcsc()),
 		uintptr(unsafe.Pointer(&handle)),
-		uintptr(activeProtocol),
+		uintptr(unsafe.Pointer(&activeProtocol)),
 	)
 	if err := scCheck(r0); err != nil {
 		return nil, err
 	}
-	return &scHandle{handle}, nil
+	return &scHandle{handle: handle, protocol: activeProtocol}, nil
+}
+
+// scardReaderStateW is SCARD_READERSTATEW.
+type scardReaderStateW struct {
+	reader       *uint16
+	userData     uintptr
+	currentState uint32
+	eventState   uint32
+	atrLen       uint32
+	atr          [36]byte
+}
+
+// ATR returns the ATR of the card in reader without connecting to it, or nil
+// if the reader is empty.
+func (c *scContext) ATR(reader string) ([]byte, error) {
+	readerPtr, err := syscall.UTF16PtrFromString(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reader string: %v", err)
+	}
+	state := scardReaderStateW{
+		reader:       readerPtr,
+		currentState: scardStateUnaware,
+	}
+	r0, _, _ := procSCardGetStatusChangeW.Call(
+		uintptr(c.ctx),
+		0,
+		uintptr(unsafe.Pointer(&state)),
+		1,
+	)
+	if err := scCheck(r0); err != nil {
+		return nil, err
+	}
+	if state.eventState&scardStatePresent == 0 {
+		return nil, nil
+	}
+	return append([]byte(nil), state.atr[:state.atrLen]...), nil
+}
+
+// getStatusChange waits until the state of a reader differs from its current state, or timeout passes.
+func (c *scContext) getStatusChange(states []readerState, timeout time.Duration) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	wStates := make([]scardReaderStateW, len(states))
+	for i := range states {
+		readerPtr, err := syscall.UTF16PtrFromString(states[i].reader)
+		if err != nil {
+			return fmt.Errorf("invalid reader string: %v", err)
+		}
+		wStates[i].reader = readerPtr
+		wStates[i].currentState = states[i].current
+	}
+
+	r0, _, _ := procSCardGetStatusChangeW.Call(
+		uintptr(c.ctx),
+		uintptr(timeout.Milliseconds()),
+		uintptr(unsafe.Pointer(&wStates[0])),
+		uintptr(len(wStates)),
+	)
+	if err := scCheck(r0); err != nil {
+		return err
+	}
+
+	for i := range states {
+		states[i].event = wStates[i].eventState
+		states[i].atr = append([]byte(nil), wStates[i].atr[:wStates[i].atrLen]...)
+	}
+
+	return nil
 }
 
 type scHandle struct {
 	handle syscall.Handle
+	// protocol is the protocol negotiated by SCardConnectW.
+	protocol uint32
 }
 
 func (h *scHandle) Close() error {
@@ -162,8 +250,9 @@ func (h *scHandle) Begin() (SCTx, error) {
 	}
 	return &PCSCTx{
 		tx: &scTx{
-			handle: h.handle,
-			debug:  false,
+			handle:   h.handle,
+			protocol: h.protocol,
+			debug:    false,
 		},
 	}, nil
 }
@@ -173,8 +262,15 @@ func (t *scTx) Close() error {
 	return scCheck(r0)
 }
 
+// begin begins a new transaction after Close ended the last one.
+func (t *scTx) begin() error {
+	r0, _, _ := procSCardBeginTransaction.Call(uintptr(t.handle))
+	return scCheck(r0)
+}
+
 type scTx struct {
-	handle syscall.Handle
+	handle   syscall.Handle
+	protocol uint32
 	// debug will dump the contents of the sent and received apdu's to stdout.
 	// this is very useful if you are comparing to another tool you know works.
 	debug bool
@@ -194,10 +290,18 @@ func (t *scTx) IsDebugEnabled() bool {
 	return t.debug
 }
 
+// sendPCI returns the SCARD_IO_REQUEST of the protocol of the connection.
+func (t *scTx) sendPCI() uintptr {
+	if t.protocol == scardProtocolT0 {
+		return procSCardT0Pci.Addr()
+	}
+	return procSCardT1Pci.Addr()
+}
+
 func (t *scTx) transmit(req []byte) (more bool, b []byte, err error) {
 	var resp [maxBufferSizeExtended]byte
 	reqN := len(req)
-	respN := len(resp)
+	respN := uint32(len(resp))
 
 	if t.debug {
 		fmt.Printf("<-- apdu=%s", hex.Dump(req[:]))
@@ -205,7 +309,7 @@ func (t *scTx) transmit(req []byte) (more bool, b []byte, err error) {
 
 	r0, _, _ := procSCardTransmit.Call(
 		uintptr(t.handle),
-		uintptr(scardPCIT1),
+		t.sendPCI(),
 		uintptr(unsafe.Pointer(&req[0])),
 		uintptr(reqN),
 		uintptr(0),
@@ -0,0 +1,346 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// pcscdConn talks to pcscd over its unix socket without libpcsclite, the protocol of pcsc-lite's
+// winscard_msg.h. Each message is a header of the size and command followed by a fixed size struct in
+// host byte order, the reply is the same struct with rv set.
+// https://github.com/LudovicRousseau/PCSC/blob/master/src/winscard_msg.h
+
+const (
+	// pcscdSocketEnv overrides pcscdDefaultSocket, like it does for libpcsclite.
+	pcscdSocketEnv     = "PCSCLITE_CSOCK_NAME"
+	pcscdDefaultSocket = "/run/pcscd/pcscd.comm"
+
+	// pcscd accepts clients of protocol 4.4 since pcsc-lite 1.8.24.
+	pcscdProtocolMajor = 4
+	pcscdProtocolMinor = 4
+
+	pcscdMaxReaderName = 128
+	pcscdMaxATRSize    = 33
+	pcscdMaxReaders    = 16
+	// pcscdMaxBufferSize is MAX_BUFFER_SIZE_EXTENDED.
+	pcscdMaxBufferSize = 4 + 3 + (1 << 16) + 3 + 2
+
+	pcscdScopeSystem = 2
+	pcscdLeaveCard   = 0
+)
+
+// Commands of enum pcsc_msg_commands.
+const (
+	pcscdEstablishContext = 0x01
+	pcscdReleaseContext   = 0x02
+	pcscdConnect          = 0x04
+	pcscdDisconnect       = 0x06
+	pcscdBeginTransaction = 0x07
+	pcscdEndTransaction   = 0x08
+	pcscdTransmit         = 0x09
+	pcscdVersion          = 0x11
+	pcscdGetReadersState  = 0x12
+)
+
+// Reader states of the pcscd reader list, they differ from the SCARD_STATE flags of SCardGetStatusChange.
+const (
+	pcscdStateAbsent  = 0x0002
+	pcscdStatePresent = 0x0004
+)
+
+type pcscdHeader struct {
+	Size    uint32
+	Command uint32
+}
+
+type pcscdVersionMsg struct {
+	Major int32
+	Minor int32
+	RV    uint32
+}
+
+type pcscdEstablishMsg struct {
+	Scope   uint32
+	Context uint32
+	RV      uint32
+}
+
+type pcscdReleaseMsg struct {
+	Context uint32
+	RV      uint32
+}
+
+type pcscdConnectMsg struct {
+	Context            uint32
+	Reader             [pcscdMaxReaderName]byte
+	ShareMode          uint32
+	PreferredProtocols uint32
+	Card               int32
+	ActiveProtocol     uint32
+	RV                 uint32
+}
+
+type pcscdDisconnectMsg struct {
+	Card        int32
+	Disposition uint32
+	RV          uint32
+}
+
+type pcscdBeginMsg struct {
+	Card int32
+	RV   uint32
+}
+
+type pcscdEndMsg struct {
+	Card        int32
+	Disposition uint32
+	RV          uint32
+}
+
+type pcscdTransmitMsg struct {
+	Card            int32
+	SendPCIProtocol uint32
+	SendPCILength   uint32
+	SendLength      uint32
+	RecvPCIProtocol uint32
+	RecvPCILength   uint32
+	RecvLength      uint32
+	RV              uint32
+}
+
+// pcscdReaderState is READER_STATE, the entries of the reader list.
+type pcscdReaderState struct {
+	Name         [pcscdMaxReaderName]byte
+	EventCounter uint32
+	State        uint32
+	Sharing      int32
+	ATR          [pcscdMaxATRSize]byte
+	_            [3]byte
+	ATRLength    uint32
+	Protocol     uint32
+}
+
+func (s *pcscdReaderState) name() string {
+	name, _, _ := bytes.Cut(s.Name[:], []byte{0})
+	return string(name)
+}
+
+func (s *pcscdReaderState) atr() []byte {
+	n := min(int(s.ATRLength), len(s.ATR))
+	return append([]byte(nil), s.ATR[:n]...)
+}
+
+// pcscdConn is an application context of pcscd, pcscd ties a context to the connection it was established on.
+type pcscdConn struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	context uint32
+}
+
+// pcscdSocket returns the path of the socket of pcscd.
+func pcscdSocket() string {
+	if path := os.Getenv(pcscdSocketEnv); path != "" {
+		return path
+	}
+
+	return pcscdDefaultSocket
+}
+
+// dialPCSCD connects to pcscd at path and establishes a context.
+func dialPCSCD(path string) (*pcscdConn, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to pcscd: %w", err)
+	}
+
+	c := &pcscdConn{conn: conn}
+
+	v := pcscdVersionMsg{Major: pcscdProtocolMajor, Minor: pcscdProtocolMinor}
+	if err := c.call(pcscdVersion, &v, nil); err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("pcscd protocol %d.%d: %w", pcscdProtocolMajor, pcscdProtocolMinor, err)
+	}
+
+	e := pcscdEstablishMsg{Scope: pcscdScopeSystem}
+	if err := c.call(pcscdEstablishContext, &e, nil); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	c.context = e.Context
+
+	return c, nil
+}
+
+// pcscdMsg is a message struct, the last field of every message is rv.
+type pcscdMsg interface {
+	result() uint32
+}
+
+func (m *pcscdVersionMsg) result() uint32    { return m.RV }
+func (m *pcscdEstablishMsg) result() uint32  { return m.RV }
+func (m *pcscdReleaseMsg) result() uint32    { return m.RV }
+func (m *pcscdConnectMsg) result() uint32    { return m.RV }
+func (m *pcscdDisconnectMsg) result() uint32 { return m.RV }
+func (m *pcscdBeginMsg) result() uint32      { return m.RV }
+func (m *pcscdEndMsg) result() uint32        { return m.RV }
+func (m *pcscdTransmitMsg) result() uint32   { return m.RV }
+
+// call sends msg followed by data and reads the reply into msg, an error is returned when rv isn't SCARD_S_SUCCESS.
+func (c *pcscdConn) call(command uint32, msg pcscdMsg, data []byte) error {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.NativeEndian, pcscdHeader{Size: uint32(binary.Size(msg)), Command: command})
+	binary.Write(&buf, binary.NativeEndian, msg)
+	buf.Write(data)
+
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing to pcscd: %w", err)
+	}
+
+	if err := binary.Read(c.conn, binary.NativeEndian, msg); err != nil {
+		return fmt.Errorf("reading from pcscd: %w", err)
+	}
+
+	if rv := msg.result(); rv != 0 {
+		return &scErr{int64(rv)}
+	}
+
+	return nil
+}
+
+func (c *pcscdConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := pcscdReleaseMsg{Context: c.context}
+	err := c.call(pcscdReleaseContext, &r, nil)
+
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// readerStates returns the readers known to pcscd.
+func (c *pcscdConn) readerStates() ([]pcscdReaderState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.NativeEndian, pcscdHeader{Command: pcscdGetReadersState})
+
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("writing to pcscd: %w", err)
+	}
+
+	var states [pcscdMaxReaders]pcscdReaderState
+	if err := binary.Read(c.conn, binary.NativeEndian, &states); err != nil {
+		return nil, fmt.Errorf("reading from pcscd: %w", err)
+	}
+
+	var rv []pcscdReaderState
+
+	for _, s := range states {
+		if s.Name[0] != 0 {
+			rv = append(rv, s)
+		}
+	}
+
+	return rv, nil
+}
+
+// connect connects to the card in reader and returns its handle and the active protocol.
+func (c *pcscdConn) connect(reader string, shareMode, protocols uint32) (int32, uint32, error) {
+	m := pcscdConnectMsg{Context: c.context, ShareMode: shareMode, PreferredProtocols: protocols}
+	if len(reader) >= len(m.Reader) {
+		return 0, 0, fmt.Errorf("reader name longer than %d bytes", len(m.Reader)-1)
+	}
+
+	copy(m.Reader[:], reader)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(pcscdConnect, &m, nil); err != nil {
+		return 0, 0, err
+	}
+
+	return m.Card, m.ActiveProtocol, nil
+}
+
+func (c *pcscdConn) disconnect(card int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.call(pcscdDisconnect, &pcscdDisconnectMsg{Card: card, Disposition: pcscdLeaveCard}, nil)
+}
+
+func (c *pcscdConn) beginTransaction(card int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.call(pcscdBeginTransaction, &pcscdBeginMsg{Card: card}, nil)
+}
+
+func (c *pcscdConn) endTransaction(card int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.call(pcscdEndTransaction, &pcscdEndMsg{Card: card, Disposition: pcscdLeaveCard}, nil)
+}
+
+// transmit sends req to the card and returns the response with the status words.
+func (c *pcscdConn) transmit(card int32, protocol uint32, req []byte) ([]byte, error) {
+	const pciLength = 8 // sizeof(SCARD_IO_REQUEST)
+
+	m := pcscdTransmitMsg{
+		Card:            card,
+		SendPCIProtocol: protocol,
+		SendPCILength:   pciLength,
+		SendLength:      uint32(len(req)),
+		RecvPCIProtocol: protocol,
+		RecvPCILength:   pciLength,
+		RecvLength:      pcscdMaxBufferSize,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call(pcscdTransmit, &m, req); err != nil {
+		return nil, err
+	}
+
+	if m.RecvLength > pcscdMaxBufferSize {
+		return nil, fmt.Errorf("pcscd response of %d bytes too long", m.RecvLength)
+	}
+
+	resp := make([]byte, m.RecvLength)
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return nil, fmt.Errorf("reading from pcscd: %w", err)
+	}
+
+	return resp, nil
+}
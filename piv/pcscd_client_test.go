@@ -0,0 +1,186 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// fakePCSCD serves one connection with a reader holding a card that answers every command with 9000.
+func fakePCSCD(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	defer conn.Close()
+
+	reply := func(m any) {
+		if err := binary.Write(conn, binary.NativeEndian, m); err != nil {
+			t.Errorf("fake pcscd: %v", err)
+		}
+	}
+
+	for {
+		var h pcscdHeader
+		if err := binary.Read(conn, binary.NativeEndian, &h); err != nil {
+			return
+		}
+
+		switch h.Command {
+		case pcscdVersion:
+			var m pcscdVersionMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+
+			if m.Major != pcscdProtocolMajor || m.Minor != pcscdProtocolMinor {
+				m.RV = uint32(rcTimeout)
+			}
+
+			reply(&m)
+		case pcscdEstablishContext:
+			var m pcscdEstablishMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+			m.Context = 0x1234
+			reply(&m)
+		case pcscdReleaseContext:
+			var m pcscdReleaseMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+			reply(&m)
+		case pcscdGetReadersState:
+			var states [pcscdMaxReaders]pcscdReaderState
+			copy(states[0].Name[:], "Yubico YubiKey OTP+FIDO+CCID 00 00")
+			states[0].State = pcscdStatePresent
+			states[0].ATRLength = 3
+			copy(states[0].ATR[:], []byte{0x3b, 0x8c, 0x80})
+			reply(&states)
+		case pcscdConnect:
+			var m pcscdConnectMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+
+			if m.Context != 0x1234 {
+				m.RV = uint32(rcReaderUnavailable)
+			}
+
+			m.Card, m.ActiveProtocol = 7, scardProtocolT1
+			reply(&m)
+		case pcscdBeginTransaction:
+			var m pcscdBeginMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+			reply(&m)
+		case pcscdEndTransaction, pcscdDisconnect:
+			var m pcscdEndMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+			reply(&m)
+		case pcscdTransmit:
+			var m pcscdTransmitMsg
+			binary.Read(conn, binary.NativeEndian, &m)
+
+			req := make([]byte, m.SendLength)
+			io.ReadFull(conn, req)
+
+			resp := append(req[1:2], 0x90, 0x00)
+			m.RecvLength = uint32(len(resp))
+			reply(&m)
+			conn.Write(resp)
+		default:
+			t.Errorf("fake pcscd: unexpected command 0x%x", h.Command)
+			return
+		}
+	}
+}
+
+func TestPCSCDConn(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "pcscd.comm")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			fakePCSCD(t, conn)
+		}
+	}()
+
+	c, err := dialPCSCD(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := c.readerStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(states) != 1 || states[0].name() != "Yubico YubiKey OTP+FIDO+CCID 00 00" || !bytes.Equal(states[0].atr(), []byte{0x3b, 0x8c, 0x80}) {
+		t.Fatalf("unexpected reader states %+v", states)
+	}
+
+	card, protocol, err := c.connect(states[0].name(), scardShareExclusive, scardProtocolT1)
+	if err != nil || card != 7 || protocol != scardProtocolT1 {
+		t.Fatalf("connect: %d %d %v", card, protocol, err)
+	}
+
+	if err := c.beginTransaction(card); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.transmit(card, protocol, []byte{0x00, 0xa4, 0x04, 0x00})
+	if err != nil || !bytes.Equal(resp, []byte{0xa4, 0x90, 0x00}) {
+		t.Errorf("transmit: %x %v", resp, err)
+	}
+
+	if _, _, err := c.connect(string(make([]byte, pcscdMaxReaderName)), scardShareExclusive, scardProtocolT1); err == nil {
+		t.Errorf("connected to a reader with a long name")
+	}
+
+	if err := c.endTransaction(card); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.disconnect(card); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPCSCDConn_Error(t *testing.T) {
+	t.Parallel()
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go fakePCSCD(t, server)
+
+	c := &pcscdConn{conn: client, context: 0x9999}
+
+	_, _, err := c.connect("reader", scardShareExclusive, scardProtocolT1)
+
+	var sc *scErr
+	if !errors.As(err, &sc) || sc.rc != rcReaderUnavailable {
+		t.Errorf("expected SCARD_E_READER_UNAVAILABLE, got %v", err)
+	}
+}
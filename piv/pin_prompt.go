@@ -0,0 +1,205 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PINRef identifies the PIN a PINPromptFunc asks for.
+type PINRef int
+
+const (
+	// PINRefPIV is the PIN of the PIV applet.
+	PINRefPIV PINRef = iota + 1
+)
+
+func (r PINRef) String() string {
+	switch r {
+	case PINRefPIV:
+		return "piv pin"
+	}
+
+	return fmt.Sprintf("unknown: %d", int(r))
+}
+
+// PINPromptFunc asks the user for the PIN identified by ref. It's only called when an operation
+// needs the PIN and ctx is the context of that operation, e.g. from SignContext.
+type PINPromptFunc func(ctx context.Context, ref PINRef) (string, error)
+
+// PINCachePolicy is how long a PINCache keeps a PIN returned by a PINPromptFunc.
+type PINCachePolicy int
+
+const (
+	// PINCacheNone prompts each time the card asks for the PIN.
+	PINCacheNone PINCachePolicy = iota
+	// PINCacheTransaction keeps the PIN until the transaction is ended or the card is closed.
+	PINCacheTransaction
+	// PINCacheProcess keeps the PIN for Timeout, across transactions and handles sharing the cache.
+	PINCacheProcess
+)
+
+// PINCache keeps PINs returned by a PINPromptFunc per card and PINRef, so the user isn't
+// asked again when the card asks for the PIN again. A PIN the card rejects is removed.
+// It's safe for concurrent use and can be shared by handles of different cards.
+type PINCache struct {
+	// Policy is how long PINs are kept.
+	Policy PINCachePolicy
+	// Timeout is how long PINCacheProcess keeps a PIN, zero keeps it until Clear.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	pins map[pinCacheKey]pinCacheEntry
+	now  func() time.Time
+}
+
+type pinCacheKey struct {
+	card string
+	ref  PINRef
+}
+
+type pinCacheEntry struct {
+	pin     string
+	expires time.Time
+}
+
+// NewPINCache returns a cache that keeps PINs according to policy, timeout is used with PINCacheProcess.
+func NewPINCache(policy PINCachePolicy, timeout time.Duration) *PINCache {
+	return &PINCache{Policy: policy, Timeout: timeout}
+}
+
+func (c *PINCache) get(card string, ref PINRef) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := pinCacheKey{card: card, ref: ref}
+
+	e, ok := c.pins[key]
+	if !ok {
+		return "", false
+	}
+
+	if !e.expires.IsZero() && !c.clock().Before(e.expires) {
+		delete(c.pins, key)
+
+		return "", false
+	}
+
+	return e.pin, true
+}
+
+func (c *PINCache) put(card string, ref PINRef, pin string) {
+	if c == nil || c.Policy == PINCacheNone {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pins == nil {
+		c.pins = make(map[pinCacheKey]pinCacheEntry)
+	}
+
+	e := pinCacheEntry{pin: pin}
+	if c.Policy == PINCacheProcess && c.Timeout > 0 {
+		e.expires = c.clock().Add(c.Timeout)
+	}
+
+	c.pins[pinCacheKey{card: card, ref: ref}] = e
+}
+
+// forget removes a PIN, the card rejected it.
+func (c *PINCache) forget(card string, ref PINRef) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pins, pinCacheKey{card: card, ref: ref})
+}
+
+// endTransaction removes the PINs of card when they're only kept for the transaction.
+func (c *PINCache) endTransaction(card string) {
+	if c == nil || c.Policy != PINCacheTransaction {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.pins {
+		if key.card == card {
+			delete(c.pins, key)
+		}
+	}
+}
+
+// Clear removes all PINs.
+func (c *PINCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pins = nil
+}
+
+func (c *PINCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
+// pinSource is the prompt and cache of a handle, card names its entries in the cache.
+type pinSource struct {
+	card   string
+	prompt PINPromptFunc
+	cache  *PINCache
+}
+
+// pin returns the cached PIN for ref or asks for it, cached is true when it came from the cache.
+func (s pinSource) pin(ctx context.Context, ref PINRef) (pin string, cached bool, err error) {
+	if pin, ok := s.cache.get(s.card, ref); ok {
+		return pin, true, nil
+	}
+
+	if s.prompt == nil {
+		return "", false, nil
+	}
+
+	pin, err = s.prompt(ctx, ref)
+	if err != nil {
+		return "", false, fmt.Errorf("pin prompt: %w", err)
+	}
+
+	return pin, false, nil
+}
+
+// SetPINPrompt sets the prompt used when a key needs the PIN and KeyAuth has neither PIN nor
+// PINPrompt, PINs it returns are kept in cache, which may be nil.
+func (yk *YubiKey) SetPINPrompt(prompt PINPromptFunc, cache *PINCache) {
+	yk.pins.prompt = prompt
+	yk.pins.cache = cache
+}
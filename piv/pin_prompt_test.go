@@ -0,0 +1,104 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPINCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		policy PINCachePolicy
+		// after is how long after put the PIN is read, ended ends the transaction first.
+		after  time.Duration
+		ended  bool
+		cached bool
+	}{
+		{name: "none", policy: PINCacheNone},
+		{name: "transaction", policy: PINCacheTransaction, cached: true},
+		{name: "transaction ended", policy: PINCacheTransaction, ended: true},
+		{name: "process", policy: PINCacheProcess, ended: true, after: time.Minute, cached: true},
+		{name: "process expired", policy: PINCacheProcess, after: time.Hour},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewPINCache(tc.policy, 5*time.Minute)
+			c.now = func() time.Time { return now }
+
+			c.put("reader", PINRefPIV, "123456")
+
+			if tc.ended {
+				c.endTransaction("reader")
+			}
+
+			c.now = func() time.Time { return now.Add(tc.after) }
+
+			pin, ok := c.get("reader", PINRefPIV)
+			if ok != tc.cached || (ok && pin != "123456") {
+				t.Errorf("got %q %t expected cached %t", pin, ok, tc.cached)
+			}
+
+			if _, ok := c.get("other reader", PINRefPIV); ok {
+				t.Error("got a pin of another card")
+			}
+		})
+	}
+}
+
+func TestKeyAuthPIN(t *testing.T) {
+	t.Parallel()
+
+	prompts := 0
+	yk := &YubiKey{}
+	yk.SetPINPrompt(func(_ context.Context, ref PINRef) (string, error) {
+		prompts++
+		if ref != PINRefPIV {
+			return "", errors.New("unexpected ref")
+		}
+
+		return "654321", nil
+	}, NewPINCache(PINCacheProcess, 0))
+
+	if pin, _, err := (KeyAuth{PIN: "123456"}).pin(context.Background(), yk); err != nil || pin != "123456" || prompts != 0 {
+		t.Errorf("got %q %v %d prompts expected the static pin", pin, err, prompts)
+	}
+
+	pin, cached, err := (KeyAuth{}).pin(context.Background(), yk)
+	if err != nil || pin != "654321" || cached || prompts != 1 {
+		t.Errorf("got %q %t %v %d prompts expected the prompted pin", pin, cached, err, prompts)
+	}
+
+	// the pin is only cached after the card accepted it.
+	yk.pins.cache.put(yk.pins.card, PINRefPIV, pin)
+
+	pin, cached, err = (KeyAuth{}).pin(context.Background(), yk)
+	if err != nil || pin != "654321" || !cached || prompts != 1 {
+		t.Errorf("got %q %t %v %d prompts expected the cached pin", pin, cached, err, prompts)
+	}
+}
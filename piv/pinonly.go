@@ -0,0 +1,393 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PIN-only mode, as implemented by YubiKey manager, stores a random management
+// key in the PIN protected metadata object and sets a flag in the "PIVMAN"
+// object so that tools know not to ask for the management key.
+//
+// The older, deprecated, PIN-derived mode stores a salt in the "PIVMAN" object
+// instead, and the management key is derived from the PIN and the salt.
+//
+// https://github.com/Yubico/yubikey-manager/blob/main/ykman/piv.py
+
+const (
+	// pivmanFlagMgmKeyProtected marks the management key as stored PIN
+	// protected in the metadata object.
+	pivmanFlagMgmKeyProtected = 0x02
+
+	// pivmanSaltLen and pivmanIterations are the PBKDF2 parameters of the
+	// PIN-derived management key.
+	pivmanSaltLen    = 16
+	pivmanIterations = 10000
+)
+
+var (
+	// ErrPINOnlyNotEnabled is returned when the management key isn't stored
+	// PIN protected on the card.
+	ErrPINOnlyNotEnabled = errors.New("management key is not pin protected")
+)
+
+// ManagementKeyMode is how YubiKey manager and yubico-piv-tool find the management key.
+type ManagementKeyMode int
+
+const (
+	// ManagementKeyModeManual is a management key that the user provides.
+	ManagementKeyModeManual ManagementKeyMode = iota
+	// ManagementKeyModeProtected is a management key stored on the card, protected by the PIN.
+	ManagementKeyModeProtected
+	// ManagementKeyModeDerived is a management key derived from the PIN with PBKDF2.
+	ManagementKeyModeDerived
+)
+
+func (m ManagementKeyMode) String() string {
+	switch m {
+	case ManagementKeyModeManual:
+		return "manual"
+	case ManagementKeyModeProtected:
+		return "protected"
+	case ManagementKeyModeDerived:
+		return "derived"
+	default:
+		return fmt.Sprintf("ManagementKeyMode(%d)", int(m))
+	}
+}
+
+// pivmanData is the YubiKey manager data object (0x5fff00).
+type pivmanData struct {
+	// flags is nil if the object doesn't hold any flags.
+	flags *byte
+	// salt is the salt of the PIN-derived management key, nil if it isn't derived.
+	salt []byte
+	// raw holds the fields this package doesn't understand, which are
+	// preserved when the object is updated.
+	raw []byte
+}
+
+func (p *pivmanData) marshal() []byte {
+	var data []byte
+	if p.flags != nil {
+		data = append(data, 0x81, 0x01, *p.flags)
+	}
+	if p.salt != nil {
+		data = append(data, marshalASN1(0x82, p.salt)...)
+	}
+	data = append(data, p.raw...)
+	return marshalASN1(0x80, data)
+}
+
+func (p *pivmanData) unmarshal(b []byte) error {
+	var obj asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(obj.FullBytes, []byte{0x80}) {
+		return fmt.Errorf("expected tag: 0x80")
+	}
+	d := obj.Bytes
+	for len(d) > 0 {
+		var (
+			err error
+			v   asn1.RawValue
+		)
+		d, err = asn1.Unmarshal(d, &v)
+		if err != nil {
+			return fmt.Errorf("unmarshal pivman field: %v", err)
+		}
+		if bytes.HasPrefix(v.FullBytes, []byte{0x82}) {
+			p.salt = append([]byte{}, v.Bytes...)
+			continue
+		}
+		if !bytes.HasPrefix(v.FullBytes, []byte{0x81}) {
+			p.raw = append(p.raw, v.FullBytes...)
+			continue
+		}
+		if len(v.Bytes) != 1 {
+			return fmt.Errorf("invalid pivman flags length: %d", len(v.Bytes))
+		}
+		flags := v.Bytes[0]
+		p.flags = &flags
+	}
+	return nil
+}
+
+func (p *pivmanData) hasFlag(flag byte) bool {
+	return p.flags != nil && *p.flags&flag != 0
+}
+
+func (p *pivmanData) mode() ManagementKeyMode {
+	switch {
+	case p.salt != nil:
+		return ManagementKeyModeDerived
+	case p.hasFlag(pivmanFlagMgmKeyProtected):
+		return ManagementKeyModeProtected
+	default:
+		return ManagementKeyModeManual
+	}
+}
+
+func (p *pivmanData) setFlag(flag byte) {
+	var flags byte
+	if p.flags != nil {
+		flags = *p.flags
+	}
+	flags |= flag
+	p.flags = &flags
+}
+
+// pivmanObject is the object id of the YubiKey manager data.
+var pivmanObject = []byte{0x5f, 0xff, 0x00}
+
+func ykGetPivmanData(tx SCTx) (*pivmanData, error) {
+	cmd := apdu{
+		instruction: insGetData,
+		param1:      0x3f,
+		param2:      0xff,
+		data:        append([]byte{0x5c, byte(len(pivmanObject))}, pivmanObject...),
+	}
+	resp, err := tx.Transmit(cmd)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return &pivmanData{}, nil
+		}
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+	obj, _, err := unmarshalASN1(resp, 1, 0x13) // tag 0x53
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %v", err)
+	}
+	var p pivmanData
+	if err := p.unmarshal(obj); err != nil {
+		return nil, fmt.Errorf("unmarshal pivman data: %v", err)
+	}
+	return &p, nil
+}
+
+// ykSetPivmanData stores the pivman data, the management key must have been
+// authenticated on tx.
+func ykSetPivmanData(tx SCTx, p *pivmanData) error {
+	data := append([]byte{0x5c, byte(len(pivmanObject))}, pivmanObject...)
+	data = append(data, marshalASN1(0x53, p.marshal())...)
+	cmd := apdu{
+		instruction: insPutData,
+		param1:      0x3f,
+		param2:      0xff,
+		data:        data,
+	}
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
+
+// EnablePINOnly switches the YubiKey to PIN-only mode. A random management key
+// is generated, set, and stored on the card protected by the PIN. From then on
+// management operations only need the PIN, see the *WithPIN methods.
+//
+// The random management key is returned, callers may discard it.
+func (yk *YubiKey) EnablePINOnly(oldKey [24]byte, pin string) ([24]byte, error) {
+	var newKey [24]byte
+	if _, err := io.ReadFull(yk.rand, newKey[:]); err != nil {
+		return newKey, fmt.Errorf("generating management key: %v", err)
+	}
+
+	// Read the existing metadata first so other fields are preserved. This
+	// also verifies the PIN before the management key is changed.
+	m, err := yk.Metadata(pin)
+	if err != nil {
+		return newKey, fmt.Errorf("reading metadata: %w", err)
+	}
+	p, err := ykGetPivmanData(yk.tx)
+	if err != nil {
+		return newKey, fmt.Errorf("reading pivman data: %w", err)
+	}
+
+	if err := yk.SetManagementKey(oldKey, newKey); err != nil {
+		return newKey, err
+	}
+	m.ManagementKey = &newKey
+	if err := ykSetProtectedMetadata(yk.tx, yk.managementKeyAlgorithm(), newKey, m); err != nil {
+		return newKey, fmt.Errorf("storing management key: %w", err)
+	}
+	p.setFlag(pivmanFlagMgmKeyProtected)
+	if err := ykSetPivmanData(yk.tx, p); err != nil {
+		return newKey, fmt.Errorf("storing pivman data: %w", err)
+	}
+	return newKey, nil
+}
+
+// PINOnly reports whether the YubiKey is in PIN-only mode. This doesn't
+// require the PIN.
+func (yk *YubiKey) PINOnly() (bool, error) {
+	p, err := ykGetPivmanData(yk.tx)
+	if err != nil {
+		return false, err
+	}
+	return p.hasFlag(pivmanFlagMgmKeyProtected), nil
+}
+
+// PINOnlyManagementKey returns the management key stored PIN protected on
+// the card, or derived from the PIN in the PIN-derived mode.
+func (yk *YubiKey) PINOnlyManagementKey(pin string) ([24]byte, error) {
+	key, err := yk.PINManagementKey(pin)
+	if err != nil {
+		return [24]byte{}, err
+	}
+	if len(key) != 24 {
+		return [24]byte{}, fmt.Errorf("%w: %d byte key, use PINManagementKey", ErrManagementKeyLength, len(key))
+	}
+	return [24]byte(key), nil
+}
+
+// ManagementKeyMode reads the "PIVMAN" object to report whether the management
+// key is stored on the card, derived from the PIN, or neither. This doesn't
+// require the PIN.
+func (yk *YubiKey) ManagementKeyMode() (ManagementKeyMode, error) {
+	p, err := ykGetPivmanData(yk.tx)
+	if err != nil {
+		return ManagementKeyModeManual, err
+	}
+	return p.mode(), nil
+}
+
+// PINManagementKey returns the management key of either PIN mode, of any
+// algorithm. Use it with AuthenticateManagementKey.
+func (yk *YubiKey) PINManagementKey(pin string) ([]byte, error) {
+	p, err := ykGetPivmanData(yk.tx)
+	if err != nil {
+		return nil, fmt.Errorf("reading pivman data: %w", err)
+	}
+	if p.mode() == ManagementKeyModeDerived {
+		// the derived key is only checked by the authentication, verify
+		// the PIN so a wrong one doesn't look like a wrong management key.
+		if err := ykLogin(yk.tx, pin); err != nil {
+			return nil, fmt.Errorf("authenticating with pin: %w", err)
+		}
+		return derivePINManagementKey(pin, p.salt), nil
+	}
+
+	m, err := yk.Metadata(pin)
+	if err != nil {
+		return nil, err
+	}
+	if m.key == nil {
+		return nil, ErrPINOnlyNotEnabled
+	}
+	return m.key, nil
+}
+
+// derivePINManagementKey is the 3DES key of the PIN-derived mode.
+func derivePINManagementKey(pin string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(pin), salt, pivmanIterations, 24, sha1.New)
+}
+
+// EnablePINDerived switches the YubiKey to the deprecated PIN-derived mode of
+// YubiKey manager, where the management key is derived from the PIN and a
+// random salt stored on the card. Prefer EnablePINOnly, the PIN-derived mode is
+// only for cards that older tools manage. Changing the PIN must go through
+// SetPINDerived, which derives the management key again.
+//
+// The derived management key is returned.
+func (yk *YubiKey) EnablePINDerived(oldKey [24]byte, pin string) ([24]byte, error) {
+	salt := make([]byte, pivmanSaltLen)
+	if _, err := io.ReadFull(yk.rand, salt); err != nil {
+		return [24]byte{}, fmt.Errorf("generating salt: %v", err)
+	}
+	if err := ykLogin(yk.tx, pin); err != nil {
+		return [24]byte{}, fmt.Errorf("authenticating with pin: %w", err)
+	}
+	p, err := ykGetPivmanData(yk.tx)
+	if err != nil {
+		return [24]byte{}, fmt.Errorf("reading pivman data: %w", err)
+	}
+
+	newKey := [24]byte(derivePINManagementKey(pin, salt))
+	if err := yk.SetManagementKey(oldKey, newKey); err != nil {
+		return [24]byte{}, err
+	}
+	p.salt = salt
+	if err := ykSetPivmanData(yk.tx, p); err != nil {
+		return newKey, fmt.Errorf("storing pivman data: %w", err)
+	}
+	return newKey, nil
+}
+
+// SetPINDerived changes the PIN of a YubiKey in the PIN-derived mode, and the
+// management key to the one derived from the new PIN. On other YubiKeys it's
+// SetPIN.
+func (yk *YubiKey) SetPINDerived(oldPIN, newPIN string) error {
+	p, err := ykGetPivmanData(yk.tx)
+	if err != nil {
+		return fmt.Errorf("reading pivman data: %w", err)
+	}
+	if p.mode() != ManagementKeyModeDerived {
+		return yk.SetPIN(oldPIN, newPIN)
+	}
+
+	// authenticate first, so the PIN isn't changed when the management key can't be.
+	oldKey := [24]byte(derivePINManagementKey(oldPIN, p.salt))
+	if err := ykLogin(yk.tx, oldPIN); err != nil {
+		return fmt.Errorf("authenticating with pin: %w", err)
+	}
+	if err := yk.authManagementKey(oldKey); err != nil {
+		return fmt.Errorf("authenticating with derived key: %w", err)
+	}
+	if err := ykChangePIN(yk.tx, oldPIN, newPIN); err != nil {
+		return err
+	}
+	newKey := derivePINManagementKey(newPIN, p.salt)
+	return ykSetManagementKeyAlgorithm(yk.tx, yk.managementKeyAlgorithm(), newKey, false)
+}
+
+// GenerateKeyWithPIN is GenerateKey for a YubiKey in PIN-only mode.
+func (yk *YubiKey) GenerateKeyWithPIN(pin string, slot Slot, opts Key) (crypto.PublicKey, error) {
+	key, err := yk.PINOnlyManagementKey(pin)
+	if err != nil {
+		return nil, err
+	}
+	return yk.GenerateKey(key, slot, opts)
+}
+
+// SetCertificateWithPIN is SetCertificate for a YubiKey in PIN-only mode.
+func (yk *YubiKey) SetCertificateWithPIN(pin string, slot Slot, cert *x509.Certificate) error {
+	key, err := yk.PINOnlyManagementKey(pin)
+	if err != nil {
+		return err
+	}
+	return yk.SetCertificate(key, slot, cert)
+}
+
+// SetPrivateKeyInsecureWithPIN is SetPrivateKeyInsecure for a YubiKey in
+// PIN-only mode.
+func (yk *YubiKey) SetPrivateKeyInsecureWithPIN(pin string, slot Slot, private crypto.PrivateKey, policy Key) error {
+	key, err := yk.PINOnlyManagementKey(pin)
+	if err != nil {
+		return err
+	}
+	return yk.SetPrivateKeyInsecure(key, slot, private, policy)
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPivmanDataRoundTrip(t *testing.T) {
+	// Flags, followed by a salt this package doesn't interpret.
+	raw := []byte{0x80, 0x07, 0x81, 0x01, 0x01, 0x82, 0x02, 0xaa, 0xbb}
+	var p pivmanData
+	if err := p.unmarshal(raw); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if p.hasFlag(pivmanFlagMgmKeyProtected) {
+		t.Errorf("expected management key not to be protected")
+	}
+	p.setFlag(pivmanFlagMgmKeyProtected)
+	want := []byte{0x80, 0x07, 0x81, 0x01, 0x03, 0x82, 0x02, 0xaa, 0xbb}
+	if got := p.marshal(); !bytes.Equal(got, want) {
+		t.Errorf("marshal, got=0x%x, want=0x%x", got, want)
+	}
+}
+
+func TestPivmanDataEmpty(t *testing.T) {
+	var p pivmanData
+	if got, want := p.marshal(), []byte{0x80, 0x00}; !bytes.Equal(got, want) {
+		t.Errorf("marshal, got=0x%x, want=0x%x", got, want)
+	}
+	p.setFlag(pivmanFlagMgmKeyProtected)
+	if !p.hasFlag(pivmanFlagMgmKeyProtected) {
+		t.Errorf("expected flag to be set")
+	}
+}
+
+func TestPINOnly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	yk, close := newTestYubiKey(t)
+	defer close()
+	if err := yk.Reset(); err != nil {
+		t.Fatalf("resetting yubikey: %v", err)
+	}
+
+	if ok, err := yk.PINOnly(); err != nil {
+		t.Fatalf("reading pin-only mode: %v", err)
+	} else if ok {
+		t.Errorf("expected reset yubikey not to be in pin-only mode")
+	}
+	key, err := yk.EnablePINOnly(DefaultManagementKey, DefaultPIN)
+	if err != nil {
+		t.Fatalf("enabling pin-only mode: %v", err)
+	}
+	if ok, err := yk.PINOnly(); err != nil || !ok {
+		t.Errorf("expected pin-only mode, got %t, %v", ok, err)
+	}
+	got, err := yk.PINOnlyManagementKey(DefaultPIN)
+	if err != nil {
+		t.Fatalf("reading management key: %v", err)
+	}
+	if got != key {
+		t.Errorf("wanted management key=0x%x, got=0x%x", key, got)
+	}
+	if _, err := yk.GenerateKeyWithPIN(DefaultPIN, SlotAuthentication, Key{Algorithm: AlgorithmEC256}); err != nil {
+		t.Errorf("generating key with pin: %v", err)
+	}
+}
+
+func TestPivmanDataMode(t *testing.T) {
+	for _, tc := range []struct {
+		raw  []byte
+		want ManagementKeyMode
+	}{
+		{[]byte{0x80, 0x00}, ManagementKeyModeManual},
+		{[]byte{0x80, 0x03, 0x81, 0x01, 0x01}, ManagementKeyModeManual},
+		{[]byte{0x80, 0x03, 0x81, 0x01, 0x02}, ManagementKeyModeProtected},
+		{[]byte{0x80, 0x04, 0x82, 0x02, 0xaa, 0xbb}, ManagementKeyModeDerived},
+	} {
+		var p pivmanData
+		if err := p.unmarshal(tc.raw); err != nil {
+			t.Fatalf("unmarshal 0x%x: %v", tc.raw, err)
+		}
+		if got := p.mode(); got != tc.want {
+			t.Errorf("mode of 0x%x, got=%s, want=%s", tc.raw, got, tc.want)
+		}
+	}
+}
@@ -16,7 +16,6 @@ package piv
 
 import (
 	"bytes"
-	"crypto/des"
 	"crypto/rand"
 	"encoding/asn1"
 	"encoding/binary"
@@ -113,9 +112,9 @@ const (
 //
 // To release the connection, call the Close method.
 type YubiKey struct {
-	ctx *PCSCContext
-	h   *PCSCHandle
-	tx  *PCSCTx
+	ctx SCContext
+	h   SCHandle
+	tx  SCTx
 
 	rand io.Reader
 
@@ -125,6 +124,18 @@ type YubiKey struct {
 	// YubiKey's version or PIV version? A NEO reports v1.0.4. Figure this out
 	// before exposing an API.
 	version *version
+
+	// quirks holds where the card differs from a YubiKey.
+	quirks CardQuirks
+
+	// pending is closed when a command abandoned by a context has finished.
+	pending chan struct{}
+
+	// pins asks for and caches the PIN when KeyAuth doesn't have it.
+	pins pinSource
+
+	// mgmtAlg is the algorithm of the management key, 0 until it's detected.
+	mgmtAlg ManagementKeyAlgorithm
 }
 
 type GPGYubiKey struct {
@@ -133,6 +144,12 @@ type GPGYubiKey struct {
 	tx      SCTx
 	gpgData *GpgData
 	trace   bool
+
+	// mse is the key chosen for an operation with ManageSecurityEnvironment.
+	mse map[SecurityOperation]KeyType
+
+	// pending is closed when a command abandoned by a context has finished.
+	pending chan struct{}
 }
 
 func closeHandles(ctx SCContext, h SCHandle) error {
@@ -158,6 +175,7 @@ func closeHandles(ctx SCContext, h SCHandle) error {
 
 // Close releases the connection to the smart card.
 func (yk *YubiKey) Close() error {
+	yk.pins.cache.endTransaction(yk.pins.card)
 	return closeHandles(yk.ctx, yk.h)
 }
 
@@ -177,6 +195,13 @@ func Open(card string) (*YubiKey, error) {
 	return c.Open(card)
 }
 
+// OpenWithOptions connects to a YubiKey PIV smart card using opts, use ShareShared to
+// coexist with gpg-agent and scdaemon.
+func OpenWithOptions(card string, opts OpenOptions) (*YubiKey, error) {
+	var c client
+	return c.OpenWithOptions(card, opts)
+}
+
 // client is a smart card client and may be exported in the future to allow
 // configuration for the top level Open() and Cards() APIs.
 type client struct {
@@ -196,20 +221,36 @@ func (c *client) Cards() ([]string, error) {
 }
 
 func (c *client) Open(card string) (*YubiKey, error) {
+	return c.OpenWithOptions(card, OpenOptions{})
+}
+
+func (c *client) OpenWithOptions(card string, opts OpenOptions) (*YubiKey, error) {
 	ctx, err := newSCContext()
 	if err != nil {
 		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
 	}
 
-	h, err := ctx.Connect(card)
+	yk, err := openPIVOnContext(&PCSCContext{ctx: ctx}, card, opts, c.Rand)
+	if err != nil {
+		logClose(opts.Logger, "context", ctx.Close())
+		return nil, err
+	}
+	return yk, nil
+}
+
+// openPIVOnContext connects to card with an existing context and selects the PIV applet.
+// The context isn't closed on failure.
+func openPIVOnContext(ctx SCContext, card string, opts OpenOptions, r io.Reader) (*YubiKey, error) {
+	h, err := connectWithOptions(ctx, card, opts)
 	if err != nil {
-		ctx.Close()
 		return nil, fmt.Errorf("connecting to smart card: %w", err)
 	}
 	tx, err := h.Begin()
 	if err != nil {
+		h.Close()
 		return nil, fmt.Errorf("beginning smart card transaction: %w", err)
 	}
+	tx = newLogTx(tx, opts.Logger, card)
 
 	// if DebugOpen was set, set debug on the tx so we can see dumps from here out.
 	if DebugOpen {
@@ -218,26 +259,26 @@ func (c *client) Open(card string) (*YubiKey, error) {
 
 	if err := ykSelectApplication(tx, aidPIV[:]); err != nil {
 		tx.Close()
+		h.Close()
 		return nil, fmt.Errorf("selecting piv applet: %w", err)
 	}
 
 	yk := &YubiKey{
-		ctx: &PCSCContext{
-			ctx: ctx,
-		},
-		h: &PCSCHandle{
-			h: h,
-		},
-		tx: tx.(*PCSCTx),
+		ctx: ctx,
+		h:   h,
+		tx:  tx,
 	}
 	v, err := ykVersion(yk.tx)
 	if err != nil {
-		yk.Close()
+		tx.Close()
+		h.Close()
 		return nil, fmt.Errorf("getting yubikey version: %w", err)
 	}
 	yk.version = v
-	if c.Rand != nil {
-		yk.rand = c.Rand
+	yk.quirks = pivQuirksFor(card)
+	yk.pins.card = card
+	if r != nil {
+		yk.rand = r
 	} else {
 		yk.rand = rand.Reader
 	}
@@ -258,8 +299,11 @@ func (yk *YubiKey) Version() Version {
 }
 
 // Serial returns the YubiKey's serial number.
+//
+// If the PIV applet can't report the serial number, it's read from the
+// management applet or the OpenPGP application identifier instead.
 func (yk *YubiKey) Serial() (uint32, error) {
-	return ykSerial(yk.tx, yk.version)
+	return ykSerialWithFallback(yk.tx, yk.version, yk.quirks)
 }
 
 func encodePIN(pin string) ([]byte, error) {
@@ -340,6 +384,9 @@ func ykPINRetries(tx SCTx) (int, error) {
 // and resetting the PIN, PUK, and Management Key to their default values. This
 // does NOT affect data on other applets, such as GPG or U2F.
 func (yk *YubiKey) Reset() error {
+	// the reset restores the default management key, of the default algorithm.
+	yk.mgmtAlg = 0
+
 	return ykReset(yk.tx, yk.rand)
 }
 
@@ -403,13 +450,13 @@ type version struct {
 	patch byte
 }
 
-// authManagementKey attempts to authenticate against the card with the provided
-// management key. The management key is required to generate new keys or add
-// certificates to slots.
-//
-// Use DefaultManagementKey if the management key hasn't been set.
-func (yk *YubiKey) authManagementKey(key [24]byte) error {
-	return ykAuthenticate(yk.tx, key, yk.rand)
+// Version converts the raw version into the exported type.
+func (v *version) Version() Version {
+	return Version{
+		Major: int(v.major),
+		Minor: int(v.minor),
+		Patch: int(v.patch),
+	}
 }
 
 var (
@@ -422,100 +469,13 @@ var (
 	aidManagement = [...]byte{0xa0, 0x00, 0x00, 0x05, 0x27, 0x47, 0x11, 0x17}
 	aidPIV        = [...]byte{0xa0, 0x00, 0x00, 0x03, 0x08}
 	aidYubiKey    = [...]byte{0xa0, 0x00, 0x00, 0x05, 0x27, 0x20, 0x01, 0x01}
+	aidOATH       = [...]byte{0xa0, 0x00, 0x00, 0x05, 0x27, 0x21, 0x01}
 )
 
-func ykAuthenticate(tx SCTx, key [24]byte, rand io.Reader) error {
-	// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=92
-	// https://tsapps.nist.gov/publication/get_pdf.cfm?pub_id=918402#page=114
-
-	// request a witness
-	cmd := apdu{
-		instruction: insAuthenticate,
-		param1:      alg3DES,
-		param2:      keyCardManagement,
-		data: []byte{
-			0x7c, // Dynamic Authentication Template tag
-			0x02, // Length of object
-			0x80, // 'Witness'
-			0x00, // Return encrypted random
-		},
-	}
-	resp, err := tx.Transmit(cmd)
-	if err != nil {
-		return fmt.Errorf("get auth challenge: %w", err)
-	}
-	if n := len(resp); n < 12 {
-		return fmt.Errorf("challenge didn't return enough bytes: %d", n)
-	}
-	if !bytes.Equal(resp[:4], []byte{
-		0x7c,
-		0x0a,
-		0x80, // 'Witness'
-		0x08, // Tag length
-	}) {
-		return fmt.Errorf("invalid authentication object header: %x", resp[:4])
-	}
-
-	cardChallenge := resp[4 : 4+8]
-	cardResponse := make([]byte, 8)
-
-	block, err := des.NewTripleDESCipher(key[:])
-	if err != nil {
-		return fmt.Errorf("creating triple des block cipher: %v", err)
-	}
-	block.Decrypt(cardResponse, cardChallenge)
-
-	challenge := make([]byte, 8)
-	if _, err := io.ReadFull(rand, challenge); err != nil {
-		return fmt.Errorf("reading rand data: %v", err)
-	}
-	response := make([]byte, 8)
-	block.Encrypt(response, challenge)
-
-	data := []byte{
-		0x7c, // Dynamic Authentication Template tag
-		20,   // 2+8+2+8
-		0x80, // 'Witness'
-		0x08, // Tag length
-	}
-	data = append(data, cardResponse...)
-	data = append(data,
-		0x81, // 'Challenge'
-		0x08, // Tag length
-	)
-	data = append(data, challenge...)
-
-	cmd = apdu{
-		instruction: insAuthenticate,
-		param1:      alg3DES,
-		param2:      keyCardManagement,
-		data:        data,
-	}
-	resp, err = tx.Transmit(cmd)
-	if err != nil {
-		return fmt.Errorf("auth challenge: %w", err)
-	}
-	if n := len(resp); n < 12 {
-		return fmt.Errorf("challenge response didn't return enough bytes: %d", n)
-	}
-	if !bytes.Equal(resp[:4], []byte{
-		0x7c,
-		0x0a,
-		0x82, // 'Response'
-		0x08,
-	}) {
-		return fmt.Errorf("response invalid authentication object header: %x", resp[:4])
-	}
-	if !bytes.Equal(resp[4:4+8], response) {
-		return fmt.Errorf("challenge failed")
-	}
-
-	return nil
-}
-
-// SetManagementKey updates the management key to a new key. Management keys
-// are triple-des keys, however padding isn't verified. To generate a new key,
-// generate 24 random bytes.
+// SetManagementKey updates the management key to a new key of the same algorithm.
+// Management keys are triple-des keys, or AES-192 keys on YubiKeys that have them,
+// however padding isn't verified. To generate a new key, generate 24 random bytes.
+// See SetManagementKeyAlgorithm for the other AES key sizes.
 //
 //	var newKey [24]byte
 //	if _, err := io.ReadFull(rand.Reader, newKey[:]); err != nil {
@@ -525,33 +485,10 @@ func ykAuthenticate(tx SCTx, key [24]byte, rand io.Reader) error {
 //		// ...
 //	}
 func (yk *YubiKey) SetManagementKey(oldKey, newKey [24]byte) error {
-	if err := ykAuthenticate(yk.tx, oldKey, yk.rand); err != nil {
+	if err := yk.authManagementKey(oldKey); err != nil {
 		return fmt.Errorf("authenticating with old key: %w", err)
 	}
-	if err := ykSetManagementKey(yk.tx, newKey, false); err != nil {
-		return err
-	}
-	return nil
-}
-
-// ykSetManagementKey updates the management key to a new key. This requires
-// authenticating with the existing management key.
-func ykSetManagementKey(tx SCTx, key [24]byte, touch bool) error {
-	cmd := apdu{
-		instruction: insSetMGMKey,
-		param1:      0xff,
-		param2:      0xff,
-		data: append([]byte{
-			alg3DES, keyCardManagement, 24,
-		}, key[:]...),
-	}
-	if touch {
-		cmd.param2 = 0xfe
-	}
-	if _, err := tx.Transmit(cmd); err != nil {
-		return fmt.Errorf("command failed: %w", err)
-	}
-	return nil
+	return ykSetManagementKeyAlgorithm(yk.tx, yk.managementKeyAlgorithm(), newKey[:], false)
 }
 
 // SetPIN updates the PIN to a new value. For compatibility, PINs should be 1-8
@@ -652,15 +589,23 @@ func ykChangePUK(tx SCTx, oldPUK, newPUK string) error {
 }
 
 func ykSelectApplication(tx SCTx, id []byte) error {
+	_, err := ykSelectApplicationResponse(tx, id)
+	return err
+}
+
+// ykSelectApplicationResponse selects the application and returns the data
+// the application responded with, some applets return their version or status.
+func ykSelectApplicationResponse(tx SCTx, id []byte) ([]byte, error) {
 	cmd := apdu{
 		instruction: insSelectApplication,
 		param1:      0x04,
 		data:        id[:],
 	}
-	if _, err := tx.Transmit(cmd); err != nil {
-		return fmt.Errorf("command failed: %w", err)
+	resp, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
 	}
-	return nil
+	return resp, nil
 }
 
 func loadYkVersion(tx SCTx, versionInstruction byte) (*version, error) {
@@ -681,9 +626,9 @@ func ykVersion(tx SCTx) (*version, error) {
 	return loadYkVersion(tx, insGetVersion)
 }
 
-func ykSerial(tx SCTx, v *version) (uint32, error) {
+func ykSerial(tx SCTx, v *version, q CardQuirks) (uint32, error) {
 	cmd := apdu{instruction: insGetSerial}
-	if v.major < 5 {
+	if !q.NoOTPApplet && !Features(v.Version()).SupportsSerial() {
 		// Earlier versions of YubiKeys required using the yubikey applet to get
 		// the serial number. Newer ones have this built into the PIV applet.
 		if err := ykSelectApplication(tx, aidYubiKey[:]); err != nil {
@@ -760,7 +705,7 @@ func (yk *YubiKey) Metadata(pin string) (*Metadata, error) {
 // store the management key on the smart card instead of managing the PIN and
 // management key separately.
 func (yk *YubiKey) SetMetadata(key [24]byte, m *Metadata) error {
-	return ykSetProtectedMetadata(yk.tx, key, m)
+	return ykSetProtectedMetadata(yk.tx, yk.managementKeyAlgorithm(), key, m)
 }
 
 // Metadata holds protected metadata. This is primarily used by YubiKey manager
@@ -770,6 +715,9 @@ type Metadata struct {
 	// ManagementKey is the management key stored directly on the YubiKey.
 	ManagementKey *[24]byte
 
+	// key is the stored management key of any length, ManagementKey is only set for 24 byte keys.
+	key []byte
+
 	// raw, if not nil, is the full bytes
 	raw []byte
 }
@@ -845,14 +793,19 @@ func (m *Metadata) unmarshal(b []byte) error {
 		if !bytes.HasPrefix(v.FullBytes, []byte{0x89}) {
 			continue
 		}
-		// 0x89 indicates key
-		if len(v.Bytes) != 24 {
+		// 0x89 indicates key, YubiKey manager also stores AES-128 and AES-256 keys.
+		switch len(v.Bytes) {
+		case 16, 32:
+			m.key = append([]byte(nil), v.Bytes...)
+		case 24:
+			var key [24]byte
+
+			copy(key[:], v.Bytes)
+			m.ManagementKey = &key
+			m.key = key[:]
+		default:
 			return fmt.Errorf("invalid management key length: %d", len(v.Bytes))
 		}
-		var key [24]byte
-
-		copy(key[:], v.Bytes)
-		m.ManagementKey = &key
 	}
 	return nil
 }
@@ -890,7 +843,7 @@ func ykGetProtectedMetadata(tx SCTx, pin string) (*Metadata, error) {
 	return &m, nil
 }
 
-func ykSetProtectedMetadata(tx SCTx, key [24]byte, m *Metadata) error {
+func ykSetProtectedMetadata(tx SCTx, alg ManagementKeyAlgorithm, key [24]byte, m *Metadata) error {
 	data, err := m.marshal()
 	if err != nil {
 		return fmt.Errorf("encoding metadata: %v", err)
@@ -910,7 +863,7 @@ func ykSetProtectedMetadata(tx SCTx, key [24]byte, m *Metadata) error {
 	}
 	// NOTE: for some reason this action requires the management key authenticated
 	// on the same transaction. It doesn't work otherwise.
-	if err := ykAuthenticate(tx, key, rand.Reader); err != nil {
+	if err := ykAuthenticateAlgorithm(tx, alg, key[:], rand.Reader); err != nil {
 		return fmt.Errorf("authenticating with key: %w", err)
 	}
 	if _, err := tx.Transmit(cmd); err != nil {
@@ -0,0 +1,308 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pivtest emulates a YubiKey with the PIV, OpenPGP and OATH applets at the APDU level,
+// so code using piv.Client, GpgData, signing and decryption can be tested without hardware.
+//
+//	card := pivtest.NewCard(pivtest.Options{})
+//	client := pivtest.NewClient(card)
+//	yk, err := client.Open(pivtest.Reader(0))
+//
+// The card starts with the default PINs, PUK and management key and empty key slots.
+// Touch is never required and PINs are always sent in plain text.
+package pivtest
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/areese/piv-go/piv"
+)
+
+const (
+	// DefaultPIN is the PIV PIN and OpenPGP PW1 of a new card.
+	DefaultPIN = "123456"
+	// DefaultPUK is the PIV PUK of a new card.
+	DefaultPUK = "12345678"
+	// DefaultAdminPIN is the OpenPGP PW3 of a new card.
+	DefaultAdminPIN = "12345678"
+	// DefaultSerial is the serial number of cards created without one.
+	DefaultSerial = 12345678
+)
+
+// Status words returned by the emulated applets.
+// ISO/IEC 7816-4 5.6.
+const (
+	swOK                 = 0x9000
+	swWrongLength        = 0x6700
+	swSecurityStatus     = 0x6982
+	swAuthBlocked        = 0x6983
+	swConditionsNotMet   = 0x6985
+	swWrongData          = 0x6a80
+	swFileNotFound       = 0x6a82
+	swWrongParameters    = 0x6a86
+	swReferenceNotFound  = 0x6a88
+	swInsNotSupported    = 0x6d00
+	swClassNotSupported  = 0x6e00
+	swVerifyFailedPrefix = 0x63c0
+)
+
+const (
+	insSelect      = 0xa4
+	insGetResponse = 0xc0
+)
+
+// ErrShortAPDU is returned by Transmit for commands shorter than the 4 byte header.
+var ErrShortAPDU = errors.New("apdu shorter than 4 bytes")
+
+// atr is the answer to reset of a YubiKey 5 with CCID enabled.
+//
+// nolint:gochecknoglobals
+var atr = []byte{
+	0x3b, 0xfd, 0x13, 0x00, 0x00, 0x81, 0x31, 0xfe, 0x15, 0x80,
+	0x73, 0xc0, 0x21, 0xc0, 0x57, 0x59, 0x75, 0x62, 0x69, 0x4b, 0x65, 0x79, 0x40,
+}
+
+// nolint:gochecknoglobals
+var (
+	aidPIV     = []byte{0xa0, 0x00, 0x00, 0x03, 0x08}
+	aidOpenPGP = []byte{0xd2, 0x76, 0x00, 0x01, 0x24, 0x01}
+)
+
+// Options configures a Card, the zero value is a YubiKey 5.4.3 with serial DefaultSerial.
+type Options struct {
+	// Serial is the serial number, DefaultSerial if zero.
+	Serial uint32
+	// Version is the firmware version reported by both applets, 5.4.3 if zero.
+	Version piv.Version
+	// Rand is the source of randomness for keys and challenges, crypto/rand.Reader if nil.
+	Rand io.Reader
+	// SecureMessaging gives the PIV applet SM keys, which YubiKeys don't have, for the
+	// secure messaging of SP 800-73-4 Part 2.
+	SecureMessaging bool
+	// Contactless makes the card behave like it's on an NFC reader, the PIN can only be used
+	// through the virtual contact interface: secure messaging and the pairing code.
+	Contactless bool
+	// PairingCode is the pairing code of the virtual contact interface, DefaultPairingCode if empty.
+	PairingCode string
+}
+
+// Card is an emulated YubiKey, it implements piv.SoftwareCard.
+// It is safe for concurrent use, commands are handled one at a time.
+type Card struct {
+	mu       sync.Mutex
+	serial   uint32
+	version  [3]byte
+	rand     io.Reader
+	selected applet
+	chained  []byte
+
+	secureMessaging bool
+	contactless     bool
+	pairingCode     string
+
+	piv     *pivApplet
+	openPGP *openPGPApplet
+	oath    *oathApplet
+}
+
+// applet handles the commands sent to a selected application.
+type applet interface {
+	handle(cmd command) ([]byte, uint16)
+}
+
+// command is a parsed command APDU, with the data of chained commands joined.
+type command struct {
+	cla, ins, p1, p2 byte
+	data             []byte
+}
+
+var _ piv.SoftwareCard = (*Card)(nil)
+
+// NewCard returns a card in its factory state.
+func NewCard(opts Options) *Card {
+	c := &Card{
+		serial:  opts.Serial,
+		version: [3]byte{byte(opts.Version.Major), byte(opts.Version.Minor), byte(opts.Version.Patch)},
+		rand:    opts.Rand,
+
+		secureMessaging: opts.SecureMessaging || opts.Contactless,
+		contactless:     opts.Contactless,
+		pairingCode:     opts.PairingCode,
+	}
+
+	if c.serial == 0 {
+		c.serial = DefaultSerial
+	}
+
+	if c.version == [3]byte{} {
+		c.version = [3]byte{5, 4, 3}
+	}
+
+	if c.rand == nil {
+		c.rand = rand.Reader
+	}
+
+	if c.pairingCode == "" {
+		c.pairingCode = DefaultPairingCode
+	}
+
+	c.piv = newPIVApplet(c)
+	c.openPGP = newOpenPGPApplet(c)
+	c.oath = newOATHApplet(c)
+
+	return c
+}
+
+// Serial returns the serial number of the card.
+func (c *Card) Serial() uint32 {
+	return c.serial
+}
+
+// ATR returns the answer to reset of a YubiKey 5.
+func (c *Card) ATR() []byte {
+	return append([]byte{}, atr...)
+}
+
+// atLeast is true when the firmware version is major.minor or later.
+func (c *Card) atLeast(major, minor byte) bool {
+	return c.version[0] > major || c.version[0] == major && c.version[1] >= minor
+}
+
+// Transmit handles a command APDU and returns the response data followed by SW1 SW2.
+// Responses aren't split, so GET RESPONSE is never needed.
+func (c *Card) Transmit(apdu []byte) ([]byte, error) {
+	cmd, err := parseCommand(apdu)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, sw := c.transmit(cmd)
+
+	return append(data, byte(sw>>8), byte(sw)), nil
+}
+
+func (c *Card) transmit(cmd command) ([]byte, uint16) {
+	// ISO/IEC 7816-4 5.1.1.1 command chaining, the last command has the header.
+	if cmd.cla&0x10 != 0 {
+		c.chained = append(c.chained, cmd.data...)
+
+		return nil, swOK
+	}
+
+	if c.chained != nil {
+		cmd.data = append(c.chained, cmd.data...)
+		c.chained = nil
+	}
+
+	if cmd.cla == smClass && c.selected == c.piv {
+		return c.piv.handleSM(cmd)
+	}
+
+	if cmd.cla != 0x00 {
+		return nil, swClassNotSupported
+	}
+
+	if cmd.ins == insSelect && cmd.p1 == 0x04 {
+		return c.selectApplet(cmd.data)
+	}
+
+	if c.selected == nil {
+		return nil, swConditionsNotMet
+	}
+
+	return c.selected.handle(cmd)
+}
+
+// selectApplet selects the applet whose AID starts with aid, other applets aren't emulated.
+func (c *Card) selectApplet(aid []byte) ([]byte, uint16) {
+	switch {
+	case hasAIDPrefix(aid, aidPIV):
+		c.selected = c.piv
+		c.piv.selected()
+	case hasAIDPrefix(aid, aidOpenPGP):
+		c.selected = c.openPGP
+		c.openPGP.selected()
+	case hasAIDPrefix(aid, aidOATH):
+		c.selected = c.oath
+		c.oath.selected()
+
+		return c.oath.selectResponse(), swOK
+	default:
+		return nil, swFileNotFound
+	}
+
+	return nil, swOK
+}
+
+func hasAIDPrefix(aid, prefix []byte) bool {
+	return len(aid) >= len(prefix) && string(aid[:len(prefix)]) == string(prefix)
+}
+
+// parseCommand parses the short and extended APDU cases of ISO/IEC 7816-4 5.1, Le is ignored.
+func parseCommand(b []byte) (command, error) {
+	if len(b) < 4 {
+		return command{}, ErrShortAPDU
+	}
+
+	cmd := command{cla: b[0], ins: b[1], p1: b[2], p2: b[3]}
+	body := b[4:]
+
+	switch {
+	case len(body) <= 1:
+		// case 1 or case 2 short, no data.
+	case body[0] == 0x00 && len(body) >= 3:
+		// extended, 00 Lc1 Lc2 or 00 Le1 Le2.
+		n := int(body[1])<<8 | int(body[2])
+		if len(body) == 3 {
+			break
+		}
+
+		if len(body) < 3+n {
+			return command{}, fmt.Errorf("extended apdu has %d bytes of data, Lc is %d", len(body)-3, n)
+		}
+
+		cmd.data = body[3 : 3+n]
+	default:
+		n := int(body[0])
+		if len(body) < 1+n {
+			return command{}, fmt.Errorf("apdu has %d bytes of data, Lc is %d", len(body)-1, n)
+		}
+
+		cmd.data = body[1 : 1+n]
+	}
+
+	return cmd, nil
+}
+
+// Reader returns the name of the reader of the i-th card given to NewClient.
+func Reader(i int) string {
+	return fmt.Sprintf("Yubico YubiKey OTP+FIDO+CCID %02d", i)
+}
+
+// NewClient returns a client whose readers, named by Reader, have the cards.
+func NewClient(cards ...*Card) *piv.Client {
+	readers := make(map[string]piv.SoftwareCard, len(cards))
+	for i, card := range cards {
+		readers[Reader(i)] = card
+	}
+
+	return &piv.Client{SCConstruct: piv.NewSoftwareCardConstructor(readers)}
+}
@@ -0,0 +1,493 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+)
+
+func openPIV(t *testing.T, card *Card) *piv.YubiKey {
+	t.Helper()
+
+	yk, err := NewClient(card).Open(Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	return yk
+}
+
+func openOpenPGP(t *testing.T, card *Card) *piv.GPGYubiKey {
+	t.Helper()
+
+	yk, err := NewClient(card).OpenGPG(Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	return yk
+}
+
+func TestParseCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		apdu    []byte
+		data    []byte
+		wantErr bool
+	}{
+		{name: "case 1", apdu: []byte{0x00, 0xfd, 0x00, 0x00}},
+		{name: "case 2", apdu: []byte{0x00, 0xfd, 0x00, 0x00, 0x00}},
+		{name: "case 3", apdu: []byte{0x00, 0x20, 0x00, 0x80, 0x02, 0x31, 0x32}, data: []byte{0x31, 0x32}},
+		{name: "case 4", apdu: []byte{0x00, 0x20, 0x00, 0x80, 0x02, 0x31, 0x32, 0x00}, data: []byte{0x31, 0x32}},
+		{name: "extended", apdu: []byte{0x00, 0x20, 0x00, 0x80, 0x00, 0x00, 0x01, 0x31}, data: []byte{0x31}},
+		{name: "short", apdu: []byte{0x00, 0x20}, wantErr: true},
+		{name: "truncated", apdu: []byte{0x00, 0x20, 0x00, 0x80, 0x02, 0x31}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd, err := parseCommand(tc.apdu)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseCommand() error = %v, wantErr %t", err, tc.wantErr)
+			}
+
+			if !bytes.Equal(cmd.data, tc.data) {
+				t.Errorf("parseCommand() data = %x, want %x", cmd.data, tc.data)
+			}
+		})
+	}
+}
+
+func TestClientCards(t *testing.T) {
+	t.Parallel()
+
+	cards, err := NewClient(NewCard(Options{}), NewCard(Options{Serial: 2})).Cards()
+	if err != nil {
+		t.Fatalf("cards: %v", err)
+	}
+
+	if want := []string{Reader(0), Reader(1)}; len(cards) != 2 || cards[0] != want[0] || cards[1] != want[1] {
+		t.Errorf("cards = %q, want %q", cards, want)
+	}
+}
+
+func TestPIVSerialAndVersion(t *testing.T) {
+	t.Parallel()
+
+	yk := openPIV(t, NewCard(Options{Serial: 987654}))
+
+	serial, err := yk.Serial()
+	if err != nil {
+		t.Fatalf("serial: %v", err)
+	}
+
+	if serial != 987654 {
+		t.Errorf("serial = %d, want 987654", serial)
+	}
+
+	if v := yk.Version(); v != (piv.Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("version = %v, want 5.4.3", v)
+	}
+}
+
+func TestPIVGenerateAndSign(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		algorithm piv.Algorithm
+		pinPolicy piv.PINPolicy
+	}{
+		{name: "p256 pin always", algorithm: piv.AlgorithmEC256, pinPolicy: piv.PINPolicyAlways},
+		{name: "p384 pin once", algorithm: piv.AlgorithmEC384, pinPolicy: piv.PINPolicyOnce},
+		{name: "ed25519 pin never", algorithm: piv.AlgorithmEd25519, pinPolicy: piv.PINPolicyNever},
+		{name: "rsa1024", algorithm: piv.AlgorithmRSA1024, pinPolicy: piv.PINPolicyOnce},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk := openPIV(t, NewCard(Options{}))
+
+			pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotSignature, piv.Key{
+				Algorithm:   tc.algorithm,
+				PINPolicy:   tc.pinPolicy,
+				TouchPolicy: piv.TouchPolicyNever,
+			})
+			if err != nil {
+				t.Fatalf("generate key: %v", err)
+			}
+
+			priv, err := yk.PrivateKey(piv.SlotSignature, pub, piv.KeyAuth{PIN: DefaultPIN})
+			if err != nil {
+				t.Fatalf("private key: %v", err)
+			}
+
+			signer, ok := priv.(crypto.Signer)
+			if !ok {
+				t.Fatalf("%T isn't a crypto.Signer", priv)
+			}
+
+			msg := []byte("hello")
+			digest := sha256.Sum256(msg)
+
+			for i := 0; i < 2; i++ {
+				switch k := pub.(type) {
+				case *ecdsa.PublicKey:
+					sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+					if err != nil {
+						t.Fatalf("sign: %v", err)
+					}
+
+					if !ecdsa.VerifyASN1(k, digest[:], sig) {
+						t.Errorf("signature doesn't verify")
+					}
+				case *rsa.PublicKey:
+					sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+					if err != nil {
+						t.Fatalf("sign: %v", err)
+					}
+
+					if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+						t.Errorf("verify: %v", err)
+					}
+				case ed25519.PublicKey:
+					sig, err := signer.Sign(rand.Reader, msg, crypto.Hash(0))
+					if err != nil {
+						t.Fatalf("sign: %v", err)
+					}
+
+					if !ed25519.Verify(k, msg, sig) {
+						t.Errorf("signature doesn't verify")
+					}
+				default:
+					t.Fatalf("unexpected public key %T", pub)
+				}
+			}
+
+			info, err := yk.KeyInfo(piv.SlotSignature)
+			if err != nil {
+				t.Fatalf("key info: %v", err)
+			}
+
+			if info.Algorithm != tc.algorithm || info.PINPolicy != tc.pinPolicy || info.Origin != piv.OriginGenerated {
+				t.Errorf("key info = %+v", info)
+			}
+		})
+	}
+}
+
+func TestPIVPINRequired(t *testing.T) {
+	t.Parallel()
+
+	yk := openPIV(t, NewCard(Options{}))
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotAuthentication, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyOnce,
+		TouchPolicy: piv.TouchPolicyNever,
+	})
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	// without a PIN the card refuses to sign.
+	priv, err := yk.PrivateKey(piv.SlotAuthentication, pub, piv.KeyAuth{PINPolicy: piv.PINPolicyNever})
+	if err != nil {
+		t.Fatalf("private key: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("hello"))
+	if _, err := priv.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Errorf("sign without the PIN succeeded")
+	}
+}
+
+func TestPIVWrongPIN(t *testing.T) {
+	t.Parallel()
+
+	yk := openPIV(t, NewCard(Options{}))
+
+	var authErr piv.AuthErr
+	if err := yk.VerifyPIN("654321"); !errors.As(err, &authErr) || authErr.Retries != 2 {
+		t.Fatalf("verify wrong pin = %v, want 2 retries", err)
+	}
+
+	retries, err := yk.Retries()
+	if err != nil || retries != 2 {
+		t.Errorf("retries = %d, %v, want 2", retries, err)
+	}
+
+	if err := yk.VerifyPIN(DefaultPIN); err != nil {
+		t.Fatalf("verify pin: %v", err)
+	}
+}
+
+func TestPIVResetAfterBlocking(t *testing.T) {
+	t.Parallel()
+
+	yk := openPIV(t, NewCard(Options{}))
+
+	if err := yk.SetPIN(DefaultPIN, "111111"); err != nil {
+		t.Fatalf("set pin: %v", err)
+	}
+
+	if err := yk.Reset(); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+
+	if err := yk.VerifyPIN(DefaultPIN); err != nil {
+		t.Errorf("default pin after reset: %v", err)
+	}
+}
+
+func TestPIVAttest(t *testing.T) {
+	t.Parallel()
+
+	card := NewCard(Options{Serial: 424242})
+	yk := openPIV(t, card)
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotKeyManagement, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyAlways,
+		TouchPolicy: piv.TouchPolicyCached,
+	})
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	attestationCert, err := yk.AttestationCertificate()
+	if err != nil {
+		t.Fatalf("attestation certificate: %v", err)
+	}
+
+	slotCert, err := yk.Attest(piv.SlotKeyManagement)
+	if err != nil {
+		t.Fatalf("attest: %v", err)
+	}
+
+	v := piv.Verifier{Roots: card.AttestationRoots()}
+
+	a, err := v.Verify(attestationCert, slotCert)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	if a.Serial != 424242 || a.PINPolicy != piv.PINPolicyAlways || a.TouchPolicy != piv.TouchPolicyCached || a.Slot != piv.SlotKeyManagement {
+		t.Errorf("attestation = %+v", a)
+	}
+
+	if !slotCert.PublicKey.(*ecdsa.PublicKey).Equal(pub) {
+		t.Errorf("attested public key doesn't match the generated key")
+	}
+}
+
+func TestPIVCertificate(t *testing.T) {
+	t.Parallel()
+
+	yk := openPIV(t, NewCard(Options{}))
+
+	if _, err := yk.Certificate(piv.SlotAuthentication); !errors.Is(err, piv.ErrNotFound) {
+		t.Fatalf("certificate of an empty slot = %v, want ErrNotFound", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pivtest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	if err := yk.SetCertificate(piv.DefaultManagementKey, piv.SlotAuthentication, cert); err != nil {
+		t.Fatalf("set certificate: %v", err)
+	}
+
+	got, err := yk.Certificate(piv.SlotAuthentication)
+	if err != nil {
+		t.Fatalf("certificate: %v", err)
+	}
+
+	if !got.Equal(cert) {
+		t.Errorf("certificate doesn't round trip")
+	}
+}
+
+func TestOpenPGPData(t *testing.T) {
+	t.Parallel()
+
+	yk := openOpenPGP(t, NewCard(Options{Serial: 12345678}))
+
+	data, err := yk.GPGData()
+	if err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	if data.Serial != "12345678" || data.Manufacturer != "0006 (Yubico)" || data.AppletVersion != "5.4.3" {
+		t.Errorf("gpg data serial %q manufacturer %q applet version %q", data.Serial, data.Manufacturer, data.AppletVersion)
+	}
+
+	if !data.KeyImportSupported || !data.AlgorithmAttributesChangeable {
+		t.Errorf("key import %t, algorithm attributes changeable %t", data.KeyImportSupported, data.AlgorithmAttributesChangeable)
+	}
+
+	if err := yk.VerifyPIN(piv.PW3, []byte("87654321")); err == nil {
+		t.Errorf("verify with a wrong admin pin succeeded")
+	}
+}
+
+func TestOpenPGPSignAndDecrypt(t *testing.T) {
+	t.Parallel()
+
+	yk := openOpenPGP(t, NewCard(Options{}))
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	for _, slot := range []piv.KeyType{piv.SignatureKey, piv.DecryptionKey, piv.AuthenticationKey} {
+		if _, err := yk.GenerateOpenPGPKey(slot, piv.AlgorithmEC256); err != nil {
+			t.Fatalf("generate %s key: %v", slot, err)
+		}
+	}
+
+	auth := piv.OpenPGPKeyAuth{PIN: []byte(DefaultPIN)}
+	digest := sha256.Sum256([]byte("hello"))
+
+	for _, slot := range []piv.KeyType{piv.SignatureKey, piv.AuthenticationKey} {
+		priv, err := yk.OpenPGPPrivateKey(slot, auth)
+		if err != nil {
+			t.Fatalf("%s private key: %v", slot, err)
+		}
+
+		signer := priv.(crypto.Signer)
+
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatalf("%s sign: %v", slot, err)
+		}
+
+		if !ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig) {
+			t.Errorf("%s signature doesn't verify", slot)
+		}
+	}
+
+	decrypter, err := yk.OpenPGPDecrypter(auth)
+	if err != nil {
+		t.Fatalf("decrypter: %v", err)
+	}
+
+	ephemeral, secret, err := piv.ECDHKeyAgreement(rand.Reader, decrypter.Public().(*ecdh.PublicKey))
+	if err != nil {
+		t.Fatalf("key agreement: %v", err)
+	}
+
+	got, err := decrypter.Decrypt(rand.Reader, ephemeral.Bytes(), nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	if !bytes.Equal(got, secret) {
+		t.Errorf("shared secret = %x, want %x", got, secret)
+	}
+
+	data, err := yk.GPGData()
+	if err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	if count, err := data.SignatureCount(); err != nil || count != 1 {
+		t.Errorf("signature count = %d, %v, want 1", count, err)
+	}
+}
+
+func TestOpenPGPRSADecrypt(t *testing.T) {
+	t.Parallel()
+
+	yk := openOpenPGP(t, NewCard(Options{}))
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+
+	if _, err := yk.ImportOpenPGPKey(piv.DecryptionKey, key); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	decrypter, err := yk.OpenPGPDecrypter(piv.OpenPGPKeyAuth{PIN: []byte(DefaultPIN)})
+	if err != nil {
+		t.Fatalf("decrypter: %v", err)
+	}
+
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, []byte("secret"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	if string(plaintext) != "secret" {
+		t.Errorf("plaintext = %q, want secret", plaintext)
+	}
+}
@@ -0,0 +1,161 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+var errUnsupportedKey = errors.New("unsupported key")
+
+// encodePublicKey encodes the public key objects of a 7F49 template, 81 modulus and 82 exponent
+// for RSA keys, 86 point for EC keys.
+func encodePublicKey(pub crypto.PublicKey) ([]byte, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(k.E)).Bytes()
+
+		return append(marshalTLV(0x81, k.N.Bytes()), marshalTLV(0x82, e)...), nil
+	case *ecdsa.PublicKey:
+		point, err := k.ECDH()
+		if err != nil {
+			// secp256k1 has no crypto/ecdh curve.
+			return marshalTLV(0x86, elliptic.Marshal(k.Curve, k.X, k.Y)), nil // nolint:staticcheck
+		}
+
+		return marshalTLV(0x86, point.Bytes()), nil
+	case *ecdh.PublicKey:
+		return marshalTLV(0x86, k.Bytes()), nil
+	case ed25519.PublicKey:
+		return marshalTLV(0x86, k), nil
+	default:
+		return nil, fmt.Errorf("%w: %T", errUnsupportedKey, pub)
+	}
+}
+
+// publicKey returns the public key of the private keys the card holds.
+func publicKey(priv crypto.PrivateKey) crypto.PublicKey {
+	switch k := priv.(type) {
+	case crypto.Signer:
+		return k.Public()
+	case *ecdh.PrivateKey:
+		return k.PublicKey()
+	default:
+		return nil
+	}
+}
+
+// rsaRaw is the RSA private key operation without padding, the input is left padded to the modulus size.
+func rsaRaw(k *rsa.PrivateKey, data []byte) ([]byte, error) {
+	size := k.Size()
+	if len(data) != size {
+		return nil, fmt.Errorf("rsa input is %d bytes, expected %d", len(data), size)
+	}
+
+	c := new(big.Int).SetBytes(data)
+	if c.Cmp(k.N) >= 0 {
+		return nil, errors.New("rsa input out of range")
+	}
+
+	return new(big.Int).Exp(c, k.D, k.N).FillBytes(make([]byte, size)), nil
+}
+
+// ecdhSharedSecret computes the shared secret of an EC or X25519 private key with the peer point.
+func ecdhSharedSecret(priv crypto.PrivateKey, point []byte) ([]byte, error) {
+	var k *ecdh.PrivateKey
+
+	switch p := priv.(type) {
+	case *ecdsa.PrivateKey:
+		var err error
+		if k, err = p.ECDH(); err != nil {
+			return nil, err
+		}
+	case *ecdh.PrivateKey:
+		k = p
+	default:
+		return nil, fmt.Errorf("%w: %T can't do ecdh", errUnsupportedKey, priv)
+	}
+
+	peer, err := k.Curve().NewPublicKey(point)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.ECDH(peer)
+}
+
+// ecdsaKeyFromScalar builds a private key from the scalar of an imported key.
+func ecdsaKeyFromScalar(curve elliptic.Curve, d []byte) (*ecdsa.PrivateKey, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(d) != size {
+		return nil, fmt.Errorf("ec private key is %d bytes, expected %d", len(d), size)
+	}
+
+	k := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	k.Curve = curve
+	k.X, k.Y = curve.ScalarBaseMult(d)
+
+	return k, nil
+}
+
+// rsaKeyFromPrimes builds a private key from the primes of an imported key.
+func rsaKeyFromPrimes(e int, p, q []byte) (*rsa.PrivateKey, error) {
+	k := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{E: e},
+		Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+	}
+
+	k.N = new(big.Int).Mul(k.Primes[0], k.Primes[1])
+
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(k.Primes[0], one), new(big.Int).Sub(k.Primes[1], one))
+
+	k.D = new(big.Int).ModInverse(big.NewInt(int64(e)), phi)
+	if k.D == nil {
+		return nil, errors.New("rsa exponent isn't invertible")
+	}
+
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+
+	k.Precompute()
+
+	return k, nil
+}
+
+// ecdsaSignRaw signs digest and returns r || s, the signature format of the OpenPGP applet.
+func ecdsaSignRaw(r io.Reader, k *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	sr, ss, err := ecdsa.Sign(r, k, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (k.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*size)
+	sr.FillBytes(sig[:size])
+	ss.FillBytes(sig[size:])
+
+	return sig, nil
+}
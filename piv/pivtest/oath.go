@@ -0,0 +1,379 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// OATH instructions and tags.
+// https://developers.yubico.com/OATH/YKOATH_Protocol.html
+const (
+	oathInsPut          = 0x01
+	oathInsDelete       = 0x02
+	oathInsSetCode      = 0x03
+	oathInsReset        = 0x04
+	oathInsList         = 0xa1
+	oathInsCalculate    = 0xa2
+	oathInsValidate     = 0xa3
+	oathInsCalculateAll = 0xa4
+
+	oathTagName      = 0x71
+	oathTagNameList  = 0x72
+	oathTagKey       = 0x73
+	oathTagChallenge = 0x74
+	oathTagResponse  = 0x75
+	oathTagTruncated = 0x76
+	oathTagHOTP      = 0x77
+	oathTagProperty  = 0x78
+	oathTagVersion   = 0x79
+	oathTagIMF       = 0x7a
+	oathTagAlgorithm = 0x7b
+	oathTagTouch     = 0x7c
+
+	oathTypeMask  = 0xf0
+	oathTypeHOTP  = 0x10
+	oathAlgSHA256 = 0x02
+	oathAlgSHA512 = 0x03
+
+	oathPropertyTouch = 0x02
+
+	oathSaltLen      = 8
+	oathChallengeLen = 8
+)
+
+// nolint:gochecknoglobals
+var aidOATH = []byte{0xa0, 0x00, 0x00, 0x05, 0x27, 0x21, 0x01}
+
+// oathCredential is a stored credential, kind is the type and algorithm byte of PUT.
+type oathCredential struct {
+	id      []byte
+	kind    byte
+	digits  byte
+	secret  []byte
+	touch   bool
+	counter uint32
+}
+
+// oathApplet emulates the YubiKey OATH applet. Credentials requiring touch are calculated without it.
+type oathApplet struct {
+	card *Card
+
+	salt          []byte
+	key           []byte
+	challenge     []byte
+	authenticated bool
+	credentials   []*oathCredential
+}
+
+func newOATHApplet(c *Card) *oathApplet {
+	a := &oathApplet{card: c}
+	a.reset()
+
+	return a
+}
+
+// reset removes the credentials and the password and changes the salt, and so the device ID.
+func (a *oathApplet) reset() {
+	a.salt = make([]byte, oathSaltLen)
+	_, _ = io.ReadFull(a.card.rand, a.salt)
+	a.key = nil
+	a.credentials = nil
+	a.selected()
+}
+
+// selected forgets the validation and makes a new challenge when there's a password.
+func (a *oathApplet) selected() {
+	a.authenticated = a.key == nil
+	a.challenge = nil
+
+	if a.key != nil {
+		a.challenge = make([]byte, oathChallengeLen)
+		_, _ = io.ReadFull(a.card.rand, a.challenge)
+	}
+}
+
+// selectResponse has the version and the salt, and the challenge for VALIDATE when there's a password.
+func (a *oathApplet) selectResponse() []byte {
+	resp := marshalTLV(oathTagVersion, a.card.version[:])
+	resp = append(resp, marshalTLV(oathTagName, a.salt)...)
+
+	if a.challenge != nil {
+		resp = append(resp, marshalTLV(oathTagChallenge, a.challenge)...)
+		resp = append(resp, marshalTLV(oathTagAlgorithm, []byte{0x01})...)
+	}
+
+	return resp
+}
+
+func (a *oathApplet) handle(cmd command) ([]byte, uint16) {
+	switch cmd.ins {
+	case oathInsValidate:
+		return a.validate(cmd)
+	case oathInsReset:
+		if cmd.p1 != 0xde || cmd.p2 != 0xad {
+			return nil, swWrongParameters
+		}
+
+		a.reset()
+
+		return nil, swOK
+	}
+
+	if !a.authenticated {
+		return nil, swSecurityStatus
+	}
+
+	switch cmd.ins {
+	case oathInsList:
+		var resp []byte
+		for _, cred := range a.credentials {
+			resp = append(resp, marshalTLV(oathTagNameList, append([]byte{cred.kind}, cred.id...))...)
+		}
+
+		return resp, swOK
+	case oathInsPut:
+		return a.put(cmd)
+	case oathInsDelete:
+		return a.delete(cmd)
+	case oathInsSetCode:
+		return a.setCode(cmd)
+	case oathInsCalculate:
+		return a.calculate(cmd)
+	case oathInsCalculateAll:
+		return a.calculateAll(cmd)
+	default:
+		return nil, swInsNotSupported
+	}
+}
+
+// parseOATHData splits the data of a command, the property tag of PUT has a value but no length.
+func parseOATHData(b []byte) (map[byte][]byte, bool) {
+	objs := map[byte][]byte{}
+
+	for len(b) > 0 {
+		if b[0] == oathTagProperty {
+			if len(b) < 2 {
+				return nil, false
+			}
+
+			objs[oathTagProperty] = b[1:2]
+			b = b[2:]
+
+			continue
+		}
+
+		if len(b) < 2 || b[1] >= 0x80 || len(b) < 2+int(b[1]) {
+			return nil, false
+		}
+
+		objs[b[0]] = b[2 : 2+int(b[1])]
+		b = b[2+int(b[1]):]
+	}
+
+	return objs, true
+}
+
+func (a *oathApplet) find(id []byte) int {
+	for i, cred := range a.credentials {
+		if bytes.Equal(cred.id, id) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (a *oathApplet) put(cmd command) ([]byte, uint16) {
+	objs, ok := parseOATHData(cmd.data)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	id, hasID := objs[oathTagName]
+	key, hasKey := objs[oathTagKey]
+
+	if !hasID || !hasKey || len(key) < 2 {
+		return nil, swWrongData
+	}
+
+	cred := &oathCredential{
+		id:     append([]byte{}, id...),
+		kind:   key[0],
+		digits: key[1],
+		secret: append([]byte{}, key[2:]...),
+	}
+
+	if prop, ok := objs[oathTagProperty]; ok {
+		cred.touch = prop[0]&oathPropertyTouch != 0
+	}
+
+	if imf, ok := objs[oathTagIMF]; ok && len(imf) == 4 {
+		cred.counter = binary.BigEndian.Uint32(imf)
+	}
+
+	if i := a.find(cred.id); i >= 0 {
+		a.credentials[i] = cred
+	} else {
+		a.credentials = append(a.credentials, cred)
+	}
+
+	return nil, swOK
+}
+
+func (a *oathApplet) delete(cmd command) ([]byte, uint16) {
+	objs, ok := parseOATHData(cmd.data)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	i := a.find(objs[oathTagName])
+	if i < 0 {
+		return nil, swFileNotFound
+	}
+
+	a.credentials = append(a.credentials[:i], a.credentials[i+1:]...)
+
+	return nil, swOK
+}
+
+// setCode sets the password key after checking that the host can compute with it, an empty key removes it.
+func (a *oathApplet) setCode(cmd command) ([]byte, uint16) {
+	objs, ok := parseOATHData(cmd.data)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	key, ok := objs[oathTagKey]
+	if !ok {
+		return nil, swWrongData
+	}
+
+	if len(key) == 0 {
+		a.key = nil
+
+		return nil, swOK
+	}
+
+	if len(key) < 2 || !hmac.Equal(objs[oathTagResponse], oathHMAC(key[0], key[1:], objs[oathTagChallenge])) {
+		return nil, swWrongData
+	}
+
+	a.key = append([]byte{}, key[1:]...)
+
+	return nil, swOK
+}
+
+// validate checks the response of the host to the select challenge and answers the host challenge.
+func (a *oathApplet) validate(cmd command) ([]byte, uint16) {
+	objs, ok := parseOATHData(cmd.data)
+	if !ok || a.key == nil {
+		return nil, swWrongData
+	}
+
+	if !hmac.Equal(objs[oathTagResponse], oathHMAC(0x01, a.key, a.challenge)) {
+		a.authenticated = false
+
+		return nil, swWrongData
+	}
+
+	a.authenticated = true
+
+	return marshalTLV(oathTagResponse, oathHMAC(0x01, a.key, objs[oathTagChallenge])), swOK
+}
+
+func (a *oathApplet) calculate(cmd command) ([]byte, uint16) {
+	objs, ok := parseOATHData(cmd.data)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	i := a.find(objs[oathTagName])
+	if i < 0 {
+		return nil, swFileNotFound
+	}
+
+	return a.response(a.credentials[i], objs[oathTagChallenge], cmd.p2 == 0x01), swOK
+}
+
+// calculateAll answers with the code of each TOTP credential, HOTP and touch credentials only have their digits.
+func (a *oathApplet) calculateAll(cmd command) ([]byte, uint16) {
+	objs, ok := parseOATHData(cmd.data)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	var resp []byte
+
+	for _, cred := range a.credentials {
+		resp = append(resp, marshalTLV(oathTagName, cred.id)...)
+
+		switch {
+		case cred.kind&oathTypeMask == oathTypeHOTP:
+			resp = append(resp, marshalTLV(oathTagHOTP, []byte{cred.digits})...)
+		case cred.touch:
+			resp = append(resp, marshalTLV(oathTagTouch, []byte{cred.digits})...)
+		default:
+			resp = append(resp, a.response(cred, objs[oathTagChallenge], cmd.p2 == 0x01)...)
+		}
+	}
+
+	return resp, swOK
+}
+
+// response computes the code of cred, the challenge of HOTP credentials is their counter.
+func (a *oathApplet) response(cred *oathCredential, challenge []byte, truncate bool) []byte {
+	if cred.kind&oathTypeMask == oathTypeHOTP {
+		challenge = binary.BigEndian.AppendUint64(nil, uint64(cred.counter))
+		cred.counter++
+	}
+
+	mac := oathHMAC(cred.kind, cred.secret, challenge)
+	if !truncate {
+		return marshalTLV(oathTagResponse, append([]byte{cred.digits}, mac...))
+	}
+
+	// RFC 4226 5.3 dynamic truncation.
+	offset := mac[len(mac)-1] & 0x0f
+	code := append([]byte{cred.digits}, mac[offset:offset+4]...)
+	code[1] &= 0x7f
+
+	return marshalTLV(oathTagTruncated, code)
+}
+
+func oathHMAC(kind byte, key, message []byte) []byte {
+	var h func() hash.Hash
+
+	switch kind & 0x0f {
+	case oathAlgSHA256:
+		h = sha256.New
+	case oathAlgSHA512:
+		h = sha512.New
+	default:
+		h = sha1.New
+	}
+
+	mac := hmac.New(h, key)
+	mac.Write(message)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,951 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/ProtonMail/go-crypto/bitcurves"
+	"github.com/areese/piv-go/piv"
+)
+
+// OpenPGP instructions.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 49
+// 7.1 Usage of ISO Standard Commands.
+const (
+	pgpInsVerify               = 0x20
+	pgpInsChangeReference      = 0x24
+	pgpInsResetRetryCounter    = 0x2c
+	pgpInsPSO                  = 0x2a
+	pgpInsGenerate             = 0x47
+	pgpInsInternalAuthenticate = 0x88
+	pgpInsGetData              = 0xca
+	pgpInsSelectData           = 0xa5
+	pgpInsMSE                  = 0x22
+	pgpInsPutData              = 0xda
+	pgpInsPutDataOdd           = 0xdb
+	pgpInsActivate             = 0x44
+	pgpInsTerminate            = 0xe6
+	pgpInsGetAppletVersion     = 0xf1
+)
+
+// password references of VERIFY and the PW status bytes, RC is the resetting code.
+const (
+	pgpPW1Sign    = 0x81
+	pgpPW1Decrypt = 0x82
+	pgpPW3        = 0x83
+	pgpRC         = 0x84
+
+	pgpDefaultRetries = 3
+	pgpMinPW1Length   = 6
+	pgpMinPW3Length   = 8
+	pgpMaxPINLength   = 0x7f
+)
+
+const (
+	pgpAlgorithmRSA   = 1
+	pgpAlgorithmECDH  = 18
+	pgpAlgorithmECDSA = 19
+	pgpAlgorithmEdDSA = 22
+
+	pgpOriginGenerated = 0x01
+	pgpOriginImported  = 0x02
+	pgpUIFOff          = 0x00
+	pgpUIFButton       = 0x20
+)
+
+// pgpExtendedCapabilities announces key import, a changeable PW status, private DOs,
+// changeable algorithm attributes, 2048 byte cardholder certificates and MSE, without secure messaging, GET CHALLENGE or KDF.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 32
+// 4.4.3.7 Extended Capabilities.
+//
+// nolint:gochecknoglobals
+var pgpExtendedCapabilities = []byte{0x3c, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0xff, 0x00, 0x01}
+
+// pgpHistoricalBytes announce command chaining without extended Lc and Le.
+//
+// nolint:gochecknoglobals
+var pgpHistoricalBytes = []byte{0x00, 0x73, 0x00, 0x00, 0x80, 0x05, 0x90, 0x00}
+
+// nolint:gochecknoglobals
+var (
+	oidP256    = []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	oidP384    = []byte{0x2b, 0x81, 0x04, 0x00, 0x22}
+	oidK256    = []byte{0x2b, 0x81, 0x04, 0x00, 0x0a}
+	oidEd25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	oidX25519  = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+)
+
+var errUnsupportedAttributes = errors.New("unsupported algorithm attributes")
+
+// openPGPKey is the key of a slot with its algorithm attributes.
+type openPGPKey struct {
+	attributes []byte
+	origin     byte
+	priv       crypto.PrivateKey
+}
+
+// openPGPApplet emulates the YubiKey OpenPGP applet.
+// Data objects without behaviour, like fingerprints, dates and the cardholder name, are stored as written.
+type openPGPApplet struct {
+	card *Card
+
+	pw1, pw3, rc           []byte
+	pw1Retries, pw3Retries int
+	rcRetries              int
+	verified               map[byte]bool
+	keys                   [3]openPGPKey
+	objects                map[uint16][]byte
+	// certificates are the 7F21 occurrences, SELECT DATA picks one, 0 is the authentication key.
+	certificates [3][]byte
+	occurrence   int
+	// decipherSlot and authenticateSlot are the keys MSE chose for PSO:DECIPHER and INTERNAL AUTHENTICATE.
+	decipherSlot, authenticateSlot int
+	signatures                     int
+	terminated                     bool
+}
+
+func newOpenPGPApplet(c *Card) *openPGPApplet {
+	a := &openPGPApplet{card: c}
+	a.reset()
+
+	return a
+}
+
+// reset restores the factory state with RSA 2048 attributes in every slot.
+func (a *openPGPApplet) reset() {
+	a.pw1 = []byte(DefaultPIN)
+	a.pw3 = []byte(DefaultAdminPIN)
+	a.rc = nil
+	a.pw1Retries, a.pw3Retries, a.rcRetries = pgpDefaultRetries, pgpDefaultRetries, 0
+	a.signatures = 0
+	a.terminated = false
+	a.certificates = [3][]byte{}
+
+	for i := range a.keys {
+		a.keys[i] = openPGPKey{attributes: piv.RSAAlgorithmAttributes(2048).Bytes()}
+	}
+
+	a.objects = map[uint16][]byte{
+		0x5b:   {},
+		0x5f2d: {},
+		0x5f35: {},
+		// PW1 is only valid for one signature.
+		0xc4: {0x00},
+	}
+
+	for i := uint16(0); i < 3; i++ {
+		a.objects[0xc7+i] = make([]byte, 20)
+		a.objects[0xca+i] = make([]byte, 20)
+		a.objects[0xce+i] = make([]byte, 4)
+		a.objects[0xd6+i] = []byte{pgpUIFOff, pgpUIFButton}
+	}
+
+	a.selected()
+}
+
+func (a *openPGPApplet) selected() {
+	a.verified = map[byte]bool{}
+	a.occurrence = 0
+	a.decipherSlot, a.authenticateSlot = 1, 2
+}
+
+func (a *openPGPApplet) handle(cmd command) ([]byte, uint16) {
+	if a.terminated {
+		if cmd.ins != pgpInsActivate {
+			return nil, swConditionsNotMet
+		}
+
+		a.reset()
+
+		return nil, swOK
+	}
+
+	switch cmd.ins {
+	case pgpInsGetAppletVersion:
+		return a.card.version[:], swOK
+	case pgpInsGetData:
+		return a.getData(uint16(cmd.p1)<<8 | uint16(cmd.p2))
+	case pgpInsPutData:
+		return a.putData(uint16(cmd.p1)<<8|uint16(cmd.p2), cmd.data)
+	case pgpInsPutDataOdd:
+		return a.importKey(cmd)
+	case pgpInsSelectData:
+		return a.selectData(cmd)
+	case pgpInsMSE:
+		return a.manageSecurityEnvironment(cmd)
+	case pgpInsVerify:
+		return a.verify(cmd)
+	case pgpInsChangeReference:
+		return a.changeReference(cmd)
+	case pgpInsResetRetryCounter:
+		return a.resetRetryCounter(cmd)
+	case pgpInsGenerate:
+		return a.generate(cmd)
+	case pgpInsPSO:
+		return a.performSecurityOperation(cmd)
+	case pgpInsInternalAuthenticate:
+		return a.internalAuthenticate(cmd)
+	case pgpInsTerminate:
+		if !a.verified[pgpPW3] && a.pw3Retries > 0 {
+			return nil, swSecurityStatus
+		}
+
+		a.terminated = true
+
+		return nil, swOK
+	case pgpInsActivate:
+		return nil, swOK
+	default:
+		return nil, swInsNotSupported
+	}
+}
+
+// aid is the application identifier with the serial number as BCD, like YubiKeys.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 19
+// 4.2.1 Application Identifier (AID).
+func (a *openPGPApplet) aid() []byte {
+	aid := append([]byte{}, aidOpenPGP...)
+	aid = append(aid, 0x03, 0x04, 0x00, 0x06)
+
+	digits := fmt.Sprintf("%08d", a.card.serial%100000000)
+	for i := 0; i < len(digits); i += 2 {
+		aid = append(aid, (digits[i]-'0')<<4|(digits[i+1]-'0'))
+	}
+
+	return append(aid, 0x00, 0x00)
+}
+
+// pwStatus is the C4 PW status bytes.
+func (a *openPGPApplet) pwStatus() []byte {
+	return []byte{a.objects[0xc4][0], pgpMaxPINLength, pgpMaxPINLength, pgpMaxPINLength,
+		byte(a.pw1Retries), byte(a.rcRetries), byte(a.pw3Retries)}
+}
+
+// applicationRelatedData is the 6E template.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 23
+// 4.4.1 DOs for GET DATA.
+func (a *openPGPApplet) applicationRelatedData() []byte {
+	var fingerprints, caFingerprints, dates, origins []byte
+
+	discretionary := marshalTLV(0xc0, pgpExtendedCapabilities)
+
+	for i := uint16(0); i < 3; i++ {
+		discretionary = append(discretionary, marshalTLV(0xc1+i, a.keys[i].attributes)...)
+		fingerprints = append(fingerprints, a.objects[0xc7+i]...)
+		caFingerprints = append(caFingerprints, a.objects[0xca+i]...)
+		dates = append(dates, a.objects[0xce+i]...)
+		origins = append(origins, byte(i+1), a.keys[i].origin)
+	}
+
+	discretionary = append(discretionary, marshalTLV(0xc4, a.pwStatus())...)
+	discretionary = append(discretionary, marshalTLV(0xc5, fingerprints)...)
+	discretionary = append(discretionary, marshalTLV(0xc6, caFingerprints)...)
+	discretionary = append(discretionary, marshalTLV(0xcd, dates)...)
+	discretionary = append(discretionary, marshalTLV(0xde, origins)...)
+
+	for i := uint16(0); i < 3; i++ {
+		discretionary = append(discretionary, marshalTLV(0xd6+i, a.objects[0xd6+i])...)
+	}
+
+	b := marshalTLV(0x4f, a.aid())
+	b = append(b, marshalTLV(0x5f52, pgpHistoricalBytes)...)
+	b = append(b, marshalTLV(0x73, discretionary)...)
+
+	return marshalTLV(0x6e, b)
+}
+
+func (a *openPGPApplet) getData(tag uint16) ([]byte, uint16) {
+	switch tag {
+	case 0x4f:
+		return a.aid(), swOK
+	case 0x5f52:
+		return pgpHistoricalBytes, swOK
+	case 0x65:
+		b := marshalTLV(0x5b, a.objects[0x5b])
+		b = append(b, marshalTLV(0x5f2d, a.objects[0x5f2d])...)
+		b = append(b, marshalTLV(0x5f35, a.objects[0x5f35])...)
+
+		return marshalTLV(0x65, b), swOK
+	case 0x6e:
+		return a.applicationRelatedData(), swOK
+	case 0x7a:
+		counter := []byte{byte(a.signatures >> 16), byte(a.signatures >> 8), byte(a.signatures)}
+
+		return marshalTLV(0x7a, marshalTLV(0x93, counter)), swOK
+	case 0xc4:
+		return a.pwStatus(), swOK
+	case 0x7f21:
+		return append([]byte{}, a.certificates[a.occurrence]...), swOK
+	case 0x0103:
+		if !a.verified[pgpPW1Decrypt] {
+			return nil, swSecurityStatus
+		}
+	case 0x0104:
+		if !a.verified[pgpPW3] {
+			return nil, swSecurityStatus
+		}
+	case 0x5e, 0x5f50, 0x0101, 0x0102, 0xd6, 0xd7, 0xd8:
+	default:
+		return nil, swReferenceNotFound
+	}
+
+	value, ok := a.objects[tag]
+	if !ok {
+		return nil, swOK
+	}
+
+	return append([]byte{}, value...), swOK
+}
+
+// putData writes a simple data object, 0101 and 0103 need PW1 and the others PW3.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 28
+// 4.4.2 DOs for PUT DATA.
+func (a *openPGPApplet) putData(tag uint16, data []byte) ([]byte, uint16) {
+	needs := byte(pgpPW3)
+	if tag == 0x0101 || tag == 0x0103 {
+		needs = pgpPW1Decrypt
+	}
+
+	if !a.verified[needs] {
+		return nil, swSecurityStatus
+	}
+
+	switch {
+	case tag >= 0xc1 && tag <= 0xc3:
+		if _, err := generateOpenPGPKey(a.card, data, true); err != nil {
+			return nil, swWrongData
+		}
+
+		// changing the algorithm removes the key.
+		a.keys[tag-0xc1] = openPGPKey{attributes: append([]byte{}, data...)}
+	case tag == 0xc4:
+		if len(data) != 1 || data[0] > 1 {
+			return nil, swWrongData
+		}
+
+		a.objects[tag] = []byte{data[0]}
+	case tag >= 0xc7 && tag <= 0xcc:
+		if len(data) != 20 {
+			return nil, swWrongLength
+		}
+
+		a.objects[tag] = append([]byte{}, data...)
+	case tag >= 0xce && tag <= 0xd0:
+		if len(data) != 4 {
+			return nil, swWrongLength
+		}
+
+		a.objects[tag] = append([]byte{}, data...)
+	case tag >= 0xd6 && tag <= 0xd8:
+		if len(data) != 2 {
+			return nil, swWrongLength
+		}
+
+		a.objects[tag] = append([]byte{}, data...)
+	case tag == 0xd3:
+		if len(data) != 0 && len(data) < pgpMinPW1Length {
+			return nil, swWrongLength
+		}
+
+		a.rc = append([]byte{}, data...)
+		a.rcRetries = 0
+
+		if len(data) > 0 {
+			a.rcRetries = pgpDefaultRetries
+		}
+	case tag == 0x7f21:
+		if len(data) > 2048 {
+			return nil, swWrongLength
+		}
+
+		a.certificates[a.occurrence] = append([]byte{}, data...)
+	case tag == 0x5b, tag == 0x5e, tag == 0x5f2d, tag == 0x5f35, tag == 0x5f50,
+		tag >= 0x0101 && tag <= 0x0104:
+		a.objects[tag] = append([]byte{}, data...)
+	default:
+		return nil, swReferenceNotFound
+	}
+
+	return nil, swOK
+}
+
+// selectData picks the occurrence of 7F21 for GET and PUT DATA, the tag list may have the extra
+// length byte of YubiKeys up to 5.4.3.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 57
+// 7.2.5 SELECT DATA.
+func (a *openPGPApplet) selectData(cmd command) ([]byte, uint16) {
+	data := cmd.data
+	if len(data) > 0 && data[0] != 0x60 {
+		data = data[1:]
+	}
+
+	if cmd.p2 != 0x04 || !bytes.Equal(data, []byte{0x60, 0x04, 0x5c, 0x02, 0x7f, 0x21}) {
+		return nil, swWrongData
+	}
+
+	if int(cmd.p1) >= len(a.certificates) {
+		return nil, swReferenceNotFound
+	}
+
+	a.occurrence = int(cmd.p1)
+
+	return nil, swOK
+}
+
+// manageSecurityEnvironment sets the key of B8 (decipher) or A4 (authenticate) to 83 01 02 or 83 01 03.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 79
+// 7.2.18 MANAGE SECURITY ENVIRONMENT.
+func (a *openPGPApplet) manageSecurityEnvironment(cmd command) ([]byte, uint16) {
+	if cmd.p1 != 0x41 || len(cmd.data) != 3 || cmd.data[0] != 0x83 || cmd.data[1] != 0x01 {
+		return nil, swWrongData
+	}
+
+	if cmd.data[2] != 0x02 && cmd.data[2] != 0x03 {
+		return nil, swReferenceNotFound
+	}
+
+	slot := int(cmd.data[2]) - 1
+
+	switch cmd.p2 {
+	case 0xb8:
+		a.decipherSlot = slot
+	case 0xa4:
+		a.authenticateSlot = slot
+	default:
+		return nil, swWrongParameters
+	}
+
+	return nil, swOK
+}
+
+// reference returns the stored password and retry counter of a VERIFY reference, PW1 has two modes.
+func (a *openPGPApplet) reference(ref byte) (*[]byte, *int, bool) {
+	switch ref {
+	case pgpPW1Sign, pgpPW1Decrypt:
+		return &a.pw1, &a.pw1Retries, true
+	case pgpPW3:
+		return &a.pw3, &a.pw3Retries, true
+	case pgpRC:
+		return &a.rc, &a.rcRetries, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// check compares pin with the password of ref and counts the retries.
+func (a *openPGPApplet) check(ref byte, pin []byte) uint16 {
+	value, retries, ok := a.reference(ref)
+	if !ok {
+		return swReferenceNotFound
+	}
+
+	if *retries == 0 {
+		return swAuthBlocked
+	}
+
+	if !bytes.Equal(pin, *value) {
+		*retries--
+
+		return verifyFailed(*retries)
+	}
+
+	*retries = pgpDefaultRetries
+
+	return swOK
+}
+
+// verify presents a password, without data it reports the status and P1 FF resets it.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 52
+// 7.2.2 VERIFY.
+func (a *openPGPApplet) verify(cmd command) ([]byte, uint16) {
+	_, retries, ok := a.reference(cmd.p2)
+	if !ok || cmd.p2 == pgpRC {
+		return nil, swReferenceNotFound
+	}
+
+	switch {
+	case cmd.p1 == 0xff:
+		a.verified[cmd.p2] = false
+
+		return nil, swOK
+	case len(cmd.data) == 0:
+		if a.verified[cmd.p2] {
+			return nil, swOK
+		}
+
+		return nil, verifyFailed(*retries)
+	}
+
+	a.verified[cmd.p2] = false
+
+	if sw := a.check(cmd.p2, cmd.data); sw != swOK {
+		return nil, sw
+	}
+
+	a.verified[cmd.p2] = true
+
+	return nil, swOK
+}
+
+// changeReference sets a new PW1 or PW3, the data is the old password followed by the new one.
+func (a *openPGPApplet) changeReference(cmd command) ([]byte, uint16) {
+	if cmd.p2 != pgpPW1Sign && cmd.p2 != pgpPW3 {
+		return nil, swReferenceNotFound
+	}
+
+	value, _, _ := a.reference(cmd.p2)
+
+	n := len(*value)
+	if len(cmd.data) < n {
+		return nil, a.check(cmd.p2, cmd.data)
+	}
+
+	newPIN := cmd.data[n:]
+
+	minLength := pgpMinPW1Length
+	if cmd.p2 == pgpPW3 {
+		minLength = pgpMinPW3Length
+	}
+
+	if len(newPIN) < minLength || len(newPIN) > pgpMaxPINLength {
+		return nil, swWrongLength
+	}
+
+	if sw := a.check(cmd.p2, cmd.data[:n]); sw != swOK {
+		return nil, sw
+	}
+
+	*value = append([]byte{}, newPIN...)
+
+	return nil, swOK
+}
+
+// resetRetryCounter sets a new PW1 after PW3 (P1 02) or with the resetting code (P1 00).
+func (a *openPGPApplet) resetRetryCounter(cmd command) ([]byte, uint16) {
+	if cmd.p2 != pgpPW1Sign {
+		return nil, swReferenceNotFound
+	}
+
+	newPIN := cmd.data
+
+	switch cmd.p1 {
+	case 0x00:
+		if len(a.rc) == 0 || len(cmd.data) < len(a.rc) {
+			return nil, verifyFailed(a.rcRetries)
+		}
+
+		if sw := a.check(pgpRC, cmd.data[:len(a.rc)]); sw != swOK {
+			return nil, sw
+		}
+
+		newPIN = cmd.data[len(a.rc):]
+	case 0x02:
+		if !a.verified[pgpPW3] {
+			return nil, swSecurityStatus
+		}
+	default:
+		return nil, swWrongParameters
+	}
+
+	if len(newPIN) < pgpMinPW1Length || len(newPIN) > pgpMaxPINLength {
+		return nil, swWrongLength
+	}
+
+	a.pw1 = append([]byte{}, newPIN...)
+	a.pw1Retries = pgpDefaultRetries
+
+	return nil, swOK
+}
+
+// keySlot returns the slot of a control reference template, B6 signature, B8 decryption, A4 authentication.
+func keySlot(crt byte) (int, bool) {
+	switch crt {
+	case 0xb6:
+		return 0, true
+	case 0xb8:
+		return 1, true
+	case 0xa4:
+		return 2, true
+	default:
+		return 0, false
+	}
+}
+
+// generate generates (P1 80, PW3) or reads (P1 81) the public key of a slot.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 74
+// 7.2.14 GENERATE ASYMMETRIC KEY PAIR.
+func (a *openPGPApplet) generate(cmd command) ([]byte, uint16) {
+	if len(cmd.data) == 0 {
+		return nil, swWrongData
+	}
+
+	slot, ok := keySlot(cmd.data[0])
+	if !ok {
+		return nil, swWrongData
+	}
+
+	key := &a.keys[slot]
+
+	switch cmd.p1 {
+	case 0x80:
+		if !a.verified[pgpPW3] {
+			return nil, swSecurityStatus
+		}
+
+		priv, err := generateOpenPGPKey(a.card, key.attributes, false)
+		if err != nil {
+			return nil, swWrongData
+		}
+
+		key.priv = priv
+		key.origin = pgpOriginGenerated
+
+		if slot == 0 {
+			a.signatures = 0
+		}
+	case 0x81:
+		if key.priv == nil {
+			return nil, swReferenceNotFound
+		}
+	default:
+		return nil, swWrongParameters
+	}
+
+	pub, err := encodePublicKey(publicKey(key.priv))
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	return marshalTLV(0x7f49, pub), swOK
+}
+
+// generateOpenPGPKey generates a key for the algorithm attributes, or only checks them when validate is set.
+func generateOpenPGPKey(c *Card, attributes []byte, validate bool) (crypto.PrivateKey, error) {
+	attrs, err := piv.ParseAlgorithmAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case attrs.Algorithm == pgpAlgorithmRSA && (attrs.Bits == 1024 || attrs.Bits == 2048 || attrs.Bits == 3072 || attrs.Bits == 4096):
+		if validate {
+			return nil, nil
+		}
+
+		return rsa.GenerateKey(c.rand, attrs.Bits)
+	case attrs.Algorithm == pgpAlgorithmEdDSA && bytes.Equal(attrs.OID, oidEd25519):
+		if validate {
+			return nil, nil
+		}
+
+		_, priv, err := ed25519.GenerateKey(c.rand)
+
+		return priv, err
+	case attrs.Algorithm == pgpAlgorithmECDH && bytes.Equal(attrs.OID, oidX25519):
+		if validate {
+			return nil, nil
+		}
+
+		return ecdh.X25519().GenerateKey(c.rand)
+	case attrs.Algorithm == pgpAlgorithmECDH || attrs.Algorithm == pgpAlgorithmECDSA:
+		curve, err := openPGPCurve(attrs.OID)
+		if err != nil || validate {
+			return nil, err
+		}
+
+		return ecdsa.GenerateKey(curve, c.rand)
+	default:
+		return nil, fmt.Errorf("%w: %X", errUnsupportedAttributes, attributes)
+	}
+}
+
+func openPGPCurve(oid []byte) (elliptic.Curve, error) {
+	switch {
+	case bytes.Equal(oid, oidP256):
+		return elliptic.P256(), nil
+	case bytes.Equal(oid, oidP384):
+		return elliptic.P384(), nil
+	case bytes.Equal(oid, oidK256):
+		return bitcurves.S256(), nil
+	default:
+		return nil, fmt.Errorf("%w: curve %X", errUnsupportedAttributes, oid)
+	}
+}
+
+// importKey imports a private key with the extended header list, 4D with the CRT of the slot,
+// 7F48 with the tags and lengths of the key parts and 5F48 with their values.
+// RSA keys are 91 e, 92 p and 93 q, EC keys 92 the private scalar.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 39
+// 4.4.3.12 Private Key Template.
+func (a *openPGPApplet) importKey(cmd command) ([]byte, uint16) {
+	if !a.verified[pgpPW3] {
+		return nil, swSecurityStatus
+	}
+
+	if cmd.p1 != 0x3f || cmd.p2 != 0xff {
+		return nil, swWrongParameters
+	}
+
+	objs, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	header, ok := findTLV(objs, 0x4d)
+	if !ok || len(header) == 0 {
+		return nil, swWrongData
+	}
+
+	slot, ok := keySlot(header[0])
+	if !ok {
+		return nil, swWrongData
+	}
+
+	if objs, err = parseTLVs(header); err != nil {
+		return nil, swWrongData
+	}
+
+	template, _ := findTLV(objs, 0x7f48)
+	values, _ := findTLV(objs, 0x5f48)
+
+	parts, err := splitKeyParts(template, values)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	key := &a.keys[slot]
+
+	priv, err := openPGPPrivateKey(key.attributes, parts)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	key.priv = priv
+	key.origin = pgpOriginImported
+
+	return nil, swOK
+}
+
+// splitKeyParts splits the values of 5F48 with the tags and lengths of 7F48.
+func splitKeyParts(template, values []byte) (map[byte][]byte, error) {
+	parts := map[byte][]byte{}
+
+	for len(template) > 0 {
+		if len(template) < 2 {
+			return nil, errTLV
+		}
+
+		tag, n := template[0], int(template[1])
+		template = template[2:]
+
+		if n&0x80 != 0 {
+			size := n & 0x7f
+			if size == 0 || size > 2 || len(template) < size {
+				return nil, errTLV
+			}
+
+			n = 0
+			for _, l := range template[:size] {
+				n = n<<8 | int(l)
+			}
+
+			template = template[size:]
+		}
+
+		if len(values) < n {
+			return nil, errTLV
+		}
+
+		parts[tag], values = values[:n], values[n:]
+	}
+
+	return parts, nil
+}
+
+func openPGPPrivateKey(attributes []byte, parts map[byte][]byte) (crypto.PrivateKey, error) {
+	attrs, err := piv.ParseAlgorithmAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case attrs.Algorithm == pgpAlgorithmRSA:
+		e := 0
+		for _, b := range parts[0x91] {
+			e = e<<8 | int(b)
+		}
+
+		k, err := rsaKeyFromPrimes(e, parts[0x92], parts[0x93])
+		if err != nil {
+			return nil, err
+		}
+
+		if k.N.BitLen() != attrs.Bits {
+			return nil, fmt.Errorf("%w: %d bit key for %d bit attributes", errUnsupportedAttributes, k.N.BitLen(), attrs.Bits)
+		}
+
+		return k, nil
+	case attrs.Algorithm == pgpAlgorithmEdDSA && bytes.Equal(attrs.OID, oidEd25519):
+		if len(parts[0x92]) != ed25519.SeedSize {
+			return nil, errUnsupportedKey
+		}
+
+		return ed25519.NewKeyFromSeed(parts[0x92]), nil
+	case attrs.Algorithm == pgpAlgorithmECDH && bytes.Equal(attrs.OID, oidX25519):
+		return ecdh.X25519().NewPrivateKey(parts[0x92])
+	default:
+		curve, err := openPGPCurve(attrs.OID)
+		if err != nil {
+			return nil, err
+		}
+
+		return ecdsaKeyFromScalar(curve, parts[0x92])
+	}
+}
+
+// performSecurityOperation is PSO: COMPUTE DIGITAL SIGNATURE (9E 9A) and PSO: DECIPHER (80 86).
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 63
+// 7.2.10 PSO: COMPUTE DIGITAL SIGNATURE.
+func (a *openPGPApplet) performSecurityOperation(cmd command) ([]byte, uint16) {
+	switch {
+	case cmd.p1 == 0x9e && cmd.p2 == 0x9a:
+		if !a.verified[pgpPW1Sign] {
+			return nil, swSecurityStatus
+		}
+
+		sig, sw := a.sign(0, cmd.data)
+		if sw != swOK {
+			return nil, sw
+		}
+
+		// PW1 is valid for one signature unless C4 says otherwise.
+		if a.objects[0xc4][0] == 0x00 {
+			a.verified[pgpPW1Sign] = false
+		}
+
+		a.signatures++
+
+		return sig, swOK
+	case cmd.p1 == 0x80 && cmd.p2 == 0x86:
+		if !a.verified[pgpPW1Decrypt] {
+			return nil, swSecurityStatus
+		}
+
+		return a.decipher(cmd.data)
+	default:
+		return nil, swWrongParameters
+	}
+}
+
+func (a *openPGPApplet) internalAuthenticate(cmd command) ([]byte, uint16) {
+	if !a.verified[pgpPW1Decrypt] {
+		return nil, swSecurityStatus
+	}
+
+	return a.sign(a.authenticateSlot, cmd.data)
+}
+
+// sign signs with the key of slot, the card adds the PKCS#1 padding to the DigestInfo of RSA keys
+// and returns r || s for ECDSA keys.
+func (a *openPGPApplet) sign(slot int, data []byte) ([]byte, uint16) {
+	var (
+		sig []byte
+		err error
+	)
+
+	switch k := a.keys[slot].priv.(type) {
+	case nil:
+		return nil, swReferenceNotFound
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(nil, k, crypto.Hash(0), data)
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsaSignRaw(a.card.rand, k, data)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, data)
+	default:
+		return nil, swConditionsNotMet
+	}
+
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	return sig, swOK
+}
+
+// decipher decrypts 00 followed by an RSA ciphertext, or computes the ECDH secret with the point of A6 7F49 86.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 67
+// 7.2.11 PSO: DECIPHER.
+func (a *openPGPApplet) decipher(data []byte) ([]byte, uint16) {
+	priv := a.keys[a.decipherSlot].priv
+	if priv == nil {
+		return nil, swReferenceNotFound
+	}
+
+	if len(data) == 0 {
+		return nil, swWrongData
+	}
+
+	if k, ok := priv.(*rsa.PrivateKey); ok {
+		if data[0] != 0x00 {
+			return nil, swWrongData
+		}
+
+		plaintext, err := rsa.DecryptPKCS1v15(nil, k, data[1:])
+		if err != nil {
+			return nil, swWrongData
+		}
+
+		return plaintext, swOK
+	}
+
+	point, ok := nestedTLV(data, 0xa6, 0x7f49, 0x86)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	secret, err := ecdhSharedSecret(priv, point)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	return secret, swOK
+}
+
+// nestedTLV follows tags through constructed objects.
+func nestedTLV(b []byte, tags ...uint16) ([]byte, bool) {
+	for _, tag := range tags {
+		objs, err := parseTLVs(b)
+		if err != nil {
+			return nil, false
+		}
+
+		var ok bool
+		if b, ok = findTLV(objs, tag); !ok {
+			return nil, false
+		}
+	}
+
+	return b, true
+}
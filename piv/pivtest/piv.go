@@ -0,0 +1,968 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// PIV instructions, the ones from F7 up are Yubico extensions.
+// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=84
+// https://developers.yubico.com/PIV/Introduction/Yubico_extensions.html
+const (
+	pivInsVerify          = 0x20
+	pivInsChangeReference = 0x24
+	pivInsResetRetry      = 0x2c
+	pivInsGenerate        = 0x47
+	pivInsAuthenticate    = 0x87
+	pivInsGetData         = 0xcb
+	pivInsPutData         = 0xdb
+	pivInsMoveKey         = 0xf6
+	pivInsGetMetadata     = 0xf7
+	pivInsGetSerial       = 0xf8
+	pivInsAttest          = 0xf9
+	pivInsSetPINRetries   = 0xfa
+	pivInsReset           = 0xfb
+	pivInsGetVersion      = 0xfd
+	pivInsImportKey       = 0xfe
+	pivInsSetMgmtKey      = 0xff
+)
+
+const (
+	pivAlg3DES     = 0x03
+	pivAlgAES128   = 0x08
+	pivAlgAES192   = 0x0a
+	pivAlgAES256   = 0x0c
+	pivAlgRSA1024  = 0x06
+	pivAlgRSA2048  = 0x07
+	pivAlgECCP256  = 0x11
+	pivAlgECCP384  = 0x14
+	pivAlgEd25519  = 0x22
+	pivPINRef      = 0x80
+	pivPUKRef      = 0x81
+	pivMgmtKeyRef  = 0x9b
+	pivCardAuthRef = 0x9e
+	pivAttestRef   = 0xf9
+
+	pivPINPolicyNever  = 0x01
+	pivPINPolicyOnce   = 0x02
+	pivPINPolicyAlways = 0x03
+	pivTouchNever      = 0x01
+	pivTouchAlways     = 0x02
+	pivOriginGenerated = 0x01
+	pivOriginImported  = 0x02
+
+	pivDefaultRetries = 3
+	pivFormFactorUSBA = 0x01
+)
+
+// pivAttestationObject holds the certificate of the attestation key.
+const pivAttestationObject = 0x5fff01
+
+// nolint:gochecknoglobals
+var (
+	extIDFirmwareVersion = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}
+	extIDSerialNumber    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}
+	extIDKeyPolicy       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}
+	extIDFormFactor      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}
+)
+
+// pivKey is the private key in a slot with its policies.
+type pivKey struct {
+	alg         byte
+	pinPolicy   byte
+	touchPolicy byte
+	origin      byte
+	priv        crypto.Signer
+}
+
+// pivApplet emulates the YubiKey PIV applet.
+type pivApplet struct {
+	card *Card
+
+	pin, puk               []byte
+	pinRetries, pukRetries int
+	pinAttempts            int
+	pukAttempts            int
+	mgmtAlg                byte
+	mgmtKey                []byte
+	mgmtTouch              bool
+	mgmtDefault            bool
+
+	// session state, cleared when the applet is selected.
+	pinVerified bool
+	// pinFresh is set by VERIFY and used up by a key with the always PIN policy.
+	pinFresh   bool
+	mgmtAuthed bool
+	witness    []byte
+
+	keys    map[byte]*pivKey
+	objects map[uint32][]byte
+
+	attestationRoot *x509.Certificate
+	attestationKey  *ecdsa.PrivateKey
+	attestationCert *x509.Certificate
+
+	// secure messaging, sm is the session and inSM is set while a protected command is handled.
+	smSigner *ecdsa.PrivateKey
+	smSuites map[byte]*smSuite
+	sm       *smSession
+	inSM     bool
+	vci      bool
+}
+
+func newPIVApplet(c *Card) *pivApplet {
+	a := &pivApplet{card: c}
+	a.initAttestation()
+	a.initSecureMessaging()
+	a.reset()
+
+	return a
+}
+
+// reset restores the factory state, the attestation key is kept.
+func (a *pivApplet) reset() {
+	a.pin = padPIN(DefaultPIN)
+	a.puk = padPIN(DefaultPUK)
+	a.pinAttempts, a.pukAttempts = pivDefaultRetries, pivDefaultRetries
+	a.pinRetries, a.pukRetries = pivDefaultRetries, pivDefaultRetries
+	// 5.7 replaced the 3DES key with an AES-192 key of the same bytes.
+	a.mgmtAlg = pivAlg3DES
+	if a.card.atLeast(5, 7) {
+		a.mgmtAlg = pivAlgAES192
+	}
+	a.mgmtKey = piv.DefaultManagementKey[:]
+	a.mgmtTouch = false
+	a.mgmtDefault = true
+	a.keys = map[byte]*pivKey{}
+	a.objects = map[uint32][]byte{
+		pivAttestationObject: marshalTLV(0x53, pivCertificateObject(a.attestationCert.Raw)),
+	}
+	a.selected()
+}
+
+func (a *pivApplet) selected() {
+	a.pinVerified = false
+	a.pinFresh = false
+	a.mgmtAuthed = false
+	a.witness = nil
+	a.sm = nil
+	a.vci = false
+}
+
+// initAttestation creates a root CA and the attestation key it certifies, like the Yubico PIV CA.
+func (a *pivApplet) initAttestation() {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), a.card.rand)
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: generating attestation root key: %v", err))
+	}
+
+	a.attestationKey, err = ecdsa.GenerateKey(elliptic.P256(), a.card.rand)
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: generating attestation key: %v", err))
+	}
+
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pivtest PIV Root CA"},
+		NotBefore:             time.Unix(0, 0),
+		NotAfter:              time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	if a.attestationRoot, err = createCertificate(a.card.rand, root, root, &rootKey.PublicKey, rootKey); err != nil {
+		panic(fmt.Sprintf("pivtest: creating attestation root: %v", err))
+	}
+
+	intermediate := &x509.Certificate{
+		SerialNumber:          big.NewInt(int64(a.card.serial)),
+		Subject:               pkix.Name{CommonName: "pivtest PIV Attestation"},
+		NotBefore:             root.NotBefore,
+		NotAfter:              root.NotAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	a.attestationCert, err = createCertificate(a.card.rand, intermediate, a.attestationRoot, &a.attestationKey.PublicKey, rootKey)
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: creating attestation certificate: %v", err))
+	}
+}
+
+func createCertificate(r io.Reader, template, parent *x509.Certificate, pub crypto.PublicKey, priv crypto.Signer) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(r, template, parent, pub, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// AttestationRoots returns the root of the attestation certificates of the card, for piv.Verifier.
+func (c *Card) AttestationRoots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.piv.attestationRoot)
+
+	return pool
+}
+
+// padPIN pads a PIN with FF to 8 bytes like the PIV client does.
+func padPIN(pin string) []byte {
+	b := bytes.Repeat([]byte{0xff}, 8)
+	copy(b, pin)
+
+	return b
+}
+
+// pivCertificateObject is the content of a certificate data object.
+// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=40
+func pivCertificateObject(der []byte) []byte {
+	b := marshalTLV(0x70, der)
+	b = append(b, marshalTLV(0x71, []byte{0x00})...)
+
+	return append(b, marshalTLV(0xfe, nil)...)
+}
+
+// verifyFailed is the status of a wrong PIN or PUK with the retries left.
+func verifyFailed(retries int) uint16 {
+	if retries <= 0 {
+		return swAuthBlocked
+	}
+
+	return swVerifyFailedPrefix | uint16(retries)
+}
+
+func (a *pivApplet) handle(cmd command) ([]byte, uint16) {
+	// over NFC the PIN needs the virtual contact interface.
+	if a.card.contactless && !a.vci && usesPIN(cmd) {
+		return nil, swSecurityStatus
+	}
+
+	switch cmd.ins {
+	case pivInsGetVersion:
+		return a.card.version[:], swOK
+	case pivInsGetSerial:
+		s := a.card.serial
+
+		return []byte{byte(s >> 24), byte(s >> 16), byte(s >> 8), byte(s)}, swOK
+	case pivInsVerify:
+		return a.verify(cmd)
+	case pivInsChangeReference:
+		return a.changeReference(cmd)
+	case pivInsResetRetry:
+		return a.resetRetry(cmd)
+	case pivInsAuthenticate:
+		return a.authenticate(cmd)
+	case pivInsGenerate:
+		return a.generate(cmd)
+	case pivInsImportKey:
+		return a.importKey(cmd)
+	case pivInsGetMetadata:
+		return a.metadata(cmd)
+	case pivInsMoveKey:
+		return a.moveKey(cmd)
+	case pivInsAttest:
+		return a.attest(cmd)
+	case pivInsGetData:
+		return a.getData(cmd)
+	case pivInsPutData:
+		return a.putData(cmd)
+	case pivInsSetMgmtKey:
+		return a.setManagementKey(cmd)
+	case pivInsSetPINRetries:
+		return a.setPINRetries(cmd)
+	case pivInsReset:
+		if a.pinRetries > 0 || a.pukRetries > 0 {
+			return nil, swConditionsNotMet
+		}
+
+		a.reset()
+
+		return nil, swOK
+	default:
+		return nil, swInsNotSupported
+	}
+}
+
+// usesPIN is true for the commands that send the PIN or PUK.
+func usesPIN(cmd command) bool {
+	switch cmd.ins {
+	case pivInsVerify:
+		return cmd.p2 == pivPINRef
+	case pivInsChangeReference, pivInsResetRetry:
+		return true
+	default:
+		return false
+	}
+}
+
+// verify checks the PIN, without data it reports whether the PIN was verified.
+func (a *pivApplet) verify(cmd command) ([]byte, uint16) {
+	if cmd.p2 == pivPairingCodeRef {
+		return a.verifyPairingCode(cmd)
+	}
+
+	if cmd.p2 != pivPINRef {
+		return nil, swReferenceNotFound
+	}
+
+	if len(cmd.data) == 0 {
+		if a.pinVerified {
+			return nil, swOK
+		}
+
+		return nil, verifyFailed(a.pinRetries)
+	}
+
+	if len(cmd.data) != 8 {
+		return nil, swWrongLength
+	}
+
+	if a.pinRetries == 0 {
+		return nil, swAuthBlocked
+	}
+
+	if !bytes.Equal(cmd.data, a.pin) {
+		a.pinRetries--
+		a.pinVerified = false
+
+		return nil, verifyFailed(a.pinRetries)
+	}
+
+	a.pinRetries = a.pinAttempts
+	a.pinVerified = true
+	a.pinFresh = true
+
+	return nil, swOK
+}
+
+// checkReference checks the first 8 bytes of data against the PIN or PUK in p2.
+func (a *pivApplet) checkReference(ref byte, old []byte) uint16 {
+	value, retries, attempts := &a.pin, &a.pinRetries, a.pinAttempts
+	if ref == pivPUKRef {
+		value, retries, attempts = &a.puk, &a.pukRetries, a.pukAttempts
+	}
+
+	if *retries == 0 {
+		return swAuthBlocked
+	}
+
+	if !bytes.Equal(old, *value) {
+		*retries--
+
+		return verifyFailed(*retries)
+	}
+
+	*retries = attempts
+
+	return swOK
+}
+
+func (a *pivApplet) changeReference(cmd command) ([]byte, uint16) {
+	if cmd.p2 != pivPINRef && cmd.p2 != pivPUKRef {
+		return nil, swReferenceNotFound
+	}
+
+	if len(cmd.data) != 16 {
+		return nil, swWrongLength
+	}
+
+	if sw := a.checkReference(cmd.p2, cmd.data[:8]); sw != swOK {
+		return nil, sw
+	}
+
+	if cmd.p2 == pivPINRef {
+		a.pin = append([]byte{}, cmd.data[8:]...)
+	} else {
+		a.puk = append([]byte{}, cmd.data[8:]...)
+	}
+
+	return nil, swOK
+}
+
+// resetRetry unblocks the PIN with the PUK.
+func (a *pivApplet) resetRetry(cmd command) ([]byte, uint16) {
+	if cmd.p2 != pivPINRef {
+		return nil, swReferenceNotFound
+	}
+
+	if len(cmd.data) != 16 {
+		return nil, swWrongLength
+	}
+
+	if sw := a.checkReference(pivPUKRef, cmd.data[:8]); sw != swOK {
+		return nil, sw
+	}
+
+	a.pin = append([]byte{}, cmd.data[8:]...)
+	a.pinRetries = a.pinAttempts
+
+	return nil, swOK
+}
+
+// authenticate is GENERAL AUTHENTICATE, mutual authentication with the management key
+// or a private key operation of a slot.
+// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=92
+func (a *pivApplet) authenticate(cmd command) ([]byte, uint16) {
+	objs, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	template, ok := findTLV(objs, 0x7c)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	if objs, err = parseTLVs(template); err != nil {
+		return nil, swWrongData
+	}
+
+	if cmd.p2 == pivMgmtKeyRef {
+		return a.authenticateManagementKey(cmd.p1, objs)
+	}
+
+	if cmd.p2 == pivSMKeyRef {
+		return a.establishSM(cmd.p1, objs)
+	}
+
+	key, ok := a.keys[cmd.p2]
+	if !ok {
+		return nil, swReferenceNotFound
+	}
+
+	if key.alg != cmd.p1 {
+		return nil, swWrongData
+	}
+
+	if !a.pinAllowed(key) {
+		return nil, swSecurityStatus
+	}
+
+	var result []byte
+
+	if challenge, ok := findTLV(objs, 0x81); ok {
+		result, err = a.sign(key, challenge)
+	} else if point, ok := findTLV(objs, 0x85); ok {
+		result, err = ecdhSharedSecret(key.priv, point)
+	} else {
+		return nil, swWrongData
+	}
+
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	return marshalTLV(0x7c, marshalTLV(0x82, result)), swOK
+}
+
+// pinAllowed enforces the PIN policy of a key.
+func (a *pivApplet) pinAllowed(key *pivKey) bool {
+	switch key.pinPolicy {
+	case pivPINPolicyNever:
+		return true
+	case pivPINPolicyAlways:
+		fresh := a.pinFresh
+		a.pinFresh = false
+
+		return fresh
+	default:
+		return a.pinVerified
+	}
+}
+
+// sign signs or decrypts the challenge, RSA keys don't pad it.
+func (a *pivApplet) sign(key *pivKey, challenge []byte) ([]byte, error) {
+	switch k := key.priv.(type) {
+	case *ecdsa.PrivateKey:
+		return ecdsa.SignASN1(a.card.rand, k, challenge)
+	case *rsa.PrivateKey:
+		return rsaRaw(k, challenge)
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, challenge), nil
+	default:
+		return nil, fmt.Errorf("%w: %T", errUnsupportedKey, key.priv)
+	}
+}
+
+// authenticateManagementKey is the two steps of the 3DES or AES mutual authentication,
+// the card sends an encrypted witness and then encrypts the challenge of the client.
+func (a *pivApplet) authenticateManagementKey(alg byte, objs []tlv) ([]byte, uint16) {
+	if alg != a.mgmtAlg {
+		return nil, swWrongParameters
+	}
+
+	block, err := managementKeyCipher(alg, a.mgmtKey)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	witness, ok := findTLV(objs, 0x80)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	if len(witness) == 0 {
+		a.mgmtAuthed = false
+		a.witness = make([]byte, block.BlockSize())
+
+		if _, err := io.ReadFull(a.card.rand, a.witness); err != nil {
+			return nil, swWrongData
+		}
+
+		encrypted := make([]byte, block.BlockSize())
+		block.Encrypt(encrypted, a.witness)
+
+		return marshalTLV(0x7c, marshalTLV(0x80, encrypted)), swOK
+	}
+
+	expected := a.witness
+	a.witness = nil
+
+	if expected == nil || !bytes.Equal(witness, expected) {
+		return nil, swSecurityStatus
+	}
+
+	challenge, ok := findTLV(objs, 0x81)
+	if !ok || len(challenge) != block.BlockSize() {
+		return nil, swWrongData
+	}
+
+	response := make([]byte, block.BlockSize())
+	block.Encrypt(response, challenge)
+	a.mgmtAuthed = true
+
+	return marshalTLV(0x7c, marshalTLV(0x82, response)), swOK
+}
+
+// managementKeyCipher returns the block cipher of a management key of alg.
+func managementKeyCipher(alg byte, key []byte) (cipher.Block, error) {
+	if alg == pivAlg3DES {
+		return des.NewTripleDESCipher(key)
+	}
+
+	return aes.NewCipher(key)
+}
+
+// managementKeyLen returns the key length of a management key algorithm, 0 for the others.
+func managementKeyLen(alg byte) int {
+	switch alg {
+	case pivAlgAES128:
+		return 16
+	case pivAlg3DES, pivAlgAES192:
+		return 24
+	case pivAlgAES256:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// policies reads the PIN and touch policies of GENERATE and IMPORT, with the defaults of the slot.
+func policies(slot byte, objs []tlv) (pinPolicy, touchPolicy byte) {
+	pinPolicy, touchPolicy = pivPINPolicyOnce, pivTouchNever
+	if slot == pivCardAuthRef {
+		pinPolicy = pivPINPolicyNever
+	}
+
+	if pp, ok := findTLV(objs, 0xaa); ok && len(pp) == 1 && pp[0] != 0 {
+		pinPolicy = pp[0]
+	}
+
+	if tp, ok := findTLV(objs, 0xab); ok && len(tp) == 1 && tp[0] != 0 {
+		touchPolicy = tp[0]
+	}
+
+	return pinPolicy, touchPolicy
+}
+
+func isKeySlot(slot byte) bool {
+	switch {
+	case slot == 0x9a, slot == 0x9c, slot == 0x9d, slot == 0x9e:
+		return true
+	case slot >= 0x82 && slot <= 0x95:
+		// retired key management slots.
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *pivApplet) generate(cmd command) ([]byte, uint16) {
+	if !a.mgmtAuthed {
+		return nil, swSecurityStatus
+	}
+
+	if !isKeySlot(cmd.p2) {
+		return nil, swReferenceNotFound
+	}
+
+	objs, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	template, ok := findTLV(objs, 0xac)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	if objs, err = parseTLVs(template); err != nil {
+		return nil, swWrongData
+	}
+
+	alg, ok := findTLV(objs, 0x80)
+	if !ok || len(alg) != 1 {
+		return nil, swWrongData
+	}
+
+	var priv crypto.Signer
+
+	switch alg[0] {
+	case pivAlgRSA1024:
+		priv, err = rsa.GenerateKey(a.card.rand, 1024)
+	case pivAlgRSA2048:
+		priv, err = rsa.GenerateKey(a.card.rand, 2048)
+	case pivAlgECCP256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), a.card.rand)
+	case pivAlgECCP384:
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), a.card.rand)
+	case pivAlgEd25519:
+		_, priv, err = ed25519.GenerateKey(a.card.rand)
+	default:
+		return nil, swWrongParameters
+	}
+
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	pub, err := encodePublicKey(priv.Public())
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	pinPolicy, touchPolicy := policies(cmd.p2, objs)
+	a.keys[cmd.p2] = &pivKey{
+		alg:         alg[0],
+		pinPolicy:   pinPolicy,
+		touchPolicy: touchPolicy,
+		origin:      pivOriginGenerated,
+		priv:        priv,
+	}
+
+	return marshalTLV(0x7f49, pub), swOK
+}
+
+// importKey is the Yubico IMPORT ASYMMETRIC KEY, P1 is the algorithm and P2 the slot.
+// RSA keys are sent as 01 p, 02 q, 03 dp, 04 dq, 05 qinv, EC keys as 06 scalar and Ed25519 keys as 07 seed.
+func (a *pivApplet) importKey(cmd command) ([]byte, uint16) {
+	if !a.mgmtAuthed {
+		return nil, swSecurityStatus
+	}
+
+	if !isKeySlot(cmd.p2) {
+		return nil, swReferenceNotFound
+	}
+
+	objs, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	var priv crypto.Signer
+
+	switch cmd.p1 {
+	case pivAlgRSA1024, pivAlgRSA2048:
+		p, _ := findTLV(objs, 0x01)
+		q, _ := findTLV(objs, 0x02)
+		priv, err = rsaKeyFromPrimes(65537, p, q)
+	case pivAlgECCP256:
+		d, _ := findTLV(objs, 0x06)
+		priv, err = ecdsaKeyFromScalar(elliptic.P256(), d)
+	case pivAlgECCP384:
+		d, _ := findTLV(objs, 0x06)
+		priv, err = ecdsaKeyFromScalar(elliptic.P384(), d)
+	case pivAlgEd25519:
+		seed, _ := findTLV(objs, 0x07)
+		if len(seed) != ed25519.SeedSize {
+			return nil, swWrongData
+		}
+
+		priv = ed25519.NewKeyFromSeed(seed)
+	default:
+		return nil, swWrongParameters
+	}
+
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	pinPolicy, touchPolicy := policies(cmd.p2, objs)
+	a.keys[cmd.p2] = &pivKey{
+		alg:         cmd.p1,
+		pinPolicy:   pinPolicy,
+		touchPolicy: touchPolicy,
+		origin:      pivOriginImported,
+		priv:        priv,
+	}
+
+	return nil, swOK
+}
+
+// metadata is the Yubico GET METADATA of a key, the PIN, the PUK or the management key.
+func (a *pivApplet) metadata(cmd command) ([]byte, uint16) {
+	switch cmd.p2 {
+	case pivPINRef, pivPUKRef:
+		value, retries, attempts, def := a.pin, a.pinRetries, a.pinAttempts, DefaultPIN
+		if cmd.p2 == pivPUKRef {
+			value, retries, attempts, def = a.puk, a.pukRetries, a.pukAttempts, DefaultPUK
+		}
+
+		b := marshalTLV(0x01, []byte{0xff})
+		b = append(b, marshalTLV(0x05, []byte{boolByte(bytes.Equal(value, padPIN(def)))})...)
+
+		return append(b, marshalTLV(0x06, []byte{byte(attempts), byte(retries)})...), swOK
+	case pivMgmtKeyRef:
+		touch := byte(pivTouchNever)
+		if a.mgmtTouch {
+			touch = pivTouchAlways
+		}
+
+		b := marshalTLV(0x01, []byte{a.mgmtAlg})
+		b = append(b, marshalTLV(0x02, []byte{0x00, touch})...)
+
+		return append(b, marshalTLV(0x05, []byte{boolByte(a.mgmtDefault)})...), swOK
+	}
+
+	key, ok := a.keys[cmd.p2]
+	if !ok {
+		return nil, swReferenceNotFound
+	}
+
+	pub, err := encodePublicKey(key.priv.Public())
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	b := marshalTLV(0x01, []byte{key.alg})
+	b = append(b, marshalTLV(0x02, []byte{key.pinPolicy, key.touchPolicy})...)
+	b = append(b, marshalTLV(0x03, []byte{key.origin})...)
+
+	return append(b, marshalTLV(0x04, pub)...), swOK
+}
+
+// moveKey moves the key of slot P2 to slot P1, or deletes it when P1 is FF.
+func (a *pivApplet) moveKey(cmd command) ([]byte, uint16) {
+	if !a.mgmtAuthed {
+		return nil, swSecurityStatus
+	}
+
+	key, ok := a.keys[cmd.p2]
+	if !ok {
+		return nil, swReferenceNotFound
+	}
+
+	if cmd.p1 != 0xff && !isKeySlot(cmd.p1) {
+		return nil, swReferenceNotFound
+	}
+
+	delete(a.keys, cmd.p2)
+
+	if cmd.p1 != 0xff {
+		a.keys[cmd.p1] = key
+	}
+
+	return nil, swOK
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 0x01
+	}
+
+	return 0x00
+}
+
+// attest returns a certificate of a generated key signed by the attestation key.
+// https://developers.yubico.com/PIV/Introduction/PIV_attestation.html
+func (a *pivApplet) attest(cmd command) ([]byte, uint16) {
+	key, ok := a.keys[cmd.p1]
+	if !ok || key.origin != pivOriginGenerated {
+		return nil, swWrongData
+	}
+
+	serial, err := asn1.Marshal(int64(a.card.serial))
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(cmd.p1)),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("YubiKey PIV Attestation %02x", cmd.p1)},
+		NotBefore:    a.attestationCert.NotBefore,
+		NotAfter:     a.attestationCert.NotAfter,
+		ExtraExtensions: []pkix.Extension{
+			{Id: extIDFirmwareVersion, Value: a.card.version[:]},
+			{Id: extIDSerialNumber, Value: serial},
+			{Id: extIDKeyPolicy, Value: []byte{key.pinPolicy, key.touchPolicy}},
+			{Id: extIDFormFactor, Value: []byte{pivFormFactorUSBA}},
+		},
+	}
+
+	cert, err := x509.CreateCertificate(a.card.rand, template, a.attestationCert, key.priv.Public(), a.attestationKey)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	return cert, swOK
+}
+
+// objectTag reads the 5C tag list of GET DATA and PUT DATA.
+func objectTag(objs []tlv) (uint32, bool) {
+	tag, ok := findTLV(objs, 0x5c)
+	if !ok || len(tag) == 0 || len(tag) > 3 {
+		return 0, false
+	}
+
+	var id uint32
+	for _, b := range tag {
+		id = id<<8 | uint32(b)
+	}
+
+	return id, true
+}
+
+func (a *pivApplet) getData(cmd command) ([]byte, uint16) {
+	if cmd.p1 != 0x3f || cmd.p2 != 0xff {
+		return nil, swWrongParameters
+	}
+
+	objs, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	id, ok := objectTag(objs)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	obj, ok := a.objects[id]
+	if !ok {
+		return nil, swFileNotFound
+	}
+
+	return append([]byte{}, obj...), swOK
+}
+
+// putData stores a data object, an empty object deletes it.
+func (a *pivApplet) putData(cmd command) ([]byte, uint16) {
+	if !a.mgmtAuthed {
+		return nil, swSecurityStatus
+	}
+
+	if cmd.p1 != 0x3f || cmd.p2 != 0xff {
+		return nil, swWrongParameters
+	}
+
+	objs, err := parseTLVs(cmd.data)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	id, ok := objectTag(objs)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	value, ok := findTLV(objs, 0x53)
+	if !ok {
+		return nil, swWrongData
+	}
+
+	if len(value) == 0 {
+		delete(a.objects, id)
+
+		return nil, swOK
+	}
+
+	a.objects[id] = marshalTLV(0x53, value)
+
+	return nil, swOK
+}
+
+func (a *pivApplet) setManagementKey(cmd command) ([]byte, uint16) {
+	if !a.mgmtAuthed {
+		return nil, swSecurityStatus
+	}
+
+	if len(cmd.data) < 3 || cmd.data[1] != pivMgmtKeyRef {
+		return nil, swWrongData
+	}
+
+	alg, n := cmd.data[0], managementKeyLen(cmd.data[0])
+	if n == 0 || alg != pivAlg3DES && !a.card.atLeast(5, 4) {
+		return nil, swWrongParameters
+	}
+
+	if int(cmd.data[2]) != n || len(cmd.data) != 3+n {
+		return nil, swWrongData
+	}
+
+	a.mgmtAlg = alg
+	a.mgmtKey = append([]byte(nil), cmd.data[3:]...)
+	a.mgmtTouch = cmd.p2 == 0xfe
+	a.mgmtDefault = bytes.Equal(a.mgmtKey, piv.DefaultManagementKey[:])
+
+	return nil, swOK
+}
+
+// setPINRetries sets the attempts of the PIN (P1) and PUK (P2), and resets both to their defaults.
+func (a *pivApplet) setPINRetries(cmd command) ([]byte, uint16) {
+	if !a.mgmtAuthed || !a.pinVerified {
+		return nil, swSecurityStatus
+	}
+
+	if cmd.p1 == 0 || cmd.p2 == 0 {
+		return nil, swWrongParameters
+	}
+
+	a.pinAttempts, a.pukAttempts = int(cmd.p1), int(cmd.p2)
+	a.pinRetries, a.pukRetries = a.pinAttempts, a.pukAttempts
+	a.pin, a.puk = padPIN(DefaultPIN), padPIN(DefaultPUK)
+
+	return nil, swOK
+}
@@ -0,0 +1,372 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// DefaultPairingCode is the pairing code of a card with secure messaging.
+const DefaultPairingCode = "12345678"
+
+// PIV secure messaging of SP 800-73-4 Part 2, which YubiKeys don't have.
+const (
+	pivSMKeyRef       = 0x04
+	pivPairingCodeRef = 0x98
+	pivCS2            = 0x27
+	pivCS7            = 0x2e
+
+	smClass        = 0x0c
+	smMACLen       = 8
+	swSMIncorrect  = 0x6988
+	swSMNotEnabled = 0x6882
+)
+
+// smSuite is a cipher suite of the emulated card, with its SM key and certificate.
+type smSuite struct {
+	key      *ecdh.PrivateKey
+	cvc      []byte
+	hash     func() hash.Hash
+	keyLen   int
+	nonceLen int
+	algID    byte
+}
+
+// smSession holds the session keys and counters of secure messaging.
+type smSession struct {
+	enc, mac, rmac cipher.Block
+	counter        []byte
+	mcv            []byte
+}
+
+// initSecureMessaging creates the SM keys of both cipher suites and their card verifiable certificates,
+// signed by the SM certificate signer.
+func (a *pivApplet) initSecureMessaging() {
+	var err error
+
+	if a.smSigner, err = ecdsa.GenerateKey(elliptic.P384(), a.card.rand); err != nil {
+		panic(fmt.Sprintf("pivtest: generating sm signer: %v", err))
+	}
+
+	subject := make([]byte, 16)
+	if _, err := io.ReadFull(a.card.rand, subject); err != nil {
+		panic(fmt.Sprintf("pivtest: generating sm subject: %v", err))
+	}
+
+	a.smSuites = map[byte]*smSuite{
+		// ecdsa-with-SHA256 and ecdsa-with-SHA384.
+		pivCS2: a.newSMSuite(ecdh.P256(), sha256.New, 16, 16, 0x09, []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x04, 0x03, 0x02}, subject),
+		pivCS7: a.newSMSuite(ecdh.P384(), sha512.New384, 32, 24, 0x0d, []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x04, 0x03, 0x03}, subject),
+	}
+}
+
+func (a *pivApplet) newSMSuite(curve ecdh.Curve, h func() hash.Hash, keyLen, nonceLen int, algID byte, oid, subject []byte) *smSuite {
+	key, err := curve.GenerateKey(a.card.rand)
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: generating sm key: %v", err))
+	}
+
+	body := []bertlv.TLV{
+		{Tag: 0x5f29, Value: []byte{0x80}},
+		{Tag: 0x42, Value: []byte("pivtest0")},
+		{Tag: 0x5f20, Value: subject},
+		{Tag: 0x7f49, Children: []bertlv.TLV{{Tag: 0x06, Value: oid}, {Tag: 0x86, Value: key.PublicKey().Bytes()}}},
+		{Tag: 0x5f4c, Value: []byte{0x00}},
+	}
+
+	signed, err := bertlv.Marshal(body...)
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: encoding cvc: %v", err))
+	}
+
+	d := h()
+	d.Write(signed)
+
+	sig, err := ecdsa.SignASN1(a.card.rand, a.smSigner, d.Sum(nil))
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: signing cvc: %v", err))
+	}
+
+	cvc, err := bertlv.Marshal(bertlv.TLV{Tag: 0x7f21, Children: append(body, bertlv.TLV{Tag: 0x5f37, Value: sig})})
+	if err != nil {
+		panic(fmt.Sprintf("pivtest: encoding cvc: %v", err))
+	}
+
+	return &smSuite{key: key, cvc: cvc, hash: h, keyLen: keyLen, nonceLen: nonceLen, algID: algID}
+}
+
+// SMSigner returns the key that signs the card verifiable certificates of the SM keys,
+// for piv.SecureMessagingOptions.CVCSigner.
+func (c *Card) SMSigner() *ecdsa.PublicKey {
+	return &c.piv.smSigner.PublicKey
+}
+
+// establishSM is the card's side of the key establishment, SP 800-73-4 Part 2, 4.1.
+func (a *pivApplet) establishSM(cs byte, objs []tlv) ([]byte, uint16) {
+	suite, ok := a.smSuites[cs]
+	if !ok || !a.card.secureMessaging {
+		return nil, swWrongParameters
+	}
+
+	data, ok := findTLV(objs, 0x81)
+	if !ok || len(data) < 9 || data[0] != 0x00 {
+		return nil, swWrongData
+	}
+
+	idH, qeH := data[1:9], data[9:]
+
+	pub, err := suite.key.Curve().NewPublicKey(qeH)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	z, err := suite.key.ECDH(pub)
+	if err != nil {
+		return nil, swWrongData
+	}
+
+	nonce := make([]byte, suite.nonceLen)
+	if _, err := io.ReadFull(a.card.rand, nonce); err != nil {
+		return nil, swWrongData
+	}
+
+	cvc, _ := bertlv.Unmarshal(suite.cvc)
+	subject, _ := bertlv.Find(cvc, 0x7f21, 0x5f20)
+	idICC := subject.Value[:8]
+
+	otherInfo := concat(
+		[]byte{0x04, suite.algID, suite.algID, suite.algID, suite.algID},
+		[]byte{0x08}, idH,
+		[]byte{0x01, 0x00},
+		[]byte{0x10}, qeH[:16],
+		[]byte{0x08}, idICC,
+		[]byte{byte(len(nonce))}, nonce,
+		[]byte{0x01, 0x00},
+	)
+
+	var keys []byte
+	for counter := uint32(1); len(keys) < 4*suite.keyLen; counter++ {
+		d := suite.hash()
+		d.Write([]byte{byte(counter >> 24), byte(counter >> 16), byte(counter >> 8), byte(counter)})
+		d.Write(z)
+		d.Write(otherInfo)
+		keys = d.Sum(keys)
+	}
+
+	n := suite.keyLen
+	blocks := make([]cipher.Block, 4)
+
+	for i := range blocks {
+		if blocks[i], err = aes.NewCipher(keys[i*n : (i+1)*n]); err != nil {
+			return nil, swWrongData
+		}
+	}
+
+	cryptogram := cmac(blocks[0], concat([]byte("KC_1_V"), idICC, idH, qeH))
+
+	a.sm = &smSession{
+		mac:     blocks[1],
+		enc:     blocks[2],
+		rmac:    blocks[3],
+		counter: make([]byte, aes.BlockSize),
+		mcv:     make([]byte, aes.BlockSize),
+	}
+
+	return marshalTLV(0x7c, marshalTLV(0x82, concat([]byte{0x00}, nonce, cryptogram, suite.cvc))), swOK
+}
+
+// handleSM unwraps a command protected with secure messaging, handles it and protects the response.
+// Errors of the protection end the session.
+func (a *pivApplet) handleSM(cmd command) ([]byte, uint16) {
+	s := a.sm
+	if s == nil {
+		return nil, swSMNotEnabled
+	}
+
+	for i := len(s.counter) - 1; i >= 0; i-- {
+		s.counter[i]++
+		if s.counter[i] != 0 {
+			break
+		}
+	}
+
+	objs, err := bertlv.Unmarshal(cmd.data)
+	if err != nil || len(objs) == 0 || objs[len(objs)-1].Tag != 0x8e || len(cmd.data) < 2+smMACLen {
+		a.sm = nil
+
+		return nil, swSMIncorrect
+	}
+
+	covered := cmd.data[:len(cmd.data)-2-smMACLen]
+	header := pad([]byte{smClass, cmd.ins, cmd.p1, cmd.p2})
+
+	mac := cmac(s.mac, concat(s.mcv, header, pad(covered)))
+	if subtle.ConstantTimeCompare(mac[:smMACLen], objs[len(objs)-1].Value) != 1 {
+		a.sm = nil
+
+		return nil, swSMIncorrect
+	}
+
+	s.mcv = mac
+
+	var data []byte
+
+	if cryptogram, ok := bertlv.Find(objs, 0x87); ok {
+		if len(cryptogram.Value) < 1+aes.BlockSize || cryptogram.Value[0] != 0x01 || (len(cryptogram.Value)-1)%aes.BlockSize != 0 {
+			a.sm = nil
+
+			return nil, swSMIncorrect
+		}
+
+		data = make([]byte, len(cryptogram.Value)-1)
+		cipher.NewCBCDecrypter(s.enc, s.iv(0x00)).CryptBlocks(data, cryptogram.Value[1:])
+
+		if data, ok = unpad(data); !ok {
+			a.sm = nil
+
+			return nil, swSMIncorrect
+		}
+	}
+
+	a.inSM = true
+	resp, sw := a.handle(command{ins: cmd.ins, p1: cmd.p1, p2: cmd.p2, data: data})
+	a.inSM = false
+
+	var dos []byte
+
+	if len(resp) > 0 {
+		out := pad(resp)
+		cipher.NewCBCEncrypter(s.enc, s.iv(0x80)).CryptBlocks(out, out)
+		dos = marshalTLV(0x87, append([]byte{0x01}, out...))
+	}
+
+	dos = append(dos, marshalTLV(0x99, []byte{byte(sw >> 8), byte(sw)})...)
+	rmac := cmac(s.rmac, concat(s.mcv, pad(dos)))
+
+	return append(dos, marshalTLV(0x8e, rmac[:smMACLen])...), swOK
+}
+
+// verifyPairingCode establishes the virtual contact interface, only through secure messaging.
+func (a *pivApplet) verifyPairingCode(cmd command) ([]byte, uint16) {
+	if !a.inSM {
+		return nil, swSecurityStatus
+	}
+
+	if !bytes.Equal(cmd.data, []byte(a.card.pairingCode)) {
+		return nil, verifyFailed(a.pinRetries)
+	}
+
+	a.vci = true
+
+	return nil, swOK
+}
+
+func (s *smSession) iv(first byte) []byte {
+	iv := make([]byte, aes.BlockSize)
+	s.enc.Encrypt(iv, append([]byte{first}, s.counter[1:]...))
+
+	return iv
+}
+
+// pad pads data with 80 00... to a multiple of the AES block size.
+func pad(data []byte) []byte {
+	out := make([]byte, len(data)+aes.BlockSize-len(data)%aes.BlockSize)
+	copy(out, data)
+	out[len(data)] = 0x80
+
+	return out
+}
+
+func unpad(data []byte) ([]byte, bool) {
+	i := bytes.LastIndexByte(data, 0x80)
+	if i < 0 || len(bytes.Trim(data[i+1:], "\x00")) != 0 {
+		return nil, false
+	}
+
+	return data[:i], true
+}
+
+// cmac is AES-CMAC, RFC 4493.
+func cmac(b cipher.Block, msg []byte) []byte {
+	k1 := make([]byte, aes.BlockSize)
+	b.Encrypt(k1, k1)
+	k1 = cmacDouble(k1)
+	k2 := cmacDouble(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, aes.BlockSize)
+	tail := msg[(n-1)*aes.BlockSize:]
+
+	if len(tail) == aes.BlockSize {
+		subtle.XORBytes(last, tail, k1)
+	} else {
+		copy(last, tail)
+		last[len(tail)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		b.Encrypt(x, x)
+	}
+
+	subtle.XORBytes(x, x, last)
+	b.Encrypt(x, x)
+
+	return x
+}
+
+func cmacDouble(in []byte) []byte {
+	out := make([]byte, len(in))
+
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+
+	if in[0]&0x80 != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+
+	return out
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+
+	return out
+}
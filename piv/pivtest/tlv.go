@@ -0,0 +1,69 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pivtest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+var errTLV = errors.New("malformed tlv")
+
+// tlv is a BER-TLV object with a tag of one or two bytes.
+type tlv struct {
+	tag   uint16
+	value []byte
+}
+
+// parseTLVs splits b into its top level objects.
+func parseTLVs(b []byte) ([]tlv, error) {
+	parsed, err := bertlv.Unmarshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errTLV, err)
+	}
+
+	objs := make([]tlv, 0, len(parsed))
+
+	for _, obj := range parsed {
+		if obj.Tag > 0xffff {
+			return nil, fmt.Errorf("%w: tag %s", errTLV, obj.Tag)
+		}
+
+		objs = append(objs, tlv{tag: uint16(obj.Tag), value: obj.Value})
+	}
+
+	return objs, nil
+}
+
+// findTLV returns the value of the first object with tag.
+func findTLV(objs []tlv, tag uint16) ([]byte, bool) {
+	for _, obj := range objs {
+		if obj.tag == tag {
+			return obj.value, true
+		}
+	}
+
+	return nil, false
+}
+
+// marshalTLV encodes an object with a one or two byte tag.
+func marshalTLV(tag uint16, value []byte) []byte {
+	// only tag 0 fails.
+	b, _ := bertlv.Marshal(bertlv.TLV{Tag: bertlv.Tag(tag), Value: value})
+
+	return b
+}
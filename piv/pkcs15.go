@@ -0,0 +1,850 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// PKCS#15 (ISO/IEC 7816-15) describes the keys and certificates on a card with files the card itself
+// doesn't need to understand, which is what most non PIV corporate cards use.
+// Only reading is supported, this is enough to identify a card and list its certificates and public keys.
+// https://www.rsa.com/wp-content/uploads/2023/08/pkcs-15v1_1.pdf
+//
+// The SmartCard-HSM doesn't have a PKCS#15 file system, it keeps PKCS#15 objects in flat files that are
+// found by enumerating them.
+// https://github.com/OpenSC/OpenSC/blob/master/src/libopensc/card-sc-hsm.c
+
+// nolint:gochecknoglobals
+var (
+	aidPKCS15       = [...]byte{0xa0, 0x00, 0x00, 0x00, 0x63, 0x50, 0x4b, 0x43, 0x53, 0x2d, 0x31, 0x35}
+	aidSmartCardHSM = [...]byte{0xe8, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x81, 0xc3, 0x1f, 0x02, 0x01}
+)
+
+const (
+	insReadBinary            = 0xb0
+	insReadBinaryOdd         = 0xb1
+	insReadRecord            = 0xb2
+	insSCHSMEnumerateObjects = 0x58
+
+	// SELECT P1 and P2 values.
+	// ISO/IEC 7816-4 7.1.1 SELECT command.
+	selectEFUnderDF  = 0x02
+	selectPathFromMF = 0x08
+	selectPathFromDF = 0x09
+	selectNoResponse = 0x0c
+
+	// readRecordCurrent is P2 for READ RECORD of the record number in P1.
+	readRecordCurrent = 0x04
+	// readBinaryChunk is the most a short READ BINARY with Le 00 returns.
+	readBinaryChunk = 256
+	// maxReadBinaryOffset is the largest offset that fits P1-P2 of READ BINARY.
+	maxReadBinaryOffset = 0x7fff
+
+	tagOffsetData = 0x54
+
+	// ODF choices, PKCS #15 v1.1 6.2 PKCS15Objects.
+	odfPrivateKeys         = 0
+	odfPublicKeys          = 1
+	odfTrustedPublicKeys   = 2
+	odfCertificates        = 4
+	odfTrustedCertificates = 5
+	odfUsefulCertificates  = 6
+
+	// SmartCard-HSM file id prefixes.
+	scHSMPrefixPrivateKeyDescription  = 0xc4
+	scHSMPrefixCertificateDescription = 0xc8
+	scHSMPrefixCACertificate          = 0xca
+	scHSMPrefixEECertificate          = 0xce
+
+	// PKCS #15 v1.1 6.9 TokenFlags.
+	tokenFlagReadOnly      = 0
+	tokenFlagLoginRequired = 1
+)
+
+// nolint:gochecknoglobals
+var (
+	fileEFDIR          = []byte{0x2f, 0x00}
+	fileODF            = []byte{0x50, 0x31}
+	fileTokenInfo      = []byte{0x50, 0x32}
+	fileSCHSMTokenInfo = []byte{0x2f, 0x03}
+	pathMF             = []byte{0x3f, 0x00}
+)
+
+var (
+	// ErrPKCS15NotFound is returned when the card has neither a PKCS#15 application nor is a SmartCard-HSM.
+	ErrPKCS15NotFound = errors.New("no PKCS#15 application found")
+	// ErrPKCS15Malformed is returned when a PKCS#15 file can't be parsed.
+	ErrPKCS15Malformed = errors.New("malformed PKCS#15 data")
+)
+
+// PKCS15Application is an application listed in EF.DIR.
+type PKCS15Application struct {
+	AID   []byte
+	Label string
+	Path  []byte
+}
+
+// PKCS15TokenInfo is the EF.TokenInfo of a PKCS#15 application.
+type PKCS15TokenInfo struct {
+	Version        int
+	SerialNumber   string
+	ManufacturerID string
+	Label          string
+	ReadOnly       bool
+	LoginRequired  bool
+}
+
+// PKCS15Certificate is a certificate listed by a certificate directory file.
+type PKCS15Certificate struct {
+	Label     string
+	ID        []byte
+	Authority bool
+	Raw       []byte
+	// Certificate is nil if Raw isn't an X.509 certificate.
+	Certificate *x509.Certificate
+}
+
+// PKCS15PublicKey is a public key listed by a public key directory file.
+type PKCS15PublicKey struct {
+	Label string
+	ID    []byte
+	Raw   []byte
+	// PublicKey is nil if Raw isn't a SubjectPublicKeyInfo or PKCS #1 RSA public key.
+	PublicKey crypto.PublicKey
+}
+
+// PKCS15 is a read only connection to the PKCS#15 application of a card.
+type PKCS15 struct {
+	ctx SCContext
+	h   SCHandle
+	tx  SCTx
+
+	applications []PKCS15Application
+	// hsm is set for a SmartCard-HSM.
+	hsm bool
+	// odf holds the paths of the directory files from EF.ODF, by ODF choice.
+	odf map[int][][]byte
+}
+
+type pkcs15TokenInfo struct {
+	Version        int
+	SerialNumber   []byte
+	ManufacturerID string `asn1:"optional,utf8"`
+	Label          string `asn1:"optional,tag:0,utf8"`
+	TokenFlags     asn1.BitString
+}
+
+type pkcs15CommonObjectAttributes struct {
+	Label string `asn1:"optional,utf8"`
+}
+
+type pkcs15CommonCertificateAttributes struct {
+	ID        []byte
+	Authority bool `asn1:"optional"`
+}
+
+type pkcs15CommonKeyAttributes struct {
+	ID []byte
+}
+
+type pkcs15Path struct {
+	Path []byte
+}
+
+// pkcs15Object is the common layout of PKCS#15 objects.
+// PKCS #15 v1.1 6.1.5 PKCS15Object.
+type pkcs15Object struct {
+	label string
+	class []byte
+	// value is the first field of the type attributes, an ObjectValue for certificates and public keys.
+	value asn1.RawValue
+}
+
+// OpenPKCS15 connects to the PKCS#15 application of a card, falling back to the SmartCard-HSM applet.
+func OpenPKCS15(card string) (*PKCS15, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenPKCS15(card)
+}
+
+// OpenPKCS15 connects to the PKCS#15 application of a card, falling back to the SmartCard-HSM applet.
+func (c *Client) OpenPKCS15(card string) (*PKCS15, error) {
+	ctx, h, tx, err := c.connectCard(card)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PKCS15{
+		ctx: ctx,
+		h:   h,
+		tx:  tx,
+	}
+
+	err = p.open()
+	if err != nil {
+		tx.Close()
+		closeHandles(ctx, h)
+
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *PKCS15) open() error {
+	dir, err := readFile(p.tx, selectPathFromMF, fileEFDIR)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("reading EF.DIR: %w", err)
+	}
+
+	p.applications, err = parseEFDIR(dir)
+	if err != nil {
+		return err
+	}
+
+	err = p.selectApplication()
+	if errors.Is(err, ErrNotFound) {
+		if _, hsmErr := ykSelectApplicationResponse(p.tx, aidSmartCardHSM[:]); hsmErr == nil {
+			p.hsm = true
+
+			return nil
+		}
+
+		return fmt.Errorf("%w: %w", ErrPKCS15NotFound, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	odf, err := readFile(p.tx, selectEFUnderDF, fileODF)
+	if err != nil {
+		return fmt.Errorf("reading EF.ODF: %w", err)
+	}
+
+	p.odf, err = parseODF(odf)
+
+	return err
+}
+
+// selectApplication selects the PKCS#15 application, by path if EF.DIR has one.
+func (p *PKCS15) selectApplication() error {
+	for _, app := range p.applications {
+		if !bytes.Equal(app.AID, aidPKCS15[:]) {
+			continue
+		}
+
+		if len(app.Path) > 0 {
+			return selectPath(p.tx, app.Path)
+		}
+	}
+
+	_, err := ykSelectApplicationResponse(p.tx, aidPKCS15[:])
+
+	return err
+}
+
+// Close releases the connection to the smart card.
+func (p *PKCS15) Close() error {
+	return closeHandles(p.ctx, p.h)
+}
+
+// SmartCardHSM reports whether the card is a SmartCard-HSM.
+func (p *PKCS15) SmartCardHSM() bool {
+	return p.hsm
+}
+
+// Applications returns the applications listed in EF.DIR, this may include applications other than PKCS#15.
+func (p *PKCS15) Applications() []PKCS15Application {
+	return p.applications
+}
+
+// TokenInfo reads EF.TokenInfo.
+func (p *PKCS15) TokenInfo() (*PKCS15TokenInfo, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if p.hsm {
+		data, err = readFileOdd(p.tx, fileSCHSMTokenInfo)
+	} else {
+		data, err = readFile(p.tx, selectEFUnderDF, fileTokenInfo)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading EF.TokenInfo: %w", err)
+	}
+
+	return parseTokenInfo(data)
+}
+
+// Certificates reads the certificates listed by all certificate directory files.
+func (p *PKCS15) Certificates() ([]PKCS15Certificate, error) {
+	if p.hsm {
+		return p.hsmCertificates()
+	}
+
+	var certs []PKCS15Certificate
+
+	for _, choice := range []int{odfCertificates, odfTrustedCertificates, odfUsefulCertificates} {
+		for _, path := range p.odf[choice] {
+			objects, err := p.readDirectory(path)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, o := range objects {
+				var attrs pkcs15CommonCertificateAttributes
+				if _, err := asn1.Unmarshal(o.class, &attrs); err != nil {
+					return nil, fmt.Errorf("%w: certificate attributes: %w", ErrPKCS15Malformed, err)
+				}
+
+				raw, err := p.objectValue(o.value)
+				if err != nil {
+					return nil, err
+				}
+
+				certs = append(certs, newPKCS15Certificate(o.label, attrs.ID, attrs.Authority || choice == odfTrustedCertificates, raw))
+			}
+		}
+	}
+
+	return certs, nil
+}
+
+// PublicKeys reads the public keys listed by all public key directory files.
+// For a SmartCard-HSM these are the public keys of the end entity certificates.
+func (p *PKCS15) PublicKeys() ([]PKCS15PublicKey, error) {
+	if p.hsm {
+		return p.hsmPublicKeys()
+	}
+
+	var keys []PKCS15PublicKey
+
+	for _, choice := range []int{odfPublicKeys, odfTrustedPublicKeys} {
+		for _, path := range p.odf[choice] {
+			objects, err := p.readDirectory(path)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, o := range objects {
+				var attrs pkcs15CommonKeyAttributes
+				if _, err := asn1.Unmarshal(o.class, &attrs); err != nil {
+					return nil, fmt.Errorf("%w: key attributes: %w", ErrPKCS15Malformed, err)
+				}
+
+				raw, err := p.objectValue(o.value)
+				if err != nil {
+					return nil, err
+				}
+
+				keys = append(keys, PKCS15PublicKey{
+					Label:     o.label,
+					ID:        attrs.ID,
+					Raw:       raw,
+					PublicKey: parsePKCS15PublicKey(raw),
+				})
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// readDirectory reads and parses a directory file such as a CDF or PuKDF.
+func (p *PKCS15) readDirectory(path []byte) ([]pkcs15Object, error) {
+	data, err := readPath(p.tx, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory file %x: %w", path, err)
+	}
+
+	return parsePKCS15Objects(data)
+}
+
+// objectValue returns the DER value of an ObjectValue, reading it from the card if it's a path.
+func (p *PKCS15) objectValue(v asn1.RawValue) ([]byte, error) {
+	switch {
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagSequence:
+		var path pkcs15Path
+		if _, err := asn1.Unmarshal(v.FullBytes, &path); err != nil {
+			return nil, fmt.Errorf("%w: path: %w", ErrPKCS15Malformed, err)
+		}
+
+		data, err := readPath(p.tx, path.Path)
+		if err != nil {
+			return nil, fmt.Errorf("reading object %x: %w", path.Path, err)
+		}
+
+		return trimDER(data), nil
+	case v.Class == asn1.ClassContextSpecific && v.IsCompound:
+		return directValue(v), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported object value class=%d tag=%d", ErrPKCS15Malformed, v.Class, v.Tag)
+	}
+}
+
+// hsmFiles enumerates the files of a SmartCard-HSM that start with prefix.
+func (p *PKCS15) hsmFiles(prefix byte) ([][]byte, error) {
+	resp, err := p.tx.Transmit(apdu{instruction: insSCHSMEnumerateObjects})
+	if err != nil {
+		return nil, fmt.Errorf("enumerating objects: %w", err)
+	}
+
+	var files [][]byte
+
+	for i := 0; i+1 < len(resp); i += 2 {
+		if resp[i] == prefix {
+			files = append(files, resp[i:i+2])
+		}
+	}
+
+	return files, nil
+}
+
+// hsmLabel returns the label and id from the description file for key id, they are empty if there isn't one.
+func (p *PKCS15) hsmLabel(prefix, id byte) (string, []byte) {
+	data, err := readFileOdd(p.tx, []byte{prefix, id})
+	if err != nil {
+		return "", nil
+	}
+
+	objects, err := parsePKCS15Objects(data)
+	if err != nil || len(objects) == 0 {
+		return "", nil
+	}
+
+	var attrs pkcs15CommonKeyAttributes
+
+	_, _ = asn1.Unmarshal(objects[0].class, &attrs)
+
+	return objects[0].label, attrs.ID
+}
+
+func (p *PKCS15) hsmCertificates() ([]PKCS15Certificate, error) {
+	var certs []PKCS15Certificate
+
+	for _, prefix := range []byte{scHSMPrefixEECertificate, scHSMPrefixCACertificate} {
+		files, err := p.hsmFiles(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			raw, err := readFileOdd(p.tx, f)
+			if err != nil {
+				return nil, fmt.Errorf("reading certificate %x: %w", f, err)
+			}
+
+			description := byte(scHSMPrefixPrivateKeyDescription)
+			if prefix == scHSMPrefixCACertificate {
+				description = scHSMPrefixCertificateDescription
+			}
+
+			label, id := p.hsmLabel(description, f[1])
+			if id == nil {
+				id = []byte{f[1]}
+			}
+
+			certs = append(certs, newPKCS15Certificate(label, id, prefix == scHSMPrefixCACertificate, trimDER(raw)))
+		}
+	}
+
+	return certs, nil
+}
+
+func (p *PKCS15) hsmPublicKeys() ([]PKCS15PublicKey, error) {
+	certs, err := p.hsmCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []PKCS15PublicKey
+
+	for _, c := range certs {
+		if c.Authority || c.Certificate == nil {
+			continue
+		}
+
+		keys = append(keys, PKCS15PublicKey{
+			Label:     c.Label,
+			ID:        c.ID,
+			Raw:       c.Certificate.RawSubjectPublicKeyInfo,
+			PublicKey: c.Certificate.PublicKey,
+		})
+	}
+
+	return keys, nil
+}
+
+func newPKCS15Certificate(label string, id []byte, authority bool, raw []byte) PKCS15Certificate {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		cert = nil
+	}
+
+	return PKCS15Certificate{
+		Label:       label,
+		ID:          id,
+		Authority:   authority,
+		Raw:         raw,
+		Certificate: cert,
+	}
+}
+
+func parsePKCS15PublicKey(raw []byte) crypto.PublicKey {
+	if pub, err := x509.ParsePKIXPublicKey(raw); err == nil {
+		return pub
+	}
+
+	if pub, err := x509.ParsePKCS1PublicKey(raw); err == nil {
+		return pub
+	}
+
+	return nil
+}
+
+// selectPath selects a file by path, absolute paths start with the MF (3F00).
+func selectPath(tx SCTx, path []byte) error {
+	p1 := byte(selectPathFromDF)
+
+	if bytes.HasPrefix(path, pathMF) {
+		p1 = selectPathFromMF
+		path = path[len(pathMF):]
+	}
+
+	_, err := tx.Transmit(apdu{
+		instruction: insSelectApplication,
+		param1:      p1,
+		param2:      selectNoResponse,
+		data:        path,
+	})
+	if err != nil {
+		return fmt.Errorf("selecting file %x: %w", path, err)
+	}
+
+	return nil
+}
+
+// readPath selects and reads a file by path.
+func readPath(tx SCTx, path []byte) ([]byte, error) {
+	err := selectPath(tx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return readSelected(tx)
+}
+
+// readFile selects and reads a file, how fileID is interpreted depends on p1.
+func readFile(tx SCTx, p1 byte, fileID []byte) ([]byte, error) {
+	_, err := tx.Transmit(apdu{
+		instruction: insSelectApplication,
+		param1:      p1,
+		param2:      selectNoResponse,
+		data:        fileID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("selecting file %x: %w", fileID, err)
+	}
+
+	return readSelected(tx)
+}
+
+// readSelected reads the selected file, falling back to READ RECORD for record files.
+func readSelected(tx SCTx) ([]byte, error) {
+	data, err := readBinary(tx, func(offset int) apdu {
+		return apdu{
+			instruction: insReadBinary,
+			param1:      byte(offset >> 8),
+			param2:      byte(offset),
+		}
+	})
+	if apduStatus(err) == 0x6981 {
+		// command incompatible with file structure.
+		return readRecords(tx)
+	}
+
+	return data, err
+}
+
+// readFileOdd reads a file by file id with the odd READ BINARY instruction the SmartCard-HSM uses.
+func readFileOdd(tx SCTx, fileID []byte) ([]byte, error) {
+	return readBinary(tx, func(offset int) apdu {
+		return apdu{
+			instruction: insReadBinaryOdd,
+			param1:      fileID[0],
+			param2:      fileID[1],
+			data:        marshalASN1(tagOffsetData, []byte{byte(offset >> 8), byte(offset)}),
+		}
+	})
+}
+
+// readBinary reads a file in chunks until the card returns a short chunk or reports the end of the file.
+func readBinary(tx SCTx, cmd func(offset int) apdu) ([]byte, error) {
+	var data []byte
+
+	for len(data) <= maxReadBinaryOffset {
+		resp, err := tx.Transmit(cmd(len(data)))
+		if err != nil {
+			// wrong P1-P2 or end of file reached means we read past the end.
+			if st := apduStatus(err); len(data) > 0 && (st == 0x6b00 || st == 0x6282) {
+				break
+			}
+
+			return nil, fmt.Errorf("reading file at offset %d: %w", len(data), err)
+		}
+
+		data = append(data, resp...)
+
+		if len(resp) < readBinaryChunk {
+			break
+		}
+	}
+
+	return data, nil
+}
+
+// readRecords reads all records of the selected record file and concatenates them.
+func readRecords(tx SCTx) ([]byte, error) {
+	var data []byte
+
+	for record := 1; record < 0xff; record++ {
+		resp, err := tx.Transmit(apdu{
+			instruction: insReadRecord,
+			param1:      byte(record),
+			param2:      readRecordCurrent,
+		})
+		if err != nil {
+			// record not found.
+			if apduStatus(err) == 0x6a83 || errors.Is(err, ErrNotFound) {
+				break
+			}
+
+			return nil, fmt.Errorf("reading record %d: %w", record, err)
+		}
+
+		data = append(data, resp...)
+	}
+
+	return data, nil
+}
+
+// apduStatus returns the status word of err, or 0 if it isn't an apduErr.
+func apduStatus(err error) uint16 {
+	var e *apduErr
+	if errors.As(err, &e) {
+		return e.Status()
+	}
+
+	return 0
+}
+
+// asn1Elements splits data into its DER elements, stopping at 00 or FF padding.
+func asn1Elements(data []byte) ([]asn1.RawValue, error) {
+	var elements []asn1.RawValue
+
+	for len(data) > 0 && data[0] != 0x00 && data[0] != 0xff {
+		var v asn1.RawValue
+
+		rest, err := asn1.Unmarshal(data, &v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPKCS15Malformed, err)
+		}
+
+		elements = append(elements, v)
+		data = rest
+	}
+
+	return elements, nil
+}
+
+// trimDER removes the padding after the first DER element of a file.
+func trimDER(data []byte) []byte {
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &v); err != nil {
+		return data
+	}
+
+	return v.FullBytes
+}
+
+// directValue returns the DER of a directly encoded value, which may be tagged implicitly or explicitly.
+func directValue(v asn1.RawValue) []byte {
+	var inner asn1.RawValue
+	if rest, err := asn1.Unmarshal(v.Bytes, &inner); err == nil && len(rest) == 0 {
+		return inner.FullBytes
+	}
+
+	// implicitly tagged, replace the context tag with a SEQUENCE.
+	der := append([]byte{}, v.FullBytes...)
+	der[0] = 0x30
+
+	return der
+}
+
+// parseEFDIR parses the application templates (61) in EF.DIR.
+// ISO/IEC 7816-4 8.2.1.1 EF.DIR.
+func parseEFDIR(data []byte) ([]PKCS15Application, error) {
+	templates, err := asn1Elements(data)
+	if err != nil {
+		return nil, fmt.Errorf("EF.DIR: %w", err)
+	}
+
+	var apps []PKCS15Application
+
+	for _, t := range templates {
+		if t.Class != asn1.ClassApplication || t.Tag != 0x01 {
+			continue
+		}
+
+		fields, err := asn1Elements(t.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("EF.DIR: %w", err)
+		}
+
+		var app PKCS15Application
+
+		for _, f := range fields {
+			if f.Class != asn1.ClassApplication {
+				continue
+			}
+
+			switch f.Tag {
+			case 0x0f:
+				app.AID = f.Bytes
+			case 0x10:
+				app.Label = string(f.Bytes)
+			case 0x11:
+				app.Path = f.Bytes
+			}
+		}
+
+		apps = append(apps, app)
+	}
+
+	return apps, nil
+}
+
+// parseODF returns the paths in EF.ODF by choice.
+func parseODF(data []byte) (map[int][][]byte, error) {
+	entries, err := asn1Elements(data)
+	if err != nil {
+		return nil, fmt.Errorf("EF.ODF: %w", err)
+	}
+
+	odf := map[int][][]byte{}
+
+	for _, e := range entries {
+		if e.Class != asn1.ClassContextSpecific {
+			continue
+		}
+
+		var path pkcs15Path
+		if _, err := asn1.Unmarshal(e.Bytes, &path); err != nil {
+			// only paths are supported, not objects stored directly in the ODF.
+			continue
+		}
+
+		odf[e.Tag] = append(odf[e.Tag], path.Path)
+	}
+
+	return odf, nil
+}
+
+// parseTokenInfo parses EF.TokenInfo.
+// PKCS #15 v1.1 6.9 TokenInfo.
+func parseTokenInfo(data []byte) (*PKCS15TokenInfo, error) {
+	var ti pkcs15TokenInfo
+	if _, err := asn1.Unmarshal(data, &ti); err != nil {
+		return nil, fmt.Errorf("%w: EF.TokenInfo: %w", ErrPKCS15Malformed, err)
+	}
+
+	return &PKCS15TokenInfo{
+		Version:        ti.Version,
+		SerialNumber:   hex.EncodeToString(ti.SerialNumber),
+		ManufacturerID: ti.ManufacturerID,
+		Label:          ti.Label,
+		ReadOnly:       ti.TokenFlags.At(tokenFlagReadOnly) == 1,
+		LoginRequired:  ti.TokenFlags.At(tokenFlagLoginRequired) == 1,
+	}, nil
+}
+
+// parsePKCS15Objects parses the objects in a directory file.
+func parsePKCS15Objects(data []byte) ([]pkcs15Object, error) {
+	entries, err := asn1Elements(data)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]pkcs15Object, 0, len(entries))
+
+	for _, e := range entries {
+		fields, err := asn1Elements(e.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%w: object has %d fields", ErrPKCS15Malformed, len(fields))
+		}
+
+		var common pkcs15CommonObjectAttributes
+		if _, err := asn1.Unmarshal(fields[0].FullBytes, &common); err != nil {
+			return nil, fmt.Errorf("%w: common object attributes: %w", ErrPKCS15Malformed, err)
+		}
+
+		o := pkcs15Object{
+			label: common.Label,
+			class: fields[1].FullBytes,
+		}
+
+		// the subclass attributes [0] are optional, the type attributes are [1].
+		typeAttributes := fields[len(fields)-1]
+		if typeAttributes.Class != asn1.ClassContextSpecific || typeAttributes.Tag != 1 {
+			return nil, fmt.Errorf("%w: missing type attributes", ErrPKCS15Malformed)
+		}
+
+		var attrs asn1.RawValue
+		if _, err := asn1.Unmarshal(typeAttributes.Bytes, &attrs); err != nil {
+			return nil, fmt.Errorf("%w: type attributes: %w", ErrPKCS15Malformed, err)
+		}
+
+		values, err := asn1Elements(attrs.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(values) > 0 {
+			o.value = values[0]
+		}
+
+		objects = append(objects, o)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,281 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func derTLV(tb testing.TB, class, tag int, compound bool, content ...[]byte) []byte {
+	tb.Helper()
+
+	b, err := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: compound, Bytes: bytes.Join(content, nil)})
+	if err != nil {
+		tb.Fatalf("marshal: %v", err)
+	}
+
+	return b
+}
+
+func derSequence(tb testing.TB, content ...[]byte) []byte {
+	tb.Helper()
+
+	return derTLV(tb, asn1.ClassUniversal, asn1.TagSequence, true, content...)
+}
+
+func derOctets(tb testing.TB, b []byte) []byte {
+	tb.Helper()
+
+	return derTLV(tb, asn1.ClassUniversal, asn1.TagOctetString, false, b)
+}
+
+func derUTF8(tb testing.TB, s string) []byte {
+	tb.Helper()
+
+	return derTLV(tb, asn1.ClassUniversal, asn1.TagUTF8String, false, []byte(s))
+}
+
+func pkcs15TestCertificate(tb testing.TB) *x509.Certificate {
+	tb.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("generating key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "pkcs15 test"},
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		tb.Fatalf("creating certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		tb.Fatalf("parsing certificate: %v", err)
+	}
+
+	return cert
+}
+
+func readBinaryAPDU(offset int) apdu {
+	return apdu{instruction: insReadBinary, param1: byte(offset >> 8), param2: byte(offset)}
+}
+
+func TestPKCS15_Open(t *testing.T) {
+	t.Parallel()
+
+	cert := pkcs15TestCertificate(t)
+	if len(cert.Raw) <= readBinaryChunk {
+		t.Fatalf("certificate should need two reads, it is %d bytes", len(cert.Raw))
+	}
+
+	efDir := append(derTLV(t, asn1.ClassApplication, 0x01, true,
+		derTLV(t, asn1.ClassApplication, 0x0f, false, aidPKCS15[:]),
+		derTLV(t, asn1.ClassApplication, 0x10, false, []byte("PKCS15")),
+		derTLV(t, asn1.ClassApplication, 0x11, false, []byte{0x3f, 0x00, 0x50, 0x15}),
+	), 0x00, 0x00)
+
+	odf := append(derTLV(t, asn1.ClassContextSpecific, odfCertificates, true, derSequence(t, derOctets(t, []byte{0x3f, 0x00, 0x50, 0x15, 0x44, 0x04}))),
+		derTLV(t, asn1.ClassContextSpecific, odfPublicKeys, true, derSequence(t, derOctets(t, []byte{0x44, 0x05})))...)
+
+	tokenInfo := derSequence(t,
+		derTLV(t, asn1.ClassUniversal, asn1.TagInteger, false, []byte{0x00}),
+		derOctets(t, []byte{0x12, 0x34}),
+		derUTF8(t, "Example Corp"),
+		derTLV(t, asn1.ClassContextSpecific, 0, false, []byte("badge")),
+		derTLV(t, asn1.ClassUniversal, asn1.TagBitString, false, []byte{0x06, 0x40}),
+	)
+
+	cdf := derSequence(t,
+		derSequence(t, derUTF8(t, "badge certificate")),
+		derSequence(t, derOctets(t, []byte{0x01})),
+		derTLV(t, asn1.ClassContextSpecific, 1, true, derSequence(t, derSequence(t, derOctets(t, []byte{0x3f, 0x00, 0x50, 0x15, 0x48, 0x01})))),
+	)
+
+	pukdf := derSequence(t,
+		derSequence(t, derUTF8(t, "badge key")),
+		derSequence(t, derOctets(t, []byte{0x02})),
+		derTLV(t, asn1.ClassContextSpecific, 1, true, derSequence(t, derTLV(t, asn1.ClassContextSpecific, 0, true, cert.RawSubjectPublicKeyInfo))),
+	)
+
+	selectFile := func(p1 byte, data ...byte) apdu {
+		return apdu{instruction: insSelectApplication, param1: p1, param2: selectNoResponse, data: data}
+	}
+
+	c := CreateTestClient(t, nil, nil, &TestSCHandle{
+		Ctx: &TestSCTx{
+			APDUList: []apdu{
+				selectFile(selectPathFromMF, 0x2f, 0x00),
+				readBinaryAPDU(0),
+				selectFile(selectPathFromMF, 0x50, 0x15),
+				selectFile(selectEFUnderDF, 0x50, 0x31),
+				readBinaryAPDU(0),
+				selectFile(selectEFUnderDF, 0x50, 0x32),
+				readBinaryAPDU(0),
+				selectFile(selectPathFromMF, 0x50, 0x15, 0x44, 0x04),
+				readBinaryAPDU(0),
+				selectFile(selectPathFromMF, 0x50, 0x15, 0x48, 0x01),
+				readBinaryAPDU(0),
+				readBinaryAPDU(readBinaryChunk),
+				selectFile(selectPathFromDF, 0x44, 0x05),
+				readBinaryAPDU(0),
+			},
+			ResponseList: [][]byte{
+				{}, efDir,
+				{},
+				{}, odf,
+				{}, tokenInfo,
+				{}, cdf,
+				{}, cert.Raw[:readBinaryChunk], cert.Raw[readBinaryChunk:],
+				{}, pukdf,
+			},
+		},
+	})
+
+	p, err := c.OpenPKCS15("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if p.SmartCardHSM() {
+		t.Errorf("expected a PKCS#15 file system")
+	}
+
+	apps := p.Applications()
+	if len(apps) != 1 || apps[0].Label != "PKCS15" {
+		t.Errorf("unexpected applications %+v", apps)
+	}
+
+	ti, err := p.TokenInfo()
+	expectedError(t, err, nil)
+
+	expected := PKCS15TokenInfo{SerialNumber: "1234", ManufacturerID: "Example Corp", Label: "badge", LoginRequired: true}
+	if ti == nil || *ti != expected {
+		t.Errorf("got token info %+v expected %+v", ti, expected)
+	}
+
+	certs, err := p.Certificates()
+	expectedError(t, err, nil)
+
+	if len(certs) != 1 || certs[0].Label != "badge certificate" || certs[0].Certificate == nil ||
+		!bytes.Equal(certs[0].Certificate.Raw, cert.Raw) {
+		t.Errorf("unexpected certificates %+v", certs)
+	}
+
+	keys, err := p.PublicKeys()
+	expectedError(t, err, nil)
+
+	if len(keys) != 1 || keys[0].Label != "badge key" || !bytes.Equal(keys[0].ID, []byte{0x02}) {
+		t.Fatalf("unexpected public keys %+v", keys)
+	}
+
+	if pub, ok := keys[0].PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(cert.PublicKey) {
+		t.Errorf("unexpected public key %+v", keys[0].PublicKey)
+	}
+}
+
+func TestPKCS15_SmartCardHSM(t *testing.T) {
+	t.Parallel()
+
+	cert := pkcs15TestCertificate(t)
+
+	prkd := derSequence(t,
+		derSequence(t, derUTF8(t, "hsm key")),
+		derSequence(t, derOctets(t, []byte{0x0a, 0x0b})),
+		derTLV(t, asn1.ClassContextSpecific, 1, true, derSequence(t, derSequence(t, derOctets(t, []byte{0xcc, 0x01})))),
+	)
+
+	readOdd := func(fid []byte, offset int) apdu {
+		return apdu{instruction: insReadBinaryOdd, param1: fid[0], param2: fid[1], data: []byte{tagOffsetData, 0x02, byte(offset >> 8), byte(offset)}}
+	}
+
+	objects := []byte{0xcc, 0x01, 0xc4, 0x01, 0xce, 0x01}
+
+	c := CreateTestClient(t, nil, nil, &TestSCHandle{
+		Ctx: &TestSCTx{
+			APDUList: []apdu{
+				{instruction: insSelectApplication, param1: selectPathFromMF, param2: selectNoResponse, data: fileEFDIR},
+				{instruction: insSelectApplication, param1: 0x04, data: aidPKCS15[:]},
+				{instruction: insSelectApplication, param1: 0x04, data: aidSmartCardHSM[:]},
+				{instruction: insSCHSMEnumerateObjects},
+				readOdd([]byte{0xce, 0x01}, 0),
+				readOdd([]byte{0xce, 0x01}, readBinaryChunk),
+				readOdd([]byte{0xc4, 0x01}, 0),
+				{instruction: insSCHSMEnumerateObjects},
+			},
+			ResponseList: [][]byte{
+				nil, nil, {},
+				objects, cert.Raw[:readBinaryChunk], cert.Raw[readBinaryChunk:], prkd,
+				objects,
+			},
+			TransmitErr: []error{&apduErr{0x6a, 0x82}, &apduErr{0x6a, 0x82}},
+		},
+	})
+
+	p, err := c.OpenPKCS15("")
+	if !expectedError(t, err, nil) {
+		t.FailNow()
+	}
+
+	if !p.SmartCardHSM() {
+		t.Errorf("expected a SmartCard-HSM")
+	}
+
+	keys, err := p.PublicKeys()
+	expectedError(t, err, nil)
+
+	if len(keys) != 1 || keys[0].Label != "hsm key" || !bytes.Equal(keys[0].ID, []byte{0x0a, 0x0b}) {
+		t.Fatalf("unexpected public keys %+v", keys)
+	}
+
+	if !bytes.Equal(keys[0].Raw, cert.RawSubjectPublicKeyInfo) {
+		t.Errorf("unexpected public key %x", keys[0].Raw)
+	}
+}
+
+func TestPKCS15_NotFound(t *testing.T) {
+	t.Parallel()
+
+	c := CreateTestClient(t, nil, nil, &TestSCHandle{
+		Ctx: &TestSCTx{
+			APDUList: []apdu{
+				{instruction: insSelectApplication, param1: selectPathFromMF, param2: selectNoResponse, data: fileEFDIR},
+				{instruction: insSelectApplication, param1: 0x04, data: aidPKCS15[:]},
+				{instruction: insSelectApplication, param1: 0x04, data: aidSmartCardHSM[:]},
+			},
+			ResponseList: [][]byte{nil, nil, nil},
+			TransmitErr:  []error{&apduErr{0x6a, 0x82}, &apduErr{0x6a, 0x82}, &apduErr{0x6a, 0x82}},
+		},
+	})
+
+	_, err := c.OpenPKCS15("")
+	expectedError(t, err, ErrPKCS15NotFound)
+}
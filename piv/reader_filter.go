@@ -0,0 +1,248 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrMultipleCards is returned by ReaderPolicyError when more than one reader matches.
+var ErrMultipleCards = errors.New("multiple matching cards")
+
+// ReaderPolicy decides which card is used when several readers match a ReaderFilter.
+type ReaderPolicy int
+
+const (
+	// ReaderPolicyFirst uses the first matching reader, in the order the smart card daemon lists them.
+	ReaderPolicyFirst ReaderPolicy = iota
+	// ReaderPolicyNewestSerial uses the card with the highest serial, the most recently made card.
+	// Every matching card is connected to for its serial.
+	ReaderPolicyNewestSerial
+	// ReaderPolicyError fails with ErrMultipleCards, the user has to narrow the filter.
+	ReaderPolicyError
+)
+
+// nolint:gochecknoglobals
+var readerPolicyNames = map[ReaderPolicy]string{
+	ReaderPolicyFirst:        "first",
+	ReaderPolicyNewestSerial: "newest-serial",
+	ReaderPolicyError:        "error",
+}
+
+func (p ReaderPolicy) String() string {
+	if name, ok := readerPolicyNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ReaderPolicy(%d)", int(p))
+}
+
+// ParseReaderPolicy parses the name of a policy: first, newest-serial or error.
+func ParseReaderPolicy(s string) (ReaderPolicy, error) {
+	for p, name := range readerPolicyNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown reader policy %q, expected first, newest-serial or error", s)
+}
+
+// ReaderFilter selects the reader to use by name, so a kiosk-style machine with an embedded reader
+// doesn't pick the wrong card. The zero value matches every reader and uses the first.
+type ReaderFilter struct {
+	// Allow are regular expressions of the reader names to use, all readers are allowed if it's empty.
+	Allow []string
+	// Deny are regular expressions of reader names that are never used, even when allowed.
+	Deny []string
+	// Policy decides between several matching readers.
+	Policy ReaderPolicy
+}
+
+// Matches reports whether reader is allowed and not denied, an error is returned for an invalid pattern.
+func (f ReaderFilter) Matches(reader string) (bool, error) {
+	allowed, err := matchAny(f.Allow, reader)
+	if err != nil {
+		return false, fmt.Errorf("reader allow list: %w", err)
+	}
+
+	denied, err := matchAny(f.Deny, reader)
+	if err != nil {
+		return false, fmt.Errorf("reader deny list: %w", err)
+	}
+
+	return (allowed || len(f.Allow) == 0) && !denied, nil
+}
+
+func matchAny(patterns []string, s string) (bool, error) {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+
+		if re.MatchString(s) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// SelectReader returns the reader chosen by f, see Client.SelectReader.
+func SelectReader(f ReaderFilter) (string, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.SelectReader(f)
+}
+
+// OpenWithReaderFilter connects to the PIV applet of the card chosen by f using opts.
+func OpenWithReaderFilter(f ReaderFilter, opts OpenOptions) (*YubiKey, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenWithReaderFilter(f, opts)
+}
+
+// OpenGPGWithReaderFilter connects to the OpenPGP applet of the card chosen by f.
+func OpenGPGWithReaderFilter(f ReaderFilter) (*GPGYubiKey, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenGPGWithReaderFilter(f)
+}
+
+// SelectReader returns the reader with a card that f matches, empty readers are skipped.
+// ErrNoMatchingCard is returned if there's none, when several match f.Policy decides.
+func (c *Client) SelectReader(f ReaderFilter) (string, error) {
+	if c.SCConstruct == nil {
+		cc := *c
+		cc.SCConstruct = &PCSCConstructor{}
+		c = &cc
+	}
+
+	readers, err := c.filterReaders(f)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case len(readers) == 0:
+		return "", ErrNoMatchingCard
+	case len(readers) == 1 || f.Policy == ReaderPolicyFirst:
+		return readers[0], nil
+	case f.Policy == ReaderPolicyError:
+		return "", fmt.Errorf("%w: %s", ErrMultipleCards, strings.Join(readers, ", "))
+	case f.Policy == ReaderPolicyNewestSerial:
+		return c.newestSerial(readers)
+	default:
+		return "", fmt.Errorf("unknown reader policy %s", f.Policy)
+	}
+}
+
+// OpenWithReaderFilter connects to the PIV applet of the card chosen by f using opts.
+func (c Client) OpenWithReaderFilter(f ReaderFilter, opts OpenOptions) (*YubiKey, error) {
+	reader, err := c.SelectReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.OpenWithOptions(reader, opts)
+}
+
+// OpenGPGWithReaderFilter connects to the OpenPGP applet of the card chosen by f.
+func (c *Client) OpenGPGWithReaderFilter(f ReaderFilter) (*GPGYubiKey, error) {
+	reader, err := c.SelectReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.OpenGPG(reader)
+}
+
+// filterReaders lists the readers f matches that have a card.
+func (c *Client) filterReaders(f ReaderFilter) ([]string, error) {
+	ctx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+	defer ctx.Close()
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		return nil, fmt.Errorf("listing readers: %w", err)
+	}
+
+	matches := make([]string, 0, len(readers))
+
+	for _, reader := range readers {
+		ok, err := f.Matches(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		raw, err := ctx.ATR(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading ATR of %s: %w", reader, err)
+		}
+
+		if raw != nil {
+			matches = append(matches, reader)
+		}
+	}
+
+	return matches, nil
+}
+
+// newestSerial returns the reader of the card with the highest serial, cards that can't be read are skipped.
+func (c *Client) newestSerial(readers []string) (string, error) {
+	var (
+		newest  CardInfo
+		lastErr error
+	)
+
+	for _, reader := range readers {
+		info := CardInfo{Reader: reader}
+		if err := c.readCardInfo(&info); err != nil {
+			lastErr = fmt.Errorf("%s: %w", reader, err)
+
+			continue
+		}
+
+		if newest.Reader == "" || info.Serial > newest.Serial {
+			newest = info
+		}
+	}
+
+	if newest.Reader == "" {
+		return "", fmt.Errorf("%w: %w", ErrNoMatchingCard, lastErr)
+	}
+
+	return newest.Reader, nil
+}
@@ -0,0 +1,102 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestClient_SelectReader(t *testing.T) {
+	t.Parallel()
+
+	c := pivtest.NewClient(
+		pivtest.NewCard(pivtest.Options{Serial: 100}),
+		pivtest.NewCard(pivtest.Options{Serial: 300}),
+		pivtest.NewCard(pivtest.Options{Serial: 200}),
+	)
+
+	tests := []struct {
+		name      string
+		filter    piv.ReaderFilter
+		expected  string
+		expectErr error
+	}{
+		{name: "zero value", expected: pivtest.Reader(0)},
+		{name: "allow", filter: piv.ReaderFilter{Allow: []string{`02$`}}, expected: pivtest.Reader(2)},
+		{name: "deny", filter: piv.ReaderFilter{Deny: []string{`00$`, `01$`}}, expected: pivtest.Reader(2)},
+		{
+			name:     "deny wins",
+			filter:   piv.ReaderFilter{Allow: []string{`0[01]$`}, Deny: []string{`00$`}, Policy: piv.ReaderPolicyError},
+			expected: pivtest.Reader(1),
+		},
+		{name: "newest serial", filter: piv.ReaderFilter{Policy: piv.ReaderPolicyNewestSerial}, expected: pivtest.Reader(1)},
+		{name: "error policy", filter: piv.ReaderFilter{Policy: piv.ReaderPolicyError}, expectErr: piv.ErrMultipleCards},
+		{name: "no match", filter: piv.ReaderFilter{Allow: []string{`Nitrokey`}}, expectErr: piv.ErrNoMatchingCard},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, err := c.SelectReader(tc.filter)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("got %v expected %v", err, tc.expectErr)
+			}
+
+			if reader != tc.expected {
+				t.Errorf("got %q expected %q", reader, tc.expected)
+			}
+		})
+	}
+
+	if _, err := c.SelectReader(piv.ReaderFilter{Deny: []string{`(`}}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+
+	yk, err := c.OpenGPGWithReaderFilter(piv.ReaderFilter{Allow: []string{`02$`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer yk.Close()
+
+	data, err := yk.GPGData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Reader != pivtest.Reader(2) {
+		t.Errorf("opened %q expected %q", data.Reader, pivtest.Reader(2))
+	}
+}
+
+func TestParseReaderPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []piv.ReaderPolicy{piv.ReaderPolicyFirst, piv.ReaderPolicyNewestSerial, piv.ReaderPolicyError} {
+		if parsed, err := piv.ParseReaderPolicy(p.String()); err != nil || parsed != p {
+			t.Errorf("%s parsed as %s, %v", p, parsed, err)
+		}
+	}
+
+	if _, err := piv.ParseReaderPolicy("last"); err == nil {
+		t.Error("unknown policy parsed")
+	}
+}
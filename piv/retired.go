@@ -0,0 +1,155 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// insMoveKey moves a key between slots, or deletes it when the destination is keyDeleted.
+// https://docs.yubico.com/yesdk/users-manual/application-piv/apdu/move-key.html
+const (
+	insMoveKey = 0xf6
+	keyDeleted = 0xff
+)
+
+// ErrNoRetiredSlot is returned by RotateKeyManagementKey when all retired slots have a key.
+var ErrNoRetiredSlot = errors.New("no empty retired key management slot")
+
+// RetiredKeyManagementSlots returns the 20 retired slots, 82 to 95, in order.
+func RetiredKeyManagementSlots() []Slot {
+	slots := make([]Slot, 0, len(retiredKeyManagementSlots))
+	for _, slot := range retiredKeyManagementSlots {
+		slots = append(slots, slot)
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Key < slots[j].Key })
+
+	return slots
+}
+
+// Retired is true for the retired key management slots.
+func (s Slot) Retired() bool {
+	_, ok := retiredKeyManagementSlots[s.Key]
+	return ok
+}
+
+// MoveKey moves the private key of from into to, replacing the key there. The certificates stay,
+// move them with Certificate and SetCertificate. It requires firmware 5.7.0, see SupportsKeyMove.
+func (yk *YubiKey) MoveKey(key [24]byte, from, to Slot) error {
+	if !yk.Features().SupportsKeyMove() {
+		return fmt.Errorf("moving keys: %w", ErrNotSupportedByCard)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	return ykMoveKey(yk.tx, from, byte(to.Key))
+}
+
+// DeleteKey deletes the private key of slot, the certificate stays. It requires firmware 5.7.0.
+func (yk *YubiKey) DeleteKey(key [24]byte, slot Slot) error {
+	if !yk.Features().SupportsKeyMove() {
+		return fmt.Errorf("deleting keys: %w", ErrNotSupportedByCard)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	return ykMoveKey(yk.tx, slot, keyDeleted)
+}
+
+func ykMoveKey(tx SCTx, from Slot, to byte) error {
+	cmd := apdu{
+		instruction: insMoveKey,
+		param1:      to,
+		param2:      byte(from.Key),
+	}
+	if _, err := tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
+
+// RotateKeyManagementKey retires the key of SlotKeyManagement and generates a new one with opts.
+// The old key and its certificate move to the first retired slot without a key, so data encrypted
+// to it can still be decrypted. It returns that slot and the new public key, and requires firmware 5.7.0.
+func (yk *YubiKey) RotateKeyManagementKey(key [24]byte, opts Key) (Slot, crypto.PublicKey, error) {
+	if !yk.Features().SupportsKeyMove() {
+		return Slot{}, nil, fmt.Errorf("rotating key management key: %w", ErrNotSupportedByCard)
+	}
+
+	retired, err := yk.emptyRetiredSlot()
+	if err != nil {
+		return Slot{}, nil, err
+	}
+
+	cert, err := yk.Certificate(SlotKeyManagement)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Slot{}, nil, fmt.Errorf("reading certificate: %w", err)
+	}
+
+	if err := yk.authManagementKey(key); err != nil {
+		return Slot{}, nil, fmt.Errorf("authenticating with management key: %w", err)
+	}
+
+	if err := ykMoveKey(yk.tx, SlotKeyManagement, byte(retired.Key)); err != nil {
+		return Slot{}, nil, fmt.Errorf("moving key to slot %s: %w", retired, err)
+	}
+
+	if cert != nil {
+		if err := ykStoreCertificate(yk.tx, retired, cert); err != nil {
+			return Slot{}, nil, fmt.Errorf("moving certificate to slot %s: %w", retired, err)
+		}
+
+		if err := ykDeleteObject(yk.tx, SlotKeyManagement.Object); err != nil {
+			return Slot{}, nil, fmt.Errorf("deleting certificate: %w", err)
+		}
+	}
+
+	pub, err := ykGenerateKey(yk.tx, SlotKeyManagement, opts)
+	if err != nil {
+		return retired, nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	return retired, pub, nil
+}
+
+// emptyRetiredSlot returns the first retired slot whose metadata says it has no key.
+func (yk *YubiKey) emptyRetiredSlot() (Slot, error) {
+	for _, slot := range RetiredKeyManagementSlots() {
+		_, err := yk.KeyInfo(slot)
+		if errors.Is(err, ErrNotFound) {
+			return slot, nil
+		}
+
+		if err != nil {
+			return Slot{}, fmt.Errorf("reading metadata of slot %s: %w", slot, err)
+		}
+	}
+
+	return Slot{}, ErrNoRetiredSlot
+}
+
+// ykDeleteObject writes an empty object, which removes it.
+func ykDeleteObject(tx SCTx, object uint32) error {
+	return ykPutObject(tx, object, nil)
+}
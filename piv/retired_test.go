@@ -0,0 +1,138 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestRetiredKeyManagementSlots(t *testing.T) {
+	t.Parallel()
+
+	slots := piv.RetiredKeyManagementSlots()
+	if len(slots) != 20 {
+		t.Fatalf("got %d slots, expected 20", len(slots))
+	}
+
+	for i, slot := range slots {
+		if slot.Key != uint32(0x82+i) || slot.Object != uint32(0x5fc10d+i) || !slot.Retired() {
+			t.Errorf("slot %d: %+v", i, slot)
+		}
+	}
+
+	if piv.SlotKeyManagement.Retired() {
+		t.Errorf("9d is not retired")
+	}
+}
+
+func openYubiKeyVersion(t *testing.T, v piv.Version) *piv.YubiKey {
+	t.Helper()
+
+	card := pivtest.NewCard(pivtest.Options{Version: v})
+
+	yk, err := pivtest.NewClient(card).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	return yk
+}
+
+func TestYubiKey_RotateKeyManagementKey(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 7, Patch: 0})
+	mk := piv.DefaultManagementKey
+	opts := piv.Key{Algorithm: piv.AlgorithmEC256, PINPolicy: piv.PINPolicyNever, TouchPolicy: piv.TouchPolicyNever}
+
+	first, err := yk.GenerateKey(mk, piv.SlotKeyManagement, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := testIssue(t, "old", first, testIssue(t, "ca", caKey.Public(), nil, caKey), caKey)
+	if err := yk.SetCertificate(mk, piv.SlotKeyManagement, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first retired slot is taken, the key goes to the second.
+	if _, err := yk.GenerateKey(mk, piv.RetiredKeyManagementSlots()[0], opts); err != nil {
+		t.Fatal(err)
+	}
+
+	retired, pub, err := yk.RotateKeyManagementKey(mk, opts)
+	if err != nil {
+		t.Fatalf("rotating: %v", err)
+	}
+
+	if retired != piv.RetiredKeyManagementSlots()[1] {
+		t.Errorf("key moved to slot %s, expected 83", retired)
+	}
+
+	if pub.(*ecdsa.PublicKey).Equal(first) {
+		t.Errorf("9d still has the old key")
+	}
+
+	info, err := yk.KeyInfo(retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.PublicKey.(*ecdsa.PublicKey).Equal(first) {
+		t.Errorf("slot %s doesn't have the old key", retired)
+	}
+
+	moved, err := yk.Certificate(retired)
+	if err != nil || !moved.Equal(cert) {
+		t.Errorf("certificate of slot %s: %v", retired, err)
+	}
+
+	if _, err := yk.Certificate(piv.SlotKeyManagement); !errors.Is(err, piv.ErrNotFound) {
+		t.Errorf("certificate of 9d: %v", err)
+	}
+
+	if err := yk.DeleteKey(mk, retired); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+
+	if _, err := yk.KeyInfo(retired); !errors.Is(err, piv.ErrNotFound) {
+		t.Errorf("metadata of deleted key: %v", err)
+	}
+}
+
+func TestYubiKey_MoveKey_NotSupported(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+
+	err := yk.MoveKey(piv.DefaultManagementKey, piv.SlotKeyManagement, piv.RetiredKeyManagementSlots()[0])
+	if !errors.Is(err, piv.ErrNotSupportedByCard) {
+		t.Errorf("expected ErrNotSupportedByCard, got %v", err)
+	}
+}
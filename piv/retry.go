@@ -0,0 +1,212 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	rcSharingViolation int64 = 0x8010000B // SCARD_E_SHARING_VIOLATION
+	rcNoService        int64 = 0x8010001D // SCARD_E_NO_SERVICE
+)
+
+// RetryPolicy retries the steps of opening a card that fail while another program uses the card or
+// the card is still being inserted, instead of failing the whole flow.
+// The commands sent to the card aren't retried, they may not be safe to repeat, and a card that
+// was reset or removed must be opened again, its handle keeps failing.
+type RetryPolicy struct {
+	// MaxAttempts is how often a step is tried, including the first time. Zero or one doesn't retry.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, it doubles with every retry.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between retries, if it's set.
+	MaxBackoff time.Duration
+	// Retryable reports whether an error is transient, DefaultRetryable if it's nil.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryable reports whether err is a PC/SC error that usually goes away: no card in the reader yet,
+// another program holding the card exclusively, a reset card, or the smart card service not running yet.
+func DefaultRetryable(err error) bool {
+	var sc *scErr
+	if !errors.As(err, &sc) {
+		return false
+	}
+
+	switch sc.rc {
+	case rcNoSmartcard, rcSharingViolation, rcResetCard, rcNoService:
+		return true
+	}
+
+	return false
+}
+
+// do calls f until it succeeds, fails with an error that isn't retryable, or MaxAttempts are used.
+func (p RetryPolicy) do(f func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	backoff := p.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// NewRetryConstructor returns an SCConstructor that retries the steps of construct with policy:
+// establishing the context, connecting, and beginning transactions.
+//
+//	c := piv.Client{SCConstruct: piv.NewRetryConstructor(&piv.PCSCConstructor{}, piv.RetryPolicy{
+//		MaxAttempts: 5,
+//		Backoff:     100 * time.Millisecond,
+//	})}
+//
+// nolint:ireturn
+func NewRetryConstructor(construct SCConstructor, policy RetryPolicy) SCConstructor {
+	return &retryConstructor{construct: construct, policy: policy}
+}
+
+type retryConstructor struct {
+	construct SCConstructor
+	policy    RetryPolicy
+}
+
+type retryContext struct {
+	SCContext
+	policy RetryPolicy
+}
+
+type retryHandle struct {
+	SCHandle
+	policy RetryPolicy
+}
+
+type retryTx struct {
+	SCTx
+	policy RetryPolicy
+}
+
+var (
+	_ SCConstructor    = (*retryConstructor)(nil)
+	_ optionsContext   = (*retryContext)(nil)
+	_ TransactionTx    = (*retryTx)(nil)
+	_ ExtendedLengthTx = (*retryTx)(nil)
+)
+
+// nolint:ireturn
+func (r *retryConstructor) NewSCContext() (SCContext, error) {
+	var ctx SCContext
+
+	err := r.policy.do(func() error {
+		var err error
+		ctx, err = r.construct.NewSCContext()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryContext{SCContext: ctx, policy: r.policy}, nil
+}
+
+// nolint:ireturn
+func (c *retryContext) Connect(reader string) (SCHandle, error) {
+	return c.connectWithOptions(reader, OpenOptions{})
+}
+
+// nolint:ireturn
+func (c *retryContext) connectWithOptions(reader string, opts OpenOptions) (SCHandle, error) {
+	var h SCHandle
+
+	err := c.policy.do(func() error {
+		var err error
+		h, err = connectWithOptions(c.SCContext, reader, opts)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryHandle{SCHandle: h, policy: c.policy}, nil
+}
+
+// nolint:ireturn
+func (h *retryHandle) Begin() (SCTx, error) {
+	var tx SCTx
+
+	err := h.policy.do(func() error {
+		var err error
+		tx, err = h.SCHandle.Begin()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryTx{SCTx: tx, policy: h.policy}, nil
+}
+
+func (t *retryTx) BeginTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	return t.policy.do(tx.BeginTransaction)
+}
+
+func (t *retryTx) EndTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	return tx.EndTransaction()
+}
+
+func (t *retryTx) SetExtendedLength(enabled bool) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetExtendedLength(enabled)
+	}
+}
+
+func (t *retryTx) ExtendedLength() bool {
+	e, ok := t.SCTx.(ExtendedLengthTx)
+
+	return ok && e.ExtendedLength()
+}
+
+func (t *retryTx) SetMaxCommandLength(n int) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetMaxCommandLength(n)
+	}
+}
@@ -0,0 +1,97 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryConstructor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		err       error
+		failures  int
+		attempts  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"sharing violation", &scErr{rc: rcSharingViolation}, 2, 5, 3, false},
+		{"no smartcard", &scErr{rc: rcNoSmartcard}, 1, 2, 2, false},
+		{"gives up", &scErr{rc: rcSharingViolation}, 5, 3, 3, true},
+		{"not retryable", &scErr{rc: rcRemovedCard}, 1, 5, 1, true},
+		{"no policy", &scErr{rc: rcSharingViolation}, 1, 0, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			construct := &TestSCConstructor{Ctx: TestSCContext{ConnectFunc: func(string) (SCHandle, error) {
+				calls++
+				if calls <= test.failures {
+					return nil, test.err
+				}
+
+				return &TestSCHandle{Ctx: &TestSCTx{}}, nil
+			}}}
+
+			ctx, err := NewRetryConstructor(construct, RetryPolicy{
+				MaxAttempts: test.attempts,
+				Backoff:     time.Millisecond,
+				MaxBackoff:  2 * time.Millisecond,
+			}).NewSCContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ctx.Connect("reader")
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v", err)
+			}
+
+			if err != nil && !errors.Is(err, test.err) {
+				t.Errorf("got %v expected %v", err, test.err)
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("connected %d times expected %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	errBusy := errors.New("busy")
+
+	err := RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(err error) bool { return errors.Is(err, errBusy) },
+	}.do(func() error {
+		calls++
+
+		return errBusy
+	})
+	if !errors.Is(err, errBusy) || calls != 3 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+}
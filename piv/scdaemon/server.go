@@ -0,0 +1,628 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scdaemon serves the OpenPGP applet of a card to gpg-agent with the Assuan protocol of
+// scdaemon, so gpg can use the card through this package when scdaemon and other programs fight
+// over the card. Point scdaemon-program in gpg-agent.conf at a program that calls Serve on
+// stdin and stdout.
+//
+// Only the commands gpg-agent needs to use the keys of a card are served: SERIALNO, READKEY,
+// SETDATA, PKSIGN, PKAUTH and PKDECRYPT, plus the Assuan housekeeping. Keys are referenced as
+// OPENPGP.1 (signature), OPENPGP.2 (decryption) and OPENPGP.3 (authentication), keygrips aren't
+// supported. The PIN is asked from gpg-agent with INQUIRE NEEDPIN, which runs pinentry.
+// https://www.gnupg.org/documentation/manuals/gnupg/Scdaemon-Protocol.html
+package scdaemon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// maxLineLength is the longest Assuan line, including the newline.
+const maxLineLength = 1000
+
+// applicationIDTag is the application identifier in the application related data.
+const applicationIDTag = "6E.4F"
+
+// errSourceSCD is the libgpg-error source of scdaemon, in the top byte of the error codes.
+const errSourceSCD = 6 << 24
+
+// libgpg-error codes of the errors returned to gpg-agent.
+// https://github.com/gpg/libgpg-error/blob/master/src/err-codes.h.in
+const (
+	errGeneral      = 1
+	errInvValue     = 55
+	errNoData       = 58
+	errNotSupported = 60
+	errBadPIN       = 87
+	errCanceled     = 99
+	errCard         = 108
+	errUnknownCmd   = 275
+)
+
+// ErrCanceled is returned by the PIN prompt when gpg-agent cancels the inquiry.
+var ErrCanceled = errors.New("scdaemon: canceled")
+
+// assuanError is sent to the client as ERR code description.
+type assuanError struct {
+	code int
+	err  error
+}
+
+func (e *assuanError) Error() string {
+	return e.err.Error()
+}
+
+func (e *assuanError) Unwrap() error {
+	return e.err
+}
+
+func errorf(code int, format string, args ...any) error {
+	return &assuanError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errorCode maps the errors of the card to libgpg-error codes.
+func errorCode(err error) int {
+	var (
+		ae      *assuanError
+		authErr piv.AuthErr
+	)
+
+	switch {
+	case errors.As(err, &ae):
+		return ae.code
+	case errors.Is(err, ErrCanceled):
+		return errCanceled
+	case errors.As(err, &authErr), errors.Is(err, piv.ErrPINRequired), errors.Is(err, piv.ErrPINBlocked):
+		return errBadPIN
+	case errors.Is(err, piv.ErrNotSupportedByCard):
+		return errNotSupported
+	case errors.Is(err, piv.ErrKeyNotPresent), errors.Is(err, piv.ErrDecryptionFailed),
+		errors.Is(err, piv.ErrSecurityStatusNotSatisfied), errors.Is(err, piv.ErrConditionsNotSatisfied):
+		return errCard
+	default:
+		return errGeneral
+	}
+}
+
+// Server serves one card, a session is one connection of gpg-agent.
+type Server struct {
+	yk *piv.GPGYubiKey
+}
+
+// New returns a server for the OpenPGP applet yk.
+func New(yk *piv.GPGYubiKey) *Server {
+	return &Server{yk: yk}
+}
+
+// session is the state of one Assuan connection.
+type session struct {
+	s *Server
+	r *bufio.Reader
+	w *bufio.Writer
+	// data is the input of the next PKSIGN, PKAUTH or PKDECRYPT, from SETDATA.
+	data []byte
+}
+
+type handler func(sess *session, args string) error
+
+// nolint:gochecknoglobals
+var handlers = map[string]handler{
+	"NOP":       func(*session, string) error { return nil },
+	"OPTION":    func(*session, string) error { return nil },
+	"RESET":     (*session).reset,
+	"RESTART":   (*session).reset,
+	"GETINFO":   (*session).getInfo,
+	"SERIALNO":  (*session).serialNo,
+	"READKEY":   (*session).readKey,
+	"SETDATA":   (*session).setData,
+	"PKSIGN":    (*session).pkSign,
+	"PKAUTH":    (*session).pkAuth,
+	"PKDECRYPT": (*session).pkDecrypt,
+}
+
+// Serve runs a session on r and w until the client sends BYE or closes r, which isn't an error.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	sess := &session{s: s, r: bufio.NewReaderSize(r, maxLineLength), w: bufio.NewWriter(w)}
+
+	if err := sess.writeLine("OK Pleased to meet you"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := sess.readLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// comments and empty lines are ignored.
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		cmd, args, _ := strings.Cut(line, " ")
+		cmd = strings.ToUpper(cmd)
+
+		if cmd == "BYE" || cmd == "KILLSCD" {
+			return sess.writeLine("OK closing connection")
+		}
+
+		h, ok := handlers[cmd]
+		if !ok {
+			err = errorf(errUnknownCmd, "Unknown IPC command")
+		} else {
+			err = h(sess, strings.TrimSpace(args))
+		}
+
+		if err != nil {
+			err = sess.writeLine(fmt.Sprintf("ERR %d %s <SCD>", errSourceSCD|errorCode(err), escapeLine(err.Error())))
+		} else {
+			err = sess.writeLine("OK")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sess *session) readLine() (string, error) {
+	line, err := sess.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("scdaemon: line longer than %d bytes", maxLineLength)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (sess *session) writeLine(line string) error {
+	sess.w.WriteString(line)
+	sess.w.WriteByte('\n')
+
+	return sess.w.Flush()
+}
+
+// writeData sends b in D lines, escaped and split to fit maxLineLength.
+func (sess *session) writeData(b []byte) error {
+	line := []byte("D ")
+
+	for _, c := range b {
+		if len(line) > maxLineLength-5 {
+			if err := sess.writeLine(string(line)); err != nil {
+				return err
+			}
+
+			line = []byte("D ")
+		}
+
+		switch c {
+		case '%', '\r', '\n':
+			line = fmt.Appendf(line, "%%%02X", c)
+		default:
+			line = append(line, c)
+		}
+	}
+
+	return sess.writeLine(string(line))
+}
+
+// escapeLine escapes the characters that can't be in an Assuan line.
+func escapeLine(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// unescape decodes the %XX escapes of data lines.
+func unescape(s string) ([]byte, error) {
+	var b []byte
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+
+			continue
+		}
+
+		if i+2 >= len(s) {
+			return nil, errorf(errInvValue, "truncated escape")
+		}
+
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return nil, errorf(errInvValue, "invalid escape %q", s[i:i+3])
+		}
+
+		b = append(b, c[0])
+		i += 2
+	}
+
+	return b, nil
+}
+
+// inquire asks the client for data with INQUIRE keyword args and returns its D lines until END.
+func (sess *session) inquire(keyword, args string) ([]byte, error) {
+	if err := sess.writeLine(fmt.Sprintf("INQUIRE %s %s", keyword, args)); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+
+	for {
+		line, err := sess.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case line == "END":
+			return data, nil
+		case line == "CAN":
+			return nil, ErrCanceled
+		case strings.HasPrefix(line, "D "):
+			b, err := unescape(line[2:])
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, b...)
+		default:
+			return nil, errorf(errInvValue, "unexpected response to inquiry: %q", line)
+		}
+	}
+}
+
+// auth asks gpg-agent for the PIN when the card needs it.
+func (sess *session) auth() piv.OpenPGPKeyAuth {
+	return piv.OpenPGPKeyAuth{
+		PINPrompt: func() ([]byte, error) {
+			// pinentry may pad the PIN with a NUL.
+			pin, err := sess.inquire("NEEDPIN", "||Please enter the PIN")
+
+			return bytes.TrimRight(pin, "\x00"), err
+		},
+	}
+}
+
+func (sess *session) reset(string) error {
+	sess.data = nil
+
+	return nil
+}
+
+func (sess *session) getInfo(args string) error {
+	switch args {
+	case "version":
+		return sess.writeData([]byte("piv-go"))
+	case "app_list":
+		return sess.writeData([]byte("openpgp\n"))
+	default:
+		return errorf(errNotSupported, "unknown GETINFO %q", args)
+	}
+}
+
+// serialNo sends the application identifier of the card, which gpg uses as the serial number.
+func (sess *session) serialNo(string) error {
+	data, err := sess.s.yk.GPGData()
+	if err != nil {
+		return err
+	}
+
+	aid, err := data.GetTag(applicationIDTag, 16)
+	if err != nil {
+		return err
+	}
+
+	return sess.writeLine(fmt.Sprintf("S SERIALNO %X", aid))
+}
+
+// keyRef parses the key reference of a command, such as OPENPGP.1, options before it are skipped.
+func keyRef(args string) (piv.KeyType, error) {
+	fields := strings.Fields(args)
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		fields = fields[1:]
+	}
+
+	if len(fields) != 1 {
+		return 0, errorf(errInvValue, "expected a key reference")
+	}
+
+	// older gpg-agents prefix the serial number.
+	ref := strings.ToUpper(fields[0])
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		ref = ref[i+1:]
+	}
+
+	switch ref {
+	case "OPENPGP.1":
+		return piv.SignatureKey, nil
+	case "OPENPGP.2":
+		return piv.DecryptionKey, nil
+	case "OPENPGP.3":
+		return piv.AuthenticationKey, nil
+	default:
+		return 0, errorf(errInvValue, "unsupported key reference %q", fields[0])
+	}
+}
+
+// option returns the value of --name=value in args.
+func option(args, name string) (string, bool) {
+	for _, f := range strings.Fields(args) {
+		if v, ok := strings.CutPrefix(f, "--"+name+"="); ok {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+func (sess *session) readKey(args string) error {
+	slot, err := keyRef(args)
+	if err != nil {
+		return err
+	}
+
+	var pub crypto.PublicKey
+
+	if slot == piv.DecryptionKey {
+		dec, err := sess.s.yk.OpenPGPDecrypter(piv.OpenPGPKeyAuth{})
+		if err != nil {
+			return err
+		}
+
+		pub = dec.Public()
+	} else {
+		pub, err = sess.s.yk.ReadOpenPGPPublicKey(slot)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := publicKeySexp(pub)
+	if err != nil {
+		return errorf(errNotSupported, "%w", err)
+	}
+
+	return sess.writeData(b)
+}
+
+func (sess *session) setData(args string) error {
+	appendData := false
+	if rest, ok := strings.CutPrefix(args, "--append"); ok {
+		appendData, args = true, strings.TrimSpace(rest)
+	}
+
+	b, err := hex.DecodeString(args)
+	if err != nil {
+		return errorf(errInvValue, "invalid hex data")
+	}
+
+	if appendData {
+		sess.data = append(sess.data, b...)
+	} else {
+		sess.data = b
+	}
+
+	return nil
+}
+
+// takeData returns the data of SETDATA, it's used by one command.
+func (sess *session) takeData() ([]byte, error) {
+	data := sess.data
+	sess.data = nil
+
+	if len(data) == 0 {
+		return nil, errorf(errNoData, "no data, SETDATA first")
+	}
+
+	return data, nil
+}
+
+func (sess *session) pkSign(args string) error {
+	slot, err := keyRef(args)
+	if err != nil {
+		return err
+	}
+
+	if slot == piv.DecryptionKey {
+		return errorf(errInvValue, "can't sign with the decryption key")
+	}
+
+	return sess.sign(slot, args)
+}
+
+// pkAuth signs with the authentication key, like PKSIGN OPENPGP.3.
+func (sess *session) pkAuth(args string) error {
+	return sess.sign(piv.AuthenticationKey, args)
+}
+
+// hashes are the --hash values of PKSIGN, with the DigestInfo prefixes of --hash=none.
+// nolint:gochecknoglobals
+var hashes = []struct {
+	name   string
+	hash   crypto.Hash
+	prefix []byte
+}{
+	{"sha1", crypto.SHA1, []byte{0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14}},
+	{"sha224", crypto.SHA224, []byte{0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c}},
+	{"sha256", crypto.SHA256, []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}},
+	{"sha384", crypto.SHA384, []byte{0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30}},
+	{"sha512", crypto.SHA512, []byte{0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40}},
+}
+
+// signInput returns the digest and hash of data for an RSA or ECDSA key. Without --hash the hash
+// is guessed from the length, with --hash=none RSA data is a DigestInfo.
+func signInput(args string, data []byte) ([]byte, crypto.Hash, error) {
+	name, ok := option(args, "hash")
+
+	for _, h := range hashes {
+		switch {
+		case name == "none" && bytes.HasPrefix(data, h.prefix) && len(data) == len(h.prefix)+h.hash.Size():
+			return data[len(h.prefix):], h.hash, nil
+		case name == h.name && len(data) == h.hash.Size(), !ok && len(data) == h.hash.Size():
+			return data, h.hash, nil
+		}
+	}
+
+	return nil, 0, errorf(errInvValue, "unsupported hash %q of %d bytes", name, len(data))
+}
+
+func (sess *session) sign(slot piv.KeyType, args string) error {
+	data, err := sess.takeData()
+	if err != nil {
+		return err
+	}
+
+	priv, err := sess.s.yk.OpenPGPPrivateKey(slot, sess.auth())
+	if err != nil {
+		return err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return errorf(errNotSupported, "%s key can't sign", slot)
+	}
+
+	var sig []byte
+
+	switch pub := signer.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = signer.Sign(nil, data, crypto.Hash(0))
+	case *ecdsa.PublicKey:
+		var hash crypto.Hash
+
+		data, hash, err = signInput(args, data)
+		if err != nil {
+			return err
+		}
+
+		sig, err = signer.Sign(nil, data, hash)
+		if err == nil {
+			sig, err = ecdsaRawSignature(pub, sig)
+		}
+	case *rsa.PublicKey:
+		var hash crypto.Hash
+
+		data, hash, err = signInput(args, data)
+		if err != nil {
+			return err
+		}
+
+		sig, err = signer.Sign(nil, data, hash)
+	default:
+		return errorf(errNotSupported, "unsupported key %T", pub)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return sess.writeData(sig)
+}
+
+// ecdsaRawSignature converts an ASN.1 signature to r || s, the signature format of the card that gpg-agent expects.
+func ecdsaRawSignature(pub *ecdsa.PublicKey, sig []byte) ([]byte, error) {
+	var rs struct {
+		R, S *big.Int
+	}
+
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, fmt.Errorf("ecdsa signature: %w", err)
+	}
+
+	size := (pub.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	rs.R.FillBytes(raw[:size])
+	rs.S.FillBytes(raw[size:])
+
+	return raw, nil
+}
+
+// pkDecrypt decrypts with the decryption key. RSA returns the plaintext without padding,
+// ECDH the shared secret, prefixed with 0x40 for Curve25519.
+func (sess *session) pkDecrypt(args string) error {
+	slot, err := keyRef(args)
+	if err != nil {
+		return err
+	}
+
+	if slot != piv.DecryptionKey {
+		return errorf(errInvValue, "only the decryption key decrypts")
+	}
+
+	data, err := sess.takeData()
+	if err != nil {
+		return err
+	}
+
+	dec, err := sess.s.yk.OpenPGPDecrypter(sess.auth())
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+
+	switch pub := dec.Public().(type) {
+	case *rsa.PublicKey:
+		// gpg-agent may send the ciphertext with a leading zero.
+		if len(data) > pub.Size() && data[0] == 0 {
+			data = data[1:]
+		}
+
+		if out, err = dec.Decrypt(nil, data, nil); err != nil {
+			return err
+		}
+
+		if err := sess.writeLine("S PADDING 0"); err != nil {
+			return err
+		}
+	case *ecdh.PublicKey:
+		x25519 := pub.Curve() == ecdh.X25519()
+		if x25519 && len(data) == 33 && data[0] == nativePointPrefix {
+			data = data[1:]
+		}
+
+		if out, err = dec.Decrypt(nil, data, nil); err != nil {
+			return err
+		}
+
+		if x25519 {
+			out = append([]byte{nativePointPrefix}, out...)
+		}
+	default:
+		return errorf(errNotSupported, "unsupported key %T", pub)
+	}
+
+	return sess.writeData(out)
+}
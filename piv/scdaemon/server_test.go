@@ -0,0 +1,347 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scdaemon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// newCard generates Ed25519 signature, X25519 decryption and P-256 authentication keys on a simulated card.
+func newCard(t *testing.T) *piv.GPGYubiKey {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	for keyType, alg := range map[piv.KeyType]piv.Algorithm{
+		piv.SignatureKey:      piv.AlgorithmEd25519,
+		piv.DecryptionKey:     piv.AlgorithmEd25519,
+		piv.AuthenticationKey: piv.AlgorithmEC256,
+	} {
+		if _, err := yk.GenerateOpenPGPKey(keyType, alg); err != nil {
+			t.Fatalf("generate %s: %v", keyType, err)
+		}
+	}
+
+	// reload the algorithm attributes of the new keys.
+	if _, err := yk.GPGData(); err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	return yk
+}
+
+// client is the gpg-agent end of a session.
+type client struct {
+	t   *testing.T
+	r   *bufio.Reader
+	w   net.Conn
+	pin string
+}
+
+// newClient serves yk on a pipe and returns a client that read the greeting.
+func newClient(t *testing.T, yk *piv.GPGYubiKey) *client {
+	t.Helper()
+
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- New(yk).Serve(server, server)
+		server.Close()
+	}()
+
+	t.Cleanup(func() {
+		conn.Close()
+
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+
+	c := &client{t: t, r: bufio.NewReader(conn), w: conn, pin: pivtest.DefaultPIN}
+	if line := c.readLine(); line != "OK Pleased to meet you" {
+		t.Fatalf("greeting %q", line)
+	}
+
+	return c
+}
+
+func (c *client) readLine() string {
+	c.t.Helper()
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+
+	return strings.TrimSuffix(line, "\n")
+}
+
+func (c *client) writeLine(line string) {
+	c.t.Helper()
+
+	if _, err := fmt.Fprintf(c.w, "%s\n", line); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// transact sends cmd and returns its status lines and data, or the ERR line. PIN inquiries are
+// answered with c.pin, or canceled when it's empty.
+func (c *client) transact(cmd string) (status []string, data []byte, errLine string) {
+	c.t.Helper()
+	c.writeLine(cmd)
+
+	for {
+		line := c.readLine()
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return status, data, ""
+		case strings.HasPrefix(line, "ERR "):
+			return status, data, line
+		case strings.HasPrefix(line, "S "):
+			status = append(status, line[2:])
+		case strings.HasPrefix(line, "D "):
+			b, err := unescape(line[2:])
+			if err != nil {
+				c.t.Fatalf("data line %q: %v", line, err)
+			}
+
+			data = append(data, b...)
+		case strings.HasPrefix(line, "INQUIRE NEEDPIN "):
+			if c.pin == "" {
+				c.writeLine("CAN")
+			} else {
+				c.writeLine("D " + c.pin)
+				c.writeLine("END")
+			}
+		default:
+			c.t.Fatalf("unexpected line %q", line)
+		}
+	}
+}
+
+// ok runs cmd and fails the test on an error.
+func (c *client) ok(cmd string) ([]string, []byte) {
+	c.t.Helper()
+
+	status, data, errLine := c.transact(cmd)
+	if errLine != "" {
+		c.t.Fatalf("%s: %s", cmd, errLine)
+	}
+
+	return status, data
+}
+
+func errLinePrefix(code int) string {
+	return fmt.Sprintf("ERR %d ", errSourceSCD|code)
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	yk := newCard(t)
+	c := newClient(t, yk)
+
+	t.Run("SERIALNO", func(t *testing.T) {
+		data, err := yk.GPGData()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		aid, err := data.GetTag(applicationIDTag, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, _ := c.ok("SERIALNO")
+		if expected := fmt.Sprintf("SERIALNO %X", aid); len(status) != 1 || status[0] != expected {
+			t.Errorf("status %q expected %q", status, expected)
+		}
+	})
+
+	t.Run("READKEY", func(t *testing.T) {
+		pub, err := yk.ReadOpenPGPPublicKey(piv.SignatureKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := publicKeySexp(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, data := c.ok("READKEY OPENPGP.1"); !bytes.Equal(data, expected) {
+			t.Errorf("key %q expected %q", data, expected)
+		}
+
+		if _, data := c.ok("READKEY --advanced OPENPGP.2"); !bytes.Contains(data, []byte("10:Curve25519")) {
+			t.Errorf("decryption key %q", data)
+		}
+	})
+
+	t.Run("PKSIGN", func(t *testing.T) {
+		pub, err := yk.ReadOpenPGPPublicKey(piv.SignatureKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message := []byte("attack at dawn")
+		c.ok("SETDATA " + hex.EncodeToString(message[:6]))
+		c.ok("SETDATA --append " + hex.EncodeToString(message[6:]))
+
+		_, sig := c.ok("PKSIGN OPENPGP.1")
+		if !ed25519.Verify(pub.(ed25519.PublicKey), message, sig) {
+			t.Error("signature doesn't verify")
+		}
+	})
+
+	t.Run("PKAUTH", func(t *testing.T) {
+		pub, err := yk.ReadOpenPGPPublicKey(piv.AuthenticationKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		digest := sha256.Sum256([]byte("attack at dawn"))
+		c.ok("SETDATA " + hex.EncodeToString(digest[:]))
+
+		_, sig := c.ok("PKAUTH OPENPGP.3")
+		if len(sig) != 64 {
+			t.Fatalf("signature of %d bytes", len(sig))
+		}
+
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub.(*ecdsa.PublicKey), digest[:], r, s) {
+			t.Error("signature doesn't verify")
+		}
+	})
+
+	t.Run("PKDECRYPT", func(t *testing.T) {
+		dec, err := yk.OpenPGPDecrypter(piv.OpenPGPKeyAuth{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ephemeral, secret, err := piv.ECDHKeyAgreement(rand.Reader, dec.Public().(*ecdh.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.ok(fmt.Sprintf("SETDATA 40%X", ephemeral.Bytes()))
+
+		_, data := c.ok("PKDECRYPT OPENPGP.2")
+		if expected := append([]byte{nativePointPrefix}, secret...); !bytes.Equal(data, expected) {
+			t.Errorf("secret %X expected %X", data, expected)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for cmd, code := range map[string]int{
+			"FROBNICATE":          errUnknownCmd,
+			"PKSIGN OPENPGP.1":    errNoData,
+			"READKEY OPENPGP.4":   errInvValue,
+			"SETDATA xyz":         errInvValue,
+			"PKDECRYPT OPENPGP.1": errInvValue,
+		} {
+			if _, _, errLine := c.transact(cmd); !strings.HasPrefix(errLine, errLinePrefix(code)) {
+				t.Errorf("%s: %q expected code %d", cmd, errLine, code)
+			}
+		}
+
+		// the session continues after errors.
+		c.ok("NOP")
+	})
+}
+
+func TestServer_PINCanceled(t *testing.T) {
+	t.Parallel()
+
+	c := newClient(t, newCard(t))
+	c.pin = ""
+
+	c.ok("SETDATA 00")
+
+	if _, _, errLine := c.transact("PKSIGN OPENPGP.1"); !strings.HasPrefix(errLine, errLinePrefix(errCanceled)) {
+		t.Errorf("canceled PIN: %q", errLine)
+	}
+
+	c.ok("RESET")
+
+	if _, _, errLine := c.transact("PKSIGN OPENPGP.1"); !strings.HasPrefix(errLine, errLinePrefix(errNoData)) {
+		t.Errorf("after RESET: %q", errLine)
+	}
+
+	c.writeLine("BYE")
+
+	if line := c.readLine(); line != "OK closing connection" {
+		t.Errorf("BYE: %q", line)
+	}
+}
+
+func TestWriteData(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("%\n\r0123456789"), 500)
+
+	var buf bytes.Buffer
+
+	sess := &session{w: bufio.NewWriter(&buf)}
+	if err := sess.writeData(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []byte
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if len(line)+1 > maxLineLength {
+			t.Errorf("line of %d bytes", len(line)+1)
+		}
+
+		b, err := unescape(strings.TrimPrefix(line, "D "))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded = append(decoded, b...)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Error("data doesn't round trip")
+	}
+}
@@ -0,0 +1,125 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scdaemon
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// nativePointPrefix prefixes the Ed25519 and Curve25519 points in the s-expressions of libgcrypt.
+const nativePointPrefix = 0x40
+
+// sexp builds a canonical s-expression, the encoding of keys between scdaemon and gpg-agent.
+// https://people.csail.mit.edu/rivest/Sexp.txt
+type sexp struct {
+	bytes.Buffer
+}
+
+func (s *sexp) atom(b []byte) {
+	s.WriteString(strconv.Itoa(len(b)))
+	s.WriteByte(':')
+	s.Write(b)
+}
+
+// list writes (name value...) with the values as atoms.
+func (s *sexp) list(name string, values ...[]byte) {
+	s.WriteByte('(')
+	s.atom([]byte(name))
+
+	for _, v := range values {
+		s.atom(v)
+	}
+
+	s.WriteByte(')')
+}
+
+// mpi is an unsigned integer of libgcrypt, a leading zero keeps it from being negative.
+func mpi(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	return b
+}
+
+// curveName returns the libgcrypt name of a curve.
+func curveName(c elliptic.Curve) string {
+	switch name := c.Params().Name; name {
+	case "P-256", "P-384", "P-521":
+		return "NIST " + name
+	default:
+		return name
+	}
+}
+
+// publicKeySexp returns the public-key s-expression of READKEY.
+func publicKeySexp(pub crypto.PublicKey) ([]byte, error) {
+	var s sexp
+
+	s.WriteByte('(')
+	s.atom([]byte("public-key"))
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		s.WriteByte('(')
+		s.atom([]byte("rsa"))
+		s.list("n", mpi(pub.N))
+		s.list("e", mpi(big.NewInt(int64(pub.E))))
+		s.WriteByte(')')
+	case *ecdsa.PublicKey:
+		s.WriteByte('(')
+		s.atom([]byte("ecc"))
+		s.list("curve", []byte(curveName(pub.Curve)))
+		s.list("q", elliptic.Marshal(pub.Curve, pub.X, pub.Y)) // nolint:staticcheck
+		s.WriteByte(')')
+	case ed25519.PublicKey:
+		s.WriteByte('(')
+		s.atom([]byte("ecc"))
+		s.list("curve", []byte("Ed25519"))
+		s.list("flags", []byte("eddsa"))
+		s.list("q", append([]byte{nativePointPrefix}, pub...))
+		s.WriteByte(')')
+	case *ecdh.PublicKey:
+		s.WriteByte('(')
+		s.atom([]byte("ecc"))
+
+		if pub.Curve() == ecdh.X25519() {
+			s.list("curve", []byte("Curve25519"))
+			s.list("flags", []byte("djb-tweak"))
+			s.list("q", append([]byte{nativePointPrefix}, pub.Bytes()...))
+		} else {
+			s.list("curve", []byte(fmt.Sprintf("NIST %s", pub.Curve())))
+			s.list("q", pub.Bytes())
+		}
+
+		s.WriteByte(')')
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", pub)
+	}
+
+	s.WriteByte(')')
+
+	return s.Bytes(), nil
+}
@@ -0,0 +1,439 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// PIV secure messaging and the virtual contact interface (VCI) of SP 800-73-4 Part 2, 4.
+// The host and the card agree on session keys with the one-pass ECDH key establishment of
+// the card's static SM key, then every command is encrypted and MACed. Over NFC, PIN protected
+// operations need the VCI, which is secure messaging and the pairing code.
+// https://nvlpubs.nist.gov/nistpubs/SpecialPublications/NIST.SP.800-73-4.pdf#page=115
+
+// CipherSuite is the cipher suite of the key establishment, its value is P1 of GENERAL AUTHENTICATE.
+type CipherSuite byte
+
+const (
+	// CipherSuite2 is ECDH P-256 with AES-128 and SHA-256.
+	CipherSuite2 CipherSuite = 0x27
+	// CipherSuite7 is ECDH P-384 with AES-256 and SHA-384.
+	CipherSuite7 CipherSuite = 0x2e
+)
+
+const (
+	// keySecureMessaging is the key reference of the SM key of the card.
+	keySecureMessaging = 0x04
+	// keyPairingCode is the key reference of the pairing code.
+	keyPairingCode = 0x98
+	pairingCodeLen = 8
+
+	// smIDLen is the length of ID_sH and ID_sICC, smCryptogramLen of AuthCryptogram_ICC.
+	smIDLen         = 8
+	smCryptogramLen = 16
+	// smT16Len is the number of bytes of the ephemeral public key in OtherInfo.
+	smT16Len = 16
+)
+
+// smKeyConfirmation is the message prefix of the key confirmation MAC, SP 800-56A 5.9.1.
+var smKeyConfirmation = []byte("KC_1_V")
+
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherSuite2:
+		return "CS2"
+	case CipherSuite7:
+		return "CS7"
+	default:
+		return fmt.Sprintf("CipherSuite(%#x)", byte(c))
+	}
+}
+
+// smSuite are the parameters of a cipher suite.
+type smSuite struct {
+	curve ecdh.Curve
+	hash  func() hash.Hash
+	// keyLen is the length of the AES session keys, nonceLen of N_ICC.
+	keyLen   int
+	nonceLen int
+	// algID is repeated four times as the AlgorithmID of OtherInfo.
+	algID byte
+}
+
+func (c CipherSuite) params() (smSuite, error) {
+	switch c {
+	case CipherSuite2:
+		return smSuite{curve: ecdh.P256(), hash: sha256.New, keyLen: 16, nonceLen: 16, algID: 0x09}, nil
+	case CipherSuite7:
+		return smSuite{curve: ecdh.P384(), hash: sha512.New384, keyLen: 32, nonceLen: 24, algID: 0x0d}, nil
+	default:
+		return smSuite{}, fmt.Errorf("%w: %s: %w", ErrSecureMessaging, c, ErrNotSupportedByCard)
+	}
+}
+
+// SecureMessagingOptions configures StartSecureMessaging.
+type SecureMessagingOptions struct {
+	// CipherSuite is CipherSuite2 if zero.
+	CipherSuite CipherSuite
+	// HostID is ID_sH, which identifies the host to the card. It may be zero.
+	HostID [8]byte
+	// CVCSigner verifies the signature of the card verifiable certificate of the SM key, it's
+	// the key of the SM certificate signer of the card issuer. Without it the card isn't authenticated.
+	CVCSigner *ecdsa.PublicKey
+	// PairingCode establishes the virtual contact interface, see VerifyPairingCode.
+	PairingCode string
+}
+
+// StartSecureMessaging establishes secure messaging with the card, every following command is
+// encrypted and MACed with the session keys. The session lasts until the PIV applet is selected
+// again, so the transaction can't be ended. With a PairingCode the VCI is established too.
+func (yk *YubiKey) StartSecureMessaging(opts SecureMessagingOptions) error {
+	if _, ok := yk.tx.(*pivSMTx); ok {
+		return fmt.Errorf("%w: already started", ErrSecureMessaging)
+	}
+
+	tx, err := ykStartSecureMessaging(yk.tx, opts, yk.rand)
+	if err != nil {
+		return err
+	}
+
+	yk.tx = tx
+
+	if opts.PairingCode == "" {
+		return nil
+	}
+
+	return yk.VerifyPairingCode(opts.PairingCode)
+}
+
+// VerifyPairingCode establishes the virtual contact interface with the 8 digit pairing code,
+// after which PIN protected operations work over a contactless reader. It requires secure messaging.
+func (yk *YubiKey) VerifyPairingCode(code string) error {
+	if _, ok := yk.tx.(*pivSMTx); !ok {
+		return fmt.Errorf("%w: the pairing code needs secure messaging", ErrSecureMessaging)
+	}
+
+	if len(code) != pairingCodeLen {
+		return fmt.Errorf("pairing code must be %d digits", pairingCodeLen)
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("pairing code must be %d digits", pairingCodeLen)
+		}
+	}
+
+	cmd := apdu{
+		instruction: insVerify,
+		param2:      keyPairingCode,
+		data:        []byte(code),
+	}
+	if _, err := yk.tx.Transmit(cmd); err != nil {
+		return fmt.Errorf("verifying pairing code: %w", err)
+	}
+
+	return nil
+}
+
+// ykStartSecureMessaging runs the key establishment protocol, SP 800-73-4 Part 2, 4.1.
+func ykStartSecureMessaging(tx SCTx, opts SecureMessagingOptions, rand io.Reader) (*pivSMTx, error) {
+	cs := opts.CipherSuite
+	if cs == 0 {
+		cs = CipherSuite2
+	}
+
+	suite, err := cs.params()
+	if err != nil {
+		return nil, err
+	}
+
+	eph, err := suite.curve.GenerateKey(rand)
+	if err != nil {
+		return nil, fmt.Errorf("%w: generating ephemeral key: %w", ErrSecureMessaging, err)
+	}
+
+	// no persistent binding, CB_H is 0.
+	const cbH = 0x00
+
+	qeH := eph.PublicKey().Bytes()
+	data := smConcat([]byte{cbH}, opts.HostID[:], qeH)
+
+	cmd := apdu{
+		instruction: insAuthenticate,
+		param1:      byte(cs),
+		param2:      keySecureMessaging,
+		data:        marshalASN1(0x7c, append(marshalASN1(0x81, data), 0x82, 0x00)),
+	}
+	resp, err := tx.Transmit(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("key establishment: %w", err)
+	}
+
+	objs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parsing key establishment response: %w", ErrSecureMessaging, err)
+	}
+
+	obj, ok := bertlv.Find(objs, 0x7c, 0x82)
+	if !ok || len(obj.Value) < 1+suite.nonceLen+smCryptogramLen {
+		return nil, fmt.Errorf("%w: key establishment response too short", ErrSecureMessaging)
+	}
+
+	v := obj.Value
+	cbICC, nICC := v[0], v[1:1+suite.nonceLen]
+	cryptogram := v[1+suite.nonceLen : 1+suite.nonceLen+smCryptogramLen]
+
+	if cbICC != 0x00 {
+		return nil, fmt.Errorf("%w: persistent binding: %w", ErrSecureMessaging, ErrNotSupportedByCard)
+	}
+
+	cardKey, idICC, err := parseSMCVC(v[1+suite.nonceLen+smCryptogramLen:], suite, opts.CVCSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := eph.ECDH(cardKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+	}
+
+	otherInfo := smConcat(
+		[]byte{0x04, suite.algID, suite.algID, suite.algID, suite.algID},
+		[]byte{smIDLen}, opts.HostID[:],
+		[]byte{0x01, cbH},
+		[]byte{smT16Len}, qeH[:smT16Len],
+		[]byte{smIDLen}, idICC,
+		[]byte{byte(len(nICC))}, nICC,
+		[]byte{0x01, cbICC},
+	)
+
+	n := suite.keyLen
+	keys := smKDF(suite.hash, z, otherInfo, 4*n)
+
+	blocks := make([]cipher.Block, 4)
+	for i := range blocks {
+		if blocks[i], err = aes.NewCipher(keys[i*n : (i+1)*n]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+		}
+	}
+
+	// SK_CFRM is only used to confirm the card derived the same keys.
+	expected := smCMAC(blocks[0], smConcat(smKeyConfirmation, idICC, opts.HostID[:], qeH))
+	if subtle.ConstantTimeCompare(cryptogram, expected) != 1 {
+		return nil, fmt.Errorf("%w: key confirmation failed", ErrSecureMessaging)
+	}
+
+	return &pivSMTx{
+		SCTx:    tx,
+		mac:     blocks[1],
+		enc:     blocks[2],
+		rmac:    blocks[3],
+		counter: make([]byte, aes.BlockSize),
+		mcv:     make([]byte, aes.BlockSize),
+	}, nil
+}
+
+// parseSMCVC returns the public key and ID_sICC of the card verifiable certificate of the SM key,
+// SP 800-73-4 Part 2, Table 15. The signature is checked when signer isn't nil.
+func parseSMCVC(b []byte, suite smSuite, signer *ecdsa.PublicKey) (*ecdh.PublicKey, []byte, error) {
+	objs, err := bertlv.Unmarshal(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: parsing card verifiable certificate: %w", ErrSecureMessaging, err)
+	}
+
+	cvc, ok := bertlv.Find(objs, 0x7f21)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: no card verifiable certificate", ErrSecureMessaging)
+	}
+
+	subject, ok := bertlv.Find(cvc.Children, 0x5f20)
+	if !ok || len(subject.Value) < smIDLen {
+		return nil, nil, fmt.Errorf("%w: card verifiable certificate without subject", ErrSecureMessaging)
+	}
+
+	point, ok := bertlv.Find(cvc.Children, 0x7f49, 0x86)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: card verifiable certificate without public key", ErrSecureMessaging)
+	}
+
+	pub, err := suite.curve.NewPublicKey(point.Value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: card sm key: %w", ErrSecureMessaging, err)
+	}
+
+	if signer != nil {
+		var signed []bertlv.TLV
+
+		for _, obj := range cvc.Children {
+			if obj.Tag != 0x5f37 {
+				signed = append(signed, obj)
+			}
+		}
+
+		sig, ok := bertlv.Find(cvc.Children, 0x5f37)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: card verifiable certificate isn't signed", ErrSecureMessaging)
+		}
+
+		data, err := bertlv.Marshal(signed...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+		}
+
+		h := suite.hash()
+		h.Write(data)
+
+		if !ecdsa.VerifyASN1(signer, h.Sum(nil), sig.Value) {
+			return nil, nil, fmt.Errorf("%w: card verifiable certificate signature", ErrSecureMessaging)
+		}
+	}
+
+	return pub, subject.Value[:smIDLen], nil
+}
+
+// smKDF is the concatenation KDF of SP 800-56A 5.8.1 with a 32 bit counter.
+func smKDF(h func() hash.Hash, z, otherInfo []byte, n int) []byte {
+	var out []byte
+
+	for counter := uint32(1); len(out) < n; counter++ {
+		d := h()
+		d.Write([]byte{byte(counter >> 24), byte(counter >> 16), byte(counter >> 8), byte(counter)})
+		d.Write(z)
+		d.Write(otherInfo)
+		out = d.Sum(out)
+	}
+
+	return out[:n]
+}
+
+// pivSMTx protects every APDU with the secure messaging of SP 800-73-4 Part 2, 4.2. Unlike the
+// OpenPGP smTx, the IV is the encryption counter encrypted with SK_ENC, 80 in the first byte for
+// responses, and the command MACs are chained: each covers the MAC of the previous command.
+type pivSMTx struct {
+	SCTx
+	enc, mac, rmac cipher.Block
+	counter        []byte
+	// mcv is the MAC chaining value, the full C-MAC of the last command.
+	mcv []byte
+}
+
+var _ SCTx = (*pivSMTx)(nil)
+
+// Transmit sends d protected and returns the verified and decrypted response.
+func (s *pivSMTx) Transmit(d apdu) ([]byte, error) {
+	resp, err := s.SCTx.Transmit(s.wrap(d))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.unwrap(resp)
+}
+
+func (s *pivSMTx) wrap(d apdu) apdu {
+	for i := len(s.counter) - 1; i >= 0; i-- {
+		s.counter[i]++
+		if s.counter[i] != 0 {
+			break
+		}
+	}
+
+	var dos []byte
+
+	if len(d.data) > 0 {
+		out := smPad(d.data)
+		cipher.NewCBCEncrypter(s.enc, s.iv(0x00)).CryptBlocks(out, out)
+		dos = marshalASN1(smCryptogramTag, append([]byte{smPaddingIndicator}, out...))
+	}
+
+	dos = append(dos, smLeTag, 0x01, 0x00)
+
+	header := smPad([]byte{smClass, d.instruction, d.param1, d.param2})
+	s.mcv = smCMAC(s.mac, smConcat(s.mcv, header, smPad(dos)))
+
+	return apdu{
+		class:       smClass,
+		instruction: d.instruction,
+		param1:      d.param1,
+		param2:      d.param2,
+		data:        append(dos, marshalASN1(smMACTag, s.mcv[:smMACLen])...),
+	}
+}
+
+// unwrap checks the R-MAC of resp, returns the status in 99 as an error and decrypts 87.
+func (s *pivSMTx) unwrap(resp []byte) ([]byte, error) {
+	objs, err := bertlv.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecureMessaging, err)
+	}
+
+	status, ok := bertlv.Find(objs, smStatusTag)
+	mac, hasMAC := bertlv.Find(objs, smMACTag)
+
+	if !ok || len(status.Value) != 2 || !hasMAC || len(mac.Value) != smMACLen || objs[len(objs)-1].Tag != smMACTag {
+		return nil, fmt.Errorf("%w: response without status or mac", ErrSecureMessaging)
+	}
+
+	covered := resp[:len(resp)-2-smMACLen]
+
+	expected := smCMAC(s.rmac, smConcat(s.mcv, smPad(covered)))
+	if subtle.ConstantTimeCompare(mac.Value, expected[:smMACLen]) != 1 {
+		return nil, fmt.Errorf("%w: response mac mismatch", ErrSecureMessaging)
+	}
+
+	if status.Value[0] != 0x90 || status.Value[1] != 0x00 {
+		return nil, &apduErr{status.Value[0], status.Value[1]}
+	}
+
+	cryptogram, ok := bertlv.Find(objs, smCryptogramTag)
+	if !ok || len(cryptogram.Value) == 0 {
+		return nil, nil
+	}
+
+	data := cryptogram.Value
+	if data[0] != smPaddingIndicator {
+		return nil, fmt.Errorf("%w: padding indicator %02x", ErrSecureMessaging, data[0])
+	}
+
+	data = data[1:]
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: cryptogram length %d", ErrSecureMessaging, len(data))
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(s.enc, s.iv(0x80)).CryptBlocks(out, data)
+
+	return smUnpad(out)
+}
+
+// iv is the encryption counter, with first as its first byte, encrypted with SK_ENC.
+func (s *pivSMTx) iv(first byte) []byte {
+	in := append([]byte{first}, s.counter[1:]...)
+	iv := make([]byte, aes.BlockSize)
+	s.enc.Encrypt(iv, in)
+
+	return iv
+}
@@ -0,0 +1,118 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func openSMYubiKey(t *testing.T, opts pivtest.Options) (*piv.YubiKey, *pivtest.Card) {
+	t.Helper()
+
+	card := pivtest.NewCard(opts)
+
+	yk, err := pivtest.NewClient(card).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	return yk, card
+}
+
+func TestYubiKey_StartSecureMessaging_VCI(t *testing.T) {
+	t.Parallel()
+
+	for _, cs := range []piv.CipherSuite{piv.CipherSuite2, piv.CipherSuite7} {
+		t.Run(cs.String(), func(t *testing.T) {
+			t.Parallel()
+
+			yk, card := openSMYubiKey(t, pivtest.Options{Contactless: true})
+
+			pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotSignature,
+				piv.Key{Algorithm: piv.AlgorithmEC256, PINPolicy: piv.PINPolicyAlways, TouchPolicy: piv.TouchPolicyNever})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := yk.VerifyPIN(piv.DefaultPIN); !errors.Is(err, piv.ErrSecurityStatusNotSatisfied) {
+				t.Fatalf("verifying the pin without the vci: %v", err)
+			}
+
+			opts := piv.SecureMessagingOptions{CipherSuite: cs, CVCSigner: card.SMSigner(), PairingCode: pivtest.DefaultPairingCode}
+			if err := yk.StartSecureMessaging(opts); err != nil {
+				t.Fatalf("starting secure messaging: %v", err)
+			}
+
+			priv, err := yk.PrivateKey(piv.SlotSignature, pub, piv.KeyAuth{PIN: piv.DefaultPIN})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256([]byte("over nfc"))
+
+			sig, err := priv.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Fatalf("signing through the vci: %v", err)
+			}
+
+			if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+				t.Errorf("invalid signature")
+			}
+
+			if err := yk.EndTransaction(); !errors.Is(err, piv.ErrSecureMessaging) {
+				t.Errorf("ending the transaction: %v", err)
+			}
+		})
+	}
+}
+
+func TestYubiKey_StartSecureMessaging_Errors(t *testing.T) {
+	t.Parallel()
+
+	other, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yk, _ := openSMYubiKey(t, pivtest.Options{SecureMessaging: true})
+	if err := yk.StartSecureMessaging(piv.SecureMessagingOptions{CVCSigner: &other.PublicKey}); !errors.Is(err, piv.ErrSecureMessaging) {
+		t.Errorf("starting with the wrong signer: %v", err)
+	}
+
+	yk, _ = openSMYubiKey(t, pivtest.Options{SecureMessaging: true})
+	if err := yk.StartSecureMessaging(piv.SecureMessagingOptions{PairingCode: "87654321"}); err == nil {
+		t.Errorf("established the vci with the wrong pairing code")
+	}
+
+	yk, _ = openSMYubiKey(t, pivtest.Options{})
+	if err := yk.VerifyPairingCode(pivtest.DefaultPairingCode); !errors.Is(err, piv.ErrSecureMessaging) {
+		t.Errorf("pairing code without secure messaging: %v", err)
+	}
+
+	if err := yk.StartSecureMessaging(piv.SecureMessagingOptions{}); err == nil {
+		t.Errorf("started secure messaging with a YubiKey")
+	}
+}
@@ -0,0 +1,199 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrNoMatchingCard is returned when no card matches a selector.
+	ErrNoMatchingCard = errors.New("no matching card")
+	// ErrInvalidFingerprint is returned for a fingerprint that isn't 40 hex digits.
+	ErrInvalidFingerprint = errors.New("invalid fingerprint")
+)
+
+// OpenBySerial opens the OpenPGP applet of the card with serial, see Client.OpenBySerial.
+func OpenBySerial(serial uint32) (*GPGYubiKey, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenBySerial(serial)
+}
+
+// OpenByOpenPGPFingerprint opens the OpenPGP applet of the card holding the key with fingerprint,
+// see Client.OpenByOpenPGPFingerprint.
+func OpenByOpenPGPFingerprint(fingerprint string) (*GPGYubiKey, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenByOpenPGPFingerprint(fingerprint)
+}
+
+// OpenByReaderRegex opens the OpenPGP applet of the first card in a reader matching pattern,
+// see Client.OpenByReaderRegex.
+func OpenByReaderRegex(pattern string) (*GPGYubiKey, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.OpenByReaderRegex(pattern)
+}
+
+// OpenBySerial opens the OpenPGP applet of the card with serial, the number printed on a YubiKey.
+// Empty readers and cards without an OpenPGP applet are skipped using their ATR, without connecting to them.
+func (c *Client) OpenBySerial(serial uint32) (*GPGYubiKey, error) {
+	yk, err := c.openMatching(nil, func(yk *GPGYubiKey) bool {
+		aid, err := yk.gpgData.GetTag("6E.4F", 14)
+		if err != nil {
+			return false
+		}
+
+		s, err := parseOpenPGPSerial(aid)
+
+		return err == nil && s == serial
+	})
+	if err != nil {
+		return nil, fmt.Errorf("serial %d: %w", serial, err)
+	}
+
+	return yk, nil
+}
+
+// OpenByOpenPGPFingerprint opens the OpenPGP applet of the card holding the key with fingerprint in any slot.
+// Spaces and a 0x prefix are ignored.
+// Empty readers and cards without an OpenPGP applet are skipped using their ATR, without connecting to them.
+func (c *Client) OpenByOpenPGPFingerprint(fingerprint string) (*GPGYubiKey, error) {
+	fp := strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	fp = strings.TrimPrefix(fp, "0X")
+
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != keyFingerprintLen {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFingerprint, fingerprint)
+	}
+
+	yk, err := c.openMatching(nil, func(yk *GPGYubiKey) bool {
+		for _, keyType := range []KeyType{SignatureKey, DecryptionKey, AuthenticationKey} {
+			if f, err := yk.gpgData.Fingerprint(keyType); err == nil && f == fp {
+				return true
+			}
+		}
+
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint %s: %w", fp, err)
+	}
+
+	return yk, nil
+}
+
+// OpenByReaderRegex opens the OpenPGP applet of the first card in a reader whose name matches pattern.
+// Only the cards in matching readers are connected to.
+func (c *Client) OpenByReaderRegex(pattern string) (*GPGYubiKey, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("reader pattern: %w", err)
+	}
+
+	yk, err := c.openMatching(re.MatchString, func(*GPGYubiKey) bool { return true })
+	if err != nil {
+		return nil, fmt.Errorf("reader pattern %s: %w", pattern, err)
+	}
+
+	return yk, nil
+}
+
+// openMatching opens the OpenPGP applet of every candidate card until one matches, the others are closed again.
+// Readers are candidates if readerMatch is nil or matches, they have a card, and the ATR doesn't rule out OpenPGP.
+func (c *Client) openMatching(readerMatch func(string) bool, match func(*GPGYubiKey) bool) (*GPGYubiKey, error) {
+	readers, err := c.openPGPReaders(readerMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+
+	for _, reader := range readers {
+		yk, err := c.OpenGPG(reader)
+		if err != nil {
+			// the card may be in use by another program, keep looking.
+			lastErr = fmt.Errorf("%s: %w", reader, err)
+
+			continue
+		}
+
+		if match(yk) {
+			return yk, nil
+		}
+
+		yk.Close()
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoMatchingCard, lastErr)
+	}
+
+	return nil, ErrNoMatchingCard
+}
+
+// openPGPReaders lists the readers matching readerMatch with a card that may have an OpenPGP applet.
+func (c *Client) openPGPReaders(readerMatch func(string) bool) ([]string, error) {
+	ctx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+	defer ctx.Close()
+
+	readers, err := ctx.ListReaders()
+	if err != nil {
+		return nil, fmt.Errorf("listing readers: %w", err)
+	}
+
+	candidates := make([]string, 0, len(readers))
+
+	for _, reader := range readers {
+		if readerMatch != nil && !readerMatch(reader) {
+			continue
+		}
+
+		raw, err := ctx.ATR(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading ATR of %s: %w", reader, err)
+		}
+
+		if raw == nil {
+			// empty reader.
+			continue
+		}
+
+		if atr, err := ParseATR(raw); err == nil && !atr.Identify().HasApplication(CapabilityOpenPGP) {
+			continue
+		}
+
+		candidates = append(candidates, reader)
+	}
+
+	return candidates, nil
+}
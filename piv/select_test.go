@@ -0,0 +1,130 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// openPGPCardTx is basicOpenGPGTx with the BCD serial and the fingerprint of the signature key replaced.
+func openPGPCardTx(t *testing.T, serial []byte, fingerprint []byte) *TestSCTx {
+	t.Helper()
+
+	tx := basicOpenGPGTx()
+	data := append([]byte{}, tx.ResponseList[2]...)
+
+	// the serial is bytes 11-14 of the AID, which starts after 6E 81 DE 4F 10.
+	copy(data[15:19], serial)
+
+	if fingerprint != nil {
+		copy(data[bytes.Index(data, []byte{0xc5, 0x3c})+2:], fingerprint)
+	}
+
+	tx.ResponseList[2] = data
+
+	return tx
+}
+
+func TestClient_OpenMatching(t *testing.T) {
+	t.Parallel()
+
+	const (
+		readerA   = "Yubico YubiKey OTP+FIDO+CCID 00 00"
+		readerB   = "Yubico YubiKey OTP+FIDO+CCID 01 00"
+		readerNFC = "ACS ACR1252 Dual Reader PICC"
+		empty     = "ACS ACR1252 Dual Reader SAM"
+	)
+
+	fingerprint := mustHex(t, "0123456789ABCDEF0123456789ABCDEF01234567")
+	ykATR := mustHex(t, "3B FD 13 00 00 81 31 FE 15 80 73 C0 21 C0 57 59 75 62 69 4B 65 79 40")
+	// a SmartCard-HSM has no OpenPGP applet, it isn't connected to.
+	hsmATR := mustHex(t, "3B DE 18 FF 81 91 FE 1F C3 80 31 81 54 48 53 4D 31 73 80 21 40 81 07 1C")
+
+	newClient := func(connects map[string]int) *Client {
+		return &Client{
+			client: &client{},
+			SCConstruct: &TestSCConstructor{Ctx: TestSCContext{
+				Readers: []string{readerNFC, empty, readerA, readerB},
+				ATRs:    map[string][]byte{readerNFC: hsmATR, readerA: ykATR, readerB: ykATR},
+				ConnectFunc: func(reader string) (SCHandle, error) {
+					connects[reader]++
+
+					switch reader {
+					case readerA:
+						return &TestSCHandle{Ctx: openPGPCardTx(t, []byte{0x01, 0x23, 0x45, 0x67}, nil)}, nil
+					case readerB:
+						return &TestSCHandle{Ctx: openPGPCardTx(t, []byte{0x07, 0x65, 0x43, 0x21}, fingerprint)}, nil
+					}
+
+					return nil, errors.New("unexpected connect")
+				},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		open      func(c *Client) (*GPGYubiKey, error)
+		expected  string
+		expectErr error
+	}{
+		{name: "serial", open: func(c *Client) (*GPGYubiKey, error) { return c.OpenBySerial(7654321) }, expected: readerB},
+		{name: "serial not found", open: func(c *Client) (*GPGYubiKey, error) { return c.OpenBySerial(1) }, expectErr: ErrNoMatchingCard},
+		{
+			name: "fingerprint",
+			open: func(c *Client) (*GPGYubiKey, error) {
+				return c.OpenByOpenPGPFingerprint("0x0123 4567 89ab cdef 0123  4567 89AB CDEF 0123 4567")
+			},
+			expected: readerB,
+		},
+		{
+			name:      "fingerprint too short",
+			open:      func(c *Client) (*GPGYubiKey, error) { return c.OpenByOpenPGPFingerprint("0123") },
+			expectErr: ErrInvalidFingerprint,
+		},
+		{name: "reader", open: func(c *Client) (*GPGYubiKey, error) { return c.OpenByReaderRegex(`YubiKey .* 00 00$`) }, expected: readerA},
+		{name: "reader not found", open: func(c *Client) (*GPGYubiKey, error) { return c.OpenByReaderRegex(`Nitrokey`) }, expectErr: ErrNoMatchingCard},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			connects := map[string]int{}
+
+			yk, err := tc.open(newClient(connects))
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("got %v expected %v", err, tc.expectErr)
+			}
+
+			if tc.expectErr != nil {
+				return
+			}
+
+			if yk.gpgData.Reader != tc.expected {
+				t.Errorf("got %s expected %s", yk.gpgData.Reader, tc.expected)
+			}
+
+			if connects[readerNFC] != 0 || connects[empty] != 0 {
+				t.Errorf("connected to cards without OpenPGP %v", connects)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// openPGPAIDTag is the application identifier data object, GET DATA 004F.
+const openPGPAIDTag = 0x4f
+
+// ykSerialWithFallback reads the serial number from the PIV applet and, if
+// that fails, from the management applet device info or the OpenPGP
+// application identifier. The PIV applet is selected again afterwards.
+func ykSerialWithFallback(tx SCTx, v *version, q CardQuirks) (uint32, error) {
+	serial, err := ykSerial(tx, v, q)
+	if err == nil {
+		return serial, nil
+	}
+	for _, fallback := range []func(SCTx) (uint32, error){ykManagementSerial, ykOpenPGPSerial} {
+		if serial, ferr := fallback(tx); ferr == nil {
+			return serial, nil
+		}
+	}
+	return 0, err
+}
+
+// ykManagementSerial reads the serial number from the device info of the
+// management applet.
+func ykManagementSerial(tx SCTx) (uint32, error) {
+	if err := ykSelectApplication(tx, aidManagement[:]); err != nil {
+		return 0, fmt.Errorf("selecting management applet: %w", err)
+	}
+	defer ykSelectApplication(tx, aidPIV[:])
+
+	info, err := ykReadDeviceInfo(tx)
+	if err != nil {
+		return 0, err
+	}
+	serial, ok := info[tagMgmtSerial]
+	if !ok {
+		return 0, fmt.Errorf("device info has no serial number: %w", ErrNotFound)
+	}
+	if n := len(serial); n != 4 {
+		return 0, fmt.Errorf("expected 4 byte serial number, got %d", n)
+	}
+	return binary.BigEndian.Uint32(serial), nil
+}
+
+// ykOpenPGPSerial reads the serial number from the OpenPGP application
+// identifier.
+func ykOpenPGPSerial(tx SCTx) (uint32, error) {
+	if err := ykSelectOpenGPGApplication(tx); err != nil {
+		return 0, fmt.Errorf("selecting openpgp applet: %w", err)
+	}
+	defer ykSelectApplication(tx, aidPIV[:])
+
+	aid, err := tx.Transmit(apdu{instruction: insGetDataA, param2: openPGPAIDTag})
+	if err != nil {
+		return 0, fmt.Errorf("smart card command: %w", err)
+	}
+	return parseOpenPGPSerial(aid)
+}
+
+// parseOpenPGPSerial returns the serial number from bytes 11-14 of an OpenPGP
+// AID. YubiKeys store the decimal serial number as BCD, so that the hex digits
+// match the serial printed on the key.
+//
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.pdf Page 15
+func parseOpenPGPSerial(aid []byte) (uint32, error) {
+	if n := len(aid); n < 14 {
+		return 0, fmt.Errorf("expected at least 14 byte application identifier, got %d", n)
+	}
+	raw := binary.BigEndian.Uint32(aid[10:14])
+	if binary.BigEndian.Uint16(aid[8:10]) != ManufacturerYubico {
+		return raw, nil
+	}
+	serial, err := strconv.ParseUint(fmt.Sprintf("%X", raw), 10, 32)
+	if err != nil {
+		return raw, nil
+	}
+	return uint32(serial), nil
+}
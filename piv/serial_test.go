@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"testing"
+)
+
+func TestSerialFallback(t *testing.T) {
+	notFound := &apduErr{0x6a, 0x82}
+	notSupported := &apduErr{0x6d, 0x00}
+	selectPIV := apdu{instruction: insSelectApplication, param1: 0x04, data: aidPIV[:]}
+	selectMgmt := apdu{instruction: insSelectApplication, param1: 0x04, data: aidManagement[:]}
+	selectOpenPGP := apdu{instruction: insSelectApplication, param1: paramOpenGPGASelectApplication, data: aidOpenPGP[:]}
+	getSerial := apdu{instruction: insGetSerial}
+
+	tests := []struct {
+		name  string
+		apdus []apdu
+		resps [][]byte
+		errs  []error
+		want  uint32
+	}{
+		{
+			name:  "piv",
+			apdus: []apdu{getSerial},
+			resps: [][]byte{{0x00, 0xbc, 0x61, 0x4e}},
+			want:  12345678,
+		},
+		{
+			name: "management",
+			apdus: []apdu{
+				getSerial,
+				selectMgmt,
+				{instruction: insManagementReadConfig},
+				selectPIV,
+			},
+			resps: [][]byte{nil, {}, {0x06, 0x02, 0x04, 0x00, 0xbc, 0x61, 0x4e}, {}},
+			errs:  []error{notSupported},
+			want:  12345678,
+		},
+		{
+			name: "openpgp yubikey",
+			apdus: []apdu{
+				getSerial,
+				selectMgmt,
+				selectOpenPGP,
+				{instruction: insGetDataA, param2: openPGPAIDTag},
+				selectPIV,
+			},
+			resps: [][]byte{nil, nil, {}, {
+				0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x03, 0x04,
+				0x00, 0x06, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00,
+			}, {}},
+			errs: []error{notSupported, notFound},
+			want: 12345678,
+		},
+		{
+			name: "openpgp gnuk",
+			apdus: []apdu{
+				getSerial,
+				selectMgmt,
+				selectOpenPGP,
+				{instruction: insGetDataA, param2: openPGPAIDTag},
+				selectPIV,
+			},
+			resps: [][]byte{nil, nil, {}, {
+				0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x02, 0x00,
+				0xf5, 0x17, 0x00, 0xbc, 0x61, 0x4e, 0x00, 0x00,
+			}, {}},
+			errs: []error{notSupported, notFound},
+			want: 12345678,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &TestSCTx{
+				APDUList:     test.apdus,
+				ResponseList: test.resps,
+				TransmitErr:  test.errs,
+			}
+			got, err := ykSerialWithFallback(tx, &version{5, 4, 3}, CardQuirks{})
+			if err != nil {
+				t.Fatalf("ykSerialWithFallback: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got serial %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	tx := &TestSCTx{
+		APDUList:     []apdu{getSerial, selectMgmt, selectOpenPGP},
+		ResponseList: [][]byte{nil, nil, nil},
+		TransmitErr:  []error{notSupported, notFound, notFound},
+	}
+	if _, err := ykSerialWithFallback(tx, &version{5, 4, 3}, CardQuirks{}); err == nil {
+		t.Errorf("expected error when no applet reports a serial number")
+	}
+}
@@ -0,0 +1,246 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// Session is a GPGYubiKey that caches what it reads from the card, for tools that show the status of
+// a card often, like WriteCardStatus in a loop. The responses to GET DATA are kept until a command
+// that may change the card, such as VERIFY, PUT DATA or a signature, is sent through the session,
+// and selecting the OpenPGP applet again is skipped while it's still selected.
+//
+// Changes made by other programs, or through the GPGYubiKey the session was made from, aren't seen
+// until Invalidate. A transaction begun again with BeginTransaction invalidates the session too.
+// The session shares the connection of its GPGYubiKey, Close closes both.
+type Session struct {
+	*GPGYubiKey
+
+	tx *sessionTx
+}
+
+// SessionStats counts the commands of a Session.
+type SessionStats struct {
+	// Hits are the commands answered from the cache.
+	Hits int
+	// Transmits are the commands sent to the card.
+	Transmits int
+}
+
+// NewSession returns a session on the connection of yk, see Session.
+func (yk *GPGYubiKey) NewSession() *Session {
+	// the applet was selected when yk was opened, its response isn't needed.
+	tx := &sessionTx{SCTx: yk.tx, data: map[uint16][]byte{}, selected: aidOpenPGP[:]}
+
+	gpg := &GPGYubiKey{
+		ctx:     yk.ctx,
+		h:       yk.h,
+		tx:      tx,
+		gpgData: yk.gpgData,
+		trace:   yk.trace,
+		mse:     maps.Clone(yk.mse),
+	}
+
+	return &Session{GPGYubiKey: gpg, tx: tx}
+}
+
+// Invalidate drops the cached data objects and reads the application related data again,
+// so the session sees changes made to the card by others.
+func (s *Session) Invalidate() error {
+	if s.trace {
+		fmt.Println("\u001b[31mSession.Invalidate\u001b[0m")
+	}
+
+	// the applet may have been deselected by another program too.
+	s.tx.reset()
+
+	if err := ykSelectOpenGPGApplication(s.tx); err != nil {
+		return fmt.Errorf("selecting openpgp applet: %w", err)
+	}
+
+	gpgData, err := ykOpenGPGData(s.tx, s.gpgData.Reader, s.gpgData.parseMode)
+	if err == nil {
+		err = gpgData.readLoginData(s.tx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	gpgData.debug = s.gpgData.debug
+	s.gpgData = gpgData
+
+	return nil
+}
+
+// Prefetch reads the data objects with the given tags that aren't cached yet, one after the other
+// with the applet selected once, so later reads don't need the card. Tags the card doesn't have
+// are skipped.
+func (s *Session) Prefetch(tags ...uint16) error {
+	if s.trace {
+		fmt.Println("\u001b[31mSession.Prefetch\u001b[0m")
+	}
+
+	for _, tag := range tags {
+		if _, err := gpgGetOptionalData(s.tx, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the number of commands answered from the cache and sent to the card.
+func (s *Session) Stats() SessionStats {
+	s.tx.mu.Lock()
+	defer s.tx.mu.Unlock()
+
+	return s.tx.stats
+}
+
+// sessionTx caches the responses to GET DATA and remembers the selected applet.
+// Every other command may change the card and drops the cache.
+type sessionTx struct {
+	SCTx
+
+	mu       sync.Mutex
+	data     map[uint16][]byte
+	selected []byte
+	// selectResp is the response to selecting the selected applet.
+	selectResp []byte
+	stats      SessionStats
+}
+
+var (
+	_ TransactionTx    = (*sessionTx)(nil)
+	_ ExtendedLengthTx = (*sessionTx)(nil)
+)
+
+func (t *sessionTx) Transmit(d apdu) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tag := uint16(d.param1)<<8 | uint16(d.param2)
+
+	switch {
+	case d.class != 0:
+		// secure messaging.
+	case d.instruction == insSelectApplication && d.param1 == 0x04:
+		if t.selected != nil && bytes.Equal(t.selected, d.data) {
+			t.stats.Hits++
+
+			return append([]byte(nil), t.selectResp...), nil
+		}
+
+		t.data = map[uint16][]byte{}
+		t.selected = nil
+		t.stats.Transmits++
+
+		resp, err := t.SCTx.Transmit(d)
+		if err == nil {
+			t.selected = append([]byte(nil), d.data...)
+			t.selectResp = append([]byte(nil), resp...)
+		}
+
+		return resp, err
+	case d.instruction == insGetDataA && len(d.data) == 0:
+		if resp, ok := t.data[tag]; ok {
+			t.stats.Hits++
+
+			return append([]byte(nil), resp...), nil
+		}
+
+		t.stats.Transmits++
+
+		resp, err := t.SCTx.Transmit(d)
+		if err == nil {
+			t.data[tag] = append([]byte(nil), resp...)
+		}
+
+		return resp, err
+	case d.instruction == insGetGPGAppletVersion:
+		t.stats.Transmits++
+
+		return t.SCTx.Transmit(d)
+	}
+
+	t.data = map[uint16][]byte{}
+	t.stats.Transmits++
+
+	return t.SCTx.Transmit(d)
+}
+
+// TransmitBytes sends a raw command, which may select another applet or change the card.
+func (t *sessionTx) TransmitBytes(req []byte) (bool, []byte, error) {
+	t.reset()
+
+	t.mu.Lock()
+	t.stats.Transmits++
+	t.mu.Unlock()
+
+	return t.SCTx.TransmitBytes(req)
+}
+
+// reset forgets the cache and the selected applet.
+func (t *sessionTx) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.data = map[uint16][]byte{}
+	t.selected = nil
+}
+
+// BeginTransaction forgets the cache and the selected applet, another program may have changed them.
+func (t *sessionTx) BeginTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	t.reset()
+
+	return tx.BeginTransaction()
+}
+
+func (t *sessionTx) EndTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	return tx.EndTransaction()
+}
+
+func (t *sessionTx) SetExtendedLength(enabled bool) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetExtendedLength(enabled)
+	}
+}
+
+func (t *sessionTx) ExtendedLength() bool {
+	e, ok := t.SCTx.(ExtendedLengthTx)
+
+	return ok && e.ExtendedLength()
+}
+
+func (t *sessionTx) SetMaxCommandLength(n int) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetMaxCommandLength(n)
+	}
+}
@@ -0,0 +1,123 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	s := yk.NewSession()
+
+	cardStatus := func() string {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := s.WriteCardStatus(&b); err != nil {
+			t.Fatalf("card status: %v", err)
+		}
+
+		return b.String()
+	}
+
+	first := cardStatus()
+	before := s.Stats()
+
+	if second := cardStatus(); second != first {
+		t.Errorf("cached status\n%s\nexpected\n%s", second, first)
+	}
+
+	if stats := s.Stats(); stats.Transmits != before.Transmits || stats.Hits <= before.Hits {
+		t.Errorf("second status sent %d commands with %d cache hits", stats.Transmits-before.Transmits, stats.Hits-before.Hits)
+	}
+
+	// a change through the session drops the cache.
+	if err := s.PutPublicKeyURL([]byte(pivtest.DefaultAdminPIN), "https://example.com/session.asc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := cardStatus(); !strings.Contains(status, "https://example.com/session.asc") {
+		t.Errorf("status after PUT DATA:\n%s", status)
+	}
+
+	// a change through another handle is only seen after Invalidate.
+	if err := yk.PutPublicKeyURL([]byte(pivtest.DefaultAdminPIN), "https://example.com/other.asc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := cardStatus(); strings.Contains(status, "other.asc") {
+		t.Error("change seen before Invalidate")
+	}
+
+	if err := s.Invalidate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := cardStatus(); !strings.Contains(status, "https://example.com/other.asc") {
+		t.Errorf("status after Invalidate:\n%s", status)
+	}
+}
+
+func TestSession_Prefetch(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+	defer yk.Close()
+
+	s := yk.NewSession()
+
+	// URL, login data and the PIN status.
+	if err := s.Prefetch(0x5f50, 0x5e, 0xc4); err != nil {
+		t.Fatal(err)
+	}
+
+	before := s.Stats()
+
+	if _, err := s.PINRetries(); err != nil {
+		t.Fatal(err)
+	}
+
+	// selecting the applet again is answered from the session too.
+	if err := s.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := s.Stats(); stats.Transmits != before.Transmits+1 || stats.Hits != before.Hits+1 {
+		t.Errorf("got %+v after %+v, expected one hit then one select", stats, before)
+	}
+
+	if _, err := s.NewBatch().GetData(0xc4).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := s.Stats(); stats.Transmits != before.Transmits+2 {
+		t.Errorf("got %+v, expected the selected applet to be remembered", stats)
+	}
+}
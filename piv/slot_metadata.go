@@ -0,0 +1,66 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeySlots returns the slots that hold keys: 9a, 9c, 9d, 9e and the retired slots 82 to 95.
+func KeySlots() []Slot {
+	return append([]Slot{
+		SlotAuthentication,
+		SlotSignature,
+		SlotKeyManagement,
+		SlotCardAuthentication,
+	}, RetiredKeyManagementSlots()...)
+}
+
+// GetSlotMetadata reads the key of slot with the Yubico GET METADATA instruction (F7): its algorithm,
+// PIN and touch policies, origin and public key, without the round trip through an attestation.
+// Slots without a key return an error wrapping ErrNotFound, firmware before 5.3.0 ErrNotSupportedByCard.
+func (yk *YubiKey) GetSlotMetadata(slot Slot) (KeyInfo, error) {
+	if !yk.Features().SupportsMetadata() {
+		return KeyInfo{}, fmt.Errorf("reading metadata: %w", ErrNotSupportedByCard)
+	}
+
+	ki, err := yk.KeyInfo(slot)
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("reading metadata of slot %s: %w", slot, err)
+	}
+
+	return ki, nil
+}
+
+// SlotsMetadata returns the metadata of every slot of KeySlots that has a key, for auditing a card.
+func (yk *YubiKey) SlotsMetadata() (map[Slot]KeyInfo, error) {
+	slots := map[Slot]KeyInfo{}
+
+	for _, slot := range KeySlots() {
+		ki, err := yk.GetSlotMetadata(slot)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		slots[slot] = ki
+	}
+
+	return slots, nil
+}
@@ -0,0 +1,64 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+)
+
+func TestYubiKey_SlotsMetadata(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 4, Patch: 3})
+	opts := piv.Key{Algorithm: piv.AlgorithmEC384, PINPolicy: piv.PINPolicyAlways, TouchPolicy: piv.TouchPolicyNever}
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotCardAuthentication, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := yk.GetSlotMetadata(piv.SlotSignature); !errors.Is(err, piv.ErrNotFound) {
+		t.Errorf("metadata of empty slot: %v", err)
+	}
+
+	slots, err := yk.SlotsMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ki, ok := slots[piv.SlotCardAuthentication]
+	if len(slots) != 1 || !ok {
+		t.Fatalf("unexpected slots %v", slots)
+	}
+
+	if ki.Algorithm != opts.Algorithm || ki.PINPolicy != opts.PINPolicy || ki.Origin != piv.OriginGenerated ||
+		!ki.PublicKey.(*ecdsa.PublicKey).Equal(pub) {
+		t.Errorf("unexpected metadata %+v", ki)
+	}
+}
+
+func TestYubiKey_GetSlotMetadata_NotSupported(t *testing.T) {
+	t.Parallel()
+
+	yk := openYubiKeyVersion(t, piv.Version{Major: 5, Minor: 2, Patch: 7})
+
+	if _, err := yk.GetSlotMetadata(piv.SlotAuthentication); !errors.Is(err, piv.ErrNotSupportedByCard) {
+		t.Errorf("expected ErrNotSupportedByCard, got %v", err)
+	}
+}
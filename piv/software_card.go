@@ -0,0 +1,225 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/areese/piv-go/bertlv"
+)
+
+// SoftwareCard is a smart card implemented in software, like the emulated YubiKey of package pivtest.
+// Transmit gets a command APDU and returns the response data followed by SW1 SW2, like SCardTransmit.
+type SoftwareCard interface {
+	Transmit(command []byte) ([]byte, error)
+}
+
+// atrCard is implemented by software cards that have an ATR.
+type atrCard interface {
+	ATR() []byte
+}
+
+// NewSoftwareCardConstructor returns an SCConstructor with a reader for each of cards, by reader name,
+// so a Client can open software cards like it opens cards in PC/SC readers:
+//
+//	c := Client{SCConstruct: NewSoftwareCardConstructor(map[string]SoftwareCard{"Yubico YubiKey 00": card})}
+//	yk, err := c.OpenGPG("Yubico YubiKey 00")
+func NewSoftwareCardConstructor(cards map[string]SoftwareCard) SCConstructor {
+	return &softwareConstructor{cards: cards}
+}
+
+type softwareConstructor struct {
+	cards map[string]SoftwareCard
+}
+
+type softwareContext struct {
+	cards map[string]SoftwareCard
+}
+
+type softwareHandle struct {
+	card SoftwareCard
+}
+
+// softwareTx sends short APDUs to a software card, chaining long commands and reading long responses
+// with GET RESPONSE like scTx does.
+type softwareTx struct {
+	card  SoftwareCard
+	debug bool
+}
+
+var (
+	_ SCConstructor = (*softwareConstructor)(nil)
+	_ SCContext     = (*softwareContext)(nil)
+	_ SCHandle      = (*softwareHandle)(nil)
+	_ SCTx          = (*softwareTx)(nil)
+	_ TransactionTx = (*softwareTx)(nil)
+)
+
+// nolint:ireturn
+func (s *softwareConstructor) NewSCContext() (SCContext, error) {
+	return &softwareContext{cards: s.cards}, nil
+}
+
+func (s *softwareContext) Close() error {
+	return nil
+}
+
+// nolint:ireturn
+func (s *softwareContext) Connect(reader string) (SCHandle, error) {
+	card, ok := s.cards[reader]
+	if !ok {
+		return nil, &scErr{rc: rcReaderUnavailable}
+	}
+
+	return &softwareHandle{card: card}, nil
+}
+
+func (s *softwareContext) ListReaders() ([]string, error) {
+	readers := make([]string, 0, len(s.cards))
+	for reader := range s.cards {
+		readers = append(readers, reader)
+	}
+
+	sort.Strings(readers)
+
+	return readers, nil
+}
+
+func (s *softwareContext) ATR(reader string) ([]byte, error) {
+	card, ok := s.cards[reader]
+	if !ok {
+		return nil, &scErr{rc: rcReaderUnavailable}
+	}
+
+	if a, ok := card.(atrCard); ok {
+		return a.ATR(), nil
+	}
+
+	return nil, nil
+}
+
+// nolint:ireturn
+func (s *softwareHandle) Begin() (SCTx, error) {
+	return &softwareTx{card: s.card}, nil
+}
+
+func (s *softwareHandle) Close() error {
+	return nil
+}
+
+func (s *softwareTx) Close() error {
+	return nil
+}
+
+// BeginTransaction does nothing, software cards aren't shared.
+func (s *softwareTx) BeginTransaction() error {
+	return nil
+}
+
+// EndTransaction does nothing, software cards aren't shared.
+func (s *softwareTx) EndTransaction() error {
+	return nil
+}
+
+func (s *softwareTx) EnableDebug() {
+	s.debug = true
+}
+
+func (s *softwareTx) DisableDebug() {
+	s.debug = false
+}
+
+func (s *softwareTx) IsDebugEnabled() bool {
+	return s.debug
+}
+
+func (s *softwareTx) String() string {
+	return bertlv.MakeJSONString(s)
+}
+
+func (s *softwareTx) TransmitBytes(req []byte) (more bool, b []byte, err error) {
+	if s.debug {
+		fmt.Printf("<-- apdu=%s", hex.Dump(req))
+	}
+
+	resp, err := s.card.Transmit(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("transmitting request: %w", err)
+	}
+
+	if len(resp) < 2 {
+		return false, nil, fmt.Errorf("scard response too short: %d", len(resp))
+	}
+
+	sw1, sw2 := resp[len(resp)-2], resp[len(resp)-1]
+
+	if s.debug {
+		fmt.Printf("--> sw=0x%02x%02x %d bytes:\n%s\n", sw1, sw2, len(resp), hex.Dump(resp))
+	}
+
+	switch {
+	case sw1 == 0x90 && sw2 == 0x00:
+		return false, resp[:len(resp)-2], nil
+	case sw1 == 0x61:
+		return true, resp[:len(resp)-2], nil
+	default:
+		return false, nil, &apduErr{sw1, sw2}
+	}
+}
+
+// Transmit sends d with command chaining when the data doesn't fit a short APDU.
+// ISO/IEC 7816-4 5.1.1.
+func (s *softwareTx) Transmit(d apdu) ([]byte, error) {
+	const maxAPDUDataSize = 0xff
+
+	data := d.data
+
+	var resp []byte
+
+	for len(data) > maxAPDUDataSize {
+		req := append([]byte{0x10 | d.class, d.instruction, d.param1, d.param2, maxAPDUDataSize}, data[:maxAPDUDataSize]...)
+		data = data[maxAPDUDataSize:]
+
+		_, r, err := s.TransmitBytes(req)
+		if err != nil {
+			return nil, fmt.Errorf("transmitting initial chunk %w", err)
+		}
+
+		resp = append(resp, r...)
+	}
+
+	req := append([]byte{d.class, d.instruction, d.param1, d.param2, byte(len(data))}, data...)
+
+	more, r, err := transmitWithLe(s.TransmitBytes, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = append(resp, r...)
+
+	for more {
+		more, r, err = s.TransmitBytes([]byte{0x00, d.getResponse(), 0x00, 0x00, 0x00})
+		if err != nil {
+			return nil, fmt.Errorf("reading further response: %w", err)
+		}
+
+		resp = append(resp, r...)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,233 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sshagent serves keys of a YubiKey to ssh with the ssh-agent protocol, so
+// SSH_AUTH_SOCK can point at a socket of this package instead of ssh-agent or gpg-agent.
+//
+// PIVKey exposes a key of a PIV slot, usually SlotAuthentication (9a), OpenPGPKey the
+// authentication key of the OpenPGP applet. The PINPrompt and TouchCallback of the auth of
+// a key are called when a signature needs the PIN or a touch.
+//
+// The agent is read only: keys can't be added or removed by clients, but it can be locked.
+// NewSSHSigner and NewOpenPGPSSHSigner return the keys as an ssh.Signer for programs that dial
+// SSH themselves.
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	// ErrReadOnly is returned when a client tries to add or remove keys.
+	ErrReadOnly = errors.New("agent: keys can't be added or removed")
+	// ErrLocked is returned by Sign while the agent is locked.
+	ErrLocked = errors.New("agent: locked")
+	// ErrUnknownKey is returned by Sign for keys the agent doesn't have.
+	ErrUnknownKey = errors.New("agent: unknown key")
+)
+
+// Agent is an agent.ExtendedAgent for keys of a card.
+type Agent struct {
+	keys []agentKey
+
+	// mu guards locked and passphrase and serializes the signatures, the card does one at a time.
+	mu         sync.Mutex
+	locked     bool
+	passphrase []byte
+}
+
+type agentKey struct {
+	signer  ssh.Signer
+	comment string
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+// New returns an agent for keys.
+func New(keys ...Key) (*Agent, error) {
+	a := &Agent{}
+
+	for _, k := range keys {
+		signer, err := ssh.NewSignerFromSigner(k.Signer)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.Comment, err)
+		}
+
+		a.keys = append(a.keys, agentKey{signer: signer, comment: k.Comment})
+	}
+
+	return a, nil
+}
+
+// List returns the keys of the agent, none while it's locked.
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return nil, nil
+	}
+
+	keys := make([]*agent.Key, 0, len(a.keys))
+	for _, k := range a.keys {
+		pub := k.signer.PublicKey()
+		keys = append(keys, &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: k.comment})
+	}
+
+	return keys, nil
+}
+
+// Sign signs data with the card key of key, RSA keys sign with SHA-1.
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs data with the card key of key, the flags select SHA-256 or SHA-512 for RSA keys.
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return nil, ErrLocked
+	}
+
+	wanted := key.Marshal()
+
+	for _, k := range a.keys {
+		if !bytes.Equal(k.signer.PublicKey().Marshal(), wanted) {
+			continue
+		}
+
+		algorithmSigner, ok := k.signer.(ssh.AlgorithmSigner)
+		if !ok || key.Type() != ssh.KeyAlgoRSA {
+			return k.signer.Sign(rand.Reader, data)
+		}
+
+		switch {
+		case flags&agent.SignatureFlagRsaSha256 != 0:
+			return algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA256)
+		case flags&agent.SignatureFlagRsaSha512 != 0:
+			return algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		default:
+			return algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSA)
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// Signers returns signers for the keys, they don't check the lock.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(a.keys))
+	for _, k := range a.keys {
+		signers = append(signers, k.signer)
+	}
+
+	return signers, nil
+}
+
+// Add returns ErrReadOnly.
+func (a *Agent) Add(agent.AddedKey) error {
+	return ErrReadOnly
+}
+
+// Remove returns ErrReadOnly.
+func (a *Agent) Remove(ssh.PublicKey) error {
+	return ErrReadOnly
+}
+
+// RemoveAll returns ErrReadOnly.
+func (a *Agent) RemoveAll() error {
+	return ErrReadOnly
+}
+
+// Lock hides the keys until Unlock is called with passphrase, like `ssh-add -x`.
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return ErrLocked
+	}
+
+	a.locked, a.passphrase = true, append([]byte{}, passphrase...)
+
+	return nil
+}
+
+// Unlock undoes Lock.
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.locked || subtle.ConstantTimeCompare(passphrase, a.passphrase) != 1 {
+		return errors.New("agent: incorrect passphrase")
+	}
+
+	a.locked, a.passphrase = false, nil
+
+	return nil
+}
+
+// Extension returns agent.ErrExtensionUnsupported, there are no extensions.
+func (a *Agent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// Listen creates a unix socket at path that only the user can connect to, for SSH_AUTH_SOCK.
+func Listen(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Serve serves the agent on the connections of l until l is closed, which isn't an error.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			// the client closing the connection ends it.
+			_ = agent.ServeAgent(a, conn)
+		}()
+	}
+}
@@ -0,0 +1,223 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newPIVKey(t *testing.T, alg piv.Algorithm) Key {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if _, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotAuthentication, piv.Key{
+		Algorithm:   alg,
+		PINPolicy:   piv.PINPolicyOnce,
+		TouchPolicy: piv.TouchPolicyNever,
+	}); err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	// without a certificate the public key comes from an attestation.
+	key, err := PIVKey(yk, piv.SlotAuthentication, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+	if err != nil {
+		t.Fatalf("PIVKey: %v", err)
+	}
+
+	return key
+}
+
+func newOpenPGPKey(t *testing.T) Key {
+	t.Helper()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.AuthenticationKey, piv.AlgorithmEC256); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// reload the algorithm attributes of the new key.
+	if _, err := yk.GPGData(); err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	key, err := OpenPGPKey(yk, piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatalf("OpenPGPKey: %v", err)
+	}
+
+	return key
+}
+
+// newClient serves a on a unix socket and returns a client connected to it.
+func newClient(t *testing.T, a *Agent) agent.ExtendedAgent {
+	t.Helper()
+
+	l, err := Listen(filepath.Join(t.TempDir(), "agent.sock"))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	done := make(chan error)
+
+	go func() { done <- a.Serve(l) }()
+
+	t.Cleanup(func() {
+		l.Close()
+
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return agent.NewClient(conn)
+}
+
+func TestAgent(t *testing.T) {
+	t.Parallel()
+
+	a, err := New(newPIVKey(t, piv.AlgorithmEC256), newPIVKey(t, piv.AlgorithmRSA2048), newOpenPGPKey(t))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	client := newClient(t, a)
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("listed %d keys, expected 3", len(keys))
+	}
+
+	data := []byte("session id and user auth request")
+
+	tests := []struct {
+		key    int
+		flags  agent.SignatureFlags
+		format string
+	}{
+		{key: 0, format: ssh.KeyAlgoECDSA256},
+		{key: 1, format: ssh.KeyAlgoRSA},
+		{key: 1, flags: agent.SignatureFlagRsaSha256, format: ssh.KeyAlgoRSASHA256},
+		{key: 1, flags: agent.SignatureFlagRsaSha512, format: ssh.KeyAlgoRSASHA512},
+		{key: 2, format: ssh.KeyAlgoECDSA256},
+	}
+
+	for _, tc := range tests {
+		pub, err := ssh.ParsePublicKey(keys[tc.key].Blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sig, err := client.SignWithFlags(pub, data, tc.flags)
+		if err != nil {
+			t.Fatalf("sign with %s: %v", keys[tc.key].Comment, err)
+		}
+
+		if sig.Format != tc.format {
+			t.Errorf("signature format %s, expected %s", sig.Format, tc.format)
+		}
+
+		if err := pub.Verify(data, sig); err != nil {
+			t.Errorf("verify %s: %v", keys[tc.key].Comment, err)
+		}
+	}
+}
+
+func TestAgentLock(t *testing.T) {
+	t.Parallel()
+
+	key := newPIVKey(t, piv.AlgorithmEC256)
+
+	a, err := New(key)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	pub, err := ssh.NewPublicKey(key.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Lock([]byte("passphrase")); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	if keys, err := a.List(); err != nil || len(keys) != 0 {
+		t.Errorf("list locked: %d keys, %v", len(keys), err)
+	}
+
+	if _, err := a.Sign(pub, []byte("data")); !errors.Is(err, ErrLocked) {
+		t.Errorf("sign locked: %v, expected %v", err, ErrLocked)
+	}
+
+	if err := a.Unlock([]byte("wrong")); err == nil {
+		t.Error("unlocked with the wrong passphrase")
+	}
+
+	if err := a.Unlock([]byte("passphrase")); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+
+	if _, err := a.Sign(pub, []byte("data")); err != nil {
+		t.Errorf("sign: %v", err)
+	}
+
+	other, err := ssh.NewPublicKey(newPIVKey(t, piv.AlgorithmEC256).Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Sign(other, []byte("data")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("sign with unknown key: %v, expected %v", err, ErrUnknownKey)
+	}
+
+	if err := a.RemoveAll(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("remove all: %v, expected %v", err, ErrReadOnly)
+	}
+}
@@ -0,0 +1,88 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/areese/piv-go/piv"
+)
+
+// ErrNotSigner is returned for keys that can't sign.
+var ErrNotSigner = errors.New("key can't sign")
+
+// Key is a key of the agent.
+type Key struct {
+	// Signer signs with the key, usually a key of a card.
+	Signer crypto.Signer
+	// Comment is shown by `ssh-add -l`.
+	Comment string
+}
+
+// PIVKey returns the key of a PIV slot, usually SlotAuthentication. The public key is read from the
+// certificate of the slot, or from an attestation when there is none. auth presents the PIN and is told
+// about touches, PINPolicy and TouchPolicy have to be set for imported keys without a certificate.
+func PIVKey(yk *piv.YubiKey, slot piv.Slot, auth piv.KeyAuth) (Key, error) {
+	cert, err := yk.Certificate(slot)
+	if errors.Is(err, piv.ErrNotFound) {
+		cert, err = yk.Attest(slot)
+	}
+
+	if err != nil {
+		return Key{}, fmt.Errorf("public key of slot %s: %w", slot, err)
+	}
+
+	priv, err := yk.PrivateKey(slot, cert.PublicKey, auth)
+	if err != nil {
+		return Key{}, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return Key{}, fmt.Errorf("%w: slot %s", ErrNotSigner, slot)
+	}
+
+	comment := "PIV slot " + slot.String()
+
+	if serial, err := yk.Serial(); err == nil {
+		comment = fmt.Sprintf("YubiKey #%d %s", serial, comment)
+	}
+
+	return Key{Signer: signer, Comment: comment}, nil
+}
+
+// OpenPGPKey returns the authentication key of the OpenPGP applet, the key gpg-agent offers for ssh.
+// auth presents PW1 (82) and is told about touches.
+func OpenPGPKey(yk *piv.GPGYubiKey, auth piv.OpenPGPKeyAuth) (Key, error) {
+	priv, err := yk.OpenPGPPrivateKey(piv.AuthenticationKey, auth)
+	if err != nil {
+		return Key{}, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return Key{}, fmt.Errorf("%w: %s", ErrNotSigner, piv.AuthenticationKey)
+	}
+
+	comment := "OpenPGP authentication key"
+
+	if serial, err := yk.SerialString(); err == nil {
+		comment = fmt.Sprintf("YubiKey #%s %s", serial, comment)
+	}
+
+	return Key{Signer: signer, Comment: comment}, nil
+}
@@ -0,0 +1,66 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/areese/piv-go/piv"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSSHSigner returns an ssh.Signer for the key of a PIV slot, for ssh.PublicKeys without an agent.
+// The key is read like PIVKey does. RSA keys offer rsa-sha2-512 and rsa-sha2-256 to the server,
+// ECDSA and Ed25519 keys sign with their own algorithm.
+func NewSSHSigner(yk *piv.YubiKey, slot piv.Slot, auth piv.KeyAuth) (ssh.MultiAlgorithmSigner, error) {
+	key, err := PIVKey(yk, slot, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSSHSigner(key.Signer)
+}
+
+// NewOpenPGPSSHSigner returns an ssh.Signer for the authentication key of the OpenPGP applet,
+// like NewSSHSigner.
+func NewOpenPGPSSHSigner(yk *piv.GPGYubiKey, auth piv.OpenPGPKeyAuth) (ssh.MultiAlgorithmSigner, error) {
+	key, err := OpenPGPKey(yk, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSSHSigner(key.Signer)
+}
+
+func newSSHSigner(signer crypto.Signer) (ssh.MultiAlgorithmSigner, error) {
+	s, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithmSigner, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotSigner, s.PublicKey().Type())
+	}
+
+	// the client picks the first the server accepts, SHA-1 ssh-rsa signatures are left out.
+	algorithms := []string{s.PublicKey().Type()}
+	if algorithms[0] == ssh.KeyAlgoRSA {
+		algorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+	}
+
+	return ssh.NewSignerWithAlgorithms(algorithmSigner, algorithms)
+}
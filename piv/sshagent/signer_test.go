@@ -0,0 +1,177 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+	"golang.org/x/crypto/ssh"
+)
+
+// testDial authenticates to an in memory SSH server with signer.
+func testDial(t *testing.T, signer ssh.Signer) error {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := signer.PublicKey().Marshal()
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), wanted) {
+				return nil, errors.New("unknown key")
+			}
+
+			return &ssh.Permissions{}, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	// both sides send their version first, which would block on a net.Pipe.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer serverConn.Close()
+
+		if conn, _, _, err := ssh.NewServerConn(serverConn, config); err == nil {
+			conn.Close()
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	conn, _, _, err := ssh.NewClientConn(clientConn, "card", &ssh.ClientConfig{
+		User:            "user",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostSigner.PublicKey()),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the server may have closed the connection already.
+	conn.Close()
+
+	return nil
+}
+
+func TestNewSSHSigner(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		alg        piv.Algorithm
+		algorithms []string
+	}{
+		{name: "ec256", alg: piv.AlgorithmEC256, algorithms: []string{ssh.KeyAlgoECDSA256}},
+		{name: "ec384", alg: piv.AlgorithmEC384, algorithms: []string{ssh.KeyAlgoECDSA384}},
+		{name: "rsa2048", alg: piv.AlgorithmRSA2048, algorithms: []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}},
+		{name: "ed25519", alg: piv.AlgorithmEd25519, algorithms: []string{ssh.KeyAlgoED25519}},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+
+			t.Cleanup(func() { yk.Close() })
+
+			if _, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotAuthentication, piv.Key{
+				Algorithm:   tc.alg,
+				PINPolicy:   piv.PINPolicyOnce,
+				TouchPolicy: piv.TouchPolicyNever,
+			}); err != nil {
+				t.Fatalf("generate key: %v", err)
+			}
+
+			signer, err := NewSSHSigner(yk, piv.SlotAuthentication, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+			if err != nil {
+				t.Fatalf("NewSSHSigner: %v", err)
+			}
+
+			if got := signer.Algorithms(); len(got) != len(tc.algorithms) || got[0] != tc.algorithms[0] {
+				t.Errorf("algorithms %v, expected %v", got, tc.algorithms)
+			}
+
+			if err := testDial(t, signer); err != nil {
+				t.Errorf("dial: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewOpenPGPSSHSigner(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).OpenGPG(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open gpg: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	if err := yk.VerifyPIN(piv.PW3, []byte(pivtest.DefaultAdminPIN)); err != nil {
+		t.Fatalf("verify admin pin: %v", err)
+	}
+
+	if _, err := yk.GenerateOpenPGPKey(piv.AuthenticationKey, piv.AlgorithmRSA2048); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	if _, err := yk.GPGData(); err != nil {
+		t.Fatalf("gpg data: %v", err)
+	}
+
+	signer, err := NewOpenPGPSSHSigner(yk, piv.OpenPGPKeyAuth{PIN: []byte(pivtest.DefaultPIN)})
+	if err != nil {
+		t.Fatalf("NewOpenPGPSSHSigner: %v", err)
+	}
+
+	if err := testDial(t, signer); err != nil {
+		t.Errorf("dial: %v", err)
+	}
+}
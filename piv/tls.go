@@ -0,0 +1,77 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+// TLSCertificate returns a tls.Certificate with the certificate of slot and its key on the card,
+// for mutual TLS clients and for servers. The card only stores the leaf certificate of a slot,
+// intermediates are sent after it.
+//
+// RSA keys sign PSS for TLS 1.3 and PKCS#1 v1.5 for TLS 1.2. auth is used for every handshake.
+func (yk *YubiKey) TLSCertificate(slot Slot, auth KeyAuth, intermediates ...*x509.Certificate) (*tls.Certificate, error) {
+	cert, err := yk.Certificate(slot)
+	if err != nil {
+		return nil, fmt.Errorf("certificate of slot %s: %w", slot, err)
+	}
+	priv, err := yk.PrivateKey(slot, cert.PublicKey, auth)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: slot %s key can't sign", ErrNoSuchAlgorithm, slot)
+	}
+
+	chain := [][]byte{cert.Raw}
+	for _, c := range intermediates {
+		chain = append(chain, c.Raw)
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: signer, Leaf: cert}, nil
+}
+
+// GetClientCertificate returns a tls.Config.GetClientCertificate callback for the certificate of
+// slot, see TLSCertificate. The certificate is read from the card on the first request and kept.
+// When the server doesn't accept the certificate, no certificate is sent and the server decides
+// whether to continue.
+func (yk *YubiKey) GetClientCertificate(slot Slot, auth KeyAuth, intermediates ...*x509.Certificate) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	var (
+		mu   sync.Mutex
+		cert *tls.Certificate
+	)
+	return func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if cert == nil {
+			c, err := yk.TLSCertificate(slot, auth, intermediates...)
+			if err != nil {
+				return nil, err
+			}
+			cert = c
+		}
+		if cri.SupportsCertificate(cert) != nil {
+			return &tls.Certificate{}, nil
+		}
+		return cert, nil
+	}
+}
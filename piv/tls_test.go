@@ -0,0 +1,231 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/areese/piv-go/piv"
+	"github.com/areese/piv-go/piv/pivtest"
+)
+
+// testIssue returns a certificate for pub signed by ca, or self signed if ca is nil.
+func testIssue(t *testing.T, name string, pub crypto.PublicKey, ca *x509.Certificate, caKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  ca == nil,
+	}
+
+	if ca == nil {
+		ca = tmpl
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, pub, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestYubiKey_GetClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := testIssue(t, "ca", caKey.Public(), nil, caKey)
+	serverCert := testIssue(t, "server", caKey.Public(), ca, caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	tests := []struct {
+		name    string
+		alg     piv.Algorithm
+		version uint16
+	}{
+		{name: "ec256 tls 1.3", alg: piv.AlgorithmEC256, version: tls.VersionTLS13},
+		{name: "rsa2048 tls 1.3", alg: piv.AlgorithmRSA2048, version: tls.VersionTLS13},
+		{name: "rsa2048 tls 1.2", alg: piv.AlgorithmRSA2048, version: tls.VersionTLS12},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+
+			t.Cleanup(func() { yk.Close() })
+
+			pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotAuthentication, piv.Key{
+				Algorithm:   tc.alg,
+				PINPolicy:   piv.PINPolicyOnce,
+				TouchPolicy: piv.TouchPolicyNever,
+			})
+			if err != nil {
+				t.Fatalf("generate key: %v", err)
+			}
+
+			clientCert := testIssue(t, "client", pub, ca, caKey)
+			if err := yk.SetCertificate(piv.DefaultManagementKey, piv.SlotAuthentication, clientCert); err != nil {
+				t.Fatalf("set certificate: %v", err)
+			}
+
+			serverConn, clientConn := net.Pipe()
+			server := tls.Server(serverConn, &tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: caKey}},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    roots,
+				MinVersion:   tc.version,
+				MaxVersion:   tc.version,
+			})
+			client := tls.Client(clientConn, &tls.Config{
+				ServerName:           "server",
+				RootCAs:              roots,
+				GetClientCertificate: yk.GetClientCertificate(piv.SlotAuthentication, piv.KeyAuth{PIN: pivtest.DefaultPIN}),
+				MinVersion:           tc.version,
+				MaxVersion:           tc.version,
+			})
+
+			done := make(chan error)
+
+			go func() {
+				defer server.Close()
+				done <- server.Handshake()
+			}()
+
+			if err := client.Handshake(); err != nil {
+				t.Fatalf("client handshake: %v", err)
+			}
+
+			defer client.Close()
+
+			if err := <-done; err != nil {
+				t.Fatalf("server handshake: %v", err)
+			}
+
+			if peer := server.ConnectionState().PeerCertificates; len(peer) != 1 || !peer[0].Equal(clientCert) {
+				t.Errorf("server saw %d client certificates, expected the card certificate", len(peer))
+			}
+		})
+	}
+}
+
+func TestYubiKey_GetClientCertificateUnsupported(t *testing.T) {
+	t.Parallel()
+
+	yk, err := pivtest.NewClient(pivtest.NewCard(pivtest.Options{})).Open(pivtest.Reader(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { yk.Close() })
+
+	getClientCertificate := yk.GetClientCertificate(piv.SlotAuthentication, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+
+	// no certificate in the slot.
+	if _, err := getClientCertificate(&tls.CertificateRequestInfo{}); err == nil {
+		t.Error("got a certificate for an empty slot")
+	}
+
+	pub, err := yk.GenerateKey(piv.DefaultManagementKey, piv.SlotAuthentication, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyOnce,
+		TouchPolicy: piv.TouchPolicyNever,
+	})
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := testIssue(t, "ca", caKey.Public(), nil, caKey)
+	if err := yk.SetCertificate(piv.DefaultManagementKey, piv.SlotAuthentication, testIssue(t, "client", pub, ca, caKey)); err != nil {
+		t.Fatalf("set certificate: %v", err)
+	}
+
+	getClientCertificate = yk.GetClientCertificate(piv.SlotAuthentication, piv.KeyAuth{PIN: pivtest.DefaultPIN})
+
+	tests := []struct {
+		name     string
+		cri      *tls.CertificateRequestInfo
+		expected bool
+	}{
+		{
+			name:     "accepted",
+			cri:      &tls.CertificateRequestInfo{SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}, AcceptableCAs: [][]byte{ca.RawSubject}},
+			expected: true,
+		},
+		{
+			name: "other ca",
+			cri:  &tls.CertificateRequestInfo{SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}, AcceptableCAs: [][]byte{[]byte("other")}},
+		},
+		{
+			name: "rsa only",
+			cri:  &tls.CertificateRequestInfo{SignatureSchemes: []tls.SignatureScheme{tls.PSSWithSHA256}},
+		},
+	}
+
+	for _, tc := range tests {
+		cert, err := getClientCertificate(tc.cri)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if got := len(cert.Certificate) != 0; got != tc.expected {
+			t.Errorf("%s: sent a certificate %v, expected %v", tc.name, got, tc.expected)
+		}
+	}
+}
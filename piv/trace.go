@@ -0,0 +1,604 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrTraceMismatch is returned by a replayed card when a command differs from the recorded one.
+var ErrTraceMismatch = errors.New("command doesn't match the trace")
+
+// TraceEntry is one exchange of a trace, a command APDU and the card's response.
+// A trace is a file of entries as JSON lines, entries with an ATR record the answer to reset of a reader.
+//
+// Secrets are redacted when recording, the data of the command or the response is replaced with
+// zeros of the same length: PINs, PUKs, resetting codes, management and imported private keys,
+// the OpenPGP secure messaging and AES keys, the private DOs 3 and 4, decrypted data and ECDH
+// secrets. Responses to PIV RSA operations are redacted too, since signing and decrypting are
+// the same command. The OTP slot configurations and challenge responses, the OATH credential
+// secrets and password keys, and the YubiHSM Auth credentials, passwords and session keys are
+// redacted as well. What is redacted depends on the
+// selected applet, before an applet is selected everything that may be a secret in any of them is.
+type TraceEntry struct {
+	Reader string `json:"reader"`
+	ATR    string `json:"atr,omitempty"`
+	// Command is CLA INS P1 P2 followed by the command data, without Lc and Le, in hex.
+	Command string `json:"command,omitempty"`
+	// Response is the response data without SW1 SW2, in hex.
+	Response string `json:"response,omitempty"`
+	// Status is SW1 SW2, zero when the transport failed.
+	Status           uint16 `json:"status,omitempty"`
+	Error            string `json:"error,omitempty"`
+	CommandRedacted  bool   `json:"commandRedacted,omitempty"`
+	ResponseRedacted bool   `json:"responseRedacted,omitempty"`
+}
+
+// TraceRecorder is an SCConstructor that passes everything to another one and writes the exchanged APDUs to a trace:
+//
+//	rec := piv.NewTraceRecorder(&piv.PCSCConstructor{}, f)
+//	c := piv.Client{SCConstruct: rec}
+//	yk, err := c.OpenGPG(reader)
+type TraceRecorder struct {
+	construct SCConstructor
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewTraceRecorder returns a recorder that writes to w.
+func NewTraceRecorder(construct SCConstructor, w io.Writer) *TraceRecorder {
+	return &TraceRecorder{construct: construct, enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing the trace, the card commands aren't affected by it.
+func (r *TraceRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+func (r *TraceRecorder) write(e TraceEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+// nolint:ireturn
+func (r *TraceRecorder) NewSCContext() (SCContext, error) {
+	ctx, err := r.construct.NewSCContext()
+	if err != nil {
+		return nil, err
+	}
+
+	return &traceContext{SCContext: ctx, rec: r}, nil
+}
+
+type traceContext struct {
+	SCContext
+	rec *TraceRecorder
+}
+
+type traceHandle struct {
+	SCHandle
+	rec    *TraceRecorder
+	reader string
+}
+
+// traceTx records the commands sent with Transmit, TransmitBytes is passed through.
+type traceTx struct {
+	SCTx
+	rec    *TraceRecorder
+	reader string
+	// selected is the AID of the selected applet, nil when it isn't known.
+	selected []byte
+}
+
+var (
+	_ SCConstructor    = (*TraceRecorder)(nil)
+	_ optionsContext   = (*traceContext)(nil)
+	_ TransactionTx    = (*traceTx)(nil)
+	_ ExtendedLengthTx = (*traceTx)(nil)
+)
+
+// nolint:ireturn
+func (c *traceContext) Connect(reader string) (SCHandle, error) {
+	return c.connectWithOptions(reader, OpenOptions{})
+}
+
+// nolint:ireturn
+func (c *traceContext) connectWithOptions(reader string, opts OpenOptions) (SCHandle, error) {
+	h, err := connectWithOptions(c.SCContext, reader, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &traceHandle{SCHandle: h, rec: c.rec, reader: reader}, nil
+}
+
+func (c *traceContext) ATR(reader string) ([]byte, error) {
+	atr, err := c.SCContext.ATR(reader)
+	if err == nil {
+		c.rec.write(TraceEntry{Reader: reader, ATR: hex.EncodeToString(atr)})
+	}
+
+	return atr, err
+}
+
+// nolint:ireturn
+func (h *traceHandle) Begin() (SCTx, error) {
+	tx, err := h.SCHandle.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &traceTx{SCTx: tx, rec: h.rec, reader: h.reader}, nil
+}
+
+func (t *traceTx) Transmit(d apdu) ([]byte, error) {
+	resp, err := t.SCTx.Transmit(d)
+
+	t.rec.write(newTraceEntry(t.reader, t.selected, d, resp, err))
+	t.selected = selectedAID(t.selected, d, err)
+
+	return resp, err
+}
+
+// selectedAID returns the AID of the applet selected after d, selected is the one before it.
+func selectedAID(selected []byte, d apdu, err error) []byte {
+	if d.instruction != insSelectApplication || d.param1 != 0x04 {
+		return selected
+	}
+
+	// the card may have deselected the applet when SELECT failed.
+	if err != nil {
+		return nil
+	}
+
+	return append([]byte{}, d.data...)
+}
+
+// newTraceEntry returns the entry of d sent to reader with the applet selected, with the secrets redacted.
+func newTraceEntry(reader string, selected []byte, d apdu, resp []byte, err error) TraceEntry {
+	e := TraceEntry{Reader: reader, Status: 0x9000}
+
+	var ae *apduErr
+
+	switch {
+	case errors.As(err, &ae):
+		e.Status = ae.Status()
+	case err != nil:
+		e.Status = 0
+		e.Error = err.Error()
+	}
+
+	command := d.data
+	if e.CommandRedacted = redactTraceCommand(selected, d); e.CommandRedacted {
+		command = make([]byte, len(d.data))
+	}
+
+	if e.ResponseRedacted = redactTraceResponse(selected, d) && len(resp) > 0; e.ResponseRedacted {
+		e.Response = hex.EncodeToString(make([]byte, len(resp)))
+	} else {
+		e.Response = hex.EncodeToString(resp)
+	}
+
+	e.Command = hex.EncodeToString(append([]byte{d.class, d.instruction, d.param1, d.param2}, command...))
+
+	return e
+}
+
+func (t *traceTx) BeginTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	return tx.BeginTransaction()
+}
+
+func (t *traceTx) EndTransaction() error {
+	tx, err := transactionTx(t.SCTx)
+	if err != nil {
+		return err
+	}
+
+	return tx.EndTransaction()
+}
+
+func (t *traceTx) SetExtendedLength(enabled bool) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetExtendedLength(enabled)
+	}
+}
+
+func (t *traceTx) ExtendedLength() bool {
+	e, ok := t.SCTx.(ExtendedLengthTx)
+
+	return ok && e.ExtendedLength()
+}
+
+func (t *traceTx) SetMaxCommandLength(n int) {
+	if e, ok := t.SCTx.(ExtendedLengthTx); ok {
+		e.SetMaxCommandLength(n)
+	}
+}
+
+// traceRedaction is what is redacted in the commands to an applet.
+type traceRedaction struct {
+	aid []byte
+	// command and response report whether the command or response data has a secret.
+	command  func(d apdu) bool
+	response func(d apdu) bool
+}
+
+// nolint:gochecknoglobals
+var traceRedactions = []traceRedaction{
+	{aid: aidPIV[:], command: redactPIVCommand, response: redactPIVResponse},
+	{aid: aidOpenPGP[:], command: redactOpenPGPCommand, response: redactOpenPGPResponse},
+	{aid: aidYubiKey[:], command: redactOTPCommand, response: redactOTPResponse},
+	{aid: aidHSMAuth[:], command: redactHSMAuthCommand, response: redactHSMAuthResponse},
+	{aid: aidOATH[:], command: redactOATHCommand, response: func(apdu) bool { return false }},
+}
+
+// The OATH instructions with a secret, the applet is in the oath package.
+const (
+	insOATHPut     = 0x01
+	insOATHSetCode = 0x03
+)
+
+// redactTraceCommand reports whether the command data has a secret for the selected applet,
+// or for any applet when selected is nil.
+func redactTraceCommand(selected []byte, d apdu) bool {
+	for _, r := range traceRedactions {
+		if (selected == nil || bytes.HasPrefix(selected, r.aid)) && r.command(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactTraceResponse reports whether the response data may have a secret for the selected applet,
+// or for any applet when selected is nil.
+func redactTraceResponse(selected []byte, d apdu) bool {
+	for _, r := range traceRedactions {
+		if (selected == nil || bytes.HasPrefix(selected, r.aid)) && r.response(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactPIVCommand(d apdu) bool {
+	switch d.instruction {
+	case insVerify, insChangeReference, insResetRetry, insSetMGMKey, insImportKey:
+		return true
+	case insPutData:
+		// protected metadata has the management key.
+		return bytes.HasPrefix(d.data, []byte{0x5c, 0x03, 0x5f, 0xc1, 0x09})
+	default:
+		return false
+	}
+}
+
+func redactPIVResponse(d apdu) bool {
+	switch d.instruction {
+	case insAuthenticate:
+		if d.param1 == algRSA1024 || d.param1 == algRSA2048 {
+			return true
+		}
+
+		// 7C .. 85 is an ECDH point.
+		return len(d.data) > 4 && d.data[2] == 0x82 && d.data[4] == 0x85
+	case insGetData:
+		return bytes.Equal(d.data, []byte{0x5c, 0x03, 0x5f, 0xc1, 0x09})
+	default:
+		return false
+	}
+}
+
+// openPGPPrivateDO reports whether d reads or writes the private DO 3 or 4, which need a PIN to read.
+func openPGPPrivateDO(d apdu) bool {
+	tag := uint16(d.param1)<<8 | uint16(d.param2)
+
+	return tag == privateDOTag+2 || tag == privateDOTag+3
+}
+
+func redactOpenPGPCommand(d apdu) bool {
+	switch d.instruction {
+	case insVerify, insChangeReference, insResetRetry:
+		return true
+	case insPutDataDB:
+		// key import is 4D.
+		return bytes.HasPrefix(d.data, []byte{extendedHeaderListTag})
+	case insPutDataDA:
+		// D1 and D2 are the secure messaging keys, D3 the resetting code and D5 the AES key for PSO: DECIPHER.
+		switch tag := uint16(d.param1)<<8 | uint16(d.param2); tag {
+		case 0xd1, 0xd2, 0xd3, 0xd5:
+			return true
+		}
+
+		return openPGPPrivateDO(d)
+	default:
+		return false
+	}
+}
+
+func redactOpenPGPResponse(d apdu) bool {
+	switch d.instruction {
+	case insPerformSecurityOperation:
+		return d.param1 == securityOperationDecipherParam1 && d.param2 == securityOperationDecipherParam2
+	case insGetDataA:
+		return openPGPPrivateDO(d)
+	default:
+		return false
+	}
+}
+
+// redactOTPCommand redacts the slot configurations, they have the keys and access codes.
+// The challenges are redacted with them, every slot command is the same instruction.
+func redactOTPCommand(d apdu) bool {
+	return d.instruction == insOTPConfig && d.param1 != otpCmdDeviceSerial
+}
+
+// redactOTPResponse redacts the HMAC-SHA1 challenge responses, they are often used as keys.
+func redactOTPResponse(d apdu) bool {
+	return d.instruction == insOTPConfig && (d.param1 == otpCmdChalHMAC1 || d.param1 == otpCmdChalHMAC2)
+}
+
+// redactHSMAuthCommand redacts the credential keys and passwords and the management keys.
+func redactHSMAuthCommand(d apdu) bool {
+	switch d.instruction {
+	case insHSMAuthPut, insHSMAuthDelete, insHSMAuthCalculate, insHSMAuthGetChallenge, insHSMAuthPutManagementKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// redactHSMAuthResponse redacts the session keys.
+func redactHSMAuthResponse(d apdu) bool {
+	return d.instruction == insHSMAuthCalculate
+}
+
+// redactOATHCommand redacts the credential secrets and the key derived from the password.
+func redactOATHCommand(d apdu) bool {
+	return d.instruction == insOATHPut || d.instruction == insOATHSetCode
+}
+
+// TraceReplayer is an SCConstructor whose cards answer with a recorded trace, to reproduce
+// the behaviour of a card without having it. Commands must be sent in the recorded order,
+// the data of redacted commands and of management key authentication isn't compared.
+type TraceReplayer struct {
+	mu      sync.Mutex
+	entries []TraceEntry
+	next    int
+	atrs    map[string][]byte
+	readers []string
+}
+
+// NewTraceReplayer reads a trace written by a TraceRecorder.
+func NewTraceReplayer(r io.Reader) (*TraceReplayer, error) {
+	t := &TraceReplayer{atrs: map[string][]byte{}}
+
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var e TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+
+		if !seen[e.Reader] {
+			seen[e.Reader] = true
+			t.readers = append(t.readers, e.Reader)
+		}
+
+		if e.Command == "" {
+			atr, err := hex.DecodeString(e.ATR)
+			if err != nil {
+				return nil, fmt.Errorf("trace line %d: atr: %w", line, err)
+			}
+
+			t.atrs[e.Reader] = atr
+
+			continue
+		}
+
+		t.entries = append(t.entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading trace: %w", err)
+	}
+
+	sort.Strings(t.readers)
+
+	return t, nil
+}
+
+// Remaining returns the number of recorded commands that weren't replayed.
+func (t *TraceReplayer) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.entries) - t.next
+}
+
+// nolint:ireturn
+func (t *TraceReplayer) NewSCContext() (SCContext, error) {
+	return &replayContext{t: t}, nil
+}
+
+// replay returns the recorded response to d sent to reader.
+func (t *TraceReplayer) replay(reader string, d apdu) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	command := append([]byte{d.class, d.instruction, d.param1, d.param2}, d.data...)
+
+	if t.next >= len(t.entries) {
+		return nil, fmt.Errorf("%w: %x after the end of the trace", ErrTraceMismatch, command)
+	}
+
+	e := t.entries[t.next]
+
+	recorded, err := hex.DecodeString(e.Command)
+	if err != nil || len(recorded) < 4 {
+		return nil, fmt.Errorf("%w: invalid recorded command %q", ErrTraceMismatch, e.Command)
+	}
+
+	compareData := !e.CommandRedacted && !(d.instruction == insAuthenticate && d.param2 == keyCardManagement)
+
+	switch {
+	case e.Reader != reader:
+		return nil, fmt.Errorf("%w: %x sent to %q, recorded for %q", ErrTraceMismatch, command, reader, e.Reader)
+	case !bytes.Equal(recorded[:4], command[:4]), compareData && !bytes.Equal(recorded, command):
+		return nil, fmt.Errorf("%w: sent %x, recorded %s", ErrTraceMismatch, command, e.Command)
+	}
+
+	t.next++
+
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+
+	resp, err := hex.DecodeString(e.Response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid recorded response %q", ErrTraceMismatch, e.Response)
+	}
+
+	if e.Status != 0x9000 {
+		return nil, &apduErr{byte(e.Status >> 8), byte(e.Status)}
+	}
+
+	return resp, nil
+}
+
+type replayContext struct {
+	t *TraceReplayer
+}
+
+type replayHandle struct {
+	t      *TraceReplayer
+	reader string
+}
+
+// replayTx replays the commands sent with Transmit, raw TransmitBytes commands aren't recorded.
+type replayTx struct {
+	t      *TraceReplayer
+	reader string
+	debug  bool
+}
+
+var (
+	_ SCConstructor = (*TraceReplayer)(nil)
+	_ SCContext     = (*replayContext)(nil)
+	_ TransactionTx = (*replayTx)(nil)
+)
+
+func (c *replayContext) Close() error {
+	return nil
+}
+
+// nolint:ireturn
+func (c *replayContext) Connect(reader string) (SCHandle, error) {
+	for _, r := range c.t.readers {
+		if r == reader {
+			return &replayHandle{t: c.t, reader: reader}, nil
+		}
+	}
+
+	return nil, &scErr{rc: rcReaderUnavailable}
+}
+
+func (c *replayContext) ListReaders() ([]string, error) {
+	return append([]string{}, c.t.readers...), nil
+}
+
+func (c *replayContext) ATR(reader string) ([]byte, error) {
+	atr, ok := c.t.atrs[reader]
+	if !ok {
+		return nil, &scErr{rc: rcReaderUnavailable}
+	}
+
+	return atr, nil
+}
+
+// nolint:ireturn
+func (h *replayHandle) Begin() (SCTx, error) {
+	return &replayTx{t: h.t, reader: h.reader}, nil
+}
+
+func (h *replayHandle) Close() error {
+	return nil
+}
+
+func (t *replayTx) Close() error {
+	return nil
+}
+
+func (t *replayTx) Transmit(d apdu) ([]byte, error) {
+	return t.t.replay(t.reader, d)
+}
+
+func (t *replayTx) TransmitBytes(req []byte) (bool, []byte, error) {
+	return false, nil, fmt.Errorf("%w: raw apdu %x", ErrTraceMismatch, req)
+}
+
+// BeginTransaction does nothing, a trace doesn't record transactions.
+func (t *replayTx) BeginTransaction() error {
+	return nil
+}
+
+// EndTransaction does nothing, a trace doesn't record transactions.
+func (t *replayTx) EndTransaction() error {
+	return nil
+}
+
+func (t *replayTx) EnableDebug() {
+	t.debug = true
+}
+
+func (t *replayTx) DisableDebug() {
+	t.debug = false
+}
+
+func (t *replayTx) IsDebugEnabled() bool {
+	return t.debug
+}
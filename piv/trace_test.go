@@ -0,0 +1,259 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// traceTestCard answers SELECT, GET VERSION and VERIFY with the PIN 123456.
+type traceTestCard struct{}
+
+func (traceTestCard) Transmit(command []byte) ([]byte, error) {
+	switch command[1] {
+	case insSelectApplication:
+		return []byte{0x90, 0x00}, nil
+	case insGetVersion:
+		return []byte{0x05, 0x04, 0x03, 0x90, 0x00}, nil
+	case insVerify:
+		if len(command) > 5 && bytes.HasPrefix(command[5:], []byte("123456")) {
+			return []byte{0x90, 0x00}, nil
+		}
+
+		return []byte{0x63, 0xc2}, nil
+	default:
+		return []byte{0x6d, 0x00}, nil
+	}
+}
+
+func TestTraceRecordAndReplay(t *testing.T) {
+	t.Parallel()
+
+	const reader = "Yubico YubiKey OTP+FIDO+CCID 00"
+
+	var trace bytes.Buffer
+
+	rec := NewTraceRecorder(NewSoftwareCardConstructor(map[string]SoftwareCard{reader: traceTestCard{}}), &trace)
+
+	yk, err := Client{SCConstruct: rec}.Open(reader)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if err := yk.VerifyPIN("123456"); err != nil {
+		t.Fatalf("verify pin: %v", err)
+	}
+
+	var authErr AuthErr
+	if err := yk.VerifyPIN("654321"); !errors.As(err, &authErr) || authErr.Retries != 2 {
+		t.Fatalf("verify wrong pin = %v, want 2 retries", err)
+	}
+
+	yk.Close()
+
+	if err := rec.Err(); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+
+	if strings.Contains(trace.String(), "313233343536") || strings.Contains(trace.String(), "363534333231") {
+		t.Errorf("trace has the pin:\n%s", trace.String())
+	}
+
+	replayer, err := NewTraceReplayer(&trace)
+	if err != nil {
+		t.Fatalf("reading trace: %v", err)
+	}
+
+	yk, err = Client{SCConstruct: replayer}.Open(reader)
+	if err != nil {
+		t.Fatalf("open replayed card: %v", err)
+	}
+	defer yk.Close()
+
+	if v := yk.Version(); v != (Version{Major: 5, Minor: 4, Patch: 3}) {
+		t.Errorf("replayed version = %v", v)
+	}
+
+	// the PIN was redacted, any PIN replays.
+	if err := yk.VerifyPIN("000000"); err != nil {
+		t.Errorf("replayed verify pin: %v", err)
+	}
+
+	if err := yk.VerifyPIN("000000"); !errors.As(err, &authErr) || authErr.Retries != 2 {
+		t.Errorf("replayed verify wrong pin = %v, want 2 retries", err)
+	}
+
+	if n := replayer.Remaining(); n != 0 {
+		t.Errorf("remaining = %d, want 0", n)
+	}
+
+	if _, err := yk.Serial(); !errors.Is(err, ErrTraceMismatch) {
+		t.Errorf("command after the trace = %v, want ErrTraceMismatch", err)
+	}
+}
+
+func TestTraceReplayMismatch(t *testing.T) {
+	t.Parallel()
+
+	trace := `{"reader":"r","command":"00a40400a000000308","status":36864}
+{"reader":"r","command":"00fd0000","response":"050403","status":36864}
+`
+
+	replayer, err := NewTraceReplayer(strings.NewReader(trace))
+	if err != nil {
+		t.Fatalf("reading trace: %v", err)
+	}
+
+	if _, err := (Client{SCConstruct: replayer}).Open("other"); err == nil {
+		t.Errorf("open of a reader not in the trace succeeded")
+	}
+
+	ctx, _ := replayer.NewSCContext()
+
+	h, err := ctx.Connect("r")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	tx, _ := h.Begin()
+
+	if _, err := tx.Transmit(apdu{instruction: insSelectApplication, param1: 0x04, data: aidOpenPGP[:]}); !errors.Is(err, ErrTraceMismatch) {
+		t.Errorf("select of another applet = %v, want ErrTraceMismatch", err)
+	}
+}
+
+func TestRedactTraceCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		selected []byte
+		cmd      apdu
+		want     bool
+	}{
+		{name: "verify", cmd: apdu{instruction: insVerify, param2: 0x80, data: []byte("123456")}, want: true},
+		{name: "import", cmd: apdu{instruction: insImportKey}, want: true},
+		{name: "openpgp import", cmd: apdu{instruction: insPutDataDB, param1: 0x3f, param2: 0xff, data: []byte{0x4d, 0x00}}, want: true},
+		{name: "certificate", cmd: apdu{instruction: insPutData, param1: 0x3f, param2: 0xff, data: []byte{0x5c, 0x03, 0x5f, 0xc1, 0x05}}},
+		{name: "resetting code", cmd: apdu{instruction: insPutDataDA, param2: 0xd3}, want: true},
+		{name: "sm key", selected: aidOpenPGP[:], cmd: apdu{instruction: insPutDataDA, param2: 0xd1}, want: true},
+		{name: "aes key", selected: aidOpenPGP[:], cmd: apdu{instruction: insPutDataDA, param2: 0xd5}, want: true},
+		{name: "private do 3", selected: aidOpenPGP[:], cmd: apdu{instruction: insPutDataDA, param1: 0x01, param2: 0x03}, want: true},
+		{name: "private do 1", selected: aidOpenPGP[:], cmd: apdu{instruction: insPutDataDA, param1: 0x01, param2: 0x01}},
+		{name: "name", cmd: apdu{instruction: insPutDataDA, param2: 0x5b}},
+		{name: "otp config", selected: aidYubiKey[:], cmd: apdu{instruction: insOTPConfig, param1: otpCmdConfig2, data: []byte{0x01}}, want: true},
+		{name: "otp serial", selected: aidYubiKey[:], cmd: apdu{instruction: insOTPConfig, param1: otpCmdDeviceSerial}},
+		{name: "hsmauth put", selected: aidHSMAuth[:], cmd: apdu{instruction: insHSMAuthPut, data: []byte{0x01}}, want: true},
+		{name: "hsmauth list", selected: aidHSMAuth[:], cmd: apdu{instruction: insHSMAuthList}},
+		{name: "unselected hsmauth put", cmd: apdu{instruction: insHSMAuthPut, data: []byte{0x01}}, want: true},
+		{name: "oath put", selected: aidOATH[:], cmd: apdu{instruction: insOATHPut, data: []byte{0x71, 0x01, 'a'}}, want: true},
+		{name: "oath set code", selected: aidOATH[:], cmd: apdu{instruction: insOATHSetCode, data: []byte{0x73, 0x00}}, want: true},
+		{name: "oath list", selected: aidOATH[:], cmd: apdu{instruction: 0xa1}},
+		// 01 is only a secret in the OTP, OATH and YubiHSM Auth applets.
+		{name: "piv 01", selected: aidPIV[:], cmd: apdu{instruction: 0x01, data: []byte{0x01}}},
+		{name: "piv verify", selected: aidPIV[:], cmd: apdu{instruction: insVerify, param2: 0x80}, want: true},
+		{name: "openpgp piv metadata", selected: aidOpenPGP[:], cmd: apdu{instruction: insPutDataDB, data: []byte{0x5c, 0x03, 0x5f, 0xc1, 0x09}}},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := redactTraceCommand(tc.selected, tc.cmd); got != tc.want {
+				t.Errorf("redactTraceCommand() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedactTraceResponse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		selected []byte
+		cmd      apdu
+		want     bool
+	}{
+		{name: "decipher", selected: aidOpenPGP[:], cmd: apdu{instruction: insPerformSecurityOperation, param1: 0x80, param2: 0x86}, want: true},
+		{name: "private do 4", selected: aidOpenPGP[:], cmd: apdu{instruction: insGetDataA, param1: 0x01, param2: 0x04}, want: true},
+		{name: "private do 2", selected: aidOpenPGP[:], cmd: apdu{instruction: insGetDataA, param1: 0x01, param2: 0x02}},
+		{name: "hsmauth calculate", selected: aidHSMAuth[:], cmd: apdu{instruction: insHSMAuthCalculate}, want: true},
+		{name: "hsmauth challenge", selected: aidHSMAuth[:], cmd: apdu{instruction: insHSMAuthGetChallenge}},
+		{name: "otp challenge", selected: aidYubiKey[:], cmd: apdu{instruction: insOTPConfig, param1: otpCmdChalHMAC1}, want: true},
+		{name: "otp serial", selected: aidYubiKey[:], cmd: apdu{instruction: insOTPConfig, param1: otpCmdDeviceSerial}},
+		{name: "unselected calculate", cmd: apdu{instruction: insHSMAuthCalculate}, want: true},
+		{name: "piv 03", selected: aidPIV[:], cmd: apdu{instruction: 0x03}},
+	}
+
+	for _, tc := range tests {
+		tc := tc // avoid aliasing due to test.parallel.
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := redactTraceResponse(tc.selected, tc.cmd); got != tc.want {
+				t.Errorf("redactTraceResponse() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTraceTxSelected(t *testing.T) {
+	t.Parallel()
+
+	var trace bytes.Buffer
+
+	tx := &traceTx{
+		SCTx: &TestSCTx{
+			APDUList: []apdu{
+				{instruction: insSelectApplication, param1: 0x04, data: aidHSMAuth[:]},
+				{instruction: insHSMAuthCalculate, data: []byte{0x01}},
+				{instruction: insSelectApplication, param1: 0x04, data: aidPIV[:]},
+				{instruction: insHSMAuthCalculate, data: []byte{0x01}},
+			},
+			ResponseList: [][]byte{nil, {0x02}, nil, {0x03}},
+		},
+		rec: NewTraceRecorder(nil, &trace),
+	}
+
+	for _, d := range tx.SCTx.(*TestSCTx).APDUList {
+		if _, err := tx.Transmit(d); err != nil {
+			t.Fatalf("transmit: %v", err)
+		}
+	}
+
+	want := []string{
+		`"command":"00a40400a000000527210701"`,
+		`"command":"0003000000","response":"00","status":36864,"commandRedacted":true,"responseRedacted":true`,
+		`"command":"00a40400a000000308"`,
+		`"command":"0003000001","response":"03","status":36864}`,
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d entries, want %d:\n%s", len(lines), len(want), trace.String())
+	}
+
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Errorf("entry %d = %s, want %s", i, lines[i], w)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWatchNotSupported is returned by Watch when the smart card context can't wait for status changes.
+var ErrWatchNotSupported = errors.New("smart card context can't watch for cards")
+
+// Reader states of SCardGetStatusChange, they are the same for pcsc-lite, macOS and Windows.
+// https://pcsclite.apdu.fr/api/group__API.html#ga33247d5d1257d59e55647c3bb717db24
+const (
+	readerStateUnaware = 0x0000
+	readerStateChanged = 0x0002
+	readerStatePresent = 0x0020
+
+	// rcTimeout is SCARD_E_TIMEOUT, returned when nothing changed before the timeout.
+	rcTimeout int64 = 0x8010000A
+)
+
+// watchInterval bounds how long a reader that was plugged in goes unnoticed, a YubiKey is its own
+// reader so inserting it adds a reader rather than changing the state of one.
+const watchInterval = 500 * time.Millisecond
+
+// readerState is a reader for SCardGetStatusChange. current is the state the caller knows about,
+// event and atr are set to the state of the reader.
+type readerState struct {
+	reader  string
+	current uint32
+	event   uint32
+	atr     []byte
+}
+
+// statusChangeContext is implemented by contexts that can wait for cards to be inserted or removed.
+type statusChangeContext interface {
+	getStatusChange(states []readerState, timeout time.Duration) error
+}
+
+// CardEventType is what happened to a card.
+type CardEventType int
+
+const (
+	CardInserted CardEventType = iota + 1
+	CardRemoved
+)
+
+func (t CardEventType) String() string {
+	switch t {
+	case CardInserted:
+		return "inserted"
+	case CardRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("CardEventType(%d)", int(t))
+	}
+}
+
+// CardEvent is a card inserted into or removed from a reader.
+// Info.Identity tells YubiKeys apart from other cards, it's only known for inserted cards.
+type CardEvent struct {
+	Type CardEventType
+	Info CardInfo
+	// Err is set on the last event when watching failed, the channel is closed after it.
+	Err error
+}
+
+// Watch returns a channel of card insertions and removals, see Client.Watch.
+func Watch(ctx context.Context) (<-chan CardEvent, error) {
+	c := Client{
+		client:      &client{},
+		SCConstruct: &PCSCConstructor{},
+	}
+
+	return c.Watch(ctx)
+}
+
+// Watch returns a channel of card insertions and removals using SCardGetStatusChange, so hot-plug
+// doesn't need polling Cards. Cards that are present when Watch is called are reported as inserted first.
+// The channel is closed when ctx is done or watching fails.
+func (c *Client) Watch(ctx context.Context) (<-chan CardEvent, error) {
+	scCtx, err := c.SCConstruct.NewSCContext()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to smart card daemon: %w", err)
+	}
+
+	waiter, ok := scCtx.(statusChangeContext)
+	if !ok {
+		scCtx.Close()
+
+		return nil, ErrWatchNotSupported
+	}
+
+	events := make(chan CardEvent)
+
+	go func() {
+		defer close(events)
+		defer scCtx.Close()
+
+		w := cardWatcher{ctx: scCtx, waiter: waiter, states: map[string]uint32{}, present: map[string]bool{}}
+
+		for ctx.Err() == nil {
+			changes, err := w.poll()
+			if err != nil {
+				changes = append(changes, CardEvent{Err: err})
+			}
+
+			for _, e := range changes {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// cardWatcher remembers the state of every reader between calls to SCardGetStatusChange.
+type cardWatcher struct {
+	ctx    SCContext
+	waiter statusChangeContext
+	// states are the last reader states, without readerStateChanged.
+	states  map[string]uint32
+	present map[string]bool
+}
+
+// poll waits up to watchInterval for a change and returns the cards inserted and removed since the last poll.
+func (w *cardWatcher) poll() ([]CardEvent, error) {
+	readers, err := w.ctx.ListReaders()
+	if err != nil {
+		return nil, fmt.Errorf("listing readers: %w", err)
+	}
+
+	if len(readers) == 0 {
+		// there is nothing to wait on until a reader is plugged in.
+		time.Sleep(watchInterval)
+	}
+
+	states := make([]readerState, 0, len(readers))
+	for _, reader := range readers {
+		current, ok := w.states[reader]
+		if !ok {
+			current = readerStateUnaware
+		}
+
+		states = append(states, readerState{reader: reader, current: current})
+	}
+
+	err = w.waiter.getStatusChange(states, watchInterval)
+
+	var sc *scErr
+	if errors.As(err, &sc) && sc.rc == rcTimeout {
+		states, err = nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("waiting for status change: %w", err)
+	}
+
+	var events []CardEvent
+
+	listed := make(map[string]bool, len(readers))
+	for _, reader := range readers {
+		listed[reader] = true
+	}
+
+	for reader := range w.present {
+		if !listed[reader] {
+			events = append(events, CardEvent{Type: CardRemoved, Info: CardInfo{Reader: reader}})
+			delete(w.present, reader)
+			delete(w.states, reader)
+		}
+	}
+
+	for _, s := range states {
+		w.states[s.reader] = s.event &^ readerStateChanged
+		present := s.event&readerStatePresent != 0
+
+		switch {
+		case present && !w.present[s.reader]:
+			info := CardInfo{Reader: s.reader}
+			if atr, err := ParseATR(s.atr); err == nil {
+				info.ATR = atr
+				info.Identity = atr.Identify()
+			}
+
+			events = append(events, CardEvent{Type: CardInserted, Info: info})
+			w.present[s.reader] = true
+		case !present && w.present[s.reader]:
+			events = append(events, CardEvent{Type: CardRemoved, Info: CardInfo{Reader: s.reader}})
+			delete(w.present, s.reader)
+		}
+	}
+
+	return events, nil
+}
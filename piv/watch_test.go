@@ -0,0 +1,214 @@
+//  Copyright © 2024 Apple Inc. All rights reserved.
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// watchStep is what the fake context reports for one poll.
+type watchStep struct {
+	readers []string
+	// events are the reader states, nil means the wait timed out.
+	events map[string]uint32
+	atrs   map[string][]byte
+	err    error
+}
+
+// watchTestContext is a context whose readers and their states change with every getStatusChange.
+type watchTestContext struct {
+	TestSCContext
+	steps []watchStep
+	step  int
+	// currents are the current states passed to getStatusChange.
+	currents []map[string]uint32
+}
+
+func (w *watchTestContext) ListReaders() ([]string, error) {
+	if w.step >= len(w.steps) {
+		return nil, errors.New("no more steps")
+	}
+
+	return w.steps[w.step].readers, nil
+}
+
+func (w *watchTestContext) getStatusChange(states []readerState, _ time.Duration) error {
+	s := w.steps[w.step]
+	w.step++
+
+	currents := map[string]uint32{}
+	for _, state := range states {
+		currents[state.reader] = state.current
+	}
+
+	w.currents = append(w.currents, currents)
+
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.events == nil {
+		return &scErr{rc: rcTimeout}
+	}
+
+	for i := range states {
+		states[i].event = s.events[states[i].reader]
+		states[i].atr = s.atrs[states[i].reader]
+	}
+
+	return nil
+}
+
+type watchTestConstructor struct {
+	ctx *watchTestContext
+}
+
+// nolint:ireturn
+func (w *watchTestConstructor) NewSCContext() (SCContext, error) {
+	return w.ctx, nil
+}
+
+func TestCardWatcher_Poll(t *testing.T) {
+	t.Parallel()
+
+	const (
+		yk  = "Yubico YubiKey OTP+FIDO+CCID 00 00"
+		nfc = "ACS ACR1252 Dual Reader PICC"
+	)
+
+	ykATR := mustHex(t, "3B FD 13 00 00 81 31 FE 15 80 73 C0 21 C0 57 59 75 62 69 4B 65 79 40")
+	present := uint32(readerStateChanged | readerStatePresent)
+	empty := uint32(readerStateChanged | 0x0010)
+
+	steps := []struct {
+		watchStep
+		expected []CardEvent
+		// current is the state of the reader passed to getStatusChange.
+		current map[string]uint32
+	}{
+		{
+			watchStep: watchStep{readers: []string{yk}, events: map[string]uint32{yk: present}, atrs: map[string][]byte{yk: ykATR}},
+			expected:  []CardEvent{{Type: CardInserted, Info: CardInfo{Reader: yk}}},
+			current:   map[string]uint32{yk: readerStateUnaware},
+		},
+		{
+			watchStep: watchStep{readers: []string{yk}},
+			current:   map[string]uint32{yk: readerStatePresent},
+		},
+		{
+			watchStep: watchStep{readers: []string{nfc}, events: map[string]uint32{nfc: empty}},
+			expected:  []CardEvent{{Type: CardRemoved, Info: CardInfo{Reader: yk}}},
+			current:   map[string]uint32{nfc: readerStateUnaware},
+		},
+		{
+			watchStep: watchStep{readers: []string{nfc}, events: map[string]uint32{nfc: present}, atrs: map[string][]byte{nfc: {0x3b}}},
+			expected:  []CardEvent{{Type: CardInserted, Info: CardInfo{Reader: nfc}}},
+			current:   map[string]uint32{nfc: 0x0010},
+		},
+		{
+			watchStep: watchStep{readers: []string{nfc}, events: map[string]uint32{nfc: empty}},
+			expected:  []CardEvent{{Type: CardRemoved, Info: CardInfo{Reader: nfc}}},
+			current:   map[string]uint32{nfc: readerStatePresent},
+		},
+	}
+
+	ctx := &watchTestContext{}
+	for _, s := range steps {
+		ctx.steps = append(ctx.steps, s.watchStep)
+	}
+
+	w := cardWatcher{ctx: ctx, waiter: ctx, states: map[string]uint32{}, present: map[string]bool{}}
+
+	for i, s := range steps {
+		events, err := w.poll()
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+
+		if len(events) != len(s.expected) {
+			t.Fatalf("step %d: got %d events expected %d", i, len(events), len(s.expected))
+		}
+
+		for j, e := range events {
+			if e.Type != s.expected[j].Type || e.Info.Reader != s.expected[j].Info.Reader {
+				t.Errorf("step %d: got %s %s expected %s %s", i, e.Type, e.Info.Reader, s.expected[j].Type, s.expected[j].Info.Reader)
+			}
+		}
+
+		for reader, current := range s.current {
+			if got := ctx.currents[i][reader]; got != current {
+				t.Errorf("step %d: got current state 0x%x of %s expected 0x%x", i, got, reader, current)
+			}
+		}
+	}
+
+	// nothing is left of the removed readers and cards.
+	if w.states[yk] != 0 || len(w.present) != 0 {
+		t.Errorf("got states %v present %v after every card was removed", w.states, w.present)
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	t.Parallel()
+
+	const yk = "Yubico YubiKey OTP+FIDO+CCID 00 00"
+
+	errWait := &scErr{rc: 0x8010001D}
+	wctx := &watchTestContext{steps: []watchStep{
+		{
+			readers: []string{yk},
+			events:  map[string]uint32{yk: readerStatePresent},
+			atrs:    map[string][]byte{yk: mustHex(t, "3B FD 13 00 00 81 31 FE 15 80 73 C0 21 C0 57 59 75 62 69 4B 65 79 40")},
+		},
+		{readers: []string{yk}, err: errWait},
+	}}
+	c := &Client{client: &client{}, SCConstruct: &watchTestConstructor{ctx: wctx}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inserted := <-events
+	if inserted.Type != CardInserted || inserted.Info.Identity.Family != CardFamilyYubiKey {
+		t.Errorf("got %s of %s expected a YubiKey inserted", inserted.Type, inserted.Info.Identity.Family)
+	}
+
+	failed := <-events
+	if !errors.Is(failed.Err, errWait) {
+		t.Errorf("got %v expected %v", failed.Err, errWait)
+	}
+
+	if _, ok := <-events; ok {
+		t.Error("channel wasn't closed after the error")
+	}
+}
+
+func TestClient_WatchNotSupported(t *testing.T) {
+	t.Parallel()
+
+	c := CreateTestClient(t, nil, nil, &TestSCHandle{})
+
+	if _, err := c.Watch(context.Background()); !errors.Is(err, ErrWatchNotSupported) {
+		t.Errorf("got %v expected %v", err, ErrWatchNotSupported)
+	}
+}